@@ -0,0 +1,296 @@
+package main
+
+import (
+	"ad_service/pkg/client"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// exportPageSize is the page size export walks the listing with
+const exportPageSize = 100
+
+// newFlagSet creates the flag set of a command with the global flags registered
+func newFlagSet(name, synopsis string, g *globals) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: adctl %s\n\nFlags:\n", synopsis)
+		fs.PrintDefaults()
+	}
+	g.register(fs)
+	return fs
+}
+
+// parseFlags parses args into fs; a bad flag is a usage error, already reported with the
+// flags of the command
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return usageError{fmt.Sprintf("%s: %v", fs.Name(), err)}
+	}
+	return err
+}
+
+// parseWithID parses "<id> [flags]"; the ID may also follow the flags
+func parseWithID(fs *flag.FlagSet, args []string) (int64, error) {
+	var rawID string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		rawID, args = args[0], args[1:]
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return 0, err
+	}
+	if rawID == "" && fs.NArg() > 0 {
+		rawID = fs.Arg(0)
+	}
+	if rawID == "" {
+		return 0, usagef("%s: missing ad ID", fs.Name())
+	}
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, usagef("%s: invalid ad ID %q, must be a positive integer", fs.Name(), rawID)
+	}
+	return id, nil
+}
+
+// listFlags are the filter and sort flags of list and export
+type listFlags struct {
+	opts                      client.ListOptions
+	lat, lng, radiusKm        float64
+	hasLat, hasLng, hasRadius bool
+}
+
+func (l *listFlags) register(fs *flag.FlagSet, paged bool) {
+	if paged {
+		fs.IntVar(&l.opts.Page, "page", 0, "page number, 1 by default")
+		fs.IntVar(&l.opts.Limit, "limit", 0, "ads per page, 10 by default")
+	}
+	fs.StringVar(&l.opts.SortBy, "sort-by", "", "id, title, price, created_at, renewed_at, is_active or distance")
+	fs.StringVar(&l.opts.Order, "order", "", "asc or desc")
+	fs.StringVar(&l.opts.Owner, "owner", "", `"me" lists the caller's own ads`)
+	fs.StringVar(&l.opts.Status, "status", "", "pending, approved or rejected; needs --owner me or the admin role")
+	fs.StringVar(&l.opts.State, "state", "", "live or archived; needs --owner me or the admin role")
+	fs.IntVar(&l.opts.CampaignID, "campaign", 0, "only ads of this campaign")
+	fs.StringVar(&l.opts.Currency, "currency", "", "also show prices converted to this currency")
+	fs.Func("lat", "latitude of a radius search", floatFlag(&l.lat, &l.hasLat))
+	fs.Func("lng", "longitude of a radius search", floatFlag(&l.lng, &l.hasLng))
+	fs.Func("radius-km", "radius of a radius search", floatFlag(&l.radiusKm, &l.hasRadius))
+}
+
+// options returns the list options once the flags are parsed
+func (l *listFlags) options() (client.ListOptions, error) {
+	if l.hasLat || l.hasLng || l.hasRadius {
+		if !l.hasLat || !l.hasLng || !l.hasRadius {
+			return l.opts, usagef("--lat, --lng and --radius-km must be given together")
+		}
+		l.opts.Near = &client.Near{Latitude: l.lat, Longitude: l.lng, RadiusKm: l.radiusKm}
+	}
+	return l.opts, nil
+}
+
+func floatFlag(value *float64, set *bool) func(string) error {
+	return func(raw string) error {
+		parsed, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("not a number")
+		}
+		*value, *set = parsed, true
+		return nil
+	}
+}
+
+func runGet(args []string, ctx context.Context) error {
+	var g globals
+	fs := newFlagSet("get", "get <id> [flags]", &g)
+	id, err := parseWithID(fs, args)
+	if err != nil {
+		return err
+	}
+	c, err := g.client()
+	if err != nil {
+		return err
+	}
+	ad, err := c.GetAd(id, ctx)
+	if err != nil {
+		return err
+	}
+	return printAds(os.Stdout, g.output, []client.Ad{*ad}, true)
+}
+
+func runList(args []string, ctx context.Context) error {
+	var g globals
+	var l listFlags
+	fs := newFlagSet("list", "list [flags]", &g)
+	l.register(fs, true)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	opts, err := l.options()
+	if err != nil {
+		return err
+	}
+	c, err := g.client()
+	if err != nil {
+		return err
+	}
+	ads, err := c.ListAds(opts, ctx)
+	if err != nil {
+		return err
+	}
+	return printAds(os.Stdout, g.output, ads, false)
+}
+
+func runCreate(args []string, ctx context.Context) error {
+	var g globals
+	fs := newFlagSet("create", "create -f ad.json [flags]", &g)
+	file := fs.String("f", "", `JSON file with the ad, "-" for stdin`)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	req, err := readAdRequest(*file)
+	if err != nil {
+		return err
+	}
+	c, err := g.client()
+	if err != nil {
+		return err
+	}
+	ad, err := c.CreateAd(req, ctx)
+	if err != nil {
+		return err
+	}
+	return printAds(os.Stdout, g.output, []client.Ad{*ad}, true)
+}
+
+func runUpdate(args []string, ctx context.Context) error {
+	var g globals
+	fs := newFlagSet("update", "update <id> -f ad.json [flags]", &g)
+	file := fs.String("f", "", `JSON file with the ad, "-" for stdin`)
+	id, err := parseWithID(fs, args)
+	if err != nil {
+		return err
+	}
+	req, err := readAdRequest(*file)
+	if err != nil {
+		return err
+	}
+	c, err := g.client()
+	if err != nil {
+		return err
+	}
+	if err := c.UpdateAd(id, req, ctx); err != nil {
+		return err
+	}
+	// PUT doesn't return the ad, so show what was stored
+	ad, err := c.GetAd(id, ctx)
+	if err != nil {
+		return err
+	}
+	return printAds(os.Stdout, g.output, []client.Ad{*ad}, true)
+}
+
+func runDelete(args []string, ctx context.Context) error {
+	var g globals
+	fs := newFlagSet("delete", "delete <id> [flags]", &g)
+	id, err := parseWithID(fs, args)
+	if err != nil {
+		return err
+	}
+	c, err := g.client()
+	if err != nil {
+		return err
+	}
+	if err := c.DeleteAd(id, ctx); err != nil {
+		return err
+	}
+	if g.output == "json" {
+		return json.NewEncoder(os.Stdout).Encode(map[string]any{"id": id, "deleted": true})
+	}
+	fmt.Printf("Deleted ad %d\n", id)
+	return nil
+}
+
+func runExport(args []string, ctx context.Context) error {
+	var g globals
+	var l listFlags
+	fs := newFlagSet("export", "export --format csv [flags]", &g)
+	l.register(fs, false)
+	format := fs.String("format", "csv", "export format, csv")
+	out := fs.String("out", "-", `file to write, "-" for stdout`)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *format != "csv" {
+		return usagef("export: unsupported --format %q, must be csv", *format)
+	}
+	opts, err := l.options()
+	if err != nil {
+		return err
+	}
+	c, err := g.client()
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("could not create %s: %v", *out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	// Walk the pages until a short one; ads created meanwhile may shift an ad onto the next page
+	// and export it twice, which the ID column makes easy to spot
+	csvOut := newCSVWriter(w)
+	opts.Limit = exportPageSize
+	total := 0
+	for opts.Page = 1; ; opts.Page++ {
+		ads, err := c.ListAds(opts, ctx)
+		if err != nil {
+			return err
+		}
+		if err := csvOut.write(ads); err != nil {
+			return fmt.Errorf("could not write CSV: %v", err)
+		}
+		total += len(ads)
+		if len(ads) < exportPageSize {
+			break
+		}
+	}
+	if *out != "-" {
+		fmt.Fprintf(os.Stderr, "Exported %d ads to %s\n", total, *out)
+	}
+	return nil
+}
+
+// readAdRequest decodes the ad in path, rejecting unknown fields so typos don't go unnoticed
+func readAdRequest(path string) (client.AdRequest, error) {
+	var req client.AdRequest
+	if path == "" {
+		return req, usagef("missing -f with the ad JSON")
+	}
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return req, fmt.Errorf("could not open %s: %v", path, err)
+		}
+		defer f.Close()
+		r = f
+	}
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return req, fmt.Errorf("could not decode %s: %v", path, err)
+	}
+	return req, nil
+}
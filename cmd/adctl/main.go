@@ -0,0 +1,177 @@
+// Command adctl manages ads from the command line through the Go client in pkg/client.
+package main
+
+import (
+	"ad_service/pkg/client"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// Exit codes; API errors map to the class of the status code
+const (
+	exitOK         = 0
+	exitFailure    = 1 // transport errors and anything unexpected
+	exitUsage      = 2
+	exitNotFound   = 3
+	exitValidation = 4
+	exitConflict   = 5
+	exitDenied     = 6 // 401 and 403
+	exitServer     = 7 // 5xx
+)
+
+const usage = `Usage: adctl <command> [arguments] [flags]
+
+Commands:
+  get <id>                 show an ad
+  list                     list a page of ads
+  create -f ad.json        create an ad from a JSON file ("-" reads stdin)
+  update <id> -f ad.json   replace an ad
+  delete <id>              delete an ad
+  export --format csv      write every ad matching the list filters
+
+The API base URL and key come from --url and --api-key, or $ADCTL_URL and $ADCTL_API_KEY.
+Run "adctl <command> -h" for the flags of a command.
+`
+
+// command runs one subcommand with its arguments after the name
+type command func(args []string, ctx context.Context) error
+
+var commands = map[string]command{
+	"get":    runGet,
+	"list":   runList,
+	"create": runCreate,
+	"update": runUpdate,
+	"delete": runDelete,
+	"export": runExport,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run executes the command line and returns the exit code
+func run(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		fmt.Fprint(os.Stderr, usage)
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "adctl: unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err := cmd(args[1:], ctx)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		printError(err)
+	}
+	return exitCode(err)
+}
+
+// usageError is a bad command line, reported with exitUsage
+type usageError struct{ msg string }
+
+func (e usageError) Error() string { return e.msg }
+
+func usagef(format string, args ...any) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
+
+// exitCode maps an error to the exit code of the process
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var usageErr usageError
+	if errors.As(err, &usageErr) {
+		return exitUsage
+	}
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) {
+		return exitFailure
+	}
+	switch {
+	case apiErr.StatusCode == http.StatusNotFound:
+		return exitNotFound
+	case apiErr.StatusCode == http.StatusBadRequest || apiErr.StatusCode == http.StatusUnprocessableEntity:
+		return exitValidation
+	case apiErr.StatusCode == http.StatusConflict:
+		return exitConflict
+	case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden:
+		return exitDenied
+	case apiErr.StatusCode >= 500:
+		return exitServer
+	}
+	return exitFailure
+}
+
+// printError writes err to stderr; API errors show the status, the error code, the failed
+// fields and the trace ID
+func printError(err error) {
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) {
+		fmt.Fprintf(os.Stderr, "adctl: %v\n", err)
+		return
+	}
+	code := apiErr.Code
+	if code == "" {
+		code = http.StatusText(apiErr.StatusCode)
+	}
+	fmt.Fprintf(os.Stderr, "adctl: %d %s: %s\n", apiErr.StatusCode, code, apiErr.Message)
+	for _, field := range apiErr.Fields {
+		fmt.Fprintf(os.Stderr, "  %s: %s\n", field.Field, field.Rule)
+	}
+	if apiErr.TraceID != "" {
+		fmt.Fprintf(os.Stderr, "  trace_id: %s\n", apiErr.TraceID)
+	}
+}
+
+// globals are the connection and output flags every command accepts
+type globals struct {
+	url     string
+	apiKey  string
+	output  string
+	timeout time.Duration
+}
+
+// register adds the global flags to fs, defaulting to the environment
+func (g *globals) register(fs *flag.FlagSet) {
+	fs.StringVar(&g.url, "url", envOr("ADCTL_URL", "http://localhost:8080"), "base URL of the API ($ADCTL_URL)")
+	fs.StringVar(&g.apiKey, "api-key", os.Getenv("ADCTL_API_KEY"), "API key sent as a bearer token ($ADCTL_API_KEY)")
+	fs.StringVar(&g.output, "o", "table", "output format, table or json")
+	fs.DurationVar(&g.timeout, "timeout", 10*time.Second, "timeout of each HTTP attempt")
+}
+
+// client builds the API client after the flags are parsed
+func (g *globals) client() (*client.Client, error) {
+	if g.output != "table" && g.output != "json" {
+		return nil, usagef("invalid -o %q, must be table or json", g.output)
+	}
+	opts := []client.Option{client.WithTimeout(g.timeout)}
+	if g.apiKey != "" {
+		opts = append(opts, client.WithAPIKey(g.apiKey))
+	}
+	return client.New(g.url, opts...), nil
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/internal/config"
+	"ad_service/internal/server"
+	"ad_service/pkg/cache"
+	"ad_service/pkg/client"
+	"ad_service/pkg/pagination"
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// adColumns are the columns of an ad row as the repository selects them by ID, keywords first
+var adColumns = []string{
+	"keywords", "id", "public_id", "slug", "title", "description", "price", "created_at", "is_active", "external_ref",
+	"category", "expires_at", "weight", "status", "status_reason", "owner_id", "renewed_at", "renewal_count",
+	"renewal_window_start", "archived_at", "favorites_count", "campaign_id", "latitude", "longitude",
+}
+
+// testCreatedAt is the creation and renewal time of the ads the mock returns
+var testCreatedAt = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// adRow returns the values of an approved, active ad in the order of adColumns
+func adRow(id int64, title string) []driver.Value {
+	return []driver.Value{
+		"bike,red", id, nil, nil, title, "Description of " + title, "10.00", testCreatedAt, true, nil,
+		"sports", nil, int64(1), "approved", nil, nil, testCreatedAt, int64(0), nil, nil, int64(0), nil, nil, nil,
+	}
+}
+
+// adRows returns the rows of a query by ID; listings select the same columns without keywords
+func adRows(keywords bool, rows ...[]driver.Value) *sqlmock.Rows {
+	columns := adColumns
+	if !keywords {
+		columns = adColumns[1:]
+	}
+	result := sqlmock.NewRows(columns)
+	for _, row := range rows {
+		if !keywords {
+			row = row[1:]
+		}
+		result.AddRow(row...)
+	}
+	return result
+}
+
+// expectAd answers the lookup of ad id with row, or with no rows when row is nil
+func expectAd(mock sqlmock.Sqlmock, id int64, row []driver.Value) {
+	if row == nil {
+		mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(id, "default").WillReturnRows(adRows(true))
+		return
+	}
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(id, "default").WillReturnRows(adRows(true, row))
+	expectNoTranslations(mock)
+}
+
+// expectNoTranslations answers the translations query of the found ads with no rows
+func expectNoTranslations(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("FROM ad_translations").WillReturnRows(sqlmock.NewRows([]string{"ad_id", "locale", "title", "description"}))
+}
+
+// newTestServer serves the API routes with the real handlers on a mocked database, points
+// $ADCTL_URL and $ADCTL_API_KEY at it and returns the Authorization header of the last request.
+// The mock must have met all its expectations when the test ends.
+func newTestServer(t *testing.T) (sqlmock.Sqlmock, *string) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := cache.NewMemoryCache(time.Minute)
+	t.Cleanup(func() {
+		memory.Close()
+		db.Close()
+	})
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	service := &ad.AdService{
+		Repo:  &ad.Repository{DB: db},
+		Cache: cache.NewTenantCache(memory),
+		TTL:   config.NewReloadable(config.CacheConfig{WriteMode: ad.WriteModeInvalidate, LockTimeout: 5 * time.Second, LockWait: 500 * time.Millisecond, MutationLockTTL: 5 * time.Second, MutationLockWait: time.Second}),
+		Rules: config.NewReloadable(config.AdsConfig{ExposeNumericIDs: true, SearchEngine: ad.SearchLike}),
+	}
+	handlers := server.Handlers{Ads: &ad.Handler{Service: service, Paging: pagination.Policy{MaxLimit: 100, Mode: pagination.ModeClamp}}}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	server.RegisterRoutes(r, handlers, config.AuthConfig{UserIDHeader: "X-User-Id", RoleHeader: "X-User-Role", AdminRole: "admin"}, config.TenancyConfig{}, false)
+	var authorization string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		authorization = req.Header.Get("Authorization")
+		r.ServeHTTP(w, req)
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Setenv("ADCTL_URL", srv.URL)
+	t.Setenv("ADCTL_API_KEY", "secret-key")
+	return mock, &authorization
+}
+
+// runCLI runs adctl with args and returns the exit code and what it printed
+func runCLI(t *testing.T, args ...string) (code int, stdout, stderr string) {
+	t.Helper()
+	dir := t.TempDir()
+	outFile, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errFile, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outFile.Close()
+	defer errFile.Close()
+
+	previousOut, previousErr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = outFile, errFile
+	code = run(args)
+	os.Stdout, os.Stderr = previousOut, previousErr
+
+	out, _ := os.ReadFile(outFile.Name())
+	errOut, _ := os.ReadFile(errFile.Name())
+	return code, string(out), string(errOut)
+}
+
+// writeAdFile writes an ad request to a JSON file and returns its path
+func writeAdFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ad.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGetAndList(t *testing.T) {
+	mock, authorization := newTestServer(t)
+
+	expectAd(mock, 7, adRow(7, "Bike"))
+	code, out, errOut := runCLI(t, "get", "7")
+	if code != exitOK {
+		t.Fatalf("get = %d: %s", code, errOut)
+	}
+	for _, line := range []string{"ID:           7\n", "Title:        Bike\n", "Price:        10.00\n", "Keywords:     bike, red\n", "Status:       approved\n"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("get printed %q, want the line %q", out, line)
+		}
+	}
+	if *authorization != "Bearer secret-key" {
+		t.Errorf("Authorization = %q, want the key of $ADCTL_API_KEY", *authorization)
+	}
+
+	// The ID may follow the flags, and the flags override the environment
+	expectAd(mock, 7, adRow(7, "Bike"))
+	code, out, _ = runCLI(t, "get", "-o", "json", "--api-key", "other-key", "7")
+	var got client.Ad
+	if err := json.Unmarshal([]byte(out), &got); code != exitOK || err != nil || got.ID != 7 || got.Title != "Bike" {
+		t.Errorf("get -o json = %d %q, want ad 7 as JSON", code, out)
+	}
+	if *authorization != "Bearer other-key" {
+		t.Errorf("Authorization = %q, want the key of --api-key", *authorization)
+	}
+
+	mock.ExpectQuery(`FROM ads .*ORDER BY price asc LIMIT \? OFFSET \?`).WillReturnRows(adRows(false, adRow(1, "Bike"), adRow(2, "Car")))
+	expectNoTranslations(mock)
+	code, out, errOut = runCLI(t, "list", "--sort-by", "price", "--order", "asc", "--limit", "2", "--page", "2")
+	if code != exitOK {
+		t.Fatalf("list = %d: %s", code, errOut)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "ID  TITLE") || !strings.HasPrefix(lines[2], "2   Car") {
+		t.Errorf("list printed %q, want a header and ads 1 and 2", out)
+	}
+}
+
+func TestCreateUpdateDelete(t *testing.T) {
+	mock, _ := newTestServer(t)
+	path := writeAdFile(t, `{"title": "Mountain bike", "description": "Barely used", "price": 250.5, "category": "sports"}`)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO ads").WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectExec("UPDATE ads SET slug").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT created_at FROM ads").WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(testCreatedAt))
+	mock.ExpectCommit()
+	code, out, errOut := runCLI(t, "create", "-f", path, "-o", "json")
+	var created client.Ad
+	if err := json.Unmarshal([]byte(out), &created); code != exitOK || err != nil || created.ID != 9 || created.Title != "Mountain bike" {
+		t.Errorf("create = %d %q %s, want ad 9", code, out, errOut)
+	}
+
+	// PUT returns nothing, so the stored ad is read back
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE ads SET title = ").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectAd(mock, 9, adRow(9, "Mountain bike"))
+	if code, out, errOut := runCLI(t, "update", "9", "-f", path); code != exitOK || !strings.Contains(out, "Title:        Mountain bike") {
+		t.Errorf("update = %d %q %s, want the stored ad", code, out, errOut)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM ads").WithArgs(int64(9), "default").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if code, out, _ := runCLI(t, "delete", "9", "-o", "json"); code != exitOK || out != "{\"deleted\":true,\"id\":9}\n" {
+		t.Errorf("delete = %d %q", code, out)
+	}
+
+	// Typos in the file are caught before any request
+	typo := writeAdFile(t, `{"title": "Bike", "descripton": "Typo"}`)
+	if code, _, errOut := runCLI(t, "create", "-f", typo); code != exitFailure || !strings.Contains(errOut, `unknown field "descripton"`) {
+		t.Errorf("create with an unknown field = %d %q", code, errOut)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	mock, _ := newTestServer(t)
+
+	// A full page is followed by the next one, a short page ends the export
+	page := make([][]driver.Value, exportPageSize)
+	for i := range page {
+		page[i] = adRow(int64(i+1), fmt.Sprintf("Ad %d", i+1))
+	}
+	mock.ExpectQuery("FROM ads").WillReturnRows(adRows(false, page...))
+	expectNoTranslations(mock)
+	last := adRow(101, `Bike, "like new"`)
+	mock.ExpectQuery("FROM ads").WillReturnRows(adRows(false, last))
+	expectNoTranslations(mock)
+
+	out := filepath.Join(t.TempDir(), "ads.csv")
+	code, _, errOut := runCLI(t, "export", "--format", "csv", "--sort-by", "id", "--out", out)
+	if code != exitOK || errOut != "Exported 101 ads to "+out+"\n" {
+		t.Fatalf("export = %d %q", code, errOut)
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("export wrote invalid CSV: %v", err)
+	}
+	if len(records) != 102 || strings.Join(records[0], ",") != strings.Join(csvColumns, ",") {
+		t.Fatalf("%d records, want the header and 101 ads", len(records))
+	}
+	if row := records[101]; row[0] != "101" || row[1] != `Bike, "like new"` || row[3] != "10.00" || row[13] != "2026-01-01T12:00:00Z" {
+		t.Errorf("last row = %v", row)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	mock, _ := newTestServer(t)
+
+	expectAd(mock, 404, nil)
+	code, out, errOut := runCLI(t, "get", "404")
+	if code != exitNotFound || out != "" || !strings.HasPrefix(errOut, "adctl: 404 not_found: ") {
+		t.Errorf("get of a missing ad = %d %q", code, errOut)
+	}
+
+	// Validation errors show the code and the failed fields
+	path := writeAdFile(t, `{"description": "No title", "price": 10}`)
+	code, _, errOut = runCLI(t, "create", "-f", path)
+	if code != exitValidation || !strings.HasPrefix(errOut, "adctl: 400 validation_failed: ") || !strings.Contains(errOut, "\n  title: required\n") {
+		t.Errorf("create without a title = %d %q", code, errOut)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	t.Setenv("ADCTL_URL", "http://127.0.0.1:1")
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"no command", nil, exitUsage},
+		{"help", []string{"help"}, exitOK},
+		{"unknown command", []string{"show", "1"}, exitUsage},
+		{"command help", []string{"get", "-h"}, exitOK},
+		{"missing ID", []string{"get"}, exitUsage},
+		{"invalid ID", []string{"delete", "abc"}, exitUsage},
+		{"unknown flag", []string{"list", "--colour"}, exitUsage},
+		{"output format", []string{"list", "-o", "yaml"}, exitUsage},
+		{"missing file", []string{"create"}, exitUsage},
+		{"partial radius search", []string{"list", "--lat", "52.5", "--lng", "13.4"}, exitUsage},
+		{"export format", []string{"export", "--format", "xml"}, exitUsage},
+		// Nothing listens on the URL
+		{"transport error", []string{"get", "1", "--timeout", "100ms"}, exitFailure},
+	}
+	for _, tt := range tests {
+		if code, _, errOut := runCLI(t, tt.args...); code != tt.code {
+			t.Errorf("%s: exit code %d, want %d: %s", tt.name, code, tt.code, errOut)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := map[int]int{
+		http.StatusBadRequest:          exitValidation,
+		http.StatusUnauthorized:        exitDenied,
+		http.StatusForbidden:           exitDenied,
+		http.StatusNotFound:            exitNotFound,
+		http.StatusConflict:            exitConflict,
+		http.StatusUnprocessableEntity: exitValidation,
+		http.StatusTooManyRequests:     exitFailure,
+		http.StatusServiceUnavailable:  exitServer,
+	}
+	for status, want := range tests {
+		if got := exitCode(fmt.Errorf("get: %w", &client.APIError{StatusCode: status})); got != want {
+			t.Errorf("exit code of a %d = %d, want %d", status, got, want)
+		}
+	}
+}
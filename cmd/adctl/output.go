@@ -0,0 +1,148 @@
+package main
+
+import (
+	"ad_service/pkg/client"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// printAds writes ads as JSON or a table; single prints one ad as a JSON object or field list
+func printAds(w io.Writer, format string, ads []client.Ad, single bool) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if single {
+			return encoder.Encode(ads[0])
+		}
+		return encoder.Encode(ads)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if single {
+		ad := ads[0]
+		rows := [][2]string{
+			{"ID", strconv.FormatInt(ad.ID, 10)},
+			{"Public ID", ad.PublicID},
+			{"Slug", ad.Slug},
+			{"Title", ad.Title},
+			{"Description", ad.Description},
+			{"Price", price(ad)},
+			{"Category", ad.Category},
+			{"Status", ad.Status},
+			{"Active", strconv.FormatBool(ad.IsActive)},
+			{"Owner", deref(ad.OwnerID)},
+			{"Keywords", strings.Join(ad.Keywords, ", ")},
+			{"Created", formatTime(ad.CreatedAt)},
+			{"Renewed", formatTime(ad.RenewedAt)},
+		}
+		if ad.ExpiresAt != nil {
+			rows = append(rows, [2]string{"Expires", formatTime(*ad.ExpiresAt)})
+		}
+		if ad.ArchivedAt != nil {
+			rows = append(rows, [2]string{"Archived", formatTime(*ad.ArchivedAt)})
+		}
+		for _, row := range rows {
+			fmt.Fprintf(tw, "%s:\t%s\n", row[0], row[1])
+		}
+		return tw.Flush()
+	}
+
+	fmt.Fprintln(tw, "ID\tTITLE\tPRICE\tCATEGORY\tSTATUS\tACTIVE\tRENEWED")
+	for _, ad := range ads {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%t\t%s\n",
+			ad.ID, truncate(ad.Title, 40), price(ad), ad.Category, ad.Status, ad.IsActive, formatTime(ad.RenewedAt))
+	}
+	return tw.Flush()
+}
+
+// csvColumns are the columns of export --format csv
+var csvColumns = []string{"id", "title", "description", "price", "currency", "category", "status", "is_active",
+	"owner_id", "campaign_id", "keywords", "latitude", "longitude", "created_at", "renewed_at", "expires_at", "archived_at"}
+
+// csvWriter writes ads as CSV rows, with the header before the first batch
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) write(ads []client.Ad) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write(csvColumns); err != nil {
+			return err
+		}
+	}
+	for _, ad := range ads {
+		campaignID := ""
+		if ad.CampaignID != nil {
+			campaignID = strconv.Itoa(*ad.CampaignID)
+		}
+		record := []string{
+			strconv.FormatInt(ad.ID, 10), ad.Title, ad.Description, strconv.FormatFloat(ad.Price, 'f', 2, 64), ad.Currency,
+			ad.Category, ad.Status, strconv.FormatBool(ad.IsActive), deref(ad.OwnerID), campaignID,
+			strings.Join(ad.Keywords, ";"), formatFloat(ad.Latitude), formatFloat(ad.Longitude),
+			ad.CreatedAt.UTC().Format(time.RFC3339), ad.RenewedAt.UTC().Format(time.RFC3339),
+			formatTimePtr(ad.ExpiresAt), formatTimePtr(ad.ArchivedAt),
+		}
+		if err := c.w.Write(record); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// price formats the price with its currency and the converted price when one was requested
+func price(ad client.Ad) string {
+	s := strconv.FormatFloat(ad.Price, 'f', 2, 64)
+	if ad.Currency != "" {
+		s += " " + ad.Currency
+	}
+	if ad.DisplayPrice != nil {
+		s += fmt.Sprintf(" (%.2f %s)", *ad.DisplayPrice, ad.DisplayCurrency)
+	}
+	return s
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func formatFloat(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
+
+func formatTime(t time.Time) string {
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func formatTimePtr(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -2,23 +2,64 @@ package main
 
 import (
 	"ad_service/internal/ad"
+	"ad_service/internal/campaign"
 	"ad_service/internal/config"
+	"ad_service/internal/currency"
 	"ad_service/internal/database"
+	"ad_service/internal/search"
+	"ad_service/internal/server"
+	"ad_service/internal/sitemap"
+	"ad_service/pkg/cache"
 	"ad_service/pkg/metrics"
 	"ad_service/pkg/middleware"
+	"ad_service/pkg/pagination"
+	"ad_service/pkg/scheduler"
 	"ad_service/pkg/tracing"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 func main() {
+	configPath := flag.String("config", "", "path to the config file (default: $"+config.ConfigEnvVar+", then config.yaml in ., /etc/ad-service or $HOME/.ad-service)")
+	check := flag.Bool("check", false, "verify the configuration and dependencies, print a summary and exit")
+	flag.Parse()
+
+	// Structured logs, with the trace and span IDs of the context passed to the slog *Context
+	// methods. The log package writes through the same handler. The level is info until the
+	// configuration is loaded and follows log.level on hot reload.
+	logLevel := &slog.LevelVar{}
+	slog.SetDefault(slog.New(tracing.NewLogHandler(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))))
+
 	// Load configuration using viper
-	cfg, err := config.LoadConfig()
+	cfg, err := config.LoadConfig(*configPath)
 	if err != nil {
+		if *check {
+			fmt.Printf("FAIL config: %v\n", err)
+			os.Exit(1)
+		}
 		log.Fatalf("Could not load configuration: %v", err)
 	}
+	logLevel.Set(cfg.Log.SlogLevel())
+
+	// Preflight check: exercise the dependencies without serving traffic
+	if *check {
+		if !runCheck(cfg) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Initialize Prometheus metrics before anything records into them
+	appMetrics := metrics.InitMetrics(cfg.Metrics)
 
 	// Connect to the database using loaded config
 	db, err := database.Connect(cfg.MySQL)
@@ -31,33 +72,137 @@ func main() {
 	// service := &ad.AdService{Repo: &repo}
 	// handler := ad.NewHandler(service)
 
+	// Initialize the cache driver selected in the configuration
+	adCache, err := cache.New(cfg.Cache, cfg.Redis)
+	if err != nil {
+		if cfg.Cache.Required {
+			log.Fatalf("Could not initialize cache: %v", err)
+		}
+		// The cache is optional: serve everything from MySQL rather than refusing to start
+		log.Printf("Could not initialize cache, continuing without caching: %v", err)
+		adCache = cache.NoopCache{}
+		metrics.CacheDegraded.Set(1)
+	}
+
 	// Initialize repository, service, and handler
-	repo := &ad.Repository{DB: db}
-	service := &ad.AdService{Repo: repo}
-	handler := &ad.Handler{Service: service}
+	repo := &ad.Repository{DB: db, SearchOutbox: cfg.Search.Elasticsearch()}
+	cacheTTLs, adRules := config.NewReloadable(cfg.Cache), config.NewReloadable(cfg.Ads)
+	service := &ad.AdService{Repo: repo, Cache: cache.NewTenantCache(adCache), TTL: cacheTTLs, Rules: adRules}
+	converter := currency.NewConverter(cfg.Currency, currency.StaticProvider{Table: cfg.Currency.Rates}, adCache)
+	paging := pagination.Policy{MaxLimit: cfg.Server.MaxPageSize, Mode: cfg.Server.PageSizeMode}
+	handler := &ad.Handler{Service: service, Currency: converter, Paging: paging}
+	sitemapHandler := &sitemap.Handler{Ads: repo, Config: cfg.Sitemap}
+	searchService := &search.SearchService{Ads: service}
+	if cfg.Search.Elasticsearch() {
+		searchService.Client = search.NewClient(cfg.Search)
+	}
+	searchHandler := &search.Handler{Service: searchService, Paging: paging}
+	campaignHandler := &campaign.Handler{Service: &campaign.CampaignService{Repo: &campaign.Repository{DB: db}, Ads: service}, Paging: paging}
 
-	// Initialize Prometheus metrics
-	metrics.InitMetrics()
+	// Background goroutines are stopped once the servers have shut down
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+
+	// Propagate invalidations between replicas; only possible when Redis is the cache
+	if cfg.Cache.Driver == cache.DriverRedis {
+		invalidator, err := cache.NewInvalidator(cfg.Cache, cfg.Redis)
+		if err != nil {
+			log.Printf("Could not set up cache invalidation, replicas will rely on TTLs: %v", err)
+		} else {
+			service.Invalidator = invalidator
+			go invalidator.Run(service.EvictLocal, backgroundCtx)
+		}
+
+		// Views for GET /ads/trending; without them it lists the most recent ads
+		trending, err := cache.NewTrending(cfg.Cache, cfg.Redis, ad.MaxTrendingWindow)
+		if err != nil {
+			log.Printf("Could not set up trending, it will list recent ads: %v", err)
+		} else {
+			service.Trending = trending
+		}
+	}
+
+	// Record the impressions of served ads off the request path
+	stopImpressions := service.StartImpressions(cfg.Ads.ImpressionWorkers, cfg.Ads.ImpressionQueueSize)
+
+	// Periodic jobs, started once tracing is set up and stopped on shutdown
+	jobs := scheduler.New()
+	jobs.Use(metrics.InstrumentJob)
+	schedule := func(job scheduler.Job) {
+		if err := jobs.Add(job); err != nil {
+			log.Fatalf("Could not schedule background job: %v", err)
+		}
+	}
+
+	// Keep the most read ads cached so they never expire under load
+	if cfg.Cache.HotKeys > 0 {
+		schedule(service.EnableHotKeys(cfg.Cache))
+	}
+
+	// Deactivate ads past expires_at; one replica at a time holds the job lock
+	if cfg.Ads.ExpireInterval > 0 {
+		schedule(service.ExpiryJob(cfg.Ads.ExpireInterval, cfg.Ads.ExpireBatchSize))
+	}
+
+	// Apply the ad changes queued in the search outbox to the index; one replica at a time
+	if cfg.Search.Elasticsearch() {
+		indexer := &search.Indexer{Repo: repo, Client: searchService.Client, Cache: adCache, BatchSize: cfg.Search.IndexBatchSize}
+		schedule(indexer.Job(cfg.Search.IndexInterval))
+	}
+
+	if cfg.Metrics.AdsRefreshInterval > 0 {
+		schedule(scheduler.Job{
+			Name:      "refresh_ads_total",
+			Interval:  cfg.Metrics.AdsRefreshInterval,
+			Timeout:   metrics.AdsTotalRefreshTimeout,
+			Immediate: true,
+			Run: func(ctx context.Context) error {
+				return metrics.RefreshAdsTotal(repo, ctx)
+			},
+		})
+	}
 
 	// Initialize OpenTelemetry tracing
-	cleanup := tracing.InitTracer(cfg.Tracing)
-	defer cleanup()
+	shutdownTracing, err := tracing.InitTracer(cfg.Tracing)
+	if err != nil {
+		log.Fatalf("Could not initialize tracing: %v", err)
+	}
+	jobs.Start()
+
+	// Push the same metrics over OTLP when enabled, /metrics keeps serving them meanwhile
+	metricsResource, err := tracing.NewResource(cfg.Tracing)
+	if err != nil {
+		log.Fatalf("Could not build metrics resource: %v", err)
+	}
+	shutdownMetrics, err := appMetrics.InitOTLP(cfg.Metrics.OTLP, metricsResource)
+	if err != nil {
+		log.Fatalf("Could not initialize OTLP metrics: %v", err)
+	}
+
+	// Apply log level, cache TTL, rate limit and sampler ratio changes from config.yaml without a
+	// restart
+	config.Watch(*cfg, reloader{cache: cacheTTLs, ads: adRules, logLevel: logLevel}.apply)
 
 	// Set up Gin router
 	r := gin.Default()
 
-	// Metrics endpoint for Prometheus
-	r.GET("/metrics", gin.WrapH(metrics.PrometheusHandler()))
+	// Start a server span per request, named after the route and continuing the caller's trace.
+	// It runs first so the handler spans are its children and metrics can attach exemplars.
+	r.Use(otelgin.Middleware("ad-service", otelgin.WithFilter(tracing.ExcludePaths(cfg.Tracing.ExcludePaths))))
+
+	// Carry the gateway's end-user ID in baggage so every span gets enduser.id
+	if cfg.Tracing.UserIDHeader != "" {
+		r.Use(middleware.UserID(cfg.Tracing.UserIDHeader))
+	}
+
+	// Expose the trace ID so client reports can be matched to a trace
+	r.Use(middleware.TraceID())
 
 	// Add middleware to track Prometheus metrics for every request
-	r.Use(metrics.MetricsMiddlewareGin())
+	r.Use(appMetrics.MetricsMiddlewareGin(cfg.Metrics))
 
-	// API Endpoints
-	r.POST("/ads", handler.AddAd)
-	r.GET("/ads", handler.GetAllAds)
-	r.GET("/ads/:id", handler.GetAdByID)
-	r.PUT("/ads/:id", handler.UpdateAd)
-	r.DELETE("/ads/:id", handler.DeleteAd)
+	// The API routes, unversioned and under /v1, behind the caller identity middleware except for
+	// the sitemap and /version
+	server.RegisterRoutes(r, server.Handlers{Ads: handler, Campaigns: campaignHandler, Sitemap: sitemapHandler, Search: searchHandler}, cfg.Auth, cfg.Tenancy, cfg.Server.LegacyResponses)
 
 	// Configure the HTTP server
 	srv := &http.Server{
@@ -65,6 +210,26 @@ func main() {
 		Handler: r,
 	}
 
-	//GracefulShutdown
-	middleware.GracefulShutdown(srv)
+	// Operational endpoints live on a separate port so the public load balancer never exposes them
+	internalSrv := server.NewInternalServer(":"+cfg.Server.InternalPort, appMetrics.PrometheusHandler(), db.PingContext, cfg.Server.Pprof)
+
+	// Drain the servers, then release everything else in dependency order: stop the background
+	// jobs, flush the spans and metrics of the last requests and close the connections
+	middleware.GracefulShutdown(cfg.Server.ShutdownTimeout, []*http.Server{srv, internalSrv},
+		middleware.ShutdownHook{Name: "background workers", Fn: func(ctx context.Context) error {
+			stopBackground()
+			return errors.Join(jobs.Stop(ctx), stopImpressions(ctx))
+		}},
+		middleware.ShutdownHook{Name: "tracer", Fn: shutdownTracing},
+		middleware.ShutdownHook{Name: "metrics exporter", Fn: shutdownMetrics},
+		middleware.ShutdownHook{Name: "cache", Fn: func(ctx context.Context) error {
+			if service.Trending != nil {
+				service.Trending.Close()
+			}
+			return adCache.Close()
+		}},
+		middleware.ShutdownHook{Name: "database", Fn: func(ctx context.Context) error {
+			return db.Close()
+		}},
+	)
 }
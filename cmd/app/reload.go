@@ -0,0 +1,35 @@
+package main
+
+import (
+	"ad_service/internal/config"
+	"ad_service/pkg/metrics"
+	"ad_service/pkg/tracing"
+	"log/slog"
+)
+
+// reloader holds the values that change on hot reload. The components read them on every use,
+// so storing a new value is all it takes to apply it.
+type reloader struct {
+	cache    *config.Reloadable[config.CacheConfig]
+	ads      *config.Reloadable[config.AdsConfig]
+	logLevel *slog.LevelVar
+}
+
+// apply is called by config.Watch for every change to the config file. It stores the dynamic
+// values of next and counts the reload as applied, as ignored when only static keys changed, or
+// as rejected when the file was invalid.
+func (r reloader) apply(next config.Config, changed []string, err error) {
+	switch {
+	case err != nil:
+		metrics.ConfigReloads.WithLabelValues("rejected").Inc()
+		return
+	case len(changed) == 0:
+		metrics.ConfigReloads.WithLabelValues("ignored").Inc()
+		return
+	}
+	r.cache.Store(next.Cache)
+	r.ads.Store(next.Ads)
+	r.logLevel.Set(next.Log.SlogLevel())
+	tracing.SetSamplerRatio(next.Tracing.SamplerRatio)
+	metrics.ConfigReloads.WithLabelValues("applied").Inc()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"ad_service/internal/config"
+	"ad_service/pkg/metrics"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+)
+
+func TestReloaderAppliesConfigFileChanges(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(yaml string) {
+		t.Helper()
+		if err := os.WriteFile(path+".tmp", []byte(yaml), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("cache:\n  adTTL: 1m\n")
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	r := reloader{cache: config.NewReloadable(cfg.Cache), ads: config.NewReloadable(cfg.Ads), logLevel: &slog.LevelVar{}}
+	done := make(chan struct{}, 10)
+	config.Watch(*cfg, func(next config.Config, changed []string, err error) {
+		r.apply(next, changed, err)
+		done <- struct{}{}
+	})
+	reloads := func(result string) float64 {
+		return testutil.ToFloat64(metrics.ConfigReloads.WithLabelValues(result))
+	}
+
+	tests := []struct {
+		name   string
+		yaml   string
+		result string
+		adTTL  time.Duration
+		level  slog.Level
+	}{
+		{"TTL and level", "log:\n  level: warn\ncache:\n  adTTL: 2m\nads:\n  reportsPerHour: 4\n", "applied", 2 * time.Minute, slog.LevelWarn},
+		{"invalid file", "log:\n  level: warn\ncache:\n  adTTL: -1m\n", "rejected", 2 * time.Minute, slog.LevelWarn},
+		{"DSN and port", "log:\n  level: warn\ncache:\n  adTTL: 2m\nads:\n  reportsPerHour: 4\nmysql:\n  host: mysql.prod.svc\nserver:\n  port: \"8081\"\n", "ignored", 2 * time.Minute, slog.LevelWarn},
+	}
+	for _, tt := range tests {
+		before := reloads(tt.result)
+		write(tt.yaml)
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: no reload within 5s", tt.name)
+		}
+		if got := reloads(tt.result) - before; got != 1 {
+			t.Errorf("%s: config_reloads_total{%s} grew by %v, want 1", tt.name, tt.result, got)
+		}
+		if got := r.cache.Load().AdTTL; got != tt.adTTL {
+			t.Errorf("%s: cache.adTTL = %s, want %s", tt.name, got, tt.adTTL)
+		}
+		if got := r.logLevel.Level(); got != tt.level {
+			t.Errorf("%s: log level = %s, want %s", tt.name, got, tt.level)
+		}
+		if got := r.ads.Load().ReportsPerHour; got != 4 {
+			t.Errorf("%s: ads.reportsPerHour = %d, want 4", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Command reindex rebuilds the search index of a tenant from MySQL, e.g. after creating the index,
+// changing search.analyzer or losing the cluster. It loads the same configuration as the service
+// and creates the index when it is missing. The searchable ads are written in ID order, batch by
+// batch, then the documents it didn't rewrite are deleted. The service keeps serving and indexing
+// meanwhile; searches only see the old documents of an ad until its batch is written.
+package main
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/internal/config"
+	"ad_service/internal/database"
+	"ad_service/internal/search"
+	"ad_service/pkg/tenant"
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func main() {
+	configPath := flag.String("config", "", "path to the config file (default: $"+config.ConfigEnvVar+", then config.yaml in ., /etc/ad-service or $HOME/.ad-service)")
+	tenantID := flag.String("tenant", tenant.Default, "tenant whose ads are reindexed")
+	batchSize := flag.Int("batch", 500, "ads read from MySQL and written to the index per batch")
+	timeout := flag.Duration("timeout", time.Minute, "per request to the cluster, overriding search.timeout")
+	flag.Parse()
+
+	if *batchSize <= 0 || *timeout <= 0 {
+		log.Fatalf("Invalid flags: batch and timeout must be positive")
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Could not load configuration: %v", err)
+	}
+	if !cfg.Search.Elasticsearch() {
+		log.Fatalf("search.engine is %s, there is no index to rebuild", cfg.Search.Engine)
+	}
+	db, err := database.Connect(cfg.MySQL)
+	if err != nil {
+		log.Fatalf("Could not connect to database: %v", err)
+	}
+	defer db.Close()
+	repo := &ad.Repository{DB: db}
+
+	// Bulk writes and the final delete take longer than a search
+	searchCfg := cfg.Search
+	searchCfg.Timeout = *timeout
+	client := search.NewClient(searchCfg)
+
+	// Stop between batches on Ctrl+C; the documents already written stay and nothing is deleted
+	ctx, stop := signal.NotifyContext(tenant.With(context.Background(), *tenantID), os.Interrupt)
+	defer stop()
+
+	if err := client.EnsureIndex(ctx); err != nil {
+		log.Fatalf("Could not prepare index %s: %v", cfg.Search.Index, err)
+	}
+
+	// Documents written from here on are kept; a second of margin covers clock skew with the cluster
+	started := time.Now().UTC().Add(-time.Second)
+	indexed := 0
+	var afterID int64
+	for {
+		ads, err := repo.SearchableAds(afterID, *batchSize, ctx)
+		if err != nil {
+			log.Fatalf("Could not read ads after ID %d, %d indexed: %v", afterID, indexed, err)
+		}
+		if len(ads) == 0 {
+			break
+		}
+		now := time.Now().UTC()
+		docs := make([]search.Document, len(ads))
+		for i := range ads {
+			docs[i] = search.NewDocument(*tenantID, &ads[i], now)
+		}
+		if err := client.Bulk(docs, nil, ctx); err != nil {
+			log.Fatalf("Could not index ads after ID %d, %d indexed: %v", afterID, indexed, err)
+		}
+		indexed += len(docs)
+		afterID = ads[len(ads)-1].ID
+		log.Printf("Indexed %d ads, up to ID %d", indexed, afterID)
+		if len(ads) < *batchSize {
+			break
+		}
+	}
+
+	// The delete only sees refreshed documents, so refresh first or it would find the previous
+	// versions of the ads just written
+	if err := client.Refresh(ctx); err != nil {
+		log.Fatalf("Could not refresh index %s: %v", cfg.Search.Index, err)
+	}
+	deleted, err := client.DeleteIndexedBefore(*tenantID, started, ctx)
+	if err != nil {
+		log.Fatalf("Could not delete stale documents: %v", err)
+	}
+	log.Printf("Reindexed %d ads of tenant %s into %s, deleted %d stale documents", indexed, *tenantID, cfg.Search.Index, deleted)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/pkg/money"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// catalogEntry describes the ads generated for one category
+type catalogEntry struct {
+	Items    []string
+	MinPrice float64
+	MaxPrice float64
+}
+
+// catalog holds the items and price ranges the generated ads draw from, by category
+var catalog = map[string]catalogEntry{
+	"bikes":       {[]string{"mountain bike", "road bike", "BMX", "e-bike", "city bike", "kids bike"}, 50, 3000},
+	"electronics": {[]string{"laptop", "smartphone", "tablet", "headphones", "4K TV", "game console", "camera"}, 20, 2500},
+	"furniture":   {[]string{"sofa", "dining table", "office chair", "bookshelf", "wardrobe", "bed frame"}, 15, 1500},
+	"cars":        {[]string{"sedan", "hatchback", "SUV", "pickup truck", "minivan", "coupe"}, 1500, 45000},
+	"books":       {[]string{"novel collection", "cookbook", "textbook", "comic series", "encyclopedia set"}, 2, 150},
+	"clothing":    {[]string{"winter jacket", "leather boots", "wool sweater", "running shoes", "denim jacket"}, 5, 400},
+	"sports":      {[]string{"treadmill", "tennis racket", "snowboard", "dumbbell set", "yoga mat", "kayak"}, 10, 1800},
+}
+
+// categories lists the catalog keys in a fixed order, so a seed always produces the same ads
+var categories = []string{"bikes", "books", "cars", "clothing", "electronics", "furniture", "sports"}
+
+var (
+	conditions = []string{"Brand new", "Like new", "Barely used", "Well kept", "Used", "Vintage", "Refurbished"}
+	highlights = []string{
+		"Comes with the original box and receipt.",
+		"Selling because I am moving abroad.",
+		"No scratches, always kept indoors.",
+		"Recently serviced, works perfectly.",
+		"Minor signs of wear, see photos.",
+		"Pick up only, cash or bank transfer.",
+		"Price is slightly negotiable.",
+		"Can deliver within the city for a small fee.",
+	}
+)
+
+// generator builds fake ads from a seeded RNG, so the same seed and options give the same ads
+type generator struct {
+	rng         *rand.Rand
+	now         time.Time
+	categories  bool    // fill in the category, left empty otherwise
+	owners      int     // spread ads over this many owners, none when 0
+	activeRatio float64 // share of ads marked active
+}
+
+// next returns a new fake ad. Most ads are approved so they show up in the public listings,
+// the rest wait for moderation or were rejected.
+func (g *generator) next() *ad.Ad {
+	category := categories[g.rng.Intn(len(categories))]
+	entry := catalog[category]
+	item := entry.Items[g.rng.Intn(len(entry.Items))]
+	condition := conditions[g.rng.Intn(len(conditions))]
+
+	// Prices cluster at the low end of the range like real listings, on a log scale
+	logMin, logMax := math.Log(entry.MinPrice), math.Log(entry.MaxPrice)
+	price := money.FromFloat(math.Exp(logMin + g.rng.Float64()*(logMax-logMin)))
+
+	description := fmt.Sprintf("%s %s for sale.", condition, item)
+	for i, n := 0, 1+g.rng.Intn(3); i < n; i++ {
+		description += " " + highlights[g.rng.Intn(len(highlights))]
+	}
+
+	seeded := &ad.Ad{
+		Title:       fmt.Sprintf("%s %s", condition, item),
+		Description: description,
+		Price:       price,
+		IsActive:    g.rng.Float64() < g.activeRatio,
+		Weight:      1 + g.rng.Intn(10),
+		Status:      ad.StatusApproved,
+		CreatedAt:   g.now.Add(-time.Duration(g.rng.Int63n(int64(365 * 24 * time.Hour)))).Truncate(time.Second),
+	}
+	switch roll := g.rng.Float64(); {
+	case roll < 0.05:
+		seeded.Status = ad.StatusRejected
+	case roll < 0.15:
+		seeded.Status = ad.StatusPending
+	}
+	if g.categories {
+		seeded.Category = category
+	}
+	if g.owners > 0 {
+		owner := fmt.Sprintf("seed-user-%d", 1+g.rng.Intn(g.owners))
+		seeded.OwnerID = &owner
+	}
+	return seeded
+}
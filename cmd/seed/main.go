@@ -0,0 +1,121 @@
+// Command seed fills the database with realistic fake ads for new environments and load tests.
+// It loads the same configuration as the service, so it targets the same database.
+package main
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/internal/config"
+	"ad_service/internal/database"
+	"ad_service/pkg/tenant"
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+func main() {
+	configPath := flag.String("config", "", "path to the config file (default: $"+config.ConfigEnvVar+", then config.yaml in ., /etc/ad-service or $HOME/.ad-service)")
+	count := flag.Int("count", 1000, "number of ads to generate")
+	batchSize := flag.Int("batch", 500, "ads inserted per transaction")
+	seed := flag.Int64("seed", 1, "random seed; the same seed and options generate the same ads")
+	activeRatio := flag.Float64("active-ratio", 0.8, "share of the ads marked active, between 0 and 1")
+	withCategories := flag.Bool("categories", true, "give every ad a category")
+	owners := flag.Int("owners", 0, "spread the ads over this many owners (seed-user-1, ...), 0 for none")
+	tenantID := flag.String("tenant", tenant.Default, "tenant the ads are seeded into")
+	truncate := flag.Bool("truncate", false, "delete every existing ad of the tenant first")
+	yes := flag.Bool("yes", false, "don't ask for confirmation before --truncate")
+	flag.Parse()
+
+	if *count <= 0 || *batchSize <= 0 || *activeRatio < 0 || *activeRatio > 1 || *owners < 0 {
+		log.Fatalf("Invalid flags: count and batch must be positive, active-ratio within [0, 1] and owners non-negative")
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Could not load configuration: %v", err)
+	}
+	db, err := database.Connect(cfg.MySQL)
+	if err != nil {
+		log.Fatalf("Could not connect to database: %v", err)
+	}
+	defer db.Close()
+	// Seeded ads reach the search index through the outbox, like ads created over the API
+	repo := &ad.Repository{DB: db, SearchOutbox: cfg.Search.Elasticsearch()}
+
+	// Stop between batches on Ctrl+C; the batches already committed stay
+	ctx, stop := signal.NotifyContext(tenant.With(context.Background(), *tenantID), os.Interrupt)
+	defer stop()
+
+	target := fmt.Sprintf("tenant %s of %s on %s:%s", *tenantID, cfg.MySQL.Database, cfg.MySQL.Host, cfg.MySQL.Port)
+	if *truncate {
+		if !*yes && !confirm("Delete every ad in "+target+"?") {
+			log.Fatalf("Aborted, nothing was deleted")
+		}
+		deleted, err := repo.DeleteAllAds(ctx)
+		if err != nil {
+			log.Fatalf("Could not delete ads: %v", err)
+		}
+		log.Printf("Deleted %d ads from %s", deleted, target)
+	}
+
+	gen := &generator{
+		rng:         rand.New(rand.NewSource(*seed)),
+		now:         time.Now(),
+		categories:  *withCategories,
+		owners:      *owners,
+		activeRatio: *activeRatio,
+	}
+
+	log.Printf("Seeding %d ads into %s in batches of %d (seed %d)", *count, target, *batchSize, *seed)
+	start := time.Now()
+	inserted := 0
+	for inserted < *count {
+		size := *batchSize
+		if remaining := *count - inserted; remaining < size {
+			size = remaining
+		}
+		batch := make([]*ad.Ad, size)
+		for i := range batch {
+			batch[i] = gen.next()
+		}
+		// AddAds stamps created_at with the insert time, so the generated dates are written after
+		createdAt := make([]time.Time, size)
+		for i, seeded := range batch {
+			createdAt[i] = seeded.CreatedAt
+		}
+
+		batchStart := time.Now()
+		if err := repo.AddAds(batch, ctx); err != nil {
+			log.Fatalf("Could not insert ads after %d of %d: %v", inserted, *count, err)
+		}
+		for i, seeded := range batch {
+			seeded.CreatedAt = createdAt[i]
+		}
+		if err := repo.BackdateAds(batch, ctx); err != nil {
+			log.Fatalf("Could not backdate ads after %d of %d: %v", inserted, *count, err)
+		}
+		inserted += size
+
+		log.Printf("Inserted %d/%d ads (%.0f ads/s for this batch)", inserted, *count, float64(size)/time.Since(batchStart).Seconds())
+	}
+
+	elapsed := time.Since(start)
+	log.Printf("Seeded %d ads in %s, %.0f ads/s", inserted, elapsed.Round(time.Millisecond), float64(inserted)/elapsed.Seconds())
+	log.Printf("Cached list pages expire within cache.listTTL; POST /admin/cache/purge with {\"all\": true} clears them right away")
+}
+
+// confirm asks a yes/no question on stdin and reports whether the answer was yes
+func confirm(question string) bool {
+	fmt.Printf("%s Type yes to continue: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(strings.ToLower(answer)) == "yes"
+}
@@ -0,0 +1,46 @@
+/*
+This file holds the audit log of changes made to ads.
+Entries are written in the same transaction as the change they describe.
+*/
+package ad
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// AuditEntry is one row of the ad_audit_log table
+type AuditEntry struct {
+	AdID   int64
+	Action string  // what happened, e.g. a moderation status or "renewed"
+	Actor  string  // user ID of the caller, "system" for the service's own changes
+	Detail *string // optional free text, e.g. a rejection reason
+}
+
+// insertAudit writes an audit entry inside the given transaction
+func insertAudit(tx *sql.Tx, entry AuditEntry, ctx context.Context) error {
+	query := "INSERT INTO ad_audit_log (ad_id, action, actor, detail) VALUES (?, ?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, query, entry.AdID, entry.Action, entry.Actor, entry.Detail); err != nil {
+		return fmt.Errorf("could not insert audit entry: %w", err)
+	}
+	return nil
+}
+
+// insertAudits writes several audit entries with one statement inside the given transaction
+func insertAudits(tx *sql.Tx, entries []AuditEntry, ctx context.Context) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	query := "INSERT INTO ad_audit_log (ad_id, action, actor, detail) VALUES (?, ?, ?, ?)" +
+		strings.Repeat(", (?, ?, ?, ?)", len(entries)-1)
+	params := make([]interface{}, 0, len(entries)*4)
+	for _, entry := range entries {
+		params = append(params, entry.AdID, entry.Action, entry.Actor, entry.Detail)
+	}
+	if _, err := tx.ExecContext(ctx, query, params...); err != nil {
+		return fmt.Errorf("could not insert audit entries: %w", err)
+	}
+	return nil
+}
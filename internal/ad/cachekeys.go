@@ -0,0 +1,87 @@
+/*
+This file builds the cache keys used by the service layer.
+Keys are relative: the cache layer puts them under the configured namespace and schema version.
+*/
+package ad
+
+import (
+	"ad_service/pkg/cache"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// serveSnapshotKey is the key holding the serialized list of servable ads
+var serveSnapshotKey = cache.Key("ads", "serve_snapshot")
+
+// slugCacheKey is the key mapping a slug to the ID of its ad
+func slugCacheKey(slug string) string {
+	return cache.Key("ad", "slug", slug)
+}
+
+// listGenerationKey holds a counter embedded in every list cache key.
+// Bumping it after a write makes all cached pages unreachable at once.
+var listGenerationKey = cache.Key("ads", "list_generation")
+
+// listKeyPrefix is shared by every cached page of GET /ads
+var listKeyPrefix = cache.Key("ads", "list") + ":"
+
+// adCacheKey is the key of a single ad
+func adCacheKey(id int64) string {
+	return cache.Key("ad", strconv.FormatInt(id, 10))
+}
+
+// listCacheKey is the key of one page of GET /ads for a list generation and filter. Owner
+// filtered pages are never cached, so the owner is not part of the key. The title filter, the
+// category and the search are escaped so a ':' in them can't make two filters share a key.
+func listCacheKey(generation string, q ListQuery) string {
+	filter := q.Filter
+	status := filter.Status
+	if status == "" {
+		status = "all"
+	}
+	state := "live"
+	if filter.Archived {
+		state = "archived"
+	}
+	near := "anywhere"
+	if filter.Near != nil {
+		near = filter.Near.String()
+	}
+	title := "any"
+	if filter.TitleContains != "" {
+		title = "has=" + url.QueryEscape(filter.TitleContains)
+	}
+	category := "any"
+	if filter.Category != "" {
+		category = "cat=" + url.QueryEscape(filter.Category)
+	}
+	search := "none"
+	if filter.Search != "" {
+		search = filter.SearchEngine + "=" + url.QueryEscape(filter.Search)
+	}
+	return listKeyPrefix + cache.Key(generation, status, state, strconv.Itoa(filter.CampaignID), near, title, category, strconv.FormatInt(filter.AfterID, 10), search, strconv.Itoa(q.Page), strconv.Itoa(q.Limit), q.SortBy, q.Order)
+}
+
+// dailyStatsCacheKey is the key of a daily stats response
+func dailyStatsCacheKey(from, to time.Time, isActive *bool) string {
+	active := "all"
+	if isActive != nil {
+		active = strconv.FormatBool(*isActive)
+	}
+	return cache.Key("ads", "stats", "daily", from.Format("2006-01-02"), to.Format("2006-01-02"), active)
+}
+
+// suggestCacheKey is the key of the title suggestions for a normalized query in a locale, "default"
+// when no locale was requested
+func suggestCacheKey(normalized, locale string, limit int) string {
+	if locale == "" {
+		locale = "default"
+	}
+	return cache.Key("ads", "suggest", locale, strconv.Itoa(limit), normalized)
+}
+
+// similarCacheKey is the key of the similar ads of a source ad
+func similarCacheKey(id int64, limit int) string {
+	return cache.Key("ads", "similar", strconv.FormatInt(id, 10), strconv.Itoa(limit))
+}
@@ -0,0 +1,47 @@
+package ad
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheKeys(t *testing.T) {
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	active := true
+	tests := []struct {
+		got, want string
+	}{
+		{adCacheKey(7), "ad:7"},
+		{slugCacheKey("red-bike"), "ad:slug:red-bike"},
+		{dailyStatsCacheKey(from, from.AddDate(0, 0, 6), nil), "ads:stats:daily:2026-03-01:2026-03-07:all"},
+		{dailyStatsCacheKey(from, from, &active), "ads:stats:daily:2026-03-01:2026-03-01:true"},
+		{suggestCacheKey("bik", "", 5), "ads:suggest:default:5:bik"},
+		{similarCacheKey(7, 10), "ads:similar:7:10"},
+		{listCacheKey("3", ListQuery{Page: 2, Limit: 10, SortBy: "id", Order: "asc"}), "ads:list:3:all:live:0:anywhere:any:any:0:none:2:10:id:asc"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("key = %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestListCacheKeyEscapesFilters(t *testing.T) {
+	q := ListQuery{Page: 1, Limit: 10, SortBy: "id", Order: "asc"}
+	// A ':' in a filter can't shift the other parts of the key
+	withColon, split := q, q
+	withColon.Filter.TitleContains = "a:b"
+	split.Filter.TitleContains = "a"
+	split.Filter.Category = "b"
+	first, second := listCacheKey("1", withColon), listCacheKey("1", split)
+	if first == second {
+		t.Errorf("different filters share the key %q", first)
+	}
+	if !strings.Contains(first, "has=a%3Ab") {
+		t.Errorf("key %q doesn't escape the title filter", first)
+	}
+	if !strings.HasPrefix(first, listKeyPrefix) {
+		t.Errorf("key %q is outside %q, so list invalidation would miss it", first, listKeyPrefix)
+	}
+}
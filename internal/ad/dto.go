@@ -0,0 +1,197 @@
+/*
+This file holds the request and response bodies of the ad endpoints. Requests carry only the
+fields a client may set, so server-owned ones like id, created_at, status and owner_id can't be
+written through the API; responses decouple the wire format from the Ad entity, which the
+repository and the cache keep using.
+*/
+package ad
+
+import (
+	"ad_service/pkg/money"
+	"ad_service/pkg/timestamp"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// UpdateAdRequest is the body of PUT /ads/:id and PUT /ads/by-ref/:ref
+type UpdateAdRequest struct {
+	Title       string       `json:"title" binding:"required"`
+	Description string       `json:"description" binding:"required"`
+	Price       money.Amount `json:"price" binding:"gt=0,max=9999999999"`
+	// IsActive left out keeps the stored state on update and is false on create
+	IsActive  *bool      `json:"is_active"`
+	Category  string     `json:"category,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Weight 0 keeps the default, or the current weight on update; the bound is MaxAdWeight
+	Weight     int      `json:"weight" binding:"min=0,max=100"`
+	CampaignID *int     `json:"campaign_id,omitempty"`
+	Keywords   []string `json:"keywords,omitempty"`
+	// Latitude and Longitude are set together or not at all
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	// Translations are keyed by locale, which must be configured in ads.locales
+	Translations map[string]Translation `json:"translations,omitempty"`
+	// RegenerateSlug rebuilds the slug from the new title on PUT /ads/:id; slugs are otherwise
+	// kept, so shared links survive title edits
+	RegenerateSlug bool `json:"regenerate_slug,omitempty"`
+}
+
+// CreateAdRequest is the body of POST /ads
+type CreateAdRequest struct {
+	UpdateAdRequest
+	ExternalRef *string `json:"external_ref,omitempty"`
+}
+
+// ToAd maps the request to a new Ad; the moderation fields are left for the handler to set
+func (r *UpdateAdRequest) ToAd() *Ad {
+	return &Ad{
+		Title:          r.Title,
+		Description:    r.Description,
+		Price:          r.Price,
+		IsActive:       r.IsActive != nil && *r.IsActive,
+		UpdateActive:   r.IsActive != nil,
+		Category:       r.Category,
+		ExpiresAt:      timestamp.UTC(r.ExpiresAt),
+		Weight:         r.Weight,
+		CampaignID:     r.CampaignID,
+		Keywords:       r.Keywords,
+		Latitude:       r.Latitude,
+		Longitude:      r.Longitude,
+		Translations:   r.Translations,
+		RegenerateSlug: r.RegenerateSlug,
+	}
+}
+
+// ToAd maps the request to a new Ad
+func (r *CreateAdRequest) ToAd() *Ad {
+	ad := r.UpdateAdRequest.ToAd()
+	ad.ExternalRef = r.ExternalRef
+	return ad
+}
+
+// AdResponse is an ad as returned by the API
+type AdResponse struct {
+	ID             *int64                 `json:"id,omitempty"` // left out unless ads.exposeNumericIDs is set
+	PublicID       *string                `json:"public_id,omitempty"`
+	Slug           *string                `json:"slug,omitempty"`
+	Title          string                 `json:"title"`
+	Description    string                 `json:"description"`
+	Price          money.Amount           `json:"price"`
+	CreatedAt      timestamp.Time         `json:"created_at"`
+	IsActive       bool                   `json:"is_active"`
+	ExternalRef    *string                `json:"external_ref,omitempty"`
+	Category       string                 `json:"category,omitempty"`
+	ExpiresAt      *timestamp.Time        `json:"expires_at,omitempty"`
+	Weight         int                    `json:"weight"`
+	Status         string                 `json:"status"`
+	StatusReason   *string                `json:"status_reason,omitempty"`
+	OwnerID        *string                `json:"owner_id,omitempty"`
+	RenewedAt      timestamp.Time         `json:"renewed_at"`
+	ArchivedAt     *timestamp.Time        `json:"archived_at,omitempty"`
+	FavoritesCount int                    `json:"favorites_count"`
+	IsFavorited    *bool                  `json:"is_favorited,omitempty"`
+	CampaignID     *int                   `json:"campaign_id,omitempty"`
+	Keywords       []string               `json:"keywords,omitempty"`
+	Variant        *string                `json:"variant,omitempty"`
+	Translations   map[string]Translation `json:"translations,omitempty"`
+	Locale         string                 `json:"locale,omitempty"`
+	Latitude       *float64               `json:"latitude,omitempty"`
+	Longitude      *float64               `json:"longitude,omitempty"`
+	DistanceKm     *float64               `json:"distance_km,omitempty"`
+	Score          *float64               `json:"score,omitempty"`
+	// Price is in Currency; DisplayPrice and DisplayCurrency answer ?currency=
+	Currency              string        `json:"currency,omitempty"`
+	DisplayPrice          *money.Amount `json:"display_price,omitempty"`
+	DisplayCurrency       string        `json:"display_currency,omitempty"`
+	ConversionUnavailable bool          `json:"conversion_unavailable,omitempty"`
+}
+
+// NewAdResponse maps an ad to its response; the variants loaded for serving stay internal, and
+// so does the numeric ID unless exposeID is set
+func NewAdResponse(ad *Ad, exposeID bool) AdResponse {
+	response := AdResponse{
+		PublicID:              ad.PublicID,
+		Slug:                  ad.Slug,
+		Title:                 ad.Title,
+		Description:           ad.Description,
+		Price:                 ad.Price,
+		CreatedAt:             timestamp.From(ad.CreatedAt),
+		IsActive:              ad.IsActive,
+		ExternalRef:           ad.ExternalRef,
+		Category:              ad.Category,
+		ExpiresAt:             timestamp.FromPtr(ad.ExpiresAt),
+		Weight:                ad.Weight,
+		Status:                ad.Status,
+		StatusReason:          ad.StatusReason,
+		OwnerID:               ad.OwnerID,
+		RenewedAt:             timestamp.From(ad.RenewedAt),
+		ArchivedAt:            timestamp.FromPtr(ad.ArchivedAt),
+		FavoritesCount:        ad.FavoritesCount,
+		IsFavorited:           ad.IsFavorited,
+		CampaignID:            ad.CampaignID,
+		Keywords:              ad.Keywords,
+		Variant:               ad.Variant,
+		Translations:          ad.Translations,
+		Locale:                ad.Locale,
+		Latitude:              ad.Latitude,
+		Longitude:             ad.Longitude,
+		DistanceKm:            ad.DistanceKm,
+		Score:                 ad.Score,
+		Currency:              ad.Currency,
+		DisplayPrice:          ad.DisplayPrice,
+		DisplayCurrency:       ad.DisplayCurrency,
+		ConversionUnavailable: ad.ConversionUnavailable,
+	}
+	if exposeID {
+		id := ad.ID
+		response.ID = &id
+	}
+	return response
+}
+
+// NewAdResponses maps a list of ads, keeping an empty list an empty JSON array
+func NewAdResponses(ads []Ad, exposeID bool) []AdResponse {
+	responses := make([]AdResponse, len(ads))
+	for i := range ads {
+		responses[i] = NewAdResponse(&ads[i], exposeID)
+	}
+	return responses
+}
+
+// bindAdRequest binds the body into req and answers a 400 when it's malformed or breaks a
+// binding rule. Rule failures keep the messages and rule codes of the checks they replaced.
+func bindAdRequest(c *gin.Context, span trace.Span, req any) bool {
+	err := c.ShouldBindJSON(req)
+	if err == nil {
+		return true
+	}
+	span.RecordError(err)
+
+	message, failed := "Invalid request body", []fieldError{{"body", "json"}}
+	var failures validator.ValidationErrors
+	if errors.Is(err, money.ErrPrecision) {
+		message, failed = "Price must have at most two decimal places", []fieldError{{"price", "decimal_places"}}
+	} else if errors.As(err, &failures) {
+		// The first failure wins, in field order like the checks before binding tags
+		switch failures[0].StructField() {
+		case "Title", "Description":
+			message, failed = "Title and description are required", []fieldError{{"title", "required"}, {"description", "required"}}
+		case "Price":
+			message, failed = "Price cannot be zero or negative", []fieldError{{"price", "positive"}}
+			if failures[0].Tag() == "max" {
+				message, failed = "Price must be at most "+money.Max.String(), []fieldError{{"price", "max"}}
+			}
+		case "Weight":
+			message, failed = "Weight must be between 1 and "+strconv.Itoa(MaxAdWeight), []fieldError{{"weight", "range"}}
+		}
+	}
+	span.SetAttributes(attribute.String("error", message))
+	badRequest(c, span, message, failed...)
+	return false
+}
@@ -0,0 +1,278 @@
+package ad
+
+import (
+	"ad_service/internal/config"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// serverOwnedFields are set by a client trying to write the fields the server owns
+const serverOwnedFields = `"id": 999, "public_id": "chosen", "slug": "chosen", "created_at": "2000-01-01T00:00:00Z", ` +
+	`"renewed_at": "2000-01-01T00:00:00Z", "status": "approved", "owner_id": "mallory", "favorites_count": 50, "archived_at": "2000-01-01T00:00:00Z"`
+
+// ownedValue fails a query whose arguments include a value of serverOwnedFields
+type ownedValue struct{}
+
+func (m ownedValue) Match(v driver.Value) bool {
+	switch v := v.(type) {
+	case int64:
+		return v != 999 && v != 50
+	case string:
+		return v != "chosen" && v != "mallory" && v != StatusApproved
+	case *string:
+		return v == nil || *v != "chosen" && *v != "mallory"
+	case time.Time:
+		return v.Year() != 2000
+	}
+	return true
+}
+
+// notOwned matches each of n arguments against ownedValue
+func notOwned(n int) []driver.Value {
+	args := make([]driver.Value, n)
+	for i := range args {
+		args[i] = ownedValue{}
+	}
+	return args
+}
+
+func TestAddAdIgnoresServerOwnedFields(t *testing.T) {
+	service, mock := newTestService(t)
+	setRules(service, func(r *config.AdsConfig) { r.ExposeNumericIDs = true })
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) { r.POST("/ads", h.AddAd) })
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO ads").WithArgs(notOwned(15)...).WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectExec("UPDATE ads SET slug").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT created_at FROM ads").WithArgs(int64(9)).WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(created))
+	mock.ExpectCommit()
+
+	body := `{"title": "Bike", "description": "Red bike", "price": 10, ` + serverOwnedFields + `}`
+	w := serve(r, http.MethodPost, "/ads", strings.NewReader(body), testUserHeader, "user-1")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s", w.Code, w.Body.String())
+	}
+	var got struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"id":              float64(9),
+		"created_at":      "2026-03-01T12:00:00Z",
+		"status":          StatusPending,
+		"owner_id":        "user-1",
+		"favorites_count": float64(0),
+	}
+	for field, value := range want {
+		if got.Data[field] != value {
+			t.Errorf("%s = %v, want %v set by the server", field, got.Data[field], value)
+		}
+	}
+	if got.Data["public_id"] == "chosen" || got.Data["slug"] == "chosen" || got.Data["archived_at"] != nil {
+		t.Errorf("created ad %v kept identifiers or state of the request", got.Data)
+	}
+}
+
+func TestUpdateAdIgnoresServerOwnedFields(t *testing.T) {
+	service, mock := newTestService(t)
+	cfg := testCacheConfig
+	cfg.WriteMode = WriteModeInvalidate
+	service.TTL.Store(cfg)
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) { r.PUT("/ads/:id", h.UpdateAd) })
+
+	// The ad of the path is written, with none of the server-owned columns
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE ads SET title = \?, description = \?, price = \?, category = \?, expires_at = \?, campaign_id = \?, latitude = \?, longitude = \?, updated_at = CURRENT_TIMESTAMP WHERE id = \? AND tenant_id = \? AND archived_at IS NULL`).
+		WithArgs("Bike", "Red bike", mustAmount("10"), "", nil, nil, nil, nil, int64(5), "default").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	body := `{"title": "Bike", "description": "Red bike", "price": 10, ` + serverOwnedFields + `}`
+	if w := serve(r, http.MethodPut, "/ads/5", strings.NewReader(body), testUserHeader, "user-1"); w.Code != http.StatusOK {
+		t.Errorf("update = %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestNewAdResponse(t *testing.T) {
+	ad := testAd(7, "Bike")
+	variant := "b"
+	ad.Variant = &variant
+	ad.Variants = []Variant{{Key: "b", Title: "Bike B"}}
+
+	for _, exposeID := range []bool{false, true} {
+		data, err := json.Marshal(NewAdResponse(&ad, exposeID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields map[string]any
+		if err := json.Unmarshal(data, &fields); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := fields["id"]; ok != exposeID {
+			t.Errorf("exposeID %v: id present = %v", exposeID, ok)
+		}
+		if _, ok := fields["variants"]; ok {
+			t.Errorf("response %s exposes the variants loaded for serving", data)
+		}
+		if fields["variant"] != "b" || fields["title"] != "Bike" || fields["created_at"] != "2026-01-01T12:00:00Z" {
+			t.Errorf("response = %s", data)
+		}
+	}
+
+	// An empty list stays an empty JSON array
+	if data, _ := json.Marshal(NewAdResponses(nil, false)); string(data) != "[]" {
+		t.Errorf("no ads = %s, want []", data)
+	}
+}
+
+func TestPricesOnTheWire(t *testing.T) {
+	service, mock := newTestService(t)
+	setRules(service, func(r *config.AdsConfig) { r.ExposeNumericIDs = true })
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) {
+		r.POST("/ads", h.AddAd)
+		r.GET("/ads/:id", h.GetAdByID)
+	})
+
+	tests := []struct {
+		name    string
+		price   string
+		message string
+	}{
+		{"sub-cent", "19.999", "Price must have at most two decimal places"},
+		{"above the column", "100000000", "Price must be at most 99999999.99"},
+		{"zero", "0.00", "Price cannot be zero or negative"},
+	}
+	for _, tt := range tests {
+		body := `{"title": "Bike", "description": "Red bike", "price": ` + tt.price + `}`
+		w := serve(r, http.MethodPost, "/ads", strings.NewReader(body))
+		if w.Code != http.StatusBadRequest || errorMessage(t, w.Body.Bytes()) != tt.message {
+			t.Errorf("%s: %d %s, want 400 %q", tt.name, w.Code, w.Body.String(), tt.message)
+		}
+	}
+
+	// The price is written to the DECIMAL column as text and answered as given
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO ads").WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "Bike", "Red bike", "99999999.99", false, nil, "", nil, DefaultAdWeight, StatusPending, nil, nil, nil, nil).
+		WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectExec("UPDATE ads SET slug").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT created_at FROM ads").WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+	mock.ExpectCommit()
+	w := serve(r, http.MethodPost, "/ads", strings.NewReader(`{"title": "Bike", "description": "Red bike", "price": 99999999.99}`))
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"price":99999999.99,`) {
+		t.Errorf("create = %d %s, want the exact price", w.Code, w.Body.String())
+	}
+
+	// A sum float64 gets wrong comes back from the column exactly
+	row := adRow(testAd(5, "Bike"))
+	row[6] = []byte("0.30")
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(5), "default").WillReturnRows(sqlmock.NewRows(testAdColumns).AddRow(row...))
+	expectNoTranslations(mock)
+	w = serve(r, http.MethodGet, "/ads/5", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"price":0.30,`) {
+		t.Errorf("get = %d %s, want the price 0.30", w.Code, w.Body.String())
+	}
+}
+
+// utcTime matches a time argument at the instant of want and in UTC
+type utcTime struct{ want time.Time }
+
+func (m utcTime) Match(v driver.Value) bool {
+	switch t := v.(type) {
+	case time.Time:
+		return t.Equal(m.want) && t.Location() == time.UTC
+	case *time.Time:
+		return t != nil && t.Equal(m.want) && t.Location() == time.UTC
+	}
+	return false
+}
+
+func TestTimestampsRoundTrip(t *testing.T) {
+	service, mock := newTestService(t)
+	setRules(service, func(r *config.AdsConfig) { r.ExposeNumericIDs = true })
+	cfg := testCacheConfig
+	cfg.WriteMode = WriteModeInvalidate
+	service.TTL.Store(cfg)
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) {
+		r.POST("/ads", h.AddAd)
+		r.GET("/ads/:id", h.GetAdByID)
+		r.PUT("/ads/:id", h.UpdateAd)
+		r.GET("/ads/stats/daily", h.GetDailyStats)
+	})
+	// The session of a misconfigured connection answers in its own zone
+	moscow := time.FixedZone("MSK", 3*3600)
+	created := time.Date(2026, 3, 1, 15, 0, 0, 0, moscow)
+	expires := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	// field returns a top-level field of the data of an envelope
+	field := func(body []byte, name string) any {
+		var decoded struct {
+			Data map[string]any `json:"data"`
+		}
+		if err := json.Unmarshal(body, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		return decoded.Data[name]
+	}
+
+	// An offset on input is stored as UTC
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO ads").WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "Bike", "Red bike", "10.00", false, nil, "", utcTime{expires}, DefaultAdWeight, StatusPending, nil, nil, nil, nil).
+		WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectExec("UPDATE ads SET slug").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT created_at FROM ads").WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(created))
+	mock.ExpectCommit()
+	w := serve(r, http.MethodPost, "/ads", strings.NewReader(`{"title": "Bike", "description": "Red bike", "price": 10, "expires_at": "2026-06-01T14:00:00+02:00"}`))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s", w.Code, w.Body.String())
+	}
+	if got := field(w.Body.Bytes(), "created_at"); got != "2026-03-01T12:00:00Z" {
+		t.Errorf("created_at = %v, want the UTC instant", got)
+	}
+	if got := field(w.Body.Bytes(), "expires_at"); got != "2026-06-01T12:00:00Z" {
+		t.Errorf("expires_at = %v, want the UTC instant", got)
+	}
+
+	// Read back, every time is written with a trailing Z and no drift
+	stored := testAd(9, "Bike")
+	stored.CreatedAt, stored.RenewedAt = created, created
+	storedExpiry := expires.In(moscow)
+	stored.ExpiresAt = &storedExpiry
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(9), "default").WillReturnRows(adRows(stored))
+	expectNoTranslations(mock)
+	w = serve(r, http.MethodGet, "/ads/9", nil)
+	for name, want := range map[string]string{"created_at": "2026-03-01T12:00:00Z", "renewed_at": "2026-03-01T12:00:00Z", "expires_at": "2026-06-01T12:00:00Z"} {
+		if got := field(w.Body.Bytes(), name); got != want {
+			t.Errorf("read %s = %v, want %s", name, got, want)
+		}
+	}
+
+	// An update with another offset stores the same instant
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE ads SET title = ").WithArgs("Bike", "Red bike", mustAmount("10"), "", utcTime{expires}, nil, nil, nil, int64(9), "default").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	w = serve(r, http.MethodPut, "/ads/9", strings.NewReader(`{"title": "Bike", "description": "Red bike", "price": 10, "expires_at": "2026-06-01T07:00:00-05:00"}`))
+	if w.Code != http.StatusOK {
+		t.Errorf("update = %d %s", w.Code, w.Body.String())
+	}
+
+	// Bare dates of the range are UTC days
+	mock.ExpectQuery("GROUP BY day").
+		WithArgs("default", utcTime{time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)}, utcTime{time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC)}).
+		WillReturnRows(sqlmock.NewRows([]string{"day", "count"}))
+	if w := serve(r, http.MethodGet, "/ads/stats/daily?from=2026-03-01&to=2026-03-02", nil); w.Code != http.StatusOK {
+		t.Errorf("daily stats = %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,202 @@
+/*
+This file holds the background job that deactivates expired ads. Listings already hide ads past
+expires_at at query time, but until the job runs they are still stored as active, so exports,
+the audit log and anything else reading is_active would see them as live. Each run takes a
+cache lock so only one replica deactivates at a time, and works in batches of
+ads.expireBatchSize so a backlog never holds locks on the whole table.
+*/
+package ad
+
+import (
+	"ad_service/pkg/cache"
+	"ad_service/pkg/metrics"
+	"ad_service/pkg/scheduler"
+	"ad_service/pkg/tenant"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// expireLockName names the cache lock held by the replica running the job
+const expireLockName = "expire_ads"
+
+// DeactivateExpired deactivates up to limit active ads whose expires_at has passed, with
+// tracing, and returns their IDs by tenant. Every deactivation is recorded in the audit log as
+// "expired" by "system", in the same transaction. The job runs for the whole deployment, so it
+// is the one write that spans tenants.
+func (r *Repository) DeactivateExpired(limit int, ctx context.Context) (_ map[string][]int64, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "DeactivateExpiredRepository")
+	defer span.End()
+	defer observeQuery("deactivate_expired", time.Now(), &err, ctx)
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to begin transaction")
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	// Lock the batch first so the IDs returned are exactly the rows updated
+	rows, err := tx.QueryContext(ctx, "SELECT id, tenant_id FROM ads WHERE expires_at < NOW() AND is_active = TRUE ORDER BY id LIMIT ? FOR UPDATE", limit)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to select expired ads")
+		return nil, fmt.Errorf("could not select expired ads: %w", err)
+	}
+	var ids []int64
+	byTenant := map[string][]int64{}
+	for rows.Next() {
+		var id int64
+		var tenantID string
+		if err := rows.Scan(&id, &tenantID); err != nil {
+			rows.Close()
+			span.RecordError(err)
+			return nil, fmt.Errorf("could not scan expired ad: %w", err)
+		}
+		ids = append(ids, id)
+		byTenant[tenantID] = append(byTenant[tenantID], id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("could not read expired ads: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	placeholders := "?" + strings.Repeat(", ?", len(ids)-1)
+	params := make([]interface{}, len(ids))
+	for i, id := range ids {
+		params[i] = id
+	}
+	query := "UPDATE ads SET is_active = FALSE, updated_at = CURRENT_TIMESTAMP WHERE id IN (" + placeholders + ")"
+	if _, err := tx.ExecContext(ctx, query, params...); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to deactivate expired ads")
+		return nil, fmt.Errorf("could not deactivate expired ads: %w", err)
+	}
+
+	entries := make([]AuditEntry, len(ids))
+	for i, id := range ids {
+		entries[i] = AuditEntry{AdID: id, Action: "expired", Actor: "system"}
+	}
+	if err := insertAudits(tx, entries, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to record audit entries")
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to commit transaction")
+		return nil, fmt.Errorf("could not commit expired ads: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("ads_deactivated", len(ids)), attribute.String("db_status", "success"))
+	return byTenant, nil
+}
+
+// DeactivateExpired deactivates every expired ad, batchSize at a time, with tracing. The cached
+// copies of each batch are dropped along with the list pages and serve snapshots of their tenant. It returns
+// how many ads were deactivated, including those of the batches before an error.
+func (s *AdService) DeactivateExpired(batchSize int, ctx context.Context) (int, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "DeactivateExpiredService")
+	defer span.End()
+
+	total := 0
+	for {
+		byTenant, err := s.Repo.DeactivateExpired(batchSize, ctx)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to deactivate expired ads")
+			return total, err
+		}
+		deactivated := 0
+		for tenantID, ids := range byTenant {
+			s.InvalidateAds(ids, tenant.With(ctx, tenantID))
+			deactivated += len(ids)
+		}
+		total += deactivated
+		metrics.AdsExpired.Add(float64(deactivated))
+		metrics.AdsUpdated.Add(float64(deactivated))
+		// A short batch means the backlog is drained
+		if deactivated < batchSize {
+			break
+		}
+	}
+
+	span.SetAttributes(attribute.Int("ads_deactivated", total))
+	return total, nil
+}
+
+// CountExpired returns the number of ads of every tenant still active past their expires_at,
+// with tracing: the backlog of the expiry job
+func (r *Repository) CountExpired(ctx context.Context) (_ int, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "CountExpiredRepository")
+	defer span.End()
+	defer observeQuery("count_expired", time.Now(), &err, ctx)
+
+	var count int
+	if err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM ads WHERE expires_at < NOW() AND is_active = TRUE").Scan(&count); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to count expired ads")
+		return 0, fmt.Errorf("could not count expired ads: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("ads_count", count))
+	return count, nil
+}
+
+// ExpiryJob is the job running DeactivateExpired every interval. Each run holds a cache lock, so
+// replicas that find it taken skip that run, and gives up after one interval so a stuck run
+// never holds the lock past it.
+func (s *AdService) ExpiryJob(interval time.Duration, batchSize int) scheduler.Job {
+	return scheduler.Job{
+		Name:     "expire_ads",
+		Interval: interval,
+		Timeout:  interval,
+		Run: func(ctx context.Context) error {
+			return s.runExpiry(interval, batchSize, ctx)
+		},
+		Backlog: s.Repo.CountExpired,
+	}
+}
+
+// runExpiry makes one run of the expiry job and records its outcome
+func (s *AdService) runExpiry(interval time.Duration, batchSize int, ctx context.Context) error {
+	lock, err := cache.AcquireLock(s.cache(), expireLockName, interval, 0, ctx)
+	if errors.Is(err, cache.ErrLockNotAcquired) {
+		metrics.AdExpiryRuns.WithLabelValues("skipped").Inc()
+		return scheduler.ErrSkipped
+	}
+	if err != nil {
+		metrics.AdExpiryRuns.WithLabelValues("error").Inc()
+		return fmt.Errorf("could not lock the expired ads job: %w", err)
+	}
+	// The run context may be the one that timed out, the lock is released regardless
+	defer lock.Release(context.WithoutCancel(ctx))
+
+	deactivated, err := s.DeactivateExpired(batchSize, ctx)
+	if err != nil {
+		metrics.AdExpiryRuns.WithLabelValues("error").Inc()
+		return fmt.Errorf("could not deactivate expired ads, %d deactivated before the error: %w", deactivated, err)
+	}
+	metrics.AdExpiryRuns.WithLabelValues("success").Inc()
+	if deactivated > 0 {
+		slog.InfoContext(ctx, "Deactivated expired ads", "count", deactivated)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+/*
+This file holds the location of ads and the radius search of GET /ads?lat=&lng=&radius_km=. An
+indexed bounding box narrows the candidates and the Haversine distance filters and sorts them.
+*/
+package ad
+
+import (
+	"ad_service/internal/apperr"
+	"fmt"
+	"math"
+)
+
+// earthRadiusKm is the mean Earth radius used by the Haversine formula
+const earthRadiusKm = 6371.0
+
+// kmPerDegree is the length of one degree of latitude, and of longitude at the equator
+const kmPerDegree = math.Pi * earthRadiusKm / 180
+
+// MaxRadiusKm caps the radius of a search, keeping the bounding box prefilter selective
+const MaxRadiusKm = 500.0
+
+// ErrInvalidLocation is returned by ValidLocation for a half-set or out of range location
+var ErrInvalidLocation = apperr.Validation("latitude and longitude must be set together, latitude within [-90, 90] and longitude within [-180, 180]",
+	apperr.Field{Field: "latitude", Rule: "range"}, apperr.Field{Field: "longitude", Rule: "range"})
+
+// ValidLocation checks that latitude and longitude are both set or both unset, and within range
+func ValidLocation(latitude, longitude *float64) error {
+	if (latitude == nil) != (longitude == nil) {
+		return ErrInvalidLocation
+	}
+	if latitude != nil && !inRange(*latitude, 90) || longitude != nil && !inRange(*longitude, 180) {
+		return ErrInvalidLocation
+	}
+	return nil
+}
+
+// inRange reports whether value is a number within [-limit, limit]
+func inRange(value, limit float64) bool {
+	return !math.IsNaN(value) && value >= -limit && value <= limit
+}
+
+// GeoFilter restricts a listing to the ads within RadiusKm of a point
+type GeoFilter struct {
+	Latitude  float64
+	Longitude float64
+	RadiusKm  float64
+}
+
+// distanceColumn computes the Haversine distance in km between an ad and the point bound to its
+// three placeholders (latitude, latitude, longitude). LEAST keeps rounding errors out of ASIN's domain.
+const distanceColumn = "(2 * 6371 * ASIN(LEAST(1, SQRT(POW(SIN(RADIANS(latitude - ?) / 2), 2) + " +
+	"COS(RADIANS(?)) * COS(RADIANS(latitude)) * POW(SIN(RADIANS(longitude - ?) / 2), 2)))))"
+
+// distanceParams returns the values of the distanceColumn placeholders
+func (g GeoFilter) distanceParams() []interface{} {
+	return []interface{}{g.Latitude, g.Latitude, g.Longitude}
+}
+
+// boundingBox returns a condition, usable with the (latitude, longitude) index, matching every
+// ad within the radius and some just outside it. A box reaching a pole spans every longitude,
+// and one crossing the antimeridian wraps around to the other side.
+func (g GeoFilter) boundingBox() (string, []interface{}) {
+	deltaLat := g.RadiusKm / kmPerDegree
+	minLat, maxLat := g.Latitude-deltaLat, g.Latitude+deltaLat
+	condition := "latitude BETWEEN ? AND ?"
+	params := []interface{}{math.Max(minLat, -90), math.Min(maxLat, 90)}
+	if minLat <= -90 || maxLat >= 90 {
+		return condition, params
+	}
+
+	// Meridians converge away from the equator, so the box widens with the cosine of the
+	// latitude furthest from it
+	widestLat := math.Max(math.Abs(minLat), math.Abs(maxLat))
+	deltaLng := g.RadiusKm / (kmPerDegree * math.Cos(widestLat*math.Pi/180))
+	if deltaLng >= 180 {
+		return condition, params
+	}
+	minLng, maxLng := g.Longitude-deltaLng, g.Longitude+deltaLng
+	switch {
+	case minLng < -180:
+		condition += " AND (longitude >= ? OR longitude <= ?)"
+		params = append(params, minLng+360, maxLng)
+	case maxLng > 180:
+		condition += " AND (longitude >= ? OR longitude <= ?)"
+		params = append(params, minLng, maxLng-360)
+	default:
+		condition += " AND longitude BETWEEN ? AND ?"
+		params = append(params, minLng, maxLng)
+	}
+	return condition, params
+}
+
+// String identifies the search in cache keys
+func (g GeoFilter) String() string {
+	return fmt.Sprintf("%.6f,%.6f,%g", g.Latitude, g.Longitude, g.RadiusKm)
+}
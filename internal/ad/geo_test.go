@@ -0,0 +1,165 @@
+package ad
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// haversineKm is the distance distanceColumn computes in SQL
+func haversineKm(lat1, lng1, lat2, lng2 float64) float64 {
+	rad := math.Pi / 180
+	a := math.Pow(math.Sin((lat2-lat1)*rad/2), 2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Pow(math.Sin((lng2-lng1)*rad/2), 2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// inBox evaluates the condition of boundingBox for a point
+func inBox(condition string, params []interface{}, lat, lng float64) bool {
+	bound := func(i int) float64 { return params[i].(float64) }
+	if lat < bound(0) || lat > bound(1) {
+		return false
+	}
+	switch {
+	case len(params) == 2:
+		return true
+	case strings.Contains(condition, " OR "):
+		return lng >= bound(2) || lng <= bound(3)
+	default:
+		return lng >= bound(2) && lng <= bound(3)
+	}
+}
+
+func TestValidLocation(t *testing.T) {
+	tests := []struct {
+		name      string
+		latitude  *float64
+		longitude *float64
+		valid     bool
+	}{
+		{"unset", nil, nil, true},
+		{"set", floatPtr(52.52), floatPtr(13.405), true},
+		{"poles and antimeridian", floatPtr(-90), floatPtr(180), true},
+		{"latitude only", floatPtr(52.52), nil, false},
+		{"longitude only", nil, floatPtr(13.405), false},
+		{"latitude over 90", floatPtr(90.5), floatPtr(0), false},
+		{"longitude under -180", floatPtr(0), floatPtr(-180.1), false},
+		{"NaN", floatPtr(math.NaN()), floatPtr(0), false},
+	}
+	for _, tt := range tests {
+		if err := ValidLocation(tt.latitude, tt.longitude); (err == nil) != tt.valid || err != nil && !errors.Is(err, ErrInvalidLocation) {
+			t.Errorf("%s: ValidLocation = %v, want valid: %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestRadiusSearchFixtures(t *testing.T) {
+	// One degree of a great circle is 111.19 km
+	const degreeKm = 111.19
+	tests := []struct {
+		name       string
+		center     [2]float64
+		point      [2]float64
+		distanceKm float64
+		// box is the shape of the bounding box: "lat" only, "between" or "wrapped" longitudes
+		box string
+	}{
+		{"along the equator", [2]float64{0, 0}, [2]float64{0, 1}, degreeKm, "between"},
+		{"along a meridian", [2]float64{0, 0}, [2]float64{1, 0}, degreeKm, "between"},
+		{"across the antimeridian", [2]float64{0, 179.5}, [2]float64{0, -179.5}, degreeKm, "wrapped"},
+		{"across the antimeridian westwards", [2]float64{-18.1, -179.8}, [2]float64{-18.1, 179.8}, 0.4 * degreeKm * math.Cos(18.1*math.Pi/180), "wrapped"},
+		// Near a pole every longitude is within reach, the point is on the other side of it
+		{"over the north pole", [2]float64{89.9, 0}, [2]float64{89.9, 180}, 0.2 * degreeKm, "lat"},
+		{"at the south pole", [2]float64{-90, 0}, [2]float64{-89.5, 45}, 0.5 * degreeKm, "lat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			distance := haversineKm(tt.center[0], tt.center[1], tt.point[0], tt.point[1])
+			if math.Abs(distance-tt.distanceKm) > 0.1 {
+				t.Errorf("distance = %.2f km, want %.2f", distance, tt.distanceKm)
+			}
+
+			// Just inside the radius the point passes the prefilter, which HAVING then keeps
+			filter := GeoFilter{Latitude: tt.center[0], Longitude: tt.center[1], RadiusKm: tt.distanceKm + 1}
+			condition, params := filter.boundingBox()
+			if !inBox(condition, params, tt.point[0], tt.point[1]) {
+				t.Errorf("%s %v excludes %v", condition, params, tt.point)
+			}
+			var shape string
+			switch {
+			case len(params) == 2:
+				shape = "lat"
+			case strings.Contains(condition, " OR "):
+				shape = "wrapped"
+			default:
+				shape = "between"
+			}
+			if shape != tt.box {
+				t.Errorf("box %s %v, want a %s box", condition, params, tt.box)
+			}
+			for _, param := range params {
+				if bound := param.(float64); bound < -180 || bound > 180 {
+					t.Errorf("box %v has a bound out of range", params)
+				}
+			}
+		})
+	}
+
+	// The prefilter narrows away from the edge cases: a point 2 degrees out is outside a 100 km box
+	condition, params := GeoFilter{Latitude: 52.52, Longitude: 13.405, RadiusKm: 100}.boundingBox()
+	if inBox(condition, params, 52.52, 16.5) || inBox(condition, params, 54.6, 13.405) {
+		t.Errorf("%s %v keeps points far outside the radius", condition, params)
+	}
+}
+
+func TestRadiusSearchRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		message string
+	}{
+		{"incompatible sort", "lat=52.52&lng=13.405&radius_km=25&sort_by=price", "Radius search results are sorted by distance. sort_by must be 'distance' or left out."},
+		{"distance without radius", "sort_by=distance", "sort_by=distance requires a radius search with lat, lng and radius_km."},
+		{"partial", "lat=52.52&lng=13.405", "Radius search requires lat, lng and radius_km together."},
+		{"latitude out of range", "lat=91&lng=0&radius_km=25", "Invalid lat value. Must be a number between -90 and 90."},
+		{"longitude out of range", "lat=0&lng=180.5&radius_km=25", "Invalid lng value. Must be a number between -180 and 180."},
+		{"radius too large", "lat=0&lng=0&radius_km=501", "Invalid radius_km value. Must be greater than 0 and at most 500."},
+		{"zero radius", "lat=0&lng=0&radius_km=0", "Invalid radius_km value. Must be greater than 0 and at most 500."},
+	}
+	service, mock := newTestService(t)
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) { r.GET("/ads", h.GetAllAds) })
+	for _, tt := range tests {
+		w := serve(r, http.MethodGet, "/ads?"+tt.query, nil)
+		if w.Code != http.StatusBadRequest || errorMessage(t, w.Body.Bytes()) != tt.message {
+			t.Errorf("%s: %d %s, want 400 %q", tt.name, w.Code, w.Body.String(), tt.message)
+		}
+	}
+
+	// The nearest ads come first, each with its distance
+	potsdam := testAd(2, "Potsdam")
+	rows := sqlmock.NewRows(append([]string{"distance_km"}, testAdColumns[1:]...)).
+		AddRow(append([]driver.Value{26.9}, adRow(potsdam)[1:]...)...)
+	mock.ExpectQuery(`AS distance_km, .* latitude BETWEEN \? AND \? AND longitude BETWEEN \? AND \? HAVING distance_km <= \? ORDER BY distance_km asc, id asc`).
+		WillReturnRows(rows)
+	expectNoTranslations(mock)
+	w := serve(r, http.MethodGet, "/ads?lat=52.52&lng=13.405&radius_km=30", nil)
+	var body struct {
+		Data []struct {
+			Title      string   `json:"title"`
+			DistanceKm *float64 `json:"distance_km"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(body.Data) != 1 || body.Data[0].DistanceKm == nil || *body.Data[0].DistanceKm != 26.9 {
+		t.Errorf("radius search = %d %s, want Potsdam at 26.9 km", w.Code, w.Body.String())
+	}
+}
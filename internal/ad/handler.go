@@ -6,19 +6,35 @@ appropriate responses are sent back to the client.
 package ad
 
 import (
-	"database/sql"
+	"ad_service/internal/apperr"
+	"ad_service/internal/currency"
+	"ad_service/pkg/metrics"
+	"ad_service/pkg/middleware"
+	"ad_service/pkg/money"
+	"ad_service/pkg/pagination"
+	"ad_service/pkg/response"
+	"context"
 	"errors"
+	"io"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Handler struct holds a reference to the AdService
 type Handler struct {
-	Service *AdService
+	Service  *AdService
+	Currency *currency.Converter // converts prices for ?currency=, which is rejected when nil
+	Paging   pagination.Policy   // caps page sizes and ID lists
 }
 
 // NewHandler is a constructor for Handler
@@ -34,221 +50,1722 @@ func (h *Handler) GetAdByID(c *gin.Context) {
 	defer span.End()
 
 	// Parse the ID from the URL parameter and handle errors
-	id, err := strconv.Atoi(c.Param("id"))
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	// Check for non-numeric or non-positive IDs
 	if err != nil || id <= 0 {
 		span.RecordError(err)
 		span.SetAttributes(attribute.String("error", "Invalid ID parameter"))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		badRequest(c, span, "Invalid ID", fieldError{"id", "positive_integer"})
 		return
 	}
 
-	// Fetch the ad using the service layer, passing the trace context
-	ad, err := h.Service.GetAdByID(id, ctx)
+	span.SetAttributes(attribute.Int64("ad_id", id))
+	h.writeAd(c, span, func() (*Ad, error) { return h.Service.GetAdByID(id, ctx) }, ctx)
+}
+
+// GetAdByPublicID handles fetching a single ad by its public UUID, with tracing. It answers
+// like GetAdByID.
+func (h *Handler) GetAdByPublicID(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "GetAdByPublicIDHandler")
+	defer span.End()
+
+	publicID, err := uuid.Parse(c.Param("public_id"))
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid public ID parameter"))
+		badRequest(c, span, "Invalid public ID. Must be a UUID.", fieldError{"public_id", "uuid"})
+		return
+	}
+
+	span.SetAttributes(attribute.String("public_id", publicID.String()))
+	h.writeAd(c, span, func() (*Ad, error) { return h.Service.GetAdByPublicID(publicID.String(), ctx) }, ctx)
+}
+
+// GetAdBySlug handles fetching a single ad by its slug, with tracing. It answers like GetAdByID.
+func (h *Handler) GetAdBySlug(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "GetAdBySlugHandler")
+	defer span.End()
+
+	slug := c.Param("slug")
+	if !validSlug(slug) {
+		span.RecordError(errors.New("invalid slug"))
+		span.SetAttributes(attribute.String("error", "Invalid slug parameter"))
+		badRequest(c, span, "Invalid slug. Must be lowercase letters and digits joined by hyphens.", fieldError{"slug", "slug"})
+		return
+	}
+
+	span.SetAttributes(attribute.String("slug", slug))
+	h.writeAd(c, span, func() (*Ad, error) { return h.Service.GetAdBySlug(slug, ctx) }, ctx)
+}
+
+// writeAd answers a single ad lookup: it reads ?locale= and ?currency=, fetches the ad, hides it
+// when the caller may not see it and marks whether the caller favorited it
+func (h *Handler) writeAd(c *gin.Context, span trace.Span, fetch func() (*Ad, error), ctx context.Context) {
+	locale, ok := h.requestLocale(c, span)
+	if !ok {
+		return
+	}
+	conversion, ok := h.requestCurrency(c, span, ctx)
+	if !ok {
+		return
+	}
+
+	// Fetch the ad using the service layer, passing the trace context; the error middleware
+	// responds to a missing ad or a failure
+	ad, err := fetch()
 	if err != nil {
-		// Check if the error is due to "not found" or an internal issue
-		if err == sql.ErrNoRows {
-			// Handle case where the ad is not found
+		c.Error(err).SetMeta("Failed to fetch ad by ID")
+		return
+	}
+
+	// Ads awaiting or failing moderation are hidden from everyone but their owner and admins
+	caller := middleware.CallerFrom(c)
+	if !visibleTo(ad, caller) {
+		span.SetAttributes(attribute.String("error", "Ad not visible"))
+		c.Error(ErrAdNotFound)
+		return
+	}
+
+	// is_favorited is personal, so it is looked up per request instead of being cached with the ad
+	if caller.UserID != "" {
+		favorited, err := h.Service.IsFavorited(caller.UserID, ad.ID, ctx)
+		if err != nil {
+			c.Error(err).SetMeta("Failed to fetch ad by ID")
+			return
+		}
+		ad.IsFavorited = &favorited
+	}
+	ad.Localize(locale)
+	conversion.apply(ad)
+
+	span.SetAttributes(attribute.Int64("ad_id", ad.ID), attribute.String("status", "success"))
+	response.Data(c, http.StatusOK, NewAdResponse(ad, h.Service.Rules.Load().ExposeNumericIDs))
+}
+
+// GetRandomAd handles serving one random active ad, with tracing
+// Expected URL: http://localhost:8080/ads/random?category=bikes&min_price=10&max_price=500
+func (h *Handler) GetRandomAd(c *gin.Context) {
+	// Start a span for the handler
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "GetRandomAdHandler")
+	defer span.End()
+
+	filter := RandomAdFilter{Category: c.Query("category")}
+	locale, ok := h.requestLocale(c, span)
+	if !ok {
+		return
+	}
+
+	// Validate the optional price range
+	var err error
+	if raw := c.Query("min_price"); raw != "" {
+		filter.MinPrice, err = money.Parse(raw)
+		if err != nil || filter.MinPrice < 0 {
 			span.RecordError(err)
-			span.SetAttributes(attribute.Int("ad_id", id), attribute.String("error", "Ad not found"))
-			c.JSON(http.StatusNotFound, gin.H{"error": "Ad not found"})
-		} else {
-			// Handle internal server errors
+			badRequest(c, span, "Invalid min_price value. Must be a non-negative number with at most two decimal places.", fieldError{"min_price", "non_negative_number"})
+			return
+		}
+	}
+	if raw := c.Query("max_price"); raw != "" {
+		filter.MaxPrice, err = money.Parse(raw)
+		if err != nil || filter.MaxPrice < 0 {
 			span.RecordError(err)
-			span.SetAttributes(attribute.String("error", "Failed to fetch ad by ID"))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ad by ID"})
+			badRequest(c, span, "Invalid max_price value. Must be a non-negative number with at most two decimal places.", fieldError{"max_price", "non_negative_number"})
+			return
 		}
+	}
+	if filter.MaxPrice > 0 && filter.MinPrice > filter.MaxPrice {
+		badRequest(c, span, "Invalid price range. min_price cannot be greater than max_price.", fieldError{"min_price", "not_above_max_price"})
+		return
+	}
+
+	ad, err := h.Service.GetRandomAd(filter, ctx)
+	if errors.Is(err, ErrAdNotFound) {
+		err = errNoAds
+	}
+	if err != nil {
+		span.SetAttributes(attribute.String("error", "Failed to fetch random ad"))
+		c.Error(err).SetMeta("Failed to fetch random ad")
 		return
 	}
+	ad.Localize(locale)
 
-	span.SetAttributes(attribute.Int("ad_id", ad.ID), attribute.String("status", "success"))
-	c.JSON(http.StatusOK, ad)
+	span.SetAttributes(attribute.Int64("ad_id", ad.ID), attribute.String("status", "success"))
+	response.Data(c, http.StatusOK, NewAdResponse(ad, h.Service.Rules.Load().ExposeNumericIDs))
 }
 
-// AddAd handles the creation of a new ad, with tracing
-func (h *Handler) AddAd(c *gin.Context) {
+// errNoAds is the 404 of GET /ads/random when no ad matches the filters
+var errNoAds = apperr.New(apperr.ErrNotFound, "No ads found")
+
+// ServeAd handles delivering one active ad chosen by weighted rotation, with tracing
+// Expected URL: http://localhost:8080/ads/serve or http://localhost:8080/ads/serve?keywords=bike,mountain
+func (h *Handler) ServeAd(c *gin.Context) {
 	// Start a span for the handler
 	tracer := otel.Tracer("ad-service.handler")
-	ctx, span := tracer.Start(c.Request.Context(), "AddAdHandler")
+	ctx, span := tracer.Start(c.Request.Context(), "ServeAdHandler")
 	defer span.End()
 
-	var ad Ad
-	if err := c.ShouldBindJSON(&ad); err != nil {
-		span.RecordError(err)
-		span.SetAttributes(attribute.String("error", "Invalid request body"))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+	var keywords []string
+	if raw := c.Query("keywords"); raw != "" {
+		var err error
+		keywords, err = NormalizeKeywords(strings.Split(raw, ","))
+		if err != nil {
+			span.RecordError(err)
+			badRequest(c, span, "Invalid keywords value: "+err.Error(), fieldError{"keywords", "keywords"})
+			return
+		}
+	}
+	locale, ok := h.requestLocale(c, span)
+	if !ok {
 		return
 	}
 
-	// Validate title and description
-	if ad.Title == "" || ad.Description == "" {
-		span.RecordError(errors.New("title or description cannot be empty"))
-		span.SetAttributes(attribute.String("error", "Title or description missing"))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Title and description are required"})
+	ad, err := h.Service.ServeAd(keywords, ctx)
+	if err != nil {
+		if errors.Is(err, ErrAdNotFound) {
+			span.SetAttributes(attribute.String("status", "no eligible ads"))
+			c.Status(http.StatusNoContent)
+			return
+		}
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Failed to serve ad"))
+		internalError(c, err, "Failed to serve ad")
 		return
 	}
+	ad.Localize(locale)
 
-	// Validate price (have to be positive)
-	if ad.Price <= 0 {
-		span.RecordError(errors.New("invalid price value"))
-		span.SetAttributes(attribute.String("error", "Price cannot be zero or negative"))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Price cannot be zero or negative"})
+	span.SetAttributes(attribute.Int64("ad_id", ad.ID), attribute.String("status", "success"))
+	response.Data(c, http.StatusOK, NewAdResponse(ad, h.Service.Rules.Load().ExposeNumericIDs))
+}
+
+// maxStatsDays caps the number of days a daily stats request can span
+const maxStatsDays = 366
+
+// GetDailyStats handles the ads-created-per-day time series, with tracing
+// Expected URL: http://localhost:8080/ads/stats/daily?from=2024-01-01&to=2024-01-31&is_active=true
+func (h *Handler) GetDailyStats(c *gin.Context) {
+	// Start a span for the handler
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "GetDailyStatsHandler")
+	defer span.End()
+
+	// Dates are interpreted as UTC days
+	from, err := time.Parse("2006-01-02", c.Query("from"))
+	if err != nil {
+		span.RecordError(err)
+		badRequest(c, span, "Invalid from value. Must be a date formatted as YYYY-MM-DD.", fieldError{"from", "date"})
+		return
+	}
+	to, err := time.Parse("2006-01-02", c.Query("to"))
+	if err != nil {
+		span.RecordError(err)
+		badRequest(c, span, "Invalid to value. Must be a date formatted as YYYY-MM-DD.", fieldError{"to", "date"})
+		return
+	}
+	if to.Before(from) {
+		badRequest(c, span, "Invalid range. from cannot be after to.", fieldError{"from", "not_after_to"})
+		return
+	}
+	if to.Sub(from) >= maxStatsDays*24*time.Hour {
+		badRequest(c, span, "Invalid range. At most "+strconv.Itoa(maxStatsDays)+" days are allowed.", fieldError{"to", "max_range"})
 		return
 	}
 
-	if err := h.Service.AddAd(&ad, ctx); err != nil {
+	var isActive *bool
+	if raw, ok := c.GetQuery("is_active"); ok {
+		active, err := strconv.ParseBool(raw)
+		if err != nil {
+			span.RecordError(err)
+			badRequest(c, span, "Invalid is_active value. Must be either 'true' or 'false'.", fieldError{"is_active", "boolean"})
+			return
+		}
+		isActive = &active
+	}
+
+	stats, err := h.Service.GetDailyStats(from, to, isActive, ctx)
+	if err != nil {
 		span.RecordError(err)
-		span.SetAttributes(attribute.String("error", "Failed to add ad"))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add ad"})
+		span.SetAttributes(attribute.String("error", "Failed to fetch daily stats"))
+		internalError(c, err, "Failed to fetch daily stats")
 		return
 	}
 
-	span.SetAttributes(attribute.Int("ad_id", ad.ID), attribute.String("status", "success"))
-	c.JSON(http.StatusCreated, ad)
+	span.SetAttributes(attribute.Int("days_count", len(stats)), attribute.String("status", "success"))
+	response.Data(c, http.StatusOK, stats)
 }
 
-// GetAllAds handles fetching all ads, with tracing
-// Expected URL: http://localhost:8080/ads?page=1&limit=10&sort_by=created_at&order=asc
-func (h *Handler) GetAllAds(c *gin.Context) {
+// maxTrendingLimit caps the limit of GET /ads/trending
+const maxTrendingLimit = 50
 
-	// Start a span for the handler
+// GetTrendingAds handles listing the most viewed live ads over a recent window, with tracing
+// Expected URL: http://localhost:8080/ads/trending?window=24h&limit=10
+func (h *Handler) GetTrendingAds(c *gin.Context) {
 	tracer := otel.Tracer("ad-service.handler")
-	ctx, span := tracer.Start(c.Request.Context(), "GetAllAdsHandler")
+	ctx, span := tracer.Start(c.Request.Context(), "GetTrendingAdsHandler")
 	defer span.End()
 
-	// Paginating and sorting
-	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
-	if err != nil || page <= 0 {
+	rawWindow := c.DefaultQuery("window", "24h")
+	window, ok := TrendingWindows[rawWindow]
+	if !ok {
+		badRequest(c, span, "Invalid window value. Must be one of '1h', '6h', '24h', '7d'.", fieldError{"window", "one_of"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 || limit > maxTrendingLimit {
 		span.RecordError(err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page value. Must be a positive integer."})
+		badRequest(c, span, "Invalid limit value. Must be between 1 and "+strconv.Itoa(maxTrendingLimit)+".", fieldError{"limit", "range"})
+		return
+	}
+	locale, ok := h.requestLocale(c, span)
+	if !ok {
 		return
 	}
 
-	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
-	if err != nil || limit <= 0 {
+	ads, source, err := h.Service.GetTrendingAds(window, limit, ctx)
+	if err != nil {
 		span.RecordError(err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit value. Must be a positive integer."})
+		span.SetAttributes(attribute.String("error", "Failed to fetch trending ads"))
+		internalError(c, err, "Failed to fetch trending ads")
 		return
 	}
+	for i := range ads {
+		ads[i].Localize(locale)
+	}
 
-	sortBy := c.DefaultQuery("sort_by", "created_at")
-	// Validate if sortBy is one of the allowed fields
-	validSortFields := map[string]bool{
-		"id":         true,
-		"title":      true,
-		"price":      true,
-		"created_at": true,
-		"is_active":  true,
+	span.SetAttributes(attribute.Int("ads_count", len(ads)), attribute.String("source", source), attribute.String("status", "success"))
+	meta := gin.H{"window": rawWindow, "source": source}
+	if response.IsLegacy(c) {
+		meta["ads"] = NewAdResponses(ads, h.Service.Rules.Load().ExposeNumericIDs)
+		response.Data(c, http.StatusOK, meta)
+		return
 	}
-	if !validSortFields[sortBy] {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort_by value. Must be one of 'id', 'title', 'price', 'created_at', 'is_active'."})
+	response.List(c, http.StatusOK, NewAdResponses(ads, h.Service.Rules.Load().ExposeNumericIDs), meta)
+}
+
+// Bounds for the title suggestions endpoint
+const (
+	minSuggestQueryLength = 2
+	maxSuggestQueryLength = 100
+	maxSuggestLimit       = 20
+)
+
+// SuggestTitles handles title autocomplete suggestions, with tracing
+// Expected URL: http://localhost:8080/ads/suggest?q=bi&limit=10
+func (h *Handler) SuggestTitles(c *gin.Context) {
+	// Start a span for the handler
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "SuggestTitlesHandler")
+	defer span.End()
+
+	q := strings.TrimSpace(c.Query("q"))
+	if n := utf8.RuneCountInString(q); n < minSuggestQueryLength || n > maxSuggestQueryLength {
+		badRequest(c, span, "Invalid q value. Must be between "+strconv.Itoa(minSuggestQueryLength)+" and "+strconv.Itoa(maxSuggestQueryLength)+" characters.", fieldError{"q", "length"})
 		return
 	}
 
-	order := c.DefaultQuery("order", "asc")
-	if order != "asc" && order != "desc" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order value. Must be either 'asc' or 'desc'."})
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 || limit > maxSuggestLimit {
+		span.RecordError(err)
+		badRequest(c, span, "Invalid limit value. Must be between 1 and "+strconv.Itoa(maxSuggestLimit)+".", fieldError{"limit", "range"})
 		return
 	}
-	// Fetch ads from the service using the validated parameters
-	ads, err := h.Service.GetAllAds(page, limit, sortBy, order, ctx)
+	locale, ok := h.requestLocale(c, span)
+	if !ok {
+		return
+	}
+
+	titles, err := h.Service.SuggestTitles(q, locale, limit, ctx)
 	if err != nil {
 		span.RecordError(err)
-		span.SetAttributes(attribute.String("error", "Failed to fetch ads"))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ads"})
+		span.SetAttributes(attribute.String("error", "Failed to fetch suggestions"))
+		internalError(c, err, "Failed to fetch suggestions")
 		return
 	}
 
-	span.SetAttributes(attribute.String("status", "success"))
-	c.JSON(http.StatusOK, ads)
+	span.SetAttributes(attribute.Int("titles_count", len(titles)), attribute.String("status", "success"))
+	response.Data(c, http.StatusOK, titles)
 }
 
-// UpdateAd handles updating an existing ad, with tracing
-func (h *Handler) UpdateAd(c *gin.Context) {
-	// Start a span for the handler
+// maxSimilarLimit caps the limit of GET /ads/:id/similar
+const maxSimilarLimit = 20
+
+// GetSimilarAds handles listing ads similar to a source ad, with tracing
+// Expected URL: http://localhost:8080/ads/42/similar?limit=6
+func (h *Handler) GetSimilarAds(c *gin.Context) {
 	tracer := otel.Tracer("ad-service.handler")
-	ctx, span := tracer.Start(c.Request.Context(), "UpdateAdHandler")
+	ctx, span := tracer.Start(c.Request.Context(), "GetSimilarAdsHandler")
 	defer span.End()
 
-	// Validate ID
-	id, err := strconv.Atoi(c.Param("id"))
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	// Check for non-numeric or non-positive IDs
 	if err != nil || id <= 0 {
 		span.RecordError(err)
-		span.SetAttributes(attribute.String("error", "Invalid ad ID"))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ad ID"})
+		span.SetAttributes(attribute.String("error", "Invalid ID parameter"))
+		badRequest(c, span, "Invalid ID", fieldError{"id", "positive_integer"})
 		return
 	}
 
-	var ad Ad
-	if err := c.ShouldBindJSON(&ad); err != nil {
+	// Larger limits are capped rather than rejected
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "6"))
+	if err != nil || limit <= 0 {
 		span.RecordError(err)
-		span.SetAttributes(attribute.String("error", "Invalid request body"))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		badRequest(c, span, "Invalid limit value. Must be a positive integer.", fieldError{"limit", "positive_integer"})
 		return
 	}
-
-	// Validate title and description
-	if ad.Title == "" || ad.Description == "" {
-		span.RecordError(errors.New("title or description cannot be empty"))
-		span.SetAttributes(attribute.String("error", "Title or description missing"))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Title and description are required"})
+	if limit > maxSimilarLimit {
+		limit = maxSimilarLimit
+	}
+	locale, ok := h.requestLocale(c, span)
+	if !ok {
 		return
 	}
 
-	// Validate price (have to be positive)
-	if ad.Price <= 0 {
-		span.RecordError(errors.New("invalid price value"))
-		span.SetAttributes(attribute.String("error", "Price cannot be zero or negative"))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Price cannot be zero or negative"})
+	source, err := h.Service.GetAdByID(id, ctx)
+	if err == nil && !visibleTo(source, middleware.CallerFrom(c)) {
+		err = ErrAdNotFound
+	}
+	if err != nil {
+		span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("error", "Failed to fetch ad by ID"))
+		c.Error(err).SetMeta("Failed to fetch ad by ID")
 		return
 	}
-	err = h.Service.UpdateAd(id, &ad, ctx)
+
+	ads, err := h.Service.GetSimilarAds(source, limit, ctx)
 	if err != nil {
-		if errors.Is(err, ErrAdNotFound) {
-			span.RecordError(err)
-			span.SetAttributes(attribute.Int("ad_id", id), attribute.String("error", "Ad not found"))
-			c.JSON(http.StatusNotFound, gin.H{"error": "Ad not found"})
-			return
-		}
 		span.RecordError(err)
-		span.SetAttributes(attribute.Int("ad_id", id), attribute.String("error", "Failed to update ad"))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update ad"})
+		span.SetAttributes(attribute.String("error", "Failed to fetch similar ads"))
+		internalError(c, err, "Failed to fetch similar ads")
 		return
 	}
+	for i := range ads {
+		ads[i].Localize(locale)
+	}
 
-	span.SetAttributes(attribute.Int("ad_id", id), attribute.String("status", "success"))
-	c.JSON(http.StatusOK, gin.H{"message": "Ad updated"})
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.Int("ads_count", len(ads)), attribute.String("status", "success"))
+	response.Data(c, http.StatusOK, NewAdResponses(ads, h.Service.Rules.Load().ExposeNumericIDs))
 }
 
-// DeleteAd handles deleting an ad by ID, with tracing
-func (h *Handler) DeleteAd(c *gin.Context) {
+// HeadAdByID handles checking whether an ad exists without returning a body, with tracing
+func (h *Handler) HeadAdByID(c *gin.Context) {
 	// Start a span for the handler
 	tracer := otel.Tracer("ad-service.handler")
-	ctx, span := tracer.Start(c.Request.Context(), "DeleteAdHandler")
+	ctx, span := tracer.Start(c.Request.Context(), "HeadAdByIDHandler")
 	defer span.End()
 
-	id, err := strconv.Atoi(c.Param("id"))
-	// Check for non-numeric or non-positive IDs
+	// Parse the ID from the URL parameter, validated exactly like GetAdByID
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil || id <= 0 {
 		span.RecordError(err)
-		span.SetAttributes(attribute.String("error", "Invalid ad ID"))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ad ID"})
+		span.SetAttributes(attribute.String("error", "Invalid ID parameter"))
+		recordValidationFailure(c, span, fieldError{"id", "positive_integer"})
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	// The same lookup and visibility rule as GetAdByID, so HEAD never reveals an ad GET hides
+	ad, err := h.Service.GetAdByID(id, ctx)
+	if middleware.ClientDisconnected(c, err) {
+		return
+	}
+	if errors.Is(err, ErrAdNotFound) || (err == nil && !visibleTo(ad, middleware.CallerFrom(c))) {
+		span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("error", "Ad not found"))
+		c.Status(http.StatusNotFound)
 		return
 	}
-	err = h.Service.DeleteAd(id, ctx)
 	if err != nil {
-		if errors.Is(err, ErrAdNotFound) {
+		span.RecordError(err)
+		span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("error", "Failed to check ad existence"))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("status", "success"))
+	c.Status(http.StatusOK)
+}
+
+// AddAd handles the creation of a new ad, with tracing
+func (h *Handler) AddAd(c *gin.Context) {
+	// Start a span for the handler
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "AddAdHandler")
+	defer span.End()
+
+	var req CreateAdRequest
+	if !bindAdRequest(c, span, &req) {
+		metrics.AdCreateFailures.WithLabelValues("validation").Inc()
+		return
+	}
+	ad := req.ToAd()
+
+	if !checkKeywords(c, span, ad) || !checkLocation(c, span, ad) || !h.checkTranslations(c, span, ad) || !h.checkCampaign(c, span, ad, ctx) {
+		metrics.AdCreateFailures.WithLabelValues("validation").Inc()
+		return
+	}
+
+	// New ads wait for moderation and belong to the caller, whatever the body says
+	caller := middleware.CallerFrom(c)
+	ownModeration(ad, caller)
+
+	if err := h.Service.AddAd(ad, caller.Admin, ctx); err != nil {
+		c.Error(err).SetMeta("Failed to add ad")
+		return
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", ad.ID), attribute.String("status", "success"))
+	response.Data(c, http.StatusCreated, NewAdResponse(ad, h.Service.Rules.Load().ExposeNumericIDs))
+}
+
+// Bounds for the title_contains filter of GET /ads
+const (
+	minTitleContainsLength = 2
+	maxTitleContainsLength = 100
+)
+
+// GetAllAds handles fetching all ads, with tracing
+// Expected URL: http://localhost:8080/ads?page=1&limit=10&sort_by=renewed_at&order=desc
+// or http://localhost:8080/ads?ids=1,2,3 to fetch specific ads
+func (h *Handler) GetAllAds(c *gin.Context) {
+
+	// Start a span for the handler
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "GetAllAdsHandler")
+	defer span.End()
+
+	locale, ok := h.requestLocale(c, span)
+	if !ok {
+		return
+	}
+	conversion, ok := h.requestCurrency(c, span, ctx)
+	if !ok {
+		return
+	}
+
+	// A list of IDs short-circuits pagination and fetches exactly those ads
+	if rawIDs, ok := c.GetQuery("ids"); ok {
+		h.getAdsByIDs(c, rawIDs, locale, conversion, ctx)
+		return
+	}
+
+	// Paginating and sorting
+	page, ok := h.pageParams(c, span)
+	if !ok {
+		return
+	}
+
+	caller := middleware.CallerFrom(c)
+
+	// A radius search is always sorted by distance, which means nothing without one
+	near, ok := geoQuery(c, span)
+	if !ok {
+		return
+	}
+	search, engine, ok := h.searchQuery(c, span)
+	if !ok {
+		return
+	}
+	defaultSort := "renewed_at"
+	if near != nil {
+		defaultSort = "distance"
+	} else if search != "" {
+		defaultSort = "relevance"
+	}
+	sortBy := c.DefaultQuery("sort_by", defaultSort)
+	if near != nil && sortBy != "distance" {
+		badRequest(c, span, "Radius search results are sorted by distance. sort_by must be 'distance' or left out.", fieldError{"sort_by", "distance_only"})
+		return
+	}
+	if near == nil && sortBy == "distance" {
+		badRequest(c, span, "sort_by=distance requires a radius search with lat, lng and radius_km.", fieldError{"sort_by", "requires_radius"})
+		return
+	}
+	if near == nil && sortBy == "relevance" && search == "" {
+		badRequest(c, span, "sort_by=relevance requires a text search with q.", fieldError{"sort_by", "requires_search"})
+		return
+	}
+	// The list query only accepts whitelisted sort fields; sorting by moderation status is for admins
+	if near == nil && sortBy != "relevance" && (!sortable(sortBy) || sortBy == "status" && !caller.Admin) {
+		badRequest(c, span, "Invalid sort_by value. Must be one of 'id', 'title', 'price', 'created_at', 'renewed_at', 'is_active'.", fieldError{"sort_by", "one_of"})
+		return
+	}
+
+	// Renewed ads go to the top, and the most relevant results, so both sort descending unless asked otherwise
+	defaultOrder := "asc"
+	if sortBy == "renewed_at" || sortBy == "relevance" {
+		defaultOrder = "desc"
+	}
+	order := c.DefaultQuery("order", defaultOrder)
+	if order != "asc" && order != "desc" {
+		badRequest(c, span, "Invalid order value. Must be either 'asc' or 'desc'.", fieldError{"order", "one_of"})
+		return
+	}
+	// The public listing only has approved ads. owner=me lists the caller's own ads in any
+	// status, and admins may list any status; both can narrow the result with status=.
+	filter := ListFilter{Status: StatusApproved, Near: near}
+	if c.Query("owner") == "me" {
+		if caller.UserID == "" {
+			span.SetAttributes(attribute.String("error", "Anonymous owner listing"))
+			response.Error(c, http.StatusUnauthorized, "owner=me requires an authenticated user", nil)
+			return
+		}
+		filter = ListFilter{OwnerID: caller.UserID, Near: near}
+	} else if caller.Admin {
+		filter = ListFilter{Near: near}
+	}
+	if status, ok := c.GetQuery("status"); ok {
+		if filter.OwnerID == "" && !caller.Admin {
+			span.SetAttributes(attribute.String("error", "Status filter not allowed"))
+			response.Error(c, http.StatusForbidden, "Filtering by status requires the admin role", nil)
+			return
+		}
+		if _, known := statusTransitions[status]; !known {
+			badRequest(c, span, "Invalid status value. Must be one of 'pending', 'approved', 'rejected'.", fieldError{"status", "one_of"})
+			return
+		}
+		filter.Status = status
+	}
+	if rawCampaignID, ok := c.GetQuery("campaign_id"); ok {
+		campaignID, err := strconv.Atoi(rawCampaignID)
+		if err != nil || campaignID <= 0 {
 			span.RecordError(err)
-			span.SetAttributes(attribute.Int("ad_id", id), attribute.String("error", "Ad not found"))
-			c.JSON(http.StatusNotFound, gin.H{"error": "Ad not found"})
+			badRequest(c, span, "Invalid campaign_id value. Must be a positive integer.", fieldError{"campaign_id", "positive_integer"})
 			return
 		}
-		span.RecordError(err)
-		span.SetAttributes(attribute.Int("ad_id", id), attribute.String("error", "Failed to delete ad"))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete ad"})
+		filter.CampaignID = campaignID
+	}
+	if state, ok := c.GetQuery("state"); ok {
+		if filter.OwnerID == "" && !caller.Admin {
+			span.SetAttributes(attribute.String("error", "State filter not allowed"))
+			response.Error(c, http.StatusForbidden, "Listing archived ads requires owner=me or the admin role", nil)
+			return
+		}
+		if state != "live" && state != "archived" {
+			badRequest(c, span, "Invalid state value. Must be either 'live' or 'archived'.", fieldError{"state", "one_of"})
+			return
+		}
+		filter.Archived = state == "archived"
+	}
+	if titleContains, ok := c.GetQuery("title_contains"); ok {
+		titleContains = strings.TrimSpace(titleContains)
+		if n := utf8.RuneCountInString(titleContains); n < minTitleContainsLength || n > maxTitleContainsLength {
+			badRequest(c, span, "Invalid title_contains value. Must be between "+strconv.Itoa(minTitleContainsLength)+" and "+strconv.Itoa(maxTitleContainsLength)+" characters.", fieldError{"title_contains", "length"})
+			return
+		}
+		filter.TitleContains = titleContains
+	}
+	filter.Search, filter.SearchEngine = search, engine
+
+	// Fetch ads from the service using the validated parameters
+	ads, err := h.Service.GetAllAds(ListQuery{Filter: filter, SortBy: sortBy, Order: order, Page: page.Page, Limit: page.Limit}, ctx)
+	if err != nil {
+		c.Error(err).SetMeta("Failed to fetch ads")
 		return
 	}
+	for i := range ads {
+		ads[i].Localize(locale)
+		conversion.apply(&ads[i])
+	}
+
+	span.SetAttributes(attribute.String("status", "success"))
+	response.List(c, http.StatusOK, NewAdResponses(ads, h.Service.Rules.Load().ExposeNumericIDs), page.Meta(len(ads)))
+}
 
-	span.SetAttributes(attribute.Int("ad_id", id), attribute.String("status", "success"))
-	c.JSON(http.StatusOK, gin.H{"message": "Ad deleted"})
+// searchQuery reads the text search q and the engine answering it, ads.searchEngine unless
+// engine= picks one. Full-text queries the index can't match are answered with LIKE.
+func (h *Handler) searchQuery(c *gin.Context, span trace.Span) (string, string, bool) {
+	search, ok := c.GetQuery("q")
+	if !ok {
+		return "", "", true
+	}
+	search = strings.TrimSpace(search)
+	if n := utf8.RuneCountInString(search); n < MinSearchLength || n > MaxSearchLength {
+		badRequest(c, span, "Invalid q value. Must be between "+strconv.Itoa(MinSearchLength)+" and "+strconv.Itoa(MaxSearchLength)+" characters.", fieldError{"q", "length"})
+		return "", "", false
+	}
+	engine := c.DefaultQuery("engine", h.Service.Rules.Load().SearchEngine)
+	if engine != SearchLike && engine != SearchFullText {
+		badRequest(c, span, "Invalid engine value. Must be either 'like' or 'fulltext'.", fieldError{"engine", "one_of"})
+		return "", "", false
+	}
+	engine = searchEngine(search, engine, h.Service.Rules.Load().SearchMinTokenLength)
+	span.SetAttributes(attribute.String("search.engine", engine))
+	return search, engine, true
+}
+
+// getAdsByIDs serves GET /ads?ids=1,2,3, returning the found ads in the requested order
+func (h *Handler) getAdsByIDs(c *gin.Context, rawIDs, locale string, conversion *priceConversion, ctx context.Context) {
+	span := trace.SpanFromContext(ctx)
+
+	// Parse and validate the IDs, dropping duplicates but keeping the first occurrence order
+	parts := strings.Split(rawIDs, ",")
+	if err := h.Paging.CheckCount("ids", len(parts)); err != nil {
+		span.SetAttributes(attribute.String("error", "Too many IDs"))
+		badRequest(c, span, err.Message, fieldError{err.Field, err.Rule})
+		return
+	}
+	ids := make([]int64, 0, len(parts))
+	seen := make(map[int64]bool, len(parts))
+	for _, part := range parts {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil || id <= 0 {
+			span.RecordError(err)
+			span.SetAttributes(attribute.String("error", "Invalid ids parameter"))
+			badRequest(c, span, "Invalid ids value. Must be a comma-separated list of positive integers.", fieldError{"ids", "positive_integers"})
+			return
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	ads, missing, err := h.Service.GetAdsByIDs(ids, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Failed to fetch ads"))
+		internalError(c, err, "Failed to fetch ads")
+		return
+	}
+
+	// Ads the caller may not see are reported as missing, like IDs that don't exist
+	caller := middleware.CallerFrom(c)
+	visible := make([]Ad, 0, len(ads))
+	for i := range ads {
+		if visibleTo(&ads[i], caller) {
+			ads[i].Localize(locale)
+			conversion.apply(&ads[i])
+			visible = append(visible, ads[i])
+		} else {
+			missing = append(missing, ads[i].ID)
+		}
+	}
+	ads = visible
+
+	span.SetAttributes(attribute.Int("ads_count", len(ads)), attribute.String("status", "success"))
+	// The envelope reports the missing IDs in meta; the legacy shape wraps the ads in an object
+	meta := gin.H{}
+	if len(missing) > 0 {
+		meta["missing"] = missing
+	}
+	if response.IsLegacy(c) {
+		meta["ads"] = NewAdResponses(ads, h.Service.Rules.Load().ExposeNumericIDs)
+		response.Data(c, http.StatusOK, meta)
+		return
+	}
+	response.List(c, http.StatusOK, NewAdResponses(ads, h.Service.Rules.Load().ExposeNumericIDs), meta)
+}
+
+// UpdateAd handles updating an existing ad, with tracing
+func (h *Handler) UpdateAd(c *gin.Context) {
+	// Start a span for the handler
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "UpdateAdHandler")
+	defer span.End()
+
+	// Validate ID
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid ad ID"))
+		badRequest(c, span, "Invalid ad ID", fieldError{"id", "positive_integer"})
+		return
+	}
+
+	var req UpdateAdRequest
+	if !bindAdRequest(c, span, &req) {
+		return
+	}
+	ad := req.ToAd()
+
+	if !checkKeywords(c, span, ad) || !checkLocation(c, span, ad) || !h.checkTranslations(c, span, ad) || !h.checkCampaign(c, span, ad, ctx) {
+		return
+	}
+
+	err = h.Service.UpdateAd(id, ad, ctx)
+	if err != nil {
+		span.SetAttributes(attribute.Int64("ad_id", id))
+		c.Error(err).SetMeta("Failed to update ad")
+		return
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("status", "success"))
+	response.Message(c, http.StatusOK, "Ad updated")
+}
+
+// UpsertAdByRef handles creating or refreshing an ad keyed by its external reference, with tracing
+func (h *Handler) UpsertAdByRef(c *gin.Context) {
+	// Start a span for the handler
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "UpsertAdByRefHandler")
+	defer span.End()
+
+	// Validate the external reference
+	ref := c.Param("ref")
+	if ref == "" || len(ref) > 255 {
+		span.RecordError(errors.New("invalid external reference"))
+		span.SetAttributes(attribute.String("error", "Invalid external reference"))
+		badRequest(c, span, "Invalid external reference", fieldError{"ref", "length"})
+		return
+	}
+
+	var req UpdateAdRequest
+	if !bindAdRequest(c, span, &req) {
+		return
+	}
+	ad := req.ToAd()
+
+	if !checkKeywords(c, span, ad) || !checkLocation(c, span, ad) || !h.checkTranslations(c, span, ad) || !h.checkCampaign(c, span, ad, ctx) {
+		return
+	}
+
+	// The reference in the URL always wins over one in the body
+	ad.ExternalRef = &ref
+	caller := middleware.CallerFrom(c)
+	ownModeration(ad, caller)
+	created, err := h.Service.UpsertAd(ad, caller.Admin, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("external_ref", ref), attribute.String("error", "Failed to upsert ad"))
+		internalError(c, err, "Failed to upsert ad")
+		return
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", ad.ID), attribute.Bool("created", created), attribute.String("status", "success"))
+	if created {
+		response.Data(c, http.StatusCreated, NewAdResponse(ad, h.Service.Rules.Load().ExposeNumericIDs))
+		return
+	}
+	response.Data(c, http.StatusOK, NewAdResponse(ad, h.Service.Rules.Load().ExposeNumericIDs))
+}
+
+// DeleteAd handles deleting an ad by ID, with tracing
+func (h *Handler) DeleteAd(c *gin.Context) {
+	// Start a span for the handler
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "DeleteAdHandler")
+	defer span.End()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	// Check for non-numeric or non-positive IDs
+	if err != nil || id <= 0 {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid ad ID"))
+		badRequest(c, span, "Invalid ad ID", fieldError{"id", "positive_integer"})
+		return
+	}
+	err = h.Service.DeleteAd(id, ctx)
+	if err != nil {
+		span.SetAttributes(attribute.Int64("ad_id", id))
+		c.Error(err).SetMeta("Failed to delete ad")
+		return
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("status", "success"))
+	response.Message(c, http.StatusOK, "Ad deleted")
+}
+
+// RenewAd handles bumping the caller's ad back to the top of the listing, with tracing
+func (h *Handler) RenewAd(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "RenewAdHandler")
+	defer span.End()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	// Check for non-numeric or non-positive IDs
+	if err != nil || id <= 0 {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid ad ID"))
+		badRequest(c, span, "Invalid ad ID", fieldError{"id", "positive_integer"})
+		return
+	}
+
+	caller := middleware.CallerFrom(c)
+	if caller.UserID == "" {
+		span.SetAttributes(attribute.String("error", "Anonymous renewal"))
+		response.Error(c, http.StatusUnauthorized, "Renewing an ad requires an authenticated user", nil)
+		return
+	}
+
+	ad, err := h.Service.RenewAd(id, caller.UserID, ctx)
+	if err != nil {
+		span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("error", "Failed to renew ad"))
+		c.Error(err).SetMeta("Failed to renew ad")
+		return
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("status", "success"))
+	response.Data(c, http.StatusOK, NewAdResponse(ad, h.Service.Rules.Load().ExposeNumericIDs))
+}
+
+// ArchiveAd handles taking the caller's ad off the market without deleting it, with tracing
+func (h *Handler) ArchiveAd(c *gin.Context) {
+	h.setArchived(c, true, "ArchiveAdHandler")
+}
+
+// UnarchiveAd handles putting the caller's archived ad back on the market, with tracing
+func (h *Handler) UnarchiveAd(c *gin.Context) {
+	h.setArchived(c, false, "UnarchiveAdHandler")
+}
+
+// setArchived archives or unarchives the ad in the URL on behalf of its owner
+func (h *Handler) setArchived(c *gin.Context, archive bool, spanName string) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), spanName)
+	defer span.End()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	// Check for non-numeric or non-positive IDs
+	if err != nil || id <= 0 {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid ad ID"))
+		badRequest(c, span, "Invalid ad ID", fieldError{"id", "positive_integer"})
+		return
+	}
+
+	caller := middleware.CallerFrom(c)
+	if caller.UserID == "" {
+		span.SetAttributes(attribute.String("error", "Anonymous archive change"))
+		response.Error(c, http.StatusUnauthorized, "Archiving an ad requires an authenticated user", nil)
+		return
+	}
+
+	ad, err := h.Service.SetArchived(id, archive, caller.UserID, ctx)
+	if err != nil {
+		span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("error", "Failed to update archive state"))
+		c.Error(err).SetMeta("Failed to update archive state")
+		return
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("status", "success"))
+	response.Data(c, http.StatusOK, NewAdResponse(ad, h.Service.Rules.Load().ExposeNumericIDs))
+}
+
+// FavoriteAd handles saving an ad for the caller, with tracing. Saving it again is a no-op.
+func (h *Handler) FavoriteAd(c *gin.Context) {
+	h.setFavorite(c, true, "FavoriteAdHandler")
+}
+
+// UnfavoriteAd handles removing a saved ad of the caller, with tracing. Removing it again is a no-op.
+func (h *Handler) UnfavoriteAd(c *gin.Context) {
+	h.setFavorite(c, false, "UnfavoriteAdHandler")
+}
+
+// setFavorite saves or unsaves the ad in the URL for the caller and responds with the new state
+func (h *Handler) setFavorite(c *gin.Context, favorite bool, spanName string) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), spanName)
+	defer span.End()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	// Check for non-numeric or non-positive IDs
+	if err != nil || id <= 0 {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid ad ID"))
+		badRequest(c, span, "Invalid ad ID", fieldError{"id", "positive_integer"})
+		return
+	}
+
+	caller := middleware.CallerFrom(c)
+	if caller.UserID == "" {
+		span.SetAttributes(attribute.String("error", "Anonymous favorite"))
+		response.Error(c, http.StatusUnauthorized, "Favorites require an authenticated user", nil)
+		return
+	}
+
+	// Only ads the caller can see can be saved; removing always succeeds so stale favorites can go
+	if favorite {
+		ad, err := h.Service.GetAdByID(id, ctx)
+		if err == nil && !visibleTo(ad, caller) {
+			err = ErrAdNotFound
+		}
+		if err != nil {
+			span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("error", "Failed to fetch ad by ID"))
+			c.Error(err).SetMeta("Failed to update favorite")
+			return
+		}
+	}
+
+	if err := h.Service.SetFavorite(caller.UserID, id, favorite, ctx); err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("error", "Failed to update favorite"))
+		internalError(c, err, "Failed to update favorite")
+		return
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("status", "success"))
+	response.Data(c, http.StatusOK, gin.H{"ad_id": id, "favorited": favorite})
+}
+
+// GetFavorites handles listing the caller's saved ads, with tracing
+// Expected URL: http://localhost:8080/users/me/favorites?page=1&limit=10
+func (h *Handler) GetFavorites(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "GetFavoritesHandler")
+	defer span.End()
+
+	caller := middleware.CallerFrom(c)
+	if caller.UserID == "" {
+		span.SetAttributes(attribute.String("error", "Anonymous favorites listing"))
+		response.Error(c, http.StatusUnauthorized, "Favorites require an authenticated user", nil)
+		return
+	}
+
+	page, ok := h.pageParams(c, span)
+	if !ok {
+		return
+	}
+
+	favorites, err := h.Service.GetFavorites(caller.UserID, page.Page, page.Limit, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Failed to fetch favorites"))
+		internalError(c, err, "Failed to fetch favorites")
+		return
+	}
+
+	// Ads taken off the market since they were saved stay listed, marked unavailable
+	for i := range favorites {
+		favorites[i].Available = visibleTo(favorites[i].Ad, caller)
+		if !favorites[i].Available {
+			favorites[i].Ad = nil
+		}
+	}
+
+	span.SetAttributes(attribute.Int("favorites_count", len(favorites)), attribute.String("status", "success"))
+	response.List(c, http.StatusOK, favorites, page.Meta(len(favorites)))
+}
+
+// maxReportCommentLength matches the ad_reports.comment column
+const maxReportCommentLength = 1000
+
+// ReportAd handles filing an abuse report against an ad, with tracing
+// Expected body: {"reason": "fraud", "comment": "Asks for payment upfront"}
+func (h *Handler) ReportAd(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "ReportAdHandler")
+	defer span.End()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	// Check for non-numeric or non-positive IDs
+	if err != nil || id <= 0 {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid ad ID"))
+		badRequest(c, span, "Invalid ad ID", fieldError{"id", "positive_integer"})
+		return
+	}
+
+	caller := middleware.CallerFrom(c)
+	if caller.UserID == "" {
+		span.SetAttributes(attribute.String("error", "Anonymous report"))
+		response.Error(c, http.StatusUnauthorized, "Reporting an ad requires an authenticated user", nil)
+		return
+	}
+
+	var body struct {
+		Reason  string `json:"reason"`
+		Comment string `json:"comment"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid request body"))
+		badRequest(c, span, "Invalid request body", fieldError{"body", "json"})
+		return
+	}
+	if !ReportReasons[body.Reason] {
+		badRequest(c, span, "Invalid reason. Must be one of 'fraud', 'spam', 'prohibited', 'offensive', 'duplicate', 'other'.", fieldError{"reason", "one_of"})
+		return
+	}
+	if utf8.RuneCountInString(body.Comment) > maxReportCommentLength {
+		badRequest(c, span, "Comment must be at most "+strconv.Itoa(maxReportCommentLength)+" characters", fieldError{"comment", "max_length"})
+		return
+	}
+
+	// Only ads the caller can see can be reported
+	ad, err := h.Service.GetAdByID(id, ctx)
+	if err == nil && !visibleTo(ad, caller) {
+		err = ErrAdNotFound
+	}
+	if err != nil {
+		span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("error", "Failed to fetch ad by ID"))
+		c.Error(err).SetMeta("Failed to report ad")
+		return
+	}
+
+	report := Report{AdID: id, ReporterID: caller.UserID, Reason: body.Reason}
+	if comment := strings.TrimSpace(body.Comment); comment != "" {
+		report.Comment = &comment
+	}
+	created, window, err := h.Service.ReportAd(&report, ctx)
+	now := time.Now()
+	if window.Limit > 0 {
+		window.SetHeaders(c.Writer.Header(), now)
+	}
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, ErrReportRateLimited) {
+			span.SetAttributes(attribute.String("error", "Report rate limit reached"))
+			c.Header("Retry-After", strconv.Itoa(window.RetryAfter(now)))
+			response.Error(c, http.StatusTooManyRequests, "Too many reports, try again later", nil)
+			return
+		}
+		span.SetAttributes(attribute.String("error", "Failed to report ad"))
+		internalError(c, err, "Failed to report ad")
+		return
+	}
+
+	// A repeated report is accepted but not stored again
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.Bool("created", created), attribute.String("status", "success"))
+	if created {
+		response.Data(c, http.StatusCreated, gin.H{"ad_id": id, "reported": true})
+		return
+	}
+	response.Data(c, http.StatusOK, gin.H{"ad_id": id, "reported": true})
+}
+
+// GetReports handles listing abuse reports for admins, with tracing
+// Expected URL: http://localhost:8080/admin/reports?status=open&page=1&limit=10
+func (h *Handler) GetReports(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "GetReportsHandler")
+	defer span.End()
+
+	page, ok := h.pageParams(c, span)
+	if !ok {
+		return
+	}
+
+	status := c.Query("status")
+	if status != "" && status != ReportOpen && status != ReportDismissed && status != ReportTakenDown {
+		badRequest(c, span, "Invalid status value. Must be one of 'open', 'dismissed', 'taken_down'.", fieldError{"status", "one_of"})
+		return
+	}
+
+	reports, err := h.Service.GetReports(status, page.Page, page.Limit, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Failed to fetch reports"))
+		internalError(c, err, "Failed to fetch reports")
+		return
+	}
+
+	span.SetAttributes(attribute.Int("reports_count", len(reports)), attribute.String("status", "success"))
+	response.List(c, http.StatusOK, reports, page.Meta(len(reports)))
+}
+
+// DismissReport handles closing a report without action, with tracing
+func (h *Handler) DismissReport(c *gin.Context) {
+	h.resolveReport(c, ReportDismissed, "DismissReportHandler")
+}
+
+// TakeDownReport handles deactivating a reported ad, with tracing
+func (h *Handler) TakeDownReport(c *gin.Context) {
+	h.resolveReport(c, ReportTakenDown, "TakeDownReportHandler")
+}
+
+// resolveReport closes the report in the URL with the given resolution on behalf of the admin caller
+func (h *Handler) resolveReport(c *gin.Context, resolution, spanName string) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), spanName)
+	defer span.End()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid report ID"))
+		badRequest(c, span, "Invalid report ID", fieldError{"id", "positive_integer"})
+		return
+	}
+
+	report, err := h.Service.ResolveReport(id, resolution, middleware.CallerFrom(c).UserID, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Failed to resolve report"))
+		c.Error(err).SetMeta("Failed to resolve report")
+		return
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", report.AdID), attribute.String("status", "success"))
+	response.Data(c, http.StatusOK, report)
+}
+
+// maxStatusReasonLength matches the status_reason column
+const maxStatusReasonLength = 500
+
+// ApproveAd handles making an ad public, with tracing
+func (h *Handler) ApproveAd(c *gin.Context) {
+	h.setStatus(c, StatusApproved, "ApproveAdHandler")
+}
+
+// RejectAd handles rejecting an ad with an optional reason, with tracing
+// Expected body (optional): {"reason": "Prohibited item"}
+func (h *Handler) RejectAd(c *gin.Context) {
+	h.setStatus(c, StatusRejected, "RejectAdHandler")
+}
+
+// setStatus moves the ad in the URL to a moderation status on behalf of the admin caller
+func (h *Handler) setStatus(c *gin.Context, status, spanName string) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), spanName)
+	defer span.End()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	// Check for non-numeric or non-positive IDs
+	if err != nil || id <= 0 {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid ad ID"))
+		badRequest(c, span, "Invalid ad ID", fieldError{"id", "positive_integer"})
+		return
+	}
+
+	// The body is optional; an empty reason is stored as none
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil && !errors.Is(err, io.EOF) {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid request body"))
+		badRequest(c, span, "Invalid request body", fieldError{"body", "json"})
+		return
+	}
+	if utf8.RuneCountInString(body.Reason) > maxStatusReasonLength {
+		span.SetAttributes(attribute.String("error", "Reason too long"))
+		badRequest(c, span, "Reason must be at most "+strconv.Itoa(maxStatusReasonLength)+" characters", fieldError{"reason", "max_length"})
+		return
+	}
+	var reason *string
+	if body.Reason = strings.TrimSpace(body.Reason); body.Reason != "" {
+		reason = &body.Reason
+	}
+
+	ad, err := h.Service.SetStatus(id, status, reason, middleware.CallerFrom(c).UserID, ctx)
+	if err != nil {
+		span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("error", "Failed to update status"))
+		c.Error(err).SetMeta("Failed to update ad status")
+		return
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("status", "success"))
+	response.Data(c, http.StatusOK, NewAdResponse(ad, h.Service.Rules.Load().ExposeNumericIDs))
+}
+
+// GetVariants handles listing the creative variants of an ad, with tracing
+func (h *Handler) GetVariants(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "GetVariantsHandler")
+	defer span.End()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid ad ID"))
+		badRequest(c, span, "Invalid ad ID", fieldError{"id", "positive_integer"})
+		return
+	}
+
+	variants, err := h.Service.GetVariants(id, ctx)
+	if err != nil {
+		variantError(c, span, err, "Failed to fetch variants")
+		return
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.Int("variants_count", len(variants)), attribute.String("status", "success"))
+	response.Data(c, http.StatusOK, variants)
+}
+
+// AddVariant handles adding a creative variant to an ad, with tracing
+// Expected body: {"key": "b", "title": "Mountain bike, barely used", "description": "...", "weight": 1}
+func (h *Handler) AddVariant(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "AddVariantHandler")
+	defer span.End()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid ad ID"))
+		badRequest(c, span, "Invalid ad ID", fieldError{"id", "positive_integer"})
+		return
+	}
+
+	var variant Variant
+	if !bindVariant(c, span, &variant) {
+		return
+	}
+	if !variantKeyPattern.MatchString(variant.Key) {
+		badRequest(c, span, "Invalid key value. Must be 1 to 50 lowercase letters, digits, '-' or '_'.", fieldError{"key", "pattern"})
+		return
+	}
+	variant.AdID = id
+
+	if err := h.Service.AddVariant(&variant, ctx); err != nil {
+		variantError(c, span, err, "Failed to add variant")
+		return
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("variant", variant.Key), attribute.String("status", "success"))
+	response.Data(c, http.StatusCreated, variant)
+}
+
+// UpdateVariant handles replacing the title, description and weight of a variant, with tracing
+func (h *Handler) UpdateVariant(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "UpdateVariantHandler")
+	defer span.End()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid ad ID"))
+		badRequest(c, span, "Invalid ad ID", fieldError{"id", "positive_integer"})
+		return
+	}
+
+	var variant Variant
+	if !bindVariant(c, span, &variant) {
+		return
+	}
+	// The key in the URL always wins over one in the body
+	variant.AdID, variant.Key = id, c.Param("key")
+
+	if err := h.Service.UpdateVariant(&variant, ctx); err != nil {
+		variantError(c, span, err, "Failed to update variant")
+		return
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("variant", variant.Key), attribute.String("status", "success"))
+	response.Data(c, http.StatusOK, variant)
+}
+
+// DeleteVariant handles removing a variant from an ad, with tracing
+func (h *Handler) DeleteVariant(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "DeleteVariantHandler")
+	defer span.End()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid ad ID"))
+		badRequest(c, span, "Invalid ad ID", fieldError{"id", "positive_integer"})
+		return
+	}
+
+	key := c.Param("key")
+	if err := h.Service.DeleteVariant(id, key, ctx); err != nil {
+		variantError(c, span, err, "Failed to delete variant")
+		return
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("variant", key), attribute.String("status", "success"))
+	response.Message(c, http.StatusOK, "Variant deleted")
+}
+
+// ClickAd handles recording a click on a served ad, with tracing. The variant named in the
+// serve response is passed back so the click is attributed to it.
+// Expected URL: http://localhost:8080/ads/3/click?variant=b
+func (h *Handler) ClickAd(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "ClickAdHandler")
+	defer span.End()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid ad ID"))
+		badRequest(c, span, "Invalid ad ID", fieldError{"id", "positive_integer"})
+		return
+	}
+
+	var variantKey *string
+	if key := c.Query("variant"); key != "" {
+		variantKey = &key
+	}
+	if err := h.Service.RecordClick(id, variantKey, ctx); err != nil {
+		variantError(c, span, err, "Failed to record click")
+		return
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("status", "success"))
+	c.Status(http.StatusNoContent)
+}
+
+// GetAdStats handles the impressions, clicks and click-through rate of an ad broken down by
+// variant, with tracing
+func (h *Handler) GetAdStats(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "GetAdStatsHandler")
+	defer span.End()
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid ad ID"))
+		badRequest(c, span, "Invalid ad ID", fieldError{"id", "positive_integer"})
+		return
+	}
+
+	stats, err := h.Service.GetAdStats(id, ctx)
+	if err != nil {
+		variantError(c, span, err, "Failed to fetch ad stats")
+		return
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("status", "success"))
+	response.Data(c, http.StatusOK, stats)
+}
+
+// variantKeyPattern restricts variant keys to short URL-safe names
+var variantKeyPattern = regexp.MustCompile(`^[a-z0-9_-]{1,50}$`)
+
+// bindVariant reads and validates a variant body, responding with a 400 and returning false
+// when it is invalid. The weight defaults to 1.
+func bindVariant(c *gin.Context, span trace.Span, variant *Variant) bool {
+	if err := c.ShouldBindJSON(variant); err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid request body"))
+		badRequest(c, span, "Invalid request body", fieldError{"body", "json"})
+		return false
+	}
+	if variant.Title == "" || variant.Description == "" {
+		span.SetAttributes(attribute.String("error", "Title or description missing"))
+		badRequest(c, span, "Title and description are required", fieldError{"title", "required"}, fieldError{"description", "required"})
+		return false
+	}
+	if variant.Weight == 0 {
+		variant.Weight = DefaultAdWeight
+	}
+	if variant.Weight < 0 || variant.Weight > MaxAdWeight {
+		span.SetAttributes(attribute.String("error", "Weight out of range"))
+		badRequest(c, span, "Weight must be between 1 and "+strconv.Itoa(MaxAdWeight), fieldError{"weight", "range"})
+		return false
+	}
+	return true
+}
+
+// variantError hands the errors of the variant, click and stats endpoints to the error
+// middleware, message being the 500 message
+func variantError(c *gin.Context, span trace.Span, err error, message string) {
+	span.SetAttributes(attribute.String("error", message))
+	c.Error(err).SetMeta(message)
+}
+
+// maxPrefixLength caps the key prefix accepted by POST /admin/cache/purge
+const maxPrefixLength = 200
+
+// PurgeCache handles deleting cache entries by ad IDs, key prefix or all of them, with tracing.
+// Admins only; keys outside the service's namespace are never touched.
+func (h *Handler) PurgeCache(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "PurgeCacheHandler")
+	defer span.End()
+
+	var req PurgeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		span.RecordError(err)
+		badRequest(c, span, "Invalid request body", fieldError{"body", "json"})
+		return
+	}
+	req.Prefix = strings.TrimSpace(req.Prefix)
+	switch req.Mode() {
+	case "":
+		badRequest(c, span, "Exactly one of ids, prefix or all must be given", fieldError{"body", "one_of"})
+		return
+	case PurgeModeIDs:
+		if len(req.IDs) == 0 {
+			badRequest(c, span, "Invalid ids value. Must list at least one ID.", fieldError{"ids", "count"})
+			return
+		}
+		if err := h.Paging.CheckCount("ids", len(req.IDs)); err != nil {
+			badRequest(c, span, err.Message, fieldError{err.Field, err.Rule})
+			return
+		}
+		for _, id := range req.IDs {
+			if id <= 0 {
+				badRequest(c, span, "Invalid ids value. Must be positive integers.", fieldError{"ids", "positive_integers"})
+				return
+			}
+		}
+	case PurgeModePrefix:
+		if len(req.Prefix) > maxPrefixLength {
+			badRequest(c, span, "Invalid prefix value. Must be at most "+strconv.Itoa(maxPrefixLength)+" characters.", fieldError{"prefix", "max_length"})
+			return
+		}
+	}
+
+	caller := middleware.CallerFrom(c)
+	result, err := h.Service.PurgeCache(req, caller.UserID, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Failed to purge cache"))
+		internalError(c, err, "Failed to purge cache")
+		return
+	}
+
+	span.SetAttributes(attribute.String("mode", result.Mode), attribute.Int("deleted", result.Deleted), attribute.String("status", "success"))
+	response.Data(c, http.StatusOK, result)
+}
+
+// requestLocale picks the locale of the response: ?locale= when given, which must be one of the
+// configured locales (400 otherwise), else the first configured language in Accept-Language by
+// preference. An empty locale keeps the default fields.
+func (h *Handler) requestLocale(c *gin.Context, span trace.Span) (string, bool) {
+	if raw, ok := c.GetQuery("locale"); ok {
+		locale := strings.ToLower(strings.TrimSpace(raw))
+		if !h.supportsLocale(locale) {
+			badRequest(c, span, "Unsupported locale. Must be one of: "+strings.Join(h.Service.Rules.Load().Locales, ", ")+".", fieldError{"locale", "one_of"})
+			return "", false
+		}
+		span.SetAttributes(attribute.String("locale", locale))
+		return locale, true
+	}
+
+	best, bestQuality := "", 0.0
+	for _, part := range strings.Split(c.GetHeader("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				quality = parsed
+			}
+		}
+		// A region the config doesn't list falls back to its language, en-US to en
+		tag = strings.ToLower(tag)
+		if !h.supportsLocale(tag) {
+			tag, _, _ = strings.Cut(tag, "-")
+		}
+		if quality > bestQuality && h.supportsLocale(tag) {
+			best, bestQuality = tag, quality
+		}
+	}
+	if best != "" {
+		span.SetAttributes(attribute.String("locale", best))
+	}
+	return best, true
+}
+
+// priceConversion is the display currency requested with ?currency= and its rate from the base
+// currency; a zero rate means no fresh rate was available
+type priceConversion struct {
+	base     string
+	currency string
+	rate     float64
+}
+
+// apply adds the display price to an ad, leaving Price in the base currency. A nil conversion
+// leaves the ad untouched.
+func (p *priceConversion) apply(ad *Ad) {
+	if p == nil {
+		return
+	}
+	ad.Currency, ad.DisplayCurrency = p.base, p.currency
+	if p.rate == 0 {
+		ad.ConversionUnavailable = true
+		return
+	}
+	price := ad.Price.Mul(p.rate)
+	ad.DisplayPrice = &price
+}
+
+// requestCurrency reads ?currency=, which must be a supported currency (400 otherwise), and looks
+// up its rate once for the whole response. Without the parameter it returns a nil conversion.
+func (h *Handler) requestCurrency(c *gin.Context, span trace.Span, ctx context.Context) (*priceConversion, bool) {
+	raw, ok := c.GetQuery("currency")
+	if !ok {
+		return nil, true
+	}
+	code := strings.ToUpper(strings.TrimSpace(raw))
+	if !h.Currency.Supports(code) {
+		message := "Unsupported currency."
+		if h.Currency != nil {
+			message = "Unsupported currency. Must be one of: " + strings.Join(h.Currency.Supported(), ", ") + "."
+		}
+		badRequest(c, span, message, fieldError{"currency", "one_of"})
+		return nil, false
+	}
+
+	// A stale or missing rate degrades to the stored price rather than failing the request
+	rate, fresh := h.Currency.Rate(code, ctx)
+	span.SetAttributes(attribute.String("currency", code), attribute.Bool("conversion_available", fresh))
+	return &priceConversion{base: h.Currency.Base, currency: code, rate: rate}, true
+}
+
+// supportsLocale reports whether locale is one of the configured locales
+func (h *Handler) supportsLocale(locale string) bool {
+	for _, supported := range h.Service.Rules.Load().Locales {
+		if locale == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTranslations responds with a 400 and returns false when a translation of a submitted ad
+// uses a locale that isn't configured or lacks its title or description
+func (h *Handler) checkTranslations(c *gin.Context, span trace.Span, ad *Ad) bool {
+	for locale, translation := range ad.Translations {
+		if !h.supportsLocale(locale) {
+			badRequest(c, span, "Unsupported translation locale "+strconv.Quote(locale)+". Must be one of: "+strings.Join(h.Service.Rules.Load().Locales, ", ")+".", fieldError{"translations", "locale"})
+			return false
+		}
+		if translation.Title == "" || translation.Description == "" {
+			badRequest(c, span, "Translations need a title and a description", fieldError{"translations", "required"})
+			return false
+		}
+	}
+	return true
+}
+
+// geoQuery reads the radius search parameters lat, lng and radius_km, which come together. It
+// responds with a 400 and returns false when they are incomplete or out of range, and returns a
+// nil filter when none is given.
+func geoQuery(c *gin.Context, span trace.Span) (*GeoFilter, bool) {
+	rawLat, hasLat := c.GetQuery("lat")
+	rawLng, hasLng := c.GetQuery("lng")
+	rawRadius, hasRadius := c.GetQuery("radius_km")
+	if !hasLat && !hasLng && !hasRadius {
+		return nil, true
+	}
+	if !hasLat || !hasLng || !hasRadius {
+		badRequest(c, span, "Radius search requires lat, lng and radius_km together.", fieldError{"lat", "required"}, fieldError{"lng", "required"}, fieldError{"radius_km", "required"})
+		return nil, false
+	}
+
+	lat, err := strconv.ParseFloat(rawLat, 64)
+	if err != nil || !inRange(lat, 90) {
+		badRequest(c, span, "Invalid lat value. Must be a number between -90 and 90.", fieldError{"lat", "range"})
+		return nil, false
+	}
+	lng, err := strconv.ParseFloat(rawLng, 64)
+	if err != nil || !inRange(lng, 180) {
+		badRequest(c, span, "Invalid lng value. Must be a number between -180 and 180.", fieldError{"lng", "range"})
+		return nil, false
+	}
+	radius, err := strconv.ParseFloat(rawRadius, 64)
+	if err != nil || !(radius > 0 && radius <= MaxRadiusKm) {
+		badRequest(c, span, "Invalid radius_km value. Must be greater than 0 and at most "+strconv.FormatFloat(MaxRadiusKm, 'f', -1, 64)+".", fieldError{"radius_km", "range"})
+		return nil, false
+	}
+
+	span.SetAttributes(attribute.Float64("lat", lat), attribute.Float64("lng", lng), attribute.Float64("radius_km", radius))
+	return &GeoFilter{Latitude: lat, Longitude: lng, RadiusKm: radius}, true
+}
+
+// checkLocation responds with a 400 and returns false when a submitted ad has only one of
+// latitude and longitude, or one out of range
+func checkLocation(c *gin.Context, span trace.Span, ad *Ad) bool {
+	if err := ValidLocation(ad.Latitude, ad.Longitude); err != nil {
+		span.RecordError(err)
+		badRequest(c, span, "Invalid location: "+err.Error(), fieldError{"latitude", "range"}, fieldError{"longitude", "range"})
+		return false
+	}
+	return true
+}
+
+// checkKeywords normalizes the targeting keywords of a submitted ad in place, responding with a
+// 400 and returning false when there are too many or one is too long
+func checkKeywords(c *gin.Context, span trace.Span, ad *Ad) bool {
+	keywords, err := NormalizeKeywords(ad.Keywords)
+	if err != nil {
+		span.RecordError(err)
+		badRequest(c, span, "Invalid keywords value: "+err.Error(), fieldError{"keywords", "keywords"})
+		return false
+	}
+	ad.Keywords = keywords
+	return true
+}
+
+// checkCampaign responds with a 400 and returns false when the ad references a campaign that
+// doesn't exist
+func (h *Handler) checkCampaign(c *gin.Context, span trace.Span, ad *Ad, ctx context.Context) bool {
+	if ad.CampaignID == nil {
+		return true
+	}
+	if *ad.CampaignID <= 0 {
+		badRequest(c, span, "Invalid campaign_id value. Must be a positive integer.", fieldError{"campaign_id", "positive_integer"})
+		return false
+	}
+	exists, err := h.Service.CampaignExists(*ad.CampaignID, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Failed to check campaign"))
+		internalError(c, err, "Failed to check campaign")
+		return false
+	}
+	if !exists {
+		span.SetAttributes(attribute.Int("campaign_id", *ad.CampaignID), attribute.String("error", "Unknown campaign"))
+		badRequest(c, span, "Campaign "+strconv.Itoa(*ad.CampaignID)+" does not exist", fieldError{"campaign_id", "exists"})
+		return false
+	}
+	return true
+}
+
+// ownModeration resets the moderation fields of a submitted ad: it starts pending and is owned by
+// the caller
+func ownModeration(ad *Ad, caller middleware.Caller) {
+	ad.Status = StatusPending
+	ad.StatusReason = nil
+	ad.OwnerID = nil
+	if caller.UserID != "" {
+		ad.OwnerID = &caller.UserID
+	}
+}
+
+// visibleTo reports whether the caller may see the ad: approved, unarchived ads are public,
+// others are only shown to their owner and to admins
+func visibleTo(ad *Ad, caller middleware.Caller) bool {
+	if (ad.Status == StatusApproved && ad.ArchivedAt == nil) || caller.Admin {
+		return true
+	}
+	return caller.UserID != "" && ad.OwnerID != nil && *ad.OwnerID == caller.UserID
+}
+
+// internalError hands an unexpected error to the error middleware, which responds with a 500
+// and, when the request is traced, the trace ID so a customer report can be matched to its
+// trace. Errors from an unavailable dependency or a timeout still get their 503 or 504.
+func internalError(c *gin.Context, err error, message string) {
+	c.Error(err).SetMeta(message)
+}
+
+// fieldError names a request field and the validation rule it broke
+type fieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+}
+
+// badRequest responds with a 400 listing the failed fields and records the failure on the span
+// and in the validation metric
+func badRequest(c *gin.Context, span trace.Span, message string, failures ...fieldError) {
+	recordValidationFailure(c, span, failures...)
+	response.ErrorCode(c, http.StatusBadRequest, response.CodeValidation, message, gin.H{"fields": failures})
+}
+
+// pageParams parses the page and limit parameters under the page size policy, answering 400
+// when they are invalid. A clamped limit is recorded on the span.
+func (h *Handler) pageParams(c *gin.Context, span trace.Span) (pagination.Page, bool) {
+	page, err := h.Paging.Parse(c)
+	if err != nil {
+		badRequest(c, span, err.Message, fieldError{err.Field, err.Rule})
+		return page, false
+	}
+	if page.Clamped() {
+		span.SetAttributes(attribute.Int("requested_limit", page.Requested), attribute.Int("limit", page.Limit))
+	}
+	return page, true
+}
+
+// recordValidationFailure adds a "validation_failed" span event and counts each failed field.
+// Only field names and rule codes are recorded, never the submitted values.
+func recordValidationFailure(c *gin.Context, span trace.Span, failures ...fieldError) {
+	fields := make([]string, len(failures))
+	rules := make([]string, len(failures))
+	for i, failure := range failures {
+		fields[i] = failure.Field
+		rules[i] = failure.Rule
+		metrics.ValidationFailures.WithLabelValues(c.FullPath(), failure.Field).Inc()
+	}
+	span.AddEvent("validation_failed", trace.WithAttributes(
+		attribute.StringSlice("validation.fields", fields),
+		attribute.StringSlice("validation.rules", rules),
+	))
 }
@@ -0,0 +1,891 @@
+package ad
+
+import (
+	"ad_service/internal/config"
+	"ad_service/internal/currency"
+	"ad_service/pkg/breaker"
+	"ad_service/pkg/cache"
+	"ad_service/pkg/metrics"
+	"ad_service/pkg/middleware"
+	"ad_service/pkg/pagination"
+	"ad_service/pkg/tracing"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestHeadAdByIDMatchesGet(t *testing.T) {
+	archivedAt := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	approved := testAd(1, "Approved")
+	pending := testAd(2, "Pending")
+	pending.Status, pending.OwnerID = StatusPending, strPtr("owner")
+	rejected := testAd(3, "Rejected")
+	rejected.Status, rejected.OwnerID = StatusRejected, strPtr("owner")
+	archived := testAd(4, "Archived")
+	archived.ArchivedAt, archived.OwnerID = &archivedAt, strPtr("owner")
+
+	tests := []struct {
+		name    string
+		id      string
+		headers []string
+		want    int
+	}{
+		{"approved", "1", nil, http.StatusOK},
+		{"pending anonymous", "2", nil, http.StatusNotFound},
+		{"rejected anonymous", "3", nil, http.StatusNotFound},
+		{"archived anonymous", "4", nil, http.StatusNotFound},
+		{"archived other user", "4", []string{testUserHeader, "someone"}, http.StatusNotFound},
+		{"pending owner", "2", []string{testUserHeader, "owner"}, http.StatusOK},
+		{"archived owner", "4", []string{testUserHeader, "owner"}, http.StatusOK},
+		{"rejected admin", "3", []string{testUserHeader, "admin-user", testRoleHeader, "admin"}, http.StatusOK},
+		{"missing", "5", nil, http.StatusNotFound},
+		{"invalid", "abc", nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mock := newTestService(t)
+			for _, ad := range []Ad{approved, pending, rejected, archived} {
+				cacheAd(t, service, ad)
+			}
+			if tt.id == "5" {
+				mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(5), "default").WillReturnRows(adRows())
+			}
+			if tt.want == http.StatusOK && len(tt.headers) > 0 {
+				// GET marks whether an identified caller favorited the ad
+				mock.ExpectQuery("FROM favorites").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			}
+			h := &Handler{Service: service}
+			r := newTestRouter(func(r gin.IRoutes) {
+				r.HEAD("/ads/:id", h.HeadAdByID)
+				r.GET("/ads/:id", h.GetAdByID)
+			})
+
+			head := serve(r, http.MethodHead, "/ads/"+tt.id, nil, tt.headers...)
+			if head.Code != tt.want {
+				t.Errorf("HEAD status = %d, want %d", head.Code, tt.want)
+			}
+			if head.Body.Len() != 0 {
+				t.Errorf("HEAD returned a body: %q", head.Body.String())
+			}
+			// The negative cache entry of the missing ad answers GET without another query
+			get := serve(r, http.MethodGet, "/ads/"+tt.id, nil, tt.headers...)
+			if get.Code != head.Code {
+				t.Errorf("GET status = %d, HEAD status = %d", get.Code, head.Code)
+			}
+		})
+	}
+}
+
+func TestOwnerActionErrors(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	live := testAd(1, "Live")
+	live.OwnerID = strPtr("owner")
+	archived := live
+	archived.ArchivedAt = &now
+	renewed := live
+	renewed.RenewalCount, renewed.RenewalWindowStart = 3, &now
+
+	tests := []struct {
+		name    string
+		path    string
+		ad      *Ad // the row GetAdByID finds, none when nil
+		user    string
+		want    int
+		message string
+	}{
+		{"renew anonymous", "/ads/1/renew", nil, "", http.StatusUnauthorized, "Renewing an ad requires an authenticated user"},
+		{"renew missing", "/ads/1/renew", nil, "owner", http.StatusNotFound, "Ad not found"},
+		{"renew other user", "/ads/1/renew", &live, "someone", http.StatusForbidden, "Ad belongs to another user"},
+		{"renew archived", "/ads/1/renew", &archived, "owner", http.StatusConflict, "Ad is archived"},
+		{"renew over limit", "/ads/1/renew", &renewed, "owner", http.StatusTooManyRequests, "Renewal limit reached"},
+		{"archive missing", "/ads/1/archive", nil, "owner", http.StatusNotFound, "Ad not found"},
+		{"archive other user", "/ads/1/archive", &live, "someone", http.StatusForbidden, "Ad belongs to another user"},
+		{"archive archived", "/ads/1/archive", &archived, "owner", http.StatusConflict, "Ad is already archived"},
+		{"unarchive live", "/ads/1/unarchive", &live, "owner", http.StatusConflict, "Ad is not archived"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mock := newTestService(t)
+			if tt.user != "" {
+				rows := adRows()
+				if tt.ad != nil {
+					rows = adRows(*tt.ad)
+				}
+				mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(1), "default").WillReturnRows(rows)
+				if tt.ad != nil {
+					expectNoTranslations(mock)
+				}
+			}
+			h := &Handler{Service: service}
+			r := newTestRouter(func(r gin.IRoutes) {
+				r.POST("/ads/:id/renew", h.RenewAd)
+				r.POST("/ads/:id/archive", h.ArchiveAd)
+				r.POST("/ads/:id/unarchive", h.UnarchiveAd)
+			})
+
+			w := serve(r, http.MethodPost, tt.path, nil, testUserHeader, tt.user)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if message := errorMessage(t, w.Body.Bytes()); message != tt.message {
+				t.Errorf("message = %q, want %q", message, tt.message)
+			}
+			if tt.want == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+				t.Error("the renewal limit has no Retry-After")
+			}
+		})
+	}
+}
+
+func TestRandomAndSimilarNotFound(t *testing.T) {
+	service, mock := newTestService(t)
+	pending := testAd(2, "Pending")
+	pending.Status = StatusPending
+	cacheAd(t, service, pending)
+	mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) {
+		r.GET("/ads/random", h.GetRandomAd)
+		r.GET("/ads/:id/similar", h.GetSimilarAds)
+	})
+
+	w := serve(r, http.MethodGet, "/ads/random", nil)
+	if message := errorMessage(t, w.Body.Bytes()); w.Code != http.StatusNotFound || message != "No ads found" {
+		t.Errorf("random without ads = %d %q, want 404 No ads found", w.Code, message)
+	}
+	// An ad the caller can't see has no similar ads to show
+	w = serve(r, http.MethodGet, "/ads/2/similar", nil)
+	if message := errorMessage(t, w.Body.Bytes()); w.Code != http.StatusNotFound || message != "Ad not found" {
+		t.Errorf("similar of a pending ad = %d %q, want 404 Ad not found", w.Code, message)
+	}
+}
+
+func TestInvalidStatusTransition(t *testing.T) {
+	service, mock := newTestService(t)
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(1), "default").WillReturnRows(adRows(testAd(1, "Approved")))
+	expectNoTranslations(mock)
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) {
+		r.POST("/ads/:id/approve", h.ApproveAd)
+	})
+
+	w := serve(r, http.MethodPost, "/ads/1/approve", nil, testUserHeader, "admin-user", testRoleHeader, "admin")
+	if message := errorMessage(t, w.Body.Bytes()); w.Code != http.StatusConflict || message != "Cannot move ad from approved to approved" {
+		t.Errorf("approving an approved ad = %d %q, want 409 Cannot move ad from approved to approved", w.Code, message)
+	}
+}
+
+// errorMessage returns the message of an error response
+func errorMessage(t *testing.T, body []byte) string {
+	t.Helper()
+	var decoded struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("decode %q: %v", body, err)
+	}
+	return decoded.Error.Message
+}
+
+func TestUpsertAdByRef(t *testing.T) {
+	tests := []struct {
+		name         string
+		rowsAffected int64 // as MySQL reports ON DUPLICATE KEY UPDATE
+		want         int
+	}{
+		{"created", 1, http.StatusCreated},
+		{"updated", 2, http.StatusOK},
+		{"unchanged", 0, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mock := newTestService(t)
+			stale := testAd(7, "Old title")
+			stale.ExternalRef = strPtr("feed-7")
+			cacheAd(t, service, stale)
+			stored := stale
+			stored.Title = "New title"
+
+			mock.ExpectBegin()
+			mock.ExpectExec("INSERT INTO ads .* ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID\\(id\\)").WillReturnResult(sqlmock.NewResult(7, tt.rowsAffected))
+			if tt.want == http.StatusCreated {
+				mock.ExpectExec("UPDATE ads SET slug").WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7)).WillReturnRows(adRows(stored))
+			expectNoTranslations(mock)
+			mock.ExpectCommit()
+			h := &Handler{Service: service}
+			r := newTestRouter(func(r gin.IRoutes) {
+				r.PUT("/ads/by-ref/:ref", h.UpsertAdByRef)
+				r.GET("/ads/:id", h.GetAdByID)
+			})
+
+			body := `{"title": "New title", "description": "Description of New title", "price": 10}`
+			w := serve(r, http.MethodPut, "/ads/by-ref/feed-7", strings.NewReader(body))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			// The cached copy was replaced, so GET sees the upsert without a query
+			get := serve(r, http.MethodGet, "/ads/7", nil)
+			if get.Code != http.StatusOK || !strings.Contains(get.Body.String(), `"title":"New title"`) {
+				t.Errorf("GET after the upsert = %d %s, want the new title", get.Code, get.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetDailyStats(t *testing.T) {
+	service, mock := newTestService(t)
+	// The driver returns DATE() as midnight in the connection's time zone, not necessarily UTC
+	moscow := time.FixedZone("MSK", 3*60*60)
+	mock.ExpectQuery(`SELECT DATE\(created_at\) AS day, COUNT\(\*\) FROM ads WHERE tenant_id = \? AND created_at >= \? AND created_at < \? GROUP BY day`).
+		WithArgs("default", time.Date(2024, 1, 30, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC)).
+		WillReturnRows(sqlmock.NewRows([]string{"day", "count"}).
+			AddRow(time.Date(2024, 1, 31, 0, 0, 0, 0, moscow), 2).
+			AddRow(time.Date(2024, 2, 1, 0, 0, 0, 0, moscow), 1))
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) {
+		r.GET("/ads/stats/daily", h.GetDailyStats)
+	})
+
+	want := `{"data":[{"date":"2024-01-30","count":0},{"date":"2024-01-31","count":2},{"date":"2024-02-01","count":1},{"date":"2024-02-02","count":0}],"meta":{}}`
+	// The second request is answered from the cache
+	for i := 0; i < 2; i++ {
+		w := serve(r, http.MethodGet, "/ads/stats/daily?from=2024-01-30&to=2024-02-02", nil)
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("request %d = %d %s, want %s", i+1, w.Code, w.Body.String(), want)
+		}
+	}
+}
+
+func TestGetDailyStatsRange(t *testing.T) {
+	tests := []struct {
+		query string
+		want  int
+	}{
+		// 366 days including both ends is the most allowed
+		{"from=2024-01-01&to=2024-12-31", http.StatusOK},
+		{"from=2024-01-01&to=2025-01-01", http.StatusBadRequest},
+		{"from=2024-02-02&to=2024-02-01", http.StatusBadRequest},
+		{"from=2024-02-30&to=2024-03-01", http.StatusBadRequest},
+		{"from=2024-01-01T00:00:00Z&to=2024-01-02", http.StatusBadRequest},
+		{"from=2024-01-01&to=2024-01-02&is_active=maybe", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run("/ads"+tt.query, func(t *testing.T) {
+			service, mock := newTestService(t)
+			if tt.want == http.StatusOK {
+				mock.ExpectQuery("GROUP BY day").WillReturnRows(sqlmock.NewRows([]string{"day", "count"}))
+			}
+			h := &Handler{Service: service}
+			r := newTestRouter(func(r gin.IRoutes) {
+				r.GET("/ads/stats/daily", h.GetDailyStats)
+			})
+			if w := serve(r, http.MethodGet, "/ads/stats/daily?"+tt.query, nil); w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetAdWithRedisDown(t *testing.T) {
+	service, mock := newTestService(t)
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	service.Cache = cache.NewTenantCache(cache.NewBreakerCache(&cache.RedisCache{Client: client}, breaker.Settings{Name: "redis-test", Threshold: 2, Cooldown: time.Minute, Probes: 1}))
+	server.Close()
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) {
+		r.GET("/ads/:id", h.GetAdByID)
+	})
+
+	// Every request is answered from MySQL, before and after the breaker opens
+	for i := 0; i < 4; i++ {
+		mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows(testAd(7, "Bike")))
+		expectNoTranslations(mock)
+		w := serve(r, http.MethodGet, "/ads/7", nil)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"title":"Bike"`) {
+			t.Fatalf("GET %d with Redis down = %d %s, want the ad", i+1, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestEndUserIDOnRepositorySpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(tracing.NewBaggageSpanProcessor([]string{tracing.EndUserIDKey})),
+		sdktrace.WithSpanProcessor(recorder),
+	)
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	service, mock := newTestService(t)
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) {
+		r.Use(otelgin.Middleware("ad-service"), middleware.UserID("X-End-User-Id")).GET("/ads/:id", h.GetAdByID)
+	})
+	mock.ExpectQuery("FROM ads WHERE id = ").WillReturnRows(adRows(testAd(7, "Bike")))
+	expectNoTranslations(mock)
+	if w := serve(r, http.MethodGet, "/ads/7", nil, "X-End-User-Id", "user-42"); w.Code != http.StatusOK {
+		t.Fatalf("GET = %d %s", w.Code, w.Body.String())
+	}
+
+	names := map[string]bool{}
+	for _, span := range recorder.Ended() {
+		names[span.Name()] = true
+		var userID string
+		for _, attr := range span.Attributes() {
+			if attr.Key == tracing.EndUserIDKey {
+				userID = attr.Value.AsString()
+			}
+		}
+		if userID != "user-42" {
+			t.Errorf("span %s has %s %q, want user-42", span.Name(), tracing.EndUserIDKey, userID)
+		}
+	}
+	if !names["GetAdByIDRepository"] || !names["/ads/:id"] {
+		t.Errorf("spans = %v, want the server and repository spans", names)
+	}
+}
+
+func TestValidationFailureSpanEvent(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	service, _ := newTestService(t)
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) {
+		r.POST("/ads", h.AddAd)
+	})
+
+	tests := []struct {
+		name          string
+		body          string
+		fields, rules string
+	}{
+		{"missing title", `{"title": "", "description": "call 555-0100", "price": "5"}`, "[title description]", "[required required]"},
+		{"negative price", `{"title": "Bike 555-0100", "description": "Red", "price": "-5"}`, "[price]", "[positive]"},
+		{"malformed", `{"title": "555-0100"`, "[body]", "[json]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+			failures := map[string]float64{}
+			for _, field := range []string{"title", "description", "price", "body"} {
+				failures[field] = testutil.ToFloat64(metrics.ValidationFailures.WithLabelValues("/ads", field))
+			}
+			if w := serve(r, http.MethodPost, "/ads", strings.NewReader(tt.body), testUserHeader, "user-1"); w.Code != http.StatusBadRequest {
+				t.Fatalf("POST = %d %s, want 400", w.Code, w.Body.String())
+			}
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 || spans[0].Name != "AddAdHandler" {
+				t.Fatalf("spans = %v, want the handler span", spans)
+			}
+			var event *sdktrace.Event
+			for i := range spans[0].Events {
+				if spans[0].Events[i].Name == "validation_failed" {
+					event = &spans[0].Events[i]
+				}
+			}
+			if event == nil {
+				t.Fatalf("events = %v, want validation_failed", spans[0].Events)
+			}
+			got := map[string]string{}
+			for _, attr := range event.Attributes {
+				got[string(attr.Key)] = fmt.Sprint(attr.Value.AsStringSlice())
+			}
+			if got["validation.fields"] != tt.fields || got["validation.rules"] != tt.rules || len(got) != 2 {
+				t.Errorf("event attributes = %v, want fields %s and rules %s only", got, tt.fields, tt.rules)
+			}
+			// The submitted values appear nowhere on the span
+			if recorded := fmt.Sprint(spans[0].Attributes, spans[0].Events); strings.Contains(recorded, "555-0100") {
+				t.Errorf("span records a submitted value: %s", recorded)
+			}
+
+			// Every failed field is counted once
+			failed := strings.Fields(strings.Trim(tt.fields, "[]"))
+			for field, before := range failures {
+				want := 0.0
+				if slices.Contains(failed, field) {
+					want = 1
+				}
+				if delta := testutil.ToFloat64(metrics.ValidationFailures.WithLabelValues("/ads", field)) - before; delta != want {
+					t.Errorf("validation failures of %s = %v, want %v", field, delta, want)
+				}
+			}
+		})
+	}
+}
+
+// Listings are sorted by renewed_at, newest first, so renewing an ad bumps it to the top
+func TestListingDefaultSort(t *testing.T) {
+	tests := []struct {
+		query string
+		order string
+	}{
+		{"", "ORDER BY renewed_at desc"},
+		{"?order=asc", "ORDER BY renewed_at asc"},
+		{"?sort_by=created_at", "ORDER BY created_at asc"},
+		{"?sort_by=renewed_at", "ORDER BY renewed_at desc"},
+	}
+	for _, tt := range tests {
+		t.Run("/ads"+tt.query, func(t *testing.T) {
+			service, mock := newTestService(t)
+			mock.ExpectQuery(regexp.QuoteMeta(tt.order)).WillReturnRows(plainAdRows(testAd(1, "Bike")))
+			expectNoTranslations(mock)
+			h := &Handler{Service: service, Paging: pagination.Policy{MaxLimit: 100, Mode: pagination.ModeClamp}}
+			r := newTestRouter(func(r gin.IRoutes) { r.GET("/ads", h.GetAllAds) })
+
+			if w := serve(r, http.MethodGet, "/ads"+tt.query, nil); w.Code != http.StatusOK {
+				t.Errorf("status = %d, want 200: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetAdLocalized(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		language string // Accept-Language
+		title    string
+		locale   string
+	}{
+		{"default", "", "", "Bike", ""},
+		{"query", "?locale=ru", "", "Велосипед", "ru"},
+		{"query over header", "?locale=en", "ru", "Bike", ""},
+		// A supported locale without a translation falls back to the default fields
+		{"no translation", "?locale=en", "", "Bike", ""},
+		{"header region", "", "ru-RU, en;q=0.5", "Велосипед", "ru"},
+		{"header quality", "", "en;q=0.4, ru;q=0.9", "Велосипед", "ru"},
+		// Unknown languages in the header are skipped rather than rejected
+		{"header unknown", "", "de, fr;q=0.8", "Bike", ""},
+	}
+	service, mock := newTestService(t)
+	bike := testAd(7, "Bike")
+	bike.Translations = map[string]Translation{"ru": {Title: "Велосипед", Description: "Почти новый"}}
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows(bike))
+	mock.ExpectQuery("FROM ad_translations").WillReturnRows(sqlmock.NewRows([]string{"ad_id", "locale", "title", "description"}).AddRow(int64(7), "ru", "Велосипед", "Почти новый"))
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) { r.GET("/ads/:id", h.GetAdByID) })
+
+	// Every locale is served from the one cached entry, read with a single query
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers []string
+			if tt.language != "" {
+				headers = []string{"Accept-Language", tt.language}
+			}
+			w := serve(r, http.MethodGet, "/ads/7"+tt.query, nil, headers...)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+			}
+			var body struct {
+				Data struct {
+					Title        string                 `json:"title"`
+					Locale       string                 `json:"locale"`
+					Translations map[string]Translation `json:"translations"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Data.Title != tt.title || body.Data.Locale != tt.locale {
+				t.Errorf("title %q in locale %q, want %q in %q", body.Data.Title, body.Data.Locale, tt.title, tt.locale)
+			}
+			if len(body.Data.Translations) != 1 {
+				t.Errorf("translations = %v, want the ru one", body.Data.Translations)
+			}
+		})
+	}
+
+	w := serve(r, http.MethodGet, "/ads/7?locale=de", nil)
+	if w.Code != http.StatusBadRequest || errorMessage(t, w.Body.Bytes()) != "Unsupported locale. Must be one of: en, ru." {
+		t.Errorf("unknown locale = %d %s, want 400", w.Code, w.Body.String())
+	}
+}
+
+// Suggestions search the translated titles of the requested locale, cached per locale
+func TestSuggestTitlesLocalized(t *testing.T) {
+	service, mock := newTestService(t)
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) { r.GET("/ads/suggest", h.SuggestTitles) })
+
+	mock.ExpectQuery(`^SELECT DISTINCT title FROM ads WHERE .* title LIKE \?`).WithArgs("default", "ве%", 10).WillReturnRows(sqlmock.NewRows([]string{"title"}))
+	if w := serve(r, http.MethodGet, "/ads/suggest?q=Ве", nil); w.Code != http.StatusOK || w.Body.String() != `{"data":[],"meta":{}}` {
+		t.Errorf("default suggestions = %d %s, want none", w.Code, w.Body.String())
+	}
+	mock.ExpectQuery("UNION SELECT t.title FROM ad_translations t").WithArgs("default", "ве%", "default", "ru", "ве%", 10).WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("Велосипед"))
+	if w := serve(r, http.MethodGet, "/ads/suggest?q=Ве", nil, "Accept-Language", "ru"); w.Code != http.StatusOK || w.Body.String() != `{"data":["Велосипед"],"meta":{}}` {
+		t.Errorf("ru suggestions = %d %s, want the translated title", w.Code, w.Body.String())
+	}
+	// Both are cached now, each under its locale
+	if w := serve(r, http.MethodGet, "/ads/suggest?q=Ве&locale=ru", nil); w.Body.String() != `{"data":["Велосипед"],"meta":{}}` {
+		t.Errorf("cached ru suggestions = %s, want the translated title", w.Body.String())
+	}
+	if w := serve(r, http.MethodGet, "/ads/suggest?q=ве", nil); w.Body.String() != `{"data":[],"meta":{}}` {
+		t.Errorf("cached default suggestions = %s, want none", w.Body.String())
+	}
+	if w := serve(r, http.MethodGet, "/ads/suggest?q=Ве&locale=xx", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown locale = %d, want 400", w.Code)
+	}
+}
+
+func TestLookupByIDAndPublicID(t *testing.T) {
+	const publicID = "3f2b8c1e-7d4a-4f6b-9a2e-5c8d1e0f7a9b"
+	for _, exposeID := range []bool{false, true} {
+		t.Run(fmt.Sprintf("exposeNumericIDs %v", exposeID), func(t *testing.T) {
+			service, mock := newTestService(t)
+			setRules(service, func(r *config.AdsConfig) { r.ExposeNumericIDs = exposeID })
+			h := &Handler{Service: service}
+			r := newTestRouter(func(r gin.IRoutes) {
+				r.GET("/ads/:id", h.GetAdByID)
+				r.GET("/ads/uuid/:public_id", h.GetAdByPublicID)
+			})
+
+			// The largest ID is parsed and written back without losing digits
+			large := testAd(9223372036854775807, "Bike")
+			large.PublicID = strPtr(publicID)
+			mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(9223372036854775807), "default").WillReturnRows(adRows(large))
+			expectNoTranslations(mock)
+			w := serve(r, http.MethodGet, "/ads/9223372036854775807", nil)
+			if w.Code != http.StatusOK || strings.Contains(w.Body.String(), `"id":9223372036854775807,`) != exposeID {
+				t.Errorf("GET by ID = %d %s, want the exact ID only when exposed", w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), `"public_id":"`+publicID+`"`) {
+				t.Errorf("GET by ID = %s, want the public ID", w.Body.String())
+			}
+
+			// The public ID is resolved case-insensitively and answers the same ad, from the cache
+			mock.ExpectQuery("SELECT id FROM ads WHERE public_id = \\? AND tenant_id = \\?").WithArgs(publicID, "default").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(9223372036854775807)))
+			byPublicID := serve(r, http.MethodGet, "/ads/uuid/"+strings.ToUpper(publicID), nil)
+			if byPublicID.Code != http.StatusOK || byPublicID.Body.String() != w.Body.String() {
+				t.Errorf("GET by public ID = %d %s, want %s", byPublicID.Code, byPublicID.Body.String(), w.Body.String())
+			}
+
+			mock.ExpectQuery("SELECT id FROM ads WHERE public_id = ").WithArgs("00000000-0000-4000-8000-000000000000", "default").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			if w := serve(r, http.MethodGet, "/ads/uuid/00000000-0000-4000-8000-000000000000", nil); w.Code != http.StatusNotFound {
+				t.Errorf("unknown public ID = %d, want 404", w.Code)
+			}
+		})
+	}
+
+	service, _ := newTestService(t)
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) {
+		r.GET("/ads/:id", h.GetAdByID)
+		r.GET("/ads/uuid/:public_id", h.GetAdByPublicID)
+	})
+	tests := []struct {
+		target  string
+		message string
+	}{
+		{"/ads/9223372036854775808", "Invalid ID"},
+		{"/ads/0", "Invalid ID"},
+		{"/ads/-7", "Invalid ID"},
+		{"/ads/1e3", "Invalid ID"},
+		{"/ads/uuid/12345", "Invalid public ID. Must be a UUID."},
+		{"/ads/uuid/3f2b8c1e-7d4a-4f6b-9a2e-5c8d1e0f7a9", "Invalid public ID. Must be a UUID."},
+	}
+	for _, tt := range tests {
+		if w := serve(r, http.MethodGet, tt.target, nil); w.Code != http.StatusBadRequest || errorMessage(t, w.Body.Bytes()) != tt.message {
+			t.Errorf("GET %s = %d %s, want 400 %q", tt.target, w.Code, w.Body.String(), tt.message)
+		}
+	}
+}
+
+func TestNewAdsGetPublicIDs(t *testing.T) {
+	service, mock := newTestService(t)
+	seen := map[string]bool{}
+	for i := int64(1); i <= 2; i++ {
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO ads").WillReturnResult(sqlmock.NewResult(i, 1))
+		mock.ExpectExec("UPDATE ads SET slug").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT created_at FROM ads").WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+		mock.ExpectCommit()
+		ad := testAd(0, "Bike")
+		if err := service.Repo.AddAd(&ad, testCtx()); err != nil {
+			t.Fatalf("AddAd: %v", err)
+		}
+		if ad.PublicID == nil || !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(*ad.PublicID) || seen[*ad.PublicID] {
+			t.Errorf("ad %d got public ID %v, want a new random UUID", ad.ID, ad.PublicID)
+		}
+		if ad.PublicID != nil {
+			seen[*ad.PublicID] = true
+		}
+	}
+}
+
+func TestReportRateLimitHeaders(t *testing.T) {
+	service, mock := newTestService(t)
+	setRules(service, func(r *config.AdsConfig) { r.ReportFlagThreshold, r.ReportsPerHour = 10, 3 })
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) { r.POST("/ads/:id/report", h.ReportAd) })
+	cacheAd(t, service, testAd(7, "Bike"))
+
+	// The oldest report leaves the window in about ten minutes, freeing the next slot
+	oldest := time.Now().Add(-50 * time.Minute).UTC()
+	reset := strconv.FormatInt(oldest.Add(time.Hour).Add(time.Second-time.Nanosecond).Unix(), 10)
+	hits := func(times ...time.Time) *sqlmock.Rows {
+		rows := sqlmock.NewRows([]string{"created_at"})
+		for _, t := range times {
+			rows.AddRow(t)
+		}
+		return rows
+	}
+	report := func() *httptest.ResponseRecorder {
+		return serve(r, http.MethodPost, "/ads/7/report", strings.NewReader(`{"reason":"spam"}`), testUserHeader, "reporter")
+	}
+
+	// The last allowed request is accepted and leaves nothing remaining
+	mock.ExpectQuery("SELECT created_at FROM ad_reports").WithArgs("reporter", sqlmock.AnyArg(), "default").WillReturnRows(hits(oldest, oldest.Add(30*time.Minute)))
+	mock.ExpectExec("INSERT IGNORE INTO ad_reports").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	w := report()
+	if w.Code != http.StatusCreated {
+		t.Fatalf("last allowed report = %d %s, want 201", w.Code, w.Body.String())
+	}
+	if got := w.Header(); got.Get("X-RateLimit-Limit") != "3" || got.Get("X-RateLimit-Remaining") != "0" || got.Get("X-RateLimit-Reset") != reset || got.Get("Retry-After") != "" {
+		t.Errorf("last allowed report headers = %v, want limit 3, remaining 0, reset %s and no Retry-After", got, reset)
+	}
+
+	// The first rejected one has the same reset and waits for it
+	mock.ExpectQuery("SELECT created_at FROM ad_reports").WillReturnRows(hits(oldest, oldest.Add(30*time.Minute), time.Now().UTC()))
+	w = report()
+	if w.Code != http.StatusTooManyRequests || errorMessage(t, w.Body.Bytes()) != "Too many reports, try again later" {
+		t.Fatalf("first rejected report = %d %s, want 429", w.Code, w.Body.String())
+	}
+	if got := w.Header(); got.Get("X-RateLimit-Limit") != "3" || got.Get("X-RateLimit-Remaining") != "0" || got.Get("X-RateLimit-Reset") != reset {
+		t.Errorf("first rejected report headers = %v, want limit 3, remaining 0 and reset %s", got, reset)
+	}
+	if retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retryAfter < 599 || retryAfter > 601 {
+		t.Errorf("Retry-After = %q, want about 600 seconds", w.Header().Get("Retry-After"))
+	}
+
+	// A repeated report isn't counted, so a slot stays free
+	mock.ExpectQuery("SELECT created_at FROM ad_reports").WillReturnRows(hits(oldest))
+	mock.ExpectExec("INSERT IGNORE INTO ad_reports").WillReturnResult(sqlmock.NewResult(0, 0))
+	w = report()
+	if w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != "2" || w.Header().Get("X-RateLimit-Reset") != reset {
+		t.Errorf("repeated report = %d %v, want 200 with 2 remaining", w.Code, w.Header())
+	}
+}
+
+// listArgs returns the arguments of the public listing query for q, as the driver receives them
+func listArgs(t *testing.T, q ListQuery) []driver.Value {
+	t.Helper()
+	_, args, err := q.build("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg
+	}
+	return values
+}
+
+func TestPageSizeCap(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  string
+		query string
+		page  int
+		limit int // the limit queried, 0 when the request is rejected
+		meta  string
+	}{
+		{"at the cap", pagination.ModeClamp, "?limit=100", 1, 100, `"meta":{"count":1,"limit":100,"page":1}`},
+		{"at the cap rejecting", pagination.ModeReject, "?limit=100", 1, 100, `"meta":{"count":1,"limit":100,"page":1}`},
+		{"one over clamped", pagination.ModeClamp, "?limit=101", 1, 100, `"meta":{"count":1,"limit":100,"page":1,"requested_limit":101}`},
+		{"far over clamped", pagination.ModeClamp, "?page=2&limit=1000000", 2, 100, `"meta":{"count":1,"limit":100,"page":2,"requested_limit":1000000}`},
+		{"one over rejected", pagination.ModeReject, "?limit=101", 1, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mock := newTestService(t)
+			h := &Handler{Service: service, Paging: pagination.Policy{MaxLimit: 100, Mode: tt.mode}}
+			r := newTestRouter(func(r gin.IRoutes) { r.GET("/ads", h.GetAllAds) })
+			if tt.limit > 0 {
+				q := ListQuery{Filter: ListFilter{Status: StatusApproved}, SortBy: "renewed_at", Order: "desc", Page: tt.page, Limit: tt.limit}
+				mock.ExpectQuery("LIMIT \\? OFFSET \\?").WithArgs(listArgs(t, q)...).WillReturnRows(plainAdRows(testAd(1, "Bike")))
+				expectNoTranslations(mock)
+			}
+
+			w := serve(r, http.MethodGet, "/ads"+tt.query, nil)
+			if tt.limit == 0 {
+				if w.Code != http.StatusBadRequest || errorMessage(t, w.Body.Bytes()) != "Invalid limit value. Must be at most 100." {
+					t.Errorf("GET /ads%s = %d %s, want 400", tt.query, w.Code, w.Body.String())
+				}
+				return
+			}
+			if w.Code != http.StatusOK || !strings.HasSuffix(w.Body.String(), tt.meta+"}") {
+				t.Errorf("GET /ads%s = %d %s, want %s", tt.query, w.Code, w.Body.String(), tt.meta)
+			}
+		})
+	}
+}
+
+func TestIDListCap(t *testing.T) {
+	ids := func(n int) string {
+		parts := make([]string, n)
+		for i := range parts {
+			parts[i] = strconv.Itoa(i + 1)
+		}
+		return strings.Join(parts, ",")
+	}
+	// ID lists are never clamped, which would silently drop ads
+	for _, mode := range []string{pagination.ModeClamp, pagination.ModeReject} {
+		t.Run(mode, func(t *testing.T) {
+			service, mock := newTestService(t)
+			h := &Handler{Service: service, Paging: pagination.Policy{MaxLimit: 100, Mode: mode}}
+			r := newTestRouter(func(r gin.IRoutes) {
+				r.GET("/ads", h.GetAllAds)
+				r.POST("/admin/cache/purge", h.PurgeCache)
+			})
+
+			mock.ExpectQuery("AND id IN \\(").WillReturnRows(plainAdRows(testAd(1, "Bike")))
+			expectNoTranslations(mock)
+			if w := serve(r, http.MethodGet, "/ads?ids="+ids(100), nil); w.Code != http.StatusOK {
+				t.Errorf("100 IDs = %d %s, want 200", w.Code, w.Body.String())
+			}
+			if w := serve(r, http.MethodGet, "/ads?ids="+ids(101), nil); w.Code != http.StatusBadRequest || errorMessage(t, w.Body.Bytes()) != "Too many ids. At most 100 are allowed." {
+				t.Errorf("101 IDs = %d %s, want 400", w.Code, w.Body.String())
+			}
+			body := `{"ids":[` + ids(101) + `]}`
+			if w := serve(r, http.MethodPost, "/admin/cache/purge", strings.NewReader(body), testUserHeader, "admin-1", testRoleHeader, "admin"); w.Code != http.StatusBadRequest || errorMessage(t, w.Body.Bytes()) != "Too many ids. At most 100 are allowed." {
+				t.Errorf("purge of 101 IDs = %d %s, want 400", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestTitleContainsFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		pattern string // the LIKE argument, empty when the request is rejected
+	}{
+		{"plain", "bike", "%bike%"},
+		{"trimmed", "  bike  ", "%bike%"},
+		{"percent signs only", "%%", `%\%\%%`},
+		{"underscores only", "__", `%\_\_%`},
+		{"escape character", `\\`, `%\\\\%`},
+		{"longest", strings.Repeat("в", 100), "%" + strings.Repeat("в", 100) + "%"},
+		{"too short", "a", ""},
+		{"too short once trimmed", " a ", ""},
+		{"too long", strings.Repeat("в", 101), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mock := newTestService(t)
+			h := &Handler{Service: service, Paging: pagination.Policy{MaxLimit: 100, Mode: pagination.ModeClamp}}
+			r := newTestRouter(func(r gin.IRoutes) { r.GET("/ads", h.GetAllAds) })
+			target := "/ads?title_contains=" + url.QueryEscape(tt.value)
+			if tt.pattern == "" {
+				w := serve(r, http.MethodGet, target, nil)
+				if w.Code != http.StatusBadRequest || errorMessage(t, w.Body.Bytes()) != "Invalid title_contains value. Must be between 2 and 100 characters." {
+					t.Errorf("GET %s = %d %s, want 400", target, w.Code, w.Body.String())
+				}
+				return
+			}
+
+			q := ListQuery{Filter: ListFilter{Status: StatusApproved, TitleContains: strings.TrimSpace(tt.value)}, SortBy: "renewed_at", Order: "desc", Page: 1, Limit: pagination.DefaultLimit}
+			args := listArgs(t, q)
+			if !slices.Contains(args, driver.Value(tt.pattern)) {
+				t.Fatalf("query arguments %v lack the pattern %q", args, tt.pattern)
+			}
+			mock.ExpectQuery("title LIKE \\?").WithArgs(args...).WillReturnRows(plainAdRows())
+			if w := serve(r, http.MethodGet, target, nil); w.Code != http.StatusOK || w.Body.String() != `{"data":[],"meta":{"count":0,"limit":10,"page":1}}` {
+				t.Errorf("GET %s = %d %s, want an empty page", target, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+// fixedRates is a currency.Provider returning a fixed table
+type fixedRates struct {
+	table currency.RateTable
+}
+
+func (p fixedRates) Rates(ctx context.Context) (currency.RateTable, error) {
+	return p.table, nil
+}
+
+func TestAdPriceCurrency(t *testing.T) {
+	rates := map[string]float64{"EUR": 0.92, "GBP": 0.25}
+	fresh := fixedRates{currency.RateTable{Rates: rates, AsOf: time.Now()}}
+	stale := fixedRates{currency.RateTable{Rates: rates, AsOf: time.Now().Add(-2 * time.Hour)}}
+	tests := []struct {
+		name     string
+		price    string
+		query    string
+		provider currency.Provider
+		status   int
+		want     []string // fragments of the response body
+		unwanted string
+	}{
+		{"converted", "19.99", "?currency=EUR", fresh, http.StatusOK, []string{`"price":19.99`, `"currency":"USD"`, `"display_price":18.39`, `"display_currency":"EUR"`}, "conversion_unavailable"},
+		{"rounded to the nearest cent", "10.01", "?currency=GBP", fresh, http.StatusOK, []string{`"display_price":2.50`}, ""},
+		{"half cent rounded away from zero", "0.10", "?currency=GBP", fresh, http.StatusOK, []string{`"display_price":0.03`}, ""},
+		{"lower case code", "19.99", "?currency=eur", fresh, http.StatusOK, []string{`"display_price":18.39`}, ""},
+		{"base currency", "19.99", "?currency=USD", fresh, http.StatusOK, []string{`"display_price":19.99`, `"display_currency":"USD"`}, ""},
+		{"stale rates", "19.99", "?currency=EUR", stale, http.StatusOK, []string{`"price":19.99`, `"display_currency":"EUR"`, `"conversion_unavailable":true`}, "display_price"},
+		{"unknown currency", "19.99", "?currency=JPY", fresh, http.StatusBadRequest, []string{"Must be one of: EUR, GBP, USD.", `"field":"currency"`}, ""},
+		{"no currency", "19.99", "", fresh, http.StatusOK, []string{`"price":19.99`}, "display_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _ := newTestService(t)
+			ad := testAd(7, "Bike")
+			ad.Price = mustAmount(tt.price)
+			cacheAd(t, service, ad)
+			converter := currency.NewConverter(config.CurrencyConfig{Base: "USD", Rates: rates, MaxRateAge: time.Hour}, tt.provider, nil)
+			h := &Handler{Service: service, Currency: converter}
+			r := newTestRouter(func(r gin.IRoutes) {
+				r.GET("/ads/:id", h.GetAdByID)
+			})
+
+			w := serve(r, http.MethodGet, "/ads/7"+tt.query, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("body = %s, want %s", w.Body, want)
+				}
+			}
+			if tt.unwanted != "" && strings.Contains(w.Body.String(), tt.unwanted) {
+				t.Errorf("body = %s, want no %s", w.Body, tt.unwanted)
+			}
+		})
+	}
+}
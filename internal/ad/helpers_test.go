@@ -0,0 +1,217 @@
+package ad
+
+import (
+	"ad_service/internal/apperr"
+	"ad_service/internal/config"
+	"ad_service/pkg/cache"
+	"ad_service/pkg/middleware"
+	"ad_service/pkg/money"
+	"ad_service/pkg/response"
+	"ad_service/pkg/tenant"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// Headers the test router reads the caller from
+const (
+	testUserHeader = "X-User-Id"
+	testRoleHeader = "X-User-Role"
+)
+
+// testAdColumns are the columns of a full ad row, keywords first like the repository selects them
+var testAdColumns = append([]string{"keywords"}, strings.Split(adColumns, ", ")...)
+
+// testCacheConfig holds the default TTLs
+var testCacheConfig = config.CacheConfig{
+	AdTTL:            5 * time.Minute,
+	ListTTL:          30 * time.Second,
+	CountTTL:         time.Minute,
+	NegativeTTL:      30 * time.Second,
+	LockTimeout:      5 * time.Second,
+	LockWait:         500 * time.Millisecond,
+	WriteMode:        "write_through",
+	MutationLockTTL:  5 * time.Second,
+	MutationLockWait: time.Second,
+}
+
+// testErrorMappings map the domain error kinds like the server does
+var testErrorMappings = []middleware.ErrorMapping{
+	{Target: ErrActiveQuota, Status: http.StatusConflict, Code: response.CodeQuotaExceeded},
+	{Target: ErrDailyQuota, Status: http.StatusTooManyRequests, Code: response.CodeQuotaExceeded},
+	{Target: apperr.ErrNotFound, Status: http.StatusNotFound},
+	{Target: apperr.ErrValidation, Status: http.StatusBadRequest},
+	{Target: apperr.ErrConflict, Status: http.StatusConflict},
+	{Target: apperr.ErrForbidden, Status: http.StatusForbidden},
+	{Target: ErrRenewalLimit, Status: http.StatusTooManyRequests},
+}
+
+// newTestService returns a service on a mocked database and an in-memory cache, wired like
+// cmd/app. The mock must have met all its expectations when the test ends.
+func newTestService(t *testing.T) (*AdService, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := cache.NewMemoryCache(time.Minute)
+	t.Cleanup(func() {
+		memory.Close()
+		db.Close()
+	})
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	service := &AdService{
+		Repo:  &Repository{DB: db},
+		Cache: cache.NewTenantCache(memory),
+		TTL:   config.NewReloadable(testCacheConfig),
+		Rules: config.NewReloadable(config.AdsConfig{RenewalExtension: 30 * 24 * time.Hour, RenewalsPerWeek: 3, Locales: []string{"en", "ru"}}),
+	}
+	return service, mock
+}
+
+// setRules changes the rules of service the way a hot reload does
+func setRules(service *AdService, change func(*config.AdsConfig)) {
+	rules := service.Rules.Load()
+	change(&rules)
+	service.Rules.Store(rules)
+}
+
+// newTestRouter returns a router with the error, tenant and identity middleware of the server,
+// for register to add the routes under test to
+func newTestRouter(register func(r gin.IRoutes)) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.Errors(testErrorMappings...), middleware.Tenant(config.TenancyConfig{}), middleware.Identity(testUserHeader, testRoleHeader, "admin"))
+	register(r)
+	return r
+}
+
+// serve sends a request to r; headers are name and value pairs
+func serve(r http.Handler, method, target string, body io.Reader, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// testCtx is a context of the default tenant
+func testCtx() context.Context {
+	return tenant.With(context.Background(), tenant.Default)
+}
+
+// testAd returns an approved, active ad
+func testAd(id int64, title string) Ad {
+	created := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	return Ad{
+		ID:          id,
+		Title:       title,
+		Description: "Description of " + title,
+		Price:       mustAmount("10.00"),
+		CreatedAt:   created,
+		RenewedAt:   created,
+		IsActive:    true,
+		Weight:      DefaultAdWeight,
+		Status:      StatusApproved,
+	}
+}
+
+// mustAmount parses a price
+func mustAmount(s string) money.Amount {
+	amount, err := money.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return amount
+}
+
+// cacheAd stores ad in the service's cache as GetAdByID would
+func cacheAd(t *testing.T, s *AdService, ad Ad) {
+	t.Helper()
+	data, err := json.Marshal(ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Cache.Set(adCacheKey(ad.ID), string(data), time.Minute, testCtx()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// adRows returns the rows of a query selecting keywordsColumn and adColumns
+func adRows(ads ...Ad) *sqlmock.Rows {
+	rows := sqlmock.NewRows(testAdColumns)
+	for _, ad := range ads {
+		rows.AddRow(adRow(ad)...)
+	}
+	return rows
+}
+
+// plainAdRows returns the rows of a query selecting adColumns only, like the listings
+func plainAdRows(ads ...Ad) *sqlmock.Rows {
+	rows := sqlmock.NewRows(testAdColumns[1:])
+	for _, ad := range ads {
+		rows.AddRow(adRow(ad)[1:]...)
+	}
+	return rows
+}
+
+// adRow returns the values of ad in the order of testAdColumns
+func adRow(ad Ad) []driver.Value {
+	var keywords any
+	if len(ad.Keywords) > 0 {
+		keywords = strings.Join(ad.Keywords, ",")
+	}
+	price, _ := ad.Price.Value()
+	return []driver.Value{
+		keywords, ad.ID, nullable(ad.PublicID), nullable(ad.Slug), ad.Title, ad.Description, price, ad.CreatedAt, ad.IsActive,
+		nullable(ad.ExternalRef), ad.Category, nullable(ad.ExpiresAt), int64(ad.Weight), ad.Status, nullable(ad.StatusReason),
+		nullable(ad.OwnerID), ad.RenewedAt, int64(ad.RenewalCount), nullable(ad.RenewalWindowStart), nullable(ad.ArchivedAt),
+		int64(ad.FavoritesCount), nullable(ad.CampaignID), nullable(ad.Latitude), nullable(ad.Longitude),
+	}
+}
+
+// nullable turns a pointer into the value a driver returns for a nullable column
+func nullable[T any](p *T) driver.Value {
+	if p == nil {
+		return nil
+	}
+	switch v := any(*p).(type) {
+	case int:
+		return int64(v)
+	default:
+		return v
+	}
+}
+
+// expectNoTranslations answers the translations query of attachTranslations with no rows
+func expectNoTranslations(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("FROM ad_translations").WillReturnRows(sqlmock.NewRows([]string{"ad_id", "locale", "title", "description"}))
+}
+
+// strPtr returns a pointer to s
+func strPtr(s string) *string {
+	return &s
+}
+
+// floatPtr returns a pointer to f
+func floatPtr(f float64) *float64 {
+	return &f
+}
@@ -0,0 +1,204 @@
+/*
+This file keeps the most read ads permanently cached. Every GetAdByID counts a read of the ad in
+Redis, per cache.hotWindow. The replica whose read takes the count to cache.hotThreshold adopts
+the ad into its hot set, so each hot ad is refreshed by one replica rather than all of them. The
+refresher re-reads hot ads from MySQL shortly before their cache entry could expire, so readers
+of hot ads don't fall through to MySQL every cache.adTTL. Ads stop being refreshed once they
+haven't reached the threshold for two windows, or when they no longer exist.
+*/
+package ad
+
+import (
+	"ad_service/internal/config"
+	"ad_service/pkg/cache"
+	"ad_service/pkg/metrics"
+	"ad_service/pkg/scheduler"
+	"ad_service/pkg/tenant"
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// hotKey identifies an ad of the hot set, whose IDs are only unique within a tenant
+type hotKey struct {
+	tenant string
+	id     int64
+}
+
+// hotAd is an ad in the hot set of this replica
+type hotAd struct {
+	lastHot     time.Time // start of the last window in which this replica saw the ad reach the threshold
+	refreshedAt time.Time // when the refresher last cached it, zero until the first refresh
+}
+
+// hotKeys is the hot set of this replica
+type hotKeys struct {
+	size      int
+	threshold int64
+	window    time.Duration
+	lead      time.Duration
+
+	mu  sync.Mutex
+	ads map[hotKey]*hotAd
+}
+
+// readCountKey is the key counting the reads of an ad in the window starting at start
+func readCountKey(id int64, start time.Time) string {
+	return cache.Key("ad", "reads", strconv.FormatInt(id, 10), strconv.FormatInt(start.Unix(), 10))
+}
+
+// countRead counts a read of the ad in the background and adopts it into the hot set when this
+// read takes it to the threshold
+func (s *AdService) countRead(id int64, ctx context.Context) {
+	if s.hot == nil {
+		return
+	}
+	tenantID, ok := tenant.FromContext(ctx)
+	if !ok {
+		return
+	}
+	window := time.Now().Truncate(s.hot.window)
+	go func() {
+		count, err := s.cache().IncrExpire(readCountKey(id, window), 2*s.hot.window, context.WithoutCancel(ctx))
+		if err != nil || count != s.hot.threshold {
+			return
+		}
+		s.hot.adopt(hotKey{tenantID, id}, window)
+	}()
+}
+
+// adopt adds an ad to the hot set or marks it hot again. When the set is full, the ad that has
+// been hot the least recently makes room.
+func (h *hotKeys) adopt(key hotKey, window time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if ad, ok := h.ads[key]; ok {
+		ad.lastHot = window
+		return
+	}
+	if len(h.ads) >= h.size {
+		var coldest hotKey
+		var coldestAt time.Time
+		for candidate, ad := range h.ads {
+			if coldestAt.IsZero() || ad.lastHot.Before(coldestAt) {
+				coldest, coldestAt = candidate, ad.lastHot
+			}
+		}
+		delete(h.ads, coldest)
+	}
+	h.ads[key] = &hotAd{lastHot: window}
+	metrics.CacheHotKeys.Set(float64(len(h.ads)))
+}
+
+// drop removes an ad from the hot set
+func (h *hotKeys) drop(key hotKey) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.ads, key)
+	metrics.CacheHotKeys.Set(float64(len(h.ads)))
+}
+
+// due returns the hot ads whose cache entry must be refreshed now, given the earliest time an
+// entry can expire after being cached, and forgets the ads that cooled down
+func (h *hotKeys) due(earliestExpiry time.Duration, now time.Time) []hotKey {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var keys []hotKey
+	for key, ad := range h.ads {
+		if now.Sub(ad.lastHot) > 2*h.window {
+			delete(h.ads, key)
+			continue
+		}
+		if ad.refreshedAt.IsZero() || now.Sub(ad.refreshedAt) >= earliestExpiry-h.lead {
+			keys = append(keys, key)
+		}
+	}
+	metrics.CacheHotKeys.Set(float64(len(h.ads)))
+	return keys
+}
+
+// refreshed records that an ad was cached at t, unless it left the hot set meanwhile
+func (h *hotKeys) refreshed(key hotKey, t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if ad, ok := h.ads[key]; ok {
+		ad.refreshedAt = t
+	}
+}
+
+// EnableHotKeys starts counting ad reads and returns the job refreshing the hot set of this
+// replica. It must be called before the service handles requests. The job checks the hot set
+// every half cache.hotRefreshLead, so each hot ad is cached again between half the lead and the
+// full lead before its entry could expire.
+func (s *AdService) EnableHotKeys(cfg config.CacheConfig) scheduler.Job {
+	s.hot = &hotKeys{
+		size:      cfg.HotKeys,
+		threshold: int64(cfg.HotThreshold),
+		window:    cfg.HotWindow,
+		lead:      cfg.HotRefreshLead,
+		ads:       make(map[hotKey]*hotAd),
+	}
+	return scheduler.Job{
+		Name:     "refresh_hot_keys",
+		Interval: cfg.HotRefreshLead / 2,
+		Run: func(ctx context.Context) error {
+			s.refreshHotKeys(ctx)
+			return nil
+		},
+	}
+}
+
+// refreshHotKeys caches the hot ads that are due again, with tracing
+func (s *AdService) refreshHotKeys(ctx context.Context) {
+	adTTL := s.TTL.Load().AdTTL
+	if adTTL <= 0 {
+		return
+	}
+	keys := s.hot.due(cache.EarliestExpiry(adTTL), time.Now())
+	if len(keys) == 0 {
+		return
+	}
+
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "RefreshHotKeysService")
+	defer span.End()
+	span.SetAttributes(attribute.Int("hot_keys_due", len(keys)))
+
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return
+		}
+		s.refreshHotAd(key, tenant.With(ctx, key.tenant))
+	}
+}
+
+// refreshHotAd caches one hot ad again, within its tenant in ctx. It holds the ad's mutation lock so the refresh can't
+// overwrite the entry of a concurrent update with the row read before it; a busy ad is left for
+// the next check, since the update refreshes the entry itself.
+func (s *AdService) refreshHotAd(key hotKey, ctx context.Context) {
+	lock, err := s.lockAd(key.id, ctx)
+	if err != nil {
+		metrics.CacheHotRefreshes.WithLabelValues("busy").Inc()
+		return
+	}
+	defer lock.Release(ctx)
+
+	_, err = s.loadAd(key.id, ctx)
+	switch {
+	case errors.Is(err, ErrAdNotFound):
+		s.hot.drop(key)
+		metrics.CacheHotRefreshes.WithLabelValues("not_found").Inc()
+	case err != nil:
+		trace.SpanFromContext(ctx).RecordError(err)
+		metrics.CacheHotRefreshes.WithLabelValues("error").Inc()
+	default:
+		s.hot.refreshed(key, time.Now())
+		metrics.CacheHotRefreshes.WithLabelValues("refreshed").Inc()
+	}
+}
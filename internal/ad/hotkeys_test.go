@@ -0,0 +1,165 @@
+package ad
+
+import (
+	"ad_service/pkg/metrics"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Hot ads are cached for 500ms and refreshed within 200ms of the earliest expiry
+const (
+	hotTestTTL  = 500 * time.Millisecond
+	hotTestLead = 200 * time.Millisecond
+)
+
+// newHotService returns a service with the hot set enabled, the refresh job and the ad with ID 7
+// already hot
+func newHotService(t *testing.T) (*AdService, sqlmock.Sqlmock, func(context.Context) error) {
+	t.Helper()
+	service, mock := newTestService(t)
+	cfg := testCacheConfig
+	cfg.AdTTL = hotTestTTL
+	cfg.HotKeys, cfg.HotThreshold, cfg.HotWindow, cfg.HotRefreshLead = 10, 3, time.Minute, hotTestLead
+	service.TTL.Store(cfg)
+	job := service.EnableHotKeys(cfg)
+	if job.Interval != hotTestLead/2 {
+		t.Errorf("refresh interval = %s, want half the lead", job.Interval)
+	}
+
+	// Reading it to the threshold makes the ad hot
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows(testAd(7, "Bike")))
+	expectNoTranslations(mock)
+	for i := 0; i < 3; i++ {
+		if _, err := service.GetAdByID(7, testCtx()); err != nil {
+			t.Fatalf("GetAdByID: %v", err)
+		}
+	}
+	waitFor(t, func() bool { return hotCount(service) == 1 })
+	return service, mock, job.Run
+}
+
+// hotCount is the size of the service's hot set
+func hotCount(s *AdService) int {
+	s.hot.mu.Lock()
+	defer s.hot.mu.Unlock()
+	return len(s.hot.ads)
+}
+
+func TestHotAdNeverMisses(t *testing.T) {
+	service, mock, refresh := newHotService(t)
+	misses := testutil.ToFloat64(metrics.CacheOperations.WithLabelValues("ad", "miss"))
+	refreshed := testutil.ToFloat64(metrics.CacheHotRefreshes.WithLabelValues("refreshed"))
+
+	// Readers keep reading through about three lifetimes of the entry, which only the refreshes
+	// keep cached
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(2 * time.Millisecond):
+			}
+			if _, err := service.GetAdByID(7, testCtx()); err != nil {
+				t.Errorf("GetAdByID of a hot ad: %v", err)
+				return
+			}
+		}
+	}()
+
+	// The first check refreshes the new hot ad; later ones only once it is due again
+	const cycles = 5
+	for i := 0; i < cycles; i++ {
+		mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows(testAd(7, "Bike")))
+		expectNoTranslations(mock)
+		if err := refresh(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		// Checking again right away finds nothing due
+		if err := refresh(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(hotTestTTL - hotTestLead)
+	}
+	close(stop)
+	wg.Wait()
+
+	if got := testutil.ToFloat64(metrics.CacheOperations.WithLabelValues("ad", "miss")) - misses; got != 0 {
+		t.Errorf("hot ad missed the cache %v times", got)
+	}
+	if got := testutil.ToFloat64(metrics.CacheHotRefreshes.WithLabelValues("refreshed")) - refreshed; got != cycles {
+		t.Errorf("cache_hot_refreshes_total{refreshed} grew by %v, want %d", got, cycles)
+	}
+}
+
+func TestDeletedHotAdLeavesHotSet(t *testing.T) {
+	service, mock, refresh := newHotService(t)
+	notFound := testutil.ToFloat64(metrics.CacheHotRefreshes.WithLabelValues("not_found"))
+
+	// The ad is gone by the time it is refreshed
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows())
+	if err := refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if hotCount(service) != 0 {
+		t.Errorf("hot set holds %d ads, want the deleted one dropped", hotCount(service))
+	}
+	if got := testutil.ToFloat64(metrics.CacheHotRefreshes.WithLabelValues("not_found")) - notFound; got != 1 {
+		t.Errorf("cache_hot_refreshes_total{not_found} grew by %v, want 1", got)
+	}
+	// It is cached as missing, and later checks don't query it again
+	if cached, _ := service.Cache.Get(adCacheKey(7), testCtx()); cached != notFoundCacheValue {
+		t.Errorf("cache entry = %q, want it marked missing", cached)
+	}
+	if err := refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHotKeysSet(t *testing.T) {
+	window := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	h := &hotKeys{size: 2, threshold: 3, window: time.Minute, lead: 10 * time.Second, ads: map[hotKey]*hotAd{}}
+	first, second, third := hotKey{"default", 1}, hotKey{"default", 2}, hotKey{"other", 1}
+
+	// A full set makes room by dropping the ad hot least recently
+	h.adopt(first, window)
+	h.adopt(second, window.Add(time.Minute))
+	h.adopt(third, window.Add(time.Minute))
+	if _, ok := h.ads[first]; ok || len(h.ads) != 2 {
+		t.Errorf("hot set = %v, want the first ad evicted", h.ads)
+	}
+
+	// New ads are due at once, refreshed ones only within the lead of the earliest expiry
+	now := window.Add(time.Minute)
+	if due := h.due(time.Minute, now); len(due) != 2 {
+		t.Errorf("due = %v, want both new ads", due)
+	}
+	h.refreshed(second, now)
+	h.refreshed(third, now.Add(-45*time.Second))
+	if due := h.due(time.Minute, now); len(due) != 0 {
+		t.Errorf("due = %v before the lead, want none", due)
+	}
+	if due := h.due(time.Minute, now.Add(5*time.Second)); len(due) != 1 || due[0] != third {
+		t.Errorf("due = %v, want the ad refreshed 50s before", due)
+	}
+
+	// Ads not hot for two windows cool down, the one hot again stays and is due
+	h.adopt(second, now.Add(2*time.Minute))
+	if due := h.due(time.Minute, now.Add(2*time.Minute+time.Second)); len(due) != 1 || due[0] != second || len(h.ads) != 1 {
+		t.Errorf("due = %v from %v, want only the ad hot again", due, h.ads)
+	}
+	// An ad dropped meanwhile isn't brought back by its refresh
+	h.drop(second)
+	h.refreshed(second, now)
+	if len(h.ads) != 0 {
+		t.Errorf("hot set = %v, want it empty", h.ads)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+This file records the impressions of served ads off the request path. ServeAd queues each
+impression for a fixed pool of workers, which count it in MySQL and in the trending views. A full
+queue drops the impression rather than holding up serving or piling up goroutines. Drops and
+failed writes are counted in ad_impressions_total, and failed writes are logged.
+*/
+package ad
+
+import (
+	"ad_service/pkg/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// impressionTimeout bounds recording one impression, so a stuck database can't hold a worker
+const impressionTimeout = 5 * time.Second
+
+// impression is a served ad waiting to be recorded
+type impression struct {
+	id      int64
+	variant *string
+	ctx     context.Context // the serve request's, detached from its cancellation
+}
+
+// impressionQueue feeds the impression workers
+type impressionQueue struct {
+	mu      sync.RWMutex // guards closing queue against concurrent pushes
+	closed  bool
+	queue   chan impression
+	workers sync.WaitGroup
+}
+
+// StartImpressions starts workers goroutines recording the impressions of ServeAd, with room for
+// queueSize impressions waiting for them, and returns the function that records the queued ones
+// and stops the workers, for graceful shutdown. Without it, as in tools and tests, ServeAd
+// records the impression before returning.
+func (s *AdService) StartImpressions(workers, queueSize int) func(context.Context) error {
+	q := &impressionQueue{queue: make(chan impression, queueSize)}
+	for i := 0; i < workers; i++ {
+		q.workers.Add(1)
+		go func() {
+			defer q.workers.Done()
+			for imp := range q.queue {
+				s.writeImpression(imp.id, imp.variant, imp.ctx)
+			}
+		}()
+	}
+	s.impressions = q
+	return q.stop
+}
+
+// recordImpression queues the impression of a served ad, or writes it right away when no
+// workers were started
+func (s *AdService) recordImpression(id int64, variant *string, ctx context.Context) {
+	ctx = context.WithoutCancel(ctx)
+	if s.impressions == nil {
+		s.writeImpression(id, variant, ctx)
+		return
+	}
+	if !s.impressions.push(impression{id: id, variant: variant, ctx: ctx}) {
+		metrics.AdImpressions.WithLabelValues("dropped").Inc()
+	}
+}
+
+// writeImpression counts the impression in MySQL and the view in the trending buckets
+func (s *AdService) writeImpression(id int64, variant *string, ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, impressionTimeout)
+	defer cancel()
+
+	err := s.Repo.RecordImpression(id, variant, ctx)
+	if s.Trending != nil {
+		if viewErr := s.Trending.RecordView(id, ctx); viewErr != nil {
+			err = errors.Join(err, fmt.Errorf("could not record view: %w", viewErr))
+		}
+	}
+	if err != nil {
+		metrics.AdImpressions.WithLabelValues("error").Inc()
+		slog.WarnContext(ctx, "Could not record impression", "ad_id", id, "error", err)
+		return
+	}
+	metrics.AdImpressions.WithLabelValues("recorded").Inc()
+}
+
+// push queues imp unless the queue is full or stopped
+func (q *impressionQueue) push(imp impression) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false
+	}
+	select {
+	case q.queue <- imp:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop refuses new impressions and waits for the workers to record the queued ones, or for ctx
+func (q *impressionQueue) stop(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("could not record %d queued impressions: %w", len(q.queue), ctx.Err())
+	}
+}
@@ -0,0 +1,158 @@
+package ad
+
+import (
+	"ad_service/pkg/metrics"
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// impressionCount returns the impressions counted with result so far
+func impressionCount(result string) float64 {
+	return testutil.ToFloat64(metrics.AdImpressions.WithLabelValues(result))
+}
+
+func TestPickWeightedFollowsWeights(t *testing.T) {
+	ads := []Ad{testAd(1, "w1"), testAd(2, "w2"), testAd(3, "w3"), testAd(4, "w4"), testAd(5, "w0")}
+	for i := range ads {
+		ads[i].Weight = i + 1
+	}
+	ads[4].Weight = 0
+	const draws = 200000
+	counts := map[int64]int{}
+	for i := 0; i < draws; i++ {
+		counts[pickWeighted(ads).ID]++
+	}
+
+	if counts[5] != 0 {
+		t.Errorf("an ad of weight 0 was served %d times", counts[5])
+	}
+	// Pearson's chi-squared test of the counts against the weights 1:2:3:4. With 3 degrees of
+	// freedom the statistic exceeds 30.7 with a probability of 1e-6 when the picks follow the
+	// weights, so the test is practically never flaky.
+	chi2 := 0.0
+	for _, ad := range ads[:4] {
+		expected := draws * float64(ad.Weight) / 10
+		diff := float64(counts[ad.ID]) - expected
+		chi2 += diff * diff / expected
+	}
+	if chi2 > 30.7 {
+		t.Errorf("serve counts %v don't follow the weights 1:2:3:4, chi-squared %.1f", counts, chi2)
+	}
+	for _, ad := range ads[:4] {
+		share := float64(counts[ad.ID]) / draws
+		if want := float64(ad.Weight) / 10; math.Abs(share-want) > 0.01 {
+			t.Errorf("ad of weight %d served %.3f of the time, want %.3f", ad.Weight, share, want)
+		}
+	}
+}
+
+func TestPickWeightedWithoutServableAds(t *testing.T) {
+	zero := testAd(1, "zero")
+	zero.Weight = 0
+	for _, ads := range [][]Ad{nil, {zero}} {
+		if ad := pickWeighted(ads); ad != nil {
+			t.Errorf("pickWeighted(%v) = %d, want nil", ads, ad.ID)
+		}
+	}
+}
+
+func TestServeAdRecordsImpressionInline(t *testing.T) {
+	service, mock := newTestService(t)
+	ad := testAd(7, "Bike")
+	mock.ExpectQuery("FROM ads").WillReturnRows(adRows(ad))
+	// GetServableAds loads the variants of the snapshot
+	mock.ExpectQuery("FROM ad_variants").WillReturnRows(sqlmock.NewRows([]string{"ad_id", "variant_key", "title", "description", "weight", "impressions", "clicks"}))
+	expectNoTranslations(mock)
+	mock.ExpectExec("UPDATE ads SET impressions = impressions \\+ 1").WithArgs(int64(7), "default").WillReturnResult(sqlmock.NewResult(0, 1))
+	before := impressionCount("recorded")
+
+	served, err := service.ServeAd(nil, testCtx())
+	if err != nil || served.ID != 7 {
+		t.Fatalf("ServeAd = %v, %v; want ad 7", served, err)
+	}
+	// Without workers the impression is written before ServeAd returns
+	if got := impressionCount("recorded") - before; got != 1 {
+		t.Errorf("recorded impressions = %v, want 1", got)
+	}
+}
+
+func TestImpressionQueueDropsWhenFull(t *testing.T) {
+	service, mock := newTestService(t)
+	stop := service.StartImpressions(1, 1)
+	ctx := testCtx()
+	dropped := impressionCount("dropped")
+	recorded := impressionCount("recorded")
+
+	// The worker is held by the first impression while the second waits in the queue
+	mock.ExpectExec("UPDATE ads SET impressions").WithArgs(int64(1), "default").WillDelayFor(100 * time.Millisecond).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE ads SET impressions").WithArgs(int64(2), "default").WillReturnResult(sqlmock.NewResult(0, 1))
+	service.recordImpression(1, nil, ctx)
+	waitFor(t, func() bool { return len(service.impressions.queue) == 0 })
+	service.recordImpression(2, nil, ctx)
+	service.recordImpression(3, nil, ctx)
+
+	if got := impressionCount("dropped") - dropped; got != 1 {
+		t.Errorf("dropped impressions = %v, want 1", got)
+	}
+	if err := stop(context.Background()); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if got := impressionCount("recorded") - recorded; got != 2 {
+		t.Errorf("recorded impressions = %v, want the 2 queued before stopping", got)
+	}
+
+	// Impressions after shutdown are dropped rather than sent on the closed queue
+	service.recordImpression(4, nil, ctx)
+	if got := impressionCount("dropped") - dropped; got != 2 {
+		t.Errorf("dropped impressions after stop = %v, want 2", got)
+	}
+}
+
+func TestImpressionFailuresAreCounted(t *testing.T) {
+	service, mock := newTestService(t)
+	stop := service.StartImpressions(2, 10)
+	failed := impressionCount("error")
+
+	mock.ExpectExec("UPDATE ads SET impressions").WillReturnError(errors.New("connection refused"))
+	service.recordImpression(1, nil, testCtx())
+	if err := stop(context.Background()); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if got := impressionCount("error") - failed; got != 1 {
+		t.Errorf("failed impressions = %v, want 1", got)
+	}
+}
+
+func TestImpressionStopGivesUpAtDeadline(t *testing.T) {
+	service, mock := newTestService(t)
+	stop := service.StartImpressions(1, 1)
+	mock.ExpectExec("UPDATE ads SET impressions").WillDelayFor(200 * time.Millisecond).WillReturnResult(sqlmock.NewResult(0, 1))
+	service.recordImpression(1, nil, testCtx())
+	waitFor(t, func() bool { return len(service.impressions.queue) == 0 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("stop with a worker still busy = %v, want DeadlineExceeded", err)
+	}
+	// Let the worker finish before the mock is checked
+	service.impressions.workers.Wait()
+}
+
+// waitFor polls cond until it holds, failing the test after a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within a second")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
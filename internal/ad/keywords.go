@@ -0,0 +1,186 @@
+/*
+This file holds the targeting keywords of ads, stored in the ad_keywords table and matched
+against the context keywords of a serve request.
+*/
+package ad
+
+import (
+	"ad_service/internal/apperr"
+	"ad_service/pkg/tenant"
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// MaxAdKeywords caps the number of targeting keywords an ad (or a serve request) can carry
+const MaxAdKeywords = 20
+
+// maxKeywordLength matches the ad_keywords.keyword column
+const maxKeywordLength = 50
+
+// ErrTooManyKeywords is returned by NormalizeKeywords for more than MaxAdKeywords distinct keywords
+var ErrTooManyKeywords = apperr.Validation(fmt.Sprintf("at most %d keywords are allowed", MaxAdKeywords), apperr.Field{Field: "keywords", Rule: "keywords"})
+
+// ErrKeywordTooLong is returned by NormalizeKeywords for a keyword longer than the column allows
+var ErrKeywordTooLong = apperr.Validation(fmt.Sprintf("keywords must be at most %d characters", maxKeywordLength), apperr.Field{Field: "keywords", Rule: "keywords"})
+
+// NormalizeKeywords trims and lowercases keywords, drops empty ones and duplicates, and sorts the
+// rest. A nil slice stays nil so that updates can tell "not sent" from "cleared".
+func NormalizeKeywords(keywords []string) ([]string, error) {
+	if keywords == nil {
+		return nil, nil
+	}
+	seen := make(map[string]bool, len(keywords))
+	normalized := []string{}
+	for _, keyword := range keywords {
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		if keyword == "" || seen[keyword] {
+			continue
+		}
+		if len(keyword) > maxKeywordLength {
+			return nil, ErrKeywordTooLong
+		}
+		seen[keyword] = true
+		normalized = append(normalized, keyword)
+	}
+	if len(normalized) > MaxAdKeywords {
+		return nil, ErrTooManyKeywords
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// replaceKeywords swaps the keywords of an ad for the given ones inside the transaction
+func replaceKeywords(tx *sql.Tx, adID int64, keywords []string, ctx context.Context) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM ad_keywords WHERE ad_id = ?", adID); err != nil {
+		return fmt.Errorf("could not delete keywords: %w", err)
+	}
+	return insertKeywords(tx, map[int64][]string{adID: keywords}, ctx)
+}
+
+// insertKeywords writes the keywords of one or more ads with a single multi-row INSERT
+func insertKeywords(tx *sql.Tx, keywordsByAd map[int64][]string, ctx context.Context) error {
+	var placeholders []string
+	var params []interface{}
+	for adID, keywords := range keywordsByAd {
+		for _, keyword := range keywords {
+			placeholders = append(placeholders, "(?, ?)")
+			params = append(params, adID, keyword)
+		}
+	}
+	if len(placeholders) == 0 {
+		return nil
+	}
+
+	query := "INSERT INTO ad_keywords (ad_id, keyword) VALUES " + strings.Join(placeholders, ", ")
+	if _, err := tx.ExecContext(ctx, query, params...); err != nil {
+		return fmt.Errorf("could not insert keywords: %w", err)
+	}
+	return nil
+}
+
+// keywordsColumn selects an ad's keywords as one comma-separated value, for queries over many ads
+const keywordsColumn = "(SELECT GROUP_CONCAT(keyword ORDER BY keyword) FROM ad_keywords WHERE ad_keywords.ad_id = ads.id)"
+
+// splitKeywords turns a keywordsColumn or GROUP_CONCAT value back into a slice
+func splitKeywords(value sql.NullString) []string {
+	if !value.Valid || value.String == "" {
+		return nil
+	}
+	return strings.Split(value.String, ",")
+}
+
+// KeywordMatch is a servable ad whose targeting overlaps the keywords of a serve request
+type KeywordMatch struct {
+	Ad      Ad
+	Matched []string
+}
+
+// MatchKeywords returns the servable ads targeting any of the given keywords, best overlap first,
+// with tracing. A single query joins ad_keywords and counts the matches per ad; only the ads
+// sharing the highest overlap are returned, since a lower overlap never wins.
+func (r *Repository) MatchKeywords(keywords []string, ctx context.Context) (_ []KeywordMatch, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "MatchKeywordsRepository")
+	defer span.End()
+	defer observeQuery("match_keywords", time.Now(), &err, ctx)
+
+	if len(keywords) == 0 {
+		return []KeywordMatch{}, nil
+	}
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(keywords)), ", ")
+	params := make([]interface{}, 0, len(keywords)+1)
+	for _, keyword := range keywords {
+		params = append(params, keyword)
+	}
+	params = append(params, tenantID)
+	// Grouping by the primary key lets the other ads columns be selected as they are
+	query := "SELECT COUNT(*) AS overlap, GROUP_CONCAT(k.keyword ORDER BY k.keyword), " + prefixedAdColumns("ads") + " " +
+		"FROM ads JOIN ad_keywords k ON k.ad_id = ads.id " +
+		"WHERE k.keyword IN (" + placeholders + ") AND ads." + tenantCondition + " AND " + activeCondition + " AND weight > 0 " +
+		"GROUP BY ads.id ORDER BY overlap DESC"
+	rows, err := r.DB.QueryContext(ctx, query, params...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to match keywords")
+		return nil, fmt.Errorf("could not match keywords: %w", err)
+	}
+	defer rows.Close()
+
+	matches := []KeywordMatch{}
+	best := 0
+	for rows.Next() {
+		var overlap int
+		var matched sql.NullString
+		var match KeywordMatch
+		if err := scanAd(prefixedScanner{prefixedScanner{rows, &overlap}, &matched}, &match.Ad); err != nil {
+			span.RecordError(err)
+			return nil, fmt.Errorf("could not scan keyword match: %w", err)
+		}
+		if overlap < best {
+			break
+		}
+		best = overlap
+		match.Matched = splitKeywords(matched)
+		matches = append(matches, match)
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to match keywords")
+		return nil, fmt.Errorf("could not match keywords: %w", err)
+	}
+	rows.Close()
+
+	ads := make([]Ad, len(matches))
+	for i := range matches {
+		ads[i] = matches[i].Ad
+	}
+	if err := r.attachVariants(ads, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve variants")
+		return nil, err
+	}
+	if err := attachTranslations(r.DB, ads, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve translations")
+		return nil, err
+	}
+	for i := range matches {
+		matches[i].Ad = ads[i]
+	}
+
+	span.SetAttributes(attribute.Int("matches_count", len(matches)), attribute.Int("overlap", best), attribute.String("status", "success"))
+	return matches, nil
+}
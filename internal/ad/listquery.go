@@ -0,0 +1,187 @@
+/*
+This file builds the SQL of the ad list queries. A ListQuery holds the filters, sort and page of
+a listing, and build turns it into the query and its arguments. Conditions and their arguments
+are collected together, so the placeholders always line up with the values whatever filters are
+set, and the sort column comes from a whitelist here rather than from the request.
+*/
+package ad
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidSort is returned when a list query asks for a sort field or order that isn't allowed
+var ErrInvalidSort = errors.New("invalid sort")
+
+// sortColumns maps the sort fields of a listing to their columns. Radius searches are always
+// sorted by distance instead, and text searches may also be sorted by relevance.
+var sortColumns = map[string]string{
+	"id":         "id",
+	"title":      "title",
+	"price":      "price",
+	"created_at": "created_at",
+	"renewed_at": "renewed_at",
+	"is_active":  "is_active",
+	"status":     "status",
+}
+
+// sortable reports whether a listing can be sorted by field
+func sortable(field string) bool {
+	_, ok := sortColumns[field]
+	return ok
+}
+
+// ListFilter narrows the ads returned by GetAllAds; zero values mean no filter, except that
+// archived ads are only listed, exclusively, when Archived is set
+type ListFilter struct {
+	Status        string
+	OwnerID       string
+	Archived      bool
+	CampaignID    int
+	Category      string
+	AfterID       int64      // only ads with a higher ID, for paging through ads in ID order
+	TitleContains string     // matched literally anywhere in the title
+	Near          *GeoFilter // radius search; SortBy must then be "distance"
+	Search        string     // text search in the title and description
+	SearchEngine  string     // SearchLike or SearchFullText, the engine answering Search
+}
+
+// ListQuery is one page of a listing
+type ListQuery struct {
+	Filter ListFilter
+	SortBy string // a key of sortColumns, "distance" for a radius search or "relevance" for a text search
+	Order  string // asc or desc
+	Page   int
+	Limit  int
+}
+
+// clause is a list of conditions joined with AND, with the arguments of their placeholders in order
+type clause struct {
+	conditions []string
+	args       []interface{}
+}
+
+// add appends a condition and the arguments of its placeholders
+func (c *clause) add(condition string, args ...interface{}) {
+	c.conditions = append(c.conditions, condition)
+	c.args = append(c.args, args...)
+}
+
+// where is the WHERE clause, empty without conditions
+func (c clause) where() string {
+	if len(c.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(c.conditions, " AND ")
+}
+
+// clause returns the conditions of the filter within the tenant's ads
+func (f ListFilter) clause(tenantID string) clause {
+	var c clause
+	c.add(tenantCondition, tenantID)
+	if f.Archived {
+		c.add("archived_at IS NOT NULL")
+	} else {
+		c.add("archived_at IS NULL")
+	}
+	if f.Status != "" {
+		c.add("status = ?", f.Status)
+	}
+	if f.OwnerID != "" {
+		c.add("owner_id = ?", f.OwnerID)
+	}
+	if f.CampaignID != 0 {
+		c.add("campaign_id = ?", f.CampaignID)
+	}
+	if f.Category != "" {
+		c.add("category = ?", f.Category)
+	}
+	if f.AfterID != 0 {
+		c.add("id > ?", f.AfterID)
+	}
+	if f.TitleContains != "" {
+		// The leading wildcard can't use the title index, the other conditions narrow the scan
+		c.add("title LIKE ?", "%"+escapeLike(f.TitleContains)+"%")
+	}
+	switch {
+	case f.Search == "":
+	case f.SearchEngine == SearchFullText:
+		c.add(scoreColumn, f.Search)
+	default:
+		// Like title_contains, the phrase is matched literally and the leading wildcard scans
+		pattern := "%" + escapeLike(f.Search) + "%"
+		c.add("(title LIKE ? OR description LIKE ?)", pattern, pattern)
+	}
+	if f.Near != nil {
+		// The indexed bounding box prefilters a radius search, the exact distance is kept by HAVING
+		box, boxArgs := f.Near.boundingBox()
+		c.add(box, boxArgs...)
+	}
+	return c
+}
+
+// orderBy returns the ORDER BY clause of the query, checking the sort against the whitelist
+func (q ListQuery) orderBy() (string, error) {
+	if q.Order != "asc" && q.Order != "desc" {
+		return "", fmt.Errorf("%w: order %q", ErrInvalidSort, q.Order)
+	}
+	if q.Filter.Near != nil {
+		if q.SortBy != "distance" {
+			return "", fmt.Errorf("%w: a radius search is sorted by distance, not %q", ErrInvalidSort, q.SortBy)
+		}
+		return " ORDER BY distance_km " + q.Order + ", id " + q.Order, nil
+	}
+	if q.SortBy == "relevance" {
+		// Without a score the LIKE engine has no ranking and lists matches by ID
+		switch {
+		case q.Filter.Search == "":
+			return "", fmt.Errorf("%w: sort_by relevance needs a text search", ErrInvalidSort)
+		case q.Filter.SearchEngine == SearchFullText:
+			return " ORDER BY score " + q.Order + ", id " + q.Order, nil
+		default:
+			return " ORDER BY id " + q.Order, nil
+		}
+	}
+	column, ok := sortColumns[q.SortBy]
+	if !ok {
+		return "", fmt.Errorf("%w: sort_by %q", ErrInvalidSort, q.SortBy)
+	}
+	return " ORDER BY " + column + " " + q.Order, nil
+}
+
+// build returns the SQL of the query on the tenant's ads and its arguments. A radius search
+// selects its distance ahead of the ad columns, and a full-text search then its score.
+func (q ListQuery) build(tenantID string) (string, []interface{}, error) {
+	orderBy, err := q.orderBy()
+	if err != nil {
+		return "", nil, err
+	}
+
+	var query strings.Builder
+	var args []interface{}
+	query.WriteString("SELECT ")
+	if q.Filter.Near != nil {
+		query.WriteString(distanceColumn + " AS distance_km, ")
+		args = append(args, q.Filter.Near.distanceParams()...)
+	}
+	if q.Filter.Search != "" && q.Filter.SearchEngine == SearchFullText {
+		query.WriteString(scoreColumn + " AS score, ")
+		args = append(args, q.Filter.Search)
+	}
+	query.WriteString(adColumns + " FROM ads")
+
+	filter := q.Filter.clause(tenantID)
+	query.WriteString(filter.where())
+	args = append(args, filter.args...)
+
+	if q.Filter.Near != nil {
+		query.WriteString(" HAVING distance_km <= ?")
+		args = append(args, q.Filter.Near.RadiusKm)
+	}
+
+	query.WriteString(orderBy + " LIMIT ? OFFSET ?")
+	args = append(args, q.Limit, (q.Page-1)*q.Limit)
+	return query.String(), args, nil
+}
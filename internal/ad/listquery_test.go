@@ -0,0 +1,106 @@
+package ad
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestListQueryBuild(t *testing.T) {
+	near := &GeoFilter{Latitude: 52.52, Longitude: 13.405, RadiusKm: 5}
+	box, boxArgs := near.boundingBox()
+	distance := []interface{}{52.52, 52.52, 13.405}
+	tests := []struct {
+		name  string
+		query ListQuery
+		sql   string
+		args  []interface{}
+	}{
+		{
+			"no filters",
+			ListQuery{SortBy: "renewed_at", Order: "desc", Page: 1, Limit: 10},
+			"SELECT " + adColumns + " FROM ads WHERE tenant_id = ? AND archived_at IS NULL ORDER BY renewed_at desc LIMIT ? OFFSET ?",
+			[]interface{}{"default", 10, 0},
+		},
+		{
+			"every equality filter",
+			ListQuery{Filter: ListFilter{Status: StatusApproved, OwnerID: "u1", CampaignID: 3, Category: "bikes", AfterID: 40}, SortBy: "price", Order: "asc", Page: 3, Limit: 20},
+			"SELECT " + adColumns + " FROM ads WHERE tenant_id = ? AND archived_at IS NULL AND status = ? AND owner_id = ? AND campaign_id = ? AND category = ? AND id > ? ORDER BY price asc LIMIT ? OFFSET ?",
+			[]interface{}{"default", StatusApproved, "u1", 3, "bikes", int64(40), 20, 40},
+		},
+		{
+			"archived",
+			ListQuery{Filter: ListFilter{Archived: true, OwnerID: "u1"}, SortBy: "id", Order: "asc", Page: 1, Limit: 5},
+			"SELECT " + adColumns + " FROM ads WHERE tenant_id = ? AND archived_at IS NOT NULL AND owner_id = ? ORDER BY id asc LIMIT ? OFFSET ?",
+			[]interface{}{"default", "u1", 5, 0},
+		},
+		{
+			"title and LIKE search",
+			ListQuery{Filter: ListFilter{TitleContains: "50%", Search: "red_bike", SearchEngine: SearchLike}, SortBy: "relevance", Order: "desc", Page: 1, Limit: 10},
+			"SELECT " + adColumns + " FROM ads WHERE tenant_id = ? AND archived_at IS NULL AND title LIKE ? AND (title LIKE ? OR description LIKE ?) ORDER BY id desc LIMIT ? OFFSET ?",
+			[]interface{}{"default", `%50\%%`, `%red\_bike%`, `%red\_bike%`, 10, 0},
+		},
+		{
+			"full-text search",
+			ListQuery{Filter: ListFilter{Status: StatusApproved, Search: "red bike", SearchEngine: SearchFullText}, SortBy: "relevance", Order: "desc", Page: 2, Limit: 10},
+			"SELECT " + scoreColumn + " AS score, " + adColumns + " FROM ads WHERE tenant_id = ? AND archived_at IS NULL AND status = ? AND " + scoreColumn + " ORDER BY score desc, id desc LIMIT ? OFFSET ?",
+			[]interface{}{"red bike", "default", StatusApproved, "red bike", 10, 10},
+		},
+		{
+			"full-text search sorted by a column",
+			ListQuery{Filter: ListFilter{Search: "red bike", SearchEngine: SearchFullText}, SortBy: "created_at", Order: "asc", Page: 1, Limit: 10},
+			"SELECT " + scoreColumn + " AS score, " + adColumns + " FROM ads WHERE tenant_id = ? AND archived_at IS NULL AND " + scoreColumn + " ORDER BY created_at asc LIMIT ? OFFSET ?",
+			[]interface{}{"red bike", "default", "red bike", 10, 0},
+		},
+		{
+			"radius search",
+			ListQuery{Filter: ListFilter{Status: StatusApproved, Category: "bikes", Near: near}, SortBy: "distance", Order: "asc", Page: 1, Limit: 10},
+			"SELECT " + distanceColumn + " AS distance_km, " + adColumns + " FROM ads WHERE tenant_id = ? AND archived_at IS NULL AND status = ? AND category = ? AND " + box + " HAVING distance_km <= ? ORDER BY distance_km asc, id asc LIMIT ? OFFSET ?",
+			append(append(append(distance, "default", StatusApproved, "bikes"), boxArgs...), 5.0, 10, 0),
+		},
+		{
+			"radius and full-text search",
+			ListQuery{Filter: ListFilter{Near: near, Search: "bike", SearchEngine: SearchFullText}, SortBy: "distance", Order: "desc", Page: 1, Limit: 10},
+			"SELECT " + distanceColumn + " AS distance_km, " + scoreColumn + " AS score, " + adColumns + " FROM ads WHERE tenant_id = ? AND archived_at IS NULL AND " + scoreColumn + " AND " + box + " HAVING distance_km <= ? ORDER BY distance_km desc, id desc LIMIT ? OFFSET ?",
+			append(append(append(distance, "bike", "default", "bike"), boxArgs...), 5.0, 10, 0),
+		},
+	}
+	for _, tt := range tests {
+		sql, args, err := tt.query.build("default")
+		if err != nil {
+			t.Errorf("%s: build: %v", tt.name, err)
+			continue
+		}
+		if sql != tt.sql {
+			t.Errorf("%s: SQL =\n%s\nwant\n%s", tt.name, sql, tt.sql)
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s: args = %#v, want %#v", tt.name, args, tt.args)
+		}
+	}
+}
+
+func TestListQuerySortWhitelist(t *testing.T) {
+	tests := []struct {
+		name  string
+		query ListQuery
+	}{
+		{"unknown field", ListQuery{SortBy: "owner_id", Order: "asc"}},
+		{"injected field", ListQuery{SortBy: "id; DROP TABLE ads", Order: "asc"}},
+		{"injected order", ListQuery{SortBy: "id", Order: "asc, (SELECT 1)"}},
+		{"no order", ListQuery{SortBy: "id"}},
+		{"distance without a radius", ListQuery{SortBy: "distance", Order: "asc"}},
+		{"radius sorted by a column", ListQuery{Filter: ListFilter{Near: &GeoFilter{RadiusKm: 1}}, SortBy: "price", Order: "asc"}},
+		{"relevance without a search", ListQuery{SortBy: "relevance", Order: "desc"}},
+	}
+	for _, tt := range tests {
+		if sql, _, err := tt.query.build("default"); !errors.Is(err, ErrInvalidSort) {
+			t.Errorf("%s: build = %q, %v; want ErrInvalidSort", tt.name, sql, err)
+		}
+	}
+	for field := range sortColumns {
+		if _, _, err := (ListQuery{SortBy: field, Order: "asc", Page: 1, Limit: 1}).build("default"); err != nil || !sortable(field) {
+			t.Errorf("sort by %s = %v, want it allowed", field, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+/*
+This file holds the search outbox. With Repository.SearchOutbox set, every write that changes how
+an ad shows up in search records the ad in search_outbox in the same transaction, so the search
+indexer catches up from the table and a search backend that is down never fails a write. Rows
+only name the ad: the indexer reads its current state, so several changes to one ad collapse
+into one update.
+*/
+package ad
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// OutboxEntry is one row of search_outbox: an ad of a tenant whose search document is out of date
+type OutboxEntry struct {
+	ID       int64
+	TenantID string
+	AdID     int64
+}
+
+// execer runs a statement, on the database or inside a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// enqueueSearch records the tenant's ads in the search outbox, a no-op unless r.SearchOutbox is set
+func (r *Repository) enqueueSearch(db execer, tenantID string, ids []int64, ctx context.Context) error {
+	if !r.SearchOutbox || len(ids) == 0 {
+		return nil
+	}
+	query := "INSERT INTO search_outbox (tenant_id, ad_id) VALUES (?, ?)" + strings.Repeat(", (?, ?)", len(ids)-1)
+	params := make([]interface{}, 0, len(ids)*2)
+	for _, id := range ids {
+		params = append(params, tenantID, id)
+	}
+	if _, err := db.ExecContext(ctx, query, params...); err != nil {
+		return fmt.Errorf("could not queue search update: %w", err)
+	}
+	return nil
+}
+
+// PendingSearchUpdates returns up to limit outbox rows of every tenant, oldest first, with tracing
+func (r *Repository) PendingSearchUpdates(limit int, ctx context.Context) (_ []OutboxEntry, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "PendingSearchUpdatesRepository")
+	defer span.End()
+	defer observeQuery("pending_search_updates", time.Now(), &err, ctx)
+
+	rows, err := r.DB.QueryContext(ctx, "SELECT id, tenant_id, ad_id FROM search_outbox ORDER BY id LIMIT ?", limit)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to read search outbox")
+		return nil, fmt.Errorf("could not read search outbox: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []OutboxEntry
+	for rows.Next() {
+		var entry OutboxEntry
+		if err := rows.Scan(&entry.ID, &entry.TenantID, &entry.AdID); err != nil {
+			span.RecordError(err)
+			return nil, fmt.Errorf("could not scan search outbox: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("could not read search outbox: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("entries_count", len(entries)))
+	return entries, nil
+}
+
+// CountSearchUpdates returns the number of outbox rows of every tenant, with tracing: the
+// backlog of the search indexer
+func (r *Repository) CountSearchUpdates(ctx context.Context) (_ int, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "CountSearchUpdatesRepository")
+	defer span.End()
+	defer observeQuery("count_search_updates", time.Now(), &err, ctx)
+
+	var count int
+	if err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM search_outbox").Scan(&count); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to count search outbox")
+		return 0, fmt.Errorf("could not count search outbox: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("entries_count", count))
+	return count, nil
+}
+
+// AckSearchUpdates removes handled outbox rows by ID, with tracing. Rows are named one by one
+// because a transaction still open when the batch was read can commit a lower ID afterwards.
+func (r *Repository) AckSearchUpdates(ids []int64, ctx context.Context) (err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "AckSearchUpdatesRepository")
+	defer span.End()
+	defer observeQuery("ack_search_updates", time.Now(), &err, ctx)
+
+	if len(ids) == 0 {
+		return nil
+	}
+	params := make([]interface{}, len(ids))
+	for i, id := range ids {
+		params[i] = id
+	}
+	query := "DELETE FROM search_outbox WHERE id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")"
+	if _, err := r.DB.ExecContext(ctx, query, params...); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to acknowledge search updates")
+		return fmt.Errorf("could not acknowledge search updates: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("entries_count", len(ids)))
+	return nil
+}
+
+// SearchableAds returns up to limit of the tenant's ads that belong in the search index, approved
+// and not archived, with IDs above afterID in ID order, with tracing. Reindexing pages through
+// them with the last ID of each batch.
+func (r *Repository) SearchableAds(afterID int64, limit int, ctx context.Context) ([]Ad, error) {
+	return r.GetAllAds(ListQuery{
+		Filter: ListFilter{Status: StatusApproved, AfterID: afterID},
+		SortBy: "id",
+		Order:  "asc",
+		Page:   1,
+		Limit:  limit,
+	}, ctx)
+}
+
+// Searchable reports whether the ad belongs in the search index, the same ads the public listing shows
+func (a *Ad) Searchable() bool {
+	return a.Status == StatusApproved && a.ArchivedAt == nil
+}
@@ -0,0 +1,218 @@
+/*
+This file purges the service's cache entries on demand, e.g. after a manual database fix. Every
+purge stays inside the cache namespace, is bounded by cache.purgeMaxDuration and is recorded in
+the cache_purge_log table before it runs.
+*/
+package ad
+
+import (
+	"ad_service/pkg/cache"
+	"ad_service/pkg/tenant"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Cache purge modes
+const (
+	PurgeModeIDs    = "ids"
+	PurgeModePrefix = "prefix"
+	PurgeModeAll    = "all"
+)
+
+// PurgeRequest selects what POST /admin/cache/purge deletes; exactly one field is set
+type PurgeRequest struct {
+	IDs    []int64 `json:"ids"`
+	Prefix string  `json:"prefix"` // relative to the namespace, e.g. "ads:list:"
+	All    bool    `json:"all"`
+}
+
+// Mode returns the purge mode of the request, or "" unless exactly one is selected
+func (r PurgeRequest) Mode() string {
+	modes := []string{}
+	if r.IDs != nil {
+		modes = append(modes, PurgeModeIDs)
+	}
+	if r.Prefix != "" {
+		modes = append(modes, PurgeModePrefix)
+	}
+	if r.All {
+		modes = append(modes, PurgeModeAll)
+	}
+	if len(modes) != 1 {
+		return ""
+	}
+	return modes[0]
+}
+
+// target describes what the request purges, for the audit log
+func (r PurgeRequest) target() string {
+	switch r.Mode() {
+	case PurgeModeIDs:
+		ids := make([]string, len(r.IDs))
+		for i, id := range r.IDs {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		return strings.Join(ids, ",")
+	case PurgeModePrefix:
+		return r.Prefix
+	}
+	return ""
+}
+
+// PurgeResult is the outcome of a purge. Truncated is set when cache.purgeMaxDuration ran out
+// before the whole keyspace was scanned; the keys left behind expire on their TTL.
+type PurgeResult struct {
+	Mode      string `json:"mode"`
+	Deleted   int    `json:"deleted"`
+	Truncated bool   `json:"truncated"`
+}
+
+// PurgeCache deletes the cache entries selected by the request on behalf of actor, with tracing.
+// The purge is audited before it starts, so a failed audit write stops it. The in-process serve
+// snapshots of every replica are dropped afterwards, whatever the mode.
+func (s *AdService) PurgeCache(req PurgeRequest, actor string, ctx context.Context) (*PurgeResult, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "PurgeCacheService")
+	defer span.End()
+
+	result := &PurgeResult{Mode: req.Mode()}
+	span.SetAttributes(attribute.String("mode", result.Mode), attribute.String("actor", actor))
+
+	auditID, err := s.Repo.StartCachePurge(actor, result.Mode, req.target(), ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to audit cache purge")
+		return nil, err
+	}
+
+	purgeCtx, cancel := context.WithTimeout(ctx, s.TTL.Load().PurgeMaxDuration)
+	defer cancel()
+	switch result.Mode {
+	case PurgeModeIDs:
+		result.Deleted, err = s.purgeAds(req.IDs, purgeCtx)
+	case PurgeModePrefix:
+		result.Deleted, err = s.purgePrefix(req.Prefix, purgeCtx)
+	case PurgeModeAll:
+		result.Deleted, err = s.purgePrefix("", purgeCtx)
+	}
+	result.Truncated = purgeCtx.Err() != nil
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to purge cache")
+	}
+
+	// The list pages and serve snapshots may hold the purged ads, so they go in every mode
+	s.invalidateLists(ctx)
+	s.evictEverywhere([]string{serveSnapshotKey}, ctx)
+
+	if auditErr := s.Repo.FinishCachePurge(auditID, result, ctx); auditErr != nil {
+		span.RecordError(auditErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("deleted", result.Deleted), attribute.Bool("truncated", result.Truncated), attribute.String("status", "success"))
+	return result, nil
+}
+
+// purgeAds deletes the cached copies of the given ads and their similar ads, and returns how
+// many keys existed
+func (s *AdService) purgeAds(ids []int64, ctx context.Context) (int, error) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = adCacheKey(id)
+	}
+	found, err := s.cache().GetMany(keys, ctx)
+	if err != nil {
+		return 0, fmt.Errorf("could not look up cached ads: %w", err)
+	}
+	for _, key := range keys {
+		if err := s.cache().Delete(key, ctx); err != nil {
+			return 0, fmt.Errorf("could not delete cached ad: %w", err)
+		}
+	}
+	s.evictEverywhere(keys, ctx)
+
+	deleted := len(found)
+	for _, id := range ids {
+		n, err := s.cache().DeleteByPrefix(cache.Key("ads", "similar", strconv.FormatInt(id, 10), ""), ctx)
+		deleted += n
+		if err != nil {
+			return deleted, fmt.Errorf("could not delete cached similar ads: %w", err)
+		}
+	}
+	return deleted, nil
+}
+
+// purgePrefix deletes the keys starting with prefix under the namespace. A single SCAN pass is
+// capped, so passes are repeated until one deletes nothing or the deadline on ctx passes.
+func (s *AdService) purgePrefix(prefix string, ctx context.Context) (int, error) {
+	deleted := 0
+	for ctx.Err() == nil {
+		n, err := s.cache().DeleteByPrefix(prefix, ctx)
+		deleted += n
+		if err != nil {
+			return deleted, fmt.Errorf("could not delete keys by prefix: %w", err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+	return deleted, nil
+}
+
+// StartCachePurge records who is purging what before the purge runs, and returns the ID of the
+// audit row, with tracing
+func (r *Repository) StartCachePurge(actor, mode, target string, ctx context.Context) (_ int64, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "StartCachePurgeRepository")
+	defer span.End()
+	defer observeQuery("start_cache_purge", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return 0, err
+	}
+	query := "INSERT INTO cache_purge_log (tenant_id, actor, mode, target) VALUES (?, ?, ?, ?)"
+	result, err := r.DB.ExecContext(ctx, query, tenantID, actor, mode, target)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to insert cache purge audit entry")
+		return 0, fmt.Errorf("could not insert cache purge audit entry: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		span.RecordError(err)
+		return 0, fmt.Errorf("could not retrieve cache purge audit entry ID: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int64("audit_id", id), attribute.String("status", "success"))
+	return id, nil
+}
+
+// FinishCachePurge records the outcome of a purge on its audit row, with tracing. For a purge
+// that failed, it is the number of keys deleted before the failure.
+func (r *Repository) FinishCachePurge(id int64, result *PurgeResult, ctx context.Context) (err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "FinishCachePurgeRepository")
+	defer span.End()
+	defer observeQuery("finish_cache_purge", time.Now(), &err, ctx)
+
+	query := "UPDATE cache_purge_log SET deleted = ?, truncated = ?, finished_at = CURRENT_TIMESTAMP WHERE id = ?"
+	if _, err := r.DB.ExecContext(ctx, query, result.Deleted, result.Truncated, id); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to update cache purge audit entry")
+		return fmt.Errorf("could not update cache purge audit entry: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int64("audit_id", id), attribute.String("status", "success"))
+	return nil
+}
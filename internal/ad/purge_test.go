@@ -0,0 +1,210 @@
+package ad
+
+import (
+	"ad_service/pkg/cache"
+	"ad_service/pkg/middleware"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// testNamespace is the cache namespace of the purge tests, as cache.New builds it
+var testNamespace = cache.Namespace("adsvc:test:")
+
+// foreignKeys are outside the namespace or the default tenant, and survive every purge
+var foreignKeys = []string{
+	"other-service:ad:1",
+	cache.Namespace("adsvc:staging:") + "t:default:ad:1",
+	"adsvc:test:v1:t:default:ad:1",
+	testNamespace + "t:acme:ad:1",
+	testNamespace + "t:acme:ads:list:5:page",
+}
+
+// newPurgeService returns a test service whose cache is Redis under testNamespace, seeded with
+// entries of the default tenant and foreignKeys
+func newPurgeService(t *testing.T, maxDuration time.Duration) (*AdService, sqlmock.Sqlmock, *miniredis.Miniredis) {
+	t.Helper()
+	service, mock := newTestService(t)
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	service.Cache = cache.NewTenantCache(cache.NewPrefixedCache(&cache.RedisCache{Client: client}, testNamespace))
+	cfg := testCacheConfig
+	cfg.PurgeMaxDuration = maxDuration
+	service.TTL.Store(cfg)
+
+	for _, key := range []string{"ad:1", "ad:2", "ad:3", "ads:similar:1:5", "ads:list:5:page1", "ads:list:5:page2", serveSnapshotKey} {
+		server.Set(testNamespace+"t:default:"+key, "cached")
+	}
+	for _, key := range foreignKeys {
+		server.Set(key, "keep")
+	}
+	return service, mock, server
+}
+
+// expectPurgeAudit expects the audit row of a purge by admin-user to be written and completed
+func expectPurgeAudit(mock sqlmock.Sqlmock, mode, target string, deleted int, truncated bool) {
+	mock.ExpectExec("INSERT INTO cache_purge_log").WithArgs("default", "admin-user", mode, target).WillReturnResult(sqlmock.NewResult(11, 1))
+	mock.ExpectExec("UPDATE cache_purge_log SET deleted = \\?, truncated = \\?, finished_at = CURRENT_TIMESTAMP WHERE id = \\?").
+		WithArgs(deleted, truncated, int64(11)).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// tenantKeys returns the keys left in the namespace for the default tenant, without it
+func tenantKeys(server *miniredis.Miniredis) []string {
+	prefix := testNamespace + "t:default:"
+	var keys []string
+	for _, key := range server.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, strings.TrimPrefix(key, prefix))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestPurgeCacheModes(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     PurgeRequest
+		mode    string
+		target  string
+		deleted int
+		// left are the keys of the tenant after the purge; the list generation is always bumped
+		// and the serve snapshot always dropped
+		left string
+	}{
+		// 9 isn't cached, and the similar ads of 1 go with it
+		{"ids", PurgeRequest{IDs: []int64{1, 2, 9}}, PurgeModeIDs, "1,2,9", 3, "[ad:3 ads:list:5:page1 ads:list:5:page2 ads:list_generation]"},
+		{"prefix", PurgeRequest{Prefix: "ads:list:"}, PurgeModePrefix, "ads:list:", 2, "[ad:1 ad:2 ad:3 ads:list_generation ads:similar:1:5]"},
+		{"all", PurgeRequest{All: true}, PurgeModeAll, "", 7, "[ads:list_generation]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			previous := otel.GetTracerProvider()
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+			t.Cleanup(func() { otel.SetTracerProvider(previous) })
+			service, mock, server := newPurgeService(t, 10*time.Second)
+			expectPurgeAudit(mock, tt.mode, tt.target, tt.deleted, false)
+
+			result, err := service.PurgeCache(tt.req, "admin-user", testCtx())
+			if err != nil {
+				t.Fatalf("PurgeCache: %v", err)
+			}
+			if *result != (PurgeResult{Mode: tt.mode, Deleted: tt.deleted}) {
+				t.Errorf("result = %+v, want %s deleting %d", *result, tt.mode, tt.deleted)
+			}
+			if left := strings.Join(tenantKeys(server), " "); "["+left+"]" != tt.left {
+				t.Errorf("left [%s], want %s", left, tt.left)
+			}
+			// Nothing outside the namespace and the tenant is touched, whatever the mode
+			for _, key := range foreignKeys {
+				if !server.Exists(key) {
+					t.Errorf("%s outside the purge scope was deleted", key)
+				}
+			}
+
+			var attributes []attribute.KeyValue
+			for _, span := range recorder.Ended() {
+				if span.Name() == "PurgeCacheService" {
+					attributes = span.Attributes()
+				}
+			}
+			want := map[attribute.Key]attribute.Value{
+				"mode":    attribute.StringValue(tt.mode),
+				"actor":   attribute.StringValue("admin-user"),
+				"deleted": attribute.IntValue(tt.deleted),
+			}
+			for _, kv := range attributes {
+				if value, ok := want[kv.Key]; ok && value == kv.Value {
+					delete(want, kv.Key)
+				}
+			}
+			if len(want) > 0 {
+				t.Errorf("PurgeCacheService span lacks %v: %v", want, attributes)
+			}
+		})
+	}
+}
+
+func TestPurgeCacheIsBoundedInTime(t *testing.T) {
+	// The deadline has passed before the first SCAN batch: the purge is recorded as truncated
+	service, mock, server := newPurgeService(t, time.Nanosecond)
+	expectPurgeAudit(mock, PurgeModeAll, "", 0, true)
+
+	result, err := service.PurgeCache(PurgeRequest{All: true}, "admin-user", testCtx())
+	if err != nil {
+		t.Fatalf("PurgeCache: %v", err)
+	}
+	if !result.Truncated || result.Deleted != 0 {
+		t.Errorf("result = %+v, want a truncated purge", *result)
+	}
+	if keys := tenantKeys(server); len(keys) < 5 {
+		t.Errorf("keys left = %v, want the cached ads and pages", keys)
+	}
+}
+
+func TestPurgeCacheWithoutAudit(t *testing.T) {
+	service, mock, server := newPurgeService(t, 10*time.Second)
+	before := len(server.Keys())
+	mock.ExpectExec("INSERT INTO cache_purge_log").WillReturnError(errors.New("connection reset"))
+
+	if _, err := service.PurgeCache(PurgeRequest{All: true}, "admin-user", testCtx()); err == nil {
+		t.Fatal("PurgeCache succeeded without its audit entry")
+	}
+	if after := len(server.Keys()); after != before {
+		t.Errorf("%d keys left of %d, want the purge not to run", after, before)
+	}
+}
+
+func TestPurgeCacheRequests(t *testing.T) {
+	service, mock, _ := newPurgeService(t, 10*time.Second)
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) {
+		r.POST("/admin/cache/purge", middleware.RequireAdmin(), h.PurgeCache)
+	})
+	admin := []string{testUserHeader, "admin-user", testRoleHeader, "admin"}
+
+	tests := []struct {
+		name    string
+		body    string
+		message string
+	}{
+		{"no mode", `{}`, "Exactly one of ids, prefix or all must be given"},
+		{"two modes", `{"ids":[1],"all":true}`, "Exactly one of ids, prefix or all must be given"},
+		{"blank prefix", `{"prefix":"  "}`, "Exactly one of ids, prefix or all must be given"},
+		{"no ids", `{"ids":[]}`, "Invalid ids value. Must list at least one ID."},
+		{"negative id", `{"ids":[1,-2]}`, "Invalid ids value. Must be positive integers."},
+		{"too many ids", `{"ids":[` + strings.Repeat("1,", 100) + `1]}`, "Too many ids. At most 100 are allowed."},
+		{"long prefix", `{"prefix":"` + strings.Repeat("a", maxPrefixLength+1) + `"}`, "Invalid prefix value. Must be at most 200 characters."},
+		{"invalid JSON", `{"all":`, "Invalid request body"},
+	}
+	for _, tt := range tests {
+		w := serve(r, http.MethodPost, "/admin/cache/purge", strings.NewReader(tt.body), admin...)
+		if w.Code != http.StatusBadRequest || errorMessage(t, w.Body.Bytes()) != tt.message {
+			t.Errorf("%s: %d %s, want 400 %q", tt.name, w.Code, w.Body.String(), tt.message)
+		}
+	}
+
+	if w := serve(r, http.MethodPost, "/admin/cache/purge", strings.NewReader(`{"all":true}`), testUserHeader, "user-1"); w.Code != http.StatusForbidden {
+		t.Errorf("purge by a non-admin = %d, want 403", w.Code)
+	}
+
+	expectPurgeAudit(mock, PurgeModePrefix, "ads:list:", 2, false)
+	w := serve(r, http.MethodPost, "/admin/cache/purge", strings.NewReader(`{"prefix":" ads:list: "}`), admin...)
+	if want := `{"data":{"mode":"prefix","deleted":2,"truncated":false},"meta":{}}`; w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("purge = %d %s, want 200 %s", w.Code, w.Body.String(), want)
+	}
+}
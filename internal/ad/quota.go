@@ -0,0 +1,227 @@
+/*
+This file enforces the per-owner creation quota. An owner may hold ads.maxActivePerOwner ads that
+still count as theirs on the market, read with a COUNT query, and create ads.maxCreationsPerDay
+ads in a rolling 24 hours, counted in hourly Redis buckets that expire once the window has passed
+them. Admins and ads without an owner are exempt. Concurrent creations of one owner are checked
+independently, so a burst can go over a limit by the number of requests in flight.
+*/
+package ad
+
+import (
+	"ad_service/internal/apperr"
+	"ad_service/pkg/cache"
+	"ad_service/pkg/metrics"
+	"ad_service/pkg/tenant"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Quota limits, reported as the limit of a QuotaError and in ads_quota_rejections_total
+const (
+	QuotaActive = "active"
+	QuotaDaily  = "daily"
+)
+
+// Errors matched by the error mapping: too many active ads is a conflict the owner resolves by
+// archiving or deleting one, too many creations is throttled until the window moves on
+var (
+	ErrActiveQuota = apperr.New(apperr.ErrConflict, "Active ad quota reached")
+	ErrDailyQuota  = errors.New("Daily ad creation quota reached")
+)
+
+// quotaBucket is the span of one creation counter; the rolling window is quotaWindow/quotaBucket of them
+const quotaBucket = time.Hour
+
+// quotaWindow is the rolling window of ads.maxCreationsPerDay
+const quotaWindow = 24 * time.Hour
+
+// QuotaError is returned when an owner is over one of the creation limits
+type QuotaError struct {
+	Limit   string // QuotaActive or QuotaDaily
+	Max     int
+	ResetAt *time.Time // when the daily window allows a creation again, nil for the active limit
+}
+
+func (e *QuotaError) Error() string {
+	if e.ResetAt == nil {
+		return fmt.Sprintf("%s ad quota of %d reached", e.Limit, e.Max)
+	}
+	return fmt.Sprintf("%s ad quota of %d reached, resets at %s", e.Limit, e.Max, e.ResetAt.Format(time.RFC3339))
+}
+
+func (e *QuotaError) Unwrap() error {
+	if e.Limit == QuotaDaily {
+		return ErrDailyQuota
+	}
+	return ErrActiveQuota
+}
+
+// ClientMessage is the message of the limit that was reached
+func (e *QuotaError) ClientMessage() string {
+	if e.Limit == QuotaDaily {
+		return fmt.Sprintf("At most %d ads can be created per 24 hours", e.Max)
+	}
+	return fmt.Sprintf("At most %d active ads are allowed per user, archive or delete one first", e.Max)
+}
+
+// ClientDetails names the limit, its value and, for the daily limit, when it resets
+func (e *QuotaError) ClientDetails() map[string]any {
+	details := map[string]any{"limit": e.Limit, "max": e.Max}
+	if e.ResetAt != nil {
+		details["reset_at"] = *e.ResetAt
+	}
+	return details
+}
+
+// RetryAfter is the wait until the daily window allows a creation again
+func (e *QuotaError) RetryAfter(now time.Time) time.Duration {
+	if e.ResetAt == nil {
+		return 0
+	}
+	return e.ResetAt.Sub(now)
+}
+
+// creationCountKey is the key counting the ads the owner created in the hour starting at start
+func creationCountKey(ownerID string, start time.Time) string {
+	return cache.Key("quota", "created", ownerID, start.UTC().Format("2006010215"))
+}
+
+// creationBuckets returns the start of every hourly bucket of the rolling window ending at now,
+// oldest first; the current, partial hour is the last one
+func creationBuckets(now time.Time) []time.Time {
+	current := now.UTC().Truncate(quotaBucket)
+	count := int(quotaWindow / quotaBucket)
+	buckets := make([]time.Time, count)
+	for i := range buckets {
+		buckets[i] = current.Add(-time.Duration(count-1-i) * quotaBucket)
+	}
+	return buckets
+}
+
+// quotaOwner returns the owner the quota of a new ad applies to, and false when it is exempt
+func quotaOwner(ad *Ad, admin bool) (string, bool) {
+	if admin || ad.OwnerID == nil || *ad.OwnerID == "" {
+		return "", false
+	}
+	return *ad.OwnerID, true
+}
+
+// checkQuota returns a *QuotaError when the owner may not create another ad. A limit of 0 is
+// disabled. The daily limit is not enforced while its counters can't be read, like the rest of
+// the cache, and a failed active count fails the creation.
+func (s *AdService) checkQuota(ownerID string, ctx context.Context) error {
+	span := trace.SpanFromContext(ctx)
+
+	if limit := s.Rules.Load().MaxActivePerOwner; limit > 0 {
+		active, err := s.Repo.CountActiveByOwner(ownerID, ctx)
+		if err != nil {
+			return err
+		}
+		if active >= limit {
+			metrics.AdQuotaRejections.WithLabelValues(QuotaActive).Inc()
+			return &QuotaError{Limit: QuotaActive, Max: limit}
+		}
+	}
+
+	limit := s.Rules.Load().MaxCreationsPerDay
+	if limit <= 0 {
+		return nil
+	}
+	now := time.Now()
+	buckets := creationBuckets(now)
+	keys := make([]string, len(buckets))
+	for i, start := range buckets {
+		keys[i] = creationCountKey(ownerID, start)
+	}
+	counts, err := s.cache().GetMany(keys, ctx)
+	if err != nil {
+		span.RecordError(err)
+		return nil
+	}
+
+	// Buckets leave the window oldest first, so the creation that brings the owner back under the
+	// limit is in the bucket where the running total from the newest one reaches it
+	created := 0
+	var resetAt time.Time
+	for i := len(buckets) - 1; i >= 0; i-- {
+		n, _ := strconv.Atoi(counts[keys[i]])
+		created += n
+		if created >= limit && resetAt.IsZero() {
+			resetAt = buckets[i].Add(quotaWindow)
+		}
+	}
+	if created < limit {
+		return nil
+	}
+	metrics.AdQuotaRejections.WithLabelValues(QuotaDaily).Inc()
+	return &QuotaError{Limit: QuotaDaily, Max: limit, ResetAt: &resetAt}
+}
+
+// recordCreation counts a created ad towards the owner's daily limit. The counter outlives its
+// hour by the window so it is read by every window it is part of.
+func (s *AdService) recordCreation(ownerID string, ctx context.Context) {
+	if s.Rules.Load().MaxCreationsPerDay <= 0 {
+		return
+	}
+	key := creationCountKey(ownerID, time.Now())
+	if _, err := s.cache().IncrExpire(key, quotaWindow+quotaBucket, context.WithoutCancel(ctx)); err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+	}
+}
+
+// CountActiveByOwner returns the number of the owner's ads that count towards ads.maxActivePerOwner,
+// with tracing: those neither rejected, archived nor expired, whatever their is_active flag, so
+// deactivating an ad doesn't make room for another
+func (r *Repository) CountActiveByOwner(ownerID string, ctx context.Context) (_ int, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "CountActiveByOwnerRepository")
+	defer span.End()
+	defer observeQuery("count_active_by_owner", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	query := "SELECT COUNT(*) FROM ads WHERE " + tenantCondition + " AND owner_id = ? AND status <> 'rejected' " +
+		"AND archived_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())"
+	if err := r.DB.QueryRowContext(ctx, query, tenantID, ownerID).Scan(&count); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to count active ads")
+		return 0, fmt.Errorf("could not count active ads: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("ads_count", count), attribute.String("status", "success"))
+	return count, nil
+}
+
+// ExternalRefExists reports whether the tenant has an ad with the external reference, with tracing
+func (r *Repository) ExternalRefExists(ref string, ctx context.Context) (_ bool, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "ExternalRefExistsRepository")
+	defer span.End()
+	defer observeQuery("external_ref_exists", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var exists bool
+	query := "SELECT EXISTS(SELECT 1 FROM ads WHERE " + tenantCondition + " AND external_ref = ?)"
+	if err := r.DB.QueryRowContext(ctx, query, tenantID, ref).Scan(&exists); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to check external reference")
+		return false, fmt.Errorf("could not check external reference: %w", err)
+	}
+	return exists, nil
+}
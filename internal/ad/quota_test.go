@@ -0,0 +1,199 @@
+package ad
+
+import (
+	"ad_service/internal/config"
+	"ad_service/pkg/metrics"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// expectAddAd expects the queries of a created ad
+func expectAddAd(mock sqlmock.Sqlmock, id int64) {
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO ads").WillReturnResult(sqlmock.NewResult(id, 1))
+	mock.ExpectExec("UPDATE ads SET slug").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT created_at FROM ads").WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+	mock.ExpectCommit()
+}
+
+// expectActiveCount expects the count of the owner's active ads
+func expectActiveCount(mock sqlmock.Sqlmock, ownerID string, count int) {
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM ads WHERE tenant_id = \? AND owner_id = \?`).WithArgs("default", ownerID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+}
+
+// ownedAd returns a new ad of the owner, nil for an anonymous one
+func ownedAd(ownerID *string) *Ad {
+	ad := testAd(0, "Bike")
+	ad.OwnerID = ownerID
+	return &ad
+}
+
+// setCreations stores the number of ads the owner created in the hour starting at start
+func setCreations(t *testing.T, s *AdService, ownerID string, start time.Time, n int) {
+	t.Helper()
+	if err := s.Cache.Set(creationCountKey(ownerID, start), strconv.Itoa(n), time.Hour, testCtx()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestActiveQuotaAtThreshold(t *testing.T) {
+	service, mock := newTestService(t)
+	setRules(service, func(r *config.AdsConfig) { r.MaxActivePerOwner = 3 })
+	rejected := testutil.ToFloat64(metrics.AdQuotaRejections.WithLabelValues(QuotaActive))
+
+	// One under the limit leaves room for one more
+	expectActiveCount(mock, "user-1", 2)
+	expectAddAd(mock, 1)
+	if err := service.AddAd(ownedAd(strPtr("user-1")), false, testCtx()); err != nil {
+		t.Fatalf("AddAd with 2 of 3 active ads: %v", err)
+	}
+
+	// At the limit nothing is inserted
+	expectActiveCount(mock, "user-1", 3)
+	err := service.AddAd(ownedAd(strPtr("user-1")), false, testCtx())
+	var quota *QuotaError
+	if !errors.As(err, &quota) || !errors.Is(err, ErrActiveQuota) || quota.Max != 3 || quota.ResetAt != nil {
+		t.Fatalf("AddAd with 3 of 3 active ads = %v, want the active quota", err)
+	}
+	if got := testutil.ToFloat64(metrics.AdQuotaRejections.WithLabelValues(QuotaActive)) - rejected; got != 1 {
+		t.Errorf("ads_quota_rejections_total{active} grew by %v, want 1", got)
+	}
+
+	// Admins and anonymous ads aren't counted
+	expectAddAd(mock, 2)
+	if err := service.AddAd(ownedAd(strPtr("user-1")), true, testCtx()); err != nil {
+		t.Errorf("AddAd as an admin: %v", err)
+	}
+	expectAddAd(mock, 3)
+	if err := service.AddAd(ownedAd(nil), false, testCtx()); err != nil {
+		t.Errorf("AddAd of an anonymous ad: %v", err)
+	}
+}
+
+func TestDailyQuotaAtThreshold(t *testing.T) {
+	service, mock := newTestService(t)
+	setRules(service, func(r *config.AdsConfig) { r.MaxCreationsPerDay = 3 })
+	rejected := testutil.ToFloat64(metrics.AdQuotaRejections.WithLabelValues(QuotaDaily))
+	buckets := creationBuckets(time.Now())
+	oldest, current := buckets[0], buckets[len(buckets)-1]
+
+	// One creation in the oldest hour of the window, one in the current hour, and some from just
+	// before the window which no longer count
+	setCreations(t, service, "user-1", oldest, 1)
+	setCreations(t, service, "user-1", current, 1)
+	setCreations(t, service, "user-1", oldest.Add(-quotaBucket), 5)
+
+	expectAddAd(mock, 1)
+	if err := service.AddAd(ownedAd(strPtr("user-1")), false, testCtx()); err != nil {
+		t.Fatalf("AddAd with 2 of 3 creations: %v", err)
+	}
+	if got, _ := service.Cache.Get(creationCountKey("user-1", current), testCtx()); got != "2" {
+		t.Errorf("current hour's count = %q, want the creation recorded", got)
+	}
+
+	// The third creation is the limit; it frees up when the oldest hour leaves the window
+	err := service.AddAd(ownedAd(strPtr("user-1")), false, testCtx())
+	var quota *QuotaError
+	if !errors.As(err, &quota) || !errors.Is(err, ErrDailyQuota) || quota.Max != 3 {
+		t.Fatalf("AddAd with 3 of 3 creations = %v, want the daily quota", err)
+	}
+	if want := oldest.Add(quotaWindow); quota.ResetAt == nil || !quota.ResetAt.Equal(want) {
+		t.Errorf("reset at %v, want %s", quota.ResetAt, want)
+	}
+	if wait := quota.RetryAfter(time.Now()); wait <= 0 || wait > quotaBucket {
+		t.Errorf("RetryAfter = %s, want within the hour", wait)
+	}
+	if got := testutil.ToFloat64(metrics.AdQuotaRejections.WithLabelValues(QuotaDaily)) - rejected; got != 1 {
+		t.Errorf("ads_quota_rejections_total{daily} grew by %v, want 1", got)
+	}
+
+	// Without the oldest creation the newest ones decide the reset
+	setCreations(t, service, "user-1", oldest, 0)
+	setCreations(t, service, "user-1", current, 3)
+	if err := service.checkQuota("user-1", testCtx()); !errors.As(err, &quota) || !quota.ResetAt.Equal(current.Add(quotaWindow)) {
+		t.Errorf("checkQuota = %v, want a reset a day after the current hour", err)
+	}
+
+	// Another owner has their own count, and admins aren't limited nor counted
+	expectAddAd(mock, 2)
+	if err := service.AddAd(ownedAd(strPtr("user-2")), false, testCtx()); err != nil {
+		t.Errorf("AddAd of another owner: %v", err)
+	}
+	expectAddAd(mock, 3)
+	if err := service.AddAd(ownedAd(strPtr("user-1")), true, testCtx()); err != nil {
+		t.Errorf("AddAd as an admin: %v", err)
+	}
+	if got, _ := service.Cache.Get(creationCountKey("user-1", current), testCtx()); got != "3" {
+		t.Errorf("current hour's count = %q after an admin's creation, want it unchanged", got)
+	}
+}
+
+func TestUpsertQuota(t *testing.T) {
+	service, mock := newTestService(t)
+	setRules(service, func(r *config.AdsConfig) { r.MaxActivePerOwner = 1 })
+	ad := ownedAd(strPtr("user-1"))
+	ad.ExternalRef = strPtr("feed-1")
+
+	// Refreshing an existing ad is never limited
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM ads WHERE tenant_id = \? AND external_ref = \?\)`).WithArgs("default", "feed-1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO ads .* ON DUPLICATE KEY UPDATE").WillReturnResult(sqlmock.NewResult(7, 2))
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7)).WillReturnRows(adRows(testAd(7, "Bike")))
+	expectNoTranslations(mock)
+	mock.ExpectCommit()
+	if created, err := service.UpsertAd(ad, false, testCtx()); err != nil || created {
+		t.Fatalf("UpsertAd of an existing ad = %v, %v; want it refreshed", created, err)
+	}
+
+	// Creating one is
+	ad = ownedAd(strPtr("user-1"))
+	ad.ExternalRef = strPtr("feed-2")
+	mock.ExpectQuery("SELECT EXISTS").WithArgs("default", "feed-2").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	expectActiveCount(mock, "user-1", 1)
+	if _, err := service.UpsertAd(ad, false, testCtx()); !errors.Is(err, ErrActiveQuota) {
+		t.Errorf("UpsertAd of a new ad at the limit = %v, want the active quota", err)
+	}
+}
+
+func TestQuotaResponse(t *testing.T) {
+	service, _ := newTestService(t)
+	setRules(service, func(r *config.AdsConfig) { r.MaxCreationsPerDay = 2 })
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) { r.POST("/ads", h.AddAd) })
+	buckets := creationBuckets(time.Now())
+	setCreations(t, service, "user-1", buckets[len(buckets)-1], 2)
+
+	body := `{"title": "Bike", "description": "Red bike", "price": 10}`
+	w := serve(r, http.MethodPost, "/ads", strings.NewReader(body), testUserHeader, "user-1")
+	var resp struct {
+		Error struct {
+			Code    string         `json:"code"`
+			Message string         `json:"message"`
+			Details map[string]any `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+	if w.Code != http.StatusTooManyRequests || resp.Error.Code != "quota_exceeded" || resp.Error.Message != "At most 2 ads can be created per 24 hours" {
+		t.Errorf("response = %d %s, want a 429 quota_exceeded", w.Code, w.Body.String())
+	}
+	if resp.Error.Details["limit"] != QuotaDaily || resp.Error.Details["max"] != 2.0 || resp.Error.Details["reset_at"] == nil {
+		t.Errorf("details = %v, want the limit, its value and the reset", resp.Error.Details)
+	}
+	// The counts come from the current hour, which leaves the window in a day
+	if wait, _ := strconv.Atoi(w.Header().Get("Retry-After")); wait < int((quotaWindow-quotaBucket).Seconds()) || wait > int(quotaWindow.Seconds()) {
+		t.Errorf("Retry-After = %q, want about a day", w.Header().Get("Retry-After"))
+	}
+}
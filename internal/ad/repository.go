@@ -5,46 +5,278 @@ It contains methods to perform CRUD operations, making it responsible for all da
 package ad
 
 import (
+	"ad_service/internal/apperr"
+	"ad_service/pkg/metrics"
+	"ad_service/pkg/money"
+	"ad_service/pkg/tenant"
+	"ad_service/pkg/timestamp"
 	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"math"
+	"math/rand"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Ad struct {
-	ID          int       `json:"id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Price       float64   `json:"price"`
-	CreatedAt   time.Time `json:"created_at"`
-	IsActive    bool      `json:"is_active"`
+	ID          int64        `json:"id"`
+	PublicID    *string      `json:"public_id,omitempty"` // random UUID set on insert, for sharing ads without their sequential ID
+	Slug        *string      `json:"slug,omitempty"`      // built from the title on insert, see Slugify
+	Title       string       `json:"title"`
+	Description string       `json:"description"`
+	Price       money.Amount `json:"price"`
+	CreatedAt   time.Time    `json:"created_at"`
+	IsActive    bool         `json:"is_active"`
+	ExternalRef *string      `json:"external_ref,omitempty"`
+	Category    string       `json:"category,omitempty"`
+	ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+	Weight      int          `json:"weight"`
+	// Moderation state, set by the service and the moderation endpoints rather than the request body
+	Status       string  `json:"status"`
+	StatusReason *string `json:"status_reason,omitempty"`
+	OwnerID      *string `json:"owner_id,omitempty"`
+	// RenewedAt is bumped by renewals and orders the default listing; the renewal counters
+	// enforce the weekly limit and stay internal
+	RenewedAt          time.Time  `json:"renewed_at"`
+	RenewalCount       int        `json:"-"`
+	RenewalWindowStart *time.Time `json:"-"`
+	// ArchivedAt is set while the owner has taken the ad off the market
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+	// FavoritesCount is kept up to date by AddFavorite and RemoveFavorite; IsFavorited is only
+	// set on the detail response for an authenticated caller
+	FavoritesCount int   `json:"favorites_count"`
+	IsFavorited    *bool `json:"is_favorited,omitempty"`
+	// CampaignID groups the ad into a campaign, whose status and dates gate serving
+	CampaignID *int `json:"campaign_id,omitempty"`
+	// Keywords target the ad at serve requests with overlapping context keywords; they live in
+	// ad_keywords and are loaded for single ads and the serving snapshot, not for list pages
+	Keywords []string `json:"keywords,omitempty"`
+	// RegenerateSlug asks UpdateAd to rebuild the slug from the new title
+	RegenerateSlug bool `json:"-"`
+	// UpdateActive asks UpdateAd to write IsActive, which a body without is_active leaves alone
+	UpdateActive bool `json:"-"`
+	// Variants are loaded for serving only; Variant names the one merged into a served ad
+	Variants []Variant `json:"variants,omitempty"`
+	Variant  *string   `json:"variant,omitempty"`
+	// Translations are keyed by locale; Locale names the one applied by Localize
+	Translations map[string]Translation `json:"translations,omitempty"`
+	Locale       string                 `json:"locale,omitempty"`
+	// Latitude and Longitude locate the ad for radius search; DistanceKm is only set on its results
+	Latitude   *float64 `json:"latitude,omitempty"`
+	Longitude  *float64 `json:"longitude,omitempty"`
+	DistanceKm *float64 `json:"distance_km,omitempty"`
+	// Score is the relevance of a full-text search result, only set on those
+	Score *float64 `json:"score,omitempty"`
+	// Price stays in Currency, the base currency; a ?currency= request adds the converted
+	// DisplayPrice, or flags ConversionUnavailable when no fresh rate is known
+	Currency              string        `json:"currency,omitempty"`
+	DisplayPrice          *money.Amount `json:"display_price,omitempty"`
+	DisplayCurrency       string        `json:"display_currency,omitempty"`
+	ConversionUnavailable bool          `json:"conversion_unavailable,omitempty"`
+}
+
+// Abuse report statuses; reports start open and are resolved by an admin
+const (
+	ReportOpen      = "open"
+	ReportDismissed = "dismissed"
+	ReportTakenDown = "taken_down"
+)
+
+// ReportReasons are the reason codes accepted for an abuse report
+var ReportReasons = map[string]bool{
+	"fraud":      true,
+	"spam":       true,
+	"prohibited": true,
+	"offensive":  true,
+	"duplicate":  true,
+	"other":      true,
+}
+
+// Report is an abuse report filed by a user against an ad
+type Report struct {
+	ID         int64      `json:"id"`
+	AdID       int64      `json:"ad_id"`
+	ReporterID string     `json:"reporter_id"`
+	Reason     string     `json:"reason"`
+	Comment    *string    `json:"comment,omitempty"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	ResolvedBy *string    `json:"resolved_by,omitempty"`
+}
+
+// ErrReportNotFound is returned when resolving a report that doesn't exist
+var ErrReportNotFound = apperr.New(apperr.ErrNotFound, "Report not found")
+
+// ErrReportResolved is returned when resolving a report that is no longer open
+var ErrReportResolved = apperr.New(apperr.ErrConflict, "Report is already resolved")
+
+// Favorite is an ad saved by a user. Ad is nil when the ad is no longer available to the user.
+type Favorite struct {
+	AdID        int64     `json:"ad_id"`
+	FavoritedAt time.Time `json:"favorited_at"`
+	Available   bool      `json:"available"`
+	Ad          *Ad       `json:"ad,omitempty"`
+}
+
+// DefaultAdWeight is used for ads created without an explicit weight
+const DefaultAdWeight = 1
+
+// MaxAdWeight caps the weight an ad can get in the serving rotation
+const MaxAdWeight = 100
+
+// Moderation statuses. New ads start pending and only approved ads are public.
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+// statusTransitions lists the statuses each status may move to
+var statusTransitions = map[string][]string{
+	StatusPending:  {StatusApproved, StatusRejected},
+	StatusApproved: {StatusRejected},
+	StatusRejected: {StatusApproved},
+}
+
+// canTransition reports whether an ad may move from one moderation status to another
+func canTransition(from, to string) bool {
+	for _, next := range statusTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
 }
 
 type Repository struct {
 	DB *sql.DB
+	// SearchOutbox makes writes record the ads they change in search_outbox for the search
+	// indexer, see outbox.go
+	SearchOutbox bool
 }
 
 // For returning Ad not found error, using in UpdateAd and DeleteAd
-var ErrAdNotFound = errors.New("Ad not found")
+var ErrAdNotFound = apperr.New(apperr.ErrNotFound, "Ad not found")
+
+// ErrArchived is returned when an archived ad is changed in a way only live ads allow
+var ErrArchived = apperr.New(apperr.ErrConflict, "Ad is archived")
+
+// observeQuery records the duration of a repository method with its outcome. Every method defers
+// it right after starting its span, with a pointer to its named error result, which it turns into
+// a domain error so no driver error leaves the repository: no rows is ErrAdNotFound and other
+// failures are apperr.ErrUnavailable.
+func observeQuery(method string, start time.Time, err *error, ctx context.Context) {
+	*err = apperr.FromDB(*err, ErrAdNotFound)
+	outcome := "ok"
+	switch {
+	case errors.Is(*err, apperr.ErrNotFound):
+		outcome = "not_found"
+	case *err != nil:
+		outcome = "error"
+	}
+	metrics.ObserveWithTrace(metrics.DBQueryDuration.WithLabelValues(method, outcome), time.Since(start).Seconds(), ctx)
+}
+
+// adColumns is the column list selected for a full Ad, in the order expected by scanAd
+const adColumns = "id, public_id, slug, title, description, price, created_at, is_active, external_ref, category, expires_at, weight, status, status_reason, owner_id, renewed_at, renewal_count, renewal_window_start, archived_at, favorites_count, campaign_id, latitude, longitude"
+
+// prefixedAdColumns is adColumns qualified with a table alias, for joins
+func prefixedAdColumns(alias string) string {
+	return alias + "." + strings.ReplaceAll(adColumns, ", ", ", "+alias+".")
+}
+
+// adInsertColumns is the column list written on insert, in the order returned by adInsertValues
+const adInsertColumns = "tenant_id, public_id, title, description, price, is_active, external_ref, category, expires_at, weight, status, owner_id, campaign_id, latitude, longitude"
+
+// adInsertPlaceholders holds one placeholder per column in adInsertColumns
+const adInsertPlaceholders = "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+// activeCondition restricts a query to ads that are approved, not archived, active and not
+// expired, and that are either outside any campaign or in an active campaign within its dates
+const activeCondition = "status = 'approved' AND archived_at IS NULL AND is_active = TRUE AND (expires_at IS NULL OR expires_at > NOW()) " +
+	"AND (campaign_id IS NULL OR campaign_id IN (SELECT id FROM campaigns WHERE status = 'active' " +
+	"AND (starts_at IS NULL OR starts_at <= NOW()) AND (ends_at IS NULL OR ends_at > NOW())))"
+
+// tenantCondition restricts a query on ads to one tenant. Every query on tenant data takes the
+// tenant from its context with tenant.Require, so a missing tenant fails instead of reading
+// across tenants.
+const tenantCondition = "tenant_id = ?"
+
+// tenantAdCondition restricts a query on a table of ad children, like favorites and reports,
+// to the ads of one tenant
+const tenantAdCondition = "ad_id IN (SELECT id FROM ads WHERE tenant_id = ?)"
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAd reads a row selected with adColumns into the given Ad, with its times in UTC
+func scanAd(row rowScanner, ad *Ad) error {
+	err := row.Scan(&ad.ID, &ad.PublicID, &ad.Slug, &ad.Title, &ad.Description, &ad.Price, &ad.CreatedAt, &ad.IsActive, &ad.ExternalRef, &ad.Category, &ad.ExpiresAt, &ad.Weight, &ad.Status, &ad.StatusReason, &ad.OwnerID, &ad.RenewedAt, &ad.RenewalCount, &ad.RenewalWindowStart, &ad.ArchivedAt, &ad.FavoritesCount, &ad.CampaignID, &ad.Latitude, &ad.Longitude)
+	if err != nil {
+		return err
+	}
+	ad.CreatedAt, ad.RenewedAt = ad.CreatedAt.UTC(), ad.RenewedAt.UTC()
+	ad.ExpiresAt, ad.RenewalWindowStart, ad.ArchivedAt = timestamp.UTC(ad.ExpiresAt), timestamp.UTC(ad.RenewalWindowStart), timestamp.UTC(ad.ArchivedAt)
+	return nil
+}
+
+// adInsertValues returns the values for adInsertColumns of an ad of the tenant, defaulting an
+// unset weight and status and generating the public ID. An upsert that updates keeps the stored
+// public ID.
+func adInsertValues(tenantID string, ad *Ad) []interface{} {
+	if ad.PublicID == nil {
+		publicID := uuid.NewString()
+		ad.PublicID = &publicID
+	}
+	if ad.Weight == 0 {
+		ad.Weight = DefaultAdWeight
+	}
+	if ad.Status == "" {
+		ad.Status = StatusPending
+	}
+	return []interface{}{tenantID, ad.PublicID, ad.Title, ad.Description, ad.Price, ad.IsActive, ad.ExternalRef, ad.Category, ad.ExpiresAt, ad.Weight, ad.Status, ad.OwnerID, ad.CampaignID, ad.Latitude, ad.Longitude}
+}
 
 // AddAd adds a new ad to the database, with tracing
-func (r *Repository) AddAd(ad *Ad, ctx context.Context) error {
+func (r *Repository) AddAd(ad *Ad, ctx context.Context) (err error) {
 	tracer := otel.Tracer("ad-service.repository")
 	ctx, span := tracer.Start(ctx, "AddAdRepository")
 	defer span.End()
+	defer observeQuery("add_ad", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The ad and its keywords are written together
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to begin transaction")
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	// Build the SQL query
-	query := "INSERT INTO ads (title, description, price, is_active) VALUES (?, ?, ?, ?)"
+	query := "INSERT INTO ads (" + adInsertColumns + ") VALUES " + adInsertPlaceholders
 
-	result, err := r.DB.ExecContext(ctx, query, ad.Title, ad.Description, ad.Price, ad.IsActive)
+	result, err := tx.ExecContext(ctx, query, adInsertValues(tenantID, ad)...)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to insert ad")
-		return fmt.Errorf("could not insert ad: %v", err)
+		return fmt.Errorf("could not insert ad: %w", err)
 	}
 
 	// Get the last inserted ID
@@ -52,80 +284,420 @@ func (r *Repository) AddAd(ad *Ad, ctx context.Context) error {
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to retrieve last insert ID")
-		return fmt.Errorf("could not retrieve last insert ID: %v", err)
+		return fmt.Errorf("could not retrieve last insert ID: %w", err)
+	}
+	ad.ID = id
+	if err := setSlugs(tx, []*Ad{ad}, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to set slug")
+		return err
+	}
+	if err := insertKeywords(tx, map[int64][]string{id: ad.Keywords}, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to insert keywords")
+		return err
+	}
+	if err := insertTranslations(tx, map[int64]map[string]Translation{id: ad.Translations}, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to insert translations")
+		return err
 	}
 
 	// Retrieve the created_at value from the database
 	query = "SELECT created_at FROM ads WHERE id = ?"
-	row := r.DB.QueryRowContext(ctx, query, id)
+	row := tx.QueryRowContext(ctx, query, id)
 	var createdAt time.Time
 	err = row.Scan(&createdAt)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to retrieve created_at")
-		return fmt.Errorf("could not retrieve created_at: %v", err)
+		return fmt.Errorf("could not retrieve created_at: %w", err)
+	}
+
+	if err := r.enqueueSearch(tx, tenantID, []int64{id}, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to queue search update")
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to commit transaction")
+		return fmt.Errorf("could not commit ad: %w", err)
+	}
+
+	// Update the Ad struct with the created_at time; renewed_at starts out equal to it
+	ad.CreatedAt = createdAt.UTC()
+	ad.RenewedAt = ad.CreatedAt
+
+	span.SetAttributes(attribute.Int64("ad_id", ad.ID), attribute.String("status", "success"))
+	return nil
+}
+
+// addAdsChunkSize is the number of rows inserted by a single multi-row INSERT in AddAds
+const addAdsChunkSize = 100
+
+// AddAds inserts many ads inside one transaction using multi-row INSERTs, with tracing.
+// The IDs and created_at values are written back to the passed structs.
+// If any chunk fails the whole batch is rolled back.
+func (r *Repository) AddAds(ads []*Ad, ctx context.Context) (err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "AddAdsRepository")
+	defer span.End()
+	defer observeQuery("add_ads", time.Now(), &err, ctx)
+
+	span.SetAttributes(attribute.Int("ads_count", len(ads)))
+	if len(ads) == 0 {
+		return nil
+	}
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to begin transaction")
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	for start := 0; start < len(ads); start += addAdsChunkSize {
+		// Stop between chunks if the caller has gone away
+		if err := ctx.Err(); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Context canceled during batch insert")
+			return err
+		}
+
+		end := start + addAdsChunkSize
+		if end > len(ads) {
+			end = len(ads)
+		}
+		if err := insertAdsChunk(tx, tenantID, ads[start:end], ctx); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to insert ads chunk")
+			return err
+		}
+	}
+
+	ids := make([]int64, len(ads))
+	for i, ad := range ads {
+		ids[i] = ad.ID
+	}
+	if err := r.enqueueSearch(tx, tenantID, ids, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to queue search update")
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to commit transaction")
+		return fmt.Errorf("could not commit ads batch: %w", err)
+	}
+
+	span.SetAttributes(attribute.String("status", "success"))
+	return nil
+}
+
+// insertAdsChunk inserts one chunk of ads of the tenant with a single statement and back-fills IDs
+// and created_at. MySQL assigns consecutive AUTO_INCREMENT values to a multi-row INSERT, starting
+// at LastInsertId.
+func insertAdsChunk(tx *sql.Tx, tenantID string, chunk []*Ad, ctx context.Context) error {
+	query := "INSERT INTO ads (" + adInsertColumns + ") VALUES " +
+		strings.TrimSuffix(strings.Repeat(adInsertPlaceholders+", ", len(chunk)), ", ")
+	params := make([]interface{}, 0, len(chunk)*15)
+	for _, ad := range chunk {
+		params = append(params, adInsertValues(tenantID, ad)...)
+	}
+
+	result, err := tx.ExecContext(ctx, query, params...)
+	if err != nil {
+		return fmt.Errorf("could not insert ads: %w", err)
+	}
+	firstID, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("could not retrieve last insert ID: %w", err)
+	}
+	keywords := make(map[int64][]string, len(chunk))
+	translations := make(map[int64]map[string]Translation, len(chunk))
+	for i, ad := range chunk {
+		ad.ID = firstID + int64(i)
+		keywords[ad.ID] = ad.Keywords
+		translations[ad.ID] = ad.Translations
+	}
+	if err := setSlugs(tx, chunk, ctx); err != nil {
+		return err
+	}
+	if err := insertKeywords(tx, keywords, ctx); err != nil {
+		return err
+	}
+	if err := insertTranslations(tx, translations, ctx); err != nil {
+		return err
 	}
 
-	// Update the Ad struct with the new ID and created_at time
-	ad.ID = int(id)
-	ad.CreatedAt = createdAt
+	// Retrieve the created_at values for the whole chunk in one query
+	rows, err := tx.QueryContext(ctx, "SELECT id, created_at FROM ads WHERE id BETWEEN ? AND ?", firstID, firstID+int64(len(chunk))-1)
+	if err != nil {
+		return fmt.Errorf("could not retrieve created_at: %w", err)
+	}
+	defer rows.Close()
 
-	span.SetAttributes(attribute.Int("ad_id", ad.ID), attribute.String("status", "success"))
+	createdAt := make(map[int64]time.Time, len(chunk))
+	for rows.Next() {
+		var id int64
+		var t time.Time
+		if err := rows.Scan(&id, &t); err != nil {
+			return fmt.Errorf("could not scan created_at: %w", err)
+		}
+		createdAt[id] = t
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not retrieve created_at: %w", err)
+	}
+	for _, ad := range chunk {
+		ad.CreatedAt = createdAt[ad.ID]
+		ad.RenewedAt = ad.CreatedAt
+	}
 	return nil
 }
 
 // UpdateAd updates an existing ad, with tracing
-func (r *Repository) UpdateAd(id int, ad *Ad, ctx context.Context) error {
+func (r *Repository) UpdateAd(id int64, ad *Ad, ctx context.Context) (err error) {
 	tracer := otel.Tracer("ad-service.repository")
 	ctx, span := tracer.Start(ctx, "UpdateAdRepository")
 	defer span.End()
+	defer observeQuery("update_ad", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
 
 	// Build the SQL query
-	query := "UPDATE ads SET title = ?, description = ?, price = ?, "
-	params := []interface{}{ad.Title, ad.Description, ad.Price}
-	if ad.IsActive {
+	query := "UPDATE ads SET title = ?, description = ?, price = ?, category = ?, expires_at = ?, campaign_id = ?, latitude = ?, longitude = ?, updated_at = CURRENT_TIMESTAMP, "
+	params := []interface{}{ad.Title, ad.Description, ad.Price, ad.Category, ad.ExpiresAt, ad.CampaignID, ad.Latitude, ad.Longitude}
+	if ad.UpdateActive {
 		query += "is_active = ?, "
 		params = append(params, ad.IsActive)
 	}
+	if ad.Weight > 0 {
+		query += "weight = ?, "
+		params = append(params, ad.Weight)
+	}
+	if ad.RegenerateSlug {
+		slug := Slugify(ad.Title, id)
+		ad.Slug = &slug
+		query += "slug = ?, "
+		params = append(params, slug)
+	}
 	query = query[:len(query)-2] // Remove last comma and space
-	query += " WHERE id = ?"
-	params = append(params, id)
+	query += " WHERE id = ? AND " + tenantCondition + " AND archived_at IS NULL"
+	params = append(params, id, tenantID)
 
-	result, err := r.DB.ExecContext(ctx, query, params...)
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to begin transaction")
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, params...)
+	if err != nil && ad.RegenerateSlug && isDuplicateKey(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Slug already in use")
+		return ErrSlugTaken
+	}
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to update ad")
-		return fmt.Errorf("could not update ad: %v", err)
+		return fmt.Errorf("could not update ad: %w", err)
 	}
 
-	// Check if any rows were affected (if no rows, the ad wasn't found)
+	// No affected rows means the ad is missing, archived or already held these values
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		span.RecordError(err)
-		return fmt.Errorf("could not retrieve affected rows: %v", err)
+		return fmt.Errorf("could not retrieve affected rows: %w", err)
 	}
 	if rowsAffected == 0 {
-		span.RecordError(ErrAdNotFound)
-		span.SetStatus(codes.Error, "Ad not found")
-		return ErrAdNotFound
+		var archived bool
+		err := tx.QueryRowContext(ctx, "SELECT archived_at IS NOT NULL FROM ads WHERE id = ? AND "+tenantCondition, id, tenantID).Scan(&archived)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			span.RecordError(ErrAdNotFound)
+			span.SetStatus(codes.Error, "Ad not found")
+			return ErrAdNotFound
+		case err != nil:
+			span.RecordError(err)
+			return fmt.Errorf("could not check ad state: %w", err)
+		case archived:
+			span.RecordError(ErrArchived)
+			span.SetStatus(codes.Error, "Ad is archived")
+			return ErrArchived
+		}
+	}
+
+	// Keywords and translations left out of the body are kept; an empty list or map clears them
+	if ad.Keywords != nil {
+		if err := replaceKeywords(tx, id, ad.Keywords, ctx); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to replace keywords")
+			return err
+		}
+	}
+	if ad.Translations != nil {
+		if err := replaceTranslations(tx, id, ad.Translations, ctx); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to replace translations")
+			return err
+		}
+	}
+	if err := r.enqueueSearch(tx, tenantID, []int64{id}, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to queue search update")
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to commit transaction")
+		return fmt.Errorf("could not commit ad update: %w", err)
 	}
 
-	span.SetAttributes(attribute.Int("ad_id", id), attribute.String("status", "updated"))
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("status", "updated"))
 	return nil
 }
 
-// GetAllAds retrieves ads from the database with pagination and sorting, with tracing
-func (r *Repository) GetAllAds(page, limit int, sortBy, order string, ctx context.Context) ([]Ad, error) {
+// UpsertAd creates or refreshes an ad keyed by its external reference, with tracing.
+// It reports whether a new row was created; the ad is filled with the stored state.
+func (r *Repository) UpsertAd(ad *Ad, ctx context.Context) (_ bool, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "UpsertAdRepository")
+	defer span.End()
+	defer observeQuery("upsert_ad", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// The external reference is unique per tenant, so another tenant's ad is never updated
+	// id = LAST_INSERT_ID(id) makes LastInsertId return the existing row's ID on update
+	query := "INSERT INTO ads (" + adInsertColumns + ") VALUES " + adInsertPlaceholders + " " +
+		"ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), title = VALUES(title), description = VALUES(description), " +
+		"price = VALUES(price), is_active = VALUES(is_active), category = VALUES(category), expires_at = VALUES(expires_at), weight = VALUES(weight), campaign_id = VALUES(campaign_id), " +
+		"latitude = VALUES(latitude), longitude = VALUES(longitude), updated_at = CURRENT_TIMESTAMP"
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to begin transaction")
+		return false, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	keywords, translations := ad.Keywords, ad.Translations
+	result, err := tx.ExecContext(ctx, query, adInsertValues(tenantID, ad)...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to upsert ad")
+		return false, fmt.Errorf("could not upsert ad: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve last insert ID")
+		return false, fmt.Errorf("could not retrieve last insert ID: %w", err)
+	}
+
+	// MySQL reports 1 affected row for an insert, 2 for an update and 0 for an unchanged row
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return false, fmt.Errorf("could not retrieve affected rows: %w", err)
+	}
+	created := rowsAffected == 1
+
+	// The slug of an existing ad is kept, like on UpdateAd without regenerate_slug
+	if created {
+		ad.ID = id
+		if err := setSlugs(tx, []*Ad{ad}, ctx); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to set slug")
+			return false, err
+		}
+	}
+
+	// Like UpdateAd, keywords and translations left out of the body are kept
+	if keywords != nil {
+		if err := replaceKeywords(tx, id, keywords, ctx); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to replace keywords")
+			return false, err
+		}
+	}
+	if translations != nil {
+		if err := replaceTranslations(tx, id, translations, ctx); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to replace translations")
+			return false, err
+		}
+	}
+
+	// Read back the stored row so the caller gets created_at and any untouched columns
+	query = "SELECT " + keywordsColumn + ", " + adColumns + " FROM ads WHERE id = ?"
+	var storedKeywords sql.NullString
+	if err := scanAd(prefixedScanner{tx.QueryRowContext(ctx, query, id), &storedKeywords}, ad); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve upserted ad")
+		return false, fmt.Errorf("could not retrieve upserted ad: %w", err)
+	}
+	ad.Keywords, ad.Translations = splitKeywords(storedKeywords), nil
+	stored := []Ad{*ad}
+	if err := attachTranslations(tx, stored, ctx); err != nil {
+		span.RecordError(err)
+		return false, err
+	}
+	ad.Translations = stored[0].Translations
+
+	if err := r.enqueueSearch(tx, tenantID, []int64{id}, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to queue search update")
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to commit transaction")
+		return false, fmt.Errorf("could not commit upserted ad: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", ad.ID), attribute.Bool("created", created), attribute.String("status", "success"))
+	return created, nil
+}
+
+// GetAllAds retrieves one page of a listing from the database, with tracing
+func (r *Repository) GetAllAds(q ListQuery, ctx context.Context) (_ []Ad, err error) {
 	// Start a new tracing span for the GetAllAds operation
 	tracer := otel.Tracer("ad-service.repository")
 	ctx, span := tracer.Start(ctx, "GetAllAdsRepository")
 	defer span.End()
+	defer observeQuery("get_all_ads", time.Now(), &err, ctx)
 
-	// Calculate the offset based on the current page and limit.
-	offset := (page - 1) * limit
-	query := fmt.Sprintf("SELECT id, title, description, price, created_at, is_active FROM ads ORDER BY %s %s LIMIT ? OFFSET ?", sortBy, order)
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query, args, err := q.build(tenantID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Invalid list query")
+		return nil, err
+	}
 
-	rows, err := r.DB.QueryContext(ctx, query, limit, offset)
+	rows, err := r.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to retrieve ads")
@@ -137,12 +709,97 @@ func (r *Repository) GetAllAds(page, limit int, sortBy, order string, ctx contex
 	ads := []Ad{}
 	for rows.Next() {
 		var ad Ad
-		if err := rows.Scan(&ad.ID, &ad.Title, &ad.Description, &ad.Price, &ad.CreatedAt, &ad.IsActive); err != nil {
+		var row rowScanner = rows
+		if q.Filter.Near != nil {
+			row = prefixedScanner{row, &ad.DistanceKm}
+		}
+		if q.Filter.Search != "" && q.Filter.SearchEngine == SearchFullText {
+			row = prefixedScanner{row, &ad.Score}
+		}
+		if err := scanAd(row, &ad); err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		if ad.DistanceKm != nil {
+			distance := math.Round(*ad.DistanceKm*1000) / 1000
+			ad.DistanceKm = &distance
+		}
+		if ad.Score != nil {
+			score := math.Round(*ad.Score*10000) / 10000
+			ad.Score = &score
+		}
+		ads = append(ads, ad)
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to read ads")
+		return nil, fmt.Errorf("could not read ads: %w", err)
+	}
+	metrics.DBRowsReturned.WithLabelValues("get_all_ads").Add(float64(len(ads)))
+
+	if err := attachTranslations(r.DB, ads, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve translations")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("ads_count", len(ads)), attribute.String("status", "success"))
+	return ads, nil
+}
+
+// GetAdsByIDs fetches the ads with the given IDs in a single query, with tracing.
+// IDs that do not exist are simply absent from the result, which is in no particular order.
+func (r *Repository) GetAdsByIDs(ids []int64, ctx context.Context) (_ []Ad, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "GetAdsByIDsRepository")
+	defer span.End()
+	defer observeQuery("get_ads_by_ids", time.Now(), &err, ctx)
+
+	span.SetAttributes(attribute.Int("ids_count", len(ids)))
+	if len(ids) == 0 {
+		return []Ad{}, nil
+	}
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build one placeholder per ID for the IN clause
+	query := "SELECT " + adColumns + " FROM ads WHERE " + tenantCondition + " AND id IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ") + ")"
+	params := make([]interface{}, 0, len(ids)+1)
+	params = append(params, tenantID)
+	for _, id := range ids {
+		params = append(params, id)
+	}
+
+	rows, err := r.DB.QueryContext(ctx, query, params...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve ads by IDs")
+		return nil, err
+	}
+	defer rows.Close()
+
+	ads := []Ad{}
+	for rows.Next() {
+		var ad Ad
+		if err := scanAd(rows, &ad); err != nil {
 			span.RecordError(err)
 			return nil, err
 		}
 		ads = append(ads, ad)
 	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve ads by IDs")
+		return nil, err
+	}
+
+	if err := attachTranslations(r.DB, ads, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve translations")
+		return nil, err
+	}
 
 	span.SetAttributes(attribute.Int("ads_count", len(ads)), attribute.String("status", "success"))
 	return ads, nil
@@ -150,49 +807,1176 @@ func (r *Repository) GetAllAds(page, limit int, sortBy, order string, ctx contex
 
 // GetAdByID fetches the ad by its ID from the database, with tracing
 
-func (r *Repository) GetAdByID(id int, ctx context.Context) (*Ad, error) {
+func (r *Repository) GetAdByID(id int64, ctx context.Context) (_ *Ad, err error) {
 	// Start a new tracing span for the GetAdByID operation
 	tracer := otel.Tracer("ad-service.repository")
 	ctx, span := tracer.Start(ctx, "GetAdByIDRepository")
 	defer span.End()
-	// Prepare the SQL query to select an ad by its ID
-	query := "SELECT id, title, description, price, created_at, is_active FROM ads WHERE id = ?"
+	defer observeQuery("get_ad_by_id", time.Now(), &err, ctx)
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Prepare the SQL query to select an ad by its ID; another tenant's ad is not found
+	query := "SELECT " + keywordsColumn + ", " + adColumns + " FROM ads WHERE id = ? AND " + tenantCondition
 	var ad Ad
-	err := r.DB.QueryRowContext(ctx, query, id).Scan(&ad.ID, &ad.Title, &ad.Description, &ad.Price, &ad.CreatedAt, &ad.IsActive)
+	var keywords sql.NullString
+	err = scanAd(prefixedScanner{r.DB.QueryRowContext(ctx, query, id, tenantID), &keywords}, &ad)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, sql.ErrNoRows) {
 			// No ad found with the given ID
 			span.SetStatus(codes.Error, "Ad not found in DB")
-			return nil, err
+			return nil, ErrAdNotFound
 		}
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to query ad from DB")
 		return nil, err
 	}
+	ad.Keywords = splitKeywords(keywords)
+
+	found := []Ad{ad}
+	if err := attachTranslations(r.DB, found, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve translations")
+		return nil, err
+	}
 
-	span.SetAttributes(attribute.Int("ad_id", ad.ID), attribute.String("db_status", "success"))
-	return &ad, nil
+	span.SetAttributes(attribute.Int64("ad_id", ad.ID), attribute.String("db_status", "success"))
+	return &found[0], nil
 }
 
-// DeleteAd deletes an ad by ID, with tracing
-func (r *Repository) DeleteAd(id int, ctx context.Context) error {
+// GetAdIDByPublicID resolves the public ID of an ad to its ID, with tracing. ErrAdNotFound is
+// returned for an unknown public ID.
+func (r *Repository) GetAdIDByPublicID(publicID string, ctx context.Context) (_ int64, err error) {
 	tracer := otel.Tracer("ad-service.repository")
-	ctx, span := tracer.Start(ctx, "DeleteAdRepository")
+	ctx, span := tracer.Start(ctx, "GetAdIDByPublicIDRepository")
 	defer span.End()
+	defer observeQuery("get_ad_id_by_public_id", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = r.DB.QueryRowContext(ctx, "SELECT id FROM ads WHERE public_id = ? AND "+tenantCondition, publicID, tenantID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		span.SetStatus(codes.Error, "Ad not found in DB")
+		return 0, ErrAdNotFound
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to resolve public ID")
+		return 0, fmt.Errorf("could not resolve public ID: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("db_status", "success"))
+	return id, nil
+}
+
+// RandomAdFilter narrows the set of ads GetRandomAd picks from; zero values mean no filter
+type RandomAdFilter struct {
+	Category string
+	MinPrice money.Amount
+	MaxPrice money.Amount
+}
+
+// clause returns the conditions of the filter, on top of the ad being a live ad of the tenant
+func (f RandomAdFilter) clause(tenantID string) clause {
+	var c clause
+	c.add(tenantCondition, tenantID)
+	c.add(activeCondition)
+	if f.Category != "" {
+		c.add("category = ?", f.Category)
+	}
+	if f.MinPrice > 0 {
+		c.add("price >= ?", f.MinPrice)
+	}
+	if f.MaxPrice > 0 {
+		c.add("price <= ?", f.MaxPrice)
+	}
+	return c
+}
+
+// GetRandomAd picks one random active, non-expired ad matching the filter, with tracing.
+// Instead of ORDER BY RAND() it counts the matching rows and reads a single row at a random offset.
+func (r *Repository) GetRandomAd(filter RandomAdFilter, ctx context.Context) (_ *Ad, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "GetRandomAdRepository")
+	defer span.End()
+	defer observeQuery("get_random_ad", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conditions := filter.clause(tenantID)
+	where, params := conditions.where(), conditions.args
+
+	var count int
+	if err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM ads"+where, params...).Scan(&count); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to count eligible ads")
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("eligible_count", count))
+	if count == 0 {
+		return nil, ErrAdNotFound
+	}
+
+	offset := rand.Intn(count)
+	query := "SELECT " + adColumns + " FROM ads" + where + " ORDER BY id LIMIT 1 OFFSET ?"
+	var ad Ad
+	err = scanAd(r.DB.QueryRowContext(ctx, query, append(params, offset)...), &ad)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Rows were deleted between the count and the read
+		return nil, ErrAdNotFound
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve random ad")
+		return nil, err
+	}
+
+	found := []Ad{ad}
+	if err := attachTranslations(r.DB, found, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve translations")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", ad.ID), attribute.String("status", "success"))
+	return &found[0], nil
+}
+
+// GetServableAds retrieves every active, non-expired ad of the tenant eligible for weighted
+// serving, with tracing
+func (r *Repository) GetServableAds(ctx context.Context) (_ []Ad, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "GetServableAdsRepository")
+	defer span.End()
+	defer observeQuery("get_servable_ads", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The keywords tell targeted ads from the untargeted ones served as a fallback
+	query := "SELECT " + keywordsColumn + ", " + adColumns + " FROM ads WHERE " + tenantCondition + " AND " + activeCondition + " AND weight > 0"
+	rows, err := r.DB.QueryContext(ctx, query, tenantID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve servable ads")
+		return nil, err
+	}
+	defer rows.Close()
+
+	ads := []Ad{}
+	for rows.Next() {
+		var ad Ad
+		var keywords sql.NullString
+		if err := scanAd(prefixedScanner{rows, &keywords}, &ad); err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		ad.Keywords = splitKeywords(keywords)
+		ads = append(ads, ad)
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve servable ads")
+		return nil, err
+	}
+
+	if err := r.attachVariants(ads, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve variants")
+		return nil, err
+	}
+	if err := attachTranslations(r.DB, ads, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve translations")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("ads_count", len(ads)), attribute.String("status", "success"))
+	return ads, nil
+}
+
+// similarPriceRange is the relative price distance within which ads count as similar
+const similarPriceRange = 0.25
+
+// GetSimilarAds returns up to limit active ads priced within 25% of the source ad, in its category
+// when it has one, closest price first and then most recently renewed, with tracing. The
+// category and price bounds use the (category, price) index, or the price index without a category.
+func (r *Repository) GetSimilarAds(source *Ad, limit int, excludeOwner bool, ctx context.Context) (_ []Ad, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "GetSimilarAdsRepository")
+	defer span.End()
+	defer observeQuery("get_similar_ads", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := "SELECT " + adColumns + " FROM ads WHERE " + tenantCondition + " AND " + activeCondition + " AND id <> ? AND price BETWEEN ? AND ?"
+	params := []interface{}{tenantID, source.ID, source.Price.Mul(1 - similarPriceRange), source.Price.Mul(1 + similarPriceRange)}
+	if source.Category != "" {
+		query += " AND category = ?"
+		params = append(params, source.Category)
+	}
+	if excludeOwner && source.OwnerID != nil {
+		query += " AND (owner_id IS NULL OR owner_id <> ?)"
+		params = append(params, *source.OwnerID)
+	}
+	query += " ORDER BY ABS(price - ?), renewed_at DESC LIMIT ?"
+	params = append(params, source.Price, limit)
+
+	rows, err := r.DB.QueryContext(ctx, query, params...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve similar ads")
+		return nil, err
+	}
+	defer rows.Close()
+
+	ads := []Ad{}
+	for rows.Next() {
+		var ad Ad
+		if err := scanAd(rows, &ad); err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		ads = append(ads, ad)
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve similar ads")
+		return nil, err
+	}
+
+	if err := attachTranslations(r.DB, ads, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve translations")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", source.ID), attribute.Int("ads_count", len(ads)), attribute.String("status", "success"))
+	return ads, nil
+}
+
+// CampaignExists reports whether a campaign exists, with tracing. Ads are checked against it
+// before they reference a campaign.
+func (r *Repository) CampaignExists(id int, ctx context.Context) (_ bool, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "CampaignExistsRepository")
+	defer span.End()
+	defer observeQuery("campaign_exists", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return false, err
+	}
+	// Another tenant's campaign doesn't exist for the ads of this one
+	var exists bool
+	if err := r.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM campaigns WHERE id = ? AND "+tenantCondition+")", id, tenantID).Scan(&exists); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to check campaign")
+		return false, err
+	}
+	span.SetAttributes(attribute.Int("campaign_id", id), attribute.Bool("exists", exists))
+	return exists, nil
+}
+
+// RecordImpression increments the impression counter of a served ad, and of the variant it was
+// served with when there is one, with tracing
+func (r *Repository) RecordImpression(id int64, variantKey *string, ctx context.Context) (err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "RecordImpressionRepository")
+	defer span.End()
+	defer observeQuery("record_impression", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := r.DB.ExecContext(ctx, "UPDATE ads SET impressions = impressions + 1 WHERE id = ? AND "+tenantCondition, id, tenantID); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to record impression")
+		return fmt.Errorf("could not record impression: %w", err)
+	}
+	if variantKey != nil {
+		query := "UPDATE ad_variants SET impressions = impressions + 1 WHERE ad_id = ? AND variant_key = ? AND " + tenantAdCondition
+		if _, err := r.DB.ExecContext(ctx, query, id, *variantKey, tenantID); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to record variant impression")
+			return fmt.Errorf("could not record variant impression: %w", err)
+		}
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("status", "success"))
+	return nil
+}
+
+// RecordClick increments the click counter of an ad, and of the given variant when there is one,
+// in one transaction, with tracing
+func (r *Repository) RecordClick(id int64, variantKey *string, ctx context.Context) (err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "RecordClickRepository")
+	defer span.End()
+	defer observeQuery("record_click", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to begin transaction")
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE ads SET clicks = clicks + 1 WHERE id = ? AND "+tenantCondition, id, tenantID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to record click")
+		return fmt.Errorf("could not record click: %w", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("could not retrieve affected rows: %w", err)
+	} else if rowsAffected == 0 {
+		span.RecordError(ErrAdNotFound)
+		span.SetStatus(codes.Error, "Ad not found")
+		return ErrAdNotFound
+	}
+
+	if variantKey != nil {
+		// The ad was found within the tenant above, so its variants are the tenant's
+		query := "UPDATE ad_variants SET clicks = clicks + 1 WHERE ad_id = ? AND variant_key = ?"
+		result, err := tx.ExecContext(ctx, query, id, *variantKey)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to record variant click")
+			return fmt.Errorf("could not record variant click: %w", err)
+		}
+		if rowsAffected, err := result.RowsAffected(); err != nil {
+			span.RecordError(err)
+			return fmt.Errorf("could not retrieve affected rows: %w", err)
+		} else if rowsAffected == 0 {
+			span.RecordError(ErrVariantNotFound)
+			span.SetStatus(codes.Error, "Variant not found")
+			return ErrVariantNotFound
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to commit transaction")
+		return fmt.Errorf("could not commit click: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("status", "success"))
+	return nil
+}
+
+// CountAdsPerDay counts the tenant's ads created on each day in [from, to), with tracing.
+// Days without ads are absent from the result; keys are formatted as 2006-01-02.
+func (r *Repository) CountAdsPerDay(from, to time.Time, isActive *bool, ctx context.Context) (_ map[string]int, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "CountAdsPerDayRepository")
+	defer span.End()
+	defer observeQuery("count_ads_per_day", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := "SELECT DATE(created_at) AS day, COUNT(*) FROM ads WHERE " + tenantCondition + " AND created_at >= ? AND created_at < ?"
+	params := []interface{}{tenantID, from, to}
+	if isActive != nil {
+		query += " AND is_active = ?"
+		params = append(params, *isActive)
+	}
+	query += " GROUP BY day ORDER BY day"
+
+	rows, err := r.DB.QueryContext(ctx, query, params...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to count ads per day")
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var day time.Time
+		var count int
+		if err := rows.Scan(&day, &count); err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		counts[day.Format("2006-01-02")] = count
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to count ads per day")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("days_count", len(counts)), attribute.String("status", "success"))
+	return counts, nil
+}
+
+// CountAdsByTenant returns the number of ads of each tenant per is_active value, with tracing.
+// It feeds the ads_total gauge, which is labeled by tenant, so it counts across tenants.
+func (r *Repository) CountAdsByTenant(ctx context.Context) (_ map[string]map[bool]int, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "CountAdsByTenantRepository")
+	defer span.End()
+	defer observeQuery("count_ads_by_tenant", time.Now(), &err, ctx)
+
+	rows, err := r.DB.QueryContext(ctx, "SELECT tenant_id, is_active, COUNT(*) FROM ads GROUP BY tenant_id, is_active")
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to count ads")
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]map[bool]int{}
+	for rows.Next() {
+		var tenantID string
+		var isActive bool
+		var count int
+		if err := rows.Scan(&tenantID, &isActive, &count); err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		// Both states of a tenant are reported even when none of its ads has one
+		if counts[tenantID] == nil {
+			counts[tenantID] = map[bool]int{true: 0, false: 0}
+		}
+		counts[tenantID][isActive] = count
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to count ads")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("tenants_count", len(counts)), attribute.String("status", "success"))
+	return counts, nil
+}
+
+// escapeLike escapes the LIKE wildcards and the escape character itself so the input matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// SuggestTitles returns up to limit distinct titles of active ads starting with prefix, with tracing.
+// The prefix-only LIKE pattern lets MySQL use the title index. With a locale, the translated
+// titles in that locale are suggested too.
+func (r *Repository) SuggestTitles(prefix, locale string, limit int, ctx context.Context) (_ []string, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "SuggestTitlesRepository")
+	defer span.End()
+	defer observeQuery("suggest_titles", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query := "SELECT DISTINCT title FROM ads WHERE " + tenantCondition + " AND title LIKE ? AND " + activeCondition + " ORDER BY title LIMIT ?"
+	params := []interface{}{tenantID, escapeLike(prefix) + "%", limit}
+	if locale != "" {
+		// The translated titles of the locale are searched alongside the default ones
+		query = "SELECT title FROM ads WHERE " + tenantCondition + " AND title LIKE ? AND " + activeCondition + " " +
+			"UNION SELECT t.title FROM ad_translations t JOIN ads ON ads.id = t.ad_id WHERE ads." + tenantCondition + " AND t.locale = ? AND t.title LIKE ? AND " + activeCondition + " " +
+			"ORDER BY title LIMIT ?"
+		params = []interface{}{tenantID, escapeLike(prefix) + "%", tenantID, locale, escapeLike(prefix) + "%", limit}
+	}
+	rows, err := r.DB.QueryContext(ctx, query, params...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to suggest titles")
+		return nil, err
+	}
+	defer rows.Close()
+
+	titles := []string{}
+	for rows.Next() {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		titles = append(titles, title)
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to suggest titles")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("titles_count", len(titles)), attribute.String("status", "success"))
+	return titles, nil
+}
+
+// ExistsAd reports whether the tenant has an ad with the given ID, with tracing
+func (r *Repository) ExistsAd(id int64, ctx context.Context) (_ bool, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "ExistsAdRepository")
+	defer span.End()
+	defer observeQuery("exists_ad", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return false, err
+	}
+	var one int
+	err = r.DB.QueryRowContext(ctx, "SELECT 1 FROM ads WHERE id = ? AND "+tenantCondition, id, tenantID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		span.SetAttributes(attribute.Int64("ad_id", id), attribute.Bool("exists", false))
+		return false, nil
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to check ad existence")
+		return false, err
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.Bool("exists", true))
+	return true, nil
+}
+
+// SetStatus moves an ad to a moderation status and records the change in the audit log, in one
+// transaction, with tracing. A nil reason clears the stored one.
+func (r *Repository) SetStatus(id int64, status string, reason *string, actor string, ctx context.Context) (err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "SetStatusRepository")
+	defer span.End()
+	defer observeQuery("set_status", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to begin transaction")
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE ads SET status = ?, status_reason = ? WHERE id = ? AND "+tenantCondition, status, reason, id, tenantID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to update status")
+		return fmt.Errorf("could not update status: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("could not retrieve affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		span.RecordError(ErrAdNotFound)
+		span.SetStatus(codes.Error, "Ad not found")
+		return ErrAdNotFound
+	}
+
+	if err := insertAudit(tx, AuditEntry{AdID: id, Action: status, Actor: actor, Detail: reason}, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to record audit entry")
+		return err
+	}
+
+	if err := r.enqueueSearch(tx, tenantID, []int64{id}, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to queue search update")
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to commit transaction")
+		return fmt.Errorf("could not commit status change: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("ad_status", status), attribute.String("status", "success"))
+	return nil
+}
+
+// RenewAd stores a renewal of an ad and records it in the audit log, in one transaction, with
+// tracing. The renewal counters and the new expiry are computed by the caller.
+func (r *Repository) RenewAd(ad *Ad, actor string, ctx context.Context) (err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "RenewAdRepository")
+	defer span.End()
+	defer observeQuery("renew_ad", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to begin transaction")
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	query := "UPDATE ads SET renewed_at = ?, expires_at = ?, renewal_count = ?, renewal_window_start = ? WHERE id = ? AND " + tenantCondition
+	result, err := tx.ExecContext(ctx, query, ad.RenewedAt, ad.ExpiresAt, ad.RenewalCount, ad.RenewalWindowStart, ad.ID, tenantID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to renew ad")
+		return fmt.Errorf("could not renew ad: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("could not retrieve affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		span.RecordError(ErrAdNotFound)
+		span.SetStatus(codes.Error, "Ad not found")
+		return ErrAdNotFound
+	}
+
+	if err := insertAudit(tx, AuditEntry{AdID: ad.ID, Action: "renewed", Actor: actor}, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to record audit entry")
+		return err
+	}
+
+	if err := r.enqueueSearch(tx, tenantID, []int64{ad.ID}, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to queue search update")
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to commit transaction")
+		return fmt.Errorf("could not commit renewal: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", ad.ID), attribute.Int("renewal_count", ad.RenewalCount), attribute.String("status", "success"))
+	return nil
+}
+
+// SetArchived archives an ad at the given time, or unarchives it when archivedAt is nil, and
+// records the change in the audit log, in one transaction, with tracing
+func (r *Repository) SetArchived(id int64, archivedAt *time.Time, actor string, ctx context.Context) (err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "SetArchivedRepository")
+	defer span.End()
+	defer observeQuery("set_archived", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to begin transaction")
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE ads SET archived_at = ? WHERE id = ? AND "+tenantCondition, archivedAt, id, tenantID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to update archived_at")
+		return fmt.Errorf("could not update archived_at: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("could not retrieve affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		span.RecordError(ErrAdNotFound)
+		span.SetStatus(codes.Error, "Ad not found")
+		return ErrAdNotFound
+	}
+
+	action := "archived"
+	if archivedAt == nil {
+		action = "unarchived"
+	}
+	if err := insertAudit(tx, AuditEntry{AdID: id, Action: action, Actor: actor}, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to record audit entry")
+		return err
+	}
+
+	if err := r.enqueueSearch(tx, tenantID, []int64{id}, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to queue search update")
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to commit transaction")
+		return fmt.Errorf("could not commit archive change: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.Bool("archived", archivedAt != nil), attribute.String("status", "success"))
+	return nil
+}
+
+// AddFavorite saves an ad for a user and bumps its favorites_count, in one transaction, with
+// tracing. It reports whether the favorite is new; saving an ad twice changes nothing.
+func (r *Repository) AddFavorite(userID string, adID int64, ctx context.Context) (_ bool, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "AddFavoriteRepository")
+	defer span.End()
+	defer observeQuery("add_favorite", time.Now(), &err, ctx)
+
+	return r.changeFavorite(
+		"INSERT IGNORE INTO favorites (user_id, ad_id) SELECT ?, id FROM ads WHERE id = ? AND "+tenantCondition,
+		"UPDATE ads SET favorites_count = favorites_count + 1 WHERE id = ? AND "+tenantCondition,
+		userID, adID, ctx)
+}
+
+// RemoveFavorite removes a saved ad of a user and lowers its favorites_count, in one transaction,
+// with tracing. It reports whether there was a favorite to remove.
+func (r *Repository) RemoveFavorite(userID string, adID int64, ctx context.Context) (_ bool, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "RemoveFavoriteRepository")
+	defer span.End()
+	defer observeQuery("remove_favorite", time.Now(), &err, ctx)
+
+	return r.changeFavorite(
+		"DELETE FROM favorites WHERE user_id = ? AND ad_id = ? AND "+tenantAdCondition,
+		"UPDATE ads SET favorites_count = favorites_count - 1 WHERE id = ? AND "+tenantCondition+" AND favorites_count > 0",
+		userID, adID, ctx)
+}
+
+// changeFavorite runs a favorites write taking (user_id, ad_id, tenant_id) and, when it changed a
+// row, the matching counter update taking (ad ID, tenant_id)
+func (r *Repository) changeFavorite(write, counter string, userID string, adID int64, ctx context.Context) (bool, error) {
+	span := trace.SpanFromContext(ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return false, err
+	}
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to begin transaction")
+		return false, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, write, userID, adID, tenantID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to write favorite")
+		return false, fmt.Errorf("could not write favorite: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return false, fmt.Errorf("could not retrieve affected rows: %w", err)
+	}
+	changed := rowsAffected > 0
+	if changed {
+		if _, err := tx.ExecContext(ctx, counter, adID, tenantID); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to update favorites_count")
+			return false, fmt.Errorf("could not update favorites_count: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to commit transaction")
+		return false, fmt.Errorf("could not commit favorite: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", adID), attribute.Bool("changed", changed), attribute.String("status", "success"))
+	return changed, nil
+}
+
+// IsFavorited reports whether a user has saved an ad, with tracing
+func (r *Repository) IsFavorited(userID string, adID int64, ctx context.Context) (_ bool, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "IsFavoritedRepository")
+	defer span.End()
+	defer observeQuery("is_favorited", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return false, err
+	}
+	var favorited bool
+	query := "SELECT EXISTS(SELECT 1 FROM favorites WHERE user_id = ? AND ad_id = ? AND " + tenantAdCondition + ")"
+	if err := r.DB.QueryRowContext(ctx, query, userID, adID, tenantID).Scan(&favorited); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to check favorite")
+		return false, err
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", adID), attribute.Bool("favorited", favorited))
+	return favorited, nil
+}
+
+// GetFavorites lists the ads saved by a user, most recently saved first, with tracing. Favorites
+// of deleted ads are removed with the ad by the foreign key.
+func (r *Repository) GetFavorites(userID string, page, limit int, ctx context.Context) (_ []Favorite, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "GetFavoritesRepository")
+	defer span.End()
+	defer observeQuery("get_favorites", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := (page - 1) * limit
+	query := "SELECT f.created_at, " + prefixedAdColumns("a") + " FROM favorites f JOIN ads a ON a.id = f.ad_id " +
+		"WHERE f.user_id = ? AND a." + tenantCondition + " ORDER BY f.created_at DESC, f.ad_id DESC LIMIT ? OFFSET ?"
+	rows, err := r.DB.QueryContext(ctx, query, userID, tenantID, limit, offset)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve favorites")
+		return nil, err
+	}
+	defer rows.Close()
+
+	favorites := []Favorite{}
+	for rows.Next() {
+		var favorite Favorite
+		var ad Ad
+		if err := scanAd(prefixedScanner{rows, &favorite.FavoritedAt}, &ad); err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		favorite.AdID = ad.ID
+		favorite.Ad = &ad
+		favorites = append(favorites, favorite)
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve favorites")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("favorites_count", len(favorites)), attribute.String("status", "success"))
+	return favorites, nil
+}
+
+// prefixedScanner scans leading columns into extra destinations before handing the rest to scanAd
+type prefixedScanner struct {
+	row    rowScanner
+	prefix interface{}
+}
+
+func (p prefixedScanner) Scan(dest ...interface{}) error {
+	return p.row.Scan(append([]interface{}{p.prefix}, dest...)...)
+}
+
+// reportColumns is the column list selected for a full Report, in the order expected by scanReport
+const reportColumns = "id, ad_id, reporter_id, reason, comment, status, created_at, resolved_at, resolved_by"
+
+// scanReport reads a row selected with reportColumns into the given Report
+func scanReport(row rowScanner, report *Report) error {
+	err := row.Scan(&report.ID, &report.AdID, &report.ReporterID, &report.Reason, &report.Comment, &report.Status, &report.CreatedAt, &report.ResolvedAt, &report.ResolvedBy)
+	if err != nil {
+		return err
+	}
+	report.CreatedAt, report.ResolvedAt = report.CreatedAt.UTC(), timestamp.UTC(report.ResolvedAt)
+	return nil
+}
+
+// AddReport stores an abuse report, with tracing. It reports whether the report is new; a
+// repeated report of the same ad by the same user is ignored.
+func (r *Repository) AddReport(report *Report, ctx context.Context) (_ bool, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "AddReportRepository")
+	defer span.End()
+	defer observeQuery("add_report", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// Selecting the ad within the tenant files nothing against another tenant's ad
+	query := "INSERT IGNORE INTO ad_reports (ad_id, reporter_id, reason, comment) SELECT id, ?, ?, ? FROM ads WHERE id = ? AND " + tenantCondition
+	result, err := r.DB.ExecContext(ctx, query, report.ReporterID, report.Reason, report.Comment, report.AdID, tenantID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to insert report")
+		return false, fmt.Errorf("could not insert report: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return false, fmt.Errorf("could not retrieve affected rows: %w", err)
+	}
+	created := rowsAffected > 0
+	if created {
+		if report.ID, err = result.LastInsertId(); err != nil {
+			span.RecordError(err)
+			return false, fmt.Errorf("could not retrieve last insert ID: %w", err)
+		}
+		report.Status = ReportOpen
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", report.AdID), attribute.Bool("created", created), attribute.String("status", "success"))
+	return created, nil
+}
+
+// ReportTimesSince returns when a user filed each report in the tenant after the given time,
+// oldest first, with tracing. The times are the sliding window of the report rate limit.
+func (r *Repository) ReportTimesSince(reporterID string, since time.Time, ctx context.Context) (_ []time.Time, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "ReportTimesSinceRepository")
+	defer span.End()
+	defer observeQuery("report_times_since", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT created_at FROM ad_reports WHERE reporter_id = ? AND created_at > ? AND " + tenantAdCondition + " ORDER BY created_at"
+	rows, err := r.DB.QueryContext(ctx, query, reporterID, since, tenantID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to list report times")
+		return nil, fmt.Errorf("could not list report times: %w", err)
+	}
+	defer rows.Close()
+
+	var times []time.Time
+	for rows.Next() {
+		var t time.Time
+		if err := rows.Scan(&t); err != nil {
+			span.RecordError(err)
+			return nil, fmt.Errorf("could not scan report time: %w", err)
+		}
+		times = append(times, t.UTC())
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("could not list report times: %w", err)
+	}
+	span.SetAttributes(attribute.Int("reports_count", len(times)))
+	return times, nil
+}
+
+// CountOpenReports counts the open reports of an ad, each from a distinct user, with tracing
+func (r *Repository) CountOpenReports(adID int64, ctx context.Context) (_ int, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "CountOpenReportsRepository")
+	defer span.End()
+	defer observeQuery("count_open_reports", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	query := "SELECT COUNT(*) FROM ad_reports WHERE ad_id = ? AND status = ? AND " + tenantAdCondition
+	if err := r.DB.QueryRowContext(ctx, query, adID, ReportOpen, tenantID).Scan(&count); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to count reports")
+		return 0, err
+	}
+	span.SetAttributes(attribute.Int64("ad_id", adID), attribute.Int("open_reports", count))
+	return count, nil
+}
+
+// GetReports lists the tenant's reports, oldest first, optionally only those with the given status, with tracing
+func (r *Repository) GetReports(status string, page, limit int, ctx context.Context) (_ []Report, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "GetReportsRepository")
+	defer span.End()
+	defer observeQuery("get_reports", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT " + reportColumns + " FROM ad_reports WHERE " + tenantAdCondition
+	params := []interface{}{tenantID}
+	if status != "" {
+		query += " AND status = ?"
+		params = append(params, status)
+	}
+	query += " ORDER BY created_at, id LIMIT ? OFFSET ?"
+	params = append(params, limit, (page-1)*limit)
+
+	rows, err := r.DB.QueryContext(ctx, query, params...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve reports")
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		var report Report
+		if err := scanReport(rows, &report); err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve reports")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("reports_count", len(reports)), attribute.String("status", "success"))
+	return reports, nil
+}
+
+// ResolveReport closes an open report as dismissed or taken down on behalf of actor, in one
+// transaction, with tracing. Taking down deactivates the ad, resolves every other open report
+// of it and records the take-down in the audit log. The resolved report is returned.
+func (r *Repository) ResolveReport(id int64, resolution, actor string, ctx context.Context) (_ *Report, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "ResolveReportRepository")
+	defer span.End()
+	defer observeQuery("resolve_report", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to begin transaction")
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	// Lock the report so two admins can't resolve it at once
+	var report Report
+	err = scanReport(tx.QueryRowContext(ctx, "SELECT "+reportColumns+" FROM ad_reports WHERE id = ? AND "+tenantAdCondition+" FOR UPDATE", id, tenantID), &report)
+	if errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(ErrReportNotFound)
+		span.SetStatus(codes.Error, "Report not found")
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve report")
+		return nil, fmt.Errorf("could not retrieve report: %w", err)
+	}
+	if report.Status != ReportOpen {
+		span.RecordError(ErrReportResolved)
+		span.SetStatus(codes.Error, "Report already resolved")
+		return nil, ErrReportResolved
+	}
+
+	// A take-down closes every open report of the ad, a dismissal only this one. The report was
+	// found within the tenant, so its ad is the tenant's.
+	query := "UPDATE ad_reports SET status = ?, resolved_at = NOW(), resolved_by = ? WHERE id = ?"
+	params := []interface{}{resolution, actor, id}
+	if resolution == ReportTakenDown {
+		query = "UPDATE ad_reports SET status = ?, resolved_at = NOW(), resolved_by = ? WHERE ad_id = ? AND status = ?"
+		params = []interface{}{resolution, actor, report.AdID, ReportOpen}
+	}
+	if _, err := tx.ExecContext(ctx, query, params...); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to resolve report")
+		return nil, fmt.Errorf("could not resolve report: %w", err)
+	}
+
+	if resolution == ReportTakenDown {
+		if _, err := tx.ExecContext(ctx, "UPDATE ads SET is_active = FALSE WHERE id = ?", report.AdID); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to deactivate ad")
+			return nil, fmt.Errorf("could not deactivate ad: %w", err)
+		}
+		detail := fmt.Sprintf("report %d", id)
+		if err := insertAudit(tx, AuditEntry{AdID: report.AdID, Action: "taken_down", Actor: actor, Detail: &detail}, ctx); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to record audit entry")
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to commit transaction")
+		return nil, fmt.Errorf("could not commit report resolution: %w", err)
+	}
+
+	now := time.Now()
+	report.Status = resolution
+	report.ResolvedAt = &now
+	report.ResolvedBy = &actor
+
+	span.SetAttributes(attribute.Int64("ad_id", report.AdID), attribute.String("resolution", resolution), attribute.String("status", "success"))
+	return &report, nil
+}
+
+// DeleteAd deletes an ad by ID, with tracing
+func (r *Repository) DeleteAd(id int64, ctx context.Context) (err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "DeleteAdRepository")
+	defer span.End()
+	defer observeQuery("delete_ad", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The ad and its search outbox entry are written together
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to begin transaction")
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
 	// Prepare the SQL query to delete the ad by its ID
-	query := "DELETE FROM ads WHERE id = ?"
-	result, err := r.DB.ExecContext(ctx, query, id)
+	query := "DELETE FROM ads WHERE id = ? AND " + tenantCondition
+	result, err := tx.ExecContext(ctx, query, id, tenantID)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to delete ad")
-		return fmt.Errorf("could not delete ad: %v", err)
+		return fmt.Errorf("could not delete ad: %w", err)
 	}
 
 	// Check if any rows were affected (if no rows, the ad wasn't found)
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		span.RecordError(err)
-		return fmt.Errorf("could not retrieve affected rows: %v", err)
+		return fmt.Errorf("could not retrieve affected rows: %w", err)
 	}
 	if rowsAffected == 0 {
 		span.RecordError(ErrAdNotFound)
@@ -200,6 +1984,18 @@ func (r *Repository) DeleteAd(id int, ctx context.Context) error {
 		return ErrAdNotFound // Ad not found
 	}
 
-	span.SetAttributes(attribute.Int("ad_id", id), attribute.String("status", "deleted"))
+	if err := r.enqueueSearch(tx, tenantID, []int64{id}, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to queue search update")
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to commit transaction")
+		return fmt.Errorf("could not commit ad deletion: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("status", "deleted"))
 	return nil
 }
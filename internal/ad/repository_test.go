@@ -0,0 +1,528 @@
+package ad
+
+import (
+	"ad_service/internal/apperr"
+	"ad_service/pkg/metrics"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestUpdateAdWritesIsActiveOnlyWhenGiven(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		// active is the value written to is_active, nil when the column is left alone
+		active *bool
+	}{
+		{"left out", `{"title": "Bike", "description": "Red bike", "price": 10}`, nil},
+		{"true", `{"title": "Bike", "description": "Red bike", "price": 10, "is_active": true}`, boolPtr(true)},
+		{"false", `{"title": "Bike", "description": "Red bike", "price": 10, "is_active": false}`, boolPtr(false)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req UpdateAdRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatal(err)
+			}
+			service, mock := newTestService(t)
+
+			args := []driver.Value{"Bike", "Red bike", mustAmount("10"), "", nil, nil, nil, nil}
+			query := `UPDATE ads SET title = \?, description = \?, price = \?, category = \?, expires_at = \?, campaign_id = \?, latitude = \?, longitude = \?, updated_at = CURRENT_TIMESTAMP WHERE`
+			if tt.active != nil {
+				args = append(args, *tt.active)
+				query = `updated_at = CURRENT_TIMESTAMP, is_active = \? WHERE`
+			}
+			args = append(args, int64(7), "default")
+			mock.ExpectBegin()
+			mock.ExpectExec(query).WithArgs(args...).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			if err := service.Repo.UpdateAd(7, req.ToAd(), testCtx()); err != nil {
+				t.Fatalf("UpdateAd: %v", err)
+			}
+		})
+	}
+}
+
+func TestUpdateAdOfArchivedAd(t *testing.T) {
+	service, mock := newTestService(t)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE ads SET .* AND archived_at IS NULL").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT archived_at IS NOT NULL FROM ads").WithArgs(int64(7), "default").
+		WillReturnRows(sqlmock.NewRows([]string{"archived"}).AddRow(true))
+	mock.ExpectRollback()
+
+	ad := testAd(7, "Bike")
+	if err := service.Repo.UpdateAd(7, &ad, testCtx()); !errors.Is(err, ErrArchived) {
+		t.Errorf("UpdateAd of an archived ad = %v, want ErrArchived", err)
+	}
+}
+
+// boolPtr returns a pointer to b
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// batchAds returns n ads for AddAds
+func batchAds(n int) []*Ad {
+	ads := make([]*Ad, n)
+	for i := range ads {
+		ad := testAd(0, fmt.Sprintf("Ad %d", i+1))
+		ads[i] = &ad
+	}
+	return ads
+}
+
+// expectAdsChunk expects the statements of one insertAdsChunk of size ads starting at firstID
+func expectAdsChunk(mock sqlmock.Sqlmock, firstID int64, size int, created time.Time) {
+	mock.ExpectExec("INSERT INTO ads").WillReturnResult(sqlmock.NewResult(firstID, int64(size)))
+	mock.ExpectExec("UPDATE ads SET slug = CASE id").WillReturnResult(sqlmock.NewResult(0, int64(size)))
+	rows := sqlmock.NewRows([]string{"id", "created_at"})
+	for i := 0; i < size; i++ {
+		rows.AddRow(firstID+int64(i), created)
+	}
+	mock.ExpectQuery("SELECT id, created_at FROM ads WHERE id BETWEEN").WithArgs(firstID, firstID+int64(size)-1).WillReturnRows(rows)
+}
+
+func TestAddAdsEmpty(t *testing.T) {
+	service, _ := newTestService(t)
+	// Nothing is sent to the database, not even a transaction
+	if err := service.Repo.AddAds(nil, testCtx()); err != nil {
+		t.Errorf("AddAds(nil) = %v", err)
+	}
+}
+
+func TestAddAdsChunks(t *testing.T) {
+	created := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		count  int
+		chunks []int
+	}{
+		{"one ad", 1, []int{1}},
+		{"exactly one chunk", addAdsChunkSize, []int{addAdsChunkSize}},
+		{"one over a chunk", addAdsChunkSize + 1, []int{addAdsChunkSize, 1}},
+		{"several chunks", 2*addAdsChunkSize + 5, []int{addAdsChunkSize, addAdsChunkSize, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mock := newTestService(t)
+			mock.ExpectBegin()
+			firstID := int64(1000)
+			for _, size := range tt.chunks {
+				expectAdsChunk(mock, firstID, size, created)
+				// AUTO_INCREMENT may leave gaps between statements
+				firstID += int64(size) + 10
+			}
+			mock.ExpectCommit()
+
+			ads := batchAds(tt.count)
+			if err := service.Repo.AddAds(ads, testCtx()); err != nil {
+				t.Fatalf("AddAds: %v", err)
+			}
+			firstID = 1000
+			i := 0
+			for _, size := range tt.chunks {
+				for j := 0; j < size; j++ {
+					ad := ads[i]
+					if want := firstID + int64(j); ad.ID != want {
+						t.Fatalf("ad %d has ID %d, want %d", i, ad.ID, want)
+					}
+					if !ad.CreatedAt.Equal(created) || !ad.RenewedAt.Equal(created) {
+						t.Errorf("ad %d created_at %s, renewed_at %s; want %s", i, ad.CreatedAt, ad.RenewedAt, created)
+					}
+					if ad.Slug == nil || *ad.Slug != Slugify(ad.Title, ad.ID) {
+						t.Errorf("ad %d slug = %v, want the slug of its title", i, ad.Slug)
+					}
+					i++
+				}
+				firstID += int64(size) + 10
+			}
+		})
+	}
+}
+
+func TestAddAdsRollsBackFailedChunk(t *testing.T) {
+	service, mock := newTestService(t)
+	mock.ExpectBegin()
+	expectAdsChunk(mock, 1, addAdsChunkSize, time.Now())
+	mock.ExpectExec("INSERT INTO ads").WillReturnError(errors.New("Deadlock found"))
+	mock.ExpectRollback()
+
+	err := service.Repo.AddAds(batchAds(addAdsChunkSize+1), testCtx())
+	if err == nil || !strings.Contains(err.Error(), "Deadlock found") {
+		t.Errorf("AddAds with a failing second chunk = %v, want its error", err)
+	}
+}
+
+// canceledAfterFirstChunk is a context the repository sees canceled once the first chunk has
+// read back its created_at values. database/sql watches Done, which never closes, so only the
+// check between chunks notices.
+type canceledAfterFirstChunk struct {
+	context.Context
+	canceled atomic.Bool
+}
+
+func (c *canceledAfterFirstChunk) Err() error {
+	if c.canceled.Load() {
+		return context.Canceled
+	}
+	return nil
+}
+
+// cancelOnMatch is a query argument that cancels ctx when the query runs
+type cancelOnMatch struct {
+	ctx  *canceledAfterFirstChunk
+	want int64
+}
+
+func (m cancelOnMatch) Match(v driver.Value) bool {
+	m.ctx.canceled.Store(true)
+	return v == m.want
+}
+
+func TestAddAdsStopsBetweenChunksWhenCanceled(t *testing.T) {
+	service, mock := newTestService(t)
+	ctx := &canceledAfterFirstChunk{Context: testCtx()}
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO ads").WillReturnResult(sqlmock.NewResult(1, addAdsChunkSize))
+	mock.ExpectExec("UPDATE ads SET slug").WillReturnResult(sqlmock.NewResult(0, addAdsChunkSize))
+	rows := sqlmock.NewRows([]string{"id", "created_at"})
+	for i := 1; i <= addAdsChunkSize; i++ {
+		rows.AddRow(int64(i), time.Now())
+	}
+	mock.ExpectQuery("SELECT id, created_at FROM ads").WithArgs(int64(1), cancelOnMatch{ctx, addAdsChunkSize}).WillReturnRows(rows)
+	// The second chunk is never sent
+	mock.ExpectRollback()
+
+	if err := service.Repo.AddAds(batchAds(2*addAdsChunkSize), ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("AddAds canceled after the first chunk = %v, want context.Canceled", err)
+	}
+}
+
+// offsetRecorder is a query argument that records the offsets GetRandomAd reads at
+type offsetRecorder struct {
+	seen map[int64]int
+}
+
+func (r offsetRecorder) Match(v driver.Value) bool {
+	offset, ok := v.(int64)
+	r.seen[offset]++
+	return ok
+}
+
+func TestGetRandomAdReachesEveryAd(t *testing.T) {
+	service, mock := newTestService(t)
+	const eligible, draws = 5, 1000
+	offsets := offsetRecorder{seen: map[int64]int{}}
+	filter := RandomAdFilter{Category: "bikes"}
+	for i := 0; i < draws; i++ {
+		// Only live ads of the tenant in the category are counted and read
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM ads WHERE tenant_id = \? AND status = 'approved' AND archived_at IS NULL AND is_active = TRUE AND \(expires_at IS NULL OR expires_at > NOW\(\)\) .* AND category = \?$`).
+			WithArgs("default", "bikes").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(eligible))
+		mock.ExpectQuery(`ORDER BY id LIMIT 1 OFFSET \?$`).WithArgs("default", "bikes", offsets).WillReturnRows(plainAdRows(testAd(1, "Bike")))
+		expectNoTranslations(mock)
+		if _, err := service.Repo.GetRandomAd(filter, testCtx()); err != nil {
+			t.Fatalf("GetRandomAd: %v", err)
+		}
+	}
+
+	// Every offset is drawn about draws/eligible = 200 times; 120 and 280 are more than six
+	// standard deviations away
+	for offset := int64(0); offset < eligible; offset++ {
+		if n := offsets.seen[offset]; n < 120 || n > 280 {
+			t.Errorf("offset %d drawn %d times in %d draws, want about %d: %v", offset, n, draws, draws/eligible, offsets.seen)
+		}
+	}
+	if len(offsets.seen) != eligible {
+		t.Errorf("offsets drawn = %v, want only 0 to %d", offsets.seen, eligible-1)
+	}
+}
+
+func TestGetRandomAdWithoutEligibleAds(t *testing.T) {
+	service, mock := newTestService(t)
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM ads`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	if _, err := service.Repo.GetRandomAd(RandomAdFilter{}, testCtx()); !errors.Is(err, ErrAdNotFound) {
+		t.Errorf("GetRandomAd without eligible ads = %v, want ErrAdNotFound", err)
+	}
+
+	// An ad deleted between the count and the read is not found either
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM ads`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`OFFSET \?$`).WillReturnRows(plainAdRows())
+	if _, err := service.Repo.GetRandomAd(RandomAdFilter{}, testCtx()); !errors.Is(err, ErrAdNotFound) {
+		t.Errorf("GetRandomAd after the ad was deleted = %v, want ErrAdNotFound", err)
+	}
+}
+
+// queryObservations returns how many times a repository method was timed with outcome
+func queryObservations(t *testing.T, method, outcome string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := metrics.DBQueryDuration.WithLabelValues(method, outcome).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestQueryDurationIsObserved(t *testing.T) {
+	service, mock := newTestService(t)
+	ctx := testCtx()
+	type series struct{ method, outcome string }
+	observed := map[series]uint64{}
+	for _, s := range []series{
+		{"get_ad_by_id", "ok"}, {"get_ad_by_id", "not_found"}, {"get_ad_by_id", "error"},
+		{"get_all_ads", "ok"}, {"get_all_ads", "error"}, {"delete_ad", "not_found"},
+	} {
+		observed[s] = queryObservations(t, s.method, s.outcome)
+	}
+	rows := testutil.ToFloat64(metrics.DBRowsReturned.WithLabelValues("get_all_ads"))
+
+	mock.ExpectQuery("FROM ads WHERE id").WillReturnRows(adRows(testAd(1, "Bike")))
+	expectNoTranslations(mock)
+	service.Repo.GetAdByID(1, ctx)
+	mock.ExpectQuery("FROM ads WHERE id").WillReturnRows(adRows())
+	service.Repo.GetAdByID(2, ctx)
+	mock.ExpectQuery("FROM ads WHERE id").WillReturnError(errors.New("connection reset"))
+	service.Repo.GetAdByID(3, ctx)
+
+	mock.ExpectQuery("FROM ads").WillReturnRows(plainAdRows(testAd(1, "Bike"), testAd(2, "Car"), testAd(3, "Boat")))
+	expectNoTranslations(mock)
+	service.Repo.GetAllAds(ListQuery{Page: 1, Limit: 10, SortBy: "id", Order: "asc"}, ctx)
+	mock.ExpectQuery("FROM ads").WillReturnError(errors.New("connection reset"))
+	service.Repo.GetAllAds(ListQuery{Page: 1, Limit: 10, SortBy: "id", Order: "asc"}, ctx)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM ads").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	service.Repo.DeleteAd(4, ctx)
+
+	for s, before := range observed {
+		if got := queryObservations(t, s.method, s.outcome) - before; got != 1 {
+			t.Errorf("%s %s observed %d times, want 1", s.method, s.outcome, got)
+		}
+	}
+	// Only the successful listing counts its rows
+	if got := testutil.ToFloat64(metrics.DBRowsReturned.WithLabelValues("get_all_ads")) - rows; got != 3 {
+		t.Errorf("rows returned by get_all_ads = %v, want 3", got)
+	}
+}
+
+func TestRepositoryErrorsAreDomainErrors(t *testing.T) {
+	insertAd := func(r *Repository) error {
+		ad := testAd(0, "Bike")
+		return r.AddAd(&ad, testCtx())
+	}
+	getAd := func(r *Repository) error {
+		_, err := r.GetAdByID(7, testCtx())
+		return err
+	}
+	deleteAd := func(r *Repository) error { return r.DeleteAd(7, testCtx()) }
+
+	tests := []struct {
+		name   string
+		expect func(mock sqlmock.Sqlmock)
+		call   func(r *Repository) error
+		kind   error
+		// cause is the driver error that must stay reachable, nil when it is replaced
+		cause error
+	}{
+		{"missing ad", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("FROM ads WHERE id = ").WillReturnRows(sqlmock.NewRows(testAdColumns))
+		}, getAd, apperr.ErrNotFound, nil},
+		{"nothing deleted", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectExec("DELETE FROM ads").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectRollback()
+		}, deleteAd, apperr.ErrNotFound, nil},
+		// database/sql retries driver.ErrBadConn itself, the MySQL driver reports a dropped connection like this
+		{"lost connection", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("FROM ads WHERE id = ").WillReturnError(mysql.ErrInvalidConn)
+		}, getAd, apperr.ErrUnavailable, mysql.ErrInvalidConn},
+		{"query timeout", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("FROM ads WHERE id = ").WillReturnError(context.DeadlineExceeded)
+		}, getAd, apperr.ErrUnavailable, context.DeadlineExceeded},
+		{"begin failure", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin().WillReturnError(errors.New("too many connections"))
+		}, deleteAd, apperr.ErrUnavailable, nil},
+		{"duplicate key", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectExec("INSERT INTO ads").WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+			mock.ExpectRollback()
+		}, insertAd, apperr.ErrConflict, &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}},
+		{"unknown campaign", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectExec("INSERT INTO ads").WillReturnError(&mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row"})
+			mock.ExpectRollback()
+		}, insertAd, apperr.ErrValidation, &mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row"}},
+		{"lock wait timeout", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectExec("DELETE FROM ads").WillReturnError(&mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"})
+			mock.ExpectRollback()
+		}, deleteAd, apperr.ErrUnavailable, &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}},
+		{"failed commit", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectExec("DELETE FROM ads").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit().WillReturnError(sql.ErrConnDone)
+		}, deleteAd, apperr.ErrUnavailable, sql.ErrConnDone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mock := newTestService(t)
+			tt.expect(mock)
+
+			err := tt.call(service.Repo)
+			if !errors.Is(err, tt.kind) || !apperr.Kinded(err) {
+				t.Fatalf("error = %v, want kind %v", err, tt.kind)
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("error = %v leaks sql.ErrNoRows", err)
+			}
+			if tt.kind == apperr.ErrNotFound && err != ErrAdNotFound {
+				t.Errorf("error = %v, want ErrAdNotFound", err)
+			}
+			var mysqlErr *mysql.MySQLError
+			switch cause := tt.cause.(type) {
+			case nil:
+			case *mysql.MySQLError:
+				if !errors.As(err, &mysqlErr) || mysqlErr.Number != cause.Number {
+					t.Errorf("error = %v lost the MySQL error %d", err, cause.Number)
+				}
+			default:
+				if !errors.Is(err, cause) {
+					t.Errorf("error = %v lost its cause %v", err, cause)
+				}
+			}
+		})
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := map[string]string{
+		"bike":      "bike",
+		"50%":       `50\%`,
+		"a_b":       `a\_b`,
+		`C:\path`:   `C:\\path`,
+		"%%":        `\%\%`,
+		"__":        `\_\_`,
+		`\%`:        `\\\%`,
+		`%_\`:       `\%\_\\`,
+		"ско_рость": `ско\_рость`,
+	}
+	for in, want := range tests {
+		if got := escapeLike(in); got != want {
+			t.Errorf("escapeLike(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTitleContainsComposesWithFilters(t *testing.T) {
+	service, mock := newTestService(t)
+	// Only wildcard characters still match literally, next to every other filter
+	q := ListQuery{
+		Filter: ListFilter{Status: StatusApproved, CampaignID: 3, TitleContains: `%_\`},
+		SortBy: "price",
+		Order:  "asc",
+		Page:   3,
+		Limit:  5,
+	}
+	query, args, err := q.build("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(query, " AND title LIKE ?") || !strings.HasSuffix(query, " ORDER BY price asc LIMIT ? OFFSET ?") {
+		t.Errorf("query = %s, want the LIKE condition ahead of the sort and page", query)
+	}
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg
+	}
+	if pattern := values[len(values)-3]; pattern != `%\%\_\\%` {
+		t.Errorf("pattern = %v, want the escaped input between wildcards", pattern)
+	}
+	if limit, offset := values[len(values)-2], values[len(values)-1]; limit != 5 || offset != 10 {
+		t.Errorf("LIMIT %v OFFSET %v, want 5 and 10", limit, offset)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(values...).WillReturnRows(plainAdRows(testAd(1, `100% wool_socks\`)))
+	expectNoTranslations(mock)
+	ads, err := service.Repo.GetAllAds(q, testCtx())
+	if err != nil || len(ads) != 1 {
+		t.Errorf("GetAllAds = %v, %v; want the matching ad", ads, err)
+	}
+}
+
+func TestRepositoryFailureInjection(t *testing.T) {
+	list := ListQuery{SortBy: "id", Order: "asc", Page: 1, Limit: 10}
+	canceled, cancel := context.WithCancel(testCtx())
+	cancel()
+	broken := errors.New("connection reset by peer")
+
+	tests := []struct {
+		name string
+		// inject sets up the failure and returns the error of the call
+		inject func(r *Repository, mock sqlmock.Sqlmock) error
+		kind   error
+		cause  error
+	}{
+		{"closed database", func(r *Repository, mock sqlmock.Sqlmock) error {
+			mock.ExpectClose()
+			r.DB.Close()
+			_, err := r.GetAdByID(7, testCtx())
+			return err
+		}, apperr.ErrUnavailable, nil},
+		{"canceled context", func(r *Repository, mock sqlmock.Sqlmock) error {
+			_, err := r.GetAdByID(7, canceled)
+			return err
+		}, apperr.ErrUnavailable, context.Canceled},
+		{"canceled listing", func(r *Repository, mock sqlmock.Sqlmock) error {
+			_, err := r.GetAllAds(list, canceled)
+			return err
+		}, apperr.ErrUnavailable, context.Canceled},
+		{"scan mismatch", func(r *Repository, mock sqlmock.Sqlmock) error {
+			mock.ExpectQuery("FROM ads WHERE id = ").WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(7, "Bike"))
+			_, err := r.GetAdByID(7, testCtx())
+			return err
+		}, apperr.ErrUnavailable, nil},
+		{"listing scan mismatch", func(r *Repository, mock sqlmock.Sqlmock) error {
+			mock.ExpectQuery("FROM ads").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+			_, err := r.GetAllAds(list, testCtx())
+			return err
+		}, apperr.ErrUnavailable, nil},
+		// An error while reading the rows isn't mistaken for the end of the listing
+		{"error between rows", func(r *Repository, mock sqlmock.Sqlmock) error {
+			mock.ExpectQuery("FROM ads").WillReturnRows(plainAdRows(testAd(1, "Bike"), testAd(2, "Car")).RowError(1, broken))
+			ads, err := r.GetAllAds(list, testCtx())
+			if ads != nil {
+				t.Errorf("GetAllAds = %d ads with an error, want none", len(ads))
+			}
+			return err
+		}, apperr.ErrUnavailable, broken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mock := newTestService(t)
+			err := tt.inject(service.Repo, mock)
+			if !errors.Is(err, tt.kind) || errors.Is(err, apperr.ErrNotFound) {
+				t.Fatalf("error = %v, want only kind %v", err, tt.kind)
+			}
+			if tt.cause != nil && !errors.Is(err, tt.cause) {
+				t.Errorf("error = %v lost its cause %v", err, tt.cause)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+/*
+This file holds the text search of GET /ads?q=. Two engines answer it so they can be compared on
+live traffic: "like" matches the words as one literal phrase anywhere in the title or description,
+and "fulltext" looks them up in the FULLTEXT index on (title, description) with MATCH ... AGAINST
+in natural language mode, scoring each ad by relevance. MySQL leaves stop words and words shorter
+than innodb_ft_min_token_size out of the index, so a query made only of those would match
+nothing; such queries are answered by the LIKE engine instead.
+*/
+package ad
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Search engines of GET /ads?q=, chosen with engine= or ads.searchEngine
+const (
+	SearchLike     = "like"
+	SearchFullText = "fulltext"
+)
+
+// Bounds for q, the text search of GET /ads and GET /ads/search, in characters
+const (
+	MinSearchLength = 2
+	MaxSearchLength = 100
+)
+
+// scoreColumn is the relevance of an ad to the query bound to its placeholder
+const scoreColumn = "MATCH(title, description) AGAINST (? IN NATURAL LANGUAGE MODE)"
+
+// fullTextStopwords is InnoDB's default stop word list (INFORMATION_SCHEMA.INNODB_FT_DEFAULT_STOPWORD)
+var fullTextStopwords = map[string]bool{
+	"a": true, "about": true, "an": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"com": true, "de": true, "en": true, "for": true, "from": true, "how": true, "i": true, "in": true,
+	"is": true, "it": true, "la": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+	"this": true, "to": true, "was": true, "what": true, "when": true, "where": true, "who": true,
+	"will": true, "with": true, "und": true, "www": true,
+}
+
+// fullTextSearchable reports whether the query has a word the FULLTEXT index holds: one that is
+// not a stop word and has at least minTokenLength characters
+func fullTextSearchable(query string, minTokenLength int) bool {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	for _, word := range words {
+		if utf8.RuneCountInString(word) >= minTokenLength && !fullTextStopwords[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// searchEngine is the engine that answers the query when engine was asked for, falling back to
+// LIKE when the FULLTEXT index can't match it
+func searchEngine(query, engine string, minTokenLength int) string {
+	if engine == SearchFullText && !fullTextSearchable(query, minTokenLength) {
+		return SearchLike
+	}
+	return engine
+}
+
+// PublicSearch is the filter of a public text search for query, narrowed to category unless it is
+// empty. The engine is ads.searchEngine, falling back to LIKE like GET /ads?q= without engine=.
+func (s *AdService) PublicSearch(query, category string) ListFilter {
+	return ListFilter{
+		Status:       StatusApproved,
+		Category:     category,
+		Search:       query,
+		SearchEngine: searchEngine(query, s.Rules.Load().SearchEngine, s.Rules.Load().SearchMinTokenLength),
+	}
+}
@@ -0,0 +1,104 @@
+/*
+This file holds the writes only the seed command needs: backdating generated ads and wiping the
+ads before a fresh seed.
+*/
+package ad
+
+import (
+	"ad_service/pkg/tenant"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// BackdateAds writes the CreatedAt of each ad to created_at and renewed_at with one UPDATE, with
+// tracing. Inserts always take the current time, so seeded ads are spread out afterwards.
+func (r *Repository) BackdateAds(ads []*Ad, ctx context.Context) (err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "BackdateAdsRepository")
+	defer span.End()
+	defer observeQuery("backdate_ads", time.Now(), &err, ctx)
+
+	span.SetAttributes(attribute.Int("ads_count", len(ads)))
+	if len(ads) == 0 {
+		return nil
+	}
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	params := make([]interface{}, 0, len(ads)*3+1)
+	for _, ad := range ads {
+		params = append(params, ad.ID, ad.CreatedAt)
+	}
+	params = append(params, tenantID)
+	for _, ad := range ads {
+		params = append(params, ad.ID)
+	}
+	query := "UPDATE ads SET created_at = CASE id " + strings.Repeat("WHEN ? THEN ? ", len(ads)) + "END, " +
+		"renewed_at = created_at, updated_at = created_at " +
+		"WHERE " + tenantCondition + " AND id IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(ads)), ", ") + ")"
+	if _, err := r.DB.ExecContext(ctx, query, params...); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to backdate ads")
+		return fmt.Errorf("could not backdate ads: %w", err)
+	}
+	for _, ad := range ads {
+		ad.RenewedAt = ad.CreatedAt
+	}
+
+	span.SetAttributes(attribute.String("status", "success"))
+	return nil
+}
+
+// DeleteAllAds deletes every ad of the tenant along with its audit log, with tracing. Favorites,
+// reports, keywords, variants and translations go with the ads through their foreign keys.
+func (r *Repository) DeleteAllAds(ctx context.Context) (_ int64, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "DeleteAllAdsRepository")
+	defer span.End()
+	defer observeQuery("delete_all_ads", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to begin transaction")
+		return 0, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM ad_audit_log WHERE "+tenantAdCondition, tenantID); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to delete audit log")
+		return 0, fmt.Errorf("could not delete audit log: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM ads WHERE "+tenantCondition, tenantID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to delete ads")
+		return 0, fmt.Errorf("could not delete ads: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return 0, fmt.Errorf("could not retrieve affected rows: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to commit transaction")
+		return 0, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int64("deleted", deleted), attribute.String("status", "success"))
+	return deleted, nil
+}
@@ -7,54 +7,325 @@ and coordination between different components (like the repository and the handl
 package ad
 
 import (
+	"ad_service/internal/apperr"
+	"ad_service/internal/config"
 	"ad_service/pkg/cache"
+	"ad_service/pkg/metrics"
+	"ad_service/pkg/ratelimit"
+	"ad_service/pkg/tenant"
 	"context"
-	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"math/rand"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
-var adCache = cache.NewCache()
-
 type AdService struct {
-	Repo *Repository
+	Repo  *Repository
+	Cache cache.Cache                            // optional, caching is skipped when nil
+	TTL   *config.Reloadable[config.CacheConfig] // read on every use, TTLs change on hot reload
+	Rules *config.Reloadable[config.AdsConfig]   // read on every use, the rate limits change on hot reload
+
+	// Invalidator tells other replicas to drop their in-process copies after a write, optional
+	Invalidator *cache.Invalidator
+	// Trending counts served ads for GET /ads/trending, optional
+	Trending *cache.Trending
+
+	// snapshots hold the servable ads of each tenant used by ServeAd between refreshes
+	snapshotsMu sync.Mutex
+	snapshots   map[string]*serveSnapshot
+	// adLoads coalesces concurrent cache misses of the same ad in GetAdByID
+	adLoads singleflight.Group
+	// hot is the set of most read ads kept cached by the refresher, nil until it is started
+	hot *hotKeys
+	// impressions queues the impressions of served ads, nil until the workers are started
+	impressions *impressionQueue
+	// sweepingLists is set while a background delete of stale list pages is running
+	sweepingLists atomic.Bool
+}
+
+// cache returns the injected cache, or a no-op cache when none was set
+func (s *AdService) cache() cache.Cache {
+	if s.Cache == nil {
+		return cache.NoopCache{}
+	}
+	return s.Cache
+}
+
+// recordCacheLookup counts a cache lookup for an entity. Lookups are counted as "bypass"
+// when there is no cache or the entity's TTL disables caching.
+func (s *AdService) recordCacheLookup(entity, outcome string, ttl time.Duration) {
+	if s.Cache == nil || ttl <= 0 {
+		outcome = "bypass"
+	}
+	metrics.CacheOperations.WithLabelValues(entity, outcome).Inc()
+}
+
+// EvictLocal drops in-process state derived from the given cache keys, which are qualified with
+// their tenant by cache.TenantKey. It is called for invalidations received from other replicas.
+// A serve snapshot key of no tenant drops the snapshots of every tenant.
+func (s *AdService) EvictLocal(keys []string) {
+	for _, key := range keys {
+		tenantID, key, scoped := cache.SplitTenantKey(key)
+		if key != serveSnapshotKey {
+			continue
+		}
+		s.snapshotsMu.Lock()
+		for id, snapshot := range s.snapshots {
+			if !scoped || id == tenantID {
+				snapshot.mu.Lock()
+				snapshot.loadedAt = time.Time{}
+				snapshot.mu.Unlock()
+			}
+		}
+		s.snapshotsMu.Unlock()
+	}
+}
+
+// invalidateServeSnapshot drops the shared and local serve snapshots after a write to an ad,
+// and asks the other replicas to drop their local copies too
+func (s *AdService) invalidateServeSnapshot(id int64, ctx context.Context) {
+	s.evictEverywhere([]string{adCacheKey(id), serveSnapshotKey}, ctx)
+}
+
+// InvalidateServing drops the list pages and serve snapshots after a change outside the ads table that decides
+// which ads are servable, such as a campaign being paused
+func (s *AdService) InvalidateServing(ctx context.Context) {
+	s.invalidateLists(ctx)
+	s.evictEverywhere([]string{serveSnapshotKey}, ctx)
+}
+
+// InvalidateAds drops the cached copies of ads changed outside this service, such as ads taken
+// out of a deleted campaign, along with the list pages and serve snapshots
+func (s *AdService) InvalidateAds(ids []int64, ctx context.Context) {
+	keys := []string{serveSnapshotKey}
+	for _, id := range ids {
+		s.cache().Delete(adCacheKey(id), ctx)
+		keys = append(keys, adCacheKey(id))
+	}
+	s.invalidateLists(ctx)
+	s.evictEverywhere(keys, ctx)
+}
+
+// evictEverywhere drops the serve snapshot from the shared cache, then evicts keys locally and
+// on the other replicas. The keys are qualified with the tenant of ctx, so only its state is
+// dropped.
+func (s *AdService) evictEverywhere(keys []string, ctx context.Context) {
+	s.cache().Delete(serveSnapshotKey, ctx)
+	scoped := make([]string, len(keys))
+	for i, key := range keys {
+		scoped[i] = cache.TenantKey(key, ctx)
+	}
+	s.EvictLocal(scoped)
+	if s.Invalidator == nil {
+		return
+	}
+	if err := s.Invalidator.Publish(scoped, ctx); err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+	}
+}
+
+// serveSnapshotTTL is how long a snapshot of servable ads is reused before being refreshed
+const serveSnapshotTTL = 30 * time.Second
+
+// serveSnapshot is the in-process copy of the servable ads of a tenant, refreshed from Redis (or MySQL) every serveSnapshotTTL
+type serveSnapshot struct {
+	mu       sync.Mutex
+	ads      []Ad
+	loadedAt time.Time
+}
+
+// snapshotFor returns the serve snapshot of a tenant, creating an empty one on first use
+func (s *AdService) snapshotFor(tenantID string) *serveSnapshot {
+	s.snapshotsMu.Lock()
+	defer s.snapshotsMu.Unlock()
+	if s.snapshots == nil {
+		s.snapshots = map[string]*serveSnapshot{}
+	}
+	snapshot, ok := s.snapshots[tenantID]
+	if !ok {
+		snapshot = &serveSnapshot{}
+		s.snapshots[tenantID] = snapshot
+	}
+	return snapshot
+}
+
+// notFoundCacheValue is cached for IDs that don't exist so repeated lookups skip the database
+const notFoundCacheValue = "__not_found__"
+
+// currentListCacheKey builds the cache key for a page of ads from the full parameter set and the current generation
+func (s *AdService) currentListCacheKey(q ListQuery, ctx context.Context) string {
+	generation, err := s.cache().Get(listGenerationKey, ctx)
+	if err != nil || generation == "" {
+		generation = "0"
+	}
+	return listCacheKey(generation, q)
+}
+
+// listSweepTimeout bounds the background delete of stale list pages started by invalidateLists
+const listSweepTimeout = 5 * time.Second
+
+// invalidateLists makes every cached page of GET /ads stale by bumping the list generation,
+// then deletes the old pages in the background so they don't sit in Redis until they expire.
+// Only one delete runs at a time: a write made while one is running leaves its stale pages to
+// that delete or to their TTL.
+func (s *AdService) invalidateLists(ctx context.Context) {
+	if _, err := s.cache().Incr(listGenerationKey, ctx); err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+	}
+	if !s.sweepingLists.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer s.sweepingLists.Store(false)
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), listSweepTimeout)
+		defer cancel()
+		s.cache().DeleteByPrefix(listKeyPrefix, ctx)
+	}()
+}
+
+// ErrAdBusy is returned when another write to the same ad kept its lock for longer than cache.mutationLockWait
+var ErrAdBusy = apperr.New(apperr.ErrConflict, "Ad is being modified by another request")
+
+// lockAd takes the per-ad lock that serializes updates and deletes of one ad across replicas, so
+// the database write and the cache refresh of two requests can't interleave. A zero
+// cache.mutationLockTTL disables locking, and if the cache fails the write goes ahead unlocked.
+func (s *AdService) lockAd(id int64, ctx context.Context) (*cache.Lock, error) {
+	if s.TTL.Load().MutationLockTTL <= 0 {
+		return nil, nil
+	}
+	lock, err := cache.AcquireLock(s.cache(), adCacheKey(id), s.TTL.Load().MutationLockTTL, s.TTL.Load().MutationLockWait, ctx)
+	switch {
+	case errors.Is(err, cache.ErrLockNotAcquired):
+		return nil, ErrAdBusy
+	case err != nil && ctx.Err() != nil:
+		return nil, apperr.Wrap(apperr.ErrUnavailable, "Could not lock ad", err)
+	case err != nil:
+		trace.SpanFromContext(ctx).RecordError(err)
+		return nil, nil
+	}
+	return lock, nil
+}
+
+// Supported values for cache.writeMode
+const (
+	WriteModeWriteThrough = "write_through"
+	WriteModeInvalidate   = "invalidate"
+)
+
+// refreshAdCache brings the cache entry of a freshly written ad up to date: it stores the ad
+// in write-through mode and deletes the entry in invalidate mode
+func (s *AdService) refreshAdCache(ad *Ad, ctx context.Context) {
+	cacheKey := adCacheKey(ad.ID)
+	if s.TTL.Load().WriteMode != WriteModeWriteThrough {
+		s.cache().Delete(cacheKey, ctx)
+		return
+	}
+
+	adBytes, err := json.Marshal(ad)
+	if err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+		s.cache().Delete(cacheKey, ctx)
+		return
+	}
+	s.cache().Set(cacheKey, string(adBytes), s.TTL.Load().AdTTL, ctx)
 }
 
-// AddAd adds a new ad to the database, with tracing
-func (s *AdService) AddAd(ad *Ad, ctx context.Context) error {
+// AddAd adds a new ad to the database, with tracing. An owned ad created by anyone but an admin
+// must fit the owner's quota, or a *QuotaError is returned.
+func (s *AdService) AddAd(ad *Ad, admin bool, ctx context.Context) error {
 	tracer := otel.Tracer("ad-service.service")
 	ctx, span := tracer.Start(ctx, "AddAdService")
 	defer span.End()
 
+	ownerID, limited := quotaOwner(ad, admin)
+	if limited {
+		if err := s.checkQuota(ownerID, ctx); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Quota check failed")
+			metrics.AdCreateFailures.WithLabelValues("quota").Inc()
+			return err
+		}
+	}
+
 	err := s.Repo.AddAd(ad, ctx)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to add ad")
+		metrics.AdCreateFailures.WithLabelValues("db_error").Inc()
 		return err
 	}
+	metrics.AdsCreated.Inc()
+	if limited {
+		s.recordCreation(ownerID, ctx)
+	}
 
-	span.SetAttributes(attribute.Int("ad_id", ad.ID), attribute.String("status", "success"))
+	// Cache the new ad (replacing any "not found" entry for its ID); list pages no longer reflect the table
+	s.refreshAdCache(ad, ctx)
+	s.invalidateLists(ctx)
+
+	span.SetAttributes(attribute.Int64("ad_id", ad.ID), attribute.String("status", "success"))
 	return nil
 }
 
-// GetAllAds retrieves ads from the database with pagination and sorting, with tracing
-func (s *AdService) GetAllAds(page, limit int, sortBy, order string, ctx context.Context) ([]Ad, error) {
+// GetAllAds retrieves ads from the database with pagination, sorting and filtering, with tracing
+// and caching. Pages filtered by owner are personal and are read from MySQL every time.
+func (s *AdService) GetAllAds(q ListQuery, ctx context.Context) ([]Ad, error) {
 	tracer := otel.Tracer("ad-service.service")
 	ctx, span := tracer.Start(ctx, "GetAllAdsService")
 	defer span.End()
 
-	ads, err := s.Repo.GetAllAds(page, limit, sortBy, order, ctx)
+	if q.Filter.OwnerID != "" {
+		ads, err := s.Repo.GetAllAds(q, ctx)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to retrieve ads")
+			return nil, err
+		}
+		span.SetAttributes(attribute.Int("ads_count", len(ads)), attribute.String("status", "success"))
+		return ads, nil
+	}
+
+	// On a miss only one caller across replicas rebuilds the page from MySQL
+	cacheKey := s.currentListCacheKey(q, ctx)
+	opts := cache.RebuildOptions{TTL: s.TTL.Load().ListTTL, LockTimeout: s.TTL.Load().LockTimeout, LockWait: s.TTL.Load().LockWait}
+	cached, hit, err := cache.GetOrRebuild(s.cache(), cacheKey, opts, func() (string, error) {
+		ads, err := s.Repo.GetAllAds(q, ctx)
+		if err != nil {
+			return "", err
+		}
+		adsBytes, err := json.Marshal(ads)
+		return string(adsBytes), err
+	}, ctx)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to retrieve ads")
 		return nil, err
 	}
+	span.SetAttributes(attribute.Bool("cache_hit", hit), attribute.String("cache_key", cacheKey))
+	if hit {
+		s.recordCacheLookup("list", "hit", s.TTL.Load().ListTTL)
+	} else {
+		s.recordCacheLookup("list", "miss", s.TTL.Load().ListTTL)
+	}
+
+	var ads []Ad
+	if err := json.Unmarshal([]byte(cached), &ads); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to decode ads")
+		return nil, err
+	}
 
 	span.SetAttributes(attribute.Int("ads_count", len(ads)), attribute.String("status", "success"))
 	return ads, nil
@@ -62,85 +333,1277 @@ func (s *AdService) GetAllAds(page, limit int, sortBy, order string, ctx context
 
 // GetAdByID retrieves a single ad by its ID, with tracing and caching
 
-func (s *AdService) GetAdByID(id int, ctx context.Context) (*Ad, error) {
+func (s *AdService) GetAdByID(id int64, ctx context.Context) (*Ad, error) {
 	tracer := otel.Tracer("ad-service.service")
 	ctx, span := tracer.Start(ctx, "GetAdByIDService")
 	defer span.End()
 
-	cacheKey := "ad_" + strconv.Itoa(id)
+	cacheKey := adCacheKey(id)
+	s.countRead(id, ctx)
 
 	// Trace cache retrieval attempt
-	cachedAd, err := adCache.Get(cacheKey, ctx)
-	if err == nil && cachedAd != "" {
-		span.SetAttributes(attribute.String("cache_status", "found"), attribute.String("cache_key", cacheKey))
-
+	cachedAd, err := s.cache().Get(cacheKey, ctx)
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("cache_status", "error"), attribute.String("cache_key", cacheKey))
+		s.recordCacheLookup("ad", "error", s.TTL.Load().AdTTL)
+	case cachedAd == notFoundCacheValue:
+		span.SetAttributes(attribute.String("cache_status", "negative hit"), attribute.String("cache_key", cacheKey))
+		s.recordCacheLookup("ad", "negative_hit", s.TTL.Load().AdTTL)
+		return nil, ErrAdNotFound
+	case cachedAd != "":
 		var ad Ad
 		if err := json.Unmarshal([]byte(cachedAd), &ad); err == nil {
+			span.SetAttributes(attribute.String("cache_status", "found"), attribute.String("cache_key", cacheKey))
+			s.recordCacheLookup("ad", "hit", s.TTL.Load().AdTTL)
 			return &ad, nil
 		}
+		// A corrupt entry counts as a miss and is overwritten below
 		span.RecordError(err)
-	} else {
+		span.SetAttributes(attribute.String("cache_status", "corrupt"), attribute.String("cache_key", cacheKey))
+		s.recordCacheLookup("ad", "miss", s.TTL.Load().AdTTL)
+	default:
 		span.SetAttributes(attribute.String("cache_status", "not found"), attribute.String("cache_key", cacheKey))
+		s.recordCacheLookup("ad", "miss", s.TTL.Load().AdTTL)
+	}
+
+	// Cache miss: concurrent misses of the same ad share one query and one cache write. The
+	// query runs detached from the cancellation of whichever caller started it, so the others
+	// aren't failed by a client that went away, but it keeps that caller's deadline.
+	loadCtx := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		loadCtx, cancel = context.WithDeadline(loadCtx, deadline)
+		defer cancel()
+	}
+	leader := false
+	loaded := s.adLoads.DoChan(cacheKey, func() (interface{}, error) {
+		leader = true
+		return s.loadAd(id, loadCtx)
+	})
+
+	var result singleflight.Result
+	select {
+	case result = <-loaded:
+	case <-ctx.Done():
+		span.RecordError(ctx.Err())
+		return nil, ctx.Err()
+	}
+	// The result was sent after the load returned, so reading leader is safe
+	if !leader {
+		metrics.CoalescedLoads.WithLabelValues("ad").Inc()
+		span.SetAttributes(attribute.Bool("coalesced", true))
 	}
+	if result.Err != nil {
+		if errors.Is(result.Err, ErrAdNotFound) {
+			span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("error", "Ad not found"))
+			return nil, result.Err
+		}
+		span.RecordError(result.Err)
+		span.SetStatus(codes.Error, "Failed to retrieve ad")
+		return nil, result.Err
+	}
+
+	// Every caller gets its own copy, since handlers localize and convert the ad in place
+	ad := *result.Val.(*Ad)
+	span.SetAttributes(attribute.Int64("ad_id", ad.ID), attribute.String("db_status", "Successfully retrieved by ID"))
+	return &ad, nil
+}
+
+// loadAd reads an ad from the database and caches it, or caches that it doesn't exist. It runs
+// once for all the concurrent cache misses of the ad in GetAdByID. Other errors are returned to
+// the callers waiting at that moment and then forgotten, so the next miss queries again.
+func (s *AdService) loadAd(id int64, ctx context.Context) (*Ad, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "LoadAdService")
+	defer span.End()
 
-	// Cache miss, trace database query
+	cacheKey := adCacheKey(id)
 	ad, err := s.Repo.GetAdByID(id, ctx)
+	if errors.Is(err, ErrAdNotFound) {
+		s.cache().Set(cacheKey, notFoundCacheValue, s.TTL.Load().NegativeTTL, ctx)
+		return nil, err
+	}
 	if err != nil {
-		if err == sql.ErrNoRows {
-			span.SetAttributes(attribute.Int("ad_id", id), attribute.String("error", "Ad not found"))
-			return nil, err // Return sql.ErrNoRows directly
-		}
 		span.RecordError(err)
-		span.SetStatus(codes.Error, "Failed to retrieve ad")
 		return nil, err
 	}
 
 	// Cache the result
 	adBytes, err := json.Marshal(ad)
 	if err == nil {
-		adCache.Set(cacheKey, string(adBytes), 5*time.Minute, ctx)
+		s.cache().Set(cacheKey, string(adBytes), s.TTL.Load().AdTTL, ctx)
 		span.SetAttributes(attribute.String("cache_status", "set"))
 	} else {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to set to cache")
 	}
+	return ad, nil
+}
+
+// GetAdByPublicID retrieves a single ad by its public ID, with tracing. The public ID is resolved
+// in the database and the ad is then read through GetAdByID, sharing its cache entry.
+func (s *AdService) GetAdByPublicID(publicID string, ctx context.Context) (*Ad, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "GetAdByPublicIDService")
+	defer span.End()
+
+	id, err := s.Repo.GetAdIDByPublicID(publicID, ctx)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	return s.GetAdByID(id, ctx)
+}
+
+// GetAdBySlug retrieves a single ad by its slug, with tracing and caching. The slug is resolved
+// to an ID through a cached mapping and the ad is then read through GetAdByID, sharing its cache
+// entry. A regenerated slug leaves the old mapping behind, so an ad whose slug no longer matches
+// is treated as not found and the mapping is dropped.
+func (s *AdService) GetAdBySlug(slug string, ctx context.Context) (*Ad, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "GetAdBySlugService")
+	defer span.End()
+
+	cacheKey := slugCacheKey(slug)
+	var id int64
+	cached, err := s.cache().Get(cacheKey, ctx)
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("cache_status", "error"), attribute.String("cache_key", cacheKey))
+		s.recordCacheLookup("slug", "error", s.TTL.Load().AdTTL)
+	case cached == notFoundCacheValue:
+		span.SetAttributes(attribute.String("cache_status", "negative hit"), attribute.String("cache_key", cacheKey))
+		s.recordCacheLookup("slug", "negative_hit", s.TTL.Load().AdTTL)
+		return nil, ErrAdNotFound
+	case cached != "":
+		// A corrupt entry counts as a miss and is overwritten below
+		if id, err = strconv.ParseInt(cached, 10, 64); err != nil {
+			span.RecordError(err)
+			id, err = 0, nil
+		}
+	}
+
+	if id != 0 {
+		span.SetAttributes(attribute.String("cache_status", "found"), attribute.String("cache_key", cacheKey))
+		s.recordCacheLookup("slug", "hit", s.TTL.Load().AdTTL)
+	} else {
+		if err == nil {
+			span.SetAttributes(attribute.String("cache_status", "not found"), attribute.String("cache_key", cacheKey))
+			s.recordCacheLookup("slug", "miss", s.TTL.Load().AdTTL)
+		}
+		id, err = s.Repo.GetAdIDBySlug(slug, ctx)
+		if errors.Is(err, ErrAdNotFound) {
+			s.cache().Set(cacheKey, notFoundCacheValue, s.TTL.Load().NegativeTTL, ctx)
+			return nil, err
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to resolve slug")
+			return nil, err
+		}
+		s.cache().Set(cacheKey, strconv.FormatInt(id, 10), s.TTL.Load().AdTTL, ctx)
+	}
 
-	span.SetAttributes(attribute.Int("ad_id", ad.ID), attribute.String("db_status", "Successfully retrieved by ID"))
+	ad, err := s.GetAdByID(id, ctx)
+	if err == nil && (ad.Slug == nil || *ad.Slug != slug) {
+		err = ErrAdNotFound
+	}
+	if errors.Is(err, ErrAdNotFound) {
+		s.cache().Delete(cacheKey, ctx)
+	}
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
 	return ad, nil
 }
 
-// UpdateAd updates an existing ad, with tracing
-func (s *AdService) UpdateAd(id int, ad *Ad, ctx context.Context) error {
+// GetAdsByIDs retrieves the ads with the given IDs in the requested order, with tracing and caching.
+// The per-ad cache is consulted first and only the misses are fetched from the database.
+// IDs that do not exist are returned in missing.
+func (s *AdService) GetAdsByIDs(ids []int64, ctx context.Context) ([]Ad, []int64, error) {
 	tracer := otel.Tracer("ad-service.service")
-	ctx, span := tracer.Start(ctx, "UpdateAdService")
+	ctx, span := tracer.Start(ctx, "GetAdsByIDsService")
+	defer span.End()
+
+	// Read every cached ad in one round trip
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = adCacheKey(id)
+	}
+	cached, err := s.cache().GetMany(keys, ctx)
+	if err != nil {
+		span.RecordError(err)
+		s.recordCacheLookup("ad", "error", s.TTL.Load().AdTTL)
+		cached = map[string]string{}
+	}
+
+	found := make(map[int64]Ad, len(ids))
+	var misses []int64
+	for i, id := range ids {
+		if cachedAd := cached[keys[i]]; cachedAd != "" && cachedAd != notFoundCacheValue {
+			var ad Ad
+			if err := json.Unmarshal([]byte(cachedAd), &ad); err == nil {
+				s.recordCacheLookup("ad", "hit", s.TTL.Load().AdTTL)
+				found[id] = ad
+				continue
+			}
+		}
+		s.recordCacheLookup("ad", "miss", s.TTL.Load().AdTTL)
+		misses = append(misses, id)
+	}
+	span.SetAttributes(attribute.Int("cache_hits", len(found)), attribute.Int("cache_misses", len(misses)))
+
+	// Fetch the misses with one IN query and cache them in one pipeline
+	if len(misses) > 0 {
+		ads, err := s.Repo.GetAdsByIDs(misses, ctx)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to retrieve ads by IDs")
+			return nil, nil, err
+		}
+		toCache := make(map[string]string, len(ads))
+		for _, ad := range ads {
+			found[ad.ID] = ad
+			if adBytes, err := json.Marshal(ad); err == nil {
+				toCache[adCacheKey(ad.ID)] = string(adBytes)
+			}
+		}
+		s.cache().SetMany(toCache, s.TTL.Load().AdTTL, ctx)
+	}
+
+	// Preserve the requested order
+	ads := make([]Ad, 0, len(ids))
+	missing := []int64{}
+	for _, id := range ids {
+		if ad, ok := found[id]; ok {
+			ads = append(ads, ad)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	span.SetAttributes(attribute.Int("ads_count", len(ads)), attribute.Int("missing_count", len(missing)), attribute.String("status", "success"))
+	return ads, missing, nil
+}
+
+// GetRandomAd retrieves one random active ad matching the filter, with tracing
+func (s *AdService) GetRandomAd(filter RandomAdFilter, ctx context.Context) (*Ad, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "GetRandomAdService")
 	defer span.End()
 
-	err := s.Repo.UpdateAd(id, ad, ctx)
+	ad, err := s.Repo.GetRandomAd(filter, ctx)
 	if err != nil {
 		span.RecordError(err)
 		if errors.Is(err, ErrAdNotFound) {
-			span.SetStatus(codes.Error, "Ad not found")
-			return ErrAdNotFound
+			span.SetStatus(codes.Error, "No eligible ads")
+			return nil, ErrAdNotFound
+		}
+		span.SetStatus(codes.Error, "Failed to retrieve random ad")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", ad.ID), attribute.String("status", "success"))
+	return ad, nil
+}
+
+// ServeAd picks one active ad by weighted rotation and records an impression for it, with tracing.
+// With keywords it prefers the ads whose targeting overlaps them most, and falls back to the
+// untargeted ads when none matches. Without keywords every servable ad takes part, read from an
+// in-process snapshot. ErrAdNotFound means nothing is eligible.
+func (s *AdService) ServeAd(keywords []string, ctx context.Context) (*Ad, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "ServeAdService")
+	defer span.End()
+
+	var ads []Ad
+	var matched map[int64][]string
+	if len(keywords) > 0 {
+		span.SetAttributes(attribute.StringSlice("keywords", keywords))
+		matches, err := s.Repo.MatchKeywords(keywords, ctx)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to match keywords")
+			return nil, err
+		}
+		matched = make(map[int64][]string, len(matches))
+		for _, match := range matches {
+			ads = append(ads, match.Ad)
+			matched[match.Ad.ID] = match.Matched
+		}
+	}
+
+	if len(ads) == 0 {
+		snapshot, err := s.servableAds(ctx)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to load servable ads")
+			return nil, err
+		}
+		ads = snapshot
+		if len(keywords) > 0 {
+			ads = untargeted(snapshot)
+			metrics.AdServeKeywordMatches.WithLabelValues("fallback").Inc()
+		}
+	} else {
+		metrics.AdServeKeywordMatches.WithLabelValues("matched").Inc()
+	}
+	span.SetAttributes(attribute.Int("eligible_count", len(ads)))
+
+	ad := pickWeighted(ads)
+	if ad == nil {
+		return nil, ErrAdNotFound
+	}
+	if words, ok := matched[ad.ID]; ok {
+		span.SetAttributes(attribute.StringSlice("matched_keywords", words), attribute.Int("keyword_overlap", len(words)))
+	}
+	applyVariant(ad)
+	if ad.Variant != nil {
+		span.SetAttributes(attribute.String("variant", *ad.Variant))
+	}
+
+	// Record the impression without holding up the response
+	s.recordImpression(ad.ID, ad.Variant, ctx)
+
+	span.SetAttributes(attribute.Int64("ad_id", ad.ID), attribute.Int("weight", ad.Weight), attribute.String("status", "success"))
+	return ad, nil
+}
+
+// applyVariant merges a variant of the ad, picked by weight, into its title and description and
+// names it in Variant. Ads without variants are left as they are.
+func applyVariant(ad *Ad) {
+	total := 0
+	for _, variant := range ad.Variants {
+		total += variant.Weight
+	}
+	if total > 0 {
+		n := rand.Intn(total)
+		for _, variant := range ad.Variants {
+			if n < variant.Weight {
+				ad.Title, ad.Description = variant.Title, variant.Description
+				key := variant.Key
+				ad.Variant = &key
+				break
+			}
+			n -= variant.Weight
+		}
+	}
+	ad.Variants = nil
+}
+
+// untargeted returns the ads without targeting keywords
+func untargeted(ads []Ad) []Ad {
+	result := []Ad{}
+	for _, ad := range ads {
+		if len(ad.Keywords) == 0 {
+			result = append(result, ad)
+		}
+	}
+	return result
+}
+
+// servableAds returns the current snapshot of the tenant's servable ads, refreshing it when it is older than serveSnapshotTTL.
+// A refresh reads the shared snapshot from Redis and only falls back to MySQL when Redis has none.
+func (s *AdService) servableAds(ctx context.Context) ([]Ad, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := s.snapshotFor(tenantID)
+	snapshot.mu.Lock()
+	defer snapshot.mu.Unlock()
+
+	if !snapshot.loadedAt.IsZero() && time.Since(snapshot.loadedAt) < serveSnapshotTTL {
+		return snapshot.ads, nil
+	}
+
+	span := trace.SpanFromContext(ctx)
+	cached, err := s.cache().Get(serveSnapshotKey, ctx)
+	if err == nil && cached != "" {
+		var ads []Ad
+		if err := json.Unmarshal([]byte(cached), &ads); err == nil {
+			span.SetAttributes(attribute.String("snapshot_source", "cache"))
+			snapshot.ads, snapshot.loadedAt = ads, time.Now()
+			return ads, nil
 		}
-		span.SetStatus(codes.Error, "Failed to update ad")
-		return err
 	}
 
-	// Invalidate cache for this ad
-	cacheKey := "ad_" + strconv.Itoa(id)
-	adCache.Delete(cacheKey, ctx)
+	ads, err := s.Repo.GetServableAds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.String("snapshot_source", "db"))
+	if adsBytes, err := json.Marshal(ads); err == nil {
+		s.cache().Set(serveSnapshotKey, string(adsBytes), serveSnapshotTTL, ctx)
+	}
+	snapshot.ads, snapshot.loadedAt = ads, time.Now()
+	return ads, nil
+}
+
+// pickWeighted chooses an ad with probability proportional to its weight, or nil when there is none
+func pickWeighted(ads []Ad) *Ad {
+	total := 0
+	for _, ad := range ads {
+		if ad.Weight > 0 {
+			total += ad.Weight
+		}
+	}
+	if total == 0 {
+		return nil
+	}
 
-	span.SetAttributes(attribute.Int("ad_id", id), attribute.String("status", "updated"))
+	n := rand.Intn(total)
+	for i := range ads {
+		if ads[i].Weight <= 0 {
+			continue
+		}
+		if n < ads[i].Weight {
+			ad := ads[i]
+			return &ad
+		}
+		n -= ads[i].Weight
+	}
 	return nil
 }
 
-// DeleteAd deletes an ad by ID, with tracing
-func (s *AdService) DeleteAd(id int, ctx context.Context) error {
+// DailyCount is the number of ads created on a single day
+type DailyCount struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+// GetDailyStats returns the number of ads created per day between from and to (both inclusive, UTC days),
+// zero-filled for days without ads, with tracing and caching
+func (s *AdService) GetDailyStats(from, to time.Time, isActive *bool, ctx context.Context) ([]DailyCount, error) {
 	tracer := otel.Tracer("ad-service.service")
-	ctx, span := tracer.Start(ctx, "DeleteAdService")
+	ctx, span := tracer.Start(ctx, "GetDailyStatsService")
+	defer span.End()
+
+	cacheKey := dailyStatsCacheKey(from, to, isActive)
+
+	cached, err := s.cache().Get(cacheKey, ctx)
+	if err == nil && cached != "" {
+		var stats []DailyCount
+		if err := json.Unmarshal([]byte(cached), &stats); err == nil {
+			span.SetAttributes(attribute.String("cache_status", "found"), attribute.String("cache_key", cacheKey))
+			s.recordCacheLookup("count", "hit", s.TTL.Load().CountTTL)
+			return stats, nil
+		}
+	}
+	span.SetAttributes(attribute.String("cache_status", "not found"), attribute.String("cache_key", cacheKey))
+	if err != nil {
+		s.recordCacheLookup("count", "error", s.TTL.Load().CountTTL)
+	} else {
+		s.recordCacheLookup("count", "miss", s.TTL.Load().CountTTL)
+	}
+
+	// The upper bound is exclusive, so query up to the start of the day after "to"
+	counts, err := s.Repo.CountAdsPerDay(from, to.AddDate(0, 0, 1), isActive, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to count ads per day")
+		return nil, err
+	}
+	stats := fillDailyCounts(from, to, counts)
+
+	if statsBytes, err := json.Marshal(stats); err == nil {
+		s.cache().Set(cacheKey, string(statsBytes), s.TTL.Load().CountTTL, ctx)
+	}
+
+	span.SetAttributes(attribute.Int("days_count", len(stats)), attribute.String("status", "success"))
+	return stats, nil
+}
+
+// fillDailyCounts returns one bucket per UTC day from "from" to "to" inclusive, using zero for days missing from counts
+func fillDailyCounts(from, to time.Time, counts map[string]int) []DailyCount {
+	stats := []DailyCount{}
+	for day := from.UTC(); !day.After(to.UTC()); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		stats = append(stats, DailyCount{Date: date, Count: counts[date]})
+	}
+	return stats
+}
+
+// suggestTTL is how long title suggestions for a query stay cached
+const suggestTTL = 30 * time.Second
+
+// SuggestTitles returns titles of active ads starting with the query, with tracing and caching.
+// The cache is keyed by the normalized (trimmed, lower-cased) query and the locale.
+func (s *AdService) SuggestTitles(q, locale string, limit int, ctx context.Context) ([]string, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "SuggestTitlesService")
+	defer span.End()
+
+	normalized := strings.ToLower(strings.TrimSpace(q))
+	cacheKey := suggestCacheKey(normalized, locale, limit)
+
+	cached, err := s.cache().Get(cacheKey, ctx)
+	if err == nil && cached != "" {
+		var titles []string
+		if err := json.Unmarshal([]byte(cached), &titles); err == nil {
+			span.SetAttributes(attribute.String("cache_status", "found"))
+			return titles, nil
+		}
+	}
+	span.SetAttributes(attribute.String("cache_status", "not found"))
+
+	titles, err := s.Repo.SuggestTitles(normalized, locale, limit, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to suggest titles")
+		return nil, err
+	}
+	if titlesBytes, err := json.Marshal(titles); err == nil {
+		s.cache().Set(cacheKey, string(titlesBytes), suggestTTL, ctx)
+	}
+
+	span.SetAttributes(attribute.Int("titles_count", len(titles)), attribute.String("status", "success"))
+	return titles, nil
+}
+
+// similarTTL is how long the similar ads of a source ad stay cached
+const similarTTL = time.Minute
+
+// GetSimilarAds returns ads similar to the source ad, with tracing and caching. Entries are not
+// invalidated on writes and just expire after similarTTL.
+func (s *AdService) GetSimilarAds(source *Ad, limit int, ctx context.Context) ([]Ad, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "GetSimilarAdsService")
 	defer span.End()
 
-	err := s.Repo.DeleteAd(id, ctx)
+	cacheKey := similarCacheKey(source.ID, limit)
+	cached, err := s.cache().Get(cacheKey, ctx)
+	if err == nil && cached != "" {
+		var ads []Ad
+		if err := json.Unmarshal([]byte(cached), &ads); err == nil {
+			span.SetAttributes(attribute.String("cache_status", "found"))
+			s.recordCacheLookup("similar", "hit", similarTTL)
+			return ads, nil
+		}
+	}
+	span.SetAttributes(attribute.String("cache_status", "not found"))
+	s.recordCacheLookup("similar", "miss", similarTTL)
+
+	ads, err := s.Repo.GetSimilarAds(source, limit, s.Rules.Load().SimilarExcludeOwner, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve similar ads")
+		return nil, err
+	}
+	if adsBytes, err := json.Marshal(ads); err == nil {
+		s.cache().Set(cacheKey, string(adsBytes), similarTTL, ctx)
+	}
+
+	span.SetAttributes(attribute.Int("ads_count", len(ads)), attribute.String("status", "success"))
+	return ads, nil
+}
+
+// CampaignExists reports whether a campaign exists, with tracing
+func (s *AdService) CampaignExists(id int, ctx context.Context) (bool, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "CampaignExistsService")
+	defer span.End()
+
+	exists, err := s.Repo.CampaignExists(id, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to check campaign")
+		return false, err
+	}
+	return exists, nil
+}
+
+// UpdateAd updates an existing ad, with tracing
+func (s *AdService) UpdateAd(id int64, ad *Ad, ctx context.Context) error {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "UpdateAdService")
+	defer span.End()
+
+	lock, err := s.lockAd(id, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Could not lock ad")
+		return err
+	}
+	defer lock.Release(ctx)
+
+	err = s.Repo.UpdateAd(id, ad, ctx)
+	if err != nil {
+		span.RecordError(err)
+		if errors.Is(err, ErrAdNotFound) {
+			span.SetStatus(codes.Error, "Ad not found")
+			return ErrAdNotFound
+		}
+		span.SetStatus(codes.Error, "Failed to update ad")
+		return err
+	}
+
+	// Write the post-update state through to the cache. The request body may omit columns
+	// (e.g. is_active), so the full row is read back; if that fails the entry is just invalidated.
+	if s.TTL.Load().WriteMode == WriteModeWriteThrough {
+		if fresh, err := s.Repo.GetAdByID(id, ctx); err == nil {
+			*ad = *fresh
+			s.refreshAdCache(ad, ctx)
+		} else {
+			span.RecordError(err)
+			s.cache().Delete(adCacheKey(id), ctx)
+		}
+	} else {
+		s.cache().Delete(adCacheKey(id), ctx)
+	}
+	s.invalidateLists(ctx)
+	s.invalidateServeSnapshot(id, ctx)
+	metrics.AdsUpdated.Inc()
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("status", "updated"))
+	return nil
+}
+
+// ErrInvalidTransition is returned when an ad can't move from its moderation status to the requested one
+var ErrInvalidTransition = apperr.New(apperr.ErrConflict, "invalid status transition")
+
+// SetStatus moves an ad to a moderation status on behalf of actor, with tracing. The transition
+// is checked against the current status under the ad lock, and the change is audited.
+func (s *AdService) SetStatus(id int64, status string, reason *string, actor string, ctx context.Context) (*Ad, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "SetStatusService")
+	defer span.End()
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("ad_status", status))
+
+	lock, err := s.lockAd(id, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Could not lock ad")
+		return nil, err
+	}
+	defer lock.Release(ctx)
+
+	ad, err := s.Repo.GetAdByID(id, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve ad")
+		return nil, err
+	}
+	if !canTransition(ad.Status, status) {
+		err := apperr.Wrap(apperr.ErrConflict, fmt.Sprintf("Cannot move ad from %s to %s", ad.Status, status), ErrInvalidTransition)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Invalid status transition")
+		return nil, err
+	}
+
+	if err := s.Repo.SetStatus(id, status, reason, actor, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to update status")
+		return nil, err
+	}
+	ad.Status = status
+	ad.StatusReason = reason
+
+	// The status decides whether the ad is public, so every cached view of it is refreshed
+	s.refreshAdCache(ad, ctx)
+	s.invalidateLists(ctx)
+	s.invalidateServeSnapshot(id, ctx)
+	metrics.AdsModerated.WithLabelValues(status).Inc()
+
+	span.SetAttributes(attribute.String("status", "success"))
+	return ad, nil
+}
+
+// renewalWindow is the period RenewalsPerWeek applies to, starting at the first renewal in it
+const renewalWindow = 7 * 24 * time.Hour
+
+// ErrNotOwner is returned when a caller acts on an ad that belongs to another user
+var ErrNotOwner = apperr.New(apperr.ErrForbidden, "Ad belongs to another user")
+
+// RenewalLimitError is returned when an ad has used up its renewals for the current window
+type RenewalLimitError struct {
+	Limit   int
+	ResetAt time.Time // when the window ends and renewals are allowed again
+}
+
+// ErrRenewalLimit is matched by the error mapping for every RenewalLimitError
+var ErrRenewalLimit = errors.New("Renewal limit reached")
+
+func (e *RenewalLimitError) Error() string {
+	return fmt.Sprintf("renewal limit of %d per week reached, resets at %s", e.Limit, e.ResetAt.Format(time.RFC3339))
+}
+
+func (e *RenewalLimitError) Unwrap() error {
+	return ErrRenewalLimit
+}
+
+// ClientDetails names the limit and when the window resets
+func (e *RenewalLimitError) ClientDetails() map[string]any {
+	return map[string]any{
+		"limit":       e.Limit,
+		"reset_at":    e.ResetAt,
+		"retry_after": ratelimit.Seconds(e.RetryAfter(time.Now())),
+	}
+}
+
+// RetryAfter is the wait until the window resets
+func (e *RenewalLimitError) RetryAfter(now time.Time) time.Duration {
+	return e.ResetAt.Sub(now)
+}
+
+// RenewAd bumps an ad owned by userID back to the top of the default listing and extends its
+// expiry, with tracing. Ads without an expiry keep none, and an expiry is never shortened.
+func (s *AdService) RenewAd(id int64, userID string, ctx context.Context) (*Ad, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "RenewAdService")
+	defer span.End()
+	span.SetAttributes(attribute.Int64("ad_id", id))
+
+	lock, err := s.lockAd(id, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Could not lock ad")
+		return nil, err
+	}
+	defer lock.Release(ctx)
+
+	ad, err := s.Repo.GetAdByID(id, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve ad")
+		return nil, err
+	}
+	if ad.OwnerID == nil || *ad.OwnerID != userID {
+		span.RecordError(ErrNotOwner)
+		span.SetStatus(codes.Error, "Not the owner")
+		return nil, ErrNotOwner
+	}
+	if ad.ArchivedAt != nil {
+		span.RecordError(ErrArchived)
+		span.SetStatus(codes.Error, "Ad is archived")
+		return nil, ErrArchived
+	}
+
+	// A new window starts with the first renewal after the previous one has ended
+	now := time.Now().Truncate(time.Second)
+	if ad.RenewalWindowStart == nil || now.Sub(*ad.RenewalWindowStart) >= renewalWindow {
+		ad.RenewalWindowStart = &now
+		ad.RenewalCount = 0
+	}
+	if ad.RenewalCount >= s.Rules.Load().RenewalsPerWeek {
+		err := &RenewalLimitError{Limit: s.Rules.Load().RenewalsPerWeek, ResetAt: ad.RenewalWindowStart.Add(renewalWindow)}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Renewal limit reached")
+		return nil, err
+	}
+	ad.RenewalCount++
+	ad.RenewedAt = now
+	if ad.ExpiresAt != nil {
+		if extended := now.Add(s.Rules.Load().RenewalExtension); extended.After(*ad.ExpiresAt) {
+			ad.ExpiresAt = &extended
+		}
+	}
+
+	if err := s.Repo.RenewAd(ad, userID, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to renew ad")
+		return nil, err
+	}
+
+	// The listing order and possibly the servable set changed
+	s.refreshAdCache(ad, ctx)
+	s.invalidateLists(ctx)
+	s.invalidateServeSnapshot(id, ctx)
+	metrics.AdsRenewed.Inc()
+
+	span.SetAttributes(attribute.Int("renewal_count", ad.RenewalCount), attribute.String("status", "success"))
+	return ad, nil
+}
+
+// Errors returned when archiving an archived ad or unarchiving a live one
+var (
+	ErrAlreadyArchived = apperr.New(apperr.ErrConflict, "Ad is already archived")
+	ErrNotArchived     = apperr.New(apperr.ErrConflict, "Ad is not archived")
+)
+
+// SetArchived archives or unarchives an ad owned by userID, with tracing
+func (s *AdService) SetArchived(id int64, archive bool, userID string, ctx context.Context) (*Ad, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "SetArchivedService")
+	defer span.End()
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.Bool("archive", archive))
+
+	lock, err := s.lockAd(id, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Could not lock ad")
+		return nil, err
+	}
+	defer lock.Release(ctx)
+
+	ad, err := s.Repo.GetAdByID(id, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve ad")
+		return nil, err
+	}
+	if ad.OwnerID == nil || *ad.OwnerID != userID {
+		span.RecordError(ErrNotOwner)
+		span.SetStatus(codes.Error, "Not the owner")
+		return nil, ErrNotOwner
+	}
+	if (ad.ArchivedAt != nil) == archive {
+		err := ErrNotArchived
+		if archive {
+			err = ErrAlreadyArchived
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Already in the requested state")
+		return nil, err
+	}
+
+	ad.ArchivedAt = nil
+	if archive {
+		now := time.Now().Truncate(time.Second)
+		ad.ArchivedAt = &now
+	}
+	if err := s.Repo.SetArchived(id, ad.ArchivedAt, userID, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to update archive state")
+		return nil, err
+	}
+
+	// Archiving takes the ad out of the public listing and the serving rotation, and back
+	s.refreshAdCache(ad, ctx)
+	s.invalidateLists(ctx)
+	s.invalidateServeSnapshot(id, ctx)
+
+	span.SetAttributes(attribute.String("status", "success"))
+	return ad, nil
+}
+
+// SetFavorite saves or unsaves an ad for a user, with tracing. Repeating either is a no-op.
+// Only the cached ad is dropped for its favorites_count; list pages catch up when they expire.
+func (s *AdService) SetFavorite(userID string, adID int64, favorite bool, ctx context.Context) error {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "SetFavoriteService")
+	defer span.End()
+	span.SetAttributes(attribute.Int64("ad_id", adID), attribute.Bool("favorite", favorite))
+
+	var changed bool
+	var err error
+	if favorite {
+		changed, err = s.Repo.AddFavorite(userID, adID, ctx)
+	} else {
+		changed, err = s.Repo.RemoveFavorite(userID, adID, ctx)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to update favorite")
+		return err
+	}
+	if changed {
+		s.cache().Delete(adCacheKey(adID), ctx)
+	}
+
+	span.SetAttributes(attribute.Bool("changed", changed), attribute.String("status", "success"))
+	return nil
+}
+
+// IsFavorited reports whether a user has saved an ad, with tracing
+func (s *AdService) IsFavorited(userID string, adID int64, ctx context.Context) (bool, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "IsFavoritedService")
+	defer span.End()
+
+	favorited, err := s.Repo.IsFavorited(userID, adID, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to check favorite")
+		return false, err
+	}
+	return favorited, nil
+}
+
+// GetFavorites lists the ads saved by a user, with tracing. Favorites are personal and always
+// read from MySQL.
+func (s *AdService) GetFavorites(userID string, page, limit int, ctx context.Context) ([]Favorite, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "GetFavoritesService")
+	defer span.End()
+
+	favorites, err := s.Repo.GetFavorites(userID, page, limit, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve favorites")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("favorites_count", len(favorites)), attribute.String("status", "success"))
+	return favorites, nil
+}
+
+// ErrReportRateLimited is returned when a user has filed ads.reportsPerHour reports in the last hour
+var ErrReportRateLimited = errors.New("Too many reports")
+
+// reportWindow is the length of the sliding window of ads.reportsPerHour
+const reportWindow = time.Hour
+
+// ReportAd files an abuse report, with tracing. It reports whether the report is new; repeats
+// by the same user are ignored. Once an approved ad has more than ads.reportFlagThreshold open
+// reports it goes back to pending, hiding it until an admin reviews it. The returned window is
+// the user's report rate limit after this report, also when it was refused with
+// ErrReportRateLimited; it is zero when the limit couldn't be read.
+func (s *AdService) ReportAd(report *Report, ctx context.Context) (bool, ratelimit.Window, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "ReportAdService")
+	defer span.End()
+	span.SetAttributes(attribute.Int64("ad_id", report.AdID), attribute.String("reason", report.Reason))
+
+	now := time.Now()
+	hits, err := s.Repo.ReportTimesSince(report.ReporterID, now.Add(-reportWindow), ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to count reports")
+		return false, ratelimit.Window{}, err
+	}
+	window := ratelimit.Window{Limit: s.Rules.Load().ReportsPerHour, Length: reportWindow, Hits: hits}
+	if !window.Allowed() {
+		span.RecordError(ErrReportRateLimited)
+		span.SetStatus(codes.Error, "Report rate limit reached")
+		return false, window, ErrReportRateLimited
+	}
+
+	created, err := s.Repo.AddReport(report, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to add report")
+		return false, window, err
+	}
+	if !created {
+		span.SetAttributes(attribute.Bool("duplicate", true))
+		return false, window, nil
+	}
+	window.Record(now)
+	metrics.AdReports.WithLabelValues(report.Reason).Inc()
+
+	open, err := s.Repo.CountOpenReports(report.AdID, ctx)
+	if err != nil {
+		// The report is stored; the next one will check the threshold again
+		span.RecordError(err)
+		return true, window, nil
+	}
+	if open > s.Rules.Load().ReportFlagThreshold {
+		if err := s.flagForReview(report.AdID, open, ctx); err != nil {
+			span.RecordError(err)
+		}
+	}
+
+	span.SetAttributes(attribute.Int("open_reports", open), attribute.String("status", "success"))
+	return true, window, nil
+}
+
+// flagForReview moves an approved ad back to pending after too many reports. This is the one
+// transition outside statusTransitions, and it is made by the service itself rather than an admin.
+func (s *AdService) flagForReview(id int64, reports int, ctx context.Context) error {
+	lock, err := s.lockAd(id, ctx)
+	if err != nil {
+		return err
+	}
+	defer lock.Release(ctx)
+
+	ad, err := s.Repo.GetAdByID(id, ctx)
+	if err != nil {
+		return err
+	}
+	if ad.Status != StatusApproved {
+		return nil
+	}
+
+	reason := fmt.Sprintf("flagged after %d reports", reports)
+	if err := s.Repo.SetStatus(id, StatusPending, &reason, "system", ctx); err != nil {
+		return err
+	}
+	ad.Status = StatusPending
+	ad.StatusReason = &reason
+
+	s.refreshAdCache(ad, ctx)
+	s.invalidateLists(ctx)
+	s.invalidateServeSnapshot(id, ctx)
+	metrics.AdsModerated.WithLabelValues(StatusPending).Inc()
+	trace.SpanFromContext(ctx).AddEvent("ad_flagged", trace.WithAttributes(attribute.Int64("ad_id", id), attribute.Int("open_reports", reports)))
+	return nil
+}
+
+// GetReports lists abuse reports for admins, with tracing
+func (s *AdService) GetReports(status string, page, limit int, ctx context.Context) ([]Report, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "GetReportsService")
+	defer span.End()
+
+	reports, err := s.Repo.GetReports(status, page, limit, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve reports")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("reports_count", len(reports)), attribute.String("status", "success"))
+	return reports, nil
+}
+
+// ResolveReport dismisses a report or takes its ad down on behalf of actor, with tracing
+func (s *AdService) ResolveReport(id int64, resolution, actor string, ctx context.Context) (*Report, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "ResolveReportService")
+	defer span.End()
+
+	report, err := s.Repo.ResolveReport(id, resolution, actor, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to resolve report")
+		return nil, err
+	}
+
+	// A take-down deactivates the ad, which leaves the listings and the serving rotation
+	if resolution == ReportTakenDown {
+		s.cache().Delete(adCacheKey(report.AdID), ctx)
+		s.invalidateLists(ctx)
+		s.invalidateServeSnapshot(report.AdID, ctx)
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", report.AdID), attribute.String("resolution", resolution), attribute.String("status", "success"))
+	return report, nil
+}
+
+// AddVariant adds a creative variant to an ad, with tracing. The serve snapshot is dropped so
+// the variant enters the rotation on the next request.
+func (s *AdService) AddVariant(variant *Variant, ctx context.Context) error {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "AddVariantService")
+	defer span.End()
+	span.SetAttributes(attribute.Int64("ad_id", variant.AdID), attribute.String("variant", variant.Key))
+
+	if err := s.Repo.AddVariant(variant, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to add variant")
+		return err
+	}
+	s.invalidateServeSnapshot(variant.AdID, ctx)
+
+	span.SetAttributes(attribute.String("status", "success"))
+	return nil
+}
+
+// GetVariants returns the variants of an ad, with tracing
+func (s *AdService) GetVariants(adID int64, ctx context.Context) ([]Variant, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "GetVariantsService")
+	defer span.End()
+
+	exists, err := s.Repo.ExistsAd(adID, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to check ad existence")
+		return nil, err
+	}
+	if !exists {
+		span.SetStatus(codes.Error, "Ad not found")
+		return nil, ErrAdNotFound
+	}
+
+	variants, err := s.Repo.GetVariants(adID, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve variants")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("variants_count", len(variants)), attribute.String("status", "success"))
+	return variants, nil
+}
+
+// UpdateVariant replaces the creative and weight of a variant, with tracing
+func (s *AdService) UpdateVariant(variant *Variant, ctx context.Context) error {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "UpdateVariantService")
+	defer span.End()
+	span.SetAttributes(attribute.Int64("ad_id", variant.AdID), attribute.String("variant", variant.Key))
+
+	if err := s.Repo.UpdateVariant(variant, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to update variant")
+		return err
+	}
+	s.invalidateServeSnapshot(variant.AdID, ctx)
+
+	span.SetAttributes(attribute.String("status", "updated"))
+	return nil
+}
+
+// DeleteVariant removes a variant from an ad, with tracing. Its counters go with it; the ad's
+// own totals keep the impressions and clicks it collected.
+func (s *AdService) DeleteVariant(adID int64, key string, ctx context.Context) error {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "DeleteVariantService")
+	defer span.End()
+	span.SetAttributes(attribute.Int64("ad_id", adID), attribute.String("variant", key))
+
+	if err := s.Repo.DeleteVariant(adID, key, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to delete variant")
+		return err
+	}
+	s.invalidateServeSnapshot(adID, ctx)
+
+	span.SetAttributes(attribute.String("status", "deleted"))
+	return nil
+}
+
+// RecordClick counts a click on an ad, attributed to the variant it was served with, with tracing
+func (s *AdService) RecordClick(id int64, variantKey *string, ctx context.Context) error {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "RecordClickService")
+	defer span.End()
+	span.SetAttributes(attribute.Int64("ad_id", id))
+	if variantKey != nil {
+		span.SetAttributes(attribute.String("variant", *variantKey))
+	}
+
+	if err := s.Repo.RecordClick(id, variantKey, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to record click")
+		return err
+	}
+
+	span.SetAttributes(attribute.String("status", "success"))
+	return nil
+}
+
+// VariantStats are the serving counters of one variant
+type VariantStats struct {
+	Variant     string  `json:"variant"`
+	Impressions int64   `json:"impressions"`
+	Clicks      int64   `json:"clicks"`
+	CTR         float64 `json:"ctr"`
+}
+
+// AdStats are the serving counters of an ad, in total and per variant
+type AdStats struct {
+	AdID        int64          `json:"ad_id"`
+	Impressions int64          `json:"impressions"`
+	Clicks      int64          `json:"clicks"`
+	CTR         float64        `json:"ctr"`
+	Variants    []VariantStats `json:"variants"`
+}
+
+// clickThroughRate is clicks per impression, zero before the first impression
+func clickThroughRate(clicks, impressions int64) float64 {
+	if impressions == 0 {
+		return 0
+	}
+	return float64(clicks) / float64(impressions)
+}
+
+// GetAdStats returns the impressions, clicks and click-through rate of an ad and each of its
+// variants, with tracing. The totals include serves from before the ad had variants.
+func (s *AdService) GetAdStats(id int64, ctx context.Context) (*AdStats, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "GetAdStatsService")
+	defer span.End()
+
+	counters, err := s.Repo.GetCounters(id, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve counters")
+		return nil, err
+	}
+
+	stats := &AdStats{
+		AdID:        id,
+		Impressions: counters.Impressions,
+		Clicks:      counters.Clicks,
+		CTR:         clickThroughRate(counters.Clicks, counters.Impressions),
+		Variants:    make([]VariantStats, len(counters.Variants)),
+	}
+	for i, variant := range counters.Variants {
+		stats.Variants[i] = VariantStats{
+			Variant:     variant.Key,
+			Impressions: variant.Impressions,
+			Clicks:      variant.Clicks,
+			CTR:         clickThroughRate(variant.Clicks, variant.Impressions),
+		}
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.Int("variants_count", len(stats.Variants)), attribute.String("status", "success"))
+	return stats, nil
+}
+
+// UpsertAd creates or refreshes an ad by its external reference, with tracing. Like AddAd, an ad
+// the upsert would create must fit the owner's quota unless the caller is an admin; refreshing
+// an existing one is never limited.
+func (s *AdService) UpsertAd(ad *Ad, admin bool, ctx context.Context) (bool, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "UpsertAdService")
+	defer span.End()
+
+	ownerID, limited := quotaOwner(ad, admin)
+	if limited && ad.ExternalRef != nil {
+		exists, err := s.Repo.ExternalRefExists(*ad.ExternalRef, ctx)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to look up external reference")
+			return false, err
+		}
+		limited = !exists
+	}
+	if limited {
+		if err := s.checkQuota(ownerID, ctx); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Quota check failed")
+			metrics.AdCreateFailures.WithLabelValues("quota").Inc()
+			return false, err
+		}
+	}
+
+	created, err := s.Repo.UpsertAd(ad, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to upsert ad")
+		return false, err
+	}
+	if created {
+		metrics.AdsCreated.Inc()
+		if limited {
+			s.recordCreation(ownerID, ctx)
+		}
+	} else {
+		metrics.AdsUpdated.Inc()
+	}
+
+	// The repository read back the stored row, so it can be written through as-is
+	s.refreshAdCache(ad, ctx)
+	s.invalidateLists(ctx)
+	s.invalidateServeSnapshot(ad.ID, ctx)
+
+	span.SetAttributes(attribute.Int64("ad_id", ad.ID), attribute.Bool("created", created), attribute.String("status", "success"))
+	return created, nil
+}
+
+// DeleteAd deletes an ad by ID, with tracing
+func (s *AdService) DeleteAd(id int64, ctx context.Context) error {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "DeleteAdService")
+	defer span.End()
+
+	lock, err := s.lockAd(id, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Could not lock ad")
+		return err
+	}
+	defer lock.Release(ctx)
+
+	err = s.Repo.DeleteAd(id, ctx)
 	if err != nil {
 		span.RecordError(err)
 		if errors.Is(err, ErrAdNotFound) {
@@ -151,10 +1614,13 @@ func (s *AdService) DeleteAd(id int, ctx context.Context) error {
 		return err
 	}
 
-	// Invalidate cache for this ad
-	cacheKey := "ad_" + strconv.Itoa(id)
-	adCache.Delete(cacheKey, ctx)
+	// Invalidate cache for this ad and the list pages
+	cacheKey := adCacheKey(id)
+	s.cache().Delete(cacheKey, ctx)
+	s.invalidateLists(ctx)
+	s.invalidateServeSnapshot(id, ctx)
+	metrics.AdsDeleted.Inc()
 
-	span.SetAttributes(attribute.Int("ad_id", id), attribute.String("status", "deleted"))
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("status", "deleted"))
 	return nil
 }
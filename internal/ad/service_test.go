@@ -0,0 +1,1045 @@
+package ad
+
+import (
+	"ad_service/internal/config"
+	"ad_service/pkg/cache"
+	"ad_service/pkg/metrics"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Ads have no soft delete: archiving hides an ad and keeps its row, deleting removes the row
+// whether the ad is archived or not
+func TestArchivedAdCanBeDeleted(t *testing.T) {
+	service, mock := newTestService(t)
+	ctx := testCtx()
+	ad := testAd(7, "Bike")
+	ad.OwnerID = strPtr("owner")
+
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows(ad))
+	expectNoTranslations(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE ads SET archived_at = ").WithArgs(sqlmock.AnyArg(), int64(7), "default").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO ad_audit_log").WithArgs(int64(7), "archived", "owner", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	archived, err := service.SetArchived(7, true, "owner", ctx)
+	if err != nil {
+		t.Fatalf("SetArchived: %v", err)
+	}
+	if archived.ArchivedAt == nil {
+		t.Fatal("archived ad has no archived_at")
+	}
+
+	// The archived ad stays readable by its owner from the cache, but can't be edited
+	cached, err := service.GetAdByID(7, ctx)
+	if err != nil || cached.ArchivedAt == nil {
+		t.Fatalf("GetAdByID after archiving = %+v, %v; want the archived ad", cached, err)
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE ads SET .* AND archived_at IS NULL").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT archived_at IS NOT NULL FROM ads").WillReturnRows(sqlmock.NewRows([]string{"archived"}).AddRow(true))
+	mock.ExpectRollback()
+	if err := service.UpdateAd(7, &ad, ctx); !errors.Is(err, ErrArchived) {
+		t.Fatalf("UpdateAd of the archived ad = %v, want ErrArchived", err)
+	}
+
+	// Deleting doesn't look at archived_at and removes the row
+	mock.ExpectBegin()
+	mock.ExpectExec(`^DELETE FROM ads WHERE id = \? AND tenant_id = \?$`).WithArgs(int64(7), "default").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := service.DeleteAd(7, ctx); err != nil {
+		t.Fatalf("DeleteAd of the archived ad: %v", err)
+	}
+
+	// The cached copy went with it, so the next read misses and finds nothing
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows())
+	if _, err := service.GetAdByID(7, ctx); !errors.Is(err, ErrAdNotFound) {
+		t.Errorf("GetAdByID after deleting = %v, want ErrAdNotFound", err)
+	}
+
+	// Deleting again is a 404, there is no deleted row to find
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM ads").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if err := service.DeleteAd(7, ctx); !errors.Is(err, ErrAdNotFound) {
+		t.Errorf("second DeleteAd = %v, want ErrAdNotFound", err)
+	}
+}
+
+// sweepCache counts the DeleteByPrefix calls to the cache it wraps, holding each of them until
+// release is closed
+type sweepCache struct {
+	cache.Cache
+	calls    atomic.Int32
+	bounded  atomic.Bool
+	release  chan struct{}
+	prefixes chan string
+}
+
+func (c *sweepCache) DeleteByPrefix(prefix string, ctx context.Context) (int, error) {
+	c.calls.Add(1)
+	_, ok := ctx.Deadline()
+	c.bounded.Store(ok)
+	<-c.release
+	n, err := c.Cache.DeleteByPrefix(prefix, ctx)
+	c.prefixes <- prefix
+	return n, err
+}
+
+func TestInvalidateListsSweepsOldPages(t *testing.T) {
+	service, _ := newTestService(t)
+	sweeping := &sweepCache{Cache: service.Cache, release: make(chan struct{}), prefixes: make(chan string, 2)}
+	service.Cache = sweeping
+	ctx := testCtx()
+	q := ListQuery{Page: 1, Limit: 10, SortBy: "id", Order: "asc"}
+
+	before := service.currentListCacheKey(q, ctx)
+	service.Cache.Set(before, "[]", testCacheConfig.ListTTL, ctx)
+	service.Cache.Set(adCacheKey(7), "{}", testCacheConfig.AdTTL, ctx)
+	service.invalidateLists(ctx)
+	after := service.currentListCacheKey(q, ctx)
+	if after == before {
+		t.Fatalf("the list key didn't change after invalidation: %s", after)
+	}
+
+	// A write during the delete doesn't start a second one
+	waitFor(t, func() bool { return sweeping.calls.Load() == 1 })
+	service.invalidateLists(ctx)
+	close(sweeping.release)
+	if prefix := <-sweeping.prefixes; prefix != listKeyPrefix {
+		t.Errorf("DeleteByPrefix(%q), want the list prefix", prefix)
+	}
+	waitFor(t, func() bool { return !service.sweepingLists.Load() })
+	if n := sweeping.calls.Load(); n != 1 {
+		t.Errorf("%d deletes for two writes during one, want 1", n)
+	}
+	if !sweeping.bounded.Load() {
+		t.Error("the background delete has no deadline")
+	}
+	if page, _ := service.Cache.Get(before, ctx); page != "" {
+		t.Errorf("the old page survived the delete: %q", page)
+	}
+	if cached, _ := service.Cache.Get(adCacheKey(7), ctx); cached != "{}" {
+		t.Errorf("the cached ad = %q, want it untouched", cached)
+	}
+
+	// Once it is done the next write deletes again
+	service.invalidateLists(ctx)
+	<-sweeping.prefixes
+	if n := sweeping.calls.Load(); n != 2 {
+		t.Errorf("%d deletes after a write following the first, want 2", n)
+	}
+}
+
+func TestFillDailyCounts(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	tests := []struct {
+		name     string
+		from, to string
+		counts   map[string]int
+		want     []DailyCount
+	}{
+		{"single day", "2024-05-10", "2024-05-10", map[string]int{"2024-05-10": 3}, []DailyCount{{"2024-05-10", 3}}},
+		{"month boundary", "2024-01-30", "2024-02-02", map[string]int{"2024-01-31": 2, "2024-02-01": 1},
+			[]DailyCount{{"2024-01-30", 0}, {"2024-01-31", 2}, {"2024-02-01", 1}, {"2024-02-02", 0}}},
+		{"leap day", "2024-02-28", "2024-03-01", map[string]int{"2024-02-29": 4},
+			[]DailyCount{{"2024-02-28", 0}, {"2024-02-29", 4}, {"2024-03-01", 0}}},
+		{"year boundary", "2023-12-31", "2024-01-01", nil, []DailyCount{{"2023-12-31", 0}, {"2024-01-01", 0}}},
+		// Counts outside the range are not reported
+		{"counts outside", "2024-04-30", "2024-05-01", map[string]int{"2024-04-29": 9, "2024-05-02": 9},
+			[]DailyCount{{"2024-04-30", 0}, {"2024-05-01", 0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fillDailyCounts(day(tt.from), day(tt.to), tt.counts)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("fillDailyCounts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A write shows up in the next listing, however long the list TTL
+func TestListSeesWritesImmediately(t *testing.T) {
+	service, mock := newTestService(t)
+	ctx := testCtx()
+	q := ListQuery{Page: 1, Limit: 10, SortBy: "id", Order: "asc"}
+	first := testAd(1, "First")
+	list := func() []Ad {
+		t.Helper()
+		ads, err := service.GetAllAds(q, ctx)
+		if err != nil {
+			t.Fatalf("GetAllAds: %v", err)
+		}
+		return ads
+	}
+
+	mock.ExpectQuery("FROM ads").WillReturnRows(plainAdRows(first))
+	expectNoTranslations(mock)
+	list()
+	// The page is cached now, so this one sends no query
+	if ads := list(); len(ads) != 1 {
+		t.Fatalf("cached page = %v, want the first ad", ads)
+	}
+
+	second := testAd(2, "Second")
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO ads").WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec("UPDATE ads SET slug").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT created_at FROM ads").WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(second.CreatedAt))
+	mock.ExpectCommit()
+	if err := service.AddAd(&second, true, ctx); err != nil {
+		t.Fatalf("AddAd: %v", err)
+	}
+	mock.ExpectQuery("FROM ads").WillReturnRows(plainAdRows(first, second))
+	expectNoTranslations(mock)
+	if ads := list(); len(ads) != 2 || ads[1].ID != 2 {
+		t.Fatalf("page after AddAd = %v, want both ads", ads)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM ads").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := service.DeleteAd(1, ctx); err != nil {
+		t.Fatalf("DeleteAd: %v", err)
+	}
+	mock.ExpectQuery("FROM ads").WillReturnRows(plainAdRows(second))
+	expectNoTranslations(mock)
+	if ads := list(); len(ads) != 1 || ads[0].ID != 2 {
+		t.Errorf("page after DeleteAd = %v, want the second ad only", ads)
+	}
+}
+
+func TestZeroTTLDisablesCaching(t *testing.T) {
+	service, mock := newTestService(t)
+	ttl := testCacheConfig
+	ttl.AdTTL = 0
+	service.TTL = config.NewReloadable(ttl)
+	ctx := testCtx()
+	bypassed := testutil.ToFloat64(metrics.CacheOperations.WithLabelValues("ad", "bypass"))
+
+	// Nothing is cached, so every read goes to MySQL
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows(testAd(7, "Bike")))
+		expectNoTranslations(mock)
+		if _, err := service.GetAdByID(7, ctx); err != nil {
+			t.Fatalf("GetAdByID: %v", err)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.CacheOperations.WithLabelValues("ad", "bypass")) - bypassed; got != 2 {
+		t.Errorf("bypassed ad lookups = %v, want 2", got)
+	}
+}
+
+func TestParallelListMissesQueryOnce(t *testing.T) {
+	service, mock := newTestService(t)
+	ctx := testCtx()
+	q := ListQuery{Page: 1, Limit: 10, SortBy: "id", Order: "asc"}
+	// The one rebuild is slow enough for every caller to miss the cache first
+	mock.ExpectQuery("FROM ads").WillDelayFor(50 * time.Millisecond).WillReturnRows(plainAdRows(testAd(1, "First")))
+	expectNoTranslations(mock)
+
+	const callers = 10
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ads, err := service.GetAllAds(q, ctx)
+			if err != nil || len(ads) != 1 {
+				t.Errorf("GetAllAds = %v, %v; want the first ad", ads, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// gatedCache holds every Get until the expected number of callers are waiting on it, so their
+// cache misses are concurrent
+type gatedCache struct {
+	cache.Cache
+	arrived sync.WaitGroup
+}
+
+func (c *gatedCache) Get(key string, ctx context.Context) (string, error) {
+	c.arrived.Done()
+	c.arrived.Wait()
+	return c.Cache.Get(key, ctx)
+}
+
+// getAdInParallel calls GetAdByID for the ad from n goroutines whose cache reads all miss at once
+func getAdInParallel(service *AdService, id int64, n int) ([]*Ad, []error) {
+	gated := &gatedCache{Cache: service.Cache}
+	gated.arrived.Add(n)
+	service.Cache = gated
+	defer func() { service.Cache = gated.Cache }()
+
+	ads, errs := make([]*Ad, n), make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ads[i], errs[i] = service.GetAdByID(id, testCtx())
+		}()
+	}
+	wg.Wait()
+	return ads, errs
+}
+
+func TestParallelAdMissesQueryOnce(t *testing.T) {
+	service, mock := newTestService(t)
+	coalesced := testutil.ToFloat64(metrics.CoalescedLoads.WithLabelValues("ad"))
+	// The one query is slow enough for every caller to join it; a second would fail the mock
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillDelayFor(50 * time.Millisecond).WillReturnRows(adRows(testAd(7, "Bike")))
+	expectNoTranslations(mock)
+
+	const callers = 20
+	ads, errs := getAdInParallel(service, 7, callers)
+	for i := range ads {
+		if errs[i] != nil || ads[i] == nil || ads[i].Title != "Bike" {
+			t.Fatalf("caller %d: GetAdByID = %v, %v; want the ad", i, ads[i], errs[i])
+		}
+	}
+	if got := testutil.ToFloat64(metrics.CoalescedLoads.WithLabelValues("ad")) - coalesced; got != callers-1 {
+		t.Errorf("cache_coalesced_loads_total grew by %v, want %d", got, callers-1)
+	}
+	// Each caller has its own copy to localize in place
+	ads[0].Title = "Changed"
+	if ads[1].Title != "Bike" {
+		t.Error("callers share one ad")
+	}
+	// The cache was filled once for all of them
+	if cached, err := service.GetAdByID(7, testCtx()); err != nil || cached.Title != "Bike" {
+		t.Errorf("GetAdByID after the load = %v, %v; want the cached ad", cached, err)
+	}
+}
+
+func TestParallelAdMissErrors(t *testing.T) {
+	service, mock := newTestService(t)
+	const callers = 10
+
+	// A missing ad is shared and remembered
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(8), "default").WillDelayFor(50 * time.Millisecond).WillReturnRows(adRows())
+	_, errs := getAdInParallel(service, 8, callers)
+	for i, err := range errs {
+		if !errors.Is(err, ErrAdNotFound) {
+			t.Errorf("caller %d: GetAdByID of a missing ad = %v, want ErrAdNotFound", i, err)
+		}
+	}
+	if _, err := service.GetAdByID(8, testCtx()); !errors.Is(err, ErrAdNotFound) {
+		t.Errorf("GetAdByID after the shared miss = %v, want the cached ErrAdNotFound", err)
+	}
+
+	// A transient error is shared by the callers waiting on it, then forgotten
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillDelayFor(50 * time.Millisecond).WillReturnError(errors.New("connection reset"))
+	_, errs = getAdInParallel(service, 7, callers)
+	for i, err := range errs {
+		if err == nil || errors.Is(err, ErrAdNotFound) {
+			t.Errorf("caller %d: GetAdByID during an outage = %v, want the error", i, err)
+		}
+	}
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows(testAd(7, "Bike")))
+	expectNoTranslations(mock)
+	if ad, err := service.GetAdByID(7, testCtx()); err != nil || ad.Title != "Bike" {
+		t.Errorf("GetAdByID after the outage = %v, %v; want the ad queried again", ad, err)
+	}
+}
+
+func TestCanceledCallerLeavesSharedLoad(t *testing.T) {
+	service, mock := newTestService(t)
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillDelayFor(100 * time.Millisecond).WillReturnRows(adRows(testAd(7, "Bike")))
+	expectNoTranslations(mock)
+
+	// The caller that starts the load goes away, the one that joined it still gets the ad
+	ctx, cancel := context.WithCancel(testCtx())
+	started := make(chan error, 1)
+	go func() {
+		_, err := service.GetAdByID(7, ctx)
+		started <- err
+	}()
+	time.AfterFunc(20*time.Millisecond, cancel)
+	time.Sleep(10 * time.Millisecond)
+	ad, err := service.GetAdByID(7, testCtx())
+	if err != nil || ad.Title != "Bike" {
+		t.Errorf("joined GetAdByID = %v, %v; want the ad", ad, err)
+	}
+	if err := <-started; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled GetAdByID = %v, want context.Canceled", err)
+	}
+}
+
+// failingCache is a cache whose reads fail, like Redis going away
+type failingCache struct{ cache.Cache }
+
+func (failingCache) Get(key string, ctx context.Context) (string, error) {
+	return "", errors.New("connection refused")
+}
+
+func TestGetAdByIDCountsCacheLookups(t *testing.T) {
+	service, mock := newTestService(t)
+	ctx := testCtx()
+	outcomes := []string{"hit", "miss", "negative_hit", "error", "bypass"}
+	before := map[string]float64{}
+	for _, outcome := range outcomes {
+		before[outcome] = testutil.ToFloat64(metrics.CacheOperations.WithLabelValues("ad", outcome))
+	}
+
+	// A miss, then a hit of the cached ad
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows(testAd(7, "Bike")))
+	expectNoTranslations(mock)
+	service.GetAdByID(7, ctx)
+	service.GetAdByID(7, ctx)
+	// A corrupt entry is a miss and is replaced from MySQL
+	if err := service.Cache.Set(adCacheKey(7), "{not json", time.Minute, ctx); err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows(testAd(7, "Bike")))
+	expectNoTranslations(mock)
+	if ad, err := service.GetAdByID(7, ctx); err != nil || ad.Title != "Bike" {
+		t.Fatalf("GetAdByID over a corrupt entry = %v, %v; want the ad from MySQL", ad, err)
+	}
+	service.GetAdByID(7, ctx)
+	// A missing ad is cached as such
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(8), "default").WillReturnRows(adRows())
+	service.GetAdByID(8, ctx)
+	service.GetAdByID(8, ctx)
+	// A failing cache read falls back to MySQL
+	service.Cache = cache.NewTenantCache(failingCache{service.Cache})
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows(testAd(7, "Bike")))
+	expectNoTranslations(mock)
+	service.GetAdByID(7, ctx)
+	// No cache at all
+	service.Cache = nil
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows(testAd(7, "Bike")))
+	expectNoTranslations(mock)
+	service.GetAdByID(7, ctx)
+
+	want := map[string]float64{"hit": 2, "miss": 3, "negative_hit": 1, "error": 1, "bypass": 1}
+	for _, outcome := range outcomes {
+		if got := testutil.ToFloat64(metrics.CacheOperations.WithLabelValues("ad", outcome)) - before[outcome]; got != want[outcome] {
+			t.Errorf("%s lookups = %v, want %v", outcome, got, want[outcome])
+		}
+	}
+}
+
+func TestGetAdsByIDsInterleavesHitsAndMisses(t *testing.T) {
+	service, mock := newTestService(t)
+	ctx := testCtx()
+	// 1 and 3 are cached, 4 is corrupt and 5 was cached as missing; 2 and 6 are only in MySQL
+	cacheAd(t, service, testAd(1, "One"))
+	cacheAd(t, service, testAd(3, "Three"))
+	service.Cache.Set(adCacheKey(4), "{not json", time.Minute, ctx)
+	service.Cache.Set(adCacheKey(5), notFoundCacheValue, time.Minute, ctx)
+	mock.ExpectQuery("FROM ads WHERE .* AND id IN \\(\\?, \\?, \\?, \\?, \\?\\)").
+		WithArgs("default", int64(6), int64(2), int64(4), int64(5), int64(7)).
+		WillReturnRows(plainAdRows(testAd(2, "Two"), testAd(4, "Four"), testAd(6, "Six")))
+	expectNoTranslations(mock)
+
+	ads, missing, err := service.GetAdsByIDs([]int64{6, 1, 2, 3, 4, 5, 7}, ctx)
+	if err != nil {
+		t.Fatalf("GetAdsByIDs: %v", err)
+	}
+	var got []string
+	for _, ad := range ads {
+		got = append(got, ad.Title)
+	}
+	if fmt.Sprint(got) != "[Six One Two Three Four]" {
+		t.Errorf("ads = %v, want the found ads in the requested order", got)
+	}
+	if fmt.Sprint(missing) != "[5 7]" {
+		t.Errorf("missing = %v, want [5 7]", missing)
+	}
+
+	// The misses were cached, so the same request now costs no query
+	ads, missing, err = service.GetAdsByIDs([]int64{1, 2, 4, 6}, ctx)
+	if err != nil || len(ads) != 4 || len(missing) != 0 {
+		t.Errorf("cached GetAdsByIDs = %d ads, missing %v, %v; want all 4 from the cache", len(ads), missing, err)
+	}
+}
+
+func TestUpdateAdCacheWriteModes(t *testing.T) {
+	// The row as MySQL holds it after the update, with a column the request left out
+	stored := testAd(7, "Road bike")
+	stored.IsActive = false
+	stored.Category = "sports"
+
+	tests := []struct {
+		name      string
+		mode      string
+		readBack  error
+		fromCache bool
+	}{
+		{"write through", WriteModeWriteThrough, nil, true},
+		{"invalidate", WriteModeInvalidate, nil, false},
+		// The entry is dropped rather than left with the old values
+		{"write through without read back", WriteModeWriteThrough, errors.New("connection reset"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mock := newTestService(t)
+			ttl := testCacheConfig
+			ttl.WriteMode = tt.mode
+			service.TTL = config.NewReloadable(ttl)
+			ctx := testCtx()
+			cacheAd(t, service, testAd(7, "Bike"))
+
+			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE ads SET title = ").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+			if tt.mode == WriteModeWriteThrough {
+				read := mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default")
+				if tt.readBack != nil {
+					read.WillReturnError(tt.readBack)
+				} else {
+					read.WillReturnRows(adRows(stored))
+					expectNoTranslations(mock)
+				}
+			}
+			update := testAd(7, "Road bike")
+			if err := service.UpdateAd(7, &update, ctx); err != nil {
+				t.Fatalf("UpdateAd: %v", err)
+			}
+
+			if !tt.fromCache {
+				mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows(stored))
+				expectNoTranslations(mock)
+			}
+			got, err := service.GetAdByID(7, ctx)
+			if err != nil {
+				t.Fatalf("GetAdByID: %v", err)
+			}
+			// Whether cached or read, the ad is what a read of the row returns
+			mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows(stored))
+			expectNoTranslations(mock)
+			fromDB, err := service.Repo.GetAdByID(7, ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotJSON, _ := json.Marshal(got)
+			dbJSON, _ := json.Marshal(fromDB)
+			if string(gotJSON) != string(dbJSON) {
+				t.Errorf("GetAdByID after UpdateAd = %s, want the row %s", gotJSON, dbJSON)
+			}
+		})
+	}
+}
+
+func TestAddAdIsServedFromCache(t *testing.T) {
+	service, mock := newTestService(t)
+	ctx := testCtx()
+	// A "not found" entry left by an earlier lookup of the ID is replaced
+	service.Cache.Set(adCacheKey(9), notFoundCacheValue, time.Minute, ctx)
+
+	created := testAd(0, "Bike")
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO ads").WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectExec("UPDATE ads SET slug").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT created_at FROM ads").WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(created.CreatedAt))
+	mock.ExpectCommit()
+	if err := service.AddAd(&created, true, ctx); err != nil {
+		t.Fatalf("AddAd: %v", err)
+	}
+
+	// Served without a query, and the same as a read of the new row
+	got, err := service.GetAdByID(9, ctx)
+	if err != nil {
+		t.Fatalf("GetAdByID of the new ad: %v", err)
+	}
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(9), "default").WillReturnRows(adRows(created))
+	expectNoTranslations(mock)
+	fromDB, err := service.Repo.GetAdByID(9, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotJSON, _ := json.Marshal(got)
+	dbJSON, _ := json.Marshal(fromDB)
+	if got.ID != 9 || string(gotJSON) != string(dbJSON) {
+		t.Errorf("cached ad = %s, want the row %s", gotJSON, dbJSON)
+	}
+}
+
+func TestConcurrentUpdatesOfOneAdAreSerialized(t *testing.T) {
+	service, mock := newTestService(t)
+	ctx := testCtx()
+	// The expectations are matched in order, so a second update starting before the first
+	// refreshed the cache fails the mock
+	for _, title := range []string{"First", "Second"} {
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE ads SET title = ").WillDelayFor(50 * time.Millisecond).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows(testAd(7, title)))
+		expectNoTranslations(mock)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for _, title := range []string{"First", "Second"} {
+		wg.Add(1)
+		go func(title string) {
+			defer wg.Done()
+			update := testAd(7, title)
+			errs <- service.UpdateAd(7, &update, ctx)
+		}(title)
+		// Let the first update take the lock
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("UpdateAd: %v", err)
+		}
+	}
+	// The cache holds the state of the last write
+	if ad, err := service.GetAdByID(7, ctx); err != nil || ad.Title != "Second" {
+		t.Errorf("cached ad = %v, %v; want the second update", ad, err)
+	}
+}
+
+func TestUpdateOfLockedAdIsBusy(t *testing.T) {
+	service, mock := newTestService(t)
+	ttl := testCacheConfig
+	ttl.MutationLockWait = 30 * time.Millisecond
+	service.TTL = config.NewReloadable(ttl)
+	ctx := testCtx()
+	// Another replica holds the lock for the whole wait
+	if _, err := cache.AcquireLock(service.Cache, adCacheKey(7), time.Minute, 0, ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	update := testAd(7, "Bike")
+	if err := service.UpdateAd(7, &update, ctx); !errors.Is(err, ErrAdBusy) {
+		t.Errorf("UpdateAd of a locked ad = %v, want ErrAdBusy", err)
+	}
+	if err := service.DeleteAd(7, ctx); !errors.Is(err, ErrAdBusy) {
+		t.Errorf("DeleteAd of a locked ad = %v, want ErrAdBusy", err)
+	}
+	// Nothing reached MySQL
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// Another ad isn't affected
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM ads").WithArgs(int64(8), "default").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := service.DeleteAd(8, ctx); err != nil {
+		t.Errorf("DeleteAd of another ad: %v", err)
+	}
+}
+
+// writeCounts gathers the ad write counters from reg, keyed by name and reason
+func writeCounts(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			name := family.GetName()
+			for _, label := range m.GetLabel() {
+				name += ":" + label.GetValue()
+			}
+			counts[name] = m.GetCounter().GetValue()
+		}
+	}
+	return counts
+}
+
+func TestAdWriteCounters(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(metrics.AdsCreated, metrics.AdsUpdated, metrics.AdsDeleted, metrics.AdCreateFailures)
+	// Touch the failure reasons so they are gathered before their first increment
+	metrics.AdCreateFailures.WithLabelValues("validation")
+	metrics.AdCreateFailures.WithLabelValues("db_error")
+	before := writeCounts(t, reg)
+	service, mock := newTestService(t)
+	ctx := testCtx()
+
+	created := testAd(0, "Bike")
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO ads").WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectExec("UPDATE ads SET slug").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT created_at FROM ads").WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(created.CreatedAt))
+	mock.ExpectCommit()
+	if err := service.AddAd(&created, true, ctx); err != nil {
+		t.Fatalf("AddAd: %v", err)
+	}
+	failing := testAd(0, "Car")
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO ads").WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+	if err := service.AddAd(&failing, true, ctx); err == nil {
+		t.Fatal("AddAd with MySQL failing succeeded")
+	}
+
+	// Upserts count as a creation or an update
+	for _, rowsAffected := range []int64{1, 2} {
+		ad := testAd(0, "Feed ad")
+		ad.ExternalRef = strPtr("feed-7")
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO ads .* ON DUPLICATE KEY UPDATE").WillReturnResult(sqlmock.NewResult(7, rowsAffected))
+		if rowsAffected == 1 {
+			mock.ExpectExec("UPDATE ads SET slug").WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		mock.ExpectQuery("FROM ads WHERE id = ").WillReturnRows(adRows(testAd(7, "Feed ad")))
+		expectNoTranslations(mock)
+		mock.ExpectCommit()
+		if _, err := service.UpsertAd(&ad, true, ctx); err != nil {
+			t.Fatalf("UpsertAd: %v", err)
+		}
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE ads SET title = ").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("FROM ads WHERE id = ").WillReturnRows(adRows(testAd(7, "Road bike")))
+	expectNoTranslations(mock)
+	update := testAd(7, "Road bike")
+	if err := service.UpdateAd(7, &update, ctx); err != nil {
+		t.Fatalf("UpdateAd: %v", err)
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM ads").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := service.DeleteAd(7, ctx); err != nil {
+		t.Fatalf("DeleteAd: %v", err)
+	}
+
+	// A rejected body never reaches the service
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) { r.POST("/ads", h.AddAd) })
+	if w := serve(r, http.MethodPost, "/ads", strings.NewReader(`{"title": ""}`)); w.Code != http.StatusBadRequest {
+		t.Fatalf("POST of an invalid ad = %d, want 400", w.Code)
+	}
+
+	after := writeCounts(t, reg)
+	want := map[string]float64{
+		"ads_created_total":                    2,
+		"ads_updated_total":                    2,
+		"ads_deleted_total":                    1,
+		"ads_create_failures_total:db_error":   1,
+		"ads_create_failures_total:validation": 1,
+	}
+	for name, delta := range want {
+		if got := after[name] - before[name]; got != delta {
+			t.Errorf("%s increased by %v, want %v", name, got, delta)
+		}
+	}
+}
+
+func TestRenewAdLimit(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	windowStart := now.Add(-2 * 24 * time.Hour)
+	expiresSoon := now.Add(24 * time.Hour)
+	expiresLater := now.Add(60 * 24 * time.Hour)
+	endedWindowStart := now.Add(-8 * 24 * time.Hour)
+
+	tests := []struct {
+		name        string
+		count       int
+		windowStart *time.Time
+		expiresAt   *time.Time
+		// wantCount is the renewal count stored, 0 when the limit is reached
+		wantCount    int
+		newWindow    bool
+		wantExtended bool
+	}{
+		{"first renewal", 0, nil, &expiresSoon, 1, true, true},
+		{"within the window", 2, &windowStart, &expiresLater, 3, false, false},
+		{"limit reached", 3, &windowStart, nil, 0, false, false},
+		// Eight days later the window has ended, so the count starts over
+		{"after the window", 3, &endedWindowStart, nil, 1, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mock := newTestService(t)
+			ctx := testCtx()
+			stored := testAd(7, "Bike")
+			stored.OwnerID = strPtr("owner")
+			stored.RenewedAt = now.Add(-30 * 24 * time.Hour)
+			stored.RenewalCount, stored.RenewalWindowStart, stored.ExpiresAt = tt.count, tt.windowStart, tt.expiresAt
+
+			mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows(stored))
+			expectNoTranslations(mock)
+			if tt.wantCount == 0 {
+				_, err := service.RenewAd(7, "owner", ctx)
+				var limitErr *RenewalLimitError
+				if !errors.As(err, &limitErr) || !errors.Is(err, ErrRenewalLimit) {
+					t.Fatalf("RenewAd = %v, want a RenewalLimitError", err)
+				}
+				if limitErr.Limit != 3 || !limitErr.ResetAt.Equal(windowStart.Add(7*24*time.Hour)) {
+					t.Errorf("limit error = %+v, want limit 3 reset a week after %s", limitErr, windowStart)
+				}
+				return
+			}
+
+			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE ads SET renewed_at = ").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("INSERT INTO ad_audit_log").WithArgs(int64(7), "renewed", "owner", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+			renewed, err := service.RenewAd(7, "owner", ctx)
+			if err != nil {
+				t.Fatalf("RenewAd: %v", err)
+			}
+			if renewed.RenewalCount != tt.wantCount || renewed.RenewedAt.Before(now) {
+				t.Errorf("renewal count %d, renewed at %s; want %d, now", renewed.RenewalCount, renewed.RenewedAt, tt.wantCount)
+			}
+			if gotNew := !renewed.RenewalWindowStart.Equal(windowStart); gotNew != tt.newWindow {
+				t.Errorf("window start = %s, want a new window: %v", renewed.RenewalWindowStart, tt.newWindow)
+			}
+			// expires_at is pushed out to the extension, never brought forward
+			switch {
+			case tt.expiresAt == nil && renewed.ExpiresAt != nil:
+				t.Errorf("expires_at = %s, want none", renewed.ExpiresAt)
+			case tt.wantExtended && !renewed.ExpiresAt.Equal(renewed.RenewedAt.Add(30*24*time.Hour)):
+				t.Errorf("expires_at = %s, want 30 days after the renewal", renewed.ExpiresAt)
+			case tt.expiresAt != nil && !tt.wantExtended && !renewed.ExpiresAt.Equal(*tt.expiresAt):
+				t.Errorf("expires_at = %s, want it kept at %s", renewed.ExpiresAt, tt.expiresAt)
+			}
+
+			// The cached ad is the renewed one
+			cached, err := service.GetAdByID(7, ctx)
+			if err != nil || !cached.RenewedAt.Equal(renewed.RenewedAt) {
+				t.Errorf("GetAdByID after RenewAd = %+v, %v; want the renewed ad", cached, err)
+			}
+		})
+	}
+}
+
+func TestReportAdFlagThreshold(t *testing.T) {
+	pending := testAd(7, "Bike")
+	pending.Status = StatusPending
+
+	tests := []struct {
+		name    string
+		ad      Ad // the row read before flagging
+		open    int
+		flagged bool
+	}{
+		{"at the threshold", testAd(7, "Bike"), 3, false},
+		{"over the threshold", testAd(7, "Bike"), 4, true},
+		// Only approved ads are flagged; a pending one is left alone
+		{"already pending", pending, 4, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mock := newTestService(t)
+			setRules(service, func(r *config.AdsConfig) { r.ReportFlagThreshold, r.ReportsPerHour = 3, 10 })
+			ctx := testCtx()
+
+			mock.ExpectQuery("SELECT created_at FROM ad_reports").WillReturnRows(sqlmock.NewRows([]string{"created_at"}))
+			mock.ExpectExec("INSERT IGNORE INTO ad_reports").WithArgs("reporter", "fraud", nil, int64(7), "default").WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectQuery("SELECT COUNT").WithArgs(int64(7), ReportOpen, "default").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tt.open))
+			if tt.open > 3 {
+				mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows(tt.ad))
+				expectNoTranslations(mock)
+			}
+			if tt.flagged {
+				mock.ExpectBegin()
+				mock.ExpectExec("UPDATE ads SET status = ").WithArgs(StatusPending, "flagged after 4 reports", int64(7), "default").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO ad_audit_log").WithArgs(int64(7), StatusPending, "system", "flagged after 4 reports").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			}
+			created, window, err := service.ReportAd(&Report{AdID: 7, ReporterID: "reporter", Reason: "fraud"}, ctx)
+			if err != nil || !created || len(window.Hits) != 1 {
+				t.Fatalf("ReportAd = %v, %d hits, %v; want a created report counted in the window", created, len(window.Hits), err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+
+			// A flagged ad is cached as pending, so it leaves the public reads right away
+			if tt.flagged {
+				cached, err := service.GetAdByID(7, ctx)
+				if err != nil || cached.Status != StatusPending || cached.StatusReason == nil || *cached.StatusReason != "flagged after 4 reports" {
+					t.Errorf("GetAdByID after flagging = %+v, %v; want the pending ad", cached, err)
+				}
+			}
+		})
+	}
+}
+
+func TestReportAdDuplicatesAndRateLimit(t *testing.T) {
+	service, mock := newTestService(t)
+	setRules(service, func(r *config.AdsConfig) { r.ReportFlagThreshold, r.ReportsPerHour = 3, 2 })
+	ctx := testCtx()
+	report := &Report{AdID: 7, ReporterID: "reporter", Reason: "spam"}
+
+	// A repeat report of the same ad is ignored and doesn't count towards the threshold
+	mock.ExpectQuery("SELECT created_at FROM ad_reports").WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now().Add(-time.Minute)))
+	mock.ExpectExec("INSERT IGNORE INTO ad_reports").WillReturnResult(sqlmock.NewResult(0, 0))
+	created, window, err := service.ReportAd(report, ctx)
+	if err != nil || created || len(window.Hits) != 1 {
+		t.Errorf("duplicate ReportAd = %v, %d hits, %v; want nothing created or counted", created, len(window.Hits), err)
+	}
+
+	// Past the hourly limit nothing is stored
+	hits := sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now().Add(-30 * time.Minute)).AddRow(time.Now().Add(-time.Minute))
+	mock.ExpectQuery("SELECT created_at FROM ad_reports").WillReturnRows(hits)
+	created, window, err = service.ReportAd(report, ctx)
+	if !errors.Is(err, ErrReportRateLimited) || created || window.Allowed() {
+		t.Errorf("ReportAd over the limit = %v, %v; want ErrReportRateLimited", created, err)
+	}
+}
+
+// keywordMatchRows returns the rows of the keyword match query: the overlap and the matched
+// keywords ahead of adColumns
+func keywordMatchRows(matches ...KeywordMatch) *sqlmock.Rows {
+	rows := sqlmock.NewRows(append([]string{"overlap", "matched"}, testAdColumns[1:]...))
+	for _, match := range matches {
+		rows.AddRow(append([]driver.Value{int64(len(match.Matched)), strings.Join(match.Matched, ",")}, adRow(match.Ad)[1:]...)...)
+	}
+	return rows
+}
+
+func TestServeAdKeywordMatching(t *testing.T) {
+	targeted := testAd(1, "Mountain bike")
+	targeted.Keywords = []string{"bike", "mountain"}
+	partial := testAd(2, "City bike")
+	partial.Keywords = []string{"bike", "city"}
+	untargeted := testAd(3, "Sofa")
+
+	tests := []struct {
+		name    string
+		matches []KeywordMatch
+		want    int64
+		outcome string
+		matched string // the matched_keywords span attribute, empty when none is set
+	}{
+		// Only the ads sharing the most keywords are eligible
+		{"overlap ranking", []KeywordMatch{{Ad: targeted, Matched: []string{"bike", "mountain"}}, {Ad: partial, Matched: []string{"bike"}}}, 1, "matched", "[bike mountain]"},
+		// Without a match an untargeted ad is served, never one targeted at other keywords
+		{"fallback", nil, 3, "fallback", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			previous := otel.GetTracerProvider()
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+			t.Cleanup(func() { otel.SetTracerProvider(previous) })
+			service, mock := newTestService(t)
+			before := testutil.ToFloat64(metrics.AdServeKeywordMatches.WithLabelValues(tt.outcome))
+
+			mock.ExpectQuery("JOIN ad_keywords k ON k.ad_id = ads.id WHERE k.keyword IN \\(\\?, \\?\\) .* GROUP BY ads.id ORDER BY overlap DESC").
+				WithArgs("bike", "mountain", "default").WillReturnRows(keywordMatchRows(tt.matches...))
+			if len(tt.matches) > 0 {
+				mock.ExpectQuery("FROM ad_variants").WillReturnRows(sqlmock.NewRows(strings.Split(variantColumns, ", ")))
+				expectNoTranslations(mock)
+			} else {
+				mock.ExpectQuery("weight > 0").WillReturnRows(adRows(targeted, untargeted))
+				mock.ExpectQuery("FROM ad_variants").WillReturnRows(sqlmock.NewRows(strings.Split(variantColumns, ", ")))
+				expectNoTranslations(mock)
+			}
+			mock.ExpectExec("UPDATE ads SET impressions = impressions \\+ 1").WithArgs(tt.want, "default").WillReturnResult(sqlmock.NewResult(0, 1))
+
+			ad, err := service.ServeAd([]string{"bike", "mountain"}, testCtx())
+			if err != nil {
+				t.Fatalf("ServeAd: %v", err)
+			}
+			if ad.ID != tt.want {
+				t.Errorf("served ad %d, want %d", ad.ID, tt.want)
+			}
+			if got := testutil.ToFloat64(metrics.AdServeKeywordMatches.WithLabelValues(tt.outcome)) - before; got != 1 {
+				t.Errorf("%s serves increased by %v, want 1", tt.outcome, got)
+			}
+
+			var matched string
+			for _, span := range recorder.Ended() {
+				if span.Name() != "ServeAdService" {
+					continue
+				}
+				for _, attr := range span.Attributes() {
+					if attr.Key == "matched_keywords" {
+						matched = fmt.Sprint(attr.Value.AsStringSlice())
+					}
+				}
+			}
+			if matched != tt.matched {
+				t.Errorf("matched_keywords = %q, want %q", matched, tt.matched)
+			}
+		})
+	}
+}
+
+func TestGetTrendingAdsSources(t *testing.T) {
+	inactive := testAd(2, "Inactive")
+	inactive.IsActive = false
+	tests := []struct {
+		name   string
+		views  []int64 // recorded views, by ad ID
+		down   bool
+		want   string
+		source string
+	}{
+		// The most viewed ad isn't live, so the next one is served in its place
+		{"views", []int64{2, 2, 2, 1, 1, 3}, false, "[1]", TrendingSourceViews},
+		{"no views", nil, false, "[5]", TrendingSourceRecent},
+		{"Redis down", []int64{1}, true, "[5]", TrendingSourceRecent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mock := newTestService(t)
+			server := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+			t.Cleanup(func() { client.Close() })
+			service.Trending = &cache.Trending{Client: client, Prefix: "trending:", MaxAge: MaxTrendingWindow}
+			ctx := testCtx()
+			for _, ad := range []Ad{testAd(1, "Viewed"), inactive, testAd(3, "Less viewed")} {
+				cacheAd(t, service, ad)
+			}
+			for _, id := range tt.views {
+				if err := service.Trending.RecordView(id, ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.down {
+				server.Close()
+			}
+			if tt.source == TrendingSourceRecent {
+				mock.ExpectQuery(`ORDER BY created_at DESC, id DESC LIMIT \?`).WithArgs("default", 1).WillReturnRows(plainAdRows(testAd(5, "Newest")))
+				expectNoTranslations(mock)
+			}
+
+			ads, source, err := service.GetTrendingAds(24*time.Hour, 1, ctx)
+			if err != nil {
+				t.Fatalf("GetTrendingAds: %v", err)
+			}
+			ids := make([]int64, len(ads))
+			for i, ad := range ads {
+				ids[i] = ad.ID
+			}
+			if got := fmt.Sprint(ids); got != tt.want || source != tt.source {
+				t.Errorf("GetTrendingAds = %s from %s, want %s from %s", got, source, tt.want, tt.source)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+/*
+This file reads the live ads listed in GET /sitemap.xml. The rows are handed over one at a time
+so that a sitemap of tens of thousands of URLs is never held in memory.
+*/
+package ad
+
+import (
+	"ad_service/pkg/tenant"
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// SitemapEntry is the public page of a live ad and when it last changed
+type SitemapEntry struct {
+	ID        int64
+	UpdatedAt time.Time
+}
+
+// CountLiveAds returns the number of the tenant's ads that are public and servable, with tracing
+func (r *Repository) CountLiveAds(ctx context.Context) (_ int, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "CountLiveAdsRepository")
+	defer span.End()
+	defer observeQuery("count_live_ads", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	if err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM ads WHERE "+tenantCondition+" AND "+activeCondition, tenantID).Scan(&count); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to count live ads")
+		return 0, fmt.Errorf("could not count live ads: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("ads_count", count), attribute.String("status", "success"))
+	return count, nil
+}
+
+// EachLiveAd calls fn with the tenant's live ads in ID order, skipping offset and stopping after limit,
+// with tracing. Rows are read from the cursor as fn consumes them; an error from fn stops the
+// iteration and is returned as is.
+func (r *Repository) EachLiveAd(offset, limit int, fn func(SitemapEntry) error, ctx context.Context) (err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "EachLiveAdRepository")
+	defer span.End()
+	defer observeQuery("each_live_ad", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := "SELECT id, GREATEST(updated_at, renewed_at) FROM ads WHERE " + tenantCondition + " AND " + activeCondition + " ORDER BY id LIMIT ? OFFSET ?"
+	rows, err := r.DB.QueryContext(ctx, query, tenantID, limit, offset)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve live ads")
+		return fmt.Errorf("could not retrieve live ads: %w", err)
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var entry SitemapEntry
+		if err := rows.Scan(&entry.ID, &entry.UpdatedAt); err != nil {
+			span.RecordError(err)
+			return fmt.Errorf("could not scan live ad: %w", err)
+		}
+		if err := fn(entry); err != nil {
+			span.RecordError(err)
+			return err
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve live ads")
+		return fmt.Errorf("could not retrieve live ads: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("ads_count", count), attribute.String("status", "success"))
+	return nil
+}
@@ -0,0 +1,164 @@
+/*
+This file builds the human-readable slugs of ads, like blue-mountain-bike-1042. A slug is made
+from the title when the ad is created and always ends in the ad ID, so two ads can't end up
+with the same generated slug. It stays the same when the title changes unless the update asks
+for a new one with regenerate_slug.
+*/
+package ad
+
+import (
+	"ad_service/internal/apperr"
+	"ad_service/pkg/tenant"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxSlugLength matches the ads.slug column
+const maxSlugLength = 96
+
+// maxSlugTitleLength is the part of a slug taken from the title, leaving room for the ID suffix
+const maxSlugTitleLength = 75
+
+// fallbackSlugTitle stands in for titles with nothing to transliterate, e.g. "!!!" or "🚲"
+const fallbackSlugTitle = "ad"
+
+// slugPattern accepts lowercase ASCII words joined by single hyphens
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// ErrSlugTaken is returned when a slug is already stored for another ad. Generated slugs end in
+// the ad ID, so this only happens when a row was given its slug outside the service.
+var ErrSlugTaken = apperr.New(apperr.ErrConflict, "Slug already in use by another ad")
+
+// transliterations spells letters that don't decompose into an ASCII base letter
+var transliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d", 'ł': "l", 'þ': "th", 'ı': "i",
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'ґ': "g", 'д': "d", 'е': "e", 'ё': "e", 'є': "ie",
+	'ж': "zh", 'з': "z", 'и': "i", 'і': "i", 'ї': "i", 'й': "i", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh",
+	'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "iu",
+	'я': "ia", '&': "and",
+}
+
+// Slugify builds the slug of an ad from its title and ID. Accents are stripped, Latin ligatures
+// and Cyrillic are transliterated, and any other run of characters becomes a single hyphen.
+// Titles left empty by that, such as scripts without a transliteration or only symbols, use
+// "ad" instead, so the result is always a valid slug.
+func Slugify(title string, id int64) string {
+	var b strings.Builder
+	hyphen := false
+	// NFKD splits accented letters into a base letter and combining marks, which are dropped
+	for _, r := range norm.NFKD.String(strings.ToLower(title)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		spelled, ok := transliterations[r]
+		if !ok && r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			spelled, ok = string(r), true
+		}
+		if !ok {
+			hyphen = b.Len() > 0
+			continue
+		}
+		if spelled == "" {
+			continue
+		}
+		if hyphen {
+			b.WriteByte('-')
+			hyphen = false
+		}
+		b.WriteString(spelled)
+	}
+
+	base := b.String()
+	if len(base) > maxSlugTitleLength {
+		// Cut at the last word boundary when there is one
+		base = base[:maxSlugTitleLength]
+		if cut := strings.LastIndexByte(base, '-'); cut > 0 {
+			base = base[:cut]
+		}
+	}
+	if base == "" {
+		base = fallbackSlugTitle
+	}
+	return base + "-" + strconv.FormatInt(id, 10)
+}
+
+// validSlug reports whether s can be a stored slug, so malformed ones are rejected before a lookup
+func validSlug(s string) bool {
+	return len(s) <= maxSlugLength && slugPattern.MatchString(s)
+}
+
+// setSlugs generates and stores the slugs of freshly inserted ads, whose IDs must be set
+func setSlugs(tx *sql.Tx, ads []*Ad, ctx context.Context) error {
+	if len(ads) == 0 {
+		return nil
+	}
+	query := "UPDATE ads SET slug = CASE id"
+	params := make([]interface{}, 0, len(ads)*3)
+	for _, ad := range ads {
+		slug := Slugify(ad.Title, ad.ID)
+		ad.Slug = &slug
+		query += " WHEN ? THEN ?"
+		params = append(params, ad.ID, slug)
+	}
+	query += " END WHERE id IN (?" + strings.Repeat(", ?", len(ads)-1) + ")"
+	for _, ad := range ads {
+		params = append(params, ad.ID)
+	}
+
+	if _, err := tx.ExecContext(ctx, query, params...); err != nil {
+		if isDuplicateKey(err) {
+			return ErrSlugTaken
+		}
+		return fmt.Errorf("could not set slugs: %w", err)
+	}
+	return nil
+}
+
+// isDuplicateKey reports whether err is MySQL refusing a write that breaks a unique index
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
+
+// GetAdIDBySlug resolves the slug of an ad to its ID, with tracing. ErrAdNotFound is returned
+// for an unknown slug.
+func (r *Repository) GetAdIDBySlug(slug string, ctx context.Context) (_ int64, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "GetAdIDBySlugRepository")
+	defer span.End()
+	defer observeQuery("get_ad_id_by_slug", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = r.DB.QueryRowContext(ctx, "SELECT id FROM ads WHERE slug = ? AND "+tenantCondition, slug, tenantID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		span.SetStatus(codes.Error, "Ad not found in DB")
+		return 0, ErrAdNotFound
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to resolve slug")
+		return 0, fmt.Errorf("could not resolve slug: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", id), attribute.String("db_status", "success"))
+	return id, nil
+}
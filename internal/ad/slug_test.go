@@ -0,0 +1,174 @@
+package ad
+
+import (
+	"ad_service/internal/apperr"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string
+		want  string
+	}{
+		{"plain", "Blue Mountain Bike", "blue-mountain-bike-1042"},
+		{"punctuation", "  Bike -- 50% off!!  ", "bike-50-off-1042"},
+		{"accents", "Café crème brûlée", "cafe-creme-brulee-1042"},
+		{"ligatures", "Straße Œuvre Ærø", "strasse-oeuvre-aero-1042"},
+		{"ampersand", "Tom&Jerry", "tomandjerry-1042"},
+		{"cyrillic", "Горный велосипед", "gornyi-velosiped-1042"},
+		{"soft and hard signs", "Объявление", "obiavlenie-1042"},
+		{"mixed scripts", "🚲 Велосипед Bike", "velosiped-bike-1042"},
+		// Titles with nothing to transliterate still give a valid slug
+		{"symbols only", "!!! ??? ***", "ad-1042"},
+		{"emoji only", "🚲🚲", "ad-1042"},
+		{"untransliterated script", "自行车", "ad-1042"},
+		{"empty", "", "ad-1042"},
+		{"long title cut at a word", strings.Repeat("bicycle ", 12), strings.TrimSuffix(strings.Repeat("bicycle-", 9), "-") + "-1042"},
+		{"long word cut at the limit", strings.Repeat("a", 100), strings.Repeat("a", maxSlugTitleLength) + "-1042"},
+	}
+	for _, tt := range tests {
+		got := Slugify(tt.title, 1042)
+		if got != tt.want {
+			t.Errorf("%s: Slugify(%q) = %q, want %q", tt.name, tt.title, got, tt.want)
+		}
+		if !validSlug(got) {
+			t.Errorf("%s: %q is not a valid slug", tt.name, got)
+		}
+	}
+
+	// Ads sharing a title differ by their ID, even at the longest title and ID
+	long := strings.Repeat("x", 200)
+	first, second := Slugify(long, 9223372036854775806), Slugify(long, 9223372036854775807)
+	if first == second || !validSlug(first) || !validSlug(second) {
+		t.Errorf("slugs of a shared title = %q and %q, want two valid slugs", first, second)
+	}
+}
+
+func TestValidSlug(t *testing.T) {
+	tests := map[string]bool{
+		"blue-mountain-bike-1042":            true,
+		"ad-7":                               true,
+		"7":                                  true,
+		"Blue-bike-7":                        false,
+		"blue--bike-7":                       false,
+		"-blue-bike-7":                       false,
+		"blue-bike-7-":                       false,
+		"blue_bike_7":                        false,
+		"велосипед-7":                        false,
+		"":                                   false,
+		strings.Repeat("a", maxSlugLength):   true,
+		strings.Repeat("a", maxSlugLength+1): false,
+	}
+	for slug, want := range tests {
+		if got := validSlug(slug); got != want {
+			t.Errorf("validSlug(%q) = %v, want %v", slug, got, want)
+		}
+	}
+}
+
+func TestGetAdBySlug(t *testing.T) {
+	service, mock := newTestService(t)
+	h := &Handler{Service: service}
+	r := newTestRouter(func(r gin.IRoutes) { r.GET("/ads/slug/:slug", h.GetAdBySlug) })
+	ad := testAd(7, "Blue bike")
+	ad.Slug = strPtr("blue-bike-7")
+
+	// The first lookup resolves the slug, the second is answered from the cache
+	mock.ExpectQuery("SELECT id FROM ads WHERE slug = \\? AND tenant_id = \\?").WithArgs("blue-bike-7", "default").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(7)))
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(7), "default").WillReturnRows(adRows(ad))
+	expectNoTranslations(mock)
+	first := serve(r, http.MethodGet, "/ads/slug/blue-bike-7", nil)
+	if first.Code != http.StatusOK || !strings.Contains(first.Body.String(), `"slug":"blue-bike-7"`) {
+		t.Fatalf("GET by slug = %d %s, want the ad", first.Code, first.Body.String())
+	}
+	if cached, _ := service.Cache.Get(slugCacheKey("blue-bike-7"), testCtx()); cached != "7" {
+		t.Errorf("cached mapping = %q, want 7", cached)
+	}
+	if w := serve(r, http.MethodGet, "/ads/slug/blue-bike-7", nil); w.Code != http.StatusOK || w.Body.String() != first.Body.String() {
+		t.Errorf("cached GET by slug = %d %s, want %s", w.Code, w.Body.String(), first.Body.String())
+	}
+
+	// An unknown slug is remembered as missing
+	mock.ExpectQuery("SELECT id FROM ads WHERE slug = ").WithArgs("red-bike-8", "default").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	for i := 0; i < 2; i++ {
+		if w := serve(r, http.MethodGet, "/ads/slug/red-bike-8", nil); w.Code != http.StatusNotFound {
+			t.Errorf("unknown slug, lookup %d = %d, want 404", i+1, w.Code)
+		}
+	}
+
+	// After a regeneration the old slug still maps to the ad, which no longer carries it
+	if err := service.Cache.Set(slugCacheKey("old-bike-7"), "7", testCacheConfig.AdTTL, testCtx()); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(r, http.MethodGet, "/ads/slug/old-bike-7", nil); w.Code != http.StatusNotFound {
+		t.Errorf("stale slug = %d %s, want 404", w.Code, w.Body.String())
+	}
+	if cached, _ := service.Cache.Get(slugCacheKey("old-bike-7"), testCtx()); cached != "" {
+		t.Errorf("stale mapping = %q, want it dropped", cached)
+	}
+
+	for _, slug := range []string{"Blue-Bike-7", "blue--bike-7", "blue_bike", "велосипед-7", strings.Repeat("a", maxSlugLength+1)} {
+		w := serve(r, http.MethodGet, "/ads/slug/"+slug, nil)
+		if w.Code != http.StatusBadRequest || errorMessage(t, w.Body.Bytes()) != "Invalid slug. Must be lowercase letters and digits joined by hyphens." {
+			t.Errorf("GET /ads/slug/%s = %d %s, want 400", slug, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestSlugCollisions(t *testing.T) {
+	duplicate := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'bike-7' for key 'ads.slug'"}
+
+	t.Run("insert", func(t *testing.T) {
+		service, mock := newTestService(t)
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO ads").WillReturnResult(sqlmock.NewResult(7, 1))
+		mock.ExpectExec("UPDATE ads SET slug = CASE id WHEN \\? THEN \\? END WHERE id IN \\(\\?\\)").
+			WithArgs(int64(7), "bike-7", int64(7)).WillReturnError(duplicate)
+		mock.ExpectRollback()
+		ad := testAd(0, "Bike")
+		if err := service.Repo.AddAd(&ad, testCtx()); !errors.Is(err, ErrSlugTaken) || !errors.Is(err, apperr.ErrConflict) {
+			t.Errorf("AddAd with a taken slug = %v, want ErrSlugTaken", err)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		regenerate bool
+		want       error
+	}{
+		{"regenerated", true, ErrSlugTaken},
+		// Without regenerate_slug the duplicate isn't about the slug
+		{"kept", false, duplicate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mock := newTestService(t)
+			query := "UPDATE ads SET title = .*, weight = \\? WHERE id = "
+			if tt.regenerate {
+				query = "UPDATE ads SET title = .*, slug = \\? WHERE id = "
+			}
+			mock.ExpectBegin()
+			mock.ExpectExec(query).WillReturnError(duplicate)
+			mock.ExpectRollback()
+
+			ad := testAd(7, "Bike")
+			ad.RegenerateSlug = tt.regenerate
+			err := service.Repo.UpdateAd(7, &ad, testCtx())
+			if !errors.Is(err, tt.want) || errors.Is(err, ErrSlugTaken) != tt.regenerate {
+				t.Errorf("UpdateAd = %v, want %v", err, tt.want)
+			}
+			if tt.regenerate && (ad.Slug == nil || *ad.Slug != "bike-7") {
+				t.Errorf("regenerated slug = %v, want bike-7", ad.Slug)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package ad
+
+import (
+	"ad_service/internal/config"
+	"ad_service/pkg/cache"
+	"ad_service/pkg/middleware"
+	"ad_service/pkg/pagination"
+	"ad_service/pkg/tenant"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// testTenancy serves acme by its header and beta by its API key
+var testTenancy = config.TenancyConfig{
+	Enabled:       true,
+	Header:        "X-Tenant-Id",
+	APIKeyHeader:  "X-Api-Key",
+	Tenants:       map[string]string{"acme": "", "beta": "beta-key"},
+	MissingStatus: http.StatusUnauthorized,
+}
+
+// newTenantRouter serves the ad routes of h with tenancy enabled
+func newTenantRouter(h *Handler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.Errors(testErrorMappings...), middleware.Tenant(testTenancy), middleware.Identity(testUserHeader, testRoleHeader, "admin"))
+	r.GET("/ads", h.GetAllAds)
+	r.GET("/ads/:id", h.GetAdByID)
+	r.PUT("/ads/:id", h.UpdateAd)
+	r.DELETE("/ads/:id", h.DeleteAd)
+	return r
+}
+
+// Tenant beta guessing the ID of an ad of tenant acme can't read, change or delete it: every
+// query is scoped to beta, which has no such ad, and acme's cached copy is never served to beta
+func TestTenantCannotReachAnotherTenantsAd(t *testing.T) {
+	service, mock := newTestService(t)
+	h := &Handler{Service: service, Paging: pagination.Policy{MaxLimit: 100, Mode: pagination.ModeClamp}}
+	r := newTenantRouter(h)
+	acme, beta := []string{"X-Tenant-Id", "acme"}, []string{"X-Api-Key", "beta-key", testRoleHeader, "admin"}
+
+	mock.ExpectQuery(`FROM ads WHERE id = \? AND tenant_id = \?`).WithArgs(int64(7), "acme").WillReturnRows(adRows(testAd(7, "Acme bike")))
+	expectNoTranslations(mock)
+	if w := serve(r, http.MethodGet, "/ads/7", nil, acme...); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Acme bike") {
+		t.Fatalf("GET /ads/7 as acme = %d %s, want its ad", w.Code, w.Body.String())
+	}
+
+	// Reading misses beta's cache and finds nothing in beta's rows
+	mock.ExpectQuery(`FROM ads WHERE id = \? AND tenant_id = \?`).WithArgs(int64(7), "beta").WillReturnRows(adRows())
+	if w := serve(r, http.MethodGet, "/ads/7", nil, beta...); w.Code != http.StatusNotFound {
+		t.Errorf("GET /ads/7 as beta = %d %s, want 404", w.Code, w.Body.String())
+	}
+
+	// Updating changes no row of beta's
+	update := make([]driver.Value, 8)
+	for i := range update {
+		update[i] = sqlmock.AnyArg()
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE ads SET .* WHERE id = \? AND tenant_id = \? AND archived_at IS NULL`).WithArgs(append(update, int64(7), "beta")...).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT archived_at IS NOT NULL FROM ads WHERE id = \? AND tenant_id = \?`).WithArgs(int64(7), "beta").WillReturnRows(sqlmock.NewRows([]string{"archived"}))
+	mock.ExpectRollback()
+	body := `{"title": "Taken over", "description": "Mine now", "price": 1}`
+	if w := serve(r, http.MethodPut, "/ads/7", strings.NewReader(body), beta...); w.Code != http.StatusNotFound {
+		t.Errorf("PUT /ads/7 as beta = %d %s, want 404", w.Code, w.Body.String())
+	}
+
+	// Deleting deletes no row of beta's
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM ads WHERE id = \? AND tenant_id = \?`).WithArgs(int64(7), "beta").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if w := serve(r, http.MethodDelete, "/ads/7", nil, beta...); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE /ads/7 as beta = %d %s, want 404", w.Code, w.Body.String())
+	}
+
+	// Listing, even as an admin of beta, only lists beta's ads
+	query, args, err := ListQuery{SortBy: "renewed_at", Order: "desc", Page: 1, Limit: pagination.DefaultLimit}.build("beta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(values...).WillReturnRows(plainAdRows())
+	if w := serve(r, http.MethodGet, "/ads", nil, beta...); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "Acme bike") {
+		t.Errorf("GET /ads as beta = %d %s, want none of acme's ads", w.Code, w.Body.String())
+	}
+
+	// Beta's attempts left acme's cached ad alone, it is still served without a query
+	if w := serve(r, http.MethodGet, "/ads/7", nil, acme...); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Acme bike") {
+		t.Errorf("GET /ads/7 as acme = %d %s, want its cached ad", w.Code, w.Body.String())
+	}
+	if cached, _ := service.Cache.Get(adCacheKey(7), tenant.With(context.Background(), "beta")); cached != notFoundCacheValue {
+		t.Errorf("beta's cache entry of ad 7 = %q, want it marked missing", cached)
+	}
+}
+
+func TestRepositoryRequiresTenant(t *testing.T) {
+	service, _ := newTestService(t)
+	ad := testAd(0, "Bike")
+	calls := map[string]error{
+		"AddAd":     service.Repo.AddAd(&ad, context.Background()),
+		"UpdateAd":  service.Repo.UpdateAd(7, &ad, context.Background()),
+		"DeleteAd":  service.Repo.DeleteAd(7, context.Background()),
+		"GetAdByID": func() error { _, err := service.Repo.GetAdByID(7, context.Background()); return err }(),
+		"GetAllAds": func() error {
+			_, err := service.Repo.GetAllAds(ListQuery{SortBy: "id", Order: "asc", Page: 1, Limit: 10}, context.Background())
+			return err
+		}(),
+	}
+	// No query is sent, which the mock's expectations check
+	for name, err := range calls {
+		if !errors.Is(err, tenant.ErrMissing) {
+			t.Errorf("%s without a tenant = %v, want ErrMissing", name, err)
+		}
+	}
+}
+
+func TestTenantCacheKeys(t *testing.T) {
+	memory := cache.NewMemoryCache(time.Minute)
+	defer memory.Close()
+	service, _ := newTestService(t)
+	service.Cache = cache.NewTenantCache(memory)
+
+	acme, beta := tenant.With(context.Background(), "acme"), tenant.With(context.Background(), "beta")
+	service.Cache.Set(adCacheKey(7), "acme's ad", time.Minute, acme)
+	if got, _ := service.Cache.Get(adCacheKey(7), beta); got != "" {
+		t.Errorf("beta reads %q under acme's key", got)
+	}
+	if got, _ := memory.Get("t:acme:"+adCacheKey(7), context.Background()); got != "acme's ad" {
+		t.Errorf("stored key holds %q, want it under acme's prefix", got)
+	}
+	// Invalidating beta's list pages keeps acme's
+	service.invalidateLists(acme)
+	service.invalidateLists(beta)
+	service.invalidateLists(beta)
+	if got, _ := service.Cache.Get(listGenerationKey, acme); got != "1" {
+		t.Errorf("acme's list generation = %q after beta's invalidations, want 1", got)
+	}
+}
+
+// The ads_total gauge is labeled by tenant, so its count groups the ads of every tenant
+func TestCountAdsByTenant(t *testing.T) {
+	service, mock := newTestService(t)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT tenant_id, is_active, COUNT(*) FROM ads GROUP BY tenant_id, is_active")).WillReturnRows(
+		sqlmock.NewRows([]string{"tenant_id", "is_active", "count"}).AddRow("acme", true, 3).AddRow("acme", false, 1).AddRow("beta", false, 2))
+
+	// Counting needs no tenant in the context
+	counts, err := service.Repo.CountAdsByTenant(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[bool]int{"acme": {true: 3, false: 1}, "beta": {true: 0, false: 2}}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("CountAdsByTenant = %v, want %v", counts, want)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+This file holds the translations of ads, stored in the ad_translations table. Ads are read
+with every translation they have and localized per request after the cache.
+*/
+package ad
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Translation is the title and description of an ad in one locale
+type Translation struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// Localize replaces the title and description with the translation for locale and names the
+// locale in Locale. Without a translation, or for an ad served with a creative variant, the
+// default fields are kept.
+func (ad *Ad) Localize(locale string) {
+	translation, ok := ad.Translations[locale]
+	if !ok || ad.Variant != nil {
+		return
+	}
+	ad.Title, ad.Description = translation.Title, translation.Description
+	ad.Locale = locale
+}
+
+// replaceTranslations swaps the translations of an ad for the given ones inside the transaction
+func replaceTranslations(tx *sql.Tx, adID int64, translations map[string]Translation, ctx context.Context) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM ad_translations WHERE ad_id = ?", adID); err != nil {
+		return fmt.Errorf("could not delete translations: %w", err)
+	}
+	return insertTranslations(tx, map[int64]map[string]Translation{adID: translations}, ctx)
+}
+
+// insertTranslations writes the translations of one or more ads with a single multi-row INSERT
+func insertTranslations(tx *sql.Tx, translationsByAd map[int64]map[string]Translation, ctx context.Context) error {
+	var placeholders []string
+	var params []interface{}
+	for adID, translations := range translationsByAd {
+		for locale, translation := range translations {
+			placeholders = append(placeholders, "(?, ?, ?, ?)")
+			params = append(params, adID, locale, translation.Title, translation.Description)
+		}
+	}
+	if len(placeholders) == 0 {
+		return nil
+	}
+
+	query := "INSERT INTO ad_translations (ad_id, locale, title, description) VALUES " + strings.Join(placeholders, ", ")
+	if _, err := tx.ExecContext(ctx, query, params...); err != nil {
+		return fmt.Errorf("could not insert translations: %w", err)
+	}
+	return nil
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// attachTranslations loads the translations of the given ads with one query and sets them on the ads
+func attachTranslations(q querier, ads []Ad, ctx context.Context) error {
+	if len(ads) == 0 {
+		return nil
+	}
+
+	index := make(map[int64]int, len(ads))
+	params := make([]interface{}, len(ads))
+	for i, ad := range ads {
+		index[ad.ID] = i
+		params[i] = ad.ID
+	}
+	query := "SELECT ad_id, locale, title, description FROM ad_translations WHERE ad_id IN (" +
+		strings.TrimSuffix(strings.Repeat("?, ", len(ads)), ", ") + ")"
+	rows, err := q.QueryContext(ctx, query, params...)
+	if err != nil {
+		return fmt.Errorf("could not retrieve translations: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var adID int64
+		var locale string
+		var translation Translation
+		if err := rows.Scan(&adID, &locale, &translation.Title, &translation.Description); err != nil {
+			return fmt.Errorf("could not scan translation: %w", err)
+		}
+		ad := &ads[index[adID]]
+		if ad.Translations == nil {
+			ad.Translations = map[string]Translation{}
+		}
+		ad.Translations[locale] = translation
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not retrieve translations: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+/*
+This file ranks ads by their recent views for GET /ads/trending. Views are counted in hourly
+Redis buckets by cache.Trending; without Redis the most recent ads are listed instead.
+*/
+package ad
+
+import (
+	"ad_service/pkg/metrics"
+	"ad_service/pkg/tenant"
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// TrendingWindows are the windows GET /ads/trending accepts, by their query value
+var TrendingWindows = map[string]time.Duration{
+	"1h":  time.Hour,
+	"6h":  6 * time.Hour,
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+}
+
+// MaxTrendingWindow is the longest of TrendingWindows, which view buckets must outlive
+const MaxTrendingWindow = 7 * 24 * time.Hour
+
+// Sources of a trending response
+const (
+	TrendingSourceViews  = "views"
+	TrendingSourceRecent = "recent"
+)
+
+// live reports whether an ad is public and servable: approved, not archived, active and not expired
+func (ad *Ad) live(now time.Time) bool {
+	return ad.Status == StatusApproved && ad.ArchivedAt == nil && ad.IsActive && (ad.ExpiresAt == nil || ad.ExpiresAt.After(now))
+}
+
+// GetTrendingAds returns up to limit live ads, most viewed over the window first, and the source
+// of the ranking, with tracing. The top IDs are hydrated through GetAdsByIDs, so cached ads
+// skip MySQL. When Redis is unavailable or no ad was viewed in the window, the most recent ads
+// are returned instead.
+func (s *AdService) GetTrendingAds(window time.Duration, limit int, ctx context.Context) ([]Ad, string, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "GetTrendingAdsService")
+	defer span.End()
+	span.SetAttributes(attribute.String("window", window.String()), attribute.Int("limit", limit))
+
+	if s.Trending != nil {
+		// Ask for more IDs than needed, since some of them may no longer be live
+		ids, err := s.Trending.Top(window, 2*limit, ctx)
+		if err != nil {
+			span.RecordError(err)
+		} else if len(ids) > 0 {
+			found, _, err := s.GetAdsByIDs(ids, ctx)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, "Failed to retrieve trending ads")
+				return nil, "", err
+			}
+			now := time.Now()
+			ads := make([]Ad, 0, limit)
+			for _, ad := range found {
+				if len(ads) < limit && ad.live(now) {
+					ads = append(ads, ad)
+				}
+			}
+			metrics.AdTrendingRequests.WithLabelValues(TrendingSourceViews).Inc()
+			span.SetAttributes(attribute.String("source", TrendingSourceViews), attribute.Int("ads_count", len(ads)))
+			return ads, TrendingSourceViews, nil
+		}
+	}
+
+	ads, err := s.Repo.GetLatestAds(limit, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve latest ads")
+		return nil, "", err
+	}
+	metrics.AdTrendingRequests.WithLabelValues(TrendingSourceRecent).Inc()
+	span.SetAttributes(attribute.String("source", TrendingSourceRecent), attribute.Int("ads_count", len(ads)))
+	return ads, TrendingSourceRecent, nil
+}
+
+// GetLatestAds returns the tenant's most recently created live ads, newest first, with tracing
+func (r *Repository) GetLatestAds(limit int, ctx context.Context) (_ []Ad, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "GetLatestAdsRepository")
+	defer span.End()
+	defer observeQuery("get_latest_ads", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT " + adColumns + " FROM ads WHERE " + tenantCondition + " AND " + activeCondition + " ORDER BY created_at DESC, id DESC LIMIT ?"
+	rows, err := r.DB.QueryContext(ctx, query, tenantID, limit)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve latest ads")
+		return nil, fmt.Errorf("could not retrieve latest ads: %w", err)
+	}
+	defer rows.Close()
+
+	ads := []Ad{}
+	for rows.Next() {
+		var ad Ad
+		if err := scanAd(rows, &ad); err != nil {
+			span.RecordError(err)
+			return nil, fmt.Errorf("could not scan ad: %w", err)
+		}
+		ads = append(ads, ad)
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve latest ads")
+		return nil, fmt.Errorf("could not retrieve latest ads: %w", err)
+	}
+	rows.Close()
+
+	if err := attachTranslations(r.DB, ads, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve translations")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("ads_count", len(ads)), attribute.String("status", "success"))
+	return ads, nil
+}
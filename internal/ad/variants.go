@@ -0,0 +1,284 @@
+/*
+This file holds the creative variants of ads, stored in the ad_variants table. Serving picks a
+variant by weight and attributes the impressions and clicks of the ad to it.
+*/
+package ad
+
+import (
+	"ad_service/internal/apperr"
+	"ad_service/pkg/tenant"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Variant is an alternative title and description for an ad, served in rotation by weight
+type Variant struct {
+	AdID        int64     `json:"ad_id"`
+	Key         string    `json:"key"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Weight      int       `json:"weight"`
+	Impressions int64     `json:"impressions"`
+	Clicks      int64     `json:"clicks"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ErrVariantNotFound is returned when a variant key doesn't exist for the ad
+var ErrVariantNotFound = apperr.New(apperr.ErrNotFound, "Variant not found")
+
+// ErrVariantExists is returned when adding a variant under a key the ad already uses
+var ErrVariantExists = apperr.New(apperr.ErrConflict, "The ad already has a variant with this key")
+
+// variantColumns is the column list selected for a full Variant, in the order expected by scanVariant
+const variantColumns = "ad_id, variant_key, title, description, weight, impressions, clicks, created_at"
+
+// scanVariant reads a row selected with variantColumns into the given Variant
+func scanVariant(row rowScanner, variant *Variant) error {
+	err := row.Scan(&variant.AdID, &variant.Key, &variant.Title, &variant.Description, &variant.Weight, &variant.Impressions, &variant.Clicks, &variant.CreatedAt)
+	variant.CreatedAt = variant.CreatedAt.UTC()
+	return err
+}
+
+// AddVariant inserts a variant for an ad, with tracing. ErrVariantExists is returned when the
+// ad already has a variant with the same key, and ErrAdNotFound when the ad doesn't exist.
+func (r *Repository) AddVariant(variant *Variant, ctx context.Context) (err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "AddVariantRepository")
+	defer span.End()
+	defer observeQuery("add_variant", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Selecting the ad within the tenant adds nothing to another tenant's ad. A plain INSERT,
+	// unlike INSERT IGNORE, still fails on bad data, and only the unique key on (ad_id,
+	// variant_key) makes it a conflict.
+	query := "INSERT INTO ad_variants (ad_id, variant_key, title, description, weight) SELECT id, ?, ?, ?, ? FROM ads WHERE id = ? AND " + tenantCondition
+	result, err := r.DB.ExecContext(ctx, query, variant.Key, variant.Title, variant.Description, variant.Weight, variant.AdID, tenantID)
+	if isDuplicateKey(err) {
+		span.RecordError(ErrVariantExists)
+		span.SetStatus(codes.Error, "The ad already has a variant with this key")
+		return ErrVariantExists
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to insert variant")
+		return fmt.Errorf("could not insert variant: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("could not retrieve affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		span.RecordError(ErrAdNotFound)
+		span.SetStatus(codes.Error, "Ad not found")
+		return ErrAdNotFound
+	}
+
+	// Read back the stored row for created_at and the zero counters
+	query = "SELECT " + variantColumns + " FROM ad_variants WHERE ad_id = ? AND variant_key = ?"
+	if err := scanVariant(r.DB.QueryRowContext(ctx, query, variant.AdID, variant.Key), variant); err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("could not retrieve variant: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", variant.AdID), attribute.String("variant", variant.Key), attribute.String("status", "success"))
+	return nil
+}
+
+// GetVariants returns the variants of an ad ordered by key, with tracing
+func (r *Repository) GetVariants(adID int64, ctx context.Context) (_ []Variant, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "GetVariantsRepository")
+	defer span.End()
+	defer observeQuery("get_variants", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT " + variantColumns + " FROM ad_variants WHERE ad_id = ? AND " + tenantAdCondition + " ORDER BY variant_key"
+	rows, err := r.DB.QueryContext(ctx, query, adID, tenantID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve variants")
+		return nil, fmt.Errorf("could not retrieve variants: %w", err)
+	}
+	defer rows.Close()
+
+	variants := []Variant{}
+	for rows.Next() {
+		var variant Variant
+		if err := scanVariant(rows, &variant); err != nil {
+			span.RecordError(err)
+			return nil, fmt.Errorf("could not scan variant: %w", err)
+		}
+		variants = append(variants, variant)
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve variants")
+		return nil, fmt.Errorf("could not retrieve variants: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", adID), attribute.Int("variants_count", len(variants)), attribute.String("status", "success"))
+	return variants, nil
+}
+
+// UpdateVariant replaces the title, description and weight of a variant, with tracing. The
+// counters are kept; the variant is filled with the stored state.
+func (r *Repository) UpdateVariant(variant *Variant, ctx context.Context) (err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "UpdateVariantRepository")
+	defer span.End()
+	defer observeQuery("update_variant", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE ad_variants SET title = ?, description = ?, weight = ? WHERE ad_id = ? AND variant_key = ? AND " + tenantAdCondition
+	if _, err := r.DB.ExecContext(ctx, query, variant.Title, variant.Description, variant.Weight, variant.AdID, variant.Key, tenantID); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to update variant")
+		return fmt.Errorf("could not update variant: %w", err)
+	}
+
+	// An unchanged row affects nothing, so existence is checked by reading it back
+	query = "SELECT " + variantColumns + " FROM ad_variants WHERE ad_id = ? AND variant_key = ? AND " + tenantAdCondition
+	err = scanVariant(r.DB.QueryRowContext(ctx, query, variant.AdID, variant.Key, tenantID), variant)
+	if errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(ErrVariantNotFound)
+		span.SetStatus(codes.Error, "Variant not found")
+		return ErrVariantNotFound
+	}
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("could not retrieve variant: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", variant.AdID), attribute.String("variant", variant.Key), attribute.String("status", "updated"))
+	return nil
+}
+
+// DeleteVariant deletes a variant, with tracing
+func (r *Repository) DeleteVariant(adID int64, key string, ctx context.Context) (err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "DeleteVariantRepository")
+	defer span.End()
+	defer observeQuery("delete_variant", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.DB.ExecContext(ctx, "DELETE FROM ad_variants WHERE ad_id = ? AND variant_key = ? AND "+tenantAdCondition, adID, key, tenantID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to delete variant")
+		return fmt.Errorf("could not delete variant: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("could not retrieve affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		span.RecordError(ErrVariantNotFound)
+		span.SetStatus(codes.Error, "Variant not found")
+		return ErrVariantNotFound
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", adID), attribute.String("variant", key), attribute.String("status", "deleted"))
+	return nil
+}
+
+// attachVariants loads the variants of the given ads with one query and sets them on the ads
+func (r *Repository) attachVariants(ads []Ad, ctx context.Context) error {
+	if len(ads) == 0 {
+		return nil
+	}
+
+	index := make(map[int64]int, len(ads))
+	params := make([]interface{}, len(ads))
+	for i, ad := range ads {
+		index[ad.ID] = i
+		params[i] = ad.ID
+	}
+	query := "SELECT " + variantColumns + " FROM ad_variants WHERE ad_id IN (" +
+		strings.TrimSuffix(strings.Repeat("?, ", len(ads)), ", ") + ") AND weight > 0 ORDER BY ad_id, variant_key"
+	rows, err := r.DB.QueryContext(ctx, query, params...)
+	if err != nil {
+		return fmt.Errorf("could not retrieve variants: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var variant Variant
+		if err := scanVariant(rows, &variant); err != nil {
+			return fmt.Errorf("could not scan variant: %w", err)
+		}
+		ad := &ads[index[variant.AdID]]
+		ad.Variants = append(ad.Variants, variant)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not retrieve variants: %w", err)
+	}
+	return nil
+}
+
+// AdCounters holds the impressions and clicks of an ad and of each of its variants
+type AdCounters struct {
+	AdID        int64     `json:"ad_id"`
+	Impressions int64     `json:"impressions"`
+	Clicks      int64     `json:"clicks"`
+	Variants    []Variant `json:"variants"`
+}
+
+// GetCounters returns the impression and click counters of an ad and its variants, with tracing
+func (r *Repository) GetCounters(adID int64, ctx context.Context) (_ *AdCounters, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "GetCountersRepository")
+	defer span.End()
+	defer observeQuery("get_counters", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	counters := AdCounters{AdID: adID}
+	err = r.DB.QueryRowContext(ctx, "SELECT impressions, clicks FROM ads WHERE id = ? AND "+tenantCondition, adID, tenantID).Scan(&counters.Impressions, &counters.Clicks)
+	if errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(ErrAdNotFound)
+		span.SetStatus(codes.Error, "Ad not found")
+		return nil, ErrAdNotFound
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve counters")
+		return nil, fmt.Errorf("could not retrieve counters: %w", err)
+	}
+
+	if counters.Variants, err = r.GetVariants(adID, ctx); err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int64("ad_id", adID), attribute.String("status", "success"))
+	return &counters, nil
+}
@@ -0,0 +1,74 @@
+package ad
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestAddVariant(t *testing.T) {
+	insert := regexp.QuoteMeta("INSERT INTO ad_variants (ad_id, variant_key, title, description, weight) SELECT id, ?, ?, ?, ? FROM ads WHERE id = ? AND tenant_id = ?")
+	tests := []struct {
+		name   string
+		expect func(mock sqlmock.Sqlmock)
+		status int
+		body   string
+	}{
+		{
+			name: "added",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(insert).WithArgs("b", "Bike B", "Other words", 2, int64(7), "default").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery("FROM ad_variants WHERE ad_id = ").WithArgs(int64(7), "b").WillReturnRows(sqlmock.NewRows(strings.Split(variantColumns, ", ")).
+					AddRow(int64(7), "b", "Bike B", "Other words", 2, int64(0), int64(0), time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)))
+			},
+			status: http.StatusCreated,
+			body:   `"key":"b"`,
+		},
+		{
+			name: "existing key",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(insert).WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry '7-b' for key 'PRIMARY'"})
+			},
+			status: http.StatusConflict,
+			body:   ErrVariantExists.Error(),
+		},
+		{
+			name: "missing ad",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(insert).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			status: http.StatusNotFound,
+			body:   ErrAdNotFound.Error(),
+		},
+		{
+			// INSERT IGNORE turned this into a warning and reported the key as taken
+			name: "value too long",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(insert).WillReturnError(&mysql.MySQLError{Number: 1406, Message: "Data too long for column 'title'"})
+			},
+			status: http.StatusBadRequest,
+			body:   "Value is too long",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mock := newTestService(t)
+			tt.expect(mock)
+			h := &Handler{Service: service}
+			r := newTestRouter(func(r gin.IRoutes) {
+				r.POST("/ads/:id/variants", h.AddVariant)
+			})
+
+			w := serve(r, http.MethodPost, "/ads/7/variants", strings.NewReader(`{"key": "b", "title": "Bike B", "description": "Other words", "weight": 2}`))
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("POST /ads/7/variants = %d %s, want %d with %q", w.Code, w.Body, tt.status, tt.body)
+			}
+		})
+	}
+}
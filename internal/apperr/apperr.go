@@ -0,0 +1,158 @@
+/*
+This file defines the kinds of domain errors shared by the repositories, the services and the
+error middleware. Every error leaving a repository or service has one of the kinds in its chain,
+so callers decide what happened with errors.Is instead of looking at database or cache errors.
+*/
+package apperr
+
+import (
+	"ad_service/pkg/tenant"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Kinds of domain errors, matched with errors.Is
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrValidation  = errors.New("validation failed")
+	ErrConflict    = errors.New("conflict")
+	ErrForbidden   = errors.New("forbidden")
+	ErrUnavailable = errors.New("unavailable")
+)
+
+// Error is a domain error of one kind. Message can be shown to clients; Cause, when set, is the
+// underlying error and stays reachable with errors.Is and errors.As.
+type Error struct {
+	Kind    error
+	Message string
+	Cause   error
+}
+
+// New returns an error of the kind with a message, for sentinels like ErrAdNotFound
+func New(kind error, message string) *Error {
+	return &Error{Kind: kind, Message: message}
+}
+
+// Wrap returns an error of the kind caused by err
+func Wrap(kind error, message string, err error) *Error {
+	return &Error{Kind: kind, Message: message, Cause: err}
+}
+
+func (e *Error) Error() string {
+	if e.Cause == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Cause.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Cause}
+}
+
+// ClientMessage is the message of the error without its cause
+func (e *Error) ClientMessage() string {
+	return e.Message
+}
+
+// Field names a request field and the validation rule it broke
+type Field struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+}
+
+// ValidationError is an ErrValidation listing the fields that failed
+type ValidationError struct {
+	Message string
+	Fields  []Field
+}
+
+// Validation returns a validation error for the given fields
+func Validation(message string, fields ...Field) *ValidationError {
+	return &ValidationError{Message: message, Fields: fields}
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrValidation
+}
+
+// ClientMessage is the message of the error
+func (e *ValidationError) ClientMessage() string {
+	return e.Message
+}
+
+// ClientDetails lists the failed fields like the 400s of the handlers
+func (e *ValidationError) ClientDetails() map[string]any {
+	return map[string]any{"fields": e.Fields}
+}
+
+// kinds lists every kind, for Kinded
+var kinds = []error{ErrNotFound, ErrValidation, ErrConflict, ErrForbidden, ErrUnavailable}
+
+// Kinded reports whether err already is a domain error
+func Kinded(err error) bool {
+	for _, kind := range kinds {
+		if errors.Is(err, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// mysqlKinds classifies the MySQL server errors that say something about the request rather
+// than the server: constraint violations and values the columns can't hold
+var mysqlKinds = map[uint16]struct {
+	kind    error
+	message string
+}{
+	1062: {ErrConflict, "Duplicate value"},                   // duplicate entry for a unique key
+	1451: {ErrConflict, "Row is still referenced"},           // deleting or updating a parent row
+	1452: {ErrValidation, "Referenced row does not exist"},   // adding a child row
+	1406: {ErrValidation, "Value is too long"},               // data too long for column
+	1264: {ErrValidation, "Value is out of range"},           // out of range value for column
+	1048: {ErrValidation, "Required value is missing"},       // column cannot be null
+	3819: {ErrValidation, "Value breaks a check constraint"}, // check constraint violated
+}
+
+// mysqlTimeouts are the MySQL errors of a query that ran out of time on the server: lock wait
+// timeouts and max_execution_time interruptions
+var mysqlTimeouts = map[uint16]bool{1205: true, 3024: true}
+
+// FromDB turns the error of a database call into a domain error. No rows becomes notFound, and a
+// domain error or tenant.ErrMissing is kept. Constraint violations and rejected values are ErrConflict or
+// ErrValidation; timeouts, lost connections and anything else are ErrUnavailable. The driver
+// error stays in the chain, so deadline and cancellation errors are still matchable.
+func FromDB(err error, notFound error) error {
+	var mysqlErr *mysql.MySQLError
+	switch {
+	case err == nil || Kinded(err):
+		return err
+	case errors.Is(err, tenant.ErrMissing):
+		// A query without a tenant is a bug in the caller, not a database failure
+		return err
+	case errors.Is(err, sql.ErrNoRows):
+		return notFound
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return Wrap(ErrUnavailable, "Database query interrupted", err)
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, mysql.ErrInvalidConn), errors.Is(err, sql.ErrConnDone):
+		return Wrap(ErrUnavailable, "Database connection lost", err)
+	case errors.As(err, &mysqlErr):
+		if class, ok := mysqlKinds[mysqlErr.Number]; ok {
+			return Wrap(class.kind, class.message, err)
+		}
+		if mysqlTimeouts[mysqlErr.Number] {
+			return Wrap(ErrUnavailable, "Database query timed out", err)
+		}
+	}
+	return Wrap(ErrUnavailable, "Database unavailable", err)
+}
@@ -0,0 +1,114 @@
+package apperr
+
+import (
+	"ad_service/pkg/tenant"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var errTestNotFound = New(ErrNotFound, "Thing not found")
+
+func TestFromDB(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		kind    error
+		message string
+	}{
+		{"no rows", fmt.Errorf("could not scan: %w", sql.ErrNoRows), ErrNotFound, "Thing not found"},
+		{"canceled", fmt.Errorf("could not query: %w", context.Canceled), ErrUnavailable, "Database query interrupted"},
+		{"deadline", context.DeadlineExceeded, ErrUnavailable, "Database query interrupted"},
+		{"bad connection", driver.ErrBadConn, ErrUnavailable, "Database connection lost"},
+		{"invalid connection", mysql.ErrInvalidConn, ErrUnavailable, "Database connection lost"},
+		{"connection done", sql.ErrConnDone, ErrUnavailable, "Database connection lost"},
+		{"duplicate key", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'x' for key 'slug'"}, ErrConflict, "Duplicate value"},
+		{"referenced row", &mysql.MySQLError{Number: 1451}, ErrConflict, "Row is still referenced"},
+		{"missing parent", &mysql.MySQLError{Number: 1452}, ErrValidation, "Referenced row does not exist"},
+		{"too long", &mysql.MySQLError{Number: 1406}, ErrValidation, "Value is too long"},
+		{"out of range", &mysql.MySQLError{Number: 1264}, ErrValidation, "Value is out of range"},
+		{"null", &mysql.MySQLError{Number: 1048}, ErrValidation, "Required value is missing"},
+		{"check constraint", &mysql.MySQLError{Number: 3819}, ErrValidation, "Value breaks a check constraint"},
+		{"lock wait timeout", &mysql.MySQLError{Number: 1205}, ErrUnavailable, "Database query timed out"},
+		{"execution time", &mysql.MySQLError{Number: 3024}, ErrUnavailable, "Database query timed out"},
+		{"other server error", &mysql.MySQLError{Number: 1064}, ErrUnavailable, "Database unavailable"},
+		{"anything else", errors.New("dial tcp: connection refused"), ErrUnavailable, "Database unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := FromDB(tt.err, errTestNotFound)
+			if !errors.Is(err, tt.kind) {
+				t.Fatalf("FromDB(%v) = %v, want kind %v", tt.err, err, tt.kind)
+			}
+			for _, kind := range kinds {
+				if kind != tt.kind && errors.Is(err, kind) {
+					t.Errorf("FromDB(%v) is also %v", tt.err, kind)
+				}
+			}
+			var domain *Error
+			if !errors.As(err, &domain) || domain.ClientMessage() != tt.message {
+				t.Errorf("FromDB(%v) = %v, want the message %q", tt.err, err, tt.message)
+			}
+			// The driver error stays matchable, except no rows which is replaced
+			if tt.kind != ErrNotFound && !errors.Is(err, tt.err) {
+				t.Errorf("FromDB(%v) = %v lost its cause", tt.err, err)
+			}
+			// Wrapping again keeps the kind, and the result doesn't change
+			wrapped := fmt.Errorf("could not get thing: %w", err)
+			if again := FromDB(wrapped, errTestNotFound); again != wrapped || !errors.Is(again, tt.kind) {
+				t.Errorf("FromDB of a domain error = %v, want it unchanged", again)
+			}
+		})
+	}
+
+	if err := FromDB(nil, errTestNotFound); err != nil {
+		t.Errorf("FromDB(nil) = %v", err)
+	}
+	// A query without a tenant is a bug, not an unavailable database
+	missing := fmt.Errorf("could not list: %w", tenant.ErrMissing)
+	if err := FromDB(missing, errTestNotFound); err != missing || Kinded(err) {
+		t.Errorf("FromDB(%v) = %v, want it unchanged", missing, err)
+	}
+	// The mysql error is reachable with errors.As through the domain error
+	var mysqlErr *mysql.MySQLError
+	if err := FromDB(fmt.Errorf("could not insert: %w", &mysql.MySQLError{Number: 1062}), errTestNotFound); !errors.As(err, &mysqlErr) || mysqlErr.Number != 1062 {
+		t.Errorf("FromDB lost the MySQL error: %v", err)
+	}
+}
+
+func TestError(t *testing.T) {
+	cause := errors.New("dial tcp")
+	err := Wrap(ErrUnavailable, "Cache unavailable", cause)
+	if err.Error() != "Cache unavailable: dial tcp" || err.ClientMessage() != "Cache unavailable" {
+		t.Errorf("Error() = %q, ClientMessage() = %q", err.Error(), err.ClientMessage())
+	}
+	if !errors.Is(err, ErrUnavailable) || !errors.Is(err, cause) || errors.Is(err, ErrNotFound) {
+		t.Errorf("%v doesn't match its kind and cause only", err)
+	}
+	if sentinel := New(ErrConflict, "Ad is archived"); sentinel.Error() != "Ad is archived" || !errors.Is(fmt.Errorf("renew: %w", sentinel), ErrConflict) {
+		t.Errorf("New = %v, want an ErrConflict", sentinel)
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	err := fmt.Errorf("could not add ad: %w", Validation("Invalid title", Field{"title", "required"}, Field{"title", "max_length"}))
+	var validation *ValidationError
+	if !errors.Is(err, ErrValidation) || !errors.As(err, &validation) || !Kinded(err) {
+		t.Fatalf("%v is not a validation error", err)
+	}
+	if validation.ClientMessage() != "Invalid title" {
+		t.Errorf("ClientMessage() = %q", validation.ClientMessage())
+	}
+	fields, _ := validation.ClientDetails()["fields"].([]Field)
+	if len(fields) != 2 || fields[1] != (Field{"title", "max_length"}) {
+		t.Errorf("ClientDetails() = %v, want both fields", validation.ClientDetails())
+	}
+	if Kinded(errors.New("boom")) {
+		t.Error("a plain error is kinded")
+	}
+}
@@ -0,0 +1,286 @@
+/*
+This file contains the HTTP handlers for campaigns under /campaigns.
+*/
+package campaign
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/pkg/metrics"
+	"ad_service/pkg/middleware"
+	"ad_service/pkg/pagination"
+	"ad_service/pkg/response"
+	"ad_service/pkg/timestamp"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Handler struct holds a reference to the CampaignService
+type Handler struct {
+	Service *CampaignService
+	Paging  pagination.Policy // caps page sizes like on GET /ads
+}
+
+// AddCampaign handles the creation of a campaign, with tracing
+// Expected body: {"name": "Spring sale", "starts_at": "2024-03-01T00:00:00Z", "ends_at": "2024-04-01T00:00:00Z", "status": "active"}
+func (h *Handler) AddCampaign(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "AddCampaignHandler")
+	defer span.End()
+
+	var campaign Campaign
+	if !bindCampaign(c, span, &campaign) {
+		return
+	}
+
+	if err := h.Service.AddCampaign(&campaign, ctx); err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Failed to add campaign"))
+		internalError(c, err, "Failed to add campaign")
+		return
+	}
+
+	span.SetAttributes(attribute.Int("campaign_id", campaign.ID), attribute.String("status", "success"))
+	response.Data(c, http.StatusCreated, campaign)
+}
+
+// GetCampaigns handles listing campaigns, with tracing
+// Expected URL: http://localhost:8080/campaigns?page=1&limit=10
+func (h *Handler) GetCampaigns(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "GetCampaignsHandler")
+	defer span.End()
+
+	page, ok := h.pageParams(c, span)
+	if !ok {
+		return
+	}
+
+	campaigns, err := h.Service.GetCampaigns(page.Page, page.Limit, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Failed to fetch campaigns"))
+		internalError(c, err, "Failed to fetch campaigns")
+		return
+	}
+
+	span.SetAttributes(attribute.Int("campaigns_count", len(campaigns)), attribute.String("status", "success"))
+	response.List(c, http.StatusOK, campaigns, page.Meta(len(campaigns)))
+}
+
+// GetCampaignByID handles fetching a single campaign, with tracing
+func (h *Handler) GetCampaignByID(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "GetCampaignByIDHandler")
+	defer span.End()
+
+	id, ok := campaignID(c, span)
+	if !ok {
+		return
+	}
+
+	campaign, err := h.Service.GetCampaignByID(id, ctx)
+	if err != nil {
+		respondError(c, span, err, "Failed to fetch campaign")
+		return
+	}
+
+	span.SetAttributes(attribute.Int("campaign_id", id), attribute.String("status", "success"))
+	response.Data(c, http.StatusOK, campaign)
+}
+
+// UpdateCampaign handles replacing a campaign's name, dates and status, with tracing
+func (h *Handler) UpdateCampaign(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "UpdateCampaignHandler")
+	defer span.End()
+
+	id, ok := campaignID(c, span)
+	if !ok {
+		return
+	}
+	var campaign Campaign
+	if !bindCampaign(c, span, &campaign) {
+		return
+	}
+
+	if err := h.Service.UpdateCampaign(id, &campaign, ctx); err != nil {
+		respondError(c, span, err, "Failed to update campaign")
+		return
+	}
+
+	span.SetAttributes(attribute.Int("campaign_id", id), attribute.String("status", "success"))
+	response.Data(c, http.StatusOK, campaign)
+}
+
+// DeleteCampaign handles deleting a campaign, with tracing. It is refused while ads reference the
+// campaign, unless ?detach=true takes them out of it first.
+func (h *Handler) DeleteCampaign(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "DeleteCampaignHandler")
+	defer span.End()
+
+	id, ok := campaignID(c, span)
+	if !ok {
+		return
+	}
+	detach, err := strconv.ParseBool(c.DefaultQuery("detach", "false"))
+	if err != nil {
+		span.RecordError(err)
+		badRequest(c, span, "Invalid detach value. Must be true or false.", fieldError{"detach", "boolean"})
+		return
+	}
+
+	if err := h.Service.DeleteCampaign(id, detach, ctx); err != nil {
+		respondError(c, span, err, "Failed to delete campaign")
+		return
+	}
+
+	span.SetAttributes(attribute.Int("campaign_id", id), attribute.String("status", "success"))
+	response.Message(c, http.StatusOK, "Campaign deleted")
+}
+
+// GetCampaignAds handles listing the ads of a campaign, with tracing. Like GET /ads, only approved
+// ads are listed unless the caller is an admin.
+// Expected URL: http://localhost:8080/campaigns/3/ads?page=1&limit=10
+func (h *Handler) GetCampaignAds(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "GetCampaignAdsHandler")
+	defer span.End()
+
+	id, ok := campaignID(c, span)
+	if !ok {
+		return
+	}
+	page, ok := h.pageParams(c, span)
+	if !ok {
+		return
+	}
+
+	filter := ad.ListFilter{Status: ad.StatusApproved}
+	if middleware.CallerFrom(c).Admin {
+		filter = ad.ListFilter{}
+	}
+	ads, err := h.Service.GetCampaignAds(id, page.Page, page.Limit, filter, ctx)
+	if err != nil {
+		respondError(c, span, err, "Failed to fetch campaign ads")
+		return
+	}
+
+	span.SetAttributes(attribute.Int("campaign_id", id), attribute.Int("ads_count", len(ads)), attribute.String("status", "success"))
+	response.List(c, http.StatusOK, ad.NewAdResponses(ads, h.Service.Ads.Rules.Load().ExposeNumericIDs), page.Meta(len(ads)))
+}
+
+// maxCampaignNameLength matches the campaigns.name column
+const maxCampaignNameLength = 255
+
+// bindCampaign reads and validates a campaign body, responding with a 400 and returning false
+// when it is invalid. The status defaults to active.
+func bindCampaign(c *gin.Context, span trace.Span, campaign *Campaign) bool {
+	if err := c.ShouldBindJSON(campaign); err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid request body"))
+		badRequest(c, span, "Invalid request body", fieldError{"body", "json"})
+		return false
+	}
+
+	campaign.Name = strings.TrimSpace(campaign.Name)
+	if campaign.Name == "" || len(campaign.Name) > maxCampaignNameLength {
+		badRequest(c, span, "Name is required and must be at most "+strconv.Itoa(maxCampaignNameLength)+" characters", fieldError{"name", "length"})
+		return false
+	}
+	if campaign.Status == "" {
+		campaign.Status = StatusActive
+	}
+	if campaign.Status != StatusActive && campaign.Status != StatusPaused {
+		badRequest(c, span, "Invalid status value. Must be either 'active' or 'paused'.", fieldError{"status", "one_of"})
+		return false
+	}
+	if campaign.StartsAt != nil && campaign.EndsAt != nil && !campaign.EndsAt.After(*campaign.StartsAt) {
+		badRequest(c, span, "ends_at must be after starts_at", fieldError{"ends_at", "after_starts_at"})
+		return false
+	}
+
+	// Offsets are accepted on input, the dates are stored and returned in UTC
+	campaign.StartsAt, campaign.EndsAt = timestamp.UTC(campaign.StartsAt), timestamp.UTC(campaign.EndsAt)
+
+	// Only the writable fields come from the body
+	campaign.ID = 0
+	campaign.CreatedAt = time.Time{}
+	return true
+}
+
+// campaignID parses the :id parameter, responding with a 400 and returning false when it is invalid
+func campaignID(c *gin.Context, span trace.Span) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		span.RecordError(err)
+		span.SetAttributes(attribute.String("error", "Invalid campaign ID"))
+		badRequest(c, span, "Invalid campaign ID", fieldError{"id", "positive_integer"})
+		return 0, false
+	}
+	return id, true
+}
+
+// pageParams parses the page and limit parameters like GET /ads
+func (h *Handler) pageParams(c *gin.Context, span trace.Span) (pagination.Page, bool) {
+	page, err := h.Paging.Parse(c)
+	if err != nil {
+		badRequest(c, span, err.Message, fieldError{err.Field, err.Rule})
+		return page, false
+	}
+	if page.Clamped() {
+		span.SetAttributes(attribute.Int("requested_limit", page.Requested), attribute.Int("limit", page.Limit))
+	}
+	return page, true
+}
+
+// respondError maps the campaign errors to 404 and 409, anything else to a 500 with message
+func respondError(c *gin.Context, span trace.Span, err error, message string) {
+	span.RecordError(err)
+	switch {
+	case errors.Is(err, ErrCampaignNotFound):
+		span.SetAttributes(attribute.String("error", "Campaign not found"))
+		response.Error(c, http.StatusNotFound, "Campaign not found", nil)
+	case errors.Is(err, ErrCampaignInUse):
+		span.SetAttributes(attribute.String("error", "Campaign in use"))
+		response.Error(c, http.StatusConflict, "Campaign still has ads, delete with ?detach=true to take them out of it", nil)
+	default:
+		span.SetAttributes(attribute.String("error", message))
+		internalError(c, err, message)
+	}
+}
+
+// internalError hands an unexpected error to the error middleware, like the ad handlers
+func internalError(c *gin.Context, err error, message string) {
+	c.Error(err).SetMeta(message)
+}
+
+// fieldError names a request field and the validation rule it broke
+type fieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+}
+
+// badRequest responds with a 400 listing the failed fields and counts them in the validation metric
+func badRequest(c *gin.Context, span trace.Span, message string, failures ...fieldError) {
+	fields := make([]string, len(failures))
+	rules := make([]string, len(failures))
+	for i, failure := range failures {
+		fields[i] = failure.Field
+		rules[i] = failure.Rule
+		metrics.ValidationFailures.WithLabelValues(c.FullPath(), failure.Field).Inc()
+	}
+	span.AddEvent("validation_failed", trace.WithAttributes(
+		attribute.StringSlice("validation.fields", fields),
+		attribute.StringSlice("validation.rules", rules),
+	))
+	response.ErrorCode(c, http.StatusBadRequest, response.CodeValidation, message, gin.H{"fields": failures})
+}
@@ -0,0 +1,119 @@
+package campaign
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectServe expects a serve to load the servable ads, holding only the given ad, and to count
+// its impression
+func expectServe(mock sqlmock.Sqlmock, id int64, campaignID driver.Value) {
+	expectServableAds(mock, servableAdRow(id, campaignID))
+	mock.ExpectExec("UPDATE ads SET impressions = impressions \\+ 1").WithArgs(id, "default").WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestDeleteCampaignHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		expect func(mock sqlmock.Sqlmock)
+		status int
+		body   string
+	}{
+		{
+			name:   "blocked by its ads",
+			target: "/campaigns/3",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectReferencingAds(mock, 7)
+				mock.ExpectRollback()
+			},
+			status: http.StatusConflict,
+			body:   "delete with ?detach=true",
+		},
+		{
+			name:   "detaching its ads",
+			target: "/campaigns/3?detach=true",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectReferencingAds(mock, 7)
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE ads SET campaign_id = NULL WHERE campaign_id = ? AND tenant_id = ?")).WithArgs(3, "default").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM campaigns WHERE id = ? AND tenant_id = ?")).WithArgs(3, "default").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			status: http.StatusOK,
+			body:   "Campaign deleted",
+		},
+		{
+			name:   "invalid detach",
+			target: "/campaigns/3?detach=maybe",
+			expect: func(sqlmock.Sqlmock) {},
+			status: http.StatusBadRequest,
+			body:   `"field":"detach"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, mock := newTestRouter(t)
+			tt.expect(mock)
+
+			w := serve(r, http.MethodDelete, tt.target, nil)
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("DELETE %s = %d %s, want %d with %q", tt.target, w.Code, w.Body, tt.status, tt.body)
+			}
+		})
+	}
+}
+
+func TestDeleteCampaignDropsServeSnapshot(t *testing.T) {
+	r, mock := newTestRouter(t)
+	expectServe(mock, 7, int64(3))
+	if w := serve(r, http.MethodGet, "/ads/serve", nil); w.Code != http.StatusOK {
+		t.Fatalf("GET /ads/serve = %d %s, want 200", w.Code, w.Body)
+	}
+
+	mock.ExpectBegin()
+	expectReferencingAds(mock, 7)
+	mock.ExpectExec("UPDATE ads SET campaign_id = NULL").WithArgs(3, "default").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM campaigns").WithArgs(3, "default").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if w := serve(r, http.MethodDelete, "/campaigns/3?detach=true", nil); w.Code != http.StatusOK {
+		t.Fatalf("DELETE /campaigns/3?detach=true = %d %s, want 200", w.Code, w.Body)
+	}
+
+	// The snapshot held the ad in its campaign, so the next serve reloads it
+	expectServe(mock, 7, nil)
+	if w := serve(r, http.MethodGet, "/ads/serve", nil); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "campaign_id") {
+		t.Errorf("GET /ads/serve after the delete = %d %s, want the ad without its campaign", w.Code, w.Body)
+	}
+}
+
+func TestPausedCampaignHidesItsAds(t *testing.T) {
+	r, mock := newTestRouter(t)
+	expectServe(mock, 7, int64(3))
+	if w := serve(r, http.MethodGet, "/ads/serve", nil); w.Code != http.StatusOK {
+		t.Fatalf("GET /ads/serve = %d %s, want 200", w.Code, w.Body)
+	}
+
+	created := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE campaigns SET name = ?, starts_at = ?, ends_at = ?, status = ? WHERE id = ? AND tenant_id = ?")).
+		WithArgs("Spring sale", nil, nil, StatusPaused, 3, "default").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT "+campaignColumns+" FROM campaigns").WithArgs(3, "default").
+		WillReturnRows(sqlmock.NewRows(testCampaignColumns).AddRow(3, "Spring sale", nil, nil, StatusPaused, created))
+	if w := serve(r, http.MethodPut, "/campaigns/3", strings.NewReader(`{"name": "Spring sale", "status": "paused"}`)); w.Code != http.StatusOK {
+		t.Fatalf("PUT /campaigns/3 = %d %s, want 200", w.Code, w.Body)
+	}
+
+	// The snapshot is reloaded at once, and activeCondition leaves out the ads of the paused
+	// campaign
+	expectServableAds(mock)
+	if w := serve(r, http.MethodGet, "/ads/serve", nil); w.Code != http.StatusNoContent {
+		t.Errorf("GET /ads/serve after pausing = %d %s, want 204", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,112 @@
+package campaign
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/internal/config"
+	"ad_service/pkg/cache"
+	"ad_service/pkg/middleware"
+	"ad_service/pkg/tenant"
+	"context"
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// testAdColumns are the columns of a servable ad row, keywords first like the ad repository
+// selects them
+var testAdColumns = []string{
+	"keywords", "id", "public_id", "slug", "title", "description", "price", "created_at", "is_active", "external_ref",
+	"category", "expires_at", "weight", "status", "status_reason", "owner_id", "renewed_at", "renewal_count",
+	"renewal_window_start", "archived_at", "favorites_count", "campaign_id", "latitude", "longitude",
+}
+
+// testCampaignColumns are the columns of campaignColumns
+var testCampaignColumns = []string{"id", "name", "starts_at", "ends_at", "status", "created_at"}
+
+// newTestRouter returns the campaign routes and GET /ads/serve on a mocked database and an
+// in-memory cache, wired like cmd/app. The mock must have met all its expectations when the test
+// ends.
+func newTestRouter(t *testing.T) (*gin.Engine, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := cache.NewMemoryCache(time.Minute)
+	t.Cleanup(func() {
+		memory.Close()
+		db.Close()
+	})
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	ads := &ad.AdService{
+		Repo:  &ad.Repository{DB: db},
+		Cache: cache.NewTenantCache(memory),
+		TTL:   config.NewReloadable(config.CacheConfig{AdTTL: 5 * time.Minute, ListTTL: 30 * time.Second, CountTTL: time.Minute, NegativeTTL: 30 * time.Second}),
+		Rules: config.NewReloadable(config.AdsConfig{}),
+	}
+	h := &Handler{Service: &CampaignService{Repo: &Repository{DB: db}, Ads: ads}}
+	adHandler := &ad.Handler{Service: ads}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.Tenant(config.TenancyConfig{}), middleware.Identity("X-User-Id", "X-User-Role", "admin"))
+	r.PUT("/campaigns/:id", h.UpdateCampaign)
+	r.DELETE("/campaigns/:id", h.DeleteCampaign)
+	r.GET("/ads/serve", adHandler.ServeAd)
+	return r, mock
+}
+
+// serve sends a request to r
+func serve(r http.Handler, method, target string, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// testCtx is a context of the default tenant
+func testCtx() context.Context {
+	return tenant.With(context.Background(), tenant.Default)
+}
+
+// servableAdRow returns the row of an approved, active ad in campaignID, which is nil for an ad
+// outside any campaign
+func servableAdRow(id int64, campaignID driver.Value) []driver.Value {
+	created := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	return []driver.Value{
+		nil, id, nil, nil, "Bike", "Description of Bike", "10.00", created, true,
+		nil, "", nil, int64(ad.DefaultAdWeight), ad.StatusApproved, nil,
+		nil, created, int64(0), nil, nil,
+		int64(0), campaignID, nil, nil,
+	}
+}
+
+// expectServableAds expects GetServableAds to load rows, restricted to the ads of active
+// campaigns, followed by their variants and translations
+func expectServableAds(mock sqlmock.Sqlmock, rows ...[]driver.Value) {
+	result := sqlmock.NewRows(testAdColumns)
+	for _, row := range rows {
+		result.AddRow(row...)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("AND (campaign_id IS NULL OR campaign_id IN (SELECT id FROM campaigns WHERE status = 'active' ")).
+		WithArgs("default").WillReturnRows(result)
+	if len(rows) == 0 {
+		return
+	}
+	mock.ExpectQuery("FROM ad_variants").WillReturnRows(sqlmock.NewRows([]string{"ad_id", "variant_key", "title", "description", "weight", "impressions", "clicks"}))
+	mock.ExpectQuery("FROM ad_translations").WillReturnRows(sqlmock.NewRows([]string{"ad_id", "locale", "title", "description"}))
+}
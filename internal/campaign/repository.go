@@ -0,0 +1,312 @@
+/*
+This file interacts with the database for campaigns, which group ads under shared metadata.
+*/
+package campaign
+
+import (
+	"ad_service/internal/apperr"
+	"ad_service/pkg/metrics"
+	"ad_service/pkg/tenant"
+	"ad_service/pkg/timestamp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Campaign statuses; ads of a paused campaign are not served
+const (
+	StatusActive = "active"
+	StatusPaused = "paused"
+)
+
+// Campaign groups ads of one advertiser push. Its ads are only served while it is active and
+// within its optional date range.
+type Campaign struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	StartsAt  *time.Time `json:"starts_at,omitempty"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type Repository struct {
+	DB *sql.DB
+}
+
+// ErrCampaignNotFound is returned when a campaign doesn't exist
+var ErrCampaignNotFound = apperr.New(apperr.ErrNotFound, "Campaign not found")
+
+// ErrCampaignInUse is returned when deleting a campaign that ads still reference
+var ErrCampaignInUse = apperr.New(apperr.ErrConflict, "Campaign is referenced by ads")
+
+// observeQuery records the duration of a repository method with its outcome and turns its error
+// into a domain error, like the ad repository
+func observeQuery(method string, start time.Time, err *error, ctx context.Context) {
+	*err = apperr.FromDB(*err, ErrCampaignNotFound)
+	outcome := "ok"
+	switch {
+	case errors.Is(*err, apperr.ErrNotFound):
+		outcome = "not_found"
+	case *err != nil:
+		outcome = "error"
+	}
+	metrics.ObserveWithTrace(metrics.DBQueryDuration.WithLabelValues(method, outcome), time.Since(start).Seconds(), ctx)
+}
+
+// tenantCondition restricts a query on campaigns or ads to one tenant, like in the ad repository
+const tenantCondition = "tenant_id = ?"
+
+// campaignColumns is the column list selected for a full Campaign, in the order expected by scanCampaign
+const campaignColumns = "id, name, starts_at, ends_at, status, created_at"
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanCampaign reads a row selected with campaignColumns into the given Campaign
+func scanCampaign(row rowScanner, campaign *Campaign) error {
+	err := row.Scan(&campaign.ID, &campaign.Name, &campaign.StartsAt, &campaign.EndsAt, &campaign.Status, &campaign.CreatedAt)
+	campaign.StartsAt, campaign.EndsAt, campaign.CreatedAt = timestamp.UTC(campaign.StartsAt), timestamp.UTC(campaign.EndsAt), campaign.CreatedAt.UTC()
+	return err
+}
+
+// AddCampaign adds a new campaign, with tracing
+func (r *Repository) AddCampaign(campaign *Campaign, ctx context.Context) (err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "AddCampaignRepository")
+	defer span.End()
+	defer observeQuery("add_campaign", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := "INSERT INTO campaigns (tenant_id, name, starts_at, ends_at, status) VALUES (?, ?, ?, ?, ?)"
+	result, err := r.DB.ExecContext(ctx, query, tenantID, campaign.Name, campaign.StartsAt, campaign.EndsAt, campaign.Status)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to insert campaign")
+		return fmt.Errorf("could not insert campaign: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve last insert ID")
+		return fmt.Errorf("could not retrieve last insert ID: %w", err)
+	}
+
+	// Read back the stored row for created_at
+	if err := scanCampaign(r.DB.QueryRowContext(ctx, "SELECT "+campaignColumns+" FROM campaigns WHERE id = ?", id), campaign); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve created campaign")
+		return fmt.Errorf("could not retrieve created campaign: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("campaign_id", campaign.ID), attribute.String("status", "success"))
+	return nil
+}
+
+// GetCampaigns lists the tenant's campaigns, newest first, with tracing
+func (r *Repository) GetCampaigns(page, limit int, ctx context.Context) (_ []Campaign, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "GetCampaignsRepository")
+	defer span.End()
+	defer observeQuery("get_campaigns", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT " + campaignColumns + " FROM campaigns WHERE " + tenantCondition + " ORDER BY id DESC LIMIT ? OFFSET ?"
+	rows, err := r.DB.QueryContext(ctx, query, tenantID, limit, (page-1)*limit)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve campaigns")
+		return nil, err
+	}
+	defer rows.Close()
+
+	campaigns := []Campaign{}
+	for rows.Next() {
+		var campaign Campaign
+		if err := scanCampaign(rows, &campaign); err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		campaigns = append(campaigns, campaign)
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve campaigns")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("campaigns_count", len(campaigns)), attribute.String("status", "success"))
+	return campaigns, nil
+}
+
+// GetCampaignByID fetches a campaign by its ID, with tracing
+func (r *Repository) GetCampaignByID(id int, ctx context.Context) (_ *Campaign, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "GetCampaignByIDRepository")
+	defer span.End()
+	defer observeQuery("get_campaign_by_id", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var campaign Campaign
+	err = scanCampaign(r.DB.QueryRowContext(ctx, "SELECT "+campaignColumns+" FROM campaigns WHERE id = ? AND "+tenantCondition, id, tenantID), &campaign)
+	if errors.Is(err, sql.ErrNoRows) {
+		span.SetStatus(codes.Error, "Campaign not found")
+		return nil, ErrCampaignNotFound
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to query campaign")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("campaign_id", id), attribute.String("status", "success"))
+	return &campaign, nil
+}
+
+// UpdateCampaign replaces the name, dates and status of a campaign, with tracing
+func (r *Repository) UpdateCampaign(id int, campaign *Campaign, ctx context.Context) (err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "UpdateCampaignRepository")
+	defer span.End()
+	defer observeQuery("update_campaign", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE campaigns SET name = ?, starts_at = ?, ends_at = ?, status = ? WHERE id = ? AND " + tenantCondition
+	if _, err := r.DB.ExecContext(ctx, query, campaign.Name, campaign.StartsAt, campaign.EndsAt, campaign.Status, id, tenantID); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to update campaign")
+		return fmt.Errorf("could not update campaign: %w", err)
+	}
+
+	// MySQL reports no affected rows for an unchanged row too, so read back to tell the two apart
+	if err := scanCampaign(r.DB.QueryRowContext(ctx, "SELECT "+campaignColumns+" FROM campaigns WHERE id = ? AND "+tenantCondition, id, tenantID), campaign); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			span.SetStatus(codes.Error, "Campaign not found")
+			return ErrCampaignNotFound
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve updated campaign")
+		return fmt.Errorf("could not retrieve updated campaign: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("campaign_id", id), attribute.String("status", "updated"))
+	return nil
+}
+
+// DeleteCampaign deletes a campaign, with tracing. When ads reference it the delete fails with
+// ErrCampaignInUse, unless detach is set, in which case the ads are taken out of the campaign
+// in the same transaction. It returns the IDs of the detached ads.
+func (r *Repository) DeleteCampaign(id int, detach bool, ctx context.Context) (_ []int64, err error) {
+	tracer := otel.Tracer("ad-service.repository")
+	ctx, span := tracer.Start(ctx, "DeleteCampaignRepository")
+	defer span.End()
+	defer observeQuery("delete_campaign", time.Now(), &err, ctx)
+
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to begin transaction")
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	// Lock the referencing ads so none is moved out of or into the campaign in the meantime. Ads
+	// only reference campaigns of their own tenant, so another tenant's campaign has none here
+	// and is not found below.
+	adIDs, err := referencingAds(tx, tenantID, id, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to list referencing ads")
+		return nil, err
+	}
+	if len(adIDs) > 0 {
+		if !detach {
+			span.RecordError(ErrCampaignInUse)
+			span.SetStatus(codes.Error, "Campaign in use")
+			return nil, ErrCampaignInUse
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE ads SET campaign_id = NULL WHERE campaign_id = ? AND "+tenantCondition, id, tenantID); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to detach ads")
+			return nil, fmt.Errorf("could not detach ads: %w", err)
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM campaigns WHERE id = ? AND "+tenantCondition, id, tenantID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to delete campaign")
+		return nil, fmt.Errorf("could not delete campaign: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("could not retrieve affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		span.RecordError(ErrCampaignNotFound)
+		span.SetStatus(codes.Error, "Campaign not found")
+		return nil, ErrCampaignNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to commit transaction")
+		return nil, fmt.Errorf("could not commit campaign delete: %w", err)
+	}
+
+	span.SetAttributes(attribute.Int("campaign_id", id), attribute.Int("detached_ads", len(adIDs)), attribute.String("status", "deleted"))
+	return adIDs, nil
+}
+
+// referencingAds locks and returns the IDs of the tenant's ads in a campaign
+func referencingAds(tx *sql.Tx, tenantID string, campaignID int, ctx context.Context) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM ads WHERE campaign_id = ? AND "+tenantCondition+" FOR UPDATE", campaignID, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list referencing ads: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("could not scan ad ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not list referencing ads: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,112 @@
+package campaign
+
+import (
+	"ad_service/pkg/tenant"
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newTestRepository returns a repository on a mocked database, which must have met all its
+// expectations when the test ends
+func newTestRepository(t *testing.T) (*Repository, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return &Repository{DB: db}, mock
+}
+
+// expectReferencingAds expects the referencing ads of campaign 3 to be locked, returning ids
+func expectReferencingAds(mock sqlmock.Sqlmock, ids ...int64) {
+	rows := sqlmock.NewRows([]string{"id"})
+	for _, id := range ids {
+		rows.AddRow(id)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM ads WHERE campaign_id = ? AND tenant_id = ? FOR UPDATE")).WithArgs(3, "default").WillReturnRows(rows)
+}
+
+func TestDeleteCampaign(t *testing.T) {
+	tests := []struct {
+		name     string
+		detach   bool
+		expect   func(mock sqlmock.Sqlmock)
+		detached []int64
+		err      error
+	}{
+		{
+			name: "unreferenced",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectReferencingAds(mock)
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM campaigns WHERE id = ? AND tenant_id = ?")).WithArgs(3, "default").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			detached: []int64{},
+		},
+		{
+			name: "blocked by its ads",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectReferencingAds(mock, 7, 8)
+				mock.ExpectRollback()
+			},
+			err: ErrCampaignInUse,
+		},
+		{
+			name:   "detaching its ads",
+			detach: true,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectReferencingAds(mock, 7, 8)
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE ads SET campaign_id = NULL WHERE campaign_id = ? AND tenant_id = ?")).WithArgs(3, "default").WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM campaigns WHERE id = ? AND tenant_id = ?")).WithArgs(3, "default").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			detached: []int64{7, 8},
+		},
+		{
+			name:   "not found",
+			detach: true,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectReferencingAds(mock)
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM campaigns WHERE id = ? AND tenant_id = ?")).WithArgs(3, "default").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+			},
+			err: ErrCampaignNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newTestRepository(t)
+			tt.expect(mock)
+
+			detached, err := repo.DeleteCampaign(3, tt.detach, testCtx())
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("DeleteCampaign error = %v, want %v", err, tt.err)
+			}
+			if !slices.Equal(detached, tt.detached) {
+				t.Errorf("detached = %v, want %v", detached, tt.detached)
+			}
+		})
+	}
+}
+
+func TestDeleteCampaignRequiresTenant(t *testing.T) {
+	repo, _ := newTestRepository(t)
+	if _, err := repo.DeleteCampaign(3, true, context.Background()); !errors.Is(err, tenant.ErrMissing) {
+		t.Errorf("DeleteCampaign without a tenant = %v, want %v", err, tenant.ErrMissing)
+	}
+}
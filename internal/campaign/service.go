@@ -0,0 +1,133 @@
+/*
+This file holds the business logic for campaigns and keeps the ad caches in step with them,
+since a campaign's status and dates decide whether its ads are served.
+*/
+package campaign
+
+import (
+	"ad_service/internal/ad"
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+type CampaignService struct {
+	Repo *Repository
+	Ads  *ad.AdService // lists a campaign's ads and drops the serving caches after changes
+}
+
+// AddCampaign creates a campaign, with tracing
+func (s *CampaignService) AddCampaign(campaign *Campaign, ctx context.Context) error {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "AddCampaignService")
+	defer span.End()
+
+	if err := s.Repo.AddCampaign(campaign, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to add campaign")
+		return err
+	}
+
+	span.SetAttributes(attribute.Int("campaign_id", campaign.ID), attribute.String("status", "success"))
+	return nil
+}
+
+// GetCampaigns lists campaigns with pagination, with tracing
+func (s *CampaignService) GetCampaigns(page, limit int, ctx context.Context) ([]Campaign, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "GetCampaignsService")
+	defer span.End()
+
+	campaigns, err := s.Repo.GetCampaigns(page, limit, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve campaigns")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("campaigns_count", len(campaigns)), attribute.String("status", "success"))
+	return campaigns, nil
+}
+
+// GetCampaignByID retrieves a campaign, with tracing
+func (s *CampaignService) GetCampaignByID(id int, ctx context.Context) (*Campaign, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "GetCampaignByIDService")
+	defer span.End()
+
+	campaign, err := s.Repo.GetCampaignByID(id, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve campaign")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("campaign_id", id), attribute.String("status", "success"))
+	return campaign, nil
+}
+
+// UpdateCampaign updates a campaign, with tracing. Pausing, resuming or moving the dates changes
+// which ads are servable, so the serve snapshots are dropped.
+func (s *CampaignService) UpdateCampaign(id int, campaign *Campaign, ctx context.Context) error {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "UpdateCampaignService")
+	defer span.End()
+
+	if err := s.Repo.UpdateCampaign(id, campaign, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to update campaign")
+		return err
+	}
+	s.Ads.InvalidateServing(ctx)
+
+	span.SetAttributes(attribute.Int("campaign_id", id), attribute.String("status", "updated"))
+	return nil
+}
+
+// DeleteCampaign deletes a campaign, detaching its ads when detach is set, with tracing
+func (s *CampaignService) DeleteCampaign(id int, detach bool, ctx context.Context) error {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "DeleteCampaignService")
+	defer span.End()
+
+	detached, err := s.Repo.DeleteCampaign(id, detach, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to delete campaign")
+		return err
+	}
+	if len(detached) > 0 {
+		s.Ads.InvalidateAds(detached, ctx)
+	}
+
+	span.SetAttributes(attribute.Int("campaign_id", id), attribute.Int("detached_ads", len(detached)), attribute.String("status", "deleted"))
+	return nil
+}
+
+// GetCampaignAds lists the ads of a campaign through the ad service, so the same visibility
+// rules and caching apply as for GET /ads, with tracing
+func (s *CampaignService) GetCampaignAds(id, page, limit int, filter ad.ListFilter, ctx context.Context) ([]ad.Ad, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "GetCampaignAdsService")
+	defer span.End()
+
+	// A missing campaign is a 404 rather than an empty page
+	if _, err := s.Repo.GetCampaignByID(id, ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve campaign")
+		return nil, err
+	}
+
+	filter.CampaignID = id
+	ads, err := s.Ads.GetAllAds(ad.ListQuery{Filter: filter, SortBy: "renewed_at", Order: "desc", Page: page, Limit: limit}, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to retrieve campaign ads")
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("campaign_id", id), attribute.Int("ads_count", len(ads)), attribute.String("status", "success"))
+	return ads, nil
+}
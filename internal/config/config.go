@@ -1,40 +1,331 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	MySQL   MySQLConfig
-	Redis   RedisConfig
-	Server  ServerConfig
-	Tracing TracingConfig
+	MySQL    MySQLConfig
+	Redis    RedisConfig
+	Cache    CacheConfig
+	Server   ServerConfig
+	Log      LogConfig
+	Tracing  TracingConfig
+	Metrics  MetricsConfig
+	Auth     AuthConfig
+	Tenancy  TenancyConfig
+	Ads      AdsConfig
+	Currency CurrencyConfig
+	Sitemap  SitemapConfig
+	Search   SearchConfig
 	// Prometheus PrometheusConfig
 }
 
 type MySQLConfig struct {
-	User     string
-	Password string
-	Host     string
-	Port     string
-	Database string
+	User         string
+	Password     string
+	PasswordFile string // file holding the password, e.g. a mounted secret; wins over Password
+	Host         string
+	Port         string
+	Database     string
+
+	MaxOpenConns    int           // upper bound on open connections
+	MaxIdleConns    int           // connections kept open between bursts
+	ConnMaxLifetime time.Duration // connections are recycled after this, below MySQL's wait_timeout
+
+	// The circuit breaker opens after BreakerThreshold consecutive failed queries, or when
+	// BreakerFailureRate of at least BreakerMinRequests queries in BreakerWindow failed. Queries
+	// then fail fast for BreakerCooldown, after which BreakerProbes queries test MySQL.
+	BreakerThreshold   int
+	BreakerFailureRate float64
+	BreakerMinRequests int
+	BreakerWindow      time.Duration
+	BreakerCooldown    time.Duration
+	BreakerProbes      int
 }
 
 type RedisConfig struct {
-	Host     string
-	Port     string
-	Password string
-	DB       int
+	Host         string
+	Port         string
+	Username     string // Redis 6 ACL user, empty for the default user
+	Password     string
+	PasswordFile string // file holding the password, e.g. a mounted secret; wins over Password
+	DB           int
+	PoolSize     int // connections per node
+	TLS          RedisTLSConfig
+	Sentinel     SentinelConfig
+}
+
+// RedisTLSConfig enables TLS for the Redis connection
+type RedisTLSConfig struct {
+	Enabled            bool
+	CAFile             string // optional CA bundle, the system pool is used when empty
+	CertFile           string // optional client certificate, requires KeyFile
+	KeyFile            string
+	InsecureSkipVerify bool // staging only
+}
+
+// SentinelConfig points the cache at a Redis master discovered through Sentinel.
+// When MasterName is empty the standalone Host and Port are used instead.
+type SentinelConfig struct {
+	MasterName string
+	Addresses  []string
+	Password   string
+}
+
+// Enabled reports whether any sentinel setting is present
+func (s SentinelConfig) Enabled() bool {
+	return s.MasterName != "" || len(s.Addresses) > 0 || s.Password != ""
+}
+
+// CacheConfig selects the cache driver and holds the TTL per entity; a zero TTL disables caching for that entity
+type CacheConfig struct {
+	Driver      string        // redis, memory or none
+	KeyPrefix   string        // namespace for every key, e.g. "adsvc:prod:"; the schema version is appended in code
+	AdTTL       time.Duration // single ads
+	ListTTL     time.Duration // pages of GET /ads
+	CountTTL    time.Duration // aggregated counts such as daily stats
+	NegativeTTL time.Duration // "ad not found" entries
+	LockTimeout time.Duration // upper bound on how long a list rebuild lock is held
+	LockWait    time.Duration // how long other callers wait for a rebuild before querying MySQL themselves
+	WriteMode   string        // write_through caches ads on create/update, invalidate only deletes the entry
+	Required    bool          // refuse to start when the cache can't be reached instead of running without it
+
+	// MutationLockTTL bounds how long a per-ad lock around an update or delete is held, and
+	// MutationLockWait is how long a concurrent write to the same ad waits for it
+	MutationLockTTL  time.Duration
+	MutationLockWait time.Duration
+
+	// BreakerThreshold consecutive Redis failures, or BreakerFailureRate of at least
+	// BreakerMinRequests calls in BreakerWindow, stop all Redis calls for BreakerCooldown, after
+	// which BreakerProbes calls test Redis
+	BreakerThreshold   int
+	BreakerFailureRate float64
+	BreakerMinRequests int
+	BreakerWindow      time.Duration
+	BreakerCooldown    time.Duration
+	BreakerProbes      int
+
+	// CompressionThreshold is the size in bytes above which cached values are gzip-compressed, 0 disables compression
+	CompressionThreshold int
+
+	// PurgeMaxDuration bounds how long one POST /admin/cache/purge keeps scanning the keyspace
+	PurgeMaxDuration time.Duration
+
+	// HotKeys is how many of the most read ads each replica keeps cached, 0 disables the
+	// refresher. An ad read HotThreshold times in one HotWindow becomes hot and is cached again
+	// HotRefreshLead before its entry could expire.
+	HotKeys        int
+	HotThreshold   int
+	HotWindow      time.Duration
+	HotRefreshLead time.Duration
 }
 
 type ServerConfig struct {
-	Port string
+	Port         string // public API
+	InternalPort string // /metrics, /healthz, /readyz and pprof, not to be exposed by the load balancer
+	Pprof        bool   // serve /debug/pprof on the internal port
+	// LegacyResponses keeps the pre-envelope response shapes on the unversioned routes while
+	// clients move to /v1, which always uses the envelope
+	LegacyResponses bool
+
+	// MaxPageSize caps the limit of list endpoints and the number of IDs a request may pass;
+	// PageSizeMode clamp serves larger pages at the cap, reject answers them with 400
+	MaxPageSize  int
+	PageSizeMode string
+
+	ShutdownTimeout time.Duration // on SIGTERM, shared by draining requests and the shutdown hooks
+}
+
+// LogConfig controls the structured logs written to stderr
+type LogConfig struct {
+	Level string // debug, info, warn or error; lines below it are dropped
+}
+
+// SlogLevel returns the configured level, info when it can't be parsed
+func (c LogConfig) SlogLevel() slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
 }
 
 type TracingConfig struct {
-	JaegerEndpoint string
+	Environment     string            // deployment.environment resource attribute, e.g. production
+	Exporter        string            // otlp, stdout or none; defaults to none without an endpoint
+	Protocol        string            // OTLP transport, http or grpc
+	Endpoint        string            // collector host:port, tracing is disabled when empty
+	JaegerEndpoint  string            // deprecated, used when Endpoint is empty
+	TLS             TracingTLSConfig  // plaintext unless enabled
+	Headers         map[string]string // sent with every export, values may reference ${ENV_VARS}
+	Sampler         string            // always, never or ratio
+	SamplerRatio    float64           // fraction of new traces sampled with the ratio sampler, 0 to 1
+	ExcludePaths    []string          // request paths that get no server span, e.g. /healthz
+	DropRoutes      []string          // matched routes whose server spans are never sampled, * for a prefix
+	Propagators     []string          // tracecontext, baggage, b3 (single header) or b3multi
+	UserIDHeader    string            // header with the end-user ID from the gateway, empty to ignore
+	ShutdownTimeout time.Duration     // upper bound for flushing spans on shutdown
+	BaggageKeys     []string          // baggage members copied onto every span as attributes
+}
+
+// TracingTLSConfig enables TLS for the connection to the collector
+type TracingTLSConfig struct {
+	Enabled            bool
+	CAFile             string // optional CA bundle, the system pool is used when empty
+	InsecureSkipVerify bool   // staging only
+}
+
+// setDefaultExporter picks otlp when an endpoint is configured and none otherwise
+func (c *TracingConfig) setDefaultExporter() {
+	if c.Exporter != "" {
+		return
+	}
+	c.Exporter = "none"
+	if c.ExporterEndpoint() != "" {
+		c.Exporter = "otlp"
+	}
+}
+
+// ExporterEndpoint returns the collector endpoint, falling back to the legacy jaegerEndpoint key
+func (c TracingConfig) ExporterEndpoint() string {
+	if c.Endpoint != "" {
+		return c.Endpoint
+	}
+	return c.JaegerEndpoint
+}
+
+// MetricsConfig controls the HTTP metrics middleware and the business metrics computed from the database
+type MetricsConfig struct {
+	AdsRefreshInterval time.Duration // how often ads_total is recomputed, 0 disables it
+	ExcludePaths       []string      // request paths not recorded in the HTTP metrics, e.g. /metrics
+	HTTPBuckets        []float64     // buckets of http_request_duration_seconds, Prometheus defaults when empty
+	DBBuckets          []float64     // buckets of db_query_duration_seconds, Prometheus defaults when empty
+	RedisBuckets       []float64     // buckets of redis_operation_duration_seconds, 0.1ms..250ms when empty
+	// LegacyStatusLabels labels HTTP metrics with the status text ("Not Found") instead of the
+	// numeric code and leaves the endpoint empty for unmatched routes. Kept for one release.
+	LegacyStatusLabels bool
+	OTLP               MetricsOTLPConfig // push to a collector, alongside /metrics
+}
+
+// MetricsOTLPConfig pushes the metrics served on /metrics to an OpenTelemetry collector over OTLP
+type MetricsOTLPConfig struct {
+	Enabled  bool
+	Protocol string            // OTLP transport, only http is supported
+	Endpoint string            // collector host:port
+	Interval time.Duration     // how often the metrics are pushed
+	Timeout  time.Duration     // bound for one export, and for the last one on shutdown
+	TLS      TracingTLSConfig  // plaintext unless enabled
+	Headers  map[string]string // sent with every export, values may reference ${ENV_VARS}
+}
+
+// AuthConfig names the headers in which the API gateway forwards the authenticated caller.
+// The service trusts them as-is, so it must only be reachable through the gateway.
+type AuthConfig struct {
+	UserIDHeader string // end-user ID, owners of ads are identified by it
+	RoleHeader   string // role of the end user
+	AdminRole    string // value of RoleHeader that grants the moderation endpoints
+}
+
+// TenancyConfig serves several white-label marketplaces from one deployment. Each request is
+// resolved to a tenant, and every query and cache key is scoped to it. While disabled, every
+// request belongs to the "default" tenant.
+type TenancyConfig struct {
+	Enabled      bool
+	Header       string            // tenant ID forwarded by the API gateway
+	APIKeyHeader string            // API key identifying the tenant, checked before Header
+	Tenants      map[string]string // tenant ID to its API key; a tenant without a key is selected by Header alone
+	// MissingStatus answers requests whose tenant can't be resolved: 400 or 401
+	MissingStatus int
+}
+
+// AdsConfig holds the rules the service applies to ads
+type AdsConfig struct {
+	RenewalExtension time.Duration // how far a renewal pushes expires_at past now
+	RenewalsPerWeek  int           // renewals allowed per ad in a 7-day window
+
+	SimilarExcludeOwner bool // leave the source ad owner's other ads out of GET /ads/:id/similar
+
+	ReportFlagThreshold int // an approved ad with more open reports than this goes back to pending
+	ReportsPerHour      int // reports a user may submit per hour
+
+	Locales []string // locales ads may carry translations for, e.g. en and ru
+
+	ExposeNumericIDs bool // include the sequential id in ad responses; public_id is always included
+
+	ExpireInterval  time.Duration // how often expired ads are deactivated; 0 disables the job
+	ExpireBatchSize int           // ads deactivated per transaction by the job
+
+	MaxActivePerOwner  int // ads an owner may have that aren't rejected, archived or expired; 0 is unlimited
+	MaxCreationsPerDay int // ads an owner may create in a rolling 24 hours; 0 is unlimited
+
+	SearchEngine         string // engine of GET /ads?q= when engine= isn't given: like or fulltext
+	SearchMinTokenLength int    // shortest word in the FULLTEXT index, MySQL's innodb_ft_min_token_size
+
+	ImpressionWorkers   int // goroutines writing the impressions of served ads
+	ImpressionQueueSize int // impressions waiting for a worker; more are dropped and counted
+}
+
+// CurrencyConfig holds the exchange rates used to show prices in other currencies
+type CurrencyConfig struct {
+	Base       string             // currency every stored price is in
+	Provider   string             // where rates come from; only static for now
+	Rates      map[string]float64 // static rates: units of each currency per unit of Base
+	RateTTL    time.Duration      // how long fetched rates are cached in Redis
+	MaxRateAge time.Duration      // rates older than this are stale and not used
+}
+
+// normalizeCodes upper-cases the currency codes, since viper lower-cases map keys
+func (c *CurrencyConfig) normalizeCodes() {
+	c.Base = strings.ToUpper(c.Base)
+	rates := make(map[string]float64, len(c.Rates))
+	for code, rate := range c.Rates {
+		rates[strings.ToUpper(code)] = rate
+	}
+	c.Rates = rates
+}
+
+// SitemapConfig holds the public URLs and limits of GET /sitemap.xml
+type SitemapConfig struct {
+	URL     string        // public URL of /sitemap.xml, used for the pages listed in a sitemap index
+	AdURL   string        // public URL of an ad page, with {id} standing for the ad ID
+	MaxURLs int           // URLs per sitemap; more live ads split it behind an index (sitemaps.org allows 50000)
+	MaxAge  time.Duration // Cache-Control max-age of the responses
+}
+
+// Search engines of GET /ads/search
+const (
+	SearchEngineMySQL         = "mysql"
+	SearchEngineElasticsearch = "elasticsearch"
+)
+
+// SearchConfig selects the engine of GET /ads/search and, for Elasticsearch or OpenSearch, the
+// cluster, the index and the indexer feeding it from the search outbox
+type SearchConfig struct {
+	Engine       string // mysql or elasticsearch
+	URL          string // base URL of the cluster, e.g. http://localhost:9200
+	Index        string // index holding the ad documents of every tenant
+	Username     string // basic auth user, empty for none
+	Password     string
+	PasswordFile string        // file holding the password, e.g. a mounted secret; wins over Password
+	Timeout      time.Duration // per request to the cluster; a slower search falls back to MySQL
+	Analyzer     string        // analyzer of the title and description fields, e.g. standard or english
+
+	IndexInterval  time.Duration // how often the indexer drains the search outbox
+	IndexBatchSize int           // outbox rows handled per indexer round
+}
+
+// Elasticsearch reports whether GET /ads/search is answered by Elasticsearch
+func (c SearchConfig) Elasticsearch() bool {
+	return c.Engine == SearchEngineElasticsearch
 }
 
 // type PrometheusConfig struct {
@@ -42,26 +333,55 @@ type TracingConfig struct {
 // 	Port            int
 // }
 
-// LoadConfig reads configuration from file or environment variables
-func LoadConfig() (*Config, error) {
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
+// ConfigEnvVar points at the config file when no --config flag is given
+const ConfigEnvVar = EnvPrefix + "_CONFIG"
 
-	// Read the config file
+// searchPaths are tried in order for config.yaml when no file is given explicitly
+var searchPaths = []string{".", "/etc/ad-service", "$HOME/.ad-service"}
+
+// LoadConfig reads configuration from file or environment variables. It is called once by main,
+// which passes the sections on to the constructors that need them. path is the file from the
+// --config flag; when empty ADSVC_CONFIG is used, and then config.yaml is searched for in the
+// working directory, /etc/ad-service and $HOME/.ad-service. A file given explicitly must exist,
+// while a missing searched-for file just leaves the defaults and the environment.
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		path = os.Getenv(ConfigEnvVar)
+	}
+	if path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", path, err)
+		}
+		viper.SetConfigFile(path)
+	} else {
+		viper.SetConfigName("config")
+		viper.SetConfigType("yaml")
+		for _, dir := range searchPaths {
+			viper.AddConfigPath(os.ExpandEnv(dir))
+		}
+	}
+
+	// Defaults for every value, so the service starts with an empty or missing file
+	setDefaults()
+
+	// Environment variables override the file, which overrides the defaults
+	bindEnv()
+
+	// Read the config file; it is optional when everything comes from the environment
 	err := viper.ReadInConfig()
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
-		return nil, err
+		if !errors.As(err, new(viper.ConfigFileNotFoundError)) {
+			return nil, fmt.Errorf("could not read config file: %w", err)
+		}
+		log.Printf("No config file found in %v, using defaults and %s_* environment variables", searchPaths, EnvPrefix)
+	} else {
+		log.Printf("Using config file %s", viper.ConfigFileUsed())
 	}
+	logDefaultedKeys()
 
-	// Unmarshal config into the Config struct
-	var config Config
-	err = viper.Unmarshal(&config)
+	config, err := load()
 	if err != nil {
-		log.Fatalf("Failed to unmarshal configuration: %v", err)
 		return nil, err
 	}
-
 	return &config, nil
 }
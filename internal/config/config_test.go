@@ -0,0 +1,281 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// loadTestConfig runs LoadConfig on a file holding yaml, with a fresh viper that is reset
+// again when the test ends
+func loadTestConfig(t *testing.T, yaml string) (*Config, error) {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(path)
+}
+
+func TestLoadConfigEnvOverrides(t *testing.T) {
+	t.Setenv("ADSVC_MYSQL_HOST", "mysql.prod.svc")
+	t.Setenv("ADSVC_REDIS_PASSWORD", "s3cret")
+	t.Setenv("ADSVC_CACHE_ADTTL", "2m")
+	t.Setenv("ADSVC_TRACING_SAMPLERRATIO", "0.25")
+	cfg, err := loadTestConfig(t, `
+mysql:
+  host: mysql.local
+  user: ads
+redis:
+  password: from-file
+server:
+  port: "8081"
+`)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	tests := []struct {
+		key       string
+		got, want any
+	}{
+		// The environment wins over the file
+		{"mysql.host", cfg.MySQL.Host, "mysql.prod.svc"},
+		{"redis.password", cfg.Redis.Password, "s3cret"},
+		// Keys missing from the file are read from the environment too, parsed to their type
+		{"cache.adTTL", cfg.Cache.AdTTL, 2 * time.Minute},
+		{"tracing.samplerRatio", cfg.Tracing.SamplerRatio, 0.25},
+		// The file wins over the defaults
+		{"mysql.user", cfg.MySQL.User, "ads"},
+		{"server.port", cfg.Server.Port, "8081"},
+		{"redis.port", cfg.Redis.Port, "6379"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.key, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigWithoutFile(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	// No config.yaml in any search path
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	t.Setenv("HOME", dir)
+	t.Setenv("ADSVC_SERVER_PORT", "9000")
+	t.Setenv("ADSVC_MYSQL_HOST", "mysql.prod.svc")
+
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig without a file: %v", err)
+	}
+	if cfg.Server.Port != "9000" || cfg.MySQL.Host != "mysql.prod.svc" || cfg.Redis.Host != "localhost" {
+		t.Errorf("config = server.port %s, mysql.host %s, redis.host %s, want the environment over the defaults", cfg.Server.Port, cfg.MySQL.Host, cfg.Redis.Host)
+	}
+
+	// A file named explicitly, here through ADSVC_CONFIG, has to exist
+	viper.Reset()
+	t.Setenv(ConfigEnvVar, filepath.Join(dir, "missing.yaml"))
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("LoadConfig with a missing ADSVC_CONFIG file succeeded")
+	}
+}
+
+// defaultConfig is the configuration loaded from a file without settings
+func defaultConfig() Config {
+	buckets := []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	return Config{
+		MySQL: MySQLConfig{
+			User: "root", Host: "localhost", Port: "3306", Database: "ad_service_db",
+			MaxOpenConns: 25, MaxIdleConns: 25, ConnMaxLifetime: 5 * time.Minute,
+			BreakerThreshold: 10, BreakerFailureRate: 0.5, BreakerMinRequests: 20, BreakerWindow: 10 * time.Second, BreakerCooldown: 5 * time.Second, BreakerProbes: 3,
+		},
+		Redis: RedisConfig{Host: "localhost", Port: "6379", PoolSize: 20, Sentinel: SentinelConfig{Addresses: []string{}}},
+		Cache: CacheConfig{
+			Driver: "redis", KeyPrefix: "adsvc:", WriteMode: "write_through",
+			AdTTL: 5 * time.Minute, ListTTL: 30 * time.Second, CountTTL: time.Minute, NegativeTTL: 30 * time.Second,
+			LockTimeout: 5 * time.Second, LockWait: 500 * time.Millisecond, MutationLockTTL: 5 * time.Second, MutationLockWait: time.Second,
+			BreakerThreshold: 5, BreakerFailureRate: 0.5, BreakerMinRequests: 20, BreakerWindow: 10 * time.Second, BreakerCooldown: 10 * time.Second, BreakerProbes: 1,
+			PurgeMaxDuration: 10 * time.Second, HotKeys: 100, HotThreshold: 100, HotWindow: time.Minute, HotRefreshLead: 20 * time.Second,
+		},
+		Server: ServerConfig{Port: "8080", InternalPort: "9090", LegacyResponses: true, MaxPageSize: 100, PageSizeMode: "clamp", ShutdownTimeout: 15 * time.Second},
+		Log:    LogConfig{Level: "info"},
+		Tracing: TracingConfig{
+			Environment: "development", Exporter: "none", Protocol: "http", Sampler: "always", SamplerRatio: 1,
+			ExcludePaths: []string{"/metrics", "/healthz"}, DropRoutes: []string{"/metrics", "/healthz", "/readyz", "/version"},
+			Propagators: []string{"tracecontext", "baggage"}, UserIDHeader: "X-User-Id", ShutdownTimeout: 5 * time.Second, BaggageKeys: []string{"enduser.id"},
+		},
+		Metrics: MetricsConfig{
+			AdsRefreshInterval: 30 * time.Second, ExcludePaths: []string{"/metrics", "/healthz"},
+			HTTPBuckets: buckets, DBBuckets: buckets, RedisBuckets: []float64{0.0001, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25},
+			OTLP: MetricsOTLPConfig{Protocol: "http", Interval: 30 * time.Second, Timeout: 10 * time.Second},
+		},
+		Auth:    AuthConfig{UserIDHeader: "X-User-Id", RoleHeader: "X-User-Role", AdminRole: "admin"},
+		Tenancy: TenancyConfig{Header: "X-Tenant-Id", APIKeyHeader: "X-Api-Key", Tenants: map[string]string{}, MissingStatus: 400},
+		Ads: AdsConfig{
+			RenewalExtension: 30 * 24 * time.Hour, RenewalsPerWeek: 3, SimilarExcludeOwner: true, ReportFlagThreshold: 3, ReportsPerHour: 10,
+			Locales: []string{"en", "ru"}, ExposeNumericIDs: true, ExpireInterval: time.Minute, ExpireBatchSize: 500,
+			MaxActivePerOwner: 50, MaxCreationsPerDay: 20, SearchEngine: "like", SearchMinTokenLength: 3, ImpressionWorkers: 4, ImpressionQueueSize: 1000,
+		},
+		Currency: CurrencyConfig{Base: "USD", Provider: "static", Rates: map[string]float64{}, RateTTL: time.Hour, MaxRateAge: 24 * time.Hour},
+		Sitemap:  SitemapConfig{URL: "http://localhost:8080/sitemap.xml", AdURL: "http://localhost:8080/ads/{id}", MaxURLs: 50000, MaxAge: time.Hour},
+		Search: SearchConfig{
+			Engine: "mysql", URL: "http://localhost:9200", Index: "ads", Timeout: 2 * time.Second, Analyzer: "standard",
+			IndexInterval: 5 * time.Second, IndexBatchSize: 500,
+		},
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	var logs bytes.Buffer
+	previous := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(previous) })
+
+	// A file with a single key starts the service with the defaults for everything else
+	cfg, err := loadTestConfig(t, "server:\n  port: \"8080\"\n")
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	want := defaultConfig()
+	if !reflect.DeepEqual(*cfg, want) {
+		got, wanted := flattenValues(reflect.ValueOf(*cfg), ""), flattenValues(reflect.ValueOf(want), "")
+		for key, value := range wanted {
+			if got[key] != value {
+				t.Errorf("%s = %s, want %s", key, got[key], value)
+			}
+		}
+		t.Errorf("default config differs from the golden one:\n got %+v\nwant %+v", *cfg, want)
+	}
+
+	// Only the keys the file left out are reported as defaulted
+	_, listed, _ := strings.Cut(strings.TrimSpace(logs.String()), "Configuration defaults used for: ")
+	defaulted := strings.Split(strings.SplitN(listed, "\n", 2)[0], ", ")
+	if !slices.Contains(defaulted, "mysql.host") || slices.Contains(defaulted, "server.port") {
+		t.Errorf("defaulted keys = %v, want every key but server.port", defaulted)
+	}
+}
+
+// writeConfig writes a config.yaml setting server.port to port in dir
+func writeConfig(t *testing.T, dir, port string) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("server:\n  port: \""+port+"\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFileResolution(t *testing.T) {
+	root := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	work, home := filepath.Join(root, "work"), filepath.Join(root, "home")
+	flagFile := writeConfig(t, filepath.Join(root, "flag"), "8001")
+	envFile := writeConfig(t, filepath.Join(root, "env"), "8002")
+	workFile := writeConfig(t, work, "8003")
+	homeFile := writeConfig(t, filepath.Join(home, ".ad-service"), "8004")
+	if err := os.Chdir(work); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+
+	tests := []struct {
+		name     string
+		flag     string // the --config flag
+		env      string // ADSVC_CONFIG
+		removed  string // file deleted before loading
+		wantFile string
+		wantPort string
+	}{
+		{"flag wins over the environment", flagFile, envFile, "", flagFile, "8001"},
+		{"environment without a flag", "", envFile, "", envFile, "8002"},
+		{"working directory first", "", "", "", workFile, "8003"},
+		{"then the home directory", "", "", workFile, homeFile, "8004"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+			t.Setenv(ConfigEnvVar, tt.env)
+			if tt.removed != "" {
+				os.Remove(tt.removed)
+			}
+			cfg, err := LoadConfig(tt.flag)
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if viper.ConfigFileUsed() != tt.wantFile {
+				t.Errorf("config file used = %s, want %s", viper.ConfigFileUsed(), tt.wantFile)
+			}
+			if cfg.Server.Port != tt.wantPort {
+				t.Errorf("server.port = %s, want %s from %s", cfg.Server.Port, tt.wantPort, tt.wantFile)
+			}
+		})
+	}
+
+	// An explicit file that doesn't exist is an error naming it, not a silent fallback
+	viper.Reset()
+	missing := filepath.Join(root, "missing.yaml")
+	if _, err := LoadConfig(missing); err == nil || !strings.Contains(err.Error(), "config file "+missing) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadConfig(%s) = %v, want an error naming the file and wrapping fs.ErrNotExist", missing, err)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		yaml  string
+		want  string
+		cause func(error) bool // whether the error wraps the expected cause
+	}{
+		{"malformed YAML", "mysql:\n  host: [localhost\n", "could not read config file", func(err error) bool {
+			return errors.As(err, new(viper.ConfigParseError))
+		}},
+		{"wrong type", "mysql:\n  maxOpenConns: lots\n", "could not unmarshal configuration", func(err error) bool {
+			return errors.Unwrap(err) != nil
+		}},
+		{"invalid value", "cache:\n  driver: memcached\n", "cache.driver must be one of redis, memory or none", func(err error) bool {
+			return errors.As(err, new(*ValidationError))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The error is returned for main to report instead of exiting here
+			cfg, err := loadTestConfig(t, tt.yaml)
+			if cfg != nil || err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadConfig = %v, %v, want an error containing %q", cfg, err, tt.want)
+			}
+			if err != nil && !tt.cause(err) {
+				t.Errorf("LoadConfig = %v, want the cause wrapped", err)
+			}
+		})
+	}
+}
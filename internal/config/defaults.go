@@ -0,0 +1,180 @@
+package config
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// setDefaults registers a default for every configuration key. Tracing has no endpoint by
+// default, so the exporter resolves to none.
+func setDefaults() {
+	viper.SetDefault("mysql.user", "root")
+	viper.SetDefault("mysql.password", "")
+	viper.SetDefault("mysql.passwordFile", "")
+	viper.SetDefault("mysql.host", "localhost")
+	viper.SetDefault("mysql.port", "3306")
+	viper.SetDefault("mysql.database", "ad_service_db")
+	viper.SetDefault("mysql.maxOpenConns", 25)
+	viper.SetDefault("mysql.maxIdleConns", 25)
+	viper.SetDefault("mysql.connMaxLifetime", 5*time.Minute)
+	viper.SetDefault("mysql.breakerThreshold", 10)
+	viper.SetDefault("mysql.breakerFailureRate", 0.5)
+	viper.SetDefault("mysql.breakerMinRequests", 20)
+	viper.SetDefault("mysql.breakerWindow", 10*time.Second)
+	viper.SetDefault("mysql.breakerCooldown", 5*time.Second)
+	viper.SetDefault("mysql.breakerProbes", 3)
+
+	viper.SetDefault("redis.host", "localhost")
+	viper.SetDefault("redis.port", "6379")
+	viper.SetDefault("redis.username", "")
+	viper.SetDefault("redis.password", "")
+	viper.SetDefault("redis.passwordFile", "")
+	viper.SetDefault("redis.db", 0)
+	viper.SetDefault("redis.poolSize", 20)
+	viper.SetDefault("redis.tls.enabled", false)
+	viper.SetDefault("redis.tls.caFile", "")
+	viper.SetDefault("redis.tls.certFile", "")
+	viper.SetDefault("redis.tls.keyFile", "")
+	viper.SetDefault("redis.tls.insecureSkipVerify", false)
+	viper.SetDefault("redis.sentinel.masterName", "")
+	viper.SetDefault("redis.sentinel.addresses", []string{})
+	viper.SetDefault("redis.sentinel.password", "")
+
+	viper.SetDefault("cache.driver", "redis")
+	viper.SetDefault("cache.writeMode", "write_through")
+	viper.SetDefault("cache.keyPrefix", "adsvc:")
+	viper.SetDefault("cache.adTTL", 5*time.Minute)
+	viper.SetDefault("cache.listTTL", 30*time.Second)
+	viper.SetDefault("cache.countTTL", time.Minute)
+	viper.SetDefault("cache.negativeTTL", 30*time.Second)
+	viper.SetDefault("cache.lockTimeout", 5*time.Second)
+	viper.SetDefault("cache.lockWait", 500*time.Millisecond)
+	viper.SetDefault("cache.required", false)
+	viper.SetDefault("cache.mutationLockTTL", 5*time.Second)
+	viper.SetDefault("cache.mutationLockWait", time.Second)
+	viper.SetDefault("cache.breakerThreshold", 5)
+	viper.SetDefault("cache.breakerFailureRate", 0.5)
+	viper.SetDefault("cache.breakerMinRequests", 20)
+	viper.SetDefault("cache.breakerWindow", 10*time.Second)
+	viper.SetDefault("cache.breakerCooldown", 10*time.Second)
+	viper.SetDefault("cache.breakerProbes", 1)
+	viper.SetDefault("cache.compressionThreshold", 0)
+	viper.SetDefault("cache.purgeMaxDuration", 10*time.Second)
+	viper.SetDefault("cache.hotKeys", 100)
+	viper.SetDefault("cache.hotThreshold", 100)
+	viper.SetDefault("cache.hotWindow", time.Minute)
+	viper.SetDefault("cache.hotRefreshLead", 20*time.Second)
+
+	viper.SetDefault("server.port", "8080")
+	viper.SetDefault("server.internalPort", "9090")
+	viper.SetDefault("server.pprof", false)
+	viper.SetDefault("server.legacyResponses", true)
+	viper.SetDefault("server.maxPageSize", 100)
+	viper.SetDefault("server.pageSizeMode", "clamp")
+	viper.SetDefault("server.shutdownTimeout", 15*time.Second)
+
+	viper.SetDefault("log.level", "info")
+
+	viper.SetDefault("tracing.environment", "development")
+	viper.SetDefault("tracing.exporter", "")
+	viper.SetDefault("tracing.protocol", "http")
+	viper.SetDefault("tracing.endpoint", "")
+	viper.SetDefault("tracing.jaegerEndpoint", "")
+	viper.SetDefault("tracing.tls.enabled", false)
+	viper.SetDefault("tracing.tls.caFile", "")
+	viper.SetDefault("tracing.tls.insecureSkipVerify", false)
+	viper.SetDefault("tracing.sampler", "always")
+	viper.SetDefault("tracing.samplerRatio", 1.0)
+	viper.SetDefault("tracing.excludePaths", []string{"/metrics", "/healthz"})
+	viper.SetDefault("tracing.dropRoutes", []string{"/metrics", "/healthz", "/readyz", "/version"})
+	viper.SetDefault("tracing.propagators", []string{"tracecontext", "baggage"})
+	viper.SetDefault("tracing.userIdHeader", "X-User-Id")
+	viper.SetDefault("tracing.baggageKeys", []string{"enduser.id"})
+	viper.SetDefault("tracing.shutdownTimeout", 5*time.Second)
+
+	viper.SetDefault("metrics.adsRefreshInterval", 30*time.Second)
+	viper.SetDefault("metrics.excludePaths", []string{"/metrics", "/healthz"})
+	viper.SetDefault("metrics.httpBuckets", []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10})
+	viper.SetDefault("metrics.dbBuckets", []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10})
+	viper.SetDefault("metrics.redisBuckets", []float64{0.0001, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25})
+	viper.SetDefault("metrics.legacyStatusLabels", false)
+	viper.SetDefault("metrics.otlp.enabled", false)
+	viper.SetDefault("metrics.otlp.protocol", "http")
+	viper.SetDefault("metrics.otlp.endpoint", "")
+	viper.SetDefault("metrics.otlp.interval", 30*time.Second)
+	viper.SetDefault("metrics.otlp.timeout", 10*time.Second)
+	viper.SetDefault("metrics.otlp.tls.enabled", false)
+	viper.SetDefault("metrics.otlp.tls.caFile", "")
+	viper.SetDefault("metrics.otlp.tls.insecureSkipVerify", false)
+
+	viper.SetDefault("auth.userIdHeader", "X-User-Id")
+	viper.SetDefault("auth.roleHeader", "X-User-Role")
+	viper.SetDefault("auth.adminRole", "admin")
+
+	viper.SetDefault("tenancy.enabled", false)
+	viper.SetDefault("tenancy.header", "X-Tenant-Id")
+	viper.SetDefault("tenancy.apiKeyHeader", "X-Api-Key")
+	viper.SetDefault("tenancy.tenants", map[string]string{})
+	viper.SetDefault("tenancy.missingStatus", 400)
+
+	viper.SetDefault("ads.renewalExtension", 30*24*time.Hour)
+	viper.SetDefault("ads.renewalsPerWeek", 3)
+	viper.SetDefault("ads.similarExcludeOwner", true)
+	viper.SetDefault("ads.reportFlagThreshold", 3)
+	viper.SetDefault("ads.reportsPerHour", 10)
+	viper.SetDefault("ads.locales", []string{"en", "ru"})
+	viper.SetDefault("ads.exposeNumericIDs", true)
+	viper.SetDefault("ads.expireInterval", time.Minute)
+	viper.SetDefault("ads.expireBatchSize", 500)
+	viper.SetDefault("ads.maxActivePerOwner", 50)
+	viper.SetDefault("ads.maxCreationsPerDay", 20)
+	viper.SetDefault("ads.searchEngine", "like")
+	viper.SetDefault("ads.searchMinTokenLength", 3)
+	viper.SetDefault("ads.impressionWorkers", 4)
+	viper.SetDefault("ads.impressionQueueSize", 1000)
+
+	viper.SetDefault("currency.base", "USD")
+	viper.SetDefault("currency.provider", "static")
+	viper.SetDefault("currency.rates", map[string]float64{})
+	viper.SetDefault("currency.rateTTL", time.Hour)
+	viper.SetDefault("currency.maxRateAge", 24*time.Hour)
+
+	viper.SetDefault("sitemap.url", "http://localhost:8080/sitemap.xml")
+	viper.SetDefault("sitemap.adURL", "http://localhost:8080/ads/{id}")
+	viper.SetDefault("sitemap.maxURLs", 50000)
+	viper.SetDefault("sitemap.maxAge", time.Hour)
+
+	viper.SetDefault("search.engine", "mysql")
+	viper.SetDefault("search.url", "http://localhost:9200")
+	viper.SetDefault("search.index", "ads")
+	viper.SetDefault("search.username", "")
+	viper.SetDefault("search.password", "")
+	viper.SetDefault("search.passwordFile", "")
+	viper.SetDefault("search.timeout", 2*time.Second)
+	viper.SetDefault("search.analyzer", "standard")
+	viper.SetDefault("search.indexInterval", 5*time.Second)
+	viper.SetDefault("search.indexBatchSize", 500)
+}
+
+// logDefaultedKeys lists the keys that were set neither in the file nor in the environment,
+// which makes a typo in config.yaml visible as a key silently falling back to its default
+func logDefaultedKeys() {
+	var defaulted []string
+	for _, key := range configKeys(reflect.TypeOf(Config{}), "") {
+		envName := EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		if _, ok := os.LookupEnv(envName); ok || viper.InConfig(key) {
+			continue
+		}
+		defaulted = append(defaulted, key)
+	}
+	sort.Strings(defaulted)
+	if len(defaulted) > 0 {
+		log.Printf("Configuration defaults used for: %s", strings.Join(defaulted, ", "))
+	}
+}
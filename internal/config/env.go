@@ -0,0 +1,45 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix is prepended to every environment variable override, e.g. ADSVC_MYSQL_HOST
+const EnvPrefix = "ADSVC"
+
+// bindEnv registers an environment variable for every configuration key so env-only settings
+// are seen by Unmarshal even when the key is missing from the file and has no default.
+// Nested keys are joined with underscores and upper-cased: cache.adTTL becomes ADSVC_CACHE_ADTTL.
+func bindEnv() {
+	viper.SetEnvPrefix(EnvPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+	for _, key := range configKeys(reflect.TypeOf(Config{}), "") {
+		// BindEnv only fails without a key, which configKeys never returns
+		_ = viper.BindEnv(key)
+	}
+}
+
+// configKeys lists the dotted keys of the leaf fields of t. Maps are skipped since their
+// keys aren't known up front.
+func configKeys(t reflect.Type, prefix string) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := strings.ToLower(field.Name)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			keys = append(keys, configKeys(field.Type, key)...)
+		case field.Type.Kind() == reflect.Map:
+		default:
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
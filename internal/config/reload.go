@@ -0,0 +1,205 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// Reloadable holds a value that is replaced on hot reload. Components keep the holder and
+// call Load on every use instead of copying the value once at startup.
+type Reloadable[T any] struct {
+	value atomic.Pointer[T]
+}
+
+// NewReloadable returns a holder initialized with v
+func NewReloadable[T any](v T) *Reloadable[T] {
+	r := &Reloadable[T]{}
+	r.Store(v)
+	return r
+}
+
+// Load returns the current value
+func (r *Reloadable[T]) Load() T {
+	return *r.value.Load()
+}
+
+// Store replaces the value
+func (r *Reloadable[T]) Store(v T) {
+	r.value.Store(&v)
+}
+
+// dynamicKeys are the only keys applied on hot reload, every other change needs a restart
+var dynamicKeys = map[string]bool{
+	"log.level":              true,
+	"cache.adttl":            true,
+	"cache.listttl":          true,
+	"cache.countttl":         true,
+	"cache.negativettl":      true,
+	"cache.locktimeout":      true,
+	"cache.lockwait":         true,
+	"cache.mutationlockttl":  true,
+	"cache.mutationlockwait": true,
+	"ads.renewalsperweek":    true,
+	"ads.reportsperhour":     true,
+	"ads.maxactiveperowner":  true,
+	"ads.maxcreationsperday": true,
+	"tracing.samplerratio":   true,
+}
+
+// Watch reloads the config file whenever it changes and calls onReload once per change:
+//   - a valid file changing dynamic keys passes the configuration with only those keys taken
+//     over, and changed lists them
+//   - a valid file changing only static keys passes the running configuration and no changed
+//     keys; static keys keep their running values and are logged as needing a restart
+//   - an invalid file passes the running configuration and a non-nil error, and stays rejected
+//     until it is fixed
+//
+// Changes are found by comparing the keys of the previous file and the new one, so a key
+// removed from the file counts as changed.
+func Watch(current Config, onReload func(next Config, changed []string, err error)) {
+	if viper.ConfigFileUsed() == "" {
+		log.Println("No config file in use, hot reload is disabled")
+		return
+	}
+
+	var mu sync.Mutex
+	last := current
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		next, err := reloadFile()
+		if err != nil {
+			slog.Warn("Configuration reload rejected, keeping the previous configuration", "file", e.Name, "error", err)
+			onReload(current, nil, err)
+			return
+		}
+
+		changed, ignored := diffKeys(last, next)
+		last = next
+		if len(ignored) > 0 {
+			slog.Warn("Configuration reload ignores static keys, restart to apply", "keys", strings.Join(ignored, ", "))
+		}
+		if len(changed) == 0 {
+			if len(ignored) > 0 {
+				onReload(current, nil, nil)
+			}
+			return
+		}
+
+		// Secrets may change too, so only key names are logged, never values
+		current = mergeDynamic(current, next)
+		slog.Info("Configuration reloaded", "file", e.Name, "changed", strings.Join(changed, ", "))
+		onReload(current, changed, nil)
+	})
+	viper.WatchConfig()
+}
+
+// reloadFile reads the config file again and loads it. Viper has already read it when it
+// reports the change, but keeps the previous values when the file can't be parsed, so it is read
+// once more to find out.
+func reloadFile() (Config, error) {
+	if err := viper.ReadInConfig(); err != nil {
+		return Config{}, fmt.Errorf("could not read config file: %w", err)
+	}
+	return load()
+}
+
+// diffKeys lists the keys whose values differ between before and after, split into the dynamic
+// and the static ones, each sorted. Keys present on only one side, such as map members, differ.
+func diffKeys(before, after Config) (changed, ignored []string) {
+	was, now := flattenValues(reflect.ValueOf(before), ""), flattenValues(reflect.ValueOf(after), "")
+	keys := map[string]bool{}
+	for key := range was {
+		keys[key] = true
+	}
+	for key := range now {
+		keys[key] = true
+	}
+	for key := range keys {
+		wasValue, inWas := was[key]
+		nowValue, inNow := now[key]
+		if inWas == inNow && wasValue == nowValue {
+			continue
+		}
+		if dynamicKeys[key] {
+			changed = append(changed, key)
+		} else {
+			ignored = append(ignored, key)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(ignored)
+	return changed, ignored
+}
+
+// load unmarshals and validates the configuration viper currently holds
+func load() (Config, error) {
+	var config Config
+	if err := viper.Unmarshal(&config); err != nil {
+		return Config{}, fmt.Errorf("could not unmarshal configuration: %w", err)
+	}
+	config.Tracing.setDefaultExporter()
+	config.Currency.normalizeCodes()
+	if err := config.resolveSecrets(); err != nil {
+		return Config{}, err
+	}
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// mergeDynamic returns current with the dynamic keys taken from next
+func mergeDynamic(current, next Config) Config {
+	current.Cache.AdTTL = next.Cache.AdTTL
+	current.Cache.ListTTL = next.Cache.ListTTL
+	current.Cache.CountTTL = next.Cache.CountTTL
+	current.Cache.NegativeTTL = next.Cache.NegativeTTL
+	current.Cache.LockTimeout = next.Cache.LockTimeout
+	current.Cache.LockWait = next.Cache.LockWait
+	current.Cache.MutationLockTTL = next.Cache.MutationLockTTL
+	current.Cache.MutationLockWait = next.Cache.MutationLockWait
+	current.Log.Level = next.Log.Level
+	current.Ads.RenewalsPerWeek = next.Ads.RenewalsPerWeek
+	current.Ads.ReportsPerHour = next.Ads.ReportsPerHour
+	current.Ads.MaxActivePerOwner = next.Ads.MaxActivePerOwner
+	current.Ads.MaxCreationsPerDay = next.Ads.MaxCreationsPerDay
+	current.Tracing.SamplerRatio = next.Tracing.SamplerRatio
+	return current
+}
+
+// flattenValues maps every dotted key to its value formatted as a string, the same keys
+// configKeys lists plus one entry per map member
+func flattenValues(v reflect.Value, prefix string) map[string]string {
+	values := map[string]string{}
+	for i := 0; i < v.NumField(); i++ {
+		key := strings.ToLower(v.Type().Field(i).Name)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Struct:
+			for k, value := range flattenValues(field, key) {
+				values[k] = value
+			}
+		case reflect.Map:
+			for _, mapKey := range field.MapKeys() {
+				values[key+"."+fmt.Sprint(mapKey.Interface())] = fmt.Sprint(field.MapIndex(mapKey).Interface())
+			}
+		default:
+			values[key] = fmt.Sprint(field.Interface())
+		}
+	}
+	return values
+}
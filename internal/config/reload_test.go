@@ -0,0 +1,139 @@
+package config
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// reload is one call of a Watch callback
+type reload struct {
+	next    Config
+	changed []string
+	err     error
+}
+
+// watchTestConfig loads yaml from a file and watches it, returning the file and the calls of
+// the callback
+func watchTestConfig(t *testing.T, yaml string) (string, *Config, <-chan reload) {
+	t.Helper()
+	cfg, err := loadTestConfig(t, yaml)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	reloads := make(chan reload, 10)
+	Watch(*cfg, func(next Config, changed []string, err error) {
+		reloads <- reload{next, changed, err}
+	})
+	return viper.ConfigFileUsed(), cfg, reloads
+}
+
+// rewrite replaces the config file the way editors and mounted ConfigMaps do, by renaming a
+// new file over it, so the watcher never sees it half written
+func rewrite(t *testing.T, path, yaml string) {
+	t.Helper()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// nextReload waits for the callback of a reload
+func nextReload(t *testing.T, reloads <-chan reload) reload {
+	t.Helper()
+	select {
+	case r := <-reloads:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload within 5s of changing the file")
+		return reload{}
+	}
+}
+
+// captureLogs sends the slog output to a buffer until the test ends
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &logs
+}
+
+func TestWatchAppliesDynamicKeys(t *testing.T) {
+	path, cfg, reloads := watchTestConfig(t, "cache:\n  adTTL: 1m\n")
+
+	rewrite(t, path, "log:\n  level: debug\ncache:\n  adTTL: 2m\nads:\n  reportsPerHour: 5\ntracing:\n  samplerRatio: 0.5\n")
+	r := nextReload(t, reloads)
+	want := []string{"ads.reportsperhour", "cache.adttl", "log.level", "tracing.samplerratio"}
+	if r.err != nil || !slices.Equal(r.changed, want) {
+		t.Fatalf("reload = %v, %v; want %v", r.changed, r.err, want)
+	}
+	if r.next.Cache.AdTTL != 2*time.Minute || r.next.Log.Level != "debug" || r.next.Ads.ReportsPerHour != 5 || r.next.Tracing.SamplerRatio != 0.5 {
+		t.Errorf("reloaded config = %+v, want the new values", r.next)
+	}
+	// Everything else is the running configuration
+	if r.next.Cache.ListTTL != cfg.Cache.ListTTL || r.next.Server.Port != cfg.Server.Port {
+		t.Errorf("reloaded config changed keys the file didn't: %+v", r.next)
+	}
+}
+
+func TestWatchRejectsInvalidFile(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{"unparsable", "cache: [adTTL\n"},
+		{"invalid value", "cache:\n  adTTL: -1s\n"},
+		{"unknown log level", "log:\n  level: verbose\n"},
+	}
+	path, _, reloads := watchTestConfig(t, "cache:\n  adTTL: 1m\n")
+	logs := captureLogs(t)
+	for _, tt := range tests {
+		rewrite(t, path, tt.yaml)
+		r := nextReload(t, reloads)
+		if r.err == nil {
+			t.Errorf("%s: reload accepted", tt.name)
+		}
+		if r.changed != nil || r.next.Cache.AdTTL != time.Minute || r.next.Log.Level != "info" {
+			t.Errorf("%s: reload = %v with adTTL %s and log level %s, want the previous configuration", tt.name, r.changed, r.next.Cache.AdTTL, r.next.Log.Level)
+		}
+	}
+	if !strings.Contains(logs.String(), "Configuration reload rejected") {
+		t.Errorf("logs = %q, want the rejections", logs)
+	}
+
+	// Fixing the file applies it, compared with the last valid one
+	rewrite(t, path, "cache:\n  adTTL: 3m\n")
+	if r := nextReload(t, reloads); r.err != nil || !slices.Equal(r.changed, []string{"cache.adttl"}) || r.next.Cache.AdTTL != 3*time.Minute {
+		t.Errorf("reload of the fixed file = %v, %v with adTTL %s; want adTTL 3m", r.changed, r.err, r.next.Cache.AdTTL)
+	}
+}
+
+func TestWatchIgnoresStaticKeys(t *testing.T) {
+	path, _, reloads := watchTestConfig(t, "mysql:\n  host: mysql.local\nserver:\n  port: \"8080\"\ntenancy:\n  tenants:\n    acme: key-1\n")
+	logs := captureLogs(t)
+
+	// The DSN and the port change, and a tenant is removed from the file
+	rewrite(t, path, "mysql:\n  host: mysql.prod.svc\nserver:\n  port: \"8081\"\n")
+	r := nextReload(t, reloads)
+	if r.err != nil || len(r.changed) != 0 {
+		t.Fatalf("reload = %v, %v; want nothing applied", r.changed, r.err)
+	}
+	if r.next.MySQL.Host != "mysql.local" || r.next.Server.Port != "8080" || r.next.Tenancy.Tenants["acme"] != "key-1" {
+		t.Errorf("reloaded config = %+v, want the running static values", r.next)
+	}
+	warning := "Configuration reload ignores static keys, restart to apply\" keys=\"mysql.host, server.port, tenancy.tenants.acme\""
+	if !strings.Contains(logs.String(), warning) {
+		t.Errorf("logs = %q, want a warning naming the removed key too", logs)
+	}
+}
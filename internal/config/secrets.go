@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// secretFile pairs an inline secret with the key of the file that may hold it instead
+type secretFile struct {
+	key    string  // e.g. mysql.passwordFile
+	path   string  // value of the file key
+	inline string  // key of the inline variant, e.g. mysql.password
+	target *string // field the secret is written to
+}
+
+// resolveSecrets reads secrets mounted as files, e.g. Kubernetes secret volumes, into their
+// fields. A readable, non-empty file wins over the inline value. A missing or empty file is an
+// error unless the inline value is set, in which case that is used. Secret values are never logged.
+func (c *Config) resolveSecrets() error {
+	secrets := []secretFile{
+		{"mysql.passwordFile", c.MySQL.PasswordFile, "mysql.password", &c.MySQL.Password},
+		{"redis.passwordFile", c.Redis.PasswordFile, "redis.password", &c.Redis.Password},
+		{"search.passwordFile", c.Search.PasswordFile, "search.password", &c.Search.Password},
+	}
+	for _, s := range secrets {
+		if s.path == "" {
+			continue
+		}
+		secret, err := readSecretFile(s.path)
+		if err != nil {
+			if *s.target == "" {
+				return fmt.Errorf("%s: %w", s.key, err)
+			}
+			log.Printf("Ignoring %s, using %s instead: %v", s.key, s.inline, err)
+			continue
+		}
+		if *s.target != "" {
+			log.Printf("Both %s and %s are set, using the file", s.key, s.inline)
+		}
+		*s.target = secret
+	}
+	return nil
+}
+
+// readSecretFile returns the file content without the trailing newline editors and
+// `kubectl create secret --from-file` leave behind
+func readSecretFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimRight(string(content), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	mounted := secretFile("mysql-password", "from-file-s3cret\n")
+	windows := secretFile("crlf-password", "crlf-s3cret\r\n")
+	empty := secretFile("empty-password", "\n")
+	missing := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		name         string
+		file, inline string
+		want         string // the resolved password
+		wantErr      string
+		wantLog      string
+	}{
+		{"file only", mounted, "", "from-file-s3cret", "", ""},
+		{"carriage return trimmed", windows, "", "crlf-s3cret", "", ""},
+		{"file wins over inline", mounted, "inline-s3cret", "from-file-s3cret", "", "Both mysql.passwordFile and mysql.password are set, using the file"},
+		{"inline without a file", "", "inline-s3cret", "inline-s3cret", "", ""},
+		{"missing file falls back to inline", missing, "inline-s3cret", "inline-s3cret", "", "Ignoring mysql.passwordFile, using mysql.password instead"},
+		{"missing file without inline", missing, "", "", "mysql.passwordFile: open " + missing, ""},
+		{"empty file without inline", empty, "", "", "mysql.passwordFile: " + empty + " is empty", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			previous := log.Writer()
+			log.SetOutput(&logs)
+			t.Cleanup(func() { log.SetOutput(previous) })
+
+			cfg := Config{MySQL: MySQLConfig{Password: tt.inline, PasswordFile: tt.file}}
+			err := cfg.resolveSecrets()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolveSecrets = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveSecrets: %v", err)
+			}
+			if cfg.MySQL.Password != tt.want {
+				t.Errorf("mysql.password = %q, want %q", cfg.MySQL.Password, tt.want)
+			}
+			if !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("log = %q, want %q", logs.String(), tt.wantLog)
+			}
+			if strings.Contains(logs.String(), "s3cret") {
+				t.Errorf("log = %q, a secret was logged", logs.String())
+			}
+		})
+	}
+}
+
+func TestLoadConfigSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	if err := os.WriteFile(passwordFile, []byte("redis-s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	previous := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(previous) })
+
+	// The file key works from the environment like every other key
+	t.Setenv("ADSVC_MYSQL_PASSWORDFILE", passwordFile)
+	cfg, err := loadTestConfig(t, "redis:\n  password: inline-s3cret\n  passwordFile: "+passwordFile+"\n")
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Redis.Password != "redis-s3cret" || cfg.MySQL.Password != "redis-s3cret" {
+		t.Errorf("passwords = %q and %q, want the file content", cfg.Redis.Password, cfg.MySQL.Password)
+	}
+	if strings.Contains(logs.String(), "s3cret") {
+		t.Errorf("loading logged a secret: %s", logs.String())
+	}
+}
@@ -0,0 +1,564 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationError lists every problem found in the configuration, so all of them can be fixed
+// in one pass instead of one restart per mistake
+type ValidationError struct {
+	Problems []error
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid configuration (%d problems):", len(e.Problems))
+	for _, problem := range e.Problems {
+		b.WriteString("\n  - ")
+		b.WriteString(problem.Error())
+	}
+	return b.String()
+}
+
+// Validate checks every section and returns a *ValidationError with all violations, each
+// naming the offending key
+func (c Config) Validate() error {
+	var problems []error
+	for _, err := range []error{
+		c.MySQL.Validate(),
+		c.Redis.Validate(),
+		c.Cache.Validate(),
+		c.Server.Validate(),
+		c.Log.Validate(),
+		c.Tracing.Validate(),
+		c.Metrics.Validate(),
+		c.Auth.Validate(),
+		c.Tenancy.Validate(),
+		c.Ads.Validate(),
+		c.Currency.Validate(),
+		c.Sitemap.Validate(),
+		c.Search.Validate(),
+	} {
+		problems = append(problems, flatten(err)...)
+	}
+	if c.Server.Port != "" && c.Server.Port == c.Server.InternalPort {
+		problems = append(problems, fmt.Errorf("server.internalPort must differ from server.port, both are %s", c.Server.Port))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
+
+// flatten unpacks the errors.Join result of a section validator
+func flatten(err error) []error {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+// checkPort reports a port that is not a number between 1 and 65535
+func checkPort(key, port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%s must be a port number between 1 and 65535, got %q", key, port)
+	}
+	return nil
+}
+
+// checkNonNegative reports durations below zero, in key order so the output is stable
+func checkNonNegative(durations map[string]time.Duration) []error {
+	keys := make([]string, 0, len(durations))
+	for key := range durations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		if durations[key] < 0 {
+			errs = append(errs, fmt.Errorf("%s cannot be negative, got %s", key, durations[key]))
+		}
+	}
+	return errs
+}
+
+// checkBreaker validates the circuit breaker settings under a section, which must keep at least
+// one way of opening the breaker
+func checkBreaker(section string, threshold int, failureRate float64, minRequests, probes int) []error {
+	var errs []error
+	if threshold < 0 {
+		errs = append(errs, fmt.Errorf("%s.breakerThreshold cannot be negative, got %d", section, threshold))
+	}
+	if failureRate < 0 || failureRate > 1 {
+		errs = append(errs, fmt.Errorf("%s.breakerFailureRate must be between 0 and 1, got %v", section, failureRate))
+	}
+	if threshold == 0 && failureRate == 0 {
+		errs = append(errs, fmt.Errorf("%s.breakerThreshold and %s.breakerFailureRate cannot both be 0", section, section))
+	}
+	if minRequests < 0 {
+		errs = append(errs, fmt.Errorf("%s.breakerMinRequests cannot be negative, got %d", section, minRequests))
+	}
+	if probes < 1 {
+		errs = append(errs, fmt.Errorf("%s.breakerProbes must be at least 1, got %d", section, probes))
+	}
+	return errs
+}
+
+// checkFiles reports configured files that can't be found, in key order
+func checkFiles(files map[string]string) []error {
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		if files[key] == "" {
+			continue
+		}
+		if _, err := os.Stat(files[key]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	return errs
+}
+
+// Validate checks the connection settings and pool sizes
+func (c MySQLConfig) Validate() error {
+	var errs []error
+	for key, value := range map[string]string{"mysql.user": c.User, "mysql.host": c.Host, "mysql.database": c.Database} {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s is required", key))
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	if err := checkPort("mysql.port", c.Port); err != nil {
+		errs = append(errs, err)
+	}
+	if c.MaxOpenConns < 0 {
+		errs = append(errs, fmt.Errorf("mysql.maxOpenConns cannot be negative, got %d", c.MaxOpenConns))
+	}
+	if c.MaxIdleConns < 0 {
+		errs = append(errs, fmt.Errorf("mysql.maxIdleConns cannot be negative, got %d", c.MaxIdleConns))
+	}
+	if c.MaxOpenConns > 0 && c.MaxIdleConns > c.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("mysql.maxIdleConns (%d) cannot exceed mysql.maxOpenConns (%d)", c.MaxIdleConns, c.MaxOpenConns))
+	}
+	errs = append(errs, checkNonNegative(map[string]time.Duration{
+		"mysql.breakerWindow":   c.BreakerWindow,
+		"mysql.breakerCooldown": c.BreakerCooldown,
+	})...)
+	errs = append(errs, checkBreaker("mysql", c.BreakerThreshold, c.BreakerFailureRate, c.BreakerMinRequests, c.BreakerProbes)...)
+	if c.ConnMaxLifetime < 0 {
+		errs = append(errs, fmt.Errorf("mysql.connMaxLifetime cannot be negative, got %s", c.ConnMaxLifetime))
+	}
+	return errors.Join(errs...)
+}
+
+// Validate rejects a half-filled sentinel section and TLS files that don't exist
+func (c RedisConfig) Validate() error {
+	var errs []error
+	if !c.Sentinel.Enabled() {
+		if err := checkPort("redis.port", c.Port); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.DB < 0 {
+		errs = append(errs, fmt.Errorf("redis.db cannot be negative, got %d", c.DB))
+	}
+	if c.PoolSize < 0 {
+		errs = append(errs, fmt.Errorf("redis.poolSize cannot be negative, got %d", c.PoolSize))
+	}
+
+	if c.TLS.Enabled {
+		if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+			errs = append(errs, fmt.Errorf("redis.tls.certFile and redis.tls.keyFile must be set together"))
+		}
+		errs = append(errs, checkFiles(map[string]string{
+			"redis.tls.caFile":   c.TLS.CAFile,
+			"redis.tls.certFile": c.TLS.CertFile,
+			"redis.tls.keyFile":  c.TLS.KeyFile,
+		})...)
+	}
+
+	if c.Sentinel.Enabled() {
+		if c.Sentinel.MasterName == "" {
+			errs = append(errs, fmt.Errorf("redis.sentinel.masterName is required when a sentinel section is present"))
+		}
+		if len(c.Sentinel.Addresses) == 0 {
+			errs = append(errs, fmt.Errorf("redis.sentinel.addresses must list at least one sentinel"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks the driver name, that no TTL is negative and the hot key settings
+func (c CacheConfig) Validate() error {
+	var errs []error
+	switch c.Driver {
+	case "redis", "memory", "none":
+	default:
+		errs = append(errs, fmt.Errorf("cache.driver must be one of redis, memory or none, got %q", c.Driver))
+	}
+	switch c.WriteMode {
+	case "write_through", "invalidate":
+	default:
+		errs = append(errs, fmt.Errorf("cache.writeMode must be either write_through or invalidate, got %q", c.WriteMode))
+	}
+
+	errs = append(errs, checkNonNegative(map[string]time.Duration{
+		"cache.adTTL":            c.AdTTL,
+		"cache.listTTL":          c.ListTTL,
+		"cache.countTTL":         c.CountTTL,
+		"cache.negativeTTL":      c.NegativeTTL,
+		"cache.lockTimeout":      c.LockTimeout,
+		"cache.lockWait":         c.LockWait,
+		"cache.breakerCooldown":  c.BreakerCooldown,
+		"cache.breakerWindow":    c.BreakerWindow,
+		"cache.mutationLockTTL":  c.MutationLockTTL,
+		"cache.mutationLockWait": c.MutationLockWait,
+	})...)
+	errs = append(errs, checkBreaker("cache", c.BreakerThreshold, c.BreakerFailureRate, c.BreakerMinRequests, c.BreakerProbes)...)
+	if c.CompressionThreshold < 0 {
+		errs = append(errs, fmt.Errorf("cache.compressionThreshold cannot be negative, got %d", c.CompressionThreshold))
+	}
+	if c.PurgeMaxDuration <= 0 {
+		errs = append(errs, fmt.Errorf("cache.purgeMaxDuration must be positive, got %s", c.PurgeMaxDuration))
+	}
+	if c.HotKeys < 0 {
+		errs = append(errs, fmt.Errorf("cache.hotKeys cannot be negative, got %d", c.HotKeys))
+	}
+	if c.HotKeys > 0 {
+		if c.HotThreshold < 1 {
+			errs = append(errs, fmt.Errorf("cache.hotThreshold must be at least 1, got %d", c.HotThreshold))
+		}
+		if c.HotWindow <= 0 {
+			errs = append(errs, fmt.Errorf("cache.hotWindow must be positive, got %s", c.HotWindow))
+		}
+		if c.HotRefreshLead <= 0 {
+			errs = append(errs, fmt.Errorf("cache.hotRefreshLead must be positive, got %s", c.HotRefreshLead))
+		} else if c.AdTTL > 0 && c.HotRefreshLead >= c.AdTTL {
+			errs = append(errs, fmt.Errorf("cache.hotRefreshLead must be shorter than cache.adTTL (%s), got %s", c.AdTTL, c.HotRefreshLead))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks that the level is one slog knows
+func (c LogConfig) Validate() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		return fmt.Errorf("log.level must be one of debug, info, warn or error, got %q", c.Level)
+	}
+	return nil
+}
+
+// Validate checks the ports, the shutdown timeout and the page size cap
+func (c ServerConfig) Validate() error {
+	var errs []error
+	if err := checkPort("server.port", c.Port); err != nil {
+		errs = append(errs, err)
+	}
+	if err := checkPort("server.internalPort", c.InternalPort); err != nil {
+		errs = append(errs, err)
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("server.shutdownTimeout must be positive, got %s", c.ShutdownTimeout))
+	}
+	if c.MaxPageSize < 1 {
+		errs = append(errs, fmt.Errorf("server.maxPageSize must be at least 1, got %d", c.MaxPageSize))
+	}
+	switch c.PageSizeMode {
+	case "clamp", "reject":
+	default:
+		errs = append(errs, fmt.Errorf("server.pageSizeMode must be either clamp or reject, got %q", c.PageSizeMode))
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks the exporter, propagator and sampler settings
+func (c TracingConfig) Validate() error {
+	var errs []error
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("tracing.shutdownTimeout must be positive, got %v", c.ShutdownTimeout))
+	}
+	switch c.Exporter {
+	case "stdout", "none":
+	case "otlp":
+		if c.ExporterEndpoint() == "" {
+			errs = append(errs, fmt.Errorf("tracing.endpoint is required with the otlp exporter"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter must be one of otlp, stdout or none, got %q", c.Exporter))
+	}
+	if c.Protocol != "http" && c.Protocol != "grpc" {
+		errs = append(errs, fmt.Errorf("tracing.protocol must be http or grpc, got %q", c.Protocol))
+	}
+	if c.TLS.Enabled {
+		errs = append(errs, checkFiles(map[string]string{"tracing.tls.caFile": c.TLS.CAFile})...)
+	}
+	for _, name := range c.Propagators {
+		switch name {
+		case "tracecontext", "baggage", "b3", "b3multi":
+		default:
+			errs = append(errs, fmt.Errorf("tracing.propagators: unknown propagator %q, must be tracecontext, baggage, b3 or b3multi", name))
+		}
+	}
+	switch c.Sampler {
+	case "always", "never", "ratio":
+	default:
+		errs = append(errs, fmt.Errorf("tracing.sampler must be one of always, never or ratio, got %q", c.Sampler))
+	}
+	if c.SamplerRatio < 0 || c.SamplerRatio > 1 {
+		errs = append(errs, fmt.Errorf("tracing.samplerRatio must be between 0 and 1, got %g", c.SamplerRatio))
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks the refresh interval, that every bucket list is strictly increasing and the
+// OTLP exporter when it is enabled
+func (c MetricsConfig) Validate() error {
+	var errs []error
+	if c.AdsRefreshInterval < 0 {
+		errs = append(errs, fmt.Errorf("metrics.adsRefreshInterval cannot be negative, got %s", c.AdsRefreshInterval))
+	}
+	buckets := []struct {
+		key    string
+		bounds []float64
+	}{
+		{"metrics.httpBuckets", c.HTTPBuckets},
+		{"metrics.dbBuckets", c.DBBuckets},
+		{"metrics.redisBuckets", c.RedisBuckets},
+	}
+	for _, b := range buckets {
+		for i := 1; i < len(b.bounds); i++ {
+			if b.bounds[i] <= b.bounds[i-1] {
+				errs = append(errs, fmt.Errorf("%s must be sorted in increasing order, %g follows %g", b.key, b.bounds[i], b.bounds[i-1]))
+				break
+			}
+		}
+	}
+	if c.OTLP.Enabled {
+		if c.OTLP.Endpoint == "" {
+			errs = append(errs, fmt.Errorf("metrics.otlp.endpoint is required when metrics.otlp.enabled is true"))
+		}
+		if c.OTLP.Protocol != "http" {
+			errs = append(errs, fmt.Errorf("metrics.otlp.protocol must be http, got %q", c.OTLP.Protocol))
+		}
+		if c.OTLP.Interval <= 0 {
+			errs = append(errs, fmt.Errorf("metrics.otlp.interval must be positive, got %s", c.OTLP.Interval))
+		}
+		if c.OTLP.Timeout <= 0 {
+			errs = append(errs, fmt.Errorf("metrics.otlp.timeout must be positive, got %s", c.OTLP.Timeout))
+		}
+		if c.OTLP.TLS.Enabled {
+			errs = append(errs, checkFiles(map[string]string{"metrics.otlp.tls.caFile": c.OTLP.TLS.CAFile})...)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks that callers can be identified and that the admin role is named
+func (c AuthConfig) Validate() error {
+	var errs []error
+	if c.UserIDHeader == "" {
+		errs = append(errs, fmt.Errorf("auth.userIdHeader must not be empty"))
+	}
+	if c.RoleHeader != "" && c.AdminRole == "" {
+		errs = append(errs, fmt.Errorf("auth.adminRole must not be empty when auth.roleHeader is set"))
+	}
+	return errors.Join(errs...)
+}
+
+// tenantPattern matches the tenant IDs accepted in tenancy.tenants. They are lowercase since
+// configuration keys are case-insensitive, and can't hold the ':' separating cache key parts.
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Validate checks the tenant headers, the tenant IDs and their API keys, and the status of
+// unresolved requests
+func (c TenancyConfig) Validate() error {
+	var errs []error
+	if c.MissingStatus != 400 && c.MissingStatus != 401 {
+		errs = append(errs, fmt.Errorf("tenancy.missingStatus must be 400 or 401, got %d", c.MissingStatus))
+	}
+	if !c.Enabled {
+		return errors.Join(errs...)
+	}
+	if c.Header == "" && c.APIKeyHeader == "" {
+		errs = append(errs, fmt.Errorf("tenancy.header or tenancy.apiKeyHeader must be set when tenancy is enabled"))
+	}
+	if len(c.Tenants) == 0 {
+		errs = append(errs, fmt.Errorf("tenancy.tenants must list at least one tenant when tenancy is enabled"))
+	}
+	ids := make([]string, 0, len(c.Tenants))
+	for id := range c.Tenants {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	keys := make(map[string]string, len(c.Tenants))
+	for _, id := range ids {
+		key := c.Tenants[id]
+		if !tenantPattern.MatchString(id) {
+			errs = append(errs, fmt.Errorf("tenancy.tenants.%s: tenant IDs are 1 to 64 lowercase letters, digits, '-' or '_'", id))
+		}
+		if key == "" {
+			if c.Header == "" {
+				errs = append(errs, fmt.Errorf("tenancy.tenants.%s has no API key and tenancy.header is empty, it can't be selected", id))
+			}
+			continue
+		}
+		if c.APIKeyHeader == "" {
+			errs = append(errs, fmt.Errorf("tenancy.tenants.%s has an API key but tenancy.apiKeyHeader is empty", id))
+		}
+		if other, ok := keys[key]; ok {
+			errs = append(errs, fmt.Errorf("tenancy.tenants.%s and tenancy.tenants.%s share an API key", other, id))
+		}
+		keys[key] = id
+	}
+	return errors.Join(errs...)
+}
+
+// localePattern matches the lowercase language codes accepted in ads.locales
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})?$`)
+
+// Validate checks the renewal and report rules, the translation locales, the expiry job, the quota
+// and the search engine
+func (c AdsConfig) Validate() error {
+	var errs []error
+	if c.RenewalExtension <= 0 {
+		errs = append(errs, fmt.Errorf("ads.renewalExtension must be positive, got %s", c.RenewalExtension))
+	}
+	if c.RenewalsPerWeek < 1 {
+		errs = append(errs, fmt.Errorf("ads.renewalsPerWeek must be at least 1, got %d", c.RenewalsPerWeek))
+	}
+	if c.ReportFlagThreshold < 1 {
+		errs = append(errs, fmt.Errorf("ads.reportFlagThreshold must be at least 1, got %d", c.ReportFlagThreshold))
+	}
+	if c.ReportsPerHour < 1 {
+		errs = append(errs, fmt.Errorf("ads.reportsPerHour must be at least 1, got %d", c.ReportsPerHour))
+	}
+	if c.ExpireInterval < 0 {
+		errs = append(errs, fmt.Errorf("ads.expireInterval cannot be negative, got %s", c.ExpireInterval))
+	}
+	if c.ExpireBatchSize < 1 {
+		errs = append(errs, fmt.Errorf("ads.expireBatchSize must be at least 1, got %d", c.ExpireBatchSize))
+	}
+	if c.MaxActivePerOwner < 0 {
+		errs = append(errs, fmt.Errorf("ads.maxActivePerOwner cannot be negative, got %d", c.MaxActivePerOwner))
+	}
+	if c.MaxCreationsPerDay < 0 {
+		errs = append(errs, fmt.Errorf("ads.maxCreationsPerDay cannot be negative, got %d", c.MaxCreationsPerDay))
+	}
+	if c.SearchEngine != "like" && c.SearchEngine != "fulltext" {
+		errs = append(errs, fmt.Errorf("ads.searchEngine must be like or fulltext, got %q", c.SearchEngine))
+	}
+	if c.SearchMinTokenLength < 1 {
+		errs = append(errs, fmt.Errorf("ads.searchMinTokenLength must be at least 1, got %d", c.SearchMinTokenLength))
+	}
+	if c.ImpressionWorkers < 1 {
+		errs = append(errs, fmt.Errorf("ads.impressionWorkers must be at least 1, got %d", c.ImpressionWorkers))
+	}
+	if c.ImpressionQueueSize < 1 {
+		errs = append(errs, fmt.Errorf("ads.impressionQueueSize must be at least 1, got %d", c.ImpressionQueueSize))
+	}
+	for _, locale := range c.Locales {
+		if !localePattern.MatchString(locale) {
+			errs = append(errs, fmt.Errorf("ads.locales: invalid locale %q, must be a lowercase language code such as en or pt-br", locale))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// currencyPattern matches ISO 4217 style currency codes
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Validate checks the base currency, the provider and the static rates
+func (c CurrencyConfig) Validate() error {
+	var errs []error
+	if !currencyPattern.MatchString(c.Base) {
+		errs = append(errs, fmt.Errorf("currency.base must be a three-letter currency code such as USD, got %q", c.Base))
+	}
+	if c.Provider != "static" {
+		errs = append(errs, fmt.Errorf("currency.provider must be static, got %q", c.Provider))
+	}
+	for code, rate := range c.Rates {
+		if !currencyPattern.MatchString(code) {
+			errs = append(errs, fmt.Errorf("currency.rates: invalid currency code %q", code))
+		}
+		if rate <= 0 {
+			errs = append(errs, fmt.Errorf("currency.rates.%s must be positive, got %v", code, rate))
+		}
+	}
+	errs = append(errs, checkNonNegative(map[string]time.Duration{
+		"currency.rateTTL":    c.RateTTL,
+		"currency.maxRateAge": c.MaxRateAge,
+	})...)
+	return errors.Join(errs...)
+}
+
+// maxSitemapURLs is the sitemaps.org limit of URLs in one sitemap
+const maxSitemapURLs = 50000
+
+// Validate checks that the sitemap URLs are absolute and the page size within the protocol limit
+func (c SitemapConfig) Validate() error {
+	var errs []error
+	if u, err := url.Parse(c.URL); err != nil || !u.IsAbs() || u.Host == "" {
+		errs = append(errs, fmt.Errorf("sitemap.url must be an absolute URL, got %q", c.URL))
+	}
+	if u, err := url.Parse(strings.ReplaceAll(c.AdURL, "{id}", "1")); err != nil || !u.IsAbs() || u.Host == "" || !strings.Contains(c.AdURL, "{id}") {
+		errs = append(errs, fmt.Errorf("sitemap.adURL must be an absolute URL containing {id}, got %q", c.AdURL))
+	}
+	if c.MaxURLs < 1 || c.MaxURLs > maxSitemapURLs {
+		errs = append(errs, fmt.Errorf("sitemap.maxURLs must be between 1 and %d, got %d", maxSitemapURLs, c.MaxURLs))
+	}
+	errs = append(errs, checkNonNegative(map[string]time.Duration{"sitemap.maxAge": c.MaxAge})...)
+	return errors.Join(errs...)
+}
+
+// indexNamePattern matches the index names Elasticsearch and OpenSearch accept
+var indexNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// Validate checks the engine and, when Elasticsearch answers searches, its cluster and indexer
+func (c SearchConfig) Validate() error {
+	var errs []error
+	if c.Engine != SearchEngineMySQL && c.Engine != SearchEngineElasticsearch {
+		errs = append(errs, fmt.Errorf("search.engine must be mysql or elasticsearch, got %q", c.Engine))
+	}
+	if !c.Elasticsearch() {
+		return errors.Join(errs...)
+	}
+	if u, err := url.Parse(c.URL); err != nil || !u.IsAbs() || u.Host == "" {
+		errs = append(errs, fmt.Errorf("search.url must be an absolute URL, got %q", c.URL))
+	}
+	if !indexNamePattern.MatchString(c.Index) {
+		errs = append(errs, fmt.Errorf("search.index must be a lowercase index name, got %q", c.Index))
+	}
+	if c.Analyzer == "" {
+		errs = append(errs, errors.New("search.analyzer must not be empty"))
+	}
+	if c.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("search.timeout must be positive, got %s", c.Timeout))
+	}
+	if c.IndexInterval <= 0 {
+		errs = append(errs, fmt.Errorf("search.indexInterval must be positive, got %s", c.IndexInterval))
+	}
+	if c.IndexBatchSize < 1 {
+		errs = append(errs, fmt.Errorf("search.indexBatchSize must be at least 1, got %d", c.IndexBatchSize))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,213 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedisConfigValidate(t *testing.T) {
+	dir := t.TempDir()
+	existing, missing := filepath.Join(dir, "redis.pem"), filepath.Join(dir, "missing.pem")
+	if err := os.WriteFile(existing, []byte("pem"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  RedisConfig
+		want string // part of the error, empty when valid
+	}{
+		{"standalone", RedisConfig{Host: "localhost", Port: "6379"}, ""},
+		{"bad port", RedisConfig{Host: "localhost", Port: "redis"}, "redis.port must be a port number"},
+		{"sentinel", RedisConfig{Sentinel: SentinelConfig{MasterName: "ads", Addresses: []string{"sentinel-1:26379"}}}, ""},
+		{"sentinel without master", RedisConfig{Sentinel: SentinelConfig{Addresses: []string{"sentinel-1:26379"}}}, "redis.sentinel.masterName is required"},
+		{"sentinel without addresses", RedisConfig{Sentinel: SentinelConfig{MasterName: "ads"}}, "redis.sentinel.addresses must list at least one sentinel"},
+		{"sentinel password only", RedisConfig{Sentinel: SentinelConfig{Password: "secret"}}, "redis.sentinel.masterName is required"},
+		{"TLS with existing files", RedisConfig{Host: "localhost", Port: "6379", TLS: RedisTLSConfig{Enabled: true, CAFile: existing, CertFile: existing, KeyFile: existing}}, ""},
+		{"TLS with missing certificate", RedisConfig{Host: "localhost", Port: "6379", TLS: RedisTLSConfig{Enabled: true, CertFile: missing, KeyFile: existing}}, "redis.tls.certFile: stat " + missing},
+		{"TLS with missing CA bundle", RedisConfig{Host: "localhost", Port: "6379", TLS: RedisTLSConfig{Enabled: true, CAFile: missing}}, "redis.tls.caFile"},
+		{"certificate without key", RedisConfig{Host: "localhost", Port: "6379", TLS: RedisTLSConfig{Enabled: true, CertFile: existing}}, "must be set together"},
+		// Files of a disabled TLS section aren't checked
+		{"TLS disabled", RedisConfig{Host: "localhost", Port: "6379", TLS: RedisTLSConfig{CertFile: missing}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTracingConfigValidate(t *testing.T) {
+	valid := TracingConfig{Exporter: "none", Protocol: "http", Sampler: "ratio", SamplerRatio: 0.1, ShutdownTimeout: time.Second}
+	tests := []struct {
+		name   string
+		change func(c *TracingConfig)
+		want   string // part of the error, empty when valid
+	}{
+		{"valid", func(c *TracingConfig) {}, ""},
+		{"always", func(c *TracingConfig) { c.Sampler = "always" }, ""},
+		{"never", func(c *TracingConfig) { c.Sampler = "never" }, ""},
+		{"ratio of one", func(c *TracingConfig) { c.SamplerRatio = 1 }, ""},
+		{"ratio above one", func(c *TracingConfig) { c.SamplerRatio = 1.5 }, "tracing.samplerRatio must be between 0 and 1, got 1.5"},
+		{"negative ratio", func(c *TracingConfig) { c.SamplerRatio = -0.1 }, "tracing.samplerRatio must be between 0 and 1"},
+		{"unknown sampler", func(c *TracingConfig) { c.Sampler = "sometimes" }, `tracing.sampler must be one of always, never or ratio, got "sometimes"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.change(&cfg)
+			err := cfg.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTracingDefaultExporter(t *testing.T) {
+	tests := []struct {
+		cfg  TracingConfig
+		want string
+	}{
+		{TracingConfig{}, "none"},
+		{TracingConfig{Endpoint: "otel-collector:4318"}, "otlp"},
+		{TracingConfig{JaegerEndpoint: "jaeger:4318"}, "otlp"},
+		// An explicit mode is kept, even with an endpoint
+		{TracingConfig{Exporter: "stdout", Endpoint: "otel-collector:4318"}, "stdout"},
+	}
+	for _, tt := range tests {
+		cfg := tt.cfg
+		cfg.setDefaultExporter()
+		if cfg.Exporter != tt.want {
+			t.Errorf("exporter for %+v = %q, want %q", tt.cfg, cfg.Exporter, tt.want)
+		}
+	}
+}
+
+func TestTenancyConfigValidate(t *testing.T) {
+	valid := TenancyConfig{Enabled: true, Header: "X-Tenant-Id", APIKeyHeader: "X-Api-Key", Tenants: map[string]string{"acme": "", "beta": "beta-key"}, MissingStatus: 401}
+	tests := []struct {
+		name   string
+		change func(c *TenancyConfig)
+		want   string // part of the error, empty when valid
+	}{
+		{"valid", func(c *TenancyConfig) {}, ""},
+		{"disabled without tenants", func(c *TenancyConfig) { *c = TenancyConfig{MissingStatus: 400} }, ""},
+		{"missing status", func(c *TenancyConfig) { c.MissingStatus = 403 }, "tenancy.missingStatus must be 400 or 401, got 403"},
+		{"no headers", func(c *TenancyConfig) { c.Header, c.APIKeyHeader = "", "" }, "tenancy.header or tenancy.apiKeyHeader must be set"},
+		{"no tenants", func(c *TenancyConfig) { c.Tenants = nil }, "tenancy.tenants must list at least one tenant"},
+		{"uppercase tenant", func(c *TenancyConfig) { c.Tenants = map[string]string{"Acme": ""} }, "tenancy.tenants.Acme: tenant IDs are 1 to 64 lowercase"},
+		{"separator in tenant", func(c *TenancyConfig) { c.Tenants = map[string]string{"acme:eu": ""} }, "tenancy.tenants.acme:eu: tenant IDs"},
+		{"unselectable tenant", func(c *TenancyConfig) { c.Header = "" }, "tenancy.tenants.acme has no API key and tenancy.header is empty"},
+		{"key without its header", func(c *TenancyConfig) { c.APIKeyHeader = "" }, "tenancy.tenants.beta has an API key but tenancy.apiKeyHeader is empty"},
+		{"shared key", func(c *TenancyConfig) { c.Tenants = map[string]string{"acme": "k", "beta": "k"} }, "tenancy.tenants.acme and tenancy.tenants.beta share an API key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			cfg.Tenants = map[string]string{"acme": "", "beta": "beta-key"}
+			tt.change(&cfg)
+			err := cfg.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   string // part of the error, empty when valid
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"mysql host missing", func(c *Config) { c.MySQL.Host = "" }, "mysql.host is required"},
+		{"mysql database missing", func(c *Config) { c.MySQL.Database = "" }, "mysql.database is required"},
+		{"mysql port", func(c *Config) { c.MySQL.Port = "70000" }, `mysql.port must be a port number between 1 and 65535, got "70000"`},
+		{"idle above open", func(c *Config) { c.MySQL.MaxIdleConns = 50 }, "mysql.maxIdleConns (50) cannot exceed mysql.maxOpenConns (25)"},
+		{"breaker without a trigger", func(c *Config) { c.MySQL.BreakerThreshold, c.MySQL.BreakerFailureRate = 0, 0 }, "mysql.breakerThreshold and mysql.breakerFailureRate cannot both be 0"},
+		{"redis port", func(c *Config) { c.Redis.Port = "redis" }, `redis.port must be a port number`},
+		{"redis db", func(c *Config) { c.Redis.DB = -1 }, "redis.db cannot be negative, got -1"},
+		{"negative TTL", func(c *Config) { c.Cache.AdTTL = -time.Second }, "cache.adTTL cannot be negative, got -1s"},
+		{"cache driver", func(c *Config) { c.Cache.Driver = "memcached" }, `cache.driver must be one of redis, memory or none, got "memcached"`},
+		{"hot refresh past the TTL", func(c *Config) { c.Cache.HotRefreshLead = 10 * time.Minute }, "cache.hotRefreshLead must be shorter than cache.adTTL"},
+		{"server port", func(c *Config) { c.Server.Port = "http" }, `server.port must be a port number between 1 and 65535, got "http"`},
+		{"server port zero", func(c *Config) { c.Server.Port = "0" }, `server.port must be a port number`},
+		{"shared ports", func(c *Config) { c.Server.InternalPort = "8080" }, "server.internalPort must differ from server.port, both are 8080"},
+		{"shutdown timeout", func(c *Config) { c.Server.ShutdownTimeout = 0 }, "server.shutdownTimeout must be positive, got 0s"},
+		{"sampler ratio", func(c *Config) { c.Tracing.SamplerRatio = 2 }, "tracing.samplerRatio must be between 0 and 1, got 2"},
+		{"otlp without endpoint", func(c *Config) { c.Tracing.Exporter = "otlp" }, "tracing.endpoint is required with the otlp exporter"},
+		{"unsorted buckets", func(c *Config) { c.Metrics.DBBuckets = []float64{0.1, 0.05, 1} }, "metrics.dbBuckets must be sorted in increasing order, 0.05 follows 0.1"},
+		{"duplicate bucket", func(c *Config) { c.Metrics.HTTPBuckets = []float64{0.1, 0.1} }, "metrics.httpBuckets must be sorted in increasing order"},
+		{"admin role", func(c *Config) { c.Auth.AdminRole = "" }, "auth.adminRole must not be empty when auth.roleHeader is set"},
+		{"tenancy without tenants", func(c *Config) { c.Tenancy.Enabled = true }, "tenancy.tenants must list at least one tenant"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			tt.change(&cfg)
+			err := cfg.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want no error", err)
+				}
+				return
+			}
+			var invalid *ValidationError
+			if !errors.As(err, &invalid) || len(invalid.Problems) != 1 || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want the one problem %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigValidateAggregatesProblems(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.MySQL.Host = ""
+	cfg.Redis.Port = "99999"
+	cfg.Server.Port = "-1"
+	cfg.Tracing.SamplerRatio = 1.5
+
+	// Every problem is reported at once, in section order, each with its key
+	want := `invalid configuration (4 problems):
+  - mysql.host is required
+  - redis.port must be a port number between 1 and 65535, got "99999"
+  - server.port must be a port number between 1 and 65535, got "-1"
+  - tracing.samplerRatio must be between 0 and 1, got 1.5`
+	if err := cfg.Validate(); err == nil || err.Error() != want {
+		t.Errorf("Validate() =\n%v\nwant\n%s", err, want)
+	}
+
+	// LoadConfig refuses the file with the same error
+	_, err := loadTestConfig(t, "mysql:\n  host: \"\"\nserver:\n  port: \"-1\"\n")
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || len(invalid.Problems) != 2 {
+		t.Errorf("LoadConfig = %v, want the two problems", err)
+	}
+}
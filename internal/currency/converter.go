@@ -0,0 +1,112 @@
+/*
+This file converts prices from the base currency for display. Prices are stored and sorted
+in the base currency; conversion only ever happens on read.
+*/
+package currency
+
+import (
+	"ad_service/internal/config"
+	"ad_service/pkg/cache"
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// ratesCacheKey holds the last rate table fetched from the provider
+var ratesCacheKey = cache.Key("currency", "rates")
+
+// Converter converts base currency amounts using rates from a Provider, cached in Redis
+type Converter struct {
+	Base     string
+	Provider Provider
+	Cache    cache.Cache   // optional, the provider is asked on every conversion when nil
+	TTL      time.Duration // how long a fetched rate table is cached
+	MaxAge   time.Duration // rate tables older than this are stale, 0 means they never are
+
+	supported map[string]bool
+}
+
+// NewConverter builds a Converter for the configured currencies. The supported currencies are
+// the base and the ones with a configured rate.
+func NewConverter(cfg config.CurrencyConfig, provider Provider, c cache.Cache) *Converter {
+	supported := map[string]bool{cfg.Base: true}
+	for code := range cfg.Rates {
+		supported[code] = true
+	}
+	return &Converter{Base: cfg.Base, Provider: provider, Cache: c, TTL: cfg.RateTTL, MaxAge: cfg.MaxRateAge, supported: supported}
+}
+
+// Supports reports whether prices can be requested in the currency
+func (c *Converter) Supports(code string) bool {
+	return c != nil && c.supported[code]
+}
+
+// Supported returns the supported currency codes in alphabetical order
+func (c *Converter) Supported() []string {
+	codes := make([]string, 0, len(c.supported))
+	for code := range c.supported {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Rate returns the units of code one unit of the base currency buys. It reports false when no
+// fresh rate is available, in which case prices are shown unconverted.
+func (c *Converter) Rate(code string, ctx context.Context) (float64, bool) {
+	if code == c.Base {
+		return 1, true
+	}
+
+	tracer := otel.Tracer("ad-service.currency")
+	ctx, span := tracer.Start(ctx, "CurrencyRate")
+	defer span.End()
+	span.SetAttributes(attribute.String("currency", code))
+
+	table, err := c.rates(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to load exchange rates")
+		return 0, false
+	}
+	if c.MaxAge > 0 && time.Since(table.AsOf) > c.MaxAge {
+		span.SetAttributes(attribute.String("rate_status", "stale"))
+		return 0, false
+	}
+	rate, ok := table.Rates[code]
+	if !ok || rate <= 0 {
+		span.SetAttributes(attribute.String("rate_status", "missing"))
+		return 0, false
+	}
+
+	span.SetAttributes(attribute.Float64("rate", rate), attribute.String("rate_status", "fresh"))
+	return rate, true
+}
+
+// rates returns the cached rate table, asking the provider and caching its answer on a miss
+func (c *Converter) rates(ctx context.Context) (RateTable, error) {
+	if c.Cache != nil {
+		if cached, err := c.Cache.Get(ratesCacheKey, ctx); err == nil && cached != "" {
+			var table RateTable
+			if err := json.Unmarshal([]byte(cached), &table); err == nil {
+				return table, nil
+			}
+		}
+	}
+
+	table, err := c.Provider.Rates(ctx)
+	if err != nil {
+		return RateTable{}, err
+	}
+	if c.Cache != nil {
+		if tableBytes, err := json.Marshal(table); err == nil {
+			c.Cache.Set(ratesCacheKey, string(tableBytes), c.TTL, ctx)
+		}
+	}
+	return table, nil
+}
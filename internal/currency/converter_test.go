@@ -0,0 +1,107 @@
+package currency
+
+import (
+	"ad_service/internal/config"
+	"ad_service/pkg/cache"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeProvider returns a fixed rate table or error and counts how often it was asked
+type fakeProvider struct {
+	table RateTable
+	err   error
+	calls int
+}
+
+func (p *fakeProvider) Rates(ctx context.Context) (RateTable, error) {
+	p.calls++
+	return p.table, p.err
+}
+
+func TestConverterRate(t *testing.T) {
+	fresh := RateTable{Rates: map[string]float64{"EUR": 0.92, "RUB": 0}, AsOf: time.Now().Add(-time.Minute)}
+	stale := RateTable{Rates: map[string]float64{"EUR": 0.92}, AsOf: time.Now().Add(-2 * time.Hour)}
+	tests := []struct {
+		name     string
+		provider *fakeProvider
+		maxAge   time.Duration
+		code     string
+		rate     float64
+		ok       bool
+	}{
+		{"base currency", &fakeProvider{err: errors.New("not asked")}, time.Hour, "USD", 1, true},
+		{"fresh rate", &fakeProvider{table: fresh}, time.Hour, "EUR", 0.92, true},
+		{"missing rate", &fakeProvider{table: fresh}, time.Hour, "GBP", 0, false},
+		{"zero rate", &fakeProvider{table: fresh}, time.Hour, "RUB", 0, false},
+		{"stale table", &fakeProvider{table: stale}, time.Hour, "EUR", 0, false},
+		{"stale table without a max age", &fakeProvider{table: stale}, 0, "EUR", 0.92, true},
+		{"provider failure", &fakeProvider{err: errors.New("rates service down")}, time.Hour, "EUR", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Converter{Base: "USD", Provider: tt.provider, MaxAge: tt.maxAge}
+			rate, ok := c.Rate(tt.code, context.Background())
+			if rate != tt.rate || ok != tt.ok {
+				t.Errorf("Rate(%s) = %v, %v; want %v, %v", tt.code, rate, ok, tt.rate, tt.ok)
+			}
+		})
+	}
+}
+
+func TestConverterCachesRateTable(t *testing.T) {
+	memory := cache.NewMemoryCache(time.Minute)
+	t.Cleanup(func() { memory.Close() })
+	provider := &fakeProvider{table: RateTable{Rates: map[string]float64{"EUR": 0.92, "GBP": 0.79}, AsOf: time.Now()}}
+	c := &Converter{Base: "USD", Provider: provider, Cache: memory, TTL: time.Minute, MaxAge: time.Hour}
+	ctx := context.Background()
+
+	if rate, ok := c.Rate("EUR", ctx); rate != 0.92 || !ok {
+		t.Fatalf("Rate(EUR) = %v, %v; want 0.92, true", rate, ok)
+	}
+	// Later lookups of any currency read the cached table
+	provider.table.Rates = map[string]float64{"EUR": 0.5}
+	if rate, ok := c.Rate("GBP", ctx); rate != 0.79 || !ok {
+		t.Errorf("Rate(GBP) = %v, %v; want 0.79, true from the cached table", rate, ok)
+	}
+	if rate, _ := c.Rate("EUR", ctx); rate != 0.92 {
+		t.Errorf("Rate(EUR) = %v, want 0.92 from the cached table", rate)
+	}
+	if provider.calls != 1 {
+		t.Errorf("provider asked %d times, want once", provider.calls)
+	}
+}
+
+func TestConverterRejectsStaleCachedTable(t *testing.T) {
+	memory := cache.NewMemoryCache(time.Minute)
+	t.Cleanup(func() { memory.Close() })
+	provider := &fakeProvider{table: RateTable{Rates: map[string]float64{"EUR": 0.92}, AsOf: time.Now().Add(-2 * time.Hour)}}
+	c := &Converter{Base: "USD", Provider: provider, Cache: memory, TTL: time.Minute, MaxAge: time.Hour}
+
+	// The table is cached as the provider dated it, so it stays stale when read back
+	for i := 0; i < 2; i++ {
+		if rate, ok := c.Rate("EUR", context.Background()); ok {
+			t.Errorf("lookup %d: Rate(EUR) = %v, true; want no rate from a stale table", i+1, rate)
+		}
+	}
+	if provider.calls != 1 {
+		t.Errorf("provider asked %d times, want once", provider.calls)
+	}
+}
+
+func TestNewConverterSupportedCurrencies(t *testing.T) {
+	c := NewConverter(config.CurrencyConfig{Base: "USD", Rates: map[string]float64{"EUR": 0.92, "GBP": 0.79}}, StaticProvider{}, nil)
+	if got, want := c.Supported(), []string{"EUR", "GBP", "USD"}; !slices.Equal(got, want) {
+		t.Errorf("Supported() = %v, want %v", got, want)
+	}
+	if c.Supports("JPY") {
+		t.Error("Supports(JPY) = true for a currency without a rate")
+	}
+	var none *Converter
+	if none.Supports("USD") {
+		t.Error("a nil Converter supports USD")
+	}
+}
@@ -0,0 +1,36 @@
+/*
+This file defines where exchange rates come from. Providers are asked for the whole rate
+table at once; the Converter caches it.
+*/
+package currency
+
+import (
+	"context"
+	"time"
+)
+
+// RateTable holds the units of each currency one unit of the base currency buys, as of a time
+type RateTable struct {
+	Rates map[string]float64 `json:"rates"`
+	AsOf  time.Time          `json:"as_of"`
+}
+
+// Provider supplies exchange rates relative to the base currency. An HTTP provider only has
+// to implement it to replace the static table.
+type Provider interface {
+	Rates(ctx context.Context) (RateTable, error)
+}
+
+// StaticProvider serves a fixed rate table, e.g. from config. Its rates never go stale.
+type StaticProvider struct {
+	Table map[string]float64
+}
+
+// Rates returns a copy of the table, dated now
+func (p StaticProvider) Rates(ctx context.Context) (RateTable, error) {
+	rates := make(map[string]float64, len(p.Table))
+	for code, rate := range p.Table {
+		rates[code] = rate
+	}
+	return RateTable{Rates: rates, AsOf: time.Now()}, nil
+}
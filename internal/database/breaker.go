@@ -0,0 +1,163 @@
+/*
+This file puts the MySQL circuit breaker between database/sql and the driver. Every round trip
+that runs SQL (queries, statements, prepares and transaction starts) asks the breaker first, so
+while MySQL is failing the whole service fails fast with breaker.ErrOpen instead of holding pool
+connections behind slow queries. Opening a connection is a round trip too. Pings on an open
+connection bypass the breaker, so /readyz keeps checking MySQL itself.
+*/
+package database
+
+import (
+	"ad_service/pkg/breaker"
+	"context"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// overloadErrors are the MySQL errors that mean the server is struggling rather than refusing
+// one query: too many connections, lock wait timeouts and max_execution_time interruptions.
+// Other server errors, such as a duplicate key, show MySQL is answering.
+var overloadErrors = map[uint16]bool{1040: true, 1203: true, 1205: true, 3024: true}
+
+// queryResult classifies the outcome of a round trip for the breaker
+func queryResult(err error, ctx context.Context) breaker.Result {
+	// ErrSkip only tells database/sql to take another path, which asks the breaker again
+	if errors.Is(err, driver.ErrSkip) {
+		return breaker.Ignored
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && !overloadErrors[mysqlErr.Number] {
+		return breaker.Success
+	}
+	return breaker.ResultOf(err, ctx)
+}
+
+// guard runs one round trip through the breaker
+func guard[T any](b *breaker.Breaker, ctx context.Context, call func() (T, error)) (T, error) {
+	done, err := b.Allow(ctx)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	result, err := call()
+	done(queryResult(err, ctx))
+	return result, err
+}
+
+// breakerConnector opens driver connections whose round trips go through the breaker
+type breakerConnector struct {
+	driver.Connector
+	breaker *breaker.Breaker
+}
+
+func (c breakerConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := guard(c.breaker, ctx, func() (driver.Conn, error) { return c.Connector.Connect(ctx) })
+	if err != nil {
+		return nil, err
+	}
+	return &breakerConn{Conn: conn, breaker: c.breaker}, nil
+}
+
+// breakerConn wraps a MySQL connection. The driver's connection implements every optional
+// interface forwarded here; the fallbacks only matter for other drivers.
+type breakerConn struct {
+	driver.Conn
+	breaker *breaker.Breaker
+}
+
+func (c *breakerConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := guard(c.breaker, ctx, func() (driver.Stmt, error) {
+		if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+			return preparer.PrepareContext(ctx, query)
+		}
+		return c.Conn.Prepare(query)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &breakerStmt{Stmt: stmt, breaker: c.breaker}, nil
+}
+
+func (c *breakerConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return guard(c.breaker, ctx, func() (driver.Tx, error) {
+		if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+			return beginner.BeginTx(ctx, opts)
+		}
+		return c.Conn.Begin()
+	})
+}
+
+func (c *breakerConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return guard(c.breaker, ctx, func() (driver.Rows, error) { return queryer.QueryContext(ctx, query, args) })
+}
+
+func (c *breakerConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return guard(c.breaker, ctx, func() (driver.Result, error) { return execer.ExecContext(ctx, query, args) })
+}
+
+func (c *breakerConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *breakerConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *breakerConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func (c *breakerConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// breakerStmt wraps a prepared statement, whose executions go through the breaker
+type breakerStmt struct {
+	driver.Stmt
+	breaker *breaker.Breaker
+}
+
+func (s *breakerStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return nil, errors.New("statement does not support QueryContext")
+	}
+	return guard(s.breaker, ctx, func() (driver.Rows, error) { return queryer.QueryContext(ctx, args) })
+}
+
+func (s *breakerStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		return nil, errors.New("statement does not support ExecContext")
+	}
+	return guard(s.breaker, ctx, func() (driver.Result, error) { return execer.ExecContext(ctx, args) })
+}
+
+func (s *breakerStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"ad_service/pkg/breaker"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+)
+
+// dsnConnector opens connections of a driver by DSN, like sql.Open does
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.driver.Open(c.dsn) }
+func (c dsnConnector) Driver() driver.Driver                        { return c.driver }
+
+// newBreakerDB returns a pool on a mocked MySQL behind a breaker with the given settings
+func newBreakerDB(t *testing.T, settings breaker.Settings) (*sql.DB, sqlmock.Sqlmock, *breaker.Breaker) {
+	t.Helper()
+	dsn := "breaker-" + t.Name()
+	mockDB, mock, err := sqlmock.NewWithDSN(dsn, sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := breaker.New(settings)
+	db := sql.OpenDB(breakerConnector{Connector: dsnConnector{dsn, mockDB.Driver()}, breaker: b})
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		db.Close()
+		mockDB.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return db, mock, b
+}
+
+func TestBreakerConnectorCycle(t *testing.T) {
+	db, mock, b := newBreakerDB(t, breaker.Settings{Name: "mysql-test", Threshold: 2, Cooldown: 50 * time.Millisecond, Probes: 1})
+	ctx := context.Background()
+	overloaded := &mysql.MySQLError{Number: 1040, Message: "Too many connections"}
+
+	// MySQL answering with an error about the query is still MySQL answering
+	mock.ExpectExec("INSERT INTO ads").WillReturnError(&mysql.MySQLError{Number: 1062})
+	mock.ExpectQuery("SELECT 1").WillReturnError(overloaded)
+	mock.ExpectExec("INSERT INTO ads").WillReturnError(&mysql.MySQLError{Number: 1062})
+	db.ExecContext(ctx, "INSERT INTO ads (title) VALUES (?)", "Bike")
+	db.QueryContext(ctx, "SELECT 1")
+	db.ExecContext(ctx, "INSERT INTO ads (title) VALUES (?)", "Bike")
+	if b.State() != breaker.Closed {
+		t.Fatalf("state = %s, want closed with query errors between the overloads", b.State())
+	}
+
+	// Two overloads in a row open it
+	mock.ExpectQuery("SELECT 1").WillReturnError(overloaded)
+	mock.ExpectExec("UPDATE ads").WillReturnError(&mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"})
+	db.QueryContext(ctx, "SELECT 1")
+	db.ExecContext(ctx, "UPDATE ads SET title = ?", "Bike")
+	if b.State() != breaker.Open {
+		t.Fatalf("state = %s after 2 overloads, want open", b.State())
+	}
+
+	// While open nothing reaches MySQL, except pings so readiness still sees it
+	if _, err := db.QueryContext(ctx, "SELECT 1"); !errors.Is(err, breaker.ErrOpen) {
+		t.Errorf("query while open = %v, want ErrOpen", err)
+	}
+	if _, err := db.BeginTx(ctx, nil); !errors.Is(err, breaker.ErrOpen) {
+		t.Errorf("BeginTx while open = %v, want ErrOpen", err)
+	}
+	mock.ExpectPing()
+	if err := db.PingContext(ctx); err != nil {
+		t.Errorf("ping while open = %v", err)
+	}
+
+	// After the cooldown a successful probe closes it
+	time.Sleep(60 * time.Millisecond)
+	if b.State() != breaker.HalfOpen {
+		t.Fatalf("state = %s after the cooldown, want half-open", b.State())
+	}
+	mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+	var one int
+	if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil || one != 1 {
+		t.Fatalf("probe = %d, %v", one, err)
+	}
+	if b.State() != breaker.Closed {
+		t.Errorf("state = %s after a successful probe, want closed", b.State())
+	}
+}
+
+func TestQueryResult(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		err  error
+		ctx  context.Context
+		want breaker.Result
+	}{
+		{"success", nil, context.Background(), breaker.Success},
+		{"skip", driver.ErrSkip, context.Background(), breaker.Ignored},
+		{"duplicate key", &mysql.MySQLError{Number: 1062}, context.Background(), breaker.Success},
+		{"syntax error", &mysql.MySQLError{Number: 1064}, context.Background(), breaker.Success},
+		{"too many connections", &mysql.MySQLError{Number: 1040}, context.Background(), breaker.Failure},
+		{"lock wait timeout", fmt.Errorf("could not update: %w", &mysql.MySQLError{Number: 1205}), context.Background(), breaker.Failure},
+		{"execution time", &mysql.MySQLError{Number: 3024}, context.Background(), breaker.Failure},
+		{"lost connection", mysql.ErrInvalidConn, context.Background(), breaker.Failure},
+		{"caller gave up", context.Canceled, canceled, breaker.Ignored},
+	}
+	for _, tt := range tests {
+		if got := queryResult(tt.err, tt.ctx); got != tt.want {
+			t.Errorf("%s: queryResult = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -2,33 +2,114 @@ package database
 
 import (
 	"ad_service/internal/config"
+	"ad_service/pkg/breaker"
+	"ad_service/pkg/retry"
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
+// Connect opens the connection pool, waits for MySQL and brings the schema up to date
 func Connect(cfg config.MySQLConfig) (*sql.DB, error) {
-
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Database)
-
-	db, err := sql.Open("mysql", dsn)
+	db, err := Open(cfg)
 	if err != nil {
 		return nil, err
 	}
-	if err := db.Ping(); err != nil {
+	if err := migrate(db, migrationsPath); err != nil {
+		db.Close()
 		return nil, err
 	}
-	migrationsPath := filepath.Join("internal", "database", "migrations", "init.sql")
-	if err := runInitSqlScript(db, migrationsPath); err != nil {
+
+	return db, nil
+}
+
+// Open opens the connection pool behind the MySQL circuit breaker and waits for MySQL to answer,
+// without touching the schema
+func Open(cfg config.MySQLConfig) (*sql.DB, error) {
+
+	// The session and the driver both work in UTC, so TIMESTAMP columns, NOW() and DATE() agree
+	// whatever zone the server or the host runs in
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true&loc=UTC&time_zone=%%27%%2B00%%3A00%%27", cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Database)
+
+	mysqlCfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := mysql.NewConnector(mysqlCfg)
+	if err != nil {
 		return nil, err
 	}
+	db := sql.OpenDB(breakerConnector{Connector: connector, breaker: breaker.New(breaker.Settings{
+		Name:        "mysql",
+		Threshold:   cfg.BreakerThreshold,
+		FailureRate: cfg.BreakerFailureRate,
+		MinRequests: cfg.BreakerMinRequests,
+		Window:      cfg.BreakerWindow,
+		Cooldown:    cfg.BreakerCooldown,
+		Probes:      cfg.BreakerProbes,
+	})})
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 
+	// MySQL often comes up after the service in docker-compose, so keep trying for a while
+	if err := retry.Do(retry.DefaultPolicy(), db.Ping, context.Background()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not reach MySQL: %w", err)
+	}
 	return db, nil
 }
 
+// migrationsPath is the schema applied by Connect, relative to the working directory
+var migrationsPath = filepath.Join("internal", "database", "migrations", "init.sql")
+
+// Migrate brings the schema up to date with init.sql of the repository checked out at root, for
+// callers that don't run from the repository root, like tests
+func Migrate(db *sql.DB, root string) error {
+	return migrate(db, filepath.Join(root, migrationsPath))
+}
+
+// migrate upgrades the tables that already exist first, so the tables init.sql creates can
+// reference their current columns, then runs init.sql for the missing tables and upgrades again
+// for the changes that needed them, such as foreign keys to a new table
+func migrate(db *sql.DB, filePath string) error {
+	ctx := context.Background()
+	if err := upgradeSchema(db, ctx); err != nil {
+		return err
+	}
+	if err := runInitSqlScript(db, filePath); err != nil {
+		return err
+	}
+	return upgradeSchema(db, ctx)
+}
+
+// schemaTables are the tables created by init.sql
+var schemaTables = []string{"campaigns", "ads", "ad_audit_log", "cache_purge_log", "favorites", "ad_reports", "ad_keywords", "ad_variants", "ad_translations", "search_outbox"}
+
+// MigrationPending reports whether the schema is out of date, i.e. Connect would create a table
+// or apply a schema change to an existing one. It fails when the migration file can't be read,
+// since Connect would fail too.
+func MigrationPending(db *sql.DB, ctx context.Context) (bool, error) {
+	if _, err := os.Stat(migrationsPath); err != nil {
+		return false, err
+	}
+	s, err := loadSchemaInfo(db, ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, table := range schemaTables {
+		if !s.tables[table] {
+			return true, nil
+		}
+	}
+	return len(pendingChanges(s)) > 0, nil
+}
+
 func runInitSqlScript(db *sql.DB, filePath string) error {
 	// Check if the file exists and read the SQL file
 	sqlBytes, err := os.ReadFile(filePath)
@@ -36,7 +117,14 @@ func runInitSqlScript(db *sql.DB, filePath string) error {
 		return err
 	}
 
-	// Execute the SQL commands
-	_, err = db.Exec(string(sqlBytes))
-	return err
+	// Execute the statements one by one, the driver runs a single statement per Exec
+	for _, statement := range strings.Split(string(sqlBytes), ";") {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+		if _, err := db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -1,8 +1,139 @@
-CREATE TABLE IF NOT EXISTS ads (
+CREATE TABLE IF NOT EXISTS campaigns (
     id INT AUTO_INCREMENT PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL DEFAULT 'default',
+    name VARCHAR(255) NOT NULL,
+    starts_at TIMESTAMP NULL,
+    ends_at TIMESTAMP NULL,
+    status ENUM('active', 'paused') NOT NULL DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_campaigns_tenant (tenant_id, id)
+);
+
+CREATE TABLE IF NOT EXISTS ads (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL DEFAULT 'default',
+    public_id CHAR(36) NULL,
+    slug VARCHAR(96) NULL,
     title VARCHAR(255) NOT NULL,
     description TEXT NOT NULL,
     price DECIMAL(10, 2) NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    is_active BOOLEAN DEFAULT FALSE
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    is_active BOOLEAN DEFAULT FALSE,
+    external_ref VARCHAR(255) NULL,
+    category VARCHAR(100) NOT NULL DEFAULT '',
+    expires_at TIMESTAMP NULL,
+    weight INT NOT NULL DEFAULT 1,
+    impressions BIGINT NOT NULL DEFAULT 0,
+    clicks BIGINT NOT NULL DEFAULT 0,
+    status ENUM('pending', 'approved', 'rejected') NOT NULL DEFAULT 'pending',
+    status_reason VARCHAR(500) NULL,
+    owner_id VARCHAR(255) NULL,
+    renewed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    renewal_count INT NOT NULL DEFAULT 0,
+    renewal_window_start TIMESTAMP NULL,
+    archived_at TIMESTAMP NULL,
+    favorites_count INT NOT NULL DEFAULT 0,
+    campaign_id INT NULL,
+    latitude DECIMAL(9, 6) NULL,
+    longitude DECIMAL(9, 6) NULL,
+    UNIQUE KEY uq_ads_external_ref (tenant_id, external_ref),
+    UNIQUE KEY uq_ads_public_id (public_id),
+    UNIQUE KEY uq_ads_slug (tenant_id, slug),
+    KEY idx_ads_tenant_status (tenant_id, status),
+    KEY idx_ads_category_price (category, price),
+    KEY idx_ads_price (price),
+    KEY idx_ads_title (title),
+    KEY idx_ads_status (status),
+    KEY idx_ads_owner_id (owner_id),
+    KEY idx_ads_renewed_at (renewed_at),
+    KEY idx_ads_location (latitude, longitude),
+    FULLTEXT KEY ft_ads_title_description (title, description),
+    CONSTRAINT fk_ads_campaign FOREIGN KEY (campaign_id) REFERENCES campaigns (id)
+);
+
+CREATE TABLE IF NOT EXISTS ad_audit_log (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    ad_id BIGINT NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    actor VARCHAR(255) NOT NULL DEFAULT '',
+    detail VARCHAR(500) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_ad_audit_log_ad_id (ad_id, created_at)
+);
+
+CREATE TABLE IF NOT EXISTS cache_purge_log (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL DEFAULT 'default',
+    actor VARCHAR(255) NOT NULL,
+    mode VARCHAR(20) NOT NULL,
+    target VARCHAR(1000) NOT NULL DEFAULT '',
+    deleted INT NULL,
+    truncated BOOLEAN NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP NULL,
+    KEY idx_cache_purge_log_created_at (created_at)
+);
+
+CREATE TABLE IF NOT EXISTS favorites (
+    user_id VARCHAR(255) NOT NULL,
+    ad_id BIGINT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, ad_id),
+    KEY idx_favorites_user_created (user_id, created_at),
+    CONSTRAINT fk_favorites_ad FOREIGN KEY (ad_id) REFERENCES ads (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS ad_reports (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    ad_id BIGINT NOT NULL,
+    reporter_id VARCHAR(255) NOT NULL,
+    reason VARCHAR(50) NOT NULL,
+    comment VARCHAR(1000) NULL,
+    status ENUM('open', 'dismissed', 'taken_down') NOT NULL DEFAULT 'open',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP NULL,
+    resolved_by VARCHAR(255) NULL,
+    UNIQUE KEY uq_ad_reports_ad_reporter (ad_id, reporter_id),
+    KEY idx_ad_reports_status (status, created_at),
+    KEY idx_ad_reports_reporter (reporter_id, created_at),
+    CONSTRAINT fk_ad_reports_ad FOREIGN KEY (ad_id) REFERENCES ads (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS ad_keywords (
+    ad_id BIGINT NOT NULL,
+    keyword VARCHAR(50) NOT NULL,
+    PRIMARY KEY (ad_id, keyword),
+    KEY idx_ad_keywords_keyword (keyword, ad_id),
+    CONSTRAINT fk_ad_keywords_ad FOREIGN KEY (ad_id) REFERENCES ads (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS ad_variants (
+    ad_id BIGINT NOT NULL,
+    variant_key VARCHAR(50) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL,
+    weight INT NOT NULL DEFAULT 1,
+    impressions BIGINT NOT NULL DEFAULT 0,
+    clicks BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (ad_id, variant_key),
+    CONSTRAINT fk_ad_variants_ad FOREIGN KEY (ad_id) REFERENCES ads (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS ad_translations (
+    ad_id BIGINT NOT NULL,
+    locale VARCHAR(10) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL,
+    PRIMARY KEY (ad_id, locale),
+    KEY idx_ad_translations_locale_title (locale, title),
+    CONSTRAINT fk_ad_translations_ad FOREIGN KEY (ad_id) REFERENCES ads (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS search_outbox (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    tenant_id VARCHAR(64) NOT NULL,
+    ad_id BIGINT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -0,0 +1,296 @@
+/*
+This file upgrades databases created by an older init.sql. init.sql only creates the tables that
+don't exist, so the columns, keys and types added since never reach an existing ads table on
+their own. Each schemaChange below inspects information_schema and applies itself only when the
+database lacks it, which keeps every startup idempotent whatever version the schema was created
+with. Changes are listed in the order the schema evolved; a new column of an existing table needs
+an entry here as well as in init.sql.
+*/
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// schemaInfo is what information_schema knows about the current database
+type schemaInfo struct {
+	tables      map[string]bool
+	columnTypes map[string]string   // "table.column" -> DATA_TYPE, e.g. int or bigint
+	indexes     map[string][]string // "table.index" -> columns in key order
+	foreignKeys map[string]bool     // "table.constraint"
+}
+
+// loadSchemaInfo reads the tables, columns, indexes and foreign keys of the current database
+func loadSchemaInfo(db *sql.DB, ctx context.Context) (*schemaInfo, error) {
+	s := &schemaInfo{
+		tables:      map[string]bool{},
+		columnTypes: map[string]string{},
+		indexes:     map[string][]string{},
+		foreignKeys: map[string]bool{},
+	}
+	queries := []struct {
+		query string
+		scan  func(rows *sql.Rows) error
+	}{
+		{"SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE()", func(rows *sql.Rows) error {
+			var table string
+			err := rows.Scan(&table)
+			s.tables[table] = true
+			return err
+		}},
+		{"SELECT table_name, column_name, data_type FROM information_schema.columns WHERE table_schema = DATABASE()", func(rows *sql.Rows) error {
+			var table, column, dataType string
+			err := rows.Scan(&table, &column, &dataType)
+			s.columnTypes[table+"."+column] = strings.ToLower(dataType)
+			return err
+		}},
+		{"SELECT table_name, index_name, column_name FROM information_schema.statistics WHERE table_schema = DATABASE() ORDER BY table_name, index_name, seq_in_index", func(rows *sql.Rows) error {
+			var table, index, column string
+			err := rows.Scan(&table, &index, &column)
+			s.indexes[table+"."+index] = append(s.indexes[table+"."+index], column)
+			return err
+		}},
+		{"SELECT table_name, constraint_name FROM information_schema.table_constraints WHERE table_schema = DATABASE() AND constraint_type = 'FOREIGN KEY'", func(rows *sql.Rows) error {
+			var table, constraint string
+			err := rows.Scan(&table, &constraint)
+			s.foreignKeys[table+"."+constraint] = true
+			return err
+		}},
+	}
+	for _, q := range queries {
+		rows, err := db.QueryContext(ctx, q.query)
+		if err != nil {
+			return nil, fmt.Errorf("could not inspect the schema: %w", err)
+		}
+		for rows.Next() {
+			if err := q.scan(rows); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("could not inspect the schema: %w", err)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("could not inspect the schema: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// schemaChange is one step of the schema's history applied to an existing table
+type schemaChange struct {
+	Name     string
+	Table    string // the change is skipped while the table doesn't exist, init.sql creates it current
+	Requires string // another table the change needs, e.g. the one a foreign key references
+	// Pending reports whether the database lacks the change
+	Pending func(s *schemaInfo) bool
+	// Statements apply the change
+	Statements func(s *schemaInfo) []string
+}
+
+// applicable reports whether the change can and must be applied to the database
+func (c schemaChange) applicable(s *schemaInfo) bool {
+	if !s.tables[c.Table] || (c.Requires != "" && !s.tables[c.Requires]) {
+		return false
+	}
+	return c.Pending(s)
+}
+
+// addColumn adds a column, then runs backfill to give the existing rows their value
+func addColumn(table, column, definition string, backfill ...string) schemaChange {
+	return schemaChange{
+		Name:  "column " + table + "." + column,
+		Table: table,
+		Pending: func(s *schemaInfo) bool {
+			_, ok := s.columnTypes[table+"."+column]
+			return !ok
+		},
+		Statements: func(*schemaInfo) []string {
+			return append([]string{"ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition}, backfill...)
+		},
+	}
+}
+
+// addIndex adds an index on columns (comma-separated, no spaces), replacing an index of the same
+// name on other columns. kind is KEY, UNIQUE KEY or FULLTEXT KEY.
+func addIndex(table, kind, name, columns string) schemaChange {
+	return schemaChange{
+		Name:  "index " + table + "." + name,
+		Table: table,
+		Pending: func(s *schemaInfo) bool {
+			return strings.Join(s.indexes[table+"."+name], ",") != columns
+		},
+		Statements: func(s *schemaInfo) []string {
+			add := "ALTER TABLE " + table + " ADD " + kind + " " + name + " (" + strings.ReplaceAll(columns, ",", ", ") + ")"
+			if _, ok := s.indexes[table+"."+name]; ok {
+				return []string{"ALTER TABLE " + table + " DROP INDEX " + name, add}
+			}
+			return []string{add}
+		},
+	}
+}
+
+// dropIndex drops an index a later version replaced
+func dropIndex(table, name string) schemaChange {
+	return schemaChange{
+		Name:  "drop index " + table + "." + name,
+		Table: table,
+		Pending: func(s *schemaInfo) bool {
+			_, ok := s.indexes[table+"."+name]
+			return ok
+		},
+		Statements: func(*schemaInfo) []string {
+			return []string{"ALTER TABLE " + table + " DROP INDEX " + name}
+		},
+	}
+}
+
+// addForeignKey adds a foreign key constraint to table referencing another table
+func addForeignKey(table, name, references, definition string) schemaChange {
+	return schemaChange{
+		Name:     "foreign key " + table + "." + name,
+		Table:    table,
+		Requires: references,
+		Pending: func(s *schemaInfo) bool {
+			return !s.foreignKeys[table+"."+name]
+		},
+		Statements: func(*schemaInfo) []string {
+			return []string{"ALTER TABLE " + table + " ADD CONSTRAINT " + name + " " + definition}
+		},
+	}
+}
+
+// adChildTables reference ads.id, with the foreign key each one has on it
+var adChildTables = []struct {
+	table      string
+	foreignKey string // empty when the table has none
+}{
+	{"ad_audit_log", ""},
+	{"favorites", "fk_favorites_ad"},
+	{"ad_reports", "fk_ad_reports_ad"},
+	{"ad_keywords", "fk_ad_keywords_ad"},
+	{"ad_variants", "fk_ad_variants_ad"},
+	{"ad_translations", "fk_ad_translations_ad"},
+}
+
+// widenAdIDs turns ads.id and every ad_id referencing it from INT into BIGINT. MySQL refuses to
+// change the type on either side of a foreign key, so the keys are dropped and added back.
+func widenAdIDs() schemaChange {
+	return schemaChange{
+		Name:  "bigint ads.id",
+		Table: "ads",
+		Pending: func(s *schemaInfo) bool {
+			if s.columnTypes["ads.id"] == "int" {
+				return true
+			}
+			for _, child := range adChildTables {
+				if s.columnTypes[child.table+".ad_id"] == "int" {
+					return true
+				}
+			}
+			return false
+		},
+		Statements: func(s *schemaInfo) []string {
+			var drop, modify, add []string
+			for _, child := range adChildTables {
+				if s.columnTypes[child.table+".ad_id"] != "int" {
+					continue
+				}
+				modify = append(modify, "ALTER TABLE "+child.table+" MODIFY ad_id BIGINT NOT NULL")
+				if child.foreignKey != "" && s.foreignKeys[child.table+"."+child.foreignKey] {
+					drop = append(drop, "ALTER TABLE "+child.table+" DROP FOREIGN KEY "+child.foreignKey)
+					add = append(add, "ALTER TABLE "+child.table+" ADD CONSTRAINT "+child.foreignKey+" FOREIGN KEY (ad_id) REFERENCES ads (id) ON DELETE CASCADE")
+				}
+			}
+			if s.columnTypes["ads.id"] == "int" {
+				modify = append([]string{"ALTER TABLE ads MODIFY id BIGINT AUTO_INCREMENT"}, modify...)
+			}
+			return append(append(drop, modify...), add...)
+		},
+	}
+}
+
+// schemaChanges is the history of init.sql since the first version, oldest first
+var schemaChanges = []schemaChange{
+	addColumn("ads", "external_ref", "VARCHAR(255) NULL"),
+	addColumn("ads", "category", "VARCHAR(100) NOT NULL DEFAULT ''"),
+	addColumn("ads", "expires_at", "TIMESTAMP NULL"),
+	addColumn("ads", "weight", "INT NOT NULL DEFAULT 1"),
+	addColumn("ads", "impressions", "BIGINT NOT NULL DEFAULT 0"),
+	addColumn("ads", "clicks", "BIGINT NOT NULL DEFAULT 0"),
+	addIndex("ads", "KEY", "idx_ads_title", "title"),
+	// Ads stored before moderation existed were already public, so they stay approved
+	addColumn("ads", "status", "ENUM('pending', 'approved', 'rejected') NOT NULL DEFAULT 'pending'", "UPDATE ads SET status = 'approved'"),
+	addColumn("ads", "status_reason", "VARCHAR(500) NULL"),
+	addColumn("ads", "owner_id", "VARCHAR(255) NULL"),
+	addIndex("ads", "KEY", "idx_ads_status", "status"),
+	addIndex("ads", "KEY", "idx_ads_owner_id", "owner_id"),
+	// Existing ads keep their place in the default sort instead of all jumping to the top
+	addColumn("ads", "renewed_at", "TIMESTAMP DEFAULT CURRENT_TIMESTAMP", "UPDATE ads SET renewed_at = created_at"),
+	addColumn("ads", "renewal_count", "INT NOT NULL DEFAULT 0"),
+	addColumn("ads", "renewal_window_start", "TIMESTAMP NULL"),
+	addIndex("ads", "KEY", "idx_ads_renewed_at", "renewed_at"),
+	addColumn("ads", "archived_at", "TIMESTAMP NULL"),
+	dropIndex("ads", "idx_ads_category"),
+	addIndex("ads", "KEY", "idx_ads_category_price", "category,price"),
+	addIndex("ads", "KEY", "idx_ads_price", "price"),
+	addColumn("ads", "favorites_count", "INT NOT NULL DEFAULT 0"),
+	addColumn("ads", "campaign_id", "INT NULL"),
+	addForeignKey("ads", "fk_ads_campaign", "campaigns", "FOREIGN KEY (campaign_id) REFERENCES campaigns (id)"),
+	addColumn("ads", "latitude", "DECIMAL(9, 6) NULL"),
+	addColumn("ads", "longitude", "DECIMAL(9, 6) NULL"),
+	addIndex("ads", "KEY", "idx_ads_location", "latitude,longitude"),
+	addColumn("ads", "updated_at", "TIMESTAMP DEFAULT CURRENT_TIMESTAMP", "UPDATE ads SET updated_at = created_at"),
+	widenAdIDs(),
+	addColumn("ads", "public_id", "CHAR(36) NULL", "UPDATE ads SET public_id = UUID() WHERE public_id IS NULL"),
+	addIndex("ads", "UNIQUE KEY", "uq_ads_public_id", "public_id"),
+	addColumn("ads", "slug", "VARCHAR(96) NULL"),
+	addColumn("ads", "tenant_id", "VARCHAR(64) NOT NULL DEFAULT 'default'"),
+	addIndex("ads", "UNIQUE KEY", "uq_ads_external_ref", "tenant_id,external_ref"),
+	addIndex("ads", "UNIQUE KEY", "uq_ads_slug", "tenant_id,slug"),
+	addIndex("ads", "KEY", "idx_ads_tenant_status", "tenant_id,status"),
+	addColumn("campaigns", "tenant_id", "VARCHAR(64) NOT NULL DEFAULT 'default'"),
+	addIndex("campaigns", "KEY", "idx_campaigns_tenant", "tenant_id,id"),
+	addColumn("cache_purge_log", "tenant_id", "VARCHAR(64) NOT NULL DEFAULT 'default'"),
+	addIndex("ads", "FULLTEXT KEY", "ft_ads_title_description", "title,description"),
+}
+
+// pendingChanges returns the changes the database lacks and can take now
+func pendingChanges(s *schemaInfo) []schemaChange {
+	var pending []schemaChange
+	for _, change := range schemaChanges {
+		if change.applicable(s) {
+			pending = append(pending, change)
+		}
+	}
+	return pending
+}
+
+// upgradeSchema applies the pending changes in order, reading the schema again after each one so
+// the next sees its result
+func upgradeSchema(db *sql.DB, ctx context.Context) error {
+	applied := map[string]bool{}
+	for {
+		s, err := loadSchemaInfo(db, ctx)
+		if err != nil {
+			return err
+		}
+		pending := pendingChanges(s)
+		if len(pending) == 0 {
+			return nil
+		}
+		change := pending[0]
+		// A change still pending after its statements ran would be applied forever
+		if applied[change.Name] {
+			return fmt.Errorf("schema change %s is still pending after being applied", change.Name)
+		}
+		applied[change.Name] = true
+		for _, statement := range change.Statements(s) {
+			if _, err := db.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("could not apply schema change %s: %w", change.Name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// createTable matches a CREATE TABLE statement of init.sql, capturing the table and its body
+var createTable = regexp.MustCompile(`(?s)CREATE TABLE IF NOT EXISTS (\w+) \((.*?)\n\);`)
+
+// keyLine matches an index definition inside CREATE TABLE
+var keyLine = regexp.MustCompile(`^(?:UNIQUE |FULLTEXT )?KEY (\w+) \(([^)]*)\)`)
+
+// constraintLine matches a foreign key definition inside CREATE TABLE
+var constraintLine = regexp.MustCompile(`^CONSTRAINT (\w+) FOREIGN KEY`)
+
+// mysqlTypes are the DATA_TYPE information_schema reports for the aliases init.sql uses
+var mysqlTypes = map[string]string{"boolean": "tinyint"}
+
+// initSQLSchema describes the database init.sql creates from scratch, the way loadSchemaInfo
+// would read it
+func initSQLSchema(t *testing.T) *schemaInfo {
+	t.Helper()
+	sqlBytes, err := os.ReadFile(filepath.Join("migrations", "init.sql"))
+	if err != nil {
+		t.Fatalf("could not read init.sql: %v", err)
+	}
+	s := &schemaInfo{tables: map[string]bool{}, columnTypes: map[string]string{}, indexes: map[string][]string{}, foreignKeys: map[string]bool{}}
+	for _, match := range createTable.FindAllStringSubmatch(string(sqlBytes), -1) {
+		table := match[1]
+		s.tables[table] = true
+		for _, line := range strings.Split(match[2], "\n") {
+			line = strings.TrimSuffix(strings.TrimSpace(line), ",")
+			switch {
+			case line == "", strings.HasPrefix(line, "PRIMARY KEY"):
+			case keyLine.MatchString(line):
+				key := keyLine.FindStringSubmatch(line)
+				s.indexes[table+"."+key[1]] = strings.Split(strings.ReplaceAll(key[2], " ", ""), ",")
+			case constraintLine.MatchString(line):
+				s.foreignKeys[table+"."+constraintLine.FindStringSubmatch(line)[1]] = true
+			default:
+				fields := strings.Fields(line)
+				dataType := strings.ToLower(strings.SplitN(fields[1], "(", 2)[0])
+				if alias, ok := mysqlTypes[dataType]; ok {
+					dataType = alias
+				}
+				s.columnTypes[table+"."+fields[0]] = dataType
+			}
+		}
+	}
+	if len(s.tables) == 0 {
+		t.Fatal("no CREATE TABLE found in init.sql")
+	}
+	return s
+}
+
+// baselineSchema is the database created by the first init.sql: a bare ads table
+func baselineSchema() *schemaInfo {
+	return &schemaInfo{
+		tables: map[string]bool{"ads": true},
+		columnTypes: map[string]string{
+			"ads.id": "int", "ads.title": "varchar", "ads.description": "text", "ads.price": "decimal",
+			"ads.created_at": "timestamp", "ads.is_active": "tinyint",
+		},
+		indexes:     map[string][]string{"ads.PRIMARY": {"id"}},
+		foreignKeys: map[string]bool{},
+	}
+}
+
+// changeNames returns the names of changes, for readable failures
+func changeNames(changes []schemaChange) []string {
+	names := make([]string, len(changes))
+	for i, change := range changes {
+		names[i] = change.Name
+	}
+	return names
+}
+
+func TestInitSQLHasNoPendingChanges(t *testing.T) {
+	s := initSQLSchema(t)
+	if pending := pendingChanges(s); len(pending) > 0 {
+		t.Errorf("a database created by init.sql should need no upgrade, pending: %v", changeNames(pending))
+	}
+	for _, table := range schemaTables {
+		if !s.tables[table] {
+			t.Errorf("schemaTables lists %s, which init.sql doesn't create", table)
+		}
+	}
+	for table := range s.tables {
+		if !slices.Contains(schemaTables, table) {
+			t.Errorf("init.sql creates %s, which schemaTables doesn't list", table)
+		}
+	}
+}
+
+func TestSchemaChangesCoverInitSQLColumns(t *testing.T) {
+	// Every column init.sql gives ads beyond the first version must be added to existing tables
+	current := initSQLSchema(t)
+	baseline := baselineSchema()
+	added := map[string]bool{}
+	for _, change := range schemaChanges {
+		if strings.HasPrefix(change.Name, "column ") {
+			added[strings.TrimPrefix(change.Name, "column ")] = true
+		}
+	}
+	for column := range current.columnTypes {
+		if !strings.HasPrefix(column, "ads.") {
+			continue
+		}
+		if _, ok := baseline.columnTypes[column]; !ok && !added[column] {
+			t.Errorf("init.sql has %s but no schema change adds it to an existing ads table", column)
+		}
+	}
+}
+
+func TestBaselineSchemaIsUpgraded(t *testing.T) {
+	s := baselineSchema()
+	pending := pendingChanges(s)
+	names := changeNames(pending)
+	for _, want := range []string{"column ads.external_ref", "column ads.status", "column ads.tenant_id", "bigint ads.id", "index ads.ft_ads_title_description"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("pending changes of the baseline schema should include %q, got %v", want, names)
+		}
+	}
+	// The campaigns table doesn't exist yet, init.sql creates it before the foreign key is added
+	if slices.Contains(names, "foreign key ads.fk_ads_campaign") {
+		t.Errorf("the campaign foreign key can't be added before campaigns exists")
+	}
+
+	// Columns that existing rows need a value for come with their backfill
+	for _, change := range pending {
+		if change.Name == "column ads.status" {
+			statements := change.Statements(s)
+			if len(statements) != 2 || statements[1] != "UPDATE ads SET status = 'approved'" {
+				t.Errorf("existing ads should stay public once moderation exists, got %v", statements)
+			}
+		}
+	}
+}
+
+func TestWidenAdIDsDropsAndRestoresForeignKeys(t *testing.T) {
+	s := baselineSchema()
+	s.tables["favorites"] = true
+	s.tables["ad_audit_log"] = true
+	s.columnTypes["favorites.ad_id"] = "int"
+	s.columnTypes["ad_audit_log.ad_id"] = "int"
+	s.foreignKeys["favorites.fk_favorites_ad"] = true
+
+	change := widenAdIDs()
+	if !change.applicable(s) {
+		t.Fatal("INT ad IDs should be widened")
+	}
+	want := []string{
+		"ALTER TABLE favorites DROP FOREIGN KEY fk_favorites_ad",
+		"ALTER TABLE ads MODIFY id BIGINT AUTO_INCREMENT",
+		"ALTER TABLE ad_audit_log MODIFY ad_id BIGINT NOT NULL",
+		"ALTER TABLE favorites MODIFY ad_id BIGINT NOT NULL",
+		"ALTER TABLE favorites ADD CONSTRAINT fk_favorites_ad FOREIGN KEY (ad_id) REFERENCES ads (id) ON DELETE CASCADE",
+	}
+	if got := change.Statements(s); !slices.Equal(got, want) {
+		t.Errorf("statements:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestAddIndexReplacesIndexOnOtherColumns(t *testing.T) {
+	s := baselineSchema()
+	s.columnTypes["ads.tenant_id"] = "varchar"
+	s.columnTypes["ads.external_ref"] = "varchar"
+	s.indexes["ads.uq_ads_external_ref"] = []string{"external_ref"}
+
+	change := addIndex("ads", "UNIQUE KEY", "uq_ads_external_ref", "tenant_id,external_ref")
+	if !change.applicable(s) {
+		t.Fatal("an index on other columns should be replaced")
+	}
+	want := []string{
+		"ALTER TABLE ads DROP INDEX uq_ads_external_ref",
+		"ALTER TABLE ads ADD UNIQUE KEY uq_ads_external_ref (tenant_id, external_ref)",
+	}
+	if got := change.Statements(s); !slices.Equal(got, want) {
+		t.Errorf("statements:\n got %q\nwant %q", got, want)
+	}
+
+	s.indexes["ads.uq_ads_external_ref"] = []string{"tenant_id", "external_ref"}
+	if change.applicable(s) {
+		t.Error("an index on the right columns should be left alone")
+	}
+}
+
+// expectSchema makes mock answer the information_schema queries of loadSchemaInfo with s
+func expectSchema(mock sqlmock.Sqlmock, s *schemaInfo) {
+	tables := sqlmock.NewRows([]string{"table_name"})
+	for table := range s.tables {
+		tables.AddRow(table)
+	}
+	columns := sqlmock.NewRows([]string{"table_name", "column_name", "data_type"})
+	for column, dataType := range s.columnTypes {
+		parts := strings.SplitN(column, ".", 2)
+		columns.AddRow(parts[0], parts[1], strings.ToUpper(dataType))
+	}
+	indexes := sqlmock.NewRows([]string{"table_name", "index_name", "column_name"})
+	for index, cols := range s.indexes {
+		parts := strings.SplitN(index, ".", 2)
+		for _, column := range cols {
+			indexes.AddRow(parts[0], parts[1], column)
+		}
+	}
+	foreignKeys := sqlmock.NewRows([]string{"table_name", "constraint_name"})
+	for constraint := range s.foreignKeys {
+		parts := strings.SplitN(constraint, ".", 2)
+		foreignKeys.AddRow(parts[0], parts[1])
+	}
+	mock.ExpectQuery("FROM information_schema.tables").WillReturnRows(tables)
+	mock.ExpectQuery("FROM information_schema.columns").WillReturnRows(columns)
+	mock.ExpectQuery("FROM information_schema.statistics").WillReturnRows(indexes)
+	mock.ExpectQuery("FROM information_schema.table_constraints").WillReturnRows(foreignKeys)
+}
+
+func TestMigrationPendingChecksColumns(t *testing.T) {
+	// MigrationPending looks for init.sql relative to the repository root, like the service
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := initSQLSchema(t)
+	if err := os.Chdir(filepath.Join("..", "..")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	tests := []struct {
+		name    string
+		modify  func(s *schemaInfo)
+		pending bool
+	}{
+		{"up to date", func(*schemaInfo) {}, false},
+		{"missing table", func(s *schemaInfo) { delete(s.tables, "search_outbox") }, true},
+		{"missing column", func(s *schemaInfo) { delete(s.columnTypes, "ads.tenant_id") }, true},
+		{"missing index", func(s *schemaInfo) { delete(s.indexes, "ads.ft_ads_title_description") }, true},
+		{"int ids", func(s *schemaInfo) { s.columnTypes["ads.id"] = "int" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			s := copySchema(current)
+			tt.modify(s)
+			expectSchema(mock, s)
+
+			pending, err := MigrationPending(db, context.Background())
+			if err != nil {
+				t.Fatalf("MigrationPending: %v", err)
+			}
+			if pending != tt.pending {
+				t.Errorf("pending = %v, want %v", pending, tt.pending)
+			}
+		})
+	}
+}
+
+// copySchema returns a deep copy of s
+func copySchema(s *schemaInfo) *schemaInfo {
+	c := &schemaInfo{tables: map[string]bool{}, columnTypes: map[string]string{}, indexes: map[string][]string{}, foreignKeys: map[string]bool{}}
+	for k, v := range s.tables {
+		c.tables[k] = v
+	}
+	for k, v := range s.columnTypes {
+		c.columnTypes[k] = v
+	}
+	for k, v := range s.indexes {
+		c.indexes[k] = slices.Clone(v)
+	}
+	for k, v := range s.foreignKeys {
+		c.foreignKeys[k] = v
+	}
+	return c
+}
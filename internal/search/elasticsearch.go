@@ -0,0 +1,376 @@
+/*
+This file talks to Elasticsearch, or OpenSearch which serves the same REST API, over plain HTTP.
+One index holds the ads of every tenant: documents are keyed "tenant:id" and every search is
+filtered on tenant_id, like the tenant condition of the SQL queries. Only the ads GET /ads lists
+publicly are indexed; a document holds what search matches, filters and sorts on, and results
+are loaded from the ad service by ID.
+*/
+package search
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/internal/config"
+	"ad_service/pkg/money"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCategoryFacets is how many categories a search reports counts for, the most frequent first
+const maxCategoryFacets = 20
+
+// MaxResultWindow is the deepest result a search can page to, Elasticsearch's default
+// index.max_result_window
+const MaxResultWindow = 10000
+
+// Highlight tags around the matched words of a snippet. Snippets are HTML-escaped, so the tags are
+// the only markup in them.
+const (
+	highlightPreTag  = "<em>"
+	highlightPostTag = "</em>"
+)
+
+// Document is an ad as stored in the index
+type Document struct {
+	TenantID    string       `json:"tenant_id"`
+	AdID        int64        `json:"ad_id"`
+	Title       string       `json:"title"`
+	Description string       `json:"description"`
+	Category    string       `json:"category,omitempty"`
+	Price       money.Amount `json:"price"`
+	CreatedAt   time.Time    `json:"created_at"`
+	RenewedAt   time.Time    `json:"renewed_at"`
+	// IndexedAt lets a reindex delete the documents it didn't rewrite
+	IndexedAt time.Time `json:"indexed_at"`
+}
+
+// NewDocument is the document of a tenant's ad, indexed at indexedAt
+func NewDocument(tenantID string, a *ad.Ad, indexedAt time.Time) Document {
+	return Document{
+		TenantID:    tenantID,
+		AdID:        a.ID,
+		Title:       a.Title,
+		Description: a.Description,
+		Category:    a.Category,
+		Price:       a.Price,
+		CreatedAt:   a.CreatedAt,
+		RenewedAt:   a.RenewedAt,
+		IndexedAt:   indexedAt,
+	}
+}
+
+// DocumentRef names the document of a tenant's ad
+type DocumentRef struct {
+	TenantID string
+	AdID     int64
+}
+
+// id is the document ID, unique across tenants
+func (r DocumentRef) id() string {
+	return r.TenantID + ":" + strconv.FormatInt(r.AdID, 10)
+}
+
+// Query is one page of a search within a tenant's ads
+type Query struct {
+	TenantID string
+	Text     string
+	Category string // narrows the hits but not the category facets, empty for every category
+	From     int
+	Size     int
+}
+
+// Hit is a matching ad, with the highlighted snippets of its matching fields by field name
+type Hit struct {
+	AdID       int64
+	Score      float64
+	Highlights map[string][]string
+}
+
+// Facet is the number of hits with one value of a field
+type Facet struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Hits is one page of hits, the total number of hits and their counts by category
+type Hits struct {
+	Total      int
+	Items      []Hit
+	Categories []Facet
+}
+
+// StatusError is a response of the cluster with an unexpected status
+type StatusError struct {
+	Status int
+	Body   string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("elasticsearch responded %d: %s", e.Status, e.Body)
+}
+
+// Client calls the search cluster of a SearchConfig
+type Client struct {
+	Config config.SearchConfig
+	HTTP   *http.Client
+}
+
+// NewClient returns a client of the cluster; each request is bounded by search.timeout
+func NewClient(cfg config.SearchConfig) *Client {
+	return &Client{Config: cfg, HTTP: &http.Client{}}
+}
+
+// do sends a request and decodes the JSON response into out unless it is nil. Statuses other
+// than 2xx are returned as a *StatusError.
+func (c *Client) do(method, path, contentType string, body io.Reader, out any, ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.Config.URL, "/")+path, body)
+	if err != nil {
+		return fmt.Errorf("could not build search request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Config.Username != "" {
+		req.SetBasicAuth(c.Config.Username, c.Config.Password)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach search cluster: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// The body names the failure, e.g. a mapping conflict; it is cut short for the logs
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &StatusError{Status: resp.StatusCode, Body: string(snippet)}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("could not decode search response: %w", err)
+	}
+	return nil
+}
+
+// doJSON sends a JSON body
+func (c *Client) doJSON(method, path string, body, out any, ctx context.Context) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("could not encode search request: %w", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	return c.do(method, path, "application/json", reader, out, ctx)
+}
+
+// indexPath is the path of the index followed by suffix
+func (c *Client) indexPath(suffix string) string {
+	return "/" + url.PathEscape(c.Config.Index) + suffix
+}
+
+// Ping checks that the cluster answers
+func (c *Client) Ping(ctx context.Context) error {
+	return c.do(http.MethodGet, "/", "", nil, nil, ctx)
+}
+
+// mapping is the index definition: the text fields use search.analyzer, the others are exact
+func (c *Client) mapping() map[string]any {
+	text := map[string]any{"type": "text", "analyzer": c.Config.Analyzer}
+	return map[string]any{
+		"mappings": map[string]any{
+			"dynamic": "strict",
+			"properties": map[string]any{
+				"tenant_id":   map[string]any{"type": "keyword"},
+				"ad_id":       map[string]any{"type": "long"},
+				"title":       text,
+				"description": text,
+				"category":    map[string]any{"type": "keyword"},
+				"price":       map[string]any{"type": "scaled_float", "scaling_factor": 100},
+				"created_at":  map[string]any{"type": "date"},
+				"renewed_at":  map[string]any{"type": "date"},
+				"indexed_at":  map[string]any{"type": "date"},
+			},
+		},
+	}
+}
+
+// EnsureIndex creates the index unless it exists. An existing index keeps its mapping, so a new
+// analyzer only applies to an index created after deleting the old one.
+func (c *Client) EnsureIndex(ctx context.Context) error {
+	err := c.do(http.MethodHead, c.indexPath(""), "", nil, nil, ctx)
+	var status *StatusError
+	if !errors.As(err, &status) || status.Status != http.StatusNotFound {
+		return err
+	}
+	if err := c.doJSON(http.MethodPut, c.indexPath(""), c.mapping(), nil, ctx); err != nil {
+		return fmt.Errorf("could not create index %s: %w", c.Config.Index, err)
+	}
+	return nil
+}
+
+// bulkResponse is the part of a _bulk response that reports failed items
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string          `json:"_id"`
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// Bulk writes docs and deletes the documents of removed in one request. Deleting a document that
+// isn't indexed succeeds; any other failed item fails the whole call, so it can be retried.
+func (c *Client) Bulk(docs []Document, removed []DocumentRef, ctx context.Context) error {
+	if len(docs) == 0 && len(removed) == 0 {
+		return nil
+	}
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, doc := range docs {
+		ref := DocumentRef{TenantID: doc.TenantID, AdID: doc.AdID}
+		encoder.Encode(map[string]any{"index": map[string]string{"_index": c.Config.Index, "_id": ref.id()}})
+		if err := encoder.Encode(doc); err != nil {
+			return fmt.Errorf("could not encode document %s: %w", ref.id(), err)
+		}
+	}
+	for _, ref := range removed {
+		encoder.Encode(map[string]any{"delete": map[string]string{"_index": c.Config.Index, "_id": ref.id()}})
+	}
+
+	var result bulkResponse
+	if err := c.do(http.MethodPost, "/_bulk", "application/x-ndjson", &body, &result, ctx); err != nil {
+		return fmt.Errorf("could not write search documents: %w", err)
+	}
+	if !result.Errors {
+		return nil
+	}
+	for _, item := range result.Items {
+		for action, outcome := range item {
+			if outcome.Status < 300 || action == "delete" && outcome.Status == http.StatusNotFound {
+				continue
+			}
+			return fmt.Errorf("could not %s search document %s: status %d: %s", action, outcome.ID, outcome.Status, outcome.Error)
+		}
+	}
+	return nil
+}
+
+// searchResponse is the part of a _search response the service reads
+type searchResponse struct {
+	Hits struct {
+		Total struct {
+			Value int `json:"value"`
+		} `json:"total"`
+		Hits []struct {
+			Score  float64 `json:"_score"`
+			Source struct {
+				AdID int64 `json:"ad_id"`
+			} `json:"_source"`
+			Highlight map[string][]string `json:"highlight"`
+		} `json:"hits"`
+	} `json:"hits"`
+	Aggregations struct {
+		Category struct {
+			Buckets []struct {
+				Key      string `json:"key"`
+				DocCount int    `json:"doc_count"`
+			} `json:"buckets"`
+		} `json:"category"`
+	} `json:"aggregations"`
+}
+
+// Search returns one page of the tenant's ads matching the query text in the title, weighted
+// double, or the description. Words match with typos within Elasticsearch's AUTO fuzziness. The
+// category is a post filter, so the facets still count every category of the matching ads.
+func (c *Client) Search(q Query, ctx context.Context) (*Hits, error) {
+	body := map[string]any{
+		"from":             q.From,
+		"size":             q.Size,
+		"track_total_hits": true,
+		"_source":          []string{"ad_id"},
+		"query": map[string]any{
+			"bool": map[string]any{
+				"must": map[string]any{
+					"multi_match": map[string]any{
+						"query":     q.Text,
+						"fields":    []string{"title^2", "description"},
+						"fuzziness": "AUTO",
+					},
+				},
+				"filter": []any{
+					map[string]any{"term": map[string]any{"tenant_id": q.TenantID}},
+				},
+			},
+		},
+		"aggs": map[string]any{
+			"category": map[string]any{"terms": map[string]any{"field": "category", "size": maxCategoryFacets}},
+		},
+		"highlight": map[string]any{
+			"encoder":   "html",
+			"pre_tags":  []string{highlightPreTag},
+			"post_tags": []string{highlightPostTag},
+			"fields": map[string]any{
+				"title":       map[string]any{"number_of_fragments": 0},
+				"description": map[string]any{"fragment_size": 150, "number_of_fragments": 2},
+			},
+		},
+	}
+	if q.Category != "" {
+		body["post_filter"] = map[string]any{"term": map[string]any{"category": q.Category}}
+	}
+
+	var result searchResponse
+	if err := c.doJSON(http.MethodPost, c.indexPath("/_search"), body, &result, ctx); err != nil {
+		return nil, fmt.Errorf("could not search ads: %w", err)
+	}
+
+	hits := &Hits{Total: result.Hits.Total.Value, Items: make([]Hit, len(result.Hits.Hits))}
+	for i, hit := range result.Hits.Hits {
+		hits.Items[i] = Hit{AdID: hit.Source.AdID, Score: hit.Score, Highlights: hit.Highlight}
+	}
+	for _, bucket := range result.Aggregations.Category.Buckets {
+		hits.Categories = append(hits.Categories, Facet{Value: bucket.Key, Count: bucket.DocCount})
+	}
+	return hits, nil
+}
+
+// Refresh makes the documents written so far visible to searches
+func (c *Client) Refresh(ctx context.Context) error {
+	return c.do(http.MethodPost, c.indexPath("/_refresh"), "", nil, nil, ctx)
+}
+
+// DeleteIndexedBefore deletes the tenant's documents indexed before a time and returns how many
+// there were. Documents rewritten meanwhile are skipped rather than failing the call.
+func (c *Client) DeleteIndexedBefore(tenantID string, before time.Time, ctx context.Context) (int, error) {
+	body := map[string]any{
+		"query": map[string]any{
+			"bool": map[string]any{
+				"filter": []any{
+					map[string]any{"term": map[string]any{"tenant_id": tenantID}},
+					map[string]any{"range": map[string]any{"indexed_at": map[string]any{"lt": before.UTC().Format(time.RFC3339Nano)}}},
+				},
+			},
+		},
+	}
+	var result struct {
+		Deleted int `json:"deleted"`
+	}
+	if err := c.doJSON(http.MethodPost, c.indexPath("/_delete_by_query?conflicts=proceed"), body, &result, ctx); err != nil {
+		return 0, fmt.Errorf("could not delete stale search documents: %w", err)
+	}
+	return result.Deleted, nil
+}
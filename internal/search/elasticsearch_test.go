@@ -0,0 +1,96 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// searchResponseBody is a _search response with two hits of three, highlights and category facets
+const searchResponseBody = `{
+	"hits": {
+		"total": {"value": 3, "relation": "eq"},
+		"hits": [
+			{"_id": "acme:7", "_score": 2.5, "_source": {"ad_id": 7}, "highlight": {"title": ["Mountain <em>bike</em>"], "description": ["A <em>bike</em>", "<em>Bike</em> lock"]}},
+			{"_id": "acme:8", "_score": 1.25, "_source": {"ad_id": 8}}
+		]
+	},
+	"aggregations": {
+		"category": {"buckets": [{"key": "sports", "doc_count": 2}, {"key": "kids", "doc_count": 1}]}
+	}
+}`
+
+func TestClientSearch(t *testing.T) {
+	client, cluster := newFakeCluster(t, func(clusterRequest) (int, string) {
+		return http.StatusOK, searchResponseBody
+	})
+
+	hits, err := client.Search(Query{TenantID: "acme", Text: "bike", Category: "sports", From: 10, Size: 10}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Hits{
+		Total: 3,
+		Items: []Hit{
+			{AdID: 7, Score: 2.5, Highlights: map[string][]string{"title": {"Mountain <em>bike</em>"}, "description": {"A <em>bike</em>", "<em>Bike</em> lock"}}},
+			{AdID: 8, Score: 1.25},
+		},
+		Categories: []Facet{{Value: "sports", Count: 2}, {Value: "kids", Count: 1}},
+	}
+	if !reflect.DeepEqual(hits, want) {
+		t.Errorf("Search = %+v, want %+v", hits, want)
+	}
+
+	// The tenant filters the query, the category only the hits so the facets count every category
+	received := cluster.requests()
+	if len(received) != 1 || received[0].Method != http.MethodPost || received[0].Path != "/ads/_search" {
+		t.Fatalf("requests = %+v, want one POST /ads/_search", received)
+	}
+	body := received[0].Body
+	if !contains(body, `"filter":[{"term":{"tenant_id":"acme"}}]`, `"post_filter":{"term":{"category":"sports"}}`, `"from":10`, `"size":10`, `"query":"bike"`) {
+		t.Errorf("search body = %s, want the tenant filter, the category post filter and the page", body)
+	}
+}
+
+func TestClientSearchFailure(t *testing.T) {
+	client, _ := newFakeCluster(t, func(clusterRequest) (int, string) {
+		return http.StatusServiceUnavailable, `{"error": "cluster_block_exception"}`
+	})
+
+	_, err := client.Search(Query{TenantID: "acme", Text: "bike", Size: 10}, context.Background())
+	var status *StatusError
+	if !errors.As(err, &status) || status.Status != http.StatusServiceUnavailable || !strings.Contains(status.Body, "cluster_block_exception") {
+		t.Errorf("Search error = %v, want a StatusError with the 503 and its body", err)
+	}
+}
+
+func TestClientBulk(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		fails    bool
+	}{
+		{"written", `{"errors": false, "items": []}`, false},
+		{"deleting a missing document", `{"errors": true, "items": [{"index": {"_id": "acme:7", "status": 201}}, {"delete": {"_id": "acme:8", "status": 404}}]}`, false},
+		{"rejected document", `{"errors": true, "items": [{"index": {"_id": "acme:7", "status": 400, "error": {"type": "mapper_parsing_exception"}}}, {"delete": {"_id": "acme:8", "status": 200}}]}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, cluster := newFakeCluster(t, func(clusterRequest) (int, string) {
+				return http.StatusOK, tt.response
+			})
+
+			err := client.Bulk([]Document{{TenantID: "acme", AdID: 7, Title: "Bike"}}, []DocumentRef{{TenantID: "acme", AdID: 8}}, context.Background())
+			if (err != nil) != tt.fails {
+				t.Errorf("Bulk error = %v, want failure %v", err, tt.fails)
+			}
+			body := cluster.requests()[0].Body
+			if !contains(body, `{"index":{"_id":"acme:7","_index":"ads"}}`, `"title":"Bike"`, `{"delete":{"_id":"acme:8","_index":"ads"}}`) {
+				t.Errorf("bulk body = %s, want the document of acme:7 and the delete of acme:8", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+/*
+This file contains the HTTP handler of GET /ads/search.
+*/
+package search
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/internal/config"
+	"ad_service/pkg/metrics"
+	"ad_service/pkg/pagination"
+	"ad_service/pkg/response"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxCategoryLength matches the ads.category column
+const maxCategoryLength = 100
+
+// Handler serves the ad search
+type Handler struct {
+	Service *SearchService
+	Paging  pagination.Policy // caps page sizes like on GET /ads
+}
+
+// hitResponse is an ad in the search results, with the highlighted snippets of its matching
+// fields by field name
+type hitResponse struct {
+	ad.AdResponse
+	Highlights map[string][]string `json:"highlights,omitempty"`
+}
+
+// SearchAds handles GET /ads/search, with tracing. The meta names the engine that answered and
+// sets degraded when Elasticsearch failed and MySQL answered without facets or highlights.
+// Expected URL: http://localhost:8080/ads/search?q=bike&category=sports&page=1&limit=10
+func (h *Handler) SearchAds(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "SearchAdsHandler")
+	defer span.End()
+
+	query := strings.TrimSpace(c.Query("q"))
+	if n := utf8.RuneCountInString(query); n < ad.MinSearchLength || n > ad.MaxSearchLength {
+		badRequest(c, span, "Invalid q value. Must be between "+strconv.Itoa(ad.MinSearchLength)+" and "+strconv.Itoa(ad.MaxSearchLength)+" characters.", fieldError{"q", "length"})
+		return
+	}
+	category := strings.TrimSpace(c.Query("category"))
+	if utf8.RuneCountInString(category) > maxCategoryLength {
+		badRequest(c, span, "Invalid category value. Must be at most "+strconv.Itoa(maxCategoryLength)+" characters.", fieldError{"category", "length"})
+		return
+	}
+	page, ok := h.pageParams(c, span)
+	if !ok {
+		return
+	}
+	// Elasticsearch pages no deeper than its result window; MySQL is held to the same bound
+	if page.Page*page.Limit > MaxResultWindow {
+		badRequest(c, span, "Invalid page value. Search results stop after "+strconv.Itoa(MaxResultWindow)+" ads.", fieldError{"page", "max_window"})
+		return
+	}
+
+	results, err := h.Service.Search(Request{Query: query, Category: category, Page: page.Page, Limit: page.Limit}, ctx)
+	if err != nil {
+		c.Error(err).SetMeta("Failed to search ads")
+		return
+	}
+
+	ads := ad.NewAdResponses(results.Ads, h.Service.Ads.Rules.Load().ExposeNumericIDs)
+	hits := make([]hitResponse, len(ads))
+	for i := range ads {
+		hits[i] = hitResponse{AdResponse: ads[i], Highlights: results.Highlights[results.Ads[i].ID]}
+	}
+	meta := page.Meta(len(hits))
+	meta["engine"] = results.Engine
+	meta["degraded"] = results.Degraded
+	if results.Total != nil {
+		meta["total"] = *results.Total
+	}
+	if results.Engine == config.SearchEngineElasticsearch {
+		categories := results.Categories
+		if categories == nil {
+			categories = []Facet{}
+		}
+		meta["facets"] = gin.H{"category": categories}
+	}
+
+	span.SetAttributes(attribute.String("status", "success"))
+	response.List(c, http.StatusOK, hits, meta)
+}
+
+// pageParams parses the page and limit parameters under the page size policy, answering 400
+// when they are invalid
+func (h *Handler) pageParams(c *gin.Context, span trace.Span) (pagination.Page, bool) {
+	page, err := h.Paging.Parse(c)
+	if err != nil {
+		badRequest(c, span, err.Message, fieldError{err.Field, err.Rule})
+		return page, false
+	}
+	if page.Clamped() {
+		span.SetAttributes(attribute.Int("requested_limit", page.Requested), attribute.Int("limit", page.Limit))
+	}
+	return page, true
+}
+
+// fieldError names a request field and the validation rule it broke
+type fieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+}
+
+// badRequest responds with a 400 listing the failed fields and counts them in the validation metric
+func badRequest(c *gin.Context, span trace.Span, message string, failures ...fieldError) {
+	fields := make([]string, len(failures))
+	rules := make([]string, len(failures))
+	for i, failure := range failures {
+		fields[i] = failure.Field
+		rules[i] = failure.Rule
+		metrics.ValidationFailures.WithLabelValues(c.FullPath(), failure.Field).Inc()
+	}
+	span.AddEvent("validation_failed", trace.WithAttributes(
+		attribute.StringSlice("validation.fields", fields),
+		attribute.StringSlice("validation.rules", rules),
+	))
+	response.ErrorCode(c, http.StatusBadRequest, response.CodeValidation, message, gin.H{"fields": failures})
+}
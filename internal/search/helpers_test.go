@@ -0,0 +1,157 @@
+package search
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/internal/config"
+	"ad_service/pkg/cache"
+	"ad_service/pkg/middleware"
+	"ad_service/pkg/pagination"
+	"ad_service/pkg/tenant"
+	"context"
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// testAdColumns are the columns of adColumns, as GetAdsByIDs and GetAllAds select them
+var testAdColumns = []string{
+	"id", "public_id", "slug", "title", "description", "price", "created_at", "is_active", "external_ref",
+	"category", "expires_at", "weight", "status", "status_reason", "owner_id", "renewed_at", "renewal_count",
+	"renewal_window_start", "archived_at", "favorites_count", "campaign_id", "latitude", "longitude",
+}
+
+// clusterRequest is a request the fake cluster received
+type clusterRequest struct {
+	Method string
+	Path   string
+	Body   string
+}
+
+// fakeCluster stands in for Elasticsearch, answering each request with respond
+type fakeCluster struct {
+	mu       sync.Mutex
+	received []clusterRequest
+	respond  func(req clusterRequest) (int, string)
+}
+
+// newFakeCluster starts a fake cluster and returns a client of it
+func newFakeCluster(t *testing.T, respond func(req clusterRequest) (int, string)) (*Client, *fakeCluster) {
+	t.Helper()
+	cluster := &fakeCluster{respond: respond}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req := clusterRequest{Method: r.Method, Path: r.URL.RequestURI(), Body: string(body)}
+		cluster.mu.Lock()
+		cluster.received = append(cluster.received, req)
+		cluster.mu.Unlock()
+
+		status, response := respond(req)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(server.Close)
+	return NewClient(config.SearchConfig{URL: server.URL, Index: "ads", Timeout: time.Second, Analyzer: "standard"}), cluster
+}
+
+// requests returns the requests received so far
+func (c *fakeCluster) requests() []clusterRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]clusterRequest(nil), c.received...)
+}
+
+// newTestService returns a search service on client, which is nil for search.engine mysql, with
+// the ad service on a mocked database and an in-memory cache. The mock must have met all its
+// expectations when the test ends.
+func newTestService(t *testing.T, client *Client) (*SearchService, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := cache.NewMemoryCache(time.Minute)
+	t.Cleanup(func() {
+		memory.Close()
+		db.Close()
+	})
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	ads := &ad.AdService{
+		Repo:  &ad.Repository{DB: db},
+		Cache: cache.NewTenantCache(memory),
+		TTL: config.NewReloadable(config.CacheConfig{
+			AdTTL: 5 * time.Minute, ListTTL: 30 * time.Second, CountTTL: time.Minute, NegativeTTL: 30 * time.Second,
+			LockTimeout: 5 * time.Second, LockWait: 500 * time.Millisecond,
+		}),
+		Rules: config.NewReloadable(config.AdsConfig{SearchEngine: ad.SearchLike, ExposeNumericIDs: true}),
+	}
+	return &SearchService{Ads: ads, Client: client}, mock
+}
+
+// newTestRouter serves GET /ads/search of service for the default tenant
+func newTestRouter(service *SearchService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.Errors(), middleware.Tenant(config.TenancyConfig{}))
+	h := &Handler{Service: service, Paging: pagination.Policy{MaxLimit: 100, Mode: pagination.ModeClamp}}
+	r.GET("/ads/search", h.SearchAds)
+	return r
+}
+
+// serve sends a GET request to r
+func serve(r http.Handler, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+// testCtx is a context of the default tenant
+func testCtx() context.Context {
+	return tenant.With(context.Background(), tenant.Default)
+}
+
+// adRow returns the row of an active ad with the given status
+func adRow(id int64, status string) []driver.Value {
+	created := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	return []driver.Value{
+		id, nil, nil, "Mountain bike", "Barely used", "120.00", created, true, nil,
+		"sports", nil, int64(ad.DefaultAdWeight), status, nil, nil, created, int64(0),
+		nil, nil, int64(0), nil, nil, nil,
+	}
+}
+
+// adRows returns rows of testAdColumns
+func adRows(rows ...[]driver.Value) *sqlmock.Rows {
+	result := sqlmock.NewRows(testAdColumns)
+	for _, row := range rows {
+		result.AddRow(row...)
+	}
+	return result
+}
+
+// expectNoTranslations expects the translations of loaded ads, finding none
+func expectNoTranslations(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("FROM ad_translations").WillReturnRows(sqlmock.NewRows([]string{"ad_id", "locale", "title", "description"}))
+}
+
+// contains reports whether s holds every fragment
+func contains(s string, fragments ...string) bool {
+	for _, fragment := range fragments {
+		if !strings.Contains(s, fragment) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+/*
+This file applies the ad changes queued in the search outbox to the index. Each round reads a
+batch of outbox rows, loads the current state of their ads from MySQL, indexes the searchable
+ones, deletes the documents of the others and then removes the rows. When the cluster is down
+the rows stay queued and the next run retries them, so writes never wait for search.
+*/
+package search
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/pkg/cache"
+	"ad_service/pkg/metrics"
+	"ad_service/pkg/scheduler"
+	"ad_service/pkg/tenant"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// indexLockName names the cache lock held by the replica running the indexer
+const indexLockName = "search_indexer"
+
+// Indexer drains the search outbox into the index
+type Indexer struct {
+	Repo      *ad.Repository
+	Client    *Client
+	Cache     cache.Cache // holds the job lock, so one replica indexes at a time
+	BatchSize int         // outbox rows per round
+	// ready is set once the index is known to exist, so documents are never written into an
+	// index Elasticsearch would create on the fly without the mapping
+	ready bool
+}
+
+// Job is the job running the indexer every interval. Like the expiry job, each run holds a
+// cache lock and gives up after one interval.
+func (i *Indexer) Job(interval time.Duration) scheduler.Job {
+	return scheduler.Job{
+		Name:     "index_ads",
+		Interval: interval,
+		Timeout:  interval,
+		Run: func(ctx context.Context) error {
+			return i.run(interval, ctx)
+		},
+		Backlog: i.Repo.CountSearchUpdates,
+	}
+}
+
+// run makes one run of the indexer and records its outcome
+func (i *Indexer) run(interval time.Duration, ctx context.Context) error {
+	lock, err := cache.AcquireLock(i.Cache, indexLockName, interval, 0, ctx)
+	if errors.Is(err, cache.ErrLockNotAcquired) {
+		metrics.AdSearchIndexRuns.WithLabelValues("skipped").Inc()
+		return scheduler.ErrSkipped
+	}
+	if err != nil {
+		metrics.AdSearchIndexRuns.WithLabelValues("error").Inc()
+		return fmt.Errorf("could not lock the search indexer: %w", err)
+	}
+	// The run context may be the one that timed out, the lock is released regardless
+	defer lock.Release(context.WithoutCancel(ctx))
+
+	if !i.ready {
+		if err := i.Client.EnsureIndex(ctx); err != nil {
+			metrics.AdSearchIndexRuns.WithLabelValues("error").Inc()
+			return fmt.Errorf("could not prepare the search index: %w", err)
+		}
+		i.ready = true
+	}
+
+	applied, err := i.Drain(ctx)
+	if err != nil {
+		metrics.AdSearchIndexRuns.WithLabelValues("error").Inc()
+		return fmt.Errorf("could not index ads, %d changes applied before the error: %w", applied, err)
+	}
+	metrics.AdSearchIndexRuns.WithLabelValues("success").Inc()
+	if applied > 0 {
+		slog.InfoContext(ctx, "Applied ad changes to the search index", "count", applied)
+	}
+	return nil
+}
+
+// Drain applies the queued changes BatchSize at a time until the outbox is empty, with tracing.
+// It returns how many outbox rows were applied, including those of the rounds before an error.
+func (i *Indexer) Drain(ctx context.Context) (int, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "DrainSearchOutbox")
+	defer span.End()
+
+	total := 0
+	for {
+		entries, err := i.Repo.PendingSearchUpdates(i.BatchSize, ctx)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to read search outbox")
+			return total, err
+		}
+		if len(entries) == 0 {
+			break
+		}
+		if err := i.apply(entries, ctx); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Failed to apply search updates")
+			return total, err
+		}
+		total += len(entries)
+		// A short batch means the outbox is drained
+		if len(entries) < i.BatchSize {
+			break
+		}
+	}
+
+	span.SetAttributes(attribute.Int("entries_applied", total))
+	return total, nil
+}
+
+// apply writes the documents of one batch of outbox rows and removes the rows. An ad queued
+// several times is loaded and written once.
+func (i *Indexer) apply(entries []ad.OutboxEntry, ctx context.Context) error {
+	byTenant := make(map[string][]int64)
+	queued := make(map[DocumentRef]bool, len(entries))
+	ackIDs := make([]int64, len(entries))
+	for n, entry := range entries {
+		ackIDs[n] = entry.ID
+		ref := DocumentRef{TenantID: entry.TenantID, AdID: entry.AdID}
+		if !queued[ref] {
+			queued[ref] = true
+			byTenant[entry.TenantID] = append(byTenant[entry.TenantID], entry.AdID)
+		}
+	}
+
+	now := time.Now().UTC()
+	var docs []Document
+	var removed []DocumentRef
+	for tenantID, ids := range byTenant {
+		ads, err := i.Repo.GetAdsByIDs(ids, tenant.With(ctx, tenantID))
+		if err != nil {
+			return err
+		}
+		// Deleted ads aren't returned, so whatever isn't indexed here is removed
+		indexed := make(map[int64]bool, len(ads))
+		for n := range ads {
+			if ads[n].Searchable() {
+				docs = append(docs, NewDocument(tenantID, &ads[n], now))
+				indexed[ads[n].ID] = true
+			}
+		}
+		for _, id := range ids {
+			if !indexed[id] {
+				removed = append(removed, DocumentRef{TenantID: tenantID, AdID: id})
+			}
+		}
+	}
+
+	if err := i.Client.Bulk(docs, removed, ctx); err != nil {
+		return err
+	}
+	metrics.AdSearchDocuments.WithLabelValues("index").Add(float64(len(docs)))
+	metrics.AdSearchDocuments.WithLabelValues("delete").Add(float64(len(removed)))
+	return i.Repo.AckSearchUpdates(ackIDs, ctx)
+}
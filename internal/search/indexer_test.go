@@ -0,0 +1,72 @@
+package search
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/pkg/metrics"
+	"context"
+	"net/http"
+	"regexp"
+	"sync/atomic"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// expectOutboxBatch expects a round of the indexer: acme's ad 7, queued twice, is approved and
+// beta's ad 8 is gone
+func expectOutboxBatch(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, tenant_id, ad_id FROM search_outbox ORDER BY id LIMIT ?")).WithArgs(10).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "tenant_id", "ad_id"}).AddRow(int64(1), "acme", int64(7)).AddRow(int64(2), "acme", int64(7)).AddRow(int64(3), "beta", int64(8)))
+	mock.ExpectQuery(`FROM ads WHERE tenant_id = \? AND id IN \(\?\)`).WithArgs("acme", int64(7)).WillReturnRows(adRows(adRow(7, ad.StatusApproved)))
+	expectNoTranslations(mock)
+	mock.ExpectQuery(`FROM ads WHERE tenant_id = \? AND id IN \(\?\)`).WithArgs("beta", int64(8)).WillReturnRows(adRows())
+}
+
+func TestDrainRetriesUntilTheClusterAccepts(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	client, cluster := newFakeCluster(t, func(clusterRequest) (int, string) {
+		if down.Load() {
+			return http.StatusServiceUnavailable, `{"error": "cluster unavailable"}`
+		}
+		return http.StatusOK, `{"errors": false, "items": []}`
+	})
+	service, mock := newTestService(t, client)
+	// The tenants of a batch are loaded in map order
+	mock.MatchExpectationsInOrder(false)
+	indexer := &Indexer{Repo: service.Ads.Repo, Client: client, BatchSize: 10}
+	indexed := testutil.ToFloat64(metrics.AdSearchDocuments.WithLabelValues("index"))
+
+	// While the cluster is down the outbox rows are kept
+	expectOutboxBatch(mock)
+	if applied, err := indexer.Drain(context.Background()); err == nil || applied != 0 {
+		t.Fatalf("Drain = %d, %v; want an error with nothing applied", applied, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next run sends the same batch and acknowledges it
+	down.Store(false)
+	expectOutboxBatch(mock)
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM search_outbox WHERE id IN (?, ?, ?)")).WithArgs(int64(1), int64(2), int64(3)).WillReturnResult(sqlmock.NewResult(0, 3))
+	if applied, err := indexer.Drain(context.Background()); err != nil || applied != 3 {
+		t.Fatalf("Drain = %d, %v; want the 3 rows applied", applied, err)
+	}
+
+	received := cluster.requests()
+	if len(received) != 2 || received[0].Path != "/_bulk" || received[1].Path != "/_bulk" {
+		t.Fatalf("cluster received %+v, want the bulk twice", received)
+	}
+	// The ad queued twice is written once, the missing one is deleted
+	if !contains(received[1].Body, `{"index":{"_id":"acme:7","_index":"ads"}}`, `{"delete":{"_id":"beta:8","_index":"ads"}}`) {
+		t.Errorf("bulk body = %s, want acme:7 indexed and beta:8 deleted", received[1].Body)
+	}
+	if got := len(regexp.MustCompile(`"_id":"acme:7"`).FindAllString(received[1].Body, -1)); got != 1 {
+		t.Errorf("acme:7 written %d times, want once", got)
+	}
+	if got := testutil.ToFloat64(metrics.AdSearchDocuments.WithLabelValues("index")) - indexed; got != 1 {
+		t.Errorf("ad_search_documents_total{index} grew by %v, want 1", got)
+	}
+}
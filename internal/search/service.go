@@ -0,0 +1,157 @@
+/*
+This file holds the business logic of GET /ads/search. With search.engine elasticsearch the index
+finds the matching ads, which are then loaded through the ad service so results carry the same
+fields and caching as GET /ads. When the cluster fails, the search is answered by the MySQL text
+search of GET /ads?q= instead and flagged degraded: a page of ads without facets or highlights.
+*/
+package search
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/internal/config"
+	"ad_service/pkg/metrics"
+	"ad_service/pkg/tenant"
+	"context"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SearchService answers ad searches from the index or MySQL
+type SearchService struct {
+	Ads    *ad.AdService
+	Client *Client // nil with search.engine mysql
+}
+
+// Request is one page of a search
+type Request struct {
+	Query    string
+	Category string // empty for every category
+	Page     int
+	Limit    int
+}
+
+// Results is one page of matching ads. Highlights, Categories and Total are only known to
+// Elasticsearch, so they are empty when MySQL answered.
+type Results struct {
+	Ads        []ad.Ad
+	Highlights map[int64]map[string][]string // snippets by ad ID, then field
+	Categories []Facet
+	Total      *int
+	Engine     string // the engine that answered: mysql or elasticsearch
+	Degraded   bool   // Elasticsearch is configured but failed, MySQL answered instead
+}
+
+// Search returns a page of the ads matching the request, with tracing
+func (s *SearchService) Search(req Request, ctx context.Context) (*Results, error) {
+	tracer := otel.Tracer("ad-service.service")
+	ctx, span := tracer.Start(ctx, "SearchAdsService")
+	defer span.End()
+
+	if s.Client != nil {
+		results, err := s.searchIndex(req, ctx)
+		if err == nil {
+			s.record(results, span)
+			return results, nil
+		}
+		// A request its client gave up on isn't worth a second query
+		if ctx.Err() != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		span.RecordError(err)
+		span.AddEvent("search_fallback")
+	}
+
+	results, err := s.searchMySQL(req, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to search ads")
+		return nil, err
+	}
+	results.Degraded = s.Client != nil
+	s.record(results, span)
+	return results, nil
+}
+
+// record counts the search by engine and adds its outcome to the span
+func (s *SearchService) record(results *Results, span trace.Span) {
+	metrics.AdSearchRequests.WithLabelValues(results.Engine, strconv.FormatBool(results.Degraded)).Inc()
+	span.SetAttributes(
+		attribute.String("search.engine", results.Engine),
+		attribute.Bool("search.degraded", results.Degraded),
+		attribute.Int("ads_count", len(results.Ads)),
+		attribute.String("status", "success"),
+	)
+}
+
+// searchIndex finds the ads in the index and loads them in rank order. Ads the index still
+// holds after they were deleted or taken off the listing are left out until the indexer catches up.
+func (s *SearchService) searchIndex(req Request, ctx context.Context) (*Results, error) {
+	tenantID, err := tenant.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hits, err := s.Client.Search(Query{
+		TenantID: tenantID,
+		Text:     req.Query,
+		Category: req.Category,
+		From:     (req.Page - 1) * req.Limit,
+		Size:     req.Limit,
+	}, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(hits.Items))
+	for i, hit := range hits.Items {
+		ids[i] = hit.AdID
+	}
+	ads, _, err := s.Ads.GetAdsByIDs(ids, ctx)
+	if err != nil {
+		return nil, err
+	}
+	loaded := make(map[int64]ad.Ad, len(ads))
+	for _, a := range ads {
+		loaded[a.ID] = a
+	}
+
+	results := &Results{
+		Ads:        make([]ad.Ad, 0, len(hits.Items)),
+		Highlights: make(map[int64]map[string][]string, len(hits.Items)),
+		Categories: hits.Categories,
+		Total:      &hits.Total,
+		Engine:     config.SearchEngineElasticsearch,
+	}
+	for _, hit := range hits.Items {
+		a, ok := loaded[hit.AdID]
+		if !ok || !a.Searchable() {
+			continue
+		}
+		score := hit.Score
+		a.Score = &score
+		results.Ads = append(results.Ads, a)
+		if len(hit.Highlights) > 0 {
+			results.Highlights[a.ID] = hit.Highlights
+		}
+	}
+	return results, nil
+}
+
+// searchMySQL answers the search like GET /ads?q=, most relevant first
+func (s *SearchService) searchMySQL(req Request, ctx context.Context) (*Results, error) {
+	ads, err := s.Ads.GetAllAds(ad.ListQuery{
+		Filter: s.Ads.PublicSearch(req.Query, req.Category),
+		SortBy: "relevance",
+		Order:  "desc",
+		Page:   req.Page,
+		Limit:  req.Limit,
+	}, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Results{Ads: ads, Engine: config.SearchEngineMySQL}, nil
+}
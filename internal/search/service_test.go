@@ -0,0 +1,131 @@
+package search
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/pkg/metrics"
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// searchPage is the part of a GET /ads/search response the tests read
+type searchPage struct {
+	Data []struct {
+		ID         int64               `json:"id"`
+		Highlights map[string][]string `json:"highlights"`
+	} `json:"data"`
+	Meta map[string]any `json:"meta"`
+}
+
+// decodePage decodes a GET /ads/search response
+func decodePage(t *testing.T, body []byte) searchPage {
+	t.Helper()
+	var page searchPage
+	if err := json.Unmarshal(body, &page); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+	return page
+}
+
+// searches reads the search counter of an engine
+func searches(engine, degraded string) float64 {
+	return testutil.ToFloat64(metrics.AdSearchRequests.WithLabelValues(engine, degraded))
+}
+
+func TestSearchFromIndex(t *testing.T) {
+	client, _ := newFakeCluster(t, func(clusterRequest) (int, string) {
+		return http.StatusOK, searchResponseBody
+	})
+	service, mock := newTestService(t, client)
+	// Ad 8 is still indexed but was rejected since, so it is left out
+	mock.ExpectQuery(`FROM ads WHERE tenant_id = \? AND id IN \(\?, \?\)`).WithArgs("default", int64(7), int64(8)).
+		WillReturnRows(adRows(adRow(7, ad.StatusApproved), adRow(8, ad.StatusRejected)))
+	expectNoTranslations(mock)
+	before := searches("elasticsearch", "false")
+
+	w := serve(newTestRouter(service), "/ads/search?q=bike")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /ads/search = %d %s, want 200", w.Code, w.Body)
+	}
+	page := decodePage(t, w.Body.Bytes())
+	if len(page.Data) != 1 || page.Data[0].ID != 7 {
+		t.Fatalf("results = %+v, want ad 7 only", page.Data)
+	}
+	if want := map[string][]string{"title": {"Mountain <em>bike</em>"}, "description": {"A <em>bike</em>", "<em>Bike</em> lock"}}; !reflect.DeepEqual(page.Data[0].Highlights, want) {
+		t.Errorf("highlights = %v, want %v", page.Data[0].Highlights, want)
+	}
+	facets := map[string]any{"category": []any{
+		map[string]any{"value": "sports", "count": float64(2)},
+		map[string]any{"value": "kids", "count": float64(1)},
+	}}
+	if page.Meta["engine"] != "elasticsearch" || page.Meta["degraded"] != false || page.Meta["total"] != float64(3) || !reflect.DeepEqual(page.Meta["facets"], facets) {
+		t.Errorf("meta = %v, want elasticsearch, not degraded, 3 in total and the category facets", page.Meta)
+	}
+	if got := searches("elasticsearch", "false") - before; got != 1 {
+		t.Errorf("ad_search_requests_total{elasticsearch,false} grew by %v, want 1", got)
+	}
+}
+
+func TestSearchFallsBackToMySQL(t *testing.T) {
+	client, cluster := newFakeCluster(t, func(clusterRequest) (int, string) {
+		return http.StatusServiceUnavailable, `{"error": "no shards available"}`
+	})
+	service, mock := newTestService(t, client)
+	mock.ExpectQuery("FROM ads WHERE").WillReturnRows(adRows(adRow(7, ad.StatusApproved), adRow(9, ad.StatusApproved)))
+	expectNoTranslations(mock)
+	before := searches("mysql", "true")
+
+	w := serve(newTestRouter(service), "/ads/search?q=bike")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /ads/search = %d %s, want 200", w.Code, w.Body)
+	}
+	if len(cluster.requests()) != 1 {
+		t.Errorf("cluster received %d requests, want the failed search", len(cluster.requests()))
+	}
+	page := decodePage(t, w.Body.Bytes())
+	if len(page.Data) != 2 || page.Data[0].ID != 7 || page.Data[1].ID != 9 {
+		t.Errorf("results = %+v, want ads 7 and 9 from MySQL", page.Data)
+	}
+	if page.Data[0].Highlights != nil {
+		t.Errorf("highlights = %v, want none from MySQL", page.Data[0].Highlights)
+	}
+	// MySQL knows neither the total nor the facets
+	if page.Meta["engine"] != "mysql" || page.Meta["degraded"] != true || page.Meta["total"] != nil || page.Meta["facets"] != nil {
+		t.Errorf("meta = %v, want mysql, degraded, without total or facets", page.Meta)
+	}
+	if got := searches("mysql", "true") - before; got != 1 {
+		t.Errorf("ad_search_requests_total{mysql,true} grew by %v, want 1", got)
+	}
+}
+
+func TestSearchWithoutIndexIsNotDegraded(t *testing.T) {
+	service, mock := newTestService(t, nil)
+	mock.ExpectQuery("FROM ads WHERE").WillReturnRows(adRows(adRow(7, ad.StatusApproved)))
+	expectNoTranslations(mock)
+
+	results, err := service.Search(Request{Query: "bike", Page: 1, Limit: 10}, testCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.Engine != "mysql" || results.Degraded || len(results.Ads) != 1 {
+		t.Errorf("Search = %s, degraded %v with %d ads; want mysql, not degraded, with ad 7", results.Engine, results.Degraded, len(results.Ads))
+	}
+}
+
+func TestSearchCanceledDoesNotFallBack(t *testing.T) {
+	ctx, cancel := context.WithCancel(testCtx())
+	client, _ := newFakeCluster(t, func(clusterRequest) (int, string) {
+		cancel()
+		return http.StatusOK, searchResponseBody
+	})
+	// No MySQL query is expected, which the mock checks
+	service, _ := newTestService(t, client)
+
+	if _, err := service.Search(Request{Query: "bike", Page: 1, Limit: 10}, ctx); err == nil {
+		t.Error("Search succeeded after its client went away")
+	}
+}
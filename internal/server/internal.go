@@ -0,0 +1,49 @@
+package server
+
+import (
+	"ad_service/pkg/ratelimit"
+	"context"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// readyTimeout bounds the dependency checks behind /readyz
+const readyTimeout = 2 * time.Second
+
+// NewInternalServer builds the server for operational endpoints, meant to be reachable only from
+// inside the cluster: /metrics, /healthz, /readyz and, when enabled, /debug/pprof. It uses a plain
+// ServeMux so none of the public Gin middleware applies.
+func NewInternalServer(addr string, metrics http.Handler, ready func(ctx context.Context) error, enablePprof bool) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+
+	// Liveness: the process is up and serving
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+
+	// Readiness: the dependencies needed to serve traffic are reachable
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+		if err := ready(ctx); err != nil {
+			ratelimit.SetRetryAfter(w.Header(), unavailableRetryAfter)
+			http.Error(w, "not ready: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ready"))
+	})
+
+	if enablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	return &http.Server{Addr: addr, Handler: mux}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		status     int
+		retryAfter string
+	}{
+		{"ready", nil, http.StatusOK, ""},
+		// An unready instance tells the load balancer when to ask again
+		{"not ready", errors.New("mysql: connection refused"), http.StatusServiceUnavailable, "5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready := func(ctx context.Context) error {
+				if _, ok := ctx.Deadline(); !ok {
+					t.Error("readiness check without a deadline")
+				}
+				return tt.err
+			}
+			srv := NewInternalServer(":0", http.NotFoundHandler(), ready, false)
+			w := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if w.Code != tt.status || w.Header().Get("Retry-After") != tt.retryAfter {
+				t.Errorf("/readyz = %d with Retry-After %q, want %d with %q", w.Code, w.Header().Get("Retry-After"), tt.status, tt.retryAfter)
+			}
+			if tt.err != nil && !strings.Contains(w.Body.String(), tt.err.Error()) {
+				t.Errorf("/readyz body = %q, want the failing dependency", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/internal/apperr"
+	"ad_service/internal/campaign"
+	"ad_service/internal/config"
+	"ad_service/internal/search"
+	"ad_service/internal/sitemap"
+	"ad_service/pkg/breaker"
+	"ad_service/pkg/middleware"
+	"ad_service/pkg/response"
+	"ad_service/pkg/version"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handlers are the handlers behind the public API routes
+type Handlers struct {
+	Ads       *ad.Handler
+	Campaigns *campaign.Handler
+	Sitemap   *sitemap.Handler
+	Search    *search.Handler
+}
+
+// errorMappings are the responses to the errors handlers pass to c.Error. The domain errors name
+// their kind and carry the message; the conflicts with a hint or their own code come before their
+// kind, and an open circuit breaker before the other unavailable errors it is wrapped in. The
+// daily quota's and the renewal limit's Retry-After is their reset time.
+var errorMappings = []middleware.ErrorMapping{
+	{Target: ad.ErrAdBusy, Status: http.StatusConflict, Message: "Ad is being modified by another request, try again"},
+	{Target: ad.ErrArchived, Status: http.StatusConflict, Message: "Archived ads can't be edited or renewed, unarchive it first"},
+	{Target: ad.ErrActiveQuota, Status: http.StatusConflict, Code: response.CodeQuotaExceeded},
+	{Target: ad.ErrDailyQuota, Status: http.StatusTooManyRequests, Code: response.CodeQuotaExceeded},
+	{Target: ad.ErrRenewalLimit, Status: http.StatusTooManyRequests},
+	{Target: apperr.ErrNotFound, Status: http.StatusNotFound},
+	{Target: apperr.ErrValidation, Status: http.StatusBadRequest, Code: response.CodeValidation},
+	{Target: apperr.ErrConflict, Status: http.StatusConflict},
+	{Target: apperr.ErrForbidden, Status: http.StatusForbidden},
+	{Target: breaker.ErrOpen, Status: http.StatusServiceUnavailable, Code: response.CodeDependencyUnavailable, RetryAfter: unavailableRetryAfter},
+	{Target: apperr.ErrUnavailable, Status: http.StatusServiceUnavailable, Message: "Service temporarily unavailable", RetryAfter: unavailableRetryAfter},
+}
+
+// unavailableRetryAfter is the Retry-After of 503s, long enough for a database or cache
+// failover instead of inviting an immediate retry storm
+const unavailableRetryAfter = 5 * time.Second
+
+// RegisterRoutes adds the public API routes to r, once unversioned and once under /v1. The /v1
+// routes always answer with the response envelope; with legacyResponses the unversioned ones keep
+// the shapes from before it. The middleware already on r, such as tracing and metrics, applies to
+// all of them; the error mapping, tenant and caller identity middleware are added here. /version
+// has no tenant and the sitemap no caller identity.
+func RegisterRoutes(r *gin.Engine, h Handlers, auth config.AuthConfig, tenancy config.TenancyConfig, legacyResponses bool) {
+	unversioned := r.Group("")
+	if legacyResponses {
+		unversioned.Use(response.Legacy())
+	}
+	unversioned.Use(middleware.Errors(errorMappings...))
+
+	// Every query and cache key is scoped to the tenant of the request
+	tenants := middleware.Tenant(tenancy)
+
+	// The sitemap is for crawlers, so it is only served unversioned and without caller identity
+	unversioned.GET("/sitemap.xml", tenants, h.Sitemap.GetSitemap)
+
+	// Identify the caller from the gateway headers for ownership and the admin endpoints
+	identity := middleware.Identity(auth.UserIDHeader, auth.RoleHeader, auth.AdminRole)
+	for _, group := range []*gin.RouterGroup{unversioned, r.Group("/v1", middleware.Errors(errorMappings...))} {
+		// Build information, the same version is reported as service.version on traces
+		group.GET("/version", func(c *gin.Context) {
+			response.Data(c, http.StatusOK, version.Get())
+		})
+		registerAPI(group.Group("", tenants, identity), h)
+	}
+}
+
+// registerAPI adds the API endpoints to api
+func registerAPI(api *gin.RouterGroup, h Handlers) {
+	// API Endpoints
+	api.POST("/ads", h.Ads.AddAd)
+	api.GET("/ads", h.Ads.GetAllAds)
+	api.GET("/ads/random", h.Ads.GetRandomAd)
+	api.GET("/ads/search", h.Search.SearchAds)
+	api.GET("/ads/serve", h.Ads.ServeAd)
+	api.GET("/ads/stats/daily", h.Ads.GetDailyStats)
+	api.GET("/ads/suggest", h.Ads.SuggestTitles)
+	api.GET("/ads/trending", h.Ads.GetTrendingAds)
+	api.GET("/ads/:id", h.Ads.GetAdByID)
+	api.GET("/ads/uuid/:public_id", h.Ads.GetAdByPublicID)
+	api.GET("/ads/slug/:slug", h.Ads.GetAdBySlug)
+	api.GET("/ads/:id/similar", h.Ads.GetSimilarAds)
+	api.HEAD("/ads/:id", h.Ads.HeadAdByID)
+	api.PUT("/ads/:id", h.Ads.UpdateAd)
+	api.DELETE("/ads/:id", h.Ads.DeleteAd)
+	api.PUT("/ads/by-ref/:ref", h.Ads.UpsertAdByRef)
+	api.POST("/ads/:id/renew", h.Ads.RenewAd)
+	api.POST("/ads/:id/archive", h.Ads.ArchiveAd)
+	api.POST("/ads/:id/favorite", h.Ads.FavoriteAd)
+	api.DELETE("/ads/:id/favorite", h.Ads.UnfavoriteAd)
+	api.GET("/users/me/favorites", h.Ads.GetFavorites)
+	api.POST("/ads/:id/unarchive", h.Ads.UnarchiveAd)
+	api.POST("/ads/:id/report", h.Ads.ReportAd)
+	api.POST("/ads/:id/click", h.Ads.ClickAd)
+	api.GET("/ads/:id/stats", h.Ads.GetAdStats)
+	api.GET("/ads/:id/variants", h.Ads.GetVariants)
+	api.POST("/ads/:id/variants", h.Ads.AddVariant)
+	api.PUT("/ads/:id/variants/:key", h.Ads.UpdateVariant)
+	api.DELETE("/ads/:id/variants/:key", h.Ads.DeleteVariant)
+	api.POST("/ads/:id/approve", middleware.RequireAdmin(), h.Ads.ApproveAd)
+	api.POST("/ads/:id/reject", middleware.RequireAdmin(), h.Ads.RejectAd)
+
+	// Moderation queue and cache maintenance, admins only
+	admin := api.Group("/admin", middleware.RequireAdmin())
+	admin.GET("/reports", h.Ads.GetReports)
+	admin.POST("/reports/:id/dismiss", h.Ads.DismissReport)
+	admin.POST("/reports/:id/take-down", h.Ads.TakeDownReport)
+	admin.POST("/cache/purge", h.Ads.PurgeCache)
+
+	// Campaigns group ads and gate their serving
+	api.POST("/campaigns", h.Campaigns.AddCampaign)
+	api.GET("/campaigns", h.Campaigns.GetCampaigns)
+	api.GET("/campaigns/:id", h.Campaigns.GetCampaignByID)
+	api.PUT("/campaigns/:id", h.Campaigns.UpdateCampaign)
+	api.DELETE("/campaigns/:id", h.Campaigns.DeleteCampaign)
+	api.GET("/campaigns/:id/ads", h.Campaigns.GetCampaignAds)
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/internal/apperr"
+	"ad_service/internal/config"
+	"ad_service/pkg/breaker"
+	"ad_service/pkg/cache"
+	"ad_service/pkg/middleware"
+	"ad_service/pkg/version"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestErrorMappings(t *testing.T) {
+	resetAt := time.Now().Add(time.Hour)
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		code    string
+		message string
+	}{
+		{"ad not found", fmt.Errorf("could not get ad: %w", ad.ErrAdNotFound), http.StatusNotFound, "not_found", "Ad not found"},
+		{"not owner", ad.ErrNotOwner, http.StatusForbidden, "forbidden", "Ad belongs to another user"},
+		{"busy", ad.ErrAdBusy, http.StatusConflict, "conflict", "Ad is being modified by another request, try again"},
+		{"archived", ad.ErrArchived, http.StatusConflict, "conflict", "Archived ads can't be edited or renewed, unarchive it first"},
+		{"already archived", ad.ErrAlreadyArchived, http.StatusConflict, "conflict", "Ad is already archived"},
+		{"not archived", ad.ErrNotArchived, http.StatusConflict, "conflict", "Ad is not archived"},
+		{"renewal limit", &ad.RenewalLimitError{Limit: 3, ResetAt: resetAt}, http.StatusTooManyRequests, "too_many_requests", "Renewal limit reached"},
+		{"active quota", &ad.QuotaError{Limit: ad.QuotaActive, Max: 5}, http.StatusConflict, "quota_exceeded", "At most 5 active ads are allowed per user, archive or delete one first"},
+		{"daily quota", &ad.QuotaError{Limit: ad.QuotaDaily, Max: 20, ResetAt: &resetAt}, http.StatusTooManyRequests, "quota_exceeded", "At most 20 ads can be created per 24 hours"},
+		{"validation", apperr.Validation("Invalid title", apperr.Field{Field: "title", Rule: "required"}), http.StatusBadRequest, "validation_failed", "Invalid title"},
+		{"breaker open", fmt.Errorf("could not query: %w", breaker.ErrOpen), http.StatusServiceUnavailable, "dependency_unavailable", "Failed to do it"},
+		{"unavailable", apperr.Wrap(apperr.ErrUnavailable, "MySQL is down", errors.New("dial tcp")), http.StatusServiceUnavailable, "unavailable", "Failed to do it"},
+		{"unexpected", errors.New("boom"), http.StatusInternalServerError, "internal", "Failed to do it"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.Use(middleware.Errors(errorMappings...))
+			r.POST("/", func(c *gin.Context) {
+				c.Error(tt.err).SetMeta("Failed to do it")
+			})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+
+			var body struct {
+				Error struct {
+					Code    string         `json:"code"`
+					Message string         `json:"message"`
+					Details map[string]any `json:"details"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode %q: %v", w.Body.String(), err)
+			}
+			if w.Code != tt.status || body.Error.Code != tt.code || body.Error.Message != tt.message {
+				t.Errorf("response = %d %s %q, want %d %s %q", w.Code, body.Error.Code, body.Error.Message, tt.status, tt.code, tt.message)
+			}
+			switch tt.status {
+			case http.StatusTooManyRequests:
+				if w.Header().Get("Retry-After") == "" {
+					t.Error("no Retry-After")
+				}
+			case http.StatusServiceUnavailable:
+				// Long enough for a failover rather than the 1 second default
+				if got := w.Header().Get("Retry-After"); got != "5" {
+					t.Errorf("Retry-After = %q, want 5", got)
+				}
+			}
+		})
+	}
+}
+
+// The renewal limit keeps the details and Retry-After its handler used to write itself
+func TestRenewalLimitResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.Errors(errorMappings...))
+	resetAt := time.Now().Add(2 * time.Hour).UTC().Truncate(time.Second)
+	r.POST("/ads/:id/renew", func(c *gin.Context) {
+		c.Error(&ad.RenewalLimitError{Limit: 3, ResetAt: resetAt}).SetMeta("Failed to renew ad")
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ads/1/renew", nil))
+
+	if got := w.Header().Get("Retry-After"); got != "7200" {
+		t.Errorf("Retry-After = %q, want 7200", got)
+	}
+	var body struct {
+		Error struct {
+			Details struct {
+				Limit      int       `json:"limit"`
+				ResetAt    time.Time `json:"reset_at"`
+				RetryAfter int       `json:"retry_after"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	details := body.Error.Details
+	if details.Limit != 3 || !details.ResetAt.Equal(resetAt) || details.RetryAfter != 7200 {
+		t.Errorf("details = %+v, want limit 3, reset_at %s, retry_after 7200", details, resetAt)
+	}
+}
+
+func TestVersionRoute(t *testing.T) {
+	previous := version.Version
+	version.Version = "v1.4.0"
+	t.Cleanup(func() { version.Version = previous })
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	RegisterRoutes(r, Handlers{}, config.AuthConfig{}, config.TenancyConfig{}, true)
+
+	// The legacy unversioned route answers with the bare info, /v1 with the envelope
+	for target, path := range map[string]func(body map[string]any) any{
+		"/version":    func(body map[string]any) any { return body["version"] },
+		"/v1/version": func(body map[string]any) any { return body["data"].(map[string]any)["version"] },
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d %s", target, w.Code, w.Body.String())
+		}
+		if got := path(body); got != "v1.4.0" {
+			t.Errorf("GET %s version = %v, want v1.4.0", target, got)
+		}
+	}
+}
+
+func TestLegacyResponses(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		t.Run(fmt.Sprintf("legacy %v", legacy), func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			service := &ad.AdService{
+				Repo:  &ad.Repository{DB: db},
+				Cache: cache.NoopCache{},
+				TTL:   config.NewReloadable(config.CacheConfig{WriteMode: ad.WriteModeInvalidate}),
+				Rules: config.NewReloadable(config.AdsConfig{}),
+			}
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			RegisterRoutes(r, Handlers{Ads: &ad.Handler{Service: service}}, config.AuthConfig{}, config.TenancyConfig{}, legacy)
+
+			envelope := map[string]string{
+				"bad request": `{"error":{"code":"validation_failed","message":"Invalid ID","details":{"fields":[{"field":"id","rule":"positive_integer"}]}}}`,
+				"not found":   `{"error":{"code":"not_found","message":"Ad not found"}}`,
+				"list":        `{"data":[],"meta":{"count":0,"limit":10,"page":1}}`,
+			}
+			// The flag only keeps the old shapes on the unversioned routes
+			unversioned := envelope
+			if legacy {
+				unversioned = map[string]string{
+					"bad request": `{"error":"Invalid ID","fields":[{"field":"id","rule":"positive_integer"}]}`,
+					"not found":   `{"error":"Ad not found"}`,
+					"list":        `[]`,
+				}
+			}
+			for prefix, want := range map[string]map[string]string{"": unversioned, "/v1": envelope} {
+				for name, target := range map[string]string{"bad request": "/ads/abc", "not found": "/ads/404", "list": "/ads"} {
+					switch name {
+					case "not found":
+						mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(int64(404), "default").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+					case "list":
+						mock.ExpectQuery("FROM ads").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+					}
+					w := httptest.NewRecorder()
+					r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, prefix+target, nil))
+					if w.Body.String() != want[name] {
+						t.Errorf("GET %s = %d %s, want %s", prefix+target, w.Code, w.Body.String(), want[name])
+					}
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+/*
+This file serves GET /sitemap.xml for the public ad pages, following the sitemaps.org protocol.
+Up to sitemap.maxURLs live ads are listed in one sitemap; beyond that /sitemap.xml becomes a
+sitemap index of /sitemap.xml?page=N. Entries are streamed from the database cursor to the client.
+*/
+package sitemap
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/internal/config"
+	"ad_service/pkg/middleware"
+	"ad_service/pkg/response"
+	"compress/gzip"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// xmlns is the namespace of sitemaps and sitemap indexes
+const xmlns = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// Handler serves the sitemap of the live ads
+type Handler struct {
+	Ads    *ad.Repository
+	Config config.SitemapConfig
+}
+
+// GetSitemap handles GET /sitemap.xml, with tracing. Without ?page= it returns the only sitemap,
+// or the index when the live ads need more than one; ?page=N returns the Nth sitemap.
+func (h *Handler) GetSitemap(c *gin.Context) {
+	tracer := otel.Tracer("ad-service.handler")
+	ctx, span := tracer.Start(c.Request.Context(), "GetSitemapHandler")
+	defer span.End()
+
+	page := 0
+	if rawPage, ok := c.GetQuery("page"); ok {
+		parsed, err := strconv.Atoi(rawPage)
+		if err != nil || parsed <= 0 {
+			span.RecordError(err)
+			response.Error(c, http.StatusBadRequest, "Invalid page value. Must be a positive integer.", nil)
+			return
+		}
+		page = parsed
+	}
+
+	count, err := h.Ads.CountLiveAds(ctx)
+	if middleware.ClientDisconnected(c, err) {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to count live ads")
+		response.Error(c, http.StatusInternalServerError, "Failed to build sitemap", nil)
+		return
+	}
+	pages := (count + h.Config.MaxURLs - 1) / h.Config.MaxURLs
+	if pages == 0 {
+		pages = 1
+	}
+	span.SetAttributes(attribute.Int("ads_count", count), attribute.Int("pages", pages), attribute.Int("page", page))
+	if page > pages {
+		response.Error(c, http.StatusNotFound, "Sitemap page not found", nil)
+		return
+	}
+
+	if page == 0 && pages > 1 {
+		w, closeWriter := h.begin(c)
+		writeIndex(w, h.Config.URL, pages)
+		closeWriter()
+		span.SetAttributes(attribute.String("status", "index"))
+		return
+	}
+	if page == 0 {
+		page = 1
+	}
+
+	// The response starts with the first row, so a query that fails up front still gets a 500.
+	// A failure mid-stream can only cut the document short.
+	var w io.Writer
+	closeWriter := func() {}
+	start := func() {
+		w, closeWriter = h.begin(c)
+		io.WriteString(w, xml.Header+`<urlset xmlns="`+xmlns+`">`+"\n")
+	}
+	err = h.Ads.EachLiveAd((page-1)*h.Config.MaxURLs, h.Config.MaxURLs, func(entry ad.SitemapEntry) error {
+		if w == nil {
+			start()
+		}
+		return writeURL(w, strings.ReplaceAll(h.Config.AdURL, "{id}", strconv.FormatInt(entry.ID, 10)), entry.UpdatedAt)
+	}, ctx)
+	if err != nil && w == nil {
+		if middleware.ClientDisconnected(c, err) {
+			return
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to list live ads")
+		response.Error(c, http.StatusInternalServerError, "Failed to build sitemap", nil)
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Sitemap cut short")
+		closeWriter()
+		return
+	}
+	if w == nil {
+		start()
+	}
+	io.WriteString(w, "</urlset>\n")
+	closeWriter()
+	span.SetAttributes(attribute.String("status", "success"))
+}
+
+// begin writes the response headers and returns the body writer, gzip-compressed when the
+// client accepts it, and the function that flushes it
+func (h *Handler) begin(c *gin.Context) (io.Writer, func()) {
+	c.Header("Content-Type", "application/xml; charset=utf-8")
+	c.Header("Cache-Control", "public, max-age="+strconv.Itoa(int(h.Config.MaxAge/time.Second)))
+	c.Header("Vary", "Accept-Encoding")
+	if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+		c.Status(http.StatusOK)
+		return c.Writer, func() {}
+	}
+	c.Header("Content-Encoding", "gzip")
+	c.Status(http.StatusOK)
+	zw := gzip.NewWriter(c.Writer)
+	return zw, func() { zw.Close() }
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, i.e. names gzip or * without q=0
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if quality, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(quality, 64); err == nil && q == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// writeURL writes one <url> entry; lastmod uses the W3C datetime format the protocol requires
+func writeURL(w io.Writer, loc string, lastmod time.Time) error {
+	if _, err := io.WriteString(w, "  <url><loc>"); err != nil {
+		return err
+	}
+	if err := xml.EscapeText(w, []byte(loc)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "</loc><lastmod>"+lastmod.UTC().Format(time.RFC3339)+"</lastmod></url>\n")
+	return err
+}
+
+// writeIndex writes a sitemap index listing the given number of pages of the sitemap at base
+func writeIndex(w io.Writer, base string, pages int) {
+	separator := "?"
+	if strings.Contains(base, "?") {
+		separator = "&"
+	}
+	io.WriteString(w, xml.Header+`<sitemapindex xmlns="`+xmlns+`">`+"\n")
+	for page := 1; page <= pages; page++ {
+		io.WriteString(w, "  <sitemap><loc>")
+		xml.EscapeText(w, []byte(base+separator+"page="+strconv.Itoa(page)))
+		io.WriteString(w, "</loc></sitemap>\n")
+	}
+	io.WriteString(w, "</sitemapindex>\n")
+}
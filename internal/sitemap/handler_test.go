@@ -0,0 +1,226 @@
+package sitemap
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/internal/config"
+	"ad_service/pkg/middleware"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// urlset and sitemapIndex are the documents of the sitemaps.org protocol
+type urlset struct {
+	XMLName xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []struct {
+		Loc     string `xml:"loc"`
+		Lastmod string `xml:"lastmod"`
+	} `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// newTestRouter serves the sitemap of maxURLs per page on a mocked database
+func newTestRouter(t *testing.T, maxURLs int) (*gin.Engine, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	h := &Handler{Ads: &ad.Repository{DB: db}, Config: config.SitemapConfig{
+		URL:     "https://ads.example.com/sitemap.xml",
+		AdURL:   "https://ads.example.com/ads/{id}?ref=sitemap&lang=en",
+		MaxURLs: maxURLs,
+		MaxAge:  time.Hour,
+	}}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/sitemap.xml", middleware.Tenant(config.TenancyConfig{}), h.GetSitemap)
+	return r, mock
+}
+
+// expectCount answers the count of live ads
+func expectCount(mock sqlmock.Sqlmock, count int) {
+	mock.ExpectQuery("SELECT COUNT").WithArgs("default").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(count))
+}
+
+// get requests target with the Accept-Encoding header, empty for none
+func get(r http.Handler, target, acceptEncoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// decode parses the XML body of w into v, decompressing it when it is gzip-encoded
+func decode(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	var body io.Reader = w.Body
+	if w.Header().Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("gzip: %v", err)
+		}
+		body = zr
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), xml.Header) {
+		t.Errorf("body starts with %.40q, want the XML declaration", data)
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+}
+
+func TestSitemap(t *testing.T) {
+	for _, encoding := range []string{"", "gzip, deflate"} {
+		t.Run("encoding "+encoding, func(t *testing.T) {
+			r, mock := newTestRouter(t, 50000)
+			updated := time.Date(2026, 3, 1, 12, 30, 0, 0, time.FixedZone("UTC+2", 2*3600))
+			expectCount(mock, 2)
+			mock.ExpectQuery(`SELECT id, GREATEST\(updated_at, renewed_at\) FROM ads .* ORDER BY id LIMIT \? OFFSET \?`).WithArgs("default", 50000, 0).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "updated_at"}).AddRow(int64(1), updated).AddRow(int64(7), updated))
+
+			w := get(r, "/sitemap.xml", encoding)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+			}
+			for header, want := range map[string]string{
+				"Content-Type":  "application/xml; charset=utf-8",
+				"Cache-Control": "public, max-age=3600",
+				"Vary":          "Accept-Encoding",
+			} {
+				if got := w.Header().Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+			if gzipped := w.Header().Get("Content-Encoding") == "gzip"; gzipped != (encoding != "") {
+				t.Errorf("Content-Encoding = %q for Accept-Encoding %q", w.Header().Get("Content-Encoding"), encoding)
+			}
+
+			var sitemap urlset
+			decode(t, w, &sitemap)
+			if len(sitemap.URLs) != 2 {
+				t.Fatalf("%d URLs, want 2", len(sitemap.URLs))
+			}
+			// The & of the template is escaped in the document and comes back unescaped
+			if loc := sitemap.URLs[1].Loc; loc != "https://ads.example.com/ads/7?ref=sitemap&lang=en" {
+				t.Errorf("loc = %q, want the ad URL of 7", loc)
+			}
+			if lastmod := sitemap.URLs[0].Lastmod; lastmod != "2026-03-01T10:30:00Z" {
+				t.Errorf("lastmod = %q, want the UTC W3C datetime", lastmod)
+			}
+		})
+	}
+}
+
+func TestSitemapIndexSplitting(t *testing.T) {
+	entries := func(ids ...int64) *sqlmock.Rows {
+		rows := sqlmock.NewRows([]string{"id", "updated_at"})
+		for _, id := range ids {
+			rows.AddRow(id, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC))
+		}
+		return rows
+	}
+
+	// Exactly at the threshold one sitemap still holds every URL
+	r, mock := newTestRouter(t, 2)
+	expectCount(mock, 2)
+	mock.ExpectQuery("SELECT id").WithArgs("default", 2, 0).WillReturnRows(entries(1, 2))
+	var single urlset
+	decode(t, get(r, "/sitemap.xml", ""), &single)
+	if len(single.URLs) != 2 {
+		t.Errorf("%d URLs at the threshold, want 2 in one sitemap", len(single.URLs))
+	}
+
+	// One more and it becomes an index of the pages
+	expectCount(mock, 5)
+	var index sitemapIndex
+	decode(t, get(r, "/sitemap.xml", "gzip"), &index)
+	var locs []string
+	for _, sitemap := range index.Sitemaps {
+		locs = append(locs, sitemap.Loc)
+	}
+	want := "https://ads.example.com/sitemap.xml?page=1 https://ads.example.com/sitemap.xml?page=2 https://ads.example.com/sitemap.xml?page=3"
+	if strings.Join(locs, " ") != want {
+		t.Errorf("index = %v, want 3 pages", locs)
+	}
+
+	// The last page has the remainder
+	expectCount(mock, 5)
+	mock.ExpectQuery("SELECT id").WithArgs("default", 2, 4).WillReturnRows(entries(5))
+	var last urlset
+	decode(t, get(r, "/sitemap.xml?page=3", ""), &last)
+	if len(last.URLs) != 1 || last.URLs[0].Loc != "https://ads.example.com/ads/5?ref=sitemap&lang=en" {
+		t.Errorf("page 3 = %+v, want ad 5", last.URLs)
+	}
+
+	expectCount(mock, 5)
+	if w := get(r, "/sitemap.xml?page=4", ""); w.Code != http.StatusNotFound {
+		t.Errorf("page past the end = %d, want 404", w.Code)
+	}
+	if w := get(r, "/sitemap.xml?page=0", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("page 0 = %d, want 400", w.Code)
+	}
+
+	// Without live ads the sitemap is valid and empty
+	expectCount(mock, 0)
+	mock.ExpectQuery("SELECT id").WithArgs("default", 2, 0).WillReturnRows(entries())
+	var empty urlset
+	decode(t, get(r, "/sitemap.xml", ""), &empty)
+	if len(empty.URLs) != 0 {
+		t.Errorf("empty sitemap has %d URLs", len(empty.URLs))
+	}
+}
+
+func TestSitemapQueryFailure(t *testing.T) {
+	r, mock := newTestRouter(t, 10)
+	expectCount(mock, 3)
+	mock.ExpectQuery("SELECT id").WillReturnError(errors.New("connection reset"))
+	if w := get(r, "/sitemap.xml", "gzip"); w.Code != http.StatusInternalServerError || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("failed query = %d with encoding %q, want a plain 500", w.Code, w.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := map[string]bool{
+		"":                      false,
+		"gzip":                  true,
+		"deflate, gzip;q=0.5":   true,
+		"*":                     true,
+		"gzip;q=0":              false,
+		"br, gzip;q=0, *;q=0.1": true,
+		"identity":              false,
+	}
+	for header, want := range tests {
+		if got := acceptsGzip(header); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package testutil_test
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/internal/testutil"
+	"ad_service/pkg/client"
+	"ad_service/pkg/tenant"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestAdsEndToEnd runs the API on MySQL and Redis; the subtests share one server and reset it
+func TestAdsEndToEnd(t *testing.T) {
+	ts := testutil.NewTestServer(t)
+
+	t.Run("lifecycle", func(t *testing.T) {
+		ts.Reset(t)
+		ctx := context.Background()
+		owner := ts.ClientAs("owner", false)
+
+		created, err := owner.CreateAd(client.AdRequest{Title: "Mountain bike", Description: "Barely used", Price: 250.5, Category: "sports"}, ctx)
+		if err != nil {
+			t.Fatalf("CreateAd: %v", err)
+		}
+		if created.ID == 0 || created.Status != ad.StatusPending || created.OwnerID == nil || *created.OwnerID != "owner" {
+			t.Fatalf("created ad = %+v, want a pending ad of owner", created)
+		}
+		// Pending ads are only shown to their owner until approved
+		if _, err := ts.Client.GetAd(created.ID, ctx); !errors.Is(err, client.ErrNotFound) {
+			t.Errorf("anonymous GetAd of a pending ad = %v, want ErrNotFound", err)
+		}
+		approve(t, ts, created.ID)
+
+		got, err := ts.Client.GetAd(created.ID, ctx)
+		if err != nil {
+			t.Fatalf("GetAd: %v", err)
+		}
+		if got.Title != "Mountain bike" || got.Price != 250.5 || got.Category != "sports" {
+			t.Errorf("GetAd = %+v, want the created ad", got)
+		}
+
+		update := client.AdRequest{Title: "Road bike", Description: "Barely used", Price: 199.99, Category: "sports"}
+		if err := owner.UpdateAd(created.ID, update, ctx); err != nil {
+			t.Fatalf("UpdateAd: %v", err)
+		}
+		got, err = ts.Client.GetAd(created.ID, ctx)
+		if err != nil || got.Title != "Road bike" || got.Price != 199.99 {
+			t.Errorf("GetAd after UpdateAd = %+v, %v; want the updated ad", got, err)
+		}
+
+		if err := owner.DeleteAd(created.ID, ctx); err != nil {
+			t.Fatalf("DeleteAd: %v", err)
+		}
+		if _, err := ts.Client.GetAd(created.ID, ctx); !errors.Is(err, client.ErrNotFound) {
+			t.Errorf("GetAd after DeleteAd = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		ts.Reset(t)
+		ctx := context.Background()
+		admin := ts.ClientAs("admin-user", true)
+
+		// ErrAdNotFound from the repository is a 404 on every route, cached or not
+		for i := 0; i < 2; i++ {
+			if _, err := ts.Client.GetAd(424242, ctx); !errors.Is(err, client.ErrNotFound) {
+				t.Errorf("GetAd of a missing ad, attempt %d = %v, want ErrNotFound", i+1, err)
+			}
+		}
+		update := client.AdRequest{Title: "Nothing", Description: "Nothing", Price: 1}
+		if err := admin.UpdateAd(424242, update, ctx); !errors.Is(err, client.ErrNotFound) {
+			t.Errorf("UpdateAd of a missing ad = %v, want ErrNotFound", err)
+		}
+		if err := admin.DeleteAd(424242, ctx); !errors.Is(err, client.ErrNotFound) {
+			t.Errorf("DeleteAd of a missing ad = %v, want ErrNotFound", err)
+		}
+		var apiErr *client.APIError
+		if _, err := ts.Client.GetAd(424242, ctx); !errors.As(err, &apiErr) || apiErr.Message != "Ad not found" {
+			t.Errorf("GetAd of a missing ad = %v, want the Ad not found message", err)
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		ts.Reset(t)
+		ctx := context.Background()
+		owner := ts.ClientAs("owner", false)
+		var ids []int64
+		for i := 1; i <= 5; i++ {
+			created, err := owner.CreateAd(client.AdRequest{Title: fmt.Sprintf("Ad %d", i), Description: "Paged", Price: float64(i)}, ctx)
+			if err != nil {
+				t.Fatalf("CreateAd: %v", err)
+			}
+			approve(t, ts, created.ID)
+			ids = append(ids, created.ID)
+		}
+
+		var listed []int64
+		for page := 1; page <= 3; page++ {
+			ads, err := ts.Client.ListAds(client.ListOptions{Page: page, Limit: 2, SortBy: "id", Order: "asc"}, ctx)
+			if err != nil {
+				t.Fatalf("ListAds page %d: %v", page, err)
+			}
+			if want := min(2, 5-(page-1)*2); len(ads) != want {
+				t.Errorf("page %d has %d ads, want %d", page, len(ads), want)
+			}
+			for _, a := range ads {
+				listed = append(listed, a.ID)
+			}
+		}
+		if fmt.Sprint(listed) != fmt.Sprint(ids) {
+			t.Errorf("pages listed %v, want every ad once in order: %v", listed, ids)
+		}
+		ads, err := ts.Client.ListAds(client.ListOptions{Page: 4, Limit: 2, SortBy: "id", Order: "asc"}, ctx)
+		if err != nil || len(ads) != 0 {
+			t.Errorf("page past the end = %v, %v; want no ads", ads, err)
+		}
+	})
+
+	t.Run("concurrent upserts", func(t *testing.T) {
+		ts.Reset(t)
+		const upserts = 8
+		statuses := make(chan int, upserts)
+		var wg sync.WaitGroup
+		for i := 0; i < upserts; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				body := fmt.Sprintf(`{"title": "Feed ad %d", "description": "From the feed", "price": 5}`, i)
+				req, _ := http.NewRequest(http.MethodPut, ts.URL+"/v1/ads/by-ref/feed-1", strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp.Body.Close()
+				statuses <- resp.StatusCode
+			}(i)
+		}
+		wg.Wait()
+		close(statuses)
+
+		// Exactly one upsert created the ad, the others updated it
+		counts := map[int]int{}
+		for status := range statuses {
+			counts[status]++
+		}
+		if counts[http.StatusCreated] != 1 || counts[http.StatusOK] != upserts-1 {
+			t.Errorf("statuses = %v, want one 201 and %d 200s", counts, upserts-1)
+		}
+		var rows int
+		if err := ts.DB.QueryRow("SELECT COUNT(*) FROM ads WHERE external_ref = 'feed-1'").Scan(&rows); err != nil || rows != 1 {
+			t.Errorf("ads with the reference = %d, %v; want 1", rows, err)
+		}
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		ts.Reset(t)
+		ctx := context.Background()
+		owner := ts.ClientAs("owner", false)
+		created, err := owner.CreateAd(client.AdRequest{Title: "Cached", Description: "From Redis", Price: 10}, ctx)
+		if err != nil {
+			t.Fatalf("CreateAd: %v", err)
+		}
+		approve(t, ts, created.ID)
+		if _, err := ts.Client.GetAd(created.ID, ctx); err != nil {
+			t.Fatalf("GetAd: %v", err)
+		}
+
+		// A change made behind the service's back is not seen while the ad is cached
+		if _, err := ts.DB.ExecContext(ctx, "UPDATE ads SET title = 'Changed in MySQL' WHERE id = ?", created.ID); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ts.Client.GetAd(created.ID, ctx)
+		if err != nil || got.Title != "Cached" {
+			t.Errorf("GetAd of the cached ad = %+v, %v; want the cached title", got, err)
+		}
+
+		// A write through the API replaces the cached copy
+		update := client.AdRequest{Title: "Updated", Description: "From Redis", Price: 10}
+		if err := owner.UpdateAd(created.ID, update, ctx); err != nil {
+			t.Fatalf("UpdateAd: %v", err)
+		}
+		got, err = ts.Client.GetAd(created.ID, ctx)
+		if err != nil || got.Title != "Updated" {
+			t.Errorf("GetAd after UpdateAd = %+v, %v; want the updated title", got, err)
+		}
+	})
+}
+
+// approve makes an ad public, as an admin would
+func approve(t *testing.T, ts *testutil.TestServer, id int64) {
+	t.Helper()
+	ctx := tenant.With(context.Background(), tenant.Default)
+	if _, err := ts.Service.SetStatus(id, ad.StatusApproved, nil, "admin-user", ctx); err != nil {
+		t.Fatalf("could not approve ad %d: %v", id, err)
+	}
+}
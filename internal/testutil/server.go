@@ -0,0 +1,227 @@
+/*
+Package testutil runs the service against real MySQL and Redis for integration tests. NewTestServer
+starts both in Docker containers with testcontainers-go, applies init.sql and serves the real
+repositories, cache and handlers from an httptest server. Tests are skipped without Docker.
+*/
+package testutil
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/internal/campaign"
+	"ad_service/internal/config"
+	"ad_service/internal/currency"
+	"ad_service/internal/database"
+	"ad_service/internal/search"
+	"ad_service/internal/server"
+	"ad_service/internal/sitemap"
+	"ad_service/pkg/cache"
+	"ad_service/pkg/client"
+	"ad_service/pkg/pagination"
+	"ad_service/pkg/retry"
+	"context"
+	"database/sql"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/gin-gonic/gin"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// Images of the dependencies, matching docker-compose.yml
+const (
+	mysqlImage = "mysql:8.0"
+	redisImage = "redis:7.4"
+)
+
+// startupTimeout bounds the start of each container, including the image pull
+const startupTimeout = 3 * time.Minute
+
+// TestServer is the service under test and the dependencies behind it
+type TestServer struct {
+	URL    string
+	Client *client.Client
+	Config *config.Config
+	DB     *sql.DB
+	Cache  cache.Cache
+	Repo   *ad.Repository
+	// Service is the ad service behind the handlers, for tests that bypass HTTP
+	Service *ad.AdService
+
+	closers []func()
+}
+
+// NewTestServer starts MySQL and Redis, applies the schema and serves the API. Everything is
+// torn down when the test ends; Close may be called earlier. It sets environment variables, so
+// tests using it can't run in parallel.
+func NewTestServer(t *testing.T) *TestServer {
+	t.Helper()
+	skipWithoutDocker(t)
+	ctx := context.Background()
+	ts := &TestServer{}
+	t.Cleanup(ts.Close)
+
+	mysqlHost, mysqlPort := startContainer(t, testcontainers.ContainerRequest{
+		Image:        mysqlImage,
+		ExposedPorts: []string{"3306/tcp"},
+		Env:          map[string]string{"MYSQL_ROOT_PASSWORD": "test", "MYSQL_DATABASE": "ad_service_test"},
+		// The entrypoint runs a temporary server for initialization before the real one
+		WaitingFor: wait.ForLog("ready for connections").WithOccurrence(2).WithStartupTimeout(startupTimeout),
+	}, "3306/tcp", ctx)
+	redisHost, redisPort := startContainer(t, testcontainers.ContainerRequest{
+		Image:        redisImage,
+		ExposedPorts: []string{"6379/tcp"},
+		WaitingFor:   wait.ForLog("Ready to accept connections").WithStartupTimeout(startupTimeout),
+	}, "6379/tcp", ctx)
+
+	// The configuration goes through LoadConfig, so the defaults and validation are the service's
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, nil, 0o600); err != nil {
+		t.Fatalf("could not write config file: %v", err)
+	}
+	for key, value := range map[string]string{
+		"MYSQL_HOST":      mysqlHost,
+		"MYSQL_PORT":      mysqlPort,
+		"MYSQL_PASSWORD":  "test",
+		"MYSQL_DATABASE":  "ad_service_test",
+		"REDIS_HOST":      redisHost,
+		"REDIS_PORT":      redisPort,
+		"CACHE_DRIVER":    cache.DriverRedis,
+		"CACHE_KEYPREFIX": "adsvc:test:",
+		"CACHE_REQUIRED":  "true",
+	} {
+		t.Setenv(config.EnvPrefix+"_"+key, value)
+	}
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("could not load config: %v", err)
+	}
+	ts.Config = cfg
+
+	db, err := database.Open(cfg.MySQL)
+	if err != nil {
+		t.Fatalf("could not connect to MySQL: %v", err)
+	}
+	ts.closers = append(ts.closers, func() { db.Close() })
+	if err := database.Migrate(db, repoRoot(t)); err != nil {
+		t.Fatalf("could not apply migrations: %v", err)
+	}
+	ts.DB = db
+
+	adCache, err := cache.New(cfg.Cache, cfg.Redis)
+	if err != nil {
+		t.Fatalf("could not connect to Redis: %v", err)
+	}
+	ts.closers = append(ts.closers, func() { adCache.Close() })
+	ts.Cache = adCache
+
+	// Wired like cmd/app, minus the background jobs
+	ts.Repo = &ad.Repository{DB: db}
+	ts.Service = &ad.AdService{Repo: ts.Repo, Cache: cache.NewTenantCache(adCache), TTL: config.NewReloadable(cfg.Cache), Rules: config.NewReloadable(cfg.Ads)}
+	if trending, err := cache.NewTrending(cfg.Cache, cfg.Redis, ad.MaxTrendingWindow); err == nil {
+		ts.Service.Trending = trending
+		ts.closers = append(ts.closers, func() { trending.Close() })
+	}
+	converter := currency.NewConverter(cfg.Currency, currency.StaticProvider{Table: cfg.Currency.Rates}, adCache)
+	paging := pagination.Policy{MaxLimit: cfg.Server.MaxPageSize, Mode: cfg.Server.PageSizeMode}
+	handlers := server.Handlers{
+		Ads:       &ad.Handler{Service: ts.Service, Currency: converter, Paging: paging},
+		Campaigns: &campaign.Handler{Service: &campaign.CampaignService{Repo: &campaign.Repository{DB: db}, Ads: ts.Service}, Paging: paging},
+		Sitemap:   &sitemap.Handler{Ads: ts.Repo, Config: cfg.Sitemap},
+		Search:    &search.Handler{Service: &search.SearchService{Ads: ts.Service}, Paging: paging},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	server.RegisterRoutes(r, handlers, cfg.Auth, cfg.Tenancy, cfg.Server.LegacyResponses)
+	srv := httptest.NewServer(r)
+	ts.closers = append(ts.closers, srv.Close)
+	ts.URL = srv.URL
+	ts.Client = ts.NewClient()
+	return ts
+}
+
+// NewClient returns a client of the test server that doesn't retry, so failures show up as-is
+func (ts *TestServer) NewClient(opts ...client.Option) *client.Client {
+	opts = append([]client.Option{client.WithRetryPolicy(retry.Policy{MaxAttempts: 1})}, opts...)
+	return client.New(ts.URL, opts...)
+}
+
+// ClientAs returns a client calling as the given user, with the admin role when admin is set
+func (ts *TestServer) ClientAs(userID string, admin bool) *client.Client {
+	opts := []client.Option{client.WithHeader(ts.Config.Auth.UserIDHeader, userID)}
+	if admin {
+		opts = append(opts, client.WithHeader(ts.Config.Auth.RoleHeader, ts.Config.Auth.AdminRole))
+	}
+	return ts.NewClient(opts...)
+}
+
+// Reset empties every table and the cache, for suites sharing one server between tests
+func (ts *TestServer) Reset(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	// The tables are emptied for every tenant; the child tables of ads go with them
+	for _, table := range []string{"ad_audit_log", "search_outbox", "ads", "campaigns", "cache_purge_log"} {
+		if _, err := ts.DB.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+			t.Fatalf("could not empty %s: %v", table, err)
+		}
+	}
+	if _, err := ts.Cache.DeleteByPrefix("", ctx); err != nil {
+		t.Fatalf("could not flush the cache: %v", err)
+	}
+}
+
+// Close stops the server and releases the connections, in reverse order of creation. The
+// containers are terminated by their own test cleanup.
+func (ts *TestServer) Close() {
+	for i := len(ts.closers) - 1; i >= 0; i-- {
+		ts.closers[i]()
+	}
+	ts.closers = nil
+}
+
+// skipWithoutDocker skips the test when no Docker daemon is reachable. testcontainers panics
+// instead of skipping when it finds no Docker host at all, so that is a skip too.
+func skipWithoutDocker(t *testing.T) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Skipf("Docker is not available: %v", r)
+		}
+	}()
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+}
+
+// startContainer starts a container that is terminated when the test ends and returns the host
+// and port its port is mapped to
+func startContainer(t *testing.T, req testcontainers.ContainerRequest, port nat.Port, ctx context.Context) (string, string) {
+	t.Helper()
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{ContainerRequest: req, Started: true})
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatalf("could not start %s: %v", req.Image, err)
+	}
+	host, err := container.Host(ctx)
+	if err != nil {
+		t.Fatalf("could not get the host of %s: %v", req.Image, err)
+	}
+	mapped, err := container.MappedPort(ctx, port)
+	if err != nil {
+		t.Fatalf("could not get the port of %s: %v", req.Image, err)
+	}
+	return host, mapped.Port()
+}
+
+// repoRoot is the repository root, found from the location of this file
+func repoRoot(t *testing.T) string {
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("could not locate the repository root")
+	}
+	return filepath.Join(filepath.Dir(file), "..", "..")
+}
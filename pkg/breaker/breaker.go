@@ -0,0 +1,242 @@
+// Package breaker is a circuit breaker for the calls to one dependency, such as MySQL or Redis.
+// While the dependency fails, calls are refused with ErrOpen instead of queueing up behind it;
+// after a cooldown a few probe calls are let through, and the breaker closes again once they
+// succeed. State changes are logged, exported as metrics and added to the span of the call that
+// caused them.
+package breaker
+
+import (
+	"ad_service/pkg/metrics"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrOpen is returned by Allow while the breaker refuses calls
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a breaker; the values grow with how much traffic is refused
+type State int
+
+const (
+	Closed   State = iota // calls go through and their outcomes are counted
+	HalfOpen              // Settings.Probes calls go through to test the dependency
+	Open                  // calls are refused until Settings.Cooldown has passed
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half_open"
+	case Open:
+		return "open"
+	}
+	return "unknown"
+}
+
+// Result is the outcome of a call let through by Allow
+type Result int
+
+const (
+	Success Result = iota
+	Failure
+	// Ignored calls say nothing about the dependency, e.g. ones the caller canceled
+	Ignored
+)
+
+// ResultOf is the result of a call that returned err: failed calls count as failures unless
+// ctx was canceled or timed out first, which is the caller giving up rather than the dependency
+// failing
+func ResultOf(err error, ctx context.Context) Result {
+	switch {
+	case err == nil:
+		return Success
+	case ctx.Err() != nil:
+		return Ignored
+	}
+	return Failure
+}
+
+// Settings configure a breaker. It opens after Threshold consecutive failures, or once at least
+// MinRequests calls were made in the current Window and FailureRate of them failed; a zero
+// Threshold or FailureRate disables that rule.
+type Settings struct {
+	Name        string        // the dependency, used in logs, metrics and spans
+	Threshold   int           // consecutive failures that open the breaker
+	FailureRate float64       // share of failed calls in a window that opens the breaker, 0 to 1
+	MinRequests int           // calls a window needs before FailureRate applies
+	Window      time.Duration // how long calls are counted for FailureRate before the counts restart
+	Cooldown    time.Duration // how long the breaker stays open before probing
+	Probes      int           // calls let through while half-open, all of which must succeed; at least 1
+
+	// OnStateChange is called after every state change, with the breaker locked
+	OnStateChange func(from, to State)
+}
+
+// Breaker is a circuit breaker, safe for concurrent use
+type Breaker struct {
+	settings Settings
+
+	mu          sync.Mutex
+	state       State
+	generation  uint64 // bumped on every state change, so late results of earlier calls are dropped
+	windowStart time.Time
+	requests    int
+	failures    int
+	consecutive int
+	openUntil   time.Time
+	inFlight    int // probes let through and not finished, while half-open
+	successes   int // probes that succeeded, while half-open
+
+	now func() time.Time
+}
+
+// New returns a closed breaker
+func New(settings Settings) *Breaker {
+	if settings.Probes < 1 {
+		settings.Probes = 1
+	}
+	b := &Breaker{settings: settings, now: time.Now}
+	b.windowStart = b.now()
+	metrics.CircuitBreakerState.WithLabelValues(settings.Name).Set(float64(Closed))
+	return b
+}
+
+// Name is the dependency the breaker guards
+func (b *Breaker) Name() string {
+	return b.settings.Name
+}
+
+// State returns the current state. An open breaker whose cooldown has passed reports HalfOpen,
+// since the next call will be a probe.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Open && !b.now().Before(b.openUntil) {
+		return HalfOpen
+	}
+	return b.state
+}
+
+// Allow asks to make a call. It returns ErrOpen when the call is refused; otherwise the call
+// must be made and its result passed to done exactly once.
+func (b *Breaker) Allow(ctx context.Context) (done func(Result), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	switch b.state {
+	case Open:
+		if now.Before(b.openUntil) {
+			b.reject(ctx)
+			return nil, ErrOpen
+		}
+		b.setState(HalfOpen, ctx)
+		fallthrough
+	case HalfOpen:
+		if b.inFlight+b.successes >= b.settings.Probes {
+			b.reject(ctx)
+			return nil, ErrOpen
+		}
+		b.inFlight++
+	case Closed:
+		if b.settings.Window > 0 && !now.Before(b.windowStart.Add(b.settings.Window)) {
+			b.windowStart, b.requests, b.failures = now, 0, 0
+		}
+	}
+
+	generation := b.generation
+	return func(result Result) { b.done(generation, result, ctx) }, nil
+}
+
+// reject records a refused call on the metrics and the span
+func (b *Breaker) reject(ctx context.Context) {
+	metrics.CircuitBreakerRejected.WithLabelValues(b.settings.Name).Inc()
+	trace.SpanFromContext(ctx).AddEvent("circuit breaker rejected call", trace.WithAttributes(
+		attribute.String("breaker.name", b.settings.Name),
+		attribute.String("breaker.state", b.state.String()),
+	))
+}
+
+// done counts the result of a call made in the given generation
+func (b *Breaker) done(generation uint64, result Result, ctx context.Context) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case HalfOpen:
+		b.inFlight--
+		switch result {
+		case Success:
+			b.successes++
+			if b.successes >= b.settings.Probes {
+				b.setState(Closed, ctx)
+			}
+		case Failure:
+			b.setState(Open, ctx)
+		}
+	case Closed:
+		switch result {
+		case Success:
+			b.requests++
+			b.consecutive = 0
+		case Failure:
+			b.requests++
+			b.failures++
+			b.consecutive++
+			if b.tripped() {
+				b.setState(Open, ctx)
+			}
+		}
+	}
+}
+
+// tripped reports whether the counts of the closed breaker call for opening it
+func (b *Breaker) tripped() bool {
+	if b.settings.Threshold > 0 && b.consecutive >= b.settings.Threshold {
+		return true
+	}
+	return b.settings.FailureRate > 0 && b.requests >= b.settings.MinRequests &&
+		float64(b.failures) >= b.settings.FailureRate*float64(b.requests)
+}
+
+// setState moves the breaker to a new state, resetting the counts of the old one
+func (b *Breaker) setState(to State, ctx context.Context) {
+	from := b.state
+	b.state = to
+	b.generation++
+	b.requests, b.failures, b.consecutive, b.inFlight, b.successes = 0, 0, 0, 0, 0
+	b.windowStart = b.now()
+	if to == Open {
+		b.openUntil = b.now().Add(b.settings.Cooldown)
+	}
+
+	switch to {
+	case Open:
+		log.Printf("Circuit breaker for %s opened after failures, refusing calls for %s", b.settings.Name, b.settings.Cooldown)
+	case HalfOpen:
+		log.Printf("Circuit breaker for %s half-open, probing with %d calls", b.settings.Name, b.settings.Probes)
+	case Closed:
+		log.Printf("Circuit breaker for %s closed, %s recovered", b.settings.Name, b.settings.Name)
+	}
+	metrics.CircuitBreakerState.WithLabelValues(b.settings.Name).Set(float64(to))
+	metrics.CircuitBreakerTransitions.WithLabelValues(b.settings.Name, to.String()).Inc()
+	trace.SpanFromContext(ctx).AddEvent("circuit breaker state change", trace.WithAttributes(
+		attribute.String("breaker.name", b.settings.Name),
+		attribute.String("breaker.from", from.String()),
+		attribute.String("breaker.to", to.String()),
+	))
+	if b.settings.OnStateChange != nil {
+		b.settings.OnStateChange(from, to)
+	}
+}
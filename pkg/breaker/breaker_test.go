@@ -0,0 +1,256 @@
+package breaker
+
+import (
+	"ad_service/pkg/metrics"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// clock is a manual time source for a breaker
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time          { return c.now }
+func (c *clock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// newTestBreaker returns a breaker on a manual clock, recording its state changes
+func newTestBreaker(settings Settings) (*Breaker, *clock, *[]string) {
+	c := &clock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	var changes []string
+	settings.OnStateChange = func(from, to State) { changes = append(changes, from.String()+"->"+to.String()) }
+	b := New(settings)
+	b.now = c.Now
+	b.windowStart = c.now
+	return b, c, &changes
+}
+
+// call makes one call through b with the given result, reporting whether it was let through
+func call(t *testing.T, b *Breaker, result Result) bool {
+	t.Helper()
+	done, err := b.Allow(context.Background())
+	if errors.Is(err, ErrOpen) {
+		return false
+	}
+	if err != nil {
+		t.Fatalf("Allow = %v", err)
+	}
+	done(result)
+	return true
+}
+
+func TestBreakerCycle(t *testing.T) {
+	name := "cycle-test"
+	b, c, changes := newTestBreaker(Settings{Name: name, Threshold: 3, Cooldown: 10 * time.Second, Probes: 2})
+	rejected := testutil.ToFloat64(metrics.CircuitBreakerRejected.WithLabelValues(name))
+
+	// Closed: a success resets the run of failures, the third in a row opens it
+	for i, result := range []Result{Failure, Failure, Success, Failure, Failure} {
+		if !call(t, b, result) {
+			t.Fatalf("call %d refused while closed", i+1)
+		}
+	}
+	if b.State() != Closed {
+		t.Fatalf("state = %s after 2 consecutive failures, want closed", b.State())
+	}
+	call(t, b, Failure)
+	if b.State() != Open || testutil.ToFloat64(metrics.CircuitBreakerState.WithLabelValues(name)) != float64(Open) {
+		t.Fatalf("state = %s after 3 consecutive failures, want open", b.State())
+	}
+
+	// Open: calls are refused until the cooldown has passed
+	c.Advance(9 * time.Second)
+	if call(t, b, Success) {
+		t.Error("call let through while open")
+	}
+	if got := testutil.ToFloat64(metrics.CircuitBreakerRejected.WithLabelValues(name)) - rejected; got != 1 {
+		t.Errorf("circuit_breaker_rejected_total grew by %v, want 1", got)
+	}
+
+	// Half-open: only Probes calls go through, and all of them must succeed
+	c.Advance(time.Second)
+	if b.State() != HalfOpen {
+		t.Fatalf("state = %s after the cooldown, want half-open", b.State())
+	}
+	first, err := b.Allow(context.Background())
+	if err != nil {
+		t.Fatalf("first probe refused: %v", err)
+	}
+	second, err := b.Allow(context.Background())
+	if err != nil {
+		t.Fatalf("second probe refused: %v", err)
+	}
+	if _, err := b.Allow(context.Background()); !errors.Is(err, ErrOpen) {
+		t.Errorf("third call while probing = %v, want ErrOpen", err)
+	}
+	first(Success)
+	if b.State() != HalfOpen {
+		t.Errorf("state = %s after one of two probes, want half-open", b.State())
+	}
+	second(Success)
+	if b.State() != Closed || testutil.ToFloat64(metrics.CircuitBreakerState.WithLabelValues(name)) != float64(Closed) {
+		t.Fatalf("state = %s after the probes succeeded, want closed", b.State())
+	}
+
+	// Closed again with fresh counts: two failures don't reopen it
+	call(t, b, Failure)
+	call(t, b, Failure)
+	if b.State() != Closed {
+		t.Errorf("state = %s, want the counts reset on closing", b.State())
+	}
+
+	want := []string{"closed->open", "open->half_open", "half_open->closed"}
+	if !slices.Equal(*changes, want) {
+		t.Errorf("state changes = %v, want %v", *changes, want)
+	}
+}
+
+func TestBreakerFailedProbeReopens(t *testing.T) {
+	b, c, changes := newTestBreaker(Settings{Name: "probe-test", Threshold: 1, Cooldown: 10 * time.Second})
+	call(t, b, Failure)
+	c.Advance(10 * time.Second)
+
+	probe, err := b.Allow(context.Background())
+	if err != nil {
+		t.Fatalf("probe refused: %v", err)
+	}
+	probe(Failure)
+	if b.State() != Open {
+		t.Fatalf("state = %s after a failed probe, want open", b.State())
+	}
+	// The cooldown starts over from the failed probe
+	c.Advance(5 * time.Second)
+	if call(t, b, Success) {
+		t.Error("call let through during the second cooldown")
+	}
+	c.Advance(5 * time.Second)
+	if !call(t, b, Success) || b.State() != Closed {
+		t.Errorf("state = %s after a successful probe, want closed", b.State())
+	}
+
+	want := []string{"closed->open", "open->half_open", "half_open->open", "open->half_open", "half_open->closed"}
+	if !slices.Equal(*changes, want) {
+		t.Errorf("state changes = %v, want %v", *changes, want)
+	}
+}
+
+func TestBreakerFailureRate(t *testing.T) {
+	b, c, _ := newTestBreaker(Settings{Name: "rate-test", FailureRate: 0.5, MinRequests: 4, Window: time.Minute, Cooldown: time.Second})
+
+	// Below the volume the rate doesn't apply, however bad
+	call(t, b, Failure)
+	call(t, b, Success)
+	call(t, b, Failure)
+	if b.State() != Closed {
+		t.Fatalf("state = %s after 3 calls, want closed below MinRequests", b.State())
+	}
+	// A new window starts the counts over
+	c.Advance(time.Minute)
+	call(t, b, Failure)
+	call(t, b, Success)
+	call(t, b, Success)
+	call(t, b, Success)
+	if b.State() != Closed {
+		t.Fatalf("state = %s at 1 failure in 4, want closed", b.State())
+	}
+	call(t, b, Failure)
+	if b.State() != Closed {
+		t.Fatalf("state = %s at 2 failures in 5, want closed", b.State())
+	}
+	// Reaching the rate is enough
+	call(t, b, Failure)
+	if b.State() != Open {
+		t.Errorf("state = %s at 3 failures in 6, want open", b.State())
+	}
+}
+
+func TestBreakerIgnoresStaleAndIgnoredResults(t *testing.T) {
+	b, c, _ := newTestBreaker(Settings{Name: "stale-test", Threshold: 2, Cooldown: time.Second})
+
+	// Canceled calls say nothing about the dependency
+	for i := 0; i < 3; i++ {
+		call(t, b, Ignored)
+	}
+	if b.State() != Closed {
+		t.Fatalf("state = %s after ignored calls, want closed", b.State())
+	}
+
+	// A call started before the breaker opened can't close it by finishing late
+	slow, err := b.Allow(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	call(t, b, Failure)
+	call(t, b, Failure)
+	slow(Success)
+	if b.State() != Open {
+		t.Errorf("state = %s after a late success, want open", b.State())
+	}
+
+	// A canceled probe frees its slot without deciding anything
+	c.Advance(time.Second)
+	probe, err := b.Allow(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe(Ignored)
+	if b.State() != HalfOpen || !call(t, b, Success) || b.State() != Closed {
+		t.Errorf("state = %s, want a second probe to close the breaker", b.State())
+	}
+}
+
+func TestResultOf(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		err  error
+		ctx  context.Context
+		want Result
+	}{
+		{"success", nil, context.Background(), Success},
+		{"failure", errors.New("connection refused"), context.Background(), Failure},
+		{"canceled caller", context.Canceled, canceled, Ignored},
+		{"success of a canceled caller", nil, canceled, Success},
+	}
+	for _, tt := range tests {
+		if got := ResultOf(tt.err, tt.ctx); got != tt.want {
+			t.Errorf("%s: ResultOf = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBreakerSpanEvents(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx, span := provider.Tracer("test").Start(context.Background(), "call")
+
+	b, _, _ := newTestBreaker(Settings{Name: "span-test", Threshold: 1, Cooldown: time.Minute})
+	done, err := b.Allow(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done(Failure)
+	if _, err := b.Allow(ctx); !errors.Is(err, ErrOpen) {
+		t.Fatalf("Allow while open = %v, want ErrOpen", err)
+	}
+	span.End()
+
+	events := recorder.Ended()[0].Events()
+	if len(events) != 2 || events[0].Name != "circuit breaker state change" || events[1].Name != "circuit breaker rejected call" {
+		t.Fatalf("span events = %v, want a state change and a rejection", events)
+	}
+	attrs := attribute.NewSet(events[0].Attributes...)
+	if to, _ := attrs.Value("breaker.to"); to.AsString() != "open" {
+		t.Errorf("state change event = %v, want breaker.to open", events[0].Attributes)
+	}
+	if name, _ := attrs.Value("breaker.name"); name.AsString() != "span-test" {
+		t.Errorf("state change event = %v, want breaker.name span-test", events[0].Attributes)
+	}
+}
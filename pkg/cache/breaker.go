@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"ad_service/pkg/breaker"
+	"ad_service/pkg/metrics"
+	"context"
+	"fmt"
+	"time"
+)
+
+// ErrCircuitOpen is returned by operations that need an answer from the backend (Incr, IncrExpire, SetNX)
+// while the breaker is open; it matches breaker.ErrOpen
+var ErrCircuitOpen = fmt.Errorf("cache %w", breaker.ErrOpen)
+
+// BreakerCache guards the wrapped cache with a circuit breaker. While it is open, reads are
+// reported as misses and writes are dropped, so requests fall through to MySQL without paying a
+// Redis timeout each. Calls canceled by their caller don't count as Redis failures.
+type BreakerCache struct {
+	Cache
+	Breaker *breaker.Breaker
+}
+
+// NewBreakerCache wraps c with a circuit breaker configured by settings. The cache_degraded
+// gauge follows the breaker: 1 while it is open or probing.
+func NewBreakerCache(c Cache, settings breaker.Settings) *BreakerCache {
+	settings.OnStateChange = func(from, to breaker.State) {
+		if to == breaker.Closed {
+			metrics.CacheDegraded.Set(0)
+		} else {
+			metrics.CacheDegraded.Set(1)
+		}
+	}
+	return &BreakerCache{Cache: c, Breaker: breaker.New(settings)}
+}
+
+// Get returns a miss without calling the wrapped cache while the breaker is open
+func (b *BreakerCache) Get(key string, ctx context.Context) (string, error) {
+	done, err := b.Breaker.Allow(ctx)
+	if err != nil {
+		return "", nil
+	}
+	value, err := b.Cache.Get(key, ctx)
+	done(breaker.ResultOf(err, ctx))
+	return value, err
+}
+
+// Set drops the write while the breaker is open
+func (b *BreakerCache) Set(key string, value string, expiration time.Duration, ctx context.Context) error {
+	done, err := b.Breaker.Allow(ctx)
+	if err != nil {
+		return nil
+	}
+	err = b.Cache.Set(key, value, expiration, ctx)
+	done(breaker.ResultOf(err, ctx))
+	return err
+}
+
+// Delete drops the delete while the breaker is open; entries expire on their own TTL
+func (b *BreakerCache) Delete(key string, ctx context.Context) error {
+	done, err := b.Breaker.Allow(ctx)
+	if err != nil {
+		return nil
+	}
+	err = b.Cache.Delete(key, ctx)
+	done(breaker.ResultOf(err, ctx))
+	return err
+}
+
+// DeleteIfValue fails fast with ErrCircuitOpen while the breaker is open
+func (b *BreakerCache) DeleteIfValue(key string, value string, ctx context.Context) (bool, error) {
+	done, err := b.Breaker.Allow(ctx)
+	if err != nil {
+		return false, ErrCircuitOpen
+	}
+	deleted, err := b.Cache.DeleteIfValue(key, value, ctx)
+	done(breaker.ResultOf(err, ctx))
+	return deleted, err
+}
+
+// DeleteByPrefix drops the delete while the breaker is open
+func (b *BreakerCache) DeleteByPrefix(prefix string, ctx context.Context) (int, error) {
+	done, err := b.Breaker.Allow(ctx)
+	if err != nil {
+		return 0, nil
+	}
+	deleted, err := b.Cache.DeleteByPrefix(prefix, ctx)
+	done(breaker.ResultOf(err, ctx))
+	return deleted, err
+}
+
+// Incr fails fast with ErrCircuitOpen while the breaker is open
+func (b *BreakerCache) Incr(key string, ctx context.Context) (int64, error) {
+	done, err := b.Breaker.Allow(ctx)
+	if err != nil {
+		return 0, ErrCircuitOpen
+	}
+	value, err := b.Cache.Incr(key, ctx)
+	done(breaker.ResultOf(err, ctx))
+	return value, err
+}
+
+// IncrExpire fails fast with ErrCircuitOpen while the breaker is open
+func (b *BreakerCache) IncrExpire(key string, expiration time.Duration, ctx context.Context) (int64, error) {
+	done, err := b.Breaker.Allow(ctx)
+	if err != nil {
+		return 0, ErrCircuitOpen
+	}
+	value, err := b.Cache.IncrExpire(key, expiration, ctx)
+	done(breaker.ResultOf(err, ctx))
+	return value, err
+}
+
+// GetMany returns no hits while the breaker is open
+func (b *BreakerCache) GetMany(keys []string, ctx context.Context) (map[string]string, error) {
+	done, err := b.Breaker.Allow(ctx)
+	if err != nil {
+		return map[string]string{}, nil
+	}
+	values, err := b.Cache.GetMany(keys, ctx)
+	done(breaker.ResultOf(err, ctx))
+	return values, err
+}
+
+// SetMany drops the writes while the breaker is open
+func (b *BreakerCache) SetMany(values map[string]string, expiration time.Duration, ctx context.Context) error {
+	done, err := b.Breaker.Allow(ctx)
+	if err != nil {
+		return nil
+	}
+	err = b.Cache.SetMany(values, expiration, ctx)
+	done(breaker.ResultOf(err, ctx))
+	return err
+}
+
+// SetNX fails fast with ErrCircuitOpen while the breaker is open, so GetOrRebuild rebuilds without waiting
+func (b *BreakerCache) SetNX(key string, value string, expiration time.Duration, ctx context.Context) (bool, error) {
+	done, err := b.Breaker.Allow(ctx)
+	if err != nil {
+		return false, ErrCircuitOpen
+	}
+	ok, err := b.Cache.SetNX(key, value, expiration, ctx)
+	done(breaker.ResultOf(err, ctx))
+	return ok, err
+}
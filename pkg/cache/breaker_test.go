@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"ad_service/pkg/breaker"
+	"ad_service/pkg/metrics"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBreakerCacheDegradesWhileRedisIsDown(t *testing.T) {
+	server := miniredis.RunT(t)
+	// Without retries, so the failed dials stay below the pool's limit and a restarted Redis is
+	// dialed again right away
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1, PoolSize: 10})
+	t.Cleanup(func() { client.Close() })
+	c := NewBreakerCache(&RedisCache{Client: client}, breaker.Settings{Name: "redis-test", Threshold: 3, Cooldown: 50 * time.Millisecond, Probes: 1})
+	ctx := context.Background()
+	if err := c.Set("ad:1", "bike", time.Minute, ctx); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	addr := server.Addr()
+	server.Close()
+	// The failures are reported until the breaker opens
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get("ad:1", ctx); err == nil {
+			t.Fatalf("Get %d with Redis down succeeded", i+1)
+		}
+	}
+	if state := c.Breaker.State(); state != breaker.Open {
+		t.Fatalf("breaker is %s after 3 failures, want open", state)
+	}
+	if got := testutil.ToFloat64(metrics.CacheDegraded); got != 1 {
+		t.Errorf("cache_degraded = %v, want 1", got)
+	}
+
+	// While open, reads are misses and writes are dropped without calling Redis
+	timed := redisObservations(t, "get", "error")
+	if value, err := c.Get("ad:1", ctx); err != nil || value != "" {
+		t.Errorf("Get while open = %q, %v; want a miss", value, err)
+	}
+	if err := c.Set("ad:1", "bike", time.Minute, ctx); err != nil {
+		t.Errorf("Set while open = %v, want the write dropped", err)
+	}
+	if redisObservations(t, "get", "error") != timed {
+		t.Error("Redis was called while the breaker was open")
+	}
+	if _, err := c.SetNX("lock:ad:1", "1", time.Second, ctx); !errors.Is(err, breaker.ErrOpen) {
+		t.Errorf("SetNX while open = %v, want ErrOpen", err)
+	}
+
+	// Once Redis is back a probe after the cooldown closes the breaker
+	if err := server.StartAddr(addr); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := c.Get("ad:1", ctx); err != nil {
+		t.Fatalf("probe Get: %v", err)
+	}
+	if state := c.Breaker.State(); state != breaker.Closed {
+		t.Errorf("breaker is %s after a successful probe, want closed", state)
+	}
+	if got := testutil.ToFloat64(metrics.CacheDegraded); got != 0 {
+		t.Errorf("cache_degraded = %v after recovery, want 0", got)
+	}
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"ad_service/internal/config"
+	"ad_service/pkg/breaker"
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// Cache is implemented by every cache driver the service can run with
+type Cache interface {
+	// Get returns the value stored at key, or an empty string on a miss
+	Get(key string, ctx context.Context) (string, error)
+	// Set stores a value for the logical TTL; a non-positive expiration stores nothing
+	Set(key string, value string, expiration time.Duration, ctx context.Context) error
+	// Delete removes a key
+	Delete(key string, ctx context.Context) error
+	// DeleteIfValue removes key only if it still holds value, atomically, and reports whether it did
+	DeleteIfValue(key string, value string, ctx context.Context) (bool, error)
+	// DeleteByPrefix removes every key starting with prefix and returns how many were deleted
+	DeleteByPrefix(prefix string, ctx context.Context) (int, error)
+	// Incr atomically increments the integer stored at key and returns the new value
+	Incr(key string, ctx context.Context) (int64, error)
+	// IncrExpire increments like Incr and has the key expire after expiration, used as-is
+	// without jitter, so counters of past time windows clean themselves up
+	IncrExpire(key string, expiration time.Duration, ctx context.Context) (int64, error)
+	// GetMany returns the values found for keys in one round trip; missing keys are absent from the map
+	GetMany(keys []string, ctx context.Context) (map[string]string, error)
+	// SetMany stores several values with the same logical TTL in one round trip
+	SetMany(values map[string]string, expiration time.Duration, ctx context.Context) error
+	// SetNX stores a value only if the key doesn't exist yet and reports whether it did.
+	// The expiration is used as-is, without jitter.
+	SetNX(key string, value string, expiration time.Duration, ctx context.Context) (bool, error)
+	// Close releases the connections or goroutines held by the cache
+	Close() error
+}
+
+// Supported values for the cache.driver setting
+const (
+	DriverRedis  = "redis"
+	DriverMemory = "memory"
+	DriverNone   = "none"
+)
+
+// New returns the cache implementation selected by cfg.Driver with every key under the
+// configured namespace, compressing large values when cfg.CompressionThreshold is set
+func New(cfg config.CacheConfig, redisCfg config.RedisConfig) (Cache, error) {
+	var c Cache
+	switch cfg.Driver {
+	case DriverRedis:
+		redisCache, err := NewCache(redisCfg)
+		if err != nil {
+			return nil, err
+		}
+		c = NewBreakerCache(redisCache, breaker.Settings{
+			Name:        "redis",
+			Threshold:   cfg.BreakerThreshold,
+			FailureRate: cfg.BreakerFailureRate,
+			MinRequests: cfg.BreakerMinRequests,
+			Window:      cfg.BreakerWindow,
+			Cooldown:    cfg.BreakerCooldown,
+			Probes:      cfg.BreakerProbes,
+		})
+	case DriverMemory:
+		c = NewMemoryCache(time.Minute)
+	case DriverNone:
+		return NoopCache{}, nil
+	default:
+		return nil, fmt.Errorf("unknown cache driver %q", cfg.Driver)
+	}
+
+	if cfg.CompressionThreshold > 0 {
+		c = NewCompressingCache(c, cfg.CompressionThreshold)
+	}
+
+	namespace := Namespace(cfg.KeyPrefix)
+	log.Printf("Cache driver %q using key namespace %q", cfg.Driver, namespace)
+	return NewPrefixedCache(c, namespace), nil
+}
+
+// ttlJitter is the maximum fraction by which Set randomly shortens or extends an expiration
+const ttlJitter = 0.1
+
+// EarliestExpiry is the shortest time an entry stored by Set with the logical TTL expiration
+// can live, so a refresh planned before it never finds the entry gone
+func EarliestExpiry(expiration time.Duration) time.Duration {
+	return time.Duration(float64(expiration) * (1 - ttlJitter))
+}
+
+// jitter spreads an expiration by up to ±ttlJitter so entries created together don't expire together
+func jitter(expiration time.Duration) time.Duration {
+	delta := float64(expiration) * ttlJitter
+	return expiration + time.Duration((rand.Float64()*2-1)*delta)
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestJitterStaysWithinBounds(t *testing.T) {
+	const expiration = 100 * time.Second
+	lowest, highest := expiration, expiration
+	for i := 0; i < 10000; i++ {
+		got := jitter(expiration)
+		if got < 90*time.Second || got > 110*time.Second {
+			t.Fatalf("jitter(%s) = %s, want within ±10%%", expiration, got)
+		}
+		lowest, highest = min(lowest, got), max(highest, got)
+	}
+	// Both directions are used, so entries created together spread out
+	if lowest > 95*time.Second || highest < 105*time.Second {
+		t.Errorf("jitter ranged over [%s, %s], want most of [90s, 110s]", lowest, highest)
+	}
+	if got := EarliestExpiry(expiration); got != 90*time.Second {
+		t.Errorf("EarliestExpiry(%s) = %s, want 90s", expiration, got)
+	}
+}
+
+func TestSetWithoutTTLStoresNothing(t *testing.T) {
+	ctx := context.Background()
+	redis, server := newTestRedis(t)
+	memory := NewMemoryCache(time.Minute)
+	defer memory.Close()
+
+	for name, c := range map[string]Cache{"redis": redis, "memory": memory} {
+		for _, ttl := range []time.Duration{0, -time.Second} {
+			if err := c.Set("ad:1", "cached", ttl, ctx); err != nil {
+				t.Errorf("%s Set with TTL %s: %v", name, ttl, err)
+			}
+			if got, _ := c.Get("ad:1", ctx); got != "" {
+				t.Errorf("%s stored %q with TTL %s, want nothing", name, got, ttl)
+			}
+		}
+	}
+	if keys := server.Keys(); len(keys) != 0 {
+		t.Errorf("Redis holds %v, want no keys", keys)
+	}
+
+	// A positive TTL is stored with its jitter
+	if err := redis.Set("ad:1", "cached", time.Minute, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := server.TTL("ad:1"); ttl < 54*time.Second || ttl > 66*time.Second {
+		t.Errorf("Redis TTL = %s, want a minute ±10%%", ttl)
+	}
+}
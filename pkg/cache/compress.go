@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"ad_service/pkg/metrics"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"time"
+)
+
+// gzipHeader prefixes values stored gzip-compressed. Anything else is read as a plain value,
+// so entries written before compression was enabled stay readable.
+const gzipHeader = '\x01'
+
+// CompressingCache gzip-compresses values larger than Threshold bytes before handing them to the wrapped Cache
+type CompressingCache struct {
+	Cache
+	Threshold int
+}
+
+// NewCompressingCache wraps c so values above threshold bytes are stored compressed
+func NewCompressingCache(c Cache, threshold int) *CompressingCache {
+	return &CompressingCache{Cache: c, Threshold: threshold}
+}
+
+// Get returns the decompressed value; a corrupted compressed value is reported as a miss
+func (c *CompressingCache) Get(key string, ctx context.Context) (string, error) {
+	value, err := c.Cache.Get(key, ctx)
+	if err != nil {
+		return "", err
+	}
+	return c.decode(value), nil
+}
+
+// GetMany returns the decompressed values, dropping corrupted ones as misses
+func (c *CompressingCache) GetMany(keys []string, ctx context.Context) (map[string]string, error) {
+	values, err := c.Cache.GetMany(keys, ctx)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		if decoded := c.decode(value); decoded != "" {
+			values[key] = decoded
+		} else {
+			delete(values, key)
+		}
+	}
+	return values, nil
+}
+
+// Set compresses the value when it is above the threshold
+func (c *CompressingCache) Set(key string, value string, expiration time.Duration, ctx context.Context) error {
+	return c.Cache.Set(key, c.encode(value), expiration, ctx)
+}
+
+// SetMany compresses each value above the threshold
+func (c *CompressingCache) SetMany(values map[string]string, expiration time.Duration, ctx context.Context) error {
+	encoded := make(map[string]string, len(values))
+	for key, value := range values {
+		encoded[key] = c.encode(value)
+	}
+	return c.Cache.SetMany(encoded, expiration, ctx)
+}
+
+// encode gzips values above the threshold, keeping the original when compression doesn't help
+func (c *CompressingCache) encode(value string) string {
+	if len(value) <= c.Threshold {
+		return value
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(gzipHeader)
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(value)); err != nil {
+		return value
+	}
+	if err := zw.Close(); err != nil {
+		return value
+	}
+	if buf.Len() >= len(value) {
+		return value
+	}
+
+	metrics.CacheCompressionBytesSaved.Add(float64(len(value) - buf.Len()))
+	return buf.String()
+}
+
+// decode reverses encode; a corrupted payload decodes to an empty string (a miss)
+func (c *CompressingCache) decode(value string) string {
+	if value == "" || value[0] != gzipHeader {
+		return value
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader([]byte(value[1:])))
+	if err != nil {
+		metrics.CacheCompressionErrors.Inc()
+		return ""
+	}
+	decoded, err := io.ReadAll(zr)
+	if err != nil {
+		metrics.CacheCompressionErrors.Inc()
+		return ""
+	}
+	return string(decoded)
+}
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"ad_service/pkg/metrics"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestCompressing returns a CompressingCache over a memory cache, with the memory cache
+// to look at what was stored
+func newTestCompressing(t *testing.T, threshold int) (*CompressingCache, *MemoryCache) {
+	t.Helper()
+	memory := newTestMemory(t, time.Minute)
+	return NewCompressingCache(memory, threshold), memory
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	c, memory := newTestCompressing(t, 100)
+	ctx := context.Background()
+	saved := testutil.ToFloat64(metrics.CacheCompressionBytesSaved)
+
+	atThreshold := strings.Repeat("a", 100)
+	above := strings.Repeat("a", 101)
+	c.Set("at", atThreshold, time.Minute, ctx)
+	c.Set("above", above, time.Minute, ctx)
+
+	if stored, _ := memory.Get("at", ctx); stored != atThreshold {
+		t.Errorf("a value of exactly the threshold was stored as %q, want it uncompressed", stored)
+	}
+	stored, _ := memory.Get("above", ctx)
+	if stored == "" || stored[0] != gzipHeader || len(stored) >= len(above) {
+		t.Errorf("a value above the threshold was stored as %d bytes %q, want it compressed", len(stored), stored)
+	}
+	if got := testutil.ToFloat64(metrics.CacheCompressionBytesSaved) - saved; got != float64(len(above)-len(stored)) {
+		t.Errorf("bytes saved = %v, want %d", got, len(above)-len(stored))
+	}
+	for key, want := range map[string]string{"at": atThreshold, "above": above} {
+		if got, _ := c.Get(key, ctx); got != want {
+			t.Errorf("Get(%s) = %q, want the original value", key, got)
+		}
+	}
+
+	// Compression that doesn't shrink the value is skipped
+	incompressible := "qZ3v9XkL0pW7yTb2"
+	small, _ := newTestCompressing(t, 4)
+	small.Set("random", incompressible, time.Minute, ctx)
+	if stored, _ := small.Cache.Get("random", ctx); stored != incompressible {
+		t.Errorf("an incompressible value was stored as %q, want it unchanged", stored)
+	}
+}
+
+func TestCompressionRoundTripsUTF8(t *testing.T) {
+	c, _ := newTestCompressing(t, 16)
+	ctx := context.Background()
+	values := map[string]string{
+		"cyrillic": strings.Repeat("Горный велосипед, почти новый. ", 20),
+		"emoji":    strings.Repeat("🚲 for sale — ¡barato! ", 20),
+		"mixed":    `{"title":"Café \"Über\"","description":"` + strings.Repeat("日本語のテキスト", 30) + `"}`,
+	}
+	if err := c.SetMany(values, time.Minute, ctx); err != nil {
+		t.Fatalf("SetMany: %v", err)
+	}
+	keys := []string{"cyrillic", "emoji", "mixed"}
+	found, err := c.GetMany(keys, ctx)
+	if err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+	for _, key := range keys {
+		if found[key] != values[key] {
+			t.Errorf("%s came back as %q, want the original", key, found[key])
+		}
+		if got, _ := c.Get(key, ctx); got != values[key] {
+			t.Errorf("Get(%s) = %q, want the original", key, got)
+		}
+	}
+}
+
+func TestCompressionCorruptedPayloads(t *testing.T) {
+	c, memory := newTestCompressing(t, 16)
+	ctx := context.Background()
+	c.Set("good", strings.Repeat("bike ", 50), time.Minute, ctx)
+	good, _ := memory.Get("good", ctx)
+	// A truncated stream and a header followed by garbage
+	memory.Set("truncated", good[:len(good)/2], time.Minute, ctx)
+	memory.Set("garbage", string(gzipHeader)+"not gzip at all", time.Minute, ctx)
+	// Entries written before compression was enabled
+	memory.Set("legacy", `{"id":1,"title":"Bike"}`, time.Minute, ctx)
+	failed := testutil.ToFloat64(metrics.CacheCompressionErrors)
+
+	for _, key := range []string{"truncated", "garbage"} {
+		value, err := c.Get(key, ctx)
+		if err != nil || value != "" {
+			t.Errorf("Get(%s) = %q, %v; want a miss", key, value, err)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.CacheCompressionErrors) - failed; got != 2 {
+		t.Errorf("compression errors = %v, want 2", got)
+	}
+	if got, _ := c.Get("legacy", ctx); got != `{"id":1,"title":"Bike"}` {
+		t.Errorf("Get(legacy) = %q, want the uncompressed value as is", got)
+	}
+
+	found, err := c.GetMany([]string{"good", "truncated", "garbage", "legacy"}, ctx)
+	if err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+	if len(found) != 2 || found["good"] != strings.Repeat("bike ", 50) || found["legacy"] == "" {
+		t.Errorf("GetMany = %v, want only the good and legacy values", found)
+	}
+}
@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"ad_service/internal/config"
+	"ad_service/pkg/metrics"
+	"ad_service/pkg/retry"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// invalidationMessage is what goes over the invalidation channel
+type invalidationMessage struct {
+	Origin string   `json:"origin"` // instance that performed the write
+	Keys   []string `json:"keys"`
+}
+
+// reconnectPolicy spaces out resubscribe attempts after the pub/sub connection drops.
+// There is no time limit: the subscriber keeps trying until shutdown.
+var reconnectPolicy = retry.Policy{
+	InitialInterval: 200 * time.Millisecond,
+	MaxInterval:     10 * time.Second,
+	Multiplier:      2,
+	Jitter:          0.2,
+}
+
+// Invalidator broadcasts evicted cache keys to every replica over a Redis channel, so
+// in-process layers (e.g. the serve snapshot) don't keep serving data another instance changed
+type Invalidator struct {
+	Client  *redis.Client
+	Channel string
+
+	// origin identifies this instance so it can skip its own messages
+	origin string
+}
+
+// NewInvalidator connects a dedicated Redis client for pub/sub. The channel lives under the
+// cache namespace so environments sharing a Redis don't evict each other's entries.
+func NewInvalidator(cfg config.CacheConfig, redisCfg config.RedisConfig) (*Invalidator, error) {
+	rdb, err := newRedisClient(redisCfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure Redis client: %v", err)
+	}
+
+	origin := make([]byte, 8)
+	if _, err := rand.Read(origin); err != nil {
+		return nil, fmt.Errorf("could not generate instance id: %v", err)
+	}
+	return &Invalidator{
+		Client:  rdb,
+		Channel: Namespace(cfg.KeyPrefix) + "invalidations",
+		origin:  hex.EncodeToString(origin),
+	}, nil
+}
+
+// Publish announces that keys were invalidated by this instance, with tracing
+func (i *Invalidator) Publish(keys []string, ctx context.Context) error {
+	tracer := otel.Tracer("cache")
+	ctx, span := tracer.Start(ctx, "Redis Publish")
+	defer span.End()
+
+	payload, err := json.Marshal(invalidationMessage{Origin: i.origin, Keys: keys})
+	if err != nil {
+		return err
+	}
+
+	span.SetAttributes(attribute.String("redis.channel", i.Channel), attribute.Int("redis.keys", len(keys)))
+	if err := i.Client.Publish(ctx, i.Channel, payload).Err(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Error in Redis PUBLISH operation")
+		return err
+	}
+	return nil
+}
+
+// Run subscribes to the channel and calls evict with the keys of every message published by
+// another instance. It resubscribes with backoff when the connection drops, and closes the
+// client and returns once ctx is done.
+func (i *Invalidator) Run(evict func(keys []string), ctx context.Context) {
+	defer i.Client.Close()
+
+	for attempt := 1; ; attempt++ {
+		err := i.subscribe(evict, ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		metrics.CacheInvalidationReconnects.Inc()
+		delay := reconnectPolicy.Backoff(attempt)
+		log.Printf("Cache invalidation subscription lost, reconnecting in %s: %v", delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// subscribe consumes messages until the connection fails or ctx is done
+func (i *Invalidator) subscribe(evict func(keys []string), ctx context.Context) error {
+	pubsub := i.Client.Subscribe(ctx, i.Channel)
+	defer pubsub.Close()
+	// Receiving only honours the deadline of ctx, so closing the subscription is what
+	// unblocks it on shutdown
+	stop := context.AfterFunc(ctx, func() { pubsub.Close() })
+	defer stop()
+
+	// Wait for the subscription to be confirmed so connection errors surface here
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+
+	for {
+		msg, err := pubsub.ReceiveMessage(ctx)
+		if err != nil {
+			return err
+		}
+
+		var message invalidationMessage
+		if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+			log.Printf("Ignoring malformed cache invalidation message: %v", err)
+			continue
+		}
+		metrics.CacheInvalidationsReceived.Inc()
+		if message.Origin == i.origin {
+			continue
+		}
+		evict(message.Keys)
+	}
+}
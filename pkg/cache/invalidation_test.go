@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"ad_service/internal/config"
+	"ad_service/pkg/metrics"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testInstance is one replica's invalidation subscriber and the keys it evicted
+type testInstance struct {
+	*Invalidator
+	mu      sync.Mutex
+	evicted []string
+	stopped chan struct{}
+}
+
+// startInstance runs an Invalidator on server until the test ends
+func startInstance(t *testing.T, server *miniredis.Miniredis) *testInstance {
+	t.Helper()
+	invalidator, err := NewInvalidator(config.CacheConfig{KeyPrefix: "adsvc:test:"}, config.RedisConfig{Host: server.Host(), Port: server.Port()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := &testInstance{Invalidator: invalidator, stopped: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		defer close(instance.stopped)
+		invalidator.Run(func(keys []string) {
+			instance.mu.Lock()
+			instance.evicted = append(instance.evicted, keys...)
+			instance.mu.Unlock()
+		}, ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-instance.stopped
+	})
+	return instance
+}
+
+func (i *testInstance) evictedKeys() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return fmt.Sprint(i.evicted)
+}
+
+// subscribers returns how many instances listen on the invalidation channel
+func subscribers(server *miniredis.Miniredis, channel string) int {
+	return server.PubSubNumSub(channel)[channel]
+}
+
+func TestInvalidationReachesOtherInstances(t *testing.T) {
+	server := miniredis.RunT(t)
+	writer, reader := startInstance(t, server), startInstance(t, server)
+	if writer.Channel != "adsvc:test:"+SchemaVersion+":invalidations" {
+		t.Errorf("channel = %q, want it under the cache namespace", writer.Channel)
+	}
+	waitUntil(t, func() bool { return subscribers(server, writer.Channel) == 2 })
+	received := testutil.ToFloat64(metrics.CacheInvalidationsReceived)
+
+	if err := writer.Publish([]string{"ad:7", "ads:serve_snapshot"}, context.Background()); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	waitUntil(t, func() bool { return reader.evictedKeys() == "[ad:7 ads:serve_snapshot]" })
+	// Both instances received the message, but the writer skips its own
+	waitUntil(t, func() bool { return testutil.ToFloat64(metrics.CacheInvalidationsReceived)-received == 2 })
+	if got := writer.evictedKeys(); got != "[]" {
+		t.Errorf("the publishing instance evicted %s, want nothing", got)
+	}
+
+	// Malformed messages are skipped without dropping the subscription
+	server.Publish(writer.Channel, "not json")
+	writer.Publish([]string{"ad:8"}, context.Background())
+	waitUntil(t, func() bool { return reader.evictedKeys() == "[ad:7 ads:serve_snapshot ad:8]" })
+}
+
+func TestInvalidationResubscribesAfterDisconnect(t *testing.T) {
+	policy := reconnectPolicy
+	reconnectPolicy.InitialInterval = 10 * time.Millisecond
+	t.Cleanup(func() { reconnectPolicy = policy })
+	server := miniredis.RunT(t)
+	writer, reader := startInstance(t, server), startInstance(t, server)
+	waitUntil(t, func() bool { return subscribers(server, writer.Channel) == 2 })
+	reconnects := testutil.ToFloat64(metrics.CacheInvalidationReconnects)
+
+	addr := server.Addr()
+	server.Close()
+	waitUntil(t, func() bool { return testutil.ToFloat64(metrics.CacheInvalidationReconnects)-reconnects >= 2 })
+	if err := server.StartAddr(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both subscribers are back and evictions flow again
+	waitUntil(t, func() bool { return subscribers(server, writer.Channel) == 2 })
+	if err := writer.Publish([]string{"ad:7"}, context.Background()); err != nil {
+		t.Fatalf("Publish after reconnect: %v", err)
+	}
+	waitUntil(t, func() bool { return reader.evictedKeys() == "[ad:7]" })
+}
+
+func TestInvalidatorStopsWithContext(t *testing.T) {
+	server := miniredis.RunT(t)
+	invalidator, err := NewInvalidator(config.CacheConfig{}, config.RedisConfig{Host: server.Host(), Port: server.Port()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		invalidator.Run(func([]string) {}, ctx)
+		close(stopped)
+	}()
+	waitUntil(t, func() bool { return subscribers(server, invalidator.Channel) == 1 })
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Run still running a second after shutdown")
+	}
+	// Run closed its client
+	if err := invalidator.Client.Ping(context.Background()).Err(); err == nil {
+		t.Error("the pub/sub client is still open after Run returned")
+	}
+}
@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is part of every key namespace. Bump it whenever the cached representation
+// changes so a deploy starts with a fresh keyspace instead of reading entries it can't decode.
+const SchemaVersion = "v2"
+
+// Key joins key parts with ":". Callers build keys with it instead of concatenating strings.
+func Key(parts ...string) string {
+	return strings.Join(parts, ":")
+}
+
+// Namespace returns the effective prefix for a configured key prefix, e.g. "adsvc:prod:" becomes "adsvc:prod:v2:"
+func Namespace(prefix string) string {
+	return prefix + SchemaVersion + ":"
+}
+
+// PrefixedCache applies a namespace to every key before handing it to the wrapped Cache,
+// so environments sharing one Redis never see each other's entries
+type PrefixedCache struct {
+	Cache
+	Prefix string
+}
+
+// NewPrefixedCache wraps c so all keys live under prefix
+func NewPrefixedCache(c Cache, prefix string) *PrefixedCache {
+	return &PrefixedCache{Cache: c, Prefix: prefix}
+}
+
+// Get reads the namespaced key
+func (c *PrefixedCache) Get(key string, ctx context.Context) (string, error) {
+	return c.Cache.Get(c.Prefix+key, ctx)
+}
+
+// Set writes the namespaced key
+func (c *PrefixedCache) Set(key string, value string, expiration time.Duration, ctx context.Context) error {
+	return c.Cache.Set(c.Prefix+key, value, expiration, ctx)
+}
+
+// Delete removes the namespaced key
+func (c *PrefixedCache) Delete(key string, ctx context.Context) error {
+	return c.Cache.Delete(c.Prefix+key, ctx)
+}
+
+// DeleteIfValue removes the namespaced key if it holds value
+func (c *PrefixedCache) DeleteIfValue(key string, value string, ctx context.Context) (bool, error) {
+	return c.Cache.DeleteIfValue(c.Prefix+key, value, ctx)
+}
+
+// DeleteByPrefix removes the keys starting with the namespaced prefix
+func (c *PrefixedCache) DeleteByPrefix(prefix string, ctx context.Context) (int, error) {
+	return c.Cache.DeleteByPrefix(c.Prefix+prefix, ctx)
+}
+
+// Incr increments the namespaced key
+func (c *PrefixedCache) Incr(key string, ctx context.Context) (int64, error) {
+	return c.Cache.Incr(c.Prefix+key, ctx)
+}
+
+// IncrExpire increments the namespaced key and sets its expiration
+func (c *PrefixedCache) IncrExpire(key string, expiration time.Duration, ctx context.Context) (int64, error) {
+	return c.Cache.IncrExpire(c.Prefix+key, expiration, ctx)
+}
+
+// GetMany reads the namespaced keys and returns the values under the caller's keys
+func (c *PrefixedCache) GetMany(keys []string, ctx context.Context) (map[string]string, error) {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = c.Prefix + key
+	}
+	values, err := c.Cache.GetMany(prefixed, ctx)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]string, len(values))
+	for key, value := range values {
+		found[strings.TrimPrefix(key, c.Prefix)] = value
+	}
+	return found, nil
+}
+
+// SetMany writes the namespaced keys
+func (c *PrefixedCache) SetMany(values map[string]string, expiration time.Duration, ctx context.Context) error {
+	prefixed := make(map[string]string, len(values))
+	for key, value := range values {
+		prefixed[c.Prefix+key] = value
+	}
+	return c.Cache.SetMany(prefixed, expiration, ctx)
+}
+
+// SetNX sets the namespaced key if it doesn't exist
+func (c *PrefixedCache) SetNX(key string, value string, expiration time.Duration, ctx context.Context) (bool, error) {
+	return c.Cache.SetNX(c.Prefix+key, value, expiration, ctx)
+}
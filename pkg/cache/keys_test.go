@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKeyConstruction(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{Key("ad", "7"), "ad:7"},
+		{Key("ads", "list", "3", "approved"), "ads:list:3:approved"},
+		{Key("lock", Key("ad", "7")), "lock:ad:7"},
+		{Namespace("adsvc:prod:"), "adsvc:prod:" + SchemaVersion + ":"},
+		{Namespace(""), SchemaVersion + ":"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("key = %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestPrefixedCacheIgnoresOtherNamespaces(t *testing.T) {
+	redis, server := newTestRedis(t)
+	c := NewPrefixedCache(redis, Namespace("adsvc:prod:"))
+	ctx := context.Background()
+	// Entries of an older schema version, another environment and the unprefixed keyspace
+	server.Set("adsvc:prod:v1:ad:7", `{"id":7,"name":"old shape"}`)
+	server.Set("adsvc:staging:"+SchemaVersion+":ad:7", `{"id":7,"title":"Staging"}`)
+	server.Set("ad:7", `{"id":7,"title":"Bare"}`)
+
+	if got, _ := c.Get("ad:7", ctx); got != "" {
+		t.Errorf("Get(ad:7) = %q, want a miss in a fresh namespace", got)
+	}
+	if found, _ := c.GetMany([]string{"ad:7"}, ctx); len(found) != 0 {
+		t.Errorf("GetMany = %v, want a miss in a fresh namespace", found)
+	}
+
+	c.Set("ad:7", `{"id":7,"title":"Prod"}`, time.Minute, ctx)
+	c.SetMany(map[string]string{"ad:8": "eight"}, time.Minute, ctx)
+	if !server.Exists("adsvc:prod:"+SchemaVersion+":ad:7") || !server.Exists("adsvc:prod:"+SchemaVersion+":ad:8") {
+		t.Errorf("keys written outside the namespace: %v", server.Keys())
+	}
+	// Values come back under the caller's keys
+	found, _ := c.GetMany([]string{"ad:7", "ad:8"}, ctx)
+	if found["ad:7"] != `{"id":7,"title":"Prod"}` || found["ad:8"] != "eight" {
+		t.Errorf("GetMany = %v, want both namespaced values", found)
+	}
+
+	// Prefix deletes stay in the namespace
+	if deleted, _ := c.DeleteByPrefix("ad:", ctx); deleted != 2 {
+		t.Errorf("DeleteByPrefix deleted %d keys, want the 2 in the namespace", deleted)
+	}
+	for _, key := range []string{"adsvc:prod:v1:ad:7", "adsvc:staging:" + SchemaVersion + ":ad:7", "ad:7"} {
+		if !server.Exists(key) {
+			t.Errorf("%s of another namespace was deleted", key)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// lockPollInterval is how often AcquireLock retries a held lock
+const lockPollInterval = 25 * time.Millisecond
+
+// ErrLockNotAcquired is returned when another holder kept the lock for the whole wait
+var ErrLockNotAcquired = errors.New("could not acquire lock in time")
+
+// Lock is a lock held on a cache key. The key stores a random token so only the holder can
+// release it, even after the TTL expired and someone else acquired it.
+type Lock struct {
+	cache Cache
+	key   string
+	token string
+}
+
+// AcquireLock takes the lock named key with SETNX, retrying until wait elapses. The TTL bounds how
+// long the lock survives a holder that dies without releasing it. Errors other than
+// ErrLockNotAcquired come from the cache itself.
+func AcquireLock(c Cache, key string, ttl, wait time.Duration, ctx context.Context) (*Lock, error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, err
+	}
+	lock := &Lock{cache: c, key: Key("lock", key), token: hex.EncodeToString(tokenBytes)}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+
+	for {
+		acquired, err := c.SetNX(lock.key, lock.token, ttl, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			return lock, nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			return nil, ErrLockNotAcquired
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Release deletes the lock if it is still ours. A nil Lock is a no-op, so callers that
+// proceeded without a lock can release unconditionally.
+func (l *Lock) Release(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	_, err := l.cache.DeleteIfValue(l.key, l.token, ctx)
+	return err
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLockExcludesOtherHolders(t *testing.T) {
+	redis, server := newTestRedis(t)
+	ctx := context.Background()
+
+	first, err := AcquireLock(redis, "ad:7", time.Minute, 0, ctx)
+	if err != nil {
+		t.Fatalf("AcquireLock: %v", err)
+	}
+	if _, err := AcquireLock(redis, "ad:7", time.Minute, 60*time.Millisecond, ctx); !errors.Is(err, ErrLockNotAcquired) {
+		t.Fatalf("AcquireLock of a held lock = %v, want ErrLockNotAcquired", err)
+	}
+	// Other keys are independent
+	other, err := AcquireLock(redis, "ad:8", time.Minute, 0, ctx)
+	if err != nil {
+		t.Fatalf("AcquireLock of another ad: %v", err)
+	}
+	other.Release(ctx)
+
+	// A waiter gets the lock as soon as it is released
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		first.Release(ctx)
+		close(released)
+	}()
+	second, err := AcquireLock(redis, "ad:7", time.Minute, time.Second, ctx)
+	if err != nil {
+		t.Fatalf("AcquireLock after release: %v", err)
+	}
+	<-released
+	if got, _ := server.Get(Key("lock", "ad:7")); got != second.token {
+		t.Errorf("lock holds %q, want the second holder's token", got)
+	}
+}
+
+func TestLockReleaseAfterExpiry(t *testing.T) {
+	redis, server := newTestRedis(t)
+	ctx := context.Background()
+	first, err := AcquireLock(redis, "ad:7", time.Second, 0, ctx)
+	if err != nil {
+		t.Fatalf("AcquireLock: %v", err)
+	}
+	// The first holder outlived its TTL and someone else took the lock
+	server.FastForward(2 * time.Second)
+	second, err := AcquireLock(redis, "ad:7", time.Minute, 0, ctx)
+	if err != nil {
+		t.Fatalf("AcquireLock after expiry: %v", err)
+	}
+
+	if err := first.Release(ctx); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if got, _ := server.Get(Key("lock", "ad:7")); got != second.token {
+		t.Errorf("a stale holder's release deleted the new holder's lock, left %q", got)
+	}
+	second.Release(ctx)
+	if server.Exists(Key("lock", "ad:7")) {
+		t.Error("the lock is still held after its holder released it")
+	}
+	var none *Lock
+	if err := none.Release(ctx); err != nil {
+		t.Errorf("Release of no lock = %v", err)
+	}
+}
+
+func TestLockWaitStopsWithContext(t *testing.T) {
+	c := newTestMemory(t, time.Minute)
+	if _, err := AcquireLock(c, "ad:7", time.Minute, 0, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := AcquireLock(c, "ad:7", time.Minute, time.Minute, ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("AcquireLock past the deadline = %v, want DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryEntry is a value stored by MemoryCache with its expiry time
+type memoryEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+// MemoryCache is an in-process Cache with TTL support, for running without Redis
+type MemoryCache struct {
+	mu      sync.RWMutex
+	entries map[string]memoryEntry
+	stop    chan struct{}
+}
+
+// NewMemoryCache creates a MemoryCache whose janitor removes expired entries every cleanupInterval
+func NewMemoryCache(cleanupInterval time.Duration) *MemoryCache {
+	c := &MemoryCache{
+		entries: make(map[string]memoryEntry),
+		stop:    make(chan struct{}),
+	}
+	go c.janitor(cleanupInterval)
+	return c
+}
+
+// janitor periodically drops expired entries until Close is called
+func (c *MemoryCache) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			c.mu.Lock()
+			for key, entry := range c.entries {
+				if now.After(entry.expiresAt) {
+					delete(c.entries, key)
+				}
+			}
+			c.mu.Unlock()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Close stops the janitor goroutine
+func (c *MemoryCache) Close() error {
+	close(c.stop)
+	return nil
+}
+
+// Get returns the value stored at key, or an empty string when it is missing or expired
+func (c *MemoryCache) Get(key string, ctx context.Context) (string, error) {
+	c.mu.RLock()
+	entry, ok := c.entries[key]
+	c.mu.RUnlock()
+	if !ok || time.Now().After(entry.expiresAt) {
+		return "", nil
+	}
+	return entry.value, nil
+}
+
+// Set stores a value for the jittered expiration; a non-positive expiration stores nothing
+func (c *MemoryCache) Set(key string, value string, expiration time.Duration, ctx context.Context) error {
+	if expiration <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	c.entries[key] = memoryEntry{value: value, expiresAt: time.Now().Add(jitter(expiration))}
+	c.mu.Unlock()
+	return nil
+}
+
+// DeleteIfValue removes key only if it holds value and hasn't expired
+func (c *MemoryCache) DeleteIfValue(key string, value string, ctx context.Context) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || entry.value != value || time.Now().After(entry.expiresAt) {
+		return false, nil
+	}
+	delete(c.entries, key)
+	return true, nil
+}
+
+// DeleteByPrefix removes every key starting with prefix
+func (c *MemoryCache) DeleteByPrefix(prefix string, ctx context.Context) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deleted := 0
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Delete removes a key
+func (c *MemoryCache) Delete(key string, ctx context.Context) error {
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+	return nil
+}
+
+// Incr increments the integer stored at key, keeping its expiry. Like Redis, a missing key
+// starts at zero and never expires.
+func (c *MemoryCache) Incr(key string, ctx context.Context) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		entry = memoryEntry{value: "0", expiresAt: time.Unix(1<<62, 0)}
+	}
+	value, err := strconv.ParseInt(entry.value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	value++
+	entry.value = strconv.FormatInt(value, 10)
+	c.entries[key] = entry
+	return value, nil
+}
+
+// IncrExpire increments the integer stored at key and has it expire after expiration
+func (c *MemoryCache) IncrExpire(key string, expiration time.Duration, ctx context.Context) (int64, error) {
+	value, err := c.Incr(key, ctx)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok {
+		entry.expiresAt = time.Now().Add(expiration)
+		c.entries[key] = entry
+	}
+	return value, nil
+}
+
+// SetNX stores a value only if the key is missing or expired
+func (c *MemoryCache) SetNX(key string, value string, expiration time.Duration, ctx context.Context) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[key]; ok && time.Now().Before(entry.expiresAt) {
+		return false, nil
+	}
+	c.entries[key] = memoryEntry{value: value, expiresAt: time.Now().Add(expiration)}
+	return true, nil
+}
+
+// GetMany returns the values of the keys that are present and not expired
+func (c *MemoryCache) GetMany(keys []string, ctx context.Context) (map[string]string, error) {
+	found := make(map[string]string, len(keys))
+	now := time.Now()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, key := range keys {
+		if entry, ok := c.entries[key]; ok && now.Before(entry.expiresAt) {
+			found[key] = entry.value
+		}
+	}
+	return found, nil
+}
+
+// SetMany stores several values, each with its own jittered expiration
+func (c *MemoryCache) SetMany(values map[string]string, expiration time.Duration, ctx context.Context) error {
+	if expiration <= 0 {
+		return nil
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, value := range values {
+		c.entries[key] = memoryEntry{value: value, expiresAt: now.Add(jitter(expiration))}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"ad_service/internal/config"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestMemory returns a MemoryCache closed when the test ends
+func newTestMemory(t *testing.T, cleanupInterval time.Duration) *MemoryCache {
+	t.Helper()
+	c := NewMemoryCache(cleanupInterval)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestMemoryCacheConcurrentAccess(t *testing.T) {
+	c := newTestMemory(t, time.Minute)
+	ctx := context.Background()
+	const goroutines, rounds = 20, 100
+
+	var wg sync.WaitGroup
+	won := make(chan string, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				key := fmt.Sprintf("ad:%d:%d", g, i)
+				if err := c.Set(key, key, time.Minute, ctx); err != nil {
+					t.Error(err)
+				}
+				if got, _ := c.Get(key, ctx); got != key {
+					t.Errorf("Get(%s) = %q right after Set", key, got)
+				}
+				if _, err := c.Incr("counter", ctx); err != nil {
+					t.Error(err)
+				}
+			}
+			owner := fmt.Sprint(g)
+			if ok, _ := c.SetNX("lock", owner, time.Minute, ctx); ok {
+				won <- owner
+			}
+			c.DeleteByPrefix(fmt.Sprintf("ad:%d:", g), ctx)
+		}(g)
+	}
+	wg.Wait()
+	close(won)
+
+	if got, _ := c.Get("counter", ctx); got != fmt.Sprint(goroutines*rounds) {
+		t.Errorf("counter = %s, want %d increments", got, goroutines*rounds)
+	}
+	var winners []string
+	for owner := range won {
+		winners = append(winners, owner)
+	}
+	if len(winners) != 1 {
+		t.Fatalf("SetNX succeeded for %v, want exactly one goroutine", winners)
+	}
+	if got, _ := c.Get("lock", ctx); got != winners[0] {
+		t.Errorf("lock = %q, want the winner %q", got, winners[0])
+	}
+	if found, _ := c.GetMany([]string{"ad:0:0", "ad:19:99"}, ctx); len(found) != 0 {
+		t.Errorf("GetMany after DeleteByPrefix = %v, want nothing", found)
+	}
+}
+
+func TestMemoryCacheExpiry(t *testing.T) {
+	c := newTestMemory(t, time.Hour)
+	ctx := context.Background()
+	// With the jitter an entry lives at most 22ms
+	c.Set("ad:1", "bike", 20*time.Millisecond, ctx)
+	c.SetMany(map[string]string{"ad:2": "car"}, 20*time.Millisecond, ctx)
+	c.SetNX("lock", "owner", 20*time.Millisecond, ctx)
+	c.IncrExpire("views", 20*time.Millisecond, ctx)
+	c.Set("ad:3", "boat", time.Hour, ctx)
+
+	if got, _ := c.Get("ad:1", ctx); got != "bike" {
+		t.Fatalf("Get before expiry = %q, want bike", got)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	for _, key := range []string{"ad:1", "ad:2", "lock", "views"} {
+		if got, _ := c.Get(key, ctx); got != "" {
+			t.Errorf("Get(%s) after expiry = %q, want a miss", key, got)
+		}
+	}
+	if found, _ := c.GetMany([]string{"ad:1", "ad:2", "ad:3"}, ctx); len(found) != 1 || found["ad:3"] != "boat" {
+		t.Errorf("GetMany after expiry = %v, want only ad:3", found)
+	}
+	if deleted, _ := c.DeleteIfValue("ad:1", "bike", ctx); deleted {
+		t.Error("DeleteIfValue deleted an expired entry")
+	}
+	// Expired keys are free again
+	if ok, _ := c.SetNX("lock", "other", time.Minute, ctx); !ok {
+		t.Error("SetNX on an expired lock failed")
+	}
+	if views, _ := c.Incr("views", ctx); views != 1 {
+		t.Errorf("Incr of an expired counter = %d, want 1", views)
+	}
+}
+
+func TestMemoryCacheJanitorDropsExpiredEntries(t *testing.T) {
+	c := newTestMemory(t, 5*time.Millisecond)
+	ctx := context.Background()
+	c.Set("ad:1", "bike", 10*time.Millisecond, ctx)
+	c.Set("ad:2", "car", time.Hour, ctx)
+
+	size := func() int {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		return len(c.entries)
+	}
+	deadline := time.Now().Add(time.Second)
+	for size() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d entries left after a second, want the expired one removed", size())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got, _ := c.Get("ad:2", ctx); got != "car" {
+		t.Errorf("Get(ad:2) = %q, want the unexpired entry kept", got)
+	}
+}
+
+func TestNewSelectsDriver(t *testing.T) {
+	for _, driver := range []string{DriverMemory, DriverNone} {
+		c, err := New(config.CacheConfig{Driver: driver, KeyPrefix: "test"}, config.RedisConfig{})
+		if err != nil {
+			t.Fatalf("New(%s): %v", driver, err)
+		}
+		defer c.Close()
+		switch driver {
+		case DriverMemory:
+			prefixed, ok := c.(*PrefixedCache)
+			if !ok {
+				t.Fatalf("New(memory) = %T, want a namespaced cache", c)
+			}
+			if _, ok := prefixed.Cache.(*MemoryCache); !ok {
+				t.Errorf("New(memory) wraps %T, want *MemoryCache", prefixed.Cache)
+			}
+		case DriverNone:
+			if _, ok := c.(NoopCache); !ok {
+				t.Errorf("New(none) = %T, want NoopCache", c)
+			}
+		}
+	}
+	if _, err := New(config.CacheConfig{Driver: "memcached"}, config.RedisConfig{}); err == nil {
+		t.Error("New accepted an unknown driver")
+	}
+}
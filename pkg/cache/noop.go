@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// NoopCache is a Cache that stores nothing, so every read is a miss
+type NoopCache struct{}
+
+// Get always reports a miss
+func (NoopCache) Get(key string, ctx context.Context) (string, error) {
+	return "", nil
+}
+
+// Set discards the value
+func (NoopCache) Set(key string, value string, expiration time.Duration, ctx context.Context) error {
+	return nil
+}
+
+// Delete does nothing
+func (NoopCache) Delete(key string, ctx context.Context) error {
+	return nil
+}
+
+// DeleteIfValue always succeeds since nothing is stored
+func (NoopCache) DeleteIfValue(key string, value string, ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// DeleteByPrefix does nothing
+func (NoopCache) DeleteByPrefix(prefix string, ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+// Incr always returns 1 since nothing is stored
+func (NoopCache) Incr(key string, ctx context.Context) (int64, error) {
+	return 1, nil
+}
+
+// IncrExpire always returns 1 since nothing is stored
+func (NoopCache) IncrExpire(key string, expiration time.Duration, ctx context.Context) (int64, error) {
+	return 1, nil
+}
+
+// SetNX always succeeds since nothing is stored
+func (NoopCache) SetNX(key string, value string, expiration time.Duration, ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// GetMany always reports misses
+func (NoopCache) GetMany(keys []string, ctx context.Context) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+// SetMany discards the values
+func (NoopCache) SetMany(values map[string]string, expiration time.Duration, ctx context.Context) error {
+	return nil
+}
+
+// Close has nothing to release
+func (NoopCache) Close() error {
+	return nil
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"ad_service/pkg/metrics"
+	"context"
+	"time"
+)
+
+// rebuildPollInterval is how often a caller waiting on another replica's rebuild re-reads the cache
+const rebuildPollInterval = 25 * time.Millisecond
+
+// RebuildOptions controls how GetOrRebuild protects an expensive rebuild
+type RebuildOptions struct {
+	TTL         time.Duration // logical TTL of the rebuilt value
+	LockTimeout time.Duration // upper bound on how long the rebuild lock is held
+	LockWait    time.Duration // how long callers that lost the lock wait for the winner
+}
+
+// GetOrRebuild returns the value cached at key and whether it was a hit. On a miss only the caller
+// that wins a short-lived SETNX lock runs rebuild and repopulates the cache; the others poll the
+// cache for up to LockWait and only rebuild themselves if the winner hasn't finished by then.
+func GetOrRebuild(c Cache, key string, opts RebuildOptions, rebuild func() (string, error), ctx context.Context) (string, bool, error) {
+	if value, err := c.Get(key, ctx); err == nil && value != "" {
+		return value, true, nil
+	}
+	// Caching disabled for this entity, nothing to protect
+	if opts.TTL <= 0 {
+		value, err := rebuild()
+		return value, false, err
+	}
+
+	lockKey := Key("lock", key)
+	acquired, err := c.SetNX(lockKey, "1", opts.LockTimeout, ctx)
+	if err == nil && !acquired {
+		metrics.CacheRebuildLocks.WithLabelValues("contended").Inc()
+		if value, ok := waitForRebuild(c, key, opts.LockWait, ctx); ok {
+			return value, true, nil
+		}
+		metrics.CacheRebuildLocks.WithLabelValues("wait_timeout").Inc()
+	} else if acquired {
+		metrics.CacheRebuildLocks.WithLabelValues("acquired").Inc()
+		defer c.Delete(lockKey, ctx)
+	}
+
+	value, err := rebuild()
+	if err != nil {
+		return "", false, err
+	}
+	c.Set(key, value, opts.TTL, ctx)
+	return value, false, nil
+}
+
+// waitForRebuild polls the cache until the key is populated, the wait elapses or ctx is done
+func waitForRebuild(c Cache, key string, wait time.Duration, ctx context.Context) (string, bool) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	ticker := time.NewTicker(rebuildPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if value, err := c.Get(key, ctx); err == nil && value != "" {
+				return value, true
+			}
+		case <-timer.C:
+			return "", false
+		case <-ctx.Done():
+			return "", false
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"ad_service/pkg/metrics"
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// rebuildLocks returns the rebuild lock outcomes counted with result so far
+func rebuildLocks(result string) float64 {
+	return testutil.ToFloat64(metrics.CacheRebuildLocks.WithLabelValues(result))
+}
+
+func TestGetOrRebuildRunsOneRebuild(t *testing.T) {
+	c := newTestMemory(t, time.Minute)
+	ctx := context.Background()
+	opts := RebuildOptions{TTL: time.Minute, LockTimeout: time.Second, LockWait: time.Second}
+	contended := rebuildLocks("contended")
+	const callers = 20
+
+	var rebuilds atomic.Int32
+	rebuild := func() (string, error) {
+		rebuilds.Add(1)
+		// Long enough for every caller to miss while the winner is querying
+		time.Sleep(50 * time.Millisecond)
+		return "page", nil
+	}
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			value, _, err := GetOrRebuild(c, "ads:list:1", opts, rebuild, ctx)
+			if err != nil || value != "page" {
+				t.Errorf("GetOrRebuild = %q, %v; want the rebuilt page", value, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if got := rebuilds.Load(); got != 1 {
+		t.Errorf("%d rebuilds for %d parallel misses, want 1", got, callers)
+	}
+	if got := rebuildLocks("contended") - contended; got != callers-1 {
+		t.Errorf("contended locks = %v, want %d", got, callers-1)
+	}
+	// The winner released the lock
+	if got, _ := c.Get(Key("lock", "ads:list:1"), ctx); got != "" {
+		t.Errorf("rebuild lock still held: %q", got)
+	}
+}
+
+func TestGetOrRebuildGivesUpWaiting(t *testing.T) {
+	c := newTestMemory(t, time.Minute)
+	ctx := context.Background()
+	// Another replica holds the lock and never repopulates the key
+	c.SetNX(Key("lock", "ads:list:1"), "1", time.Minute, ctx)
+	timedOut := rebuildLocks("wait_timeout")
+
+	opts := RebuildOptions{TTL: time.Minute, LockTimeout: time.Second, LockWait: 60 * time.Millisecond}
+	start := time.Now()
+	value, hit, err := GetOrRebuild(c, "ads:list:1", opts, func() (string, error) { return "page", nil }, ctx)
+	if err != nil || hit || value != "page" {
+		t.Fatalf("GetOrRebuild = %q, %v, %v; want its own rebuild", value, hit, err)
+	}
+	if waited := time.Since(start); waited < opts.LockWait {
+		t.Errorf("rebuilt after %s, want a wait of %s first", waited, opts.LockWait)
+	}
+	if got := rebuildLocks("wait_timeout") - timedOut; got != 1 {
+		t.Errorf("wait timeouts = %v, want 1", got)
+	}
+}
+
+func TestGetOrRebuildWithoutTTL(t *testing.T) {
+	c := newTestMemory(t, time.Minute)
+	ctx := context.Background()
+	rebuilds := 0
+	for i := 0; i < 2; i++ {
+		value, hit, err := GetOrRebuild(c, "ads:list:1", RebuildOptions{}, func() (string, error) {
+			rebuilds++
+			return "page", nil
+		}, ctx)
+		if err != nil || hit || value != "page" {
+			t.Fatalf("GetOrRebuild = %q, %v, %v; want an uncached rebuild", value, hit, err)
+		}
+	}
+	if rebuilds != 2 {
+		t.Errorf("rebuilds = %d, want one per call with caching disabled", rebuilds)
+	}
+}
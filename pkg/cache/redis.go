@@ -2,8 +2,15 @@ package cache
 
 import (
 	"ad_service/internal/config"
+	"ad_service/pkg/metrics"
+	"ad_service/pkg/retry"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -12,46 +19,126 @@ import (
 	"go.opentelemetry.io/otel/codes"
 )
 
-// Cache struct holds the Redis client instance
-type Cache struct {
+// RedisCache is the Cache implementation backed by Redis
+type RedisCache struct {
 	Client *redis.Client
 }
 
-// NewCache initializes and returns a new Cache instance connected to Redis
-func NewCache() *Cache {
+// NewCache initializes and returns a new RedisCache instance connected to Redis.
+// It returns an error instead of exiting so the caller can decide to run without a cache.
+func NewCache(cfg config.RedisConfig) (*RedisCache, error) {
+	rdb, err := newRedisClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure Redis client: %v", err)
+	}
+
+	// Test the connection to Redis, backing off between attempts
+	errRetry := retry.Do(connectPolicy, func() error {
+		return rdb.Ping(context.Background()).Err()
+	}, context.Background())
+
+	if errRetry != nil {
+		rdb.Close()
+		// The last ping error carries the underlying cause, e.g. a TLS handshake failure
+		return nil, fmt.Errorf("could not connect to Redis after multiple attempts: %v", errRetry)
+	}
+
+	return &RedisCache{Client: rdb}, nil
+}
 
-	// Load configuration from Viper
-	cfg, err := config.LoadConfig()
+// connectPolicy bounds how long startup waits for Redis. The cache is optional,
+// so it gives up well before the MySQL connection would.
+var connectPolicy = retry.Policy{
+	InitialInterval: 500 * time.Millisecond,
+	MaxInterval:     2 * time.Second,
+	Multiplier:      2,
+	Jitter:          0.2,
+	MaxElapsedTime:  6 * time.Second,
+}
+
+// newRedisClient creates a failover client when a sentinel section is configured,
+// and a standalone client otherwise. Both are *redis.Client, so callers don't care which.
+func newRedisClient(cfg config.RedisConfig) (*redis.Client, error) {
+	tlsConfig, err := newTLSConfig(cfg.TLS)
 	if err != nil {
-		log.Fatalf("Could not load configuration: %v", err)
+		return nil, err
+	}
+
+	if cfg.Sentinel.Enabled() {
+		log.Printf("Using Redis Sentinel master %q via %v", cfg.Sentinel.MasterName, cfg.Sentinel.Addresses)
+		go watchFailover(cfg.Sentinel)
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.Sentinel.MasterName,
+			SentinelAddrs:    cfg.Sentinel.Addresses,
+			SentinelPassword: cfg.Sentinel.Password,
+			Username:         cfg.Username,
+			Password:         cfg.Password,
+			DB:               cfg.DB,
+			PoolSize:         cfg.PoolSize,
+			TLSConfig:        tlsConfig,
+		}), nil
 	}
-	redisHost := cfg.Redis.Host
-	redisPort := cfg.Redis.Port
-	redisPassword := cfg.Redis.Password
-	redisDB := cfg.Redis.DB
 
 	// Create a Redis client using configuration
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     redisHost + ":" + redisPort,
-		Password: redisPassword, // Password from config (can be empty)
-		DB:       redisDB,       // DB number from config
-	})
+	return redis.NewClient(&redis.Options{
+		Addr:      cfg.Host + ":" + cfg.Port,
+		Username:  cfg.Username, // ACL user from config (can be empty)
+		Password:  cfg.Password, // Password from config (can be empty)
+		DB:        cfg.DB,       // DB number from config
+		PoolSize:  cfg.PoolSize, // connections per node, go-redis picks 10 per CPU when 0
+		TLSConfig: tlsConfig,    // nil unless TLS is enabled
+	}), nil
+}
 
-	// Test the connection to Redis
-	errRetry := retry(func() error {
-		_, err := rdb.Ping(context.Background()).Result()
-		return err
-	}, 3, 2*time.Second) // Retry 3 times with 2s delay if connection fails
+// newTLSConfig builds the tls.Config for the Redis connection, or nil when TLS is disabled
+func newTLSConfig(cfg config.RedisTLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
 
-	if errRetry != nil {
-		log.Fatalf("Could not connect to Redis after multiple attempts: %v", err)
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		caPEM, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read Redis CA bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in Redis CA bundle %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load Redis client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
 
-	return &Cache{Client: rdb}
+	return tlsConfig, nil
+}
+
+// watchFailover logs every master switch announced by the sentinels
+func watchFailover(cfg config.SentinelConfig) {
+	sentinel := redis.NewSentinelClient(&redis.Options{
+		Addr:     cfg.Addresses[0],
+		Password: cfg.Password,
+	})
+	pubsub := sentinel.Subscribe(context.Background(), "+switch-master")
+	// The channel reconnects on its own when the sentinel connection drops
+	for msg := range pubsub.Channel() {
+		log.Printf("Redis failover: master switched (%s)", msg.Payload)
+	}
 }
 
 // Get retrieves a value from Redis by key, with tracing
-func (c *Cache) Get(key string, ctx context.Context) (string, error) {
+func (c *RedisCache) Get(key string, ctx context.Context) (string, error) {
 	// Start a new span for the Get operation
 	tracer := otel.Tracer("cache")
 	ctx, span := tracer.Start(ctx, "Redis Get")
@@ -60,7 +147,9 @@ func (c *Cache) Get(key string, ctx context.Context) (string, error) {
 	// Add key as attribute for tracing
 	span.SetAttributes(attribute.String("redis.key", key))
 	// Get the value associated with the key
+	start := time.Now()
 	result, err := c.Client.Get(ctx, key).Result()
+	observeRedis("get", lookupOutcome(err == redis.Nil, err), start, ctx)
 
 	if err == redis.Nil {
 		span.SetAttributes(attribute.String("Cache", "miss"))
@@ -76,13 +165,21 @@ func (c *Cache) Get(key string, ctx context.Context) (string, error) {
 	return result, nil
 }
 
-// Set stores a value in Redis with an expiration time, with tracing
-func (c *Cache) Set(key string, value string, expiration time.Duration, ctx context.Context) error {
+// Set stores a value in Redis with an expiration time, with tracing.
+// The expiration is the logical TTL: a ±10% jitter is applied here, and a non-positive
+// expiration means caching is disabled so nothing is stored.
+func (c *RedisCache) Set(key string, value string, expiration time.Duration, ctx context.Context) error {
+	if expiration <= 0 {
+		return nil
+	}
+
 	// Start a new span for the Set operation
 	tracer := otel.Tracer("cache")
 	ctx, span := tracer.Start(ctx, "Redis Set")
 	defer span.End()
 
+	expiration = jitter(expiration)
+
 	// Add key and expiration as attributes for tracing
 	span.SetAttributes(
 		attribute.String("redis.key", key),
@@ -90,7 +187,9 @@ func (c *Cache) Set(key string, value string, expiration time.Duration, ctx cont
 		attribute.Int64("redis.expiration", int64(expiration.Seconds())),
 	)
 	// Set the key-value pair with the specified expiration time
+	start := time.Now()
 	err := c.Client.Set(ctx, key, value, expiration).Err()
+	observeRedis("set", outcome(err), start, ctx)
 
 	if err != nil {
 		span.RecordError(err)
@@ -104,14 +203,16 @@ func (c *Cache) Set(key string, value string, expiration time.Duration, ctx cont
 }
 
 // Delete: removes a specific key from the Redis cache
-func (c *Cache) Delete(key string, ctx context.Context) error {
+func (c *RedisCache) Delete(key string, ctx context.Context) error {
 	// Start a new span for the Delete operation
 	tracer := otel.Tracer("cache")
 	ctx, span := tracer.Start(ctx, "Redis Delete")
 	defer span.End()
 
 	// Delete the key from the Redis cache.
+	start := time.Now()
 	err := c.Client.Del(ctx, key).Err()
+	observeRedis("del", outcome(err), start, ctx)
 	span.SetAttributes(attribute.String("redis.key", key))
 
 	if err != nil {
@@ -123,17 +224,277 @@ func (c *Cache) Delete(key string, ctx context.Context) error {
 	return nil
 }
 
-// retry is a helper function to retry Redis connection
-func retry(operation func() error, attempts int, delay time.Duration) error {
-	for i := 0; i < attempts; i++ {
-		if err := operation(); err != nil {
-			if i == attempts-1 {
-				return err // Return the final error if all attempts fail
+// deleteIfValueScript deletes a key only if it still holds the expected value, so a lock
+// holder whose TTL expired can't release a lock someone else acquired since
+var deleteIfValueScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// DeleteIfValue removes key only if it still holds value, atomically via a Lua script, with tracing
+func (c *RedisCache) DeleteIfValue(key string, value string, ctx context.Context) (bool, error) {
+	// Start a new span for the compare-and-delete
+	tracer := otel.Tracer("cache")
+	ctx, span := tracer.Start(ctx, "Redis DeleteIfValue")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("redis.key", key))
+	start := time.Now()
+	deleted, err := deleteIfValueScript.Run(ctx, c.Client, []string{key}, value).Int()
+	observeRedis("del_if_value", outcome(err), start, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Error in Redis compare-and-delete script")
+		return false, err
+	}
+
+	span.SetAttributes(attribute.Bool("redis.deleted", deleted == 1))
+	return deleted == 1, nil
+}
+
+// observeRedis records the latency of one Redis command with its outcome
+func observeRedis(operation, outcome string, start time.Time, ctx context.Context) {
+	metrics.ObserveWithTrace(metrics.RedisOperationDuration.WithLabelValues(operation, outcome), time.Since(start).Seconds(), ctx)
+}
+
+// outcome is the outcome label of a command that doesn't look anything up
+func outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// lookupOutcome is the outcome label of a read: hit, miss or error. redis.Nil is a miss, not an error.
+func lookupOutcome(miss bool, err error) string {
+	switch {
+	case miss:
+		return "miss"
+	case err != nil:
+		return "error"
+	}
+	return "hit"
+}
+
+// countFound counts the keys an MGET found; missing keys come back as nil
+func countFound(values []interface{}) int {
+	found := 0
+	for _, value := range values {
+		if value != nil {
+			found++
+		}
+	}
+	return found
+}
+
+// scanBatchSize is the COUNT hint for each SCAN call and the number of keys unlinked per pipeline
+const scanBatchSize = 500
+
+// maxScanIterations bounds the SCAN calls made by one DeleteByPrefix, so a huge keyspace can't
+// turn a write into an unbounded loop. Keys left over expire on their TTL.
+const maxScanIterations = 1000
+
+// globEscaper escapes the characters SCAN MATCH treats as glob patterns
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// DeleteByPrefix removes every key starting with prefix using SCAN and pipelined UNLINKs, with tracing.
+// KEYS is never used since it blocks Redis, and keys are unlinked one by one so the pipeline
+// never sends a multi-key command that would span cluster slots. A deadline on ctx stops the
+// iteration between batches rather than failing a command halfway; the caller can tell from ctx.Err().
+func (c *RedisCache) DeleteByPrefix(prefix string, ctx context.Context) (deleted int, err error) {
+	// Start a new span for the prefix delete
+	tracer := otel.Tracer("cache")
+	ctx, span := tracer.Start(ctx, "Redis DeleteByPrefix")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("redis.prefix", prefix))
+	start := time.Now()
+	defer func() {
+		observeRedis("delete_by_prefix", outcome(err), start, ctx)
+	}()
+
+	match := globEscaper.Replace(prefix) + "*"
+	deadline, bounded := ctx.Deadline()
+	ctx = context.WithoutCancel(ctx)
+	var cursor uint64
+	scanned := 0
+	for i := 0; i < maxScanIterations; i++ {
+		if bounded && time.Now().After(deadline) {
+			break
+		}
+		keys, next, err := c.Client.Scan(ctx, cursor, match, scanBatchSize).Result()
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Error in Redis SCAN operation")
+			return deleted, err
+		}
+		scanned += len(keys)
+		metrics.CachePrefixKeysScanned.Add(float64(len(keys)))
+
+		if len(keys) > 0 {
+			cmds, err := c.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				for _, key := range keys {
+					pipe.Unlink(ctx, key)
+				}
+				return nil
+			})
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, "Error in Redis UNLINK operation")
+				return deleted, err
+			}
+			// Another writer may have removed a key between SCAN and UNLINK, so count what was actually deleted
+			batchDeleted := 0
+			for _, cmd := range cmds {
+				if n, err := cmd.(*redis.IntCmd).Result(); err == nil {
+					batchDeleted += int(n)
+				}
 			}
-			time.Sleep(delay)
-			continue
+			deleted += batchDeleted
+			metrics.CachePrefixKeysDeleted.Add(float64(batchDeleted))
+		}
+
+		cursor = next
+		if cursor == 0 {
+			span.SetAttributes(attribute.Int("redis.scanned", scanned), attribute.Int("redis.deleted", deleted))
+			return deleted, nil
 		}
-		break
+	}
+
+	span.SetAttributes(attribute.Int("redis.scanned", scanned), attribute.Int("redis.deleted", deleted), attribute.Bool("redis.truncated", true))
+	return deleted, nil
+}
+
+// Incr atomically increments the integer stored at key and returns the new value, with tracing
+func (c *RedisCache) Incr(key string, ctx context.Context) (int64, error) {
+	// Start a new span for the Incr operation
+	tracer := otel.Tracer("cache")
+	ctx, span := tracer.Start(ctx, "Redis Incr")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("redis.key", key))
+	start := time.Now()
+	value, err := c.Client.Incr(ctx, key).Result()
+	observeRedis("incr", outcome(err), start, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Error in Redis INCR operation")
+		return 0, err
+	}
+
+	span.SetAttributes(attribute.Int64("redis.value", value))
+	return value, nil
+}
+
+// IncrExpire increments key and sets its expiration in one transaction, with tracing
+func (c *RedisCache) IncrExpire(key string, expiration time.Duration, ctx context.Context) (int64, error) {
+	tracer := otel.Tracer("cache")
+	ctx, span := tracer.Start(ctx, "Redis IncrExpire")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("redis.key", key))
+	start := time.Now()
+	pipe := c.Client.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, expiration)
+	_, err := pipe.Exec(ctx)
+	observeRedis("incr_expire", outcome(err), start, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Error in Redis INCR operation")
+		return 0, err
+	}
+
+	value := incr.Val()
+	span.SetAttributes(attribute.Int64("redis.value", value))
+	return value, nil
+}
+
+// GetMany retrieves several keys with a single MGET, with tracing
+func (c *RedisCache) GetMany(keys []string, ctx context.Context) (map[string]string, error) {
+	found := make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return found, nil
+	}
+
+	// Start a new span for the MGet operation
+	tracer := otel.Tracer("cache")
+	ctx, span := tracer.Start(ctx, "Redis MGet")
+	defer span.End()
+
+	span.SetAttributes(attribute.Int("redis.keys", len(keys)))
+	start := time.Now()
+	values, err := c.Client.MGet(ctx, keys...).Result()
+	observeRedis("mget", lookupOutcome(err == nil && countFound(values) < len(keys), err), start, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Error in Redis MGET operation")
+		return nil, err
+	}
+
+	// Missing keys come back as nil entries
+	for i, value := range values {
+		if str, ok := value.(string); ok {
+			found[keys[i]] = str
+		}
+	}
+
+	span.SetAttributes(attribute.Int("redis.hits", len(found)))
+	return found, nil
+}
+
+// SetMany stores several values in one pipeline, each with its own jittered expiration, with tracing
+func (c *RedisCache) SetMany(values map[string]string, expiration time.Duration, ctx context.Context) error {
+	if expiration <= 0 || len(values) == 0 {
+		return nil
+	}
+
+	// Start a new span for the pipelined Set operation
+	tracer := otel.Tracer("cache")
+	ctx, span := tracer.Start(ctx, "Redis SetMany")
+	defer span.End()
+
+	span.SetAttributes(attribute.Int("redis.keys", len(values)))
+	start := time.Now()
+	_, err := c.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, value := range values {
+			pipe.Set(ctx, key, value, jitter(expiration))
+		}
+		return nil
+	})
+	observeRedis("set_many", outcome(err), start, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Error in Redis pipelined SET operation")
+		return err
 	}
 	return nil
 }
+
+// SetNX stores a value only if the key doesn't exist yet, with tracing
+func (c *RedisCache) SetNX(key string, value string, expiration time.Duration, ctx context.Context) (bool, error) {
+	// Start a new span for the SetNX operation
+	tracer := otel.Tracer("cache")
+	ctx, span := tracer.Start(ctx, "Redis SetNX")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("redis.key", key), attribute.Int64("redis.expiration", int64(expiration.Seconds())))
+	start := time.Now()
+	ok, err := c.Client.SetNX(ctx, key, value, expiration).Result()
+	observeRedis("setnx", outcome(err), start, ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Error in Redis SETNX operation")
+		return false, err
+	}
+
+	span.SetAttributes(attribute.Bool("redis.set", ok))
+	return ok, nil
+}
+
+// Close closes the connection pool
+func (c *RedisCache) Close() error {
+	return c.Client.Close()
+}
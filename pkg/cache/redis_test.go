@@ -0,0 +1,294 @@
+package cache
+
+import (
+	"ad_service/internal/config"
+	"ad_service/pkg/metrics"
+	"ad_service/pkg/retry"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// newTestRedis returns a RedisCache on an in-process Redis server
+func newTestRedis(t *testing.T) (*RedisCache, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return &RedisCache{Client: client}, server
+}
+
+func TestRedisDeleteByPrefix(t *testing.T) {
+	tests := []struct {
+		name     string
+		matching int
+		prefix   string
+	}{
+		// More keys than one SCAN page of scanBatchSize
+		{"several scan pages", 3*scanBatchSize + 17, "ads:list:"},
+		{"no matches", 0, "ads:list:"},
+		// Glob characters in the prefix match literally
+		{"glob characters", 3, "ads:list:*?[x]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := newTestRedis(t)
+			for i := 0; i < tt.matching; i++ {
+				server.Set(fmt.Sprintf("%s%d", tt.prefix, i), "page")
+			}
+			unrelated := []string{"ads:list_generation", "ad:1", "ads:lis", "other:ads:list:1", "ads:listing:1"}
+			for _, key := range unrelated {
+				server.Set(key, "keep")
+			}
+
+			deleted, err := c.DeleteByPrefix(tt.prefix, context.Background())
+			if err != nil {
+				t.Fatalf("DeleteByPrefix: %v", err)
+			}
+			if deleted != tt.matching {
+				t.Errorf("deleted = %d, want %d", deleted, tt.matching)
+			}
+			if left := len(server.Keys()); left != len(unrelated) {
+				t.Errorf("%d keys left, want the %d unrelated ones: %v", left, len(unrelated), server.Keys())
+			}
+			for _, key := range unrelated {
+				if !server.Exists(key) {
+					t.Errorf("unrelated key %s was deleted", key)
+				}
+			}
+		})
+	}
+}
+
+func TestRedisDeleteByPrefixStopsAtDeadline(t *testing.T) {
+	c, server := newTestRedis(t)
+	for i := 0; i < 10; i++ {
+		server.Set(fmt.Sprintf("ads:list:%d", i), "page")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	deleted, err := c.DeleteByPrefix("ads:list:", ctx)
+	if err != nil {
+		t.Fatalf("DeleteByPrefix: %v", err)
+	}
+	if deleted != 0 || len(server.Keys()) != 10 {
+		t.Errorf("a passed deadline should stop before the first batch, deleted %d", deleted)
+	}
+}
+
+func TestMemoryDeleteByPrefix(t *testing.T) {
+	c := NewMemoryCache(time.Minute)
+	defer c.Close()
+	ctx := context.Background()
+	for _, key := range []string{"ads:list:1", "ads:list:2", "ads:list_generation", "ad:1"} {
+		c.Set(key, "v", time.Minute, ctx)
+	}
+
+	deleted, err := c.DeleteByPrefix("ads:list:", ctx)
+	if err != nil || deleted != 2 {
+		t.Fatalf("DeleteByPrefix = %d, %v; want 2", deleted, err)
+	}
+	for key, want := range map[string]string{"ads:list:1": "", "ads:list_generation": "v", "ad:1": "v"} {
+		if got, _ := c.Get(key, ctx); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestNewRedisClientSelection(t *testing.T) {
+	server := miniredis.RunT(t)
+	ctx := context.Background()
+
+	// Without a sentinel section the standalone host and port are used
+	standalone, err := NewCache(config.RedisConfig{Host: server.Host(), Port: server.Port()})
+	if err != nil {
+		t.Fatalf("NewCache: %v", err)
+	}
+	defer standalone.Close()
+	if got := standalone.Client.Options().Addr; got != server.Addr() {
+		t.Errorf("standalone client dials %s, want %s", got, server.Addr())
+	}
+	if err := standalone.Set("ad:1", "bike", time.Minute, ctx); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if got, _ := server.Get("ad:1"); got != "bike" {
+		t.Errorf("stored value = %q, want bike", got)
+	}
+
+	// A sentinel section switches to the failover client, even with a host configured
+	failover, err := newRedisClient(config.RedisConfig{
+		Host:     "ignored",
+		Port:     "6379",
+		Sentinel: config.SentinelConfig{MasterName: "ads", Addresses: []string{server.Addr()}},
+	})
+	if err != nil {
+		t.Fatalf("newRedisClient: %v", err)
+	}
+	defer failover.Close()
+	if got := failover.Options().Addr; got != "FailoverClient" {
+		t.Errorf("sentinel config created a client for %s, want a failover client", got)
+	}
+}
+
+func TestFailoverIsLogged(t *testing.T) {
+	server := miniredis.RunT(t)
+	var logs syncBuffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	go watchFailover(config.SentinelConfig{MasterName: "ads", Addresses: []string{server.Addr()}})
+	waitUntil(t, func() bool { return server.PubSubNumSub("+switch-master")["+switch-master"] == 1 })
+	server.Publish("+switch-master", "ads 10.0.0.1 6379 10.0.0.2 6379")
+
+	waitUntil(t, func() bool {
+		return strings.Contains(logs.String(), "Redis failover: master switched (ads 10.0.0.1 6379 10.0.0.2 6379)")
+	})
+}
+
+// syncBuffer is a bytes.Buffer safe for the logger and the test to share
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitUntil polls cond until it holds, failing the test after a second
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within a second")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// redisObservations returns how many Redis commands were timed with operation and outcome
+func redisObservations(t *testing.T, operation, outcome string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := metrics.RedisOperationDuration.WithLabelValues(operation, outcome).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestRedisLatencyIsObserved(t *testing.T) {
+	c, server := newTestRedis(t)
+	ctx := context.Background()
+	hits, misses, sets := redisObservations(t, "get", "hit"), redisObservations(t, "get", "miss"), redisObservations(t, "set", "ok")
+
+	mgetHits, mgetMisses, dels := redisObservations(t, "mget", "hit"), redisObservations(t, "mget", "miss"), redisObservations(t, "del", "ok")
+
+	c.Get("ad:1", ctx)
+	c.Set("ad:1", "bike", time.Minute, ctx)
+	c.Get("ad:1", ctx)
+	c.Get("ad:1", ctx)
+	// A batch read missing any key counts as a miss
+	c.GetMany([]string{"ad:1"}, ctx)
+	c.GetMany([]string{"ad:1", "ad:2"}, ctx)
+	c.Delete("ad:1", ctx)
+	server.Close()
+	failed := redisObservations(t, "get", "error")
+	c.Get("ad:1", ctx)
+
+	if got := redisObservations(t, "get", "miss") - misses; got != 1 {
+		t.Errorf("timed misses = %d, want 1", got)
+	}
+	if got := redisObservations(t, "get", "hit") - hits; got != 2 {
+		t.Errorf("timed hits = %d, want 2", got)
+	}
+	if got := redisObservations(t, "set", "ok") - sets; got != 1 {
+		t.Errorf("timed sets = %d, want 1", got)
+	}
+	if got := redisObservations(t, "get", "error") - failed; got != 1 {
+		t.Errorf("timed failed reads = %d, want 1", got)
+	}
+	if got := redisObservations(t, "mget", "hit") - mgetHits; got != 1 {
+		t.Errorf("timed batch hits = %d, want 1", got)
+	}
+	if got := redisObservations(t, "mget", "miss") - mgetMisses; got != 1 {
+		t.Errorf("timed batch misses = %d, want 1", got)
+	}
+	if got := redisObservations(t, "del", "ok") - dels; got != 1 {
+		t.Errorf("timed deletes = %d, want 1", got)
+	}
+}
+
+func TestRedisGetManyAndSetMany(t *testing.T) {
+	c, server := newTestRedis(t)
+	ctx := context.Background()
+	server.Set("ad:1", "one")
+	server.Set("ad:3", "three")
+	server.Set("ad:5", "")
+
+	found, err := c.GetMany([]string{"ad:1", "ad:2", "ad:3", "ad:4", "ad:5"}, ctx)
+	if err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+	// Missing keys are absent rather than empty, an empty value is still found
+	if len(found) != 3 || found["ad:1"] != "one" || found["ad:3"] != "three" {
+		t.Errorf("GetMany = %v, want ad:1, ad:3 and the empty ad:5", found)
+	}
+	if _, ok := found["ad:5"]; !ok {
+		t.Error("GetMany dropped a key holding an empty value")
+	}
+	if found, err := c.GetMany(nil, ctx); err != nil || len(found) != 0 {
+		t.Errorf("GetMany of no keys = %v, %v; want an empty map", found, err)
+	}
+
+	if err := c.SetMany(map[string]string{"ad:2": "two", "ad:4": "four"}, time.Minute, ctx); err != nil {
+		t.Fatalf("SetMany: %v", err)
+	}
+	for key, want := range map[string]string{"ad:2": "two", "ad:4": "four"} {
+		if got, _ := server.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+		// Every key gets its own jittered TTL around the logical one
+		if ttl := server.TTL(key); ttl < 54*time.Second || ttl > 66*time.Second {
+			t.Errorf("TTL of %s = %s, want within 10%% of a minute", key, ttl)
+		}
+	}
+	if err := c.SetMany(map[string]string{"ad:6": "six"}, 0, ctx); err != nil || server.Exists("ad:6") {
+		t.Errorf("SetMany without a TTL stored ad:6, %v", err)
+	}
+}
+
+func TestNewCacheReturnsConnectError(t *testing.T) {
+	server := miniredis.RunT(t)
+	host, port := server.Host(), server.Port()
+	server.Close()
+	policy := connectPolicy
+	connectPolicy = retry.Policy{InitialInterval: time.Millisecond, MaxAttempts: 2}
+	t.Cleanup(func() { connectPolicy = policy })
+
+	// The caller decides whether to run without a cache
+	c, err := NewCache(config.RedisConfig{Host: host, Port: port})
+	if c != nil || err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("NewCache with Redis down = %v, %v; want the connection error", c, err)
+	}
+}
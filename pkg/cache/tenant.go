@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"ad_service/pkg/tenant"
+	"context"
+	"strings"
+	"time"
+)
+
+// tenantKeyPrefix starts the keys of every tenant, followed by the tenant ID
+const tenantKeyPrefix = "t:"
+
+// TenantKey is key as stored for the tenant of ctx, e.g. "ad:42" becomes "t:acme:ad:42". Keys
+// used without a tenant, like the locks of background jobs, are left as they are.
+func TenantKey(key string, ctx context.Context) string {
+	id, ok := tenant.FromContext(ctx)
+	if !ok {
+		return key
+	}
+	return tenantKeyPrefix + id + ":" + key
+}
+
+// SplitTenantKey returns the tenant and the key of a key built by TenantKey, and false for a key
+// of no tenant
+func SplitTenantKey(key string) (string, string, bool) {
+	if !strings.HasPrefix(key, tenantKeyPrefix) {
+		return "", key, false
+	}
+	id, rest, ok := strings.Cut(strings.TrimPrefix(key, tenantKeyPrefix), ":")
+	if !ok {
+		return "", key, false
+	}
+	return id, rest, true
+}
+
+// TenantCache keeps the keys of every tenant apart, under the tenant of each call's context, so
+// an entry cached for one tenant is never served to another whatever key the caller builds
+type TenantCache struct {
+	Cache
+}
+
+// NewTenantCache wraps c so all keys live under the tenant of the context
+func NewTenantCache(c Cache) *TenantCache {
+	return &TenantCache{Cache: c}
+}
+
+// Get reads the tenant's key
+func (c *TenantCache) Get(key string, ctx context.Context) (string, error) {
+	return c.Cache.Get(TenantKey(key, ctx), ctx)
+}
+
+// Set writes the tenant's key
+func (c *TenantCache) Set(key string, value string, expiration time.Duration, ctx context.Context) error {
+	return c.Cache.Set(TenantKey(key, ctx), value, expiration, ctx)
+}
+
+// Delete removes the tenant's key
+func (c *TenantCache) Delete(key string, ctx context.Context) error {
+	return c.Cache.Delete(TenantKey(key, ctx), ctx)
+}
+
+// DeleteIfValue removes the tenant's key if it holds value
+func (c *TenantCache) DeleteIfValue(key string, value string, ctx context.Context) (bool, error) {
+	return c.Cache.DeleteIfValue(TenantKey(key, ctx), value, ctx)
+}
+
+// DeleteByPrefix removes the tenant's keys starting with prefix
+func (c *TenantCache) DeleteByPrefix(prefix string, ctx context.Context) (int, error) {
+	return c.Cache.DeleteByPrefix(TenantKey(prefix, ctx), ctx)
+}
+
+// Incr increments the tenant's key
+func (c *TenantCache) Incr(key string, ctx context.Context) (int64, error) {
+	return c.Cache.Incr(TenantKey(key, ctx), ctx)
+}
+
+// IncrExpire increments the tenant's key and sets its expiration
+func (c *TenantCache) IncrExpire(key string, expiration time.Duration, ctx context.Context) (int64, error) {
+	return c.Cache.IncrExpire(TenantKey(key, ctx), expiration, ctx)
+}
+
+// GetMany reads the tenant's keys and returns the values under the caller's keys
+func (c *TenantCache) GetMany(keys []string, ctx context.Context) (map[string]string, error) {
+	scoped := make([]string, len(keys))
+	byScoped := make(map[string]string, len(keys))
+	for i, key := range keys {
+		scoped[i] = TenantKey(key, ctx)
+		byScoped[scoped[i]] = key
+	}
+	values, err := c.Cache.GetMany(scoped, ctx)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]string, len(values))
+	for key, value := range values {
+		found[byScoped[key]] = value
+	}
+	return found, nil
+}
+
+// SetMany writes the tenant's keys
+func (c *TenantCache) SetMany(values map[string]string, expiration time.Duration, ctx context.Context) error {
+	scoped := make(map[string]string, len(values))
+	for key, value := range values {
+		scoped[TenantKey(key, ctx)] = value
+	}
+	return c.Cache.SetMany(scoped, expiration, ctx)
+}
+
+// SetNX sets the tenant's key if it doesn't exist
+func (c *TenantCache) SetNX(key string, value string, expiration time.Duration, ctx context.Context) (bool, error) {
+	return c.Cache.SetNX(TenantKey(key, ctx), value, expiration, ctx)
+}
@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"ad_service/pkg/tenant"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTenantKey(t *testing.T) {
+	acme := tenant.With(context.Background(), "acme")
+	if key := TenantKey("ad:42", acme); key != "t:acme:ad:42" {
+		t.Errorf("TenantKey = %q, want t:acme:ad:42", key)
+	}
+	// Keys of no tenant, like the locks of background jobs, are left alone
+	if key := TenantKey("lock:expire", context.Background()); key != "lock:expire" {
+		t.Errorf("TenantKey without a tenant = %q, want the key unchanged", key)
+	}
+
+	tests := []struct {
+		key    string
+		tenant string
+		rest   string
+		ok     bool
+	}{
+		{"t:acme:ad:42", "acme", "ad:42", true},
+		{"t:acme:", "acme", "", true},
+		{"ad:42", "", "ad:42", false},
+		{"t:acme", "", "t:acme", false},
+	}
+	for _, tt := range tests {
+		id, rest, ok := SplitTenantKey(tt.key)
+		if id != tt.tenant || rest != tt.rest || ok != tt.ok {
+			t.Errorf("SplitTenantKey(%q) = %q, %q, %v; want %q, %q, %v", tt.key, id, rest, ok, tt.tenant, tt.rest, tt.ok)
+		}
+	}
+}
+
+func TestTenantCacheKeepsTenantsApart(t *testing.T) {
+	memory := NewMemoryCache(time.Minute)
+	defer memory.Close()
+	c := NewTenantCache(memory)
+	acme, beta := tenant.With(context.Background(), "acme"), tenant.With(context.Background(), "beta")
+
+	c.Set("ad:1", "acme 1", time.Minute, acme)
+	c.SetMany(map[string]string{"ad:2": "acme 2", "ad:3": "acme 3"}, time.Minute, acme)
+	c.Set("ad:1", "beta 1", time.Minute, beta)
+
+	if got, _ := c.Get("ad:1", acme); got != "acme 1" {
+		t.Errorf("acme's ad:1 = %q", got)
+	}
+	if got, _ := c.Get("ad:2", beta); got != "" {
+		t.Errorf("beta reads %q under acme's ad:2", got)
+	}
+	// GetMany answers under the caller's keys, with only the tenant's entries
+	if got, _ := c.GetMany([]string{"ad:1", "ad:2", "ad:3"}, beta); len(got) != 1 || got["ad:1"] != "beta 1" {
+		t.Errorf("beta's GetMany = %v, want only its ad:1", got)
+	}
+	if got, _ := c.GetMany([]string{"ad:1", "ad:2"}, acme); len(got) != 2 || got["ad:2"] != "acme 2" {
+		t.Errorf("acme's GetMany = %v, want both ads", got)
+	}
+
+	// Deleting beta's keys leaves acme's
+	if n, _ := c.DeleteByPrefix("ad:", beta); n != 1 {
+		t.Errorf("DeleteByPrefix as beta removed %d keys, want 1", n)
+	}
+	c.Delete("ad:3", beta)
+	if ok, _ := c.DeleteIfValue("ad:1", "beta 1", beta); ok {
+		t.Error("DeleteIfValue as beta removed acme's ad:1")
+	}
+	if got, _ := memory.GetMany([]string{"t:acme:ad:1", "t:acme:ad:2", "t:acme:ad:3"}, context.Background()); len(got) != 3 {
+		t.Errorf("acme's entries = %v after beta's deletes, want all 3", got)
+	}
+
+	// Counters and locks are per tenant too
+	c.Incr("gen", acme)
+	if n, _ := c.IncrExpire("gen", time.Minute, beta); n != 1 {
+		t.Errorf("beta's counter = %d, want it apart from acme's", n)
+	}
+	if ok, _ := c.SetNX("lock:ad:1", "x", time.Minute, acme); !ok {
+		t.Error("acme couldn't take its lock")
+	}
+	if ok, _ := c.SetNX("lock:ad:1", "y", time.Minute, beta); !ok {
+		t.Error("beta couldn't take its lock while acme held the same-named one")
+	}
+}
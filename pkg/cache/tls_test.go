@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"ad_service/internal/config"
+	"ad_service/pkg/retry"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate and its key to dir and returns their paths
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "redis-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir)
+	notPEM := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		cfg      config.RedisTLSConfig
+		rootCAs  bool
+		certs    int
+		insecure bool
+		err      string // part of the error, empty when it succeeds
+	}{
+		{"system roots", config.RedisTLSConfig{Enabled: true}, false, 0, false, ""},
+		{"CA bundle", config.RedisTLSConfig{Enabled: true, CAFile: certFile}, true, 0, false, ""},
+		{"client certificate", config.RedisTLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile}, false, 1, false, ""},
+		{"CA bundle and client certificate", config.RedisTLSConfig{Enabled: true, CAFile: certFile, CertFile: certFile, KeyFile: keyFile}, true, 1, false, ""},
+		{"skip verify", config.RedisTLSConfig{Enabled: true, InsecureSkipVerify: true}, false, 0, true, ""},
+		{"missing CA bundle", config.RedisTLSConfig{Enabled: true, CAFile: filepath.Join(dir, "missing.pem")}, false, 0, false, "could not read Redis CA bundle"},
+		{"CA bundle without certificates", config.RedisTLSConfig{Enabled: true, CAFile: notPEM}, false, 0, false, "no certificates found in Redis CA bundle"},
+		{"key that isn't a key", config.RedisTLSConfig{Enabled: true, CertFile: certFile, KeyFile: notPEM}, false, 0, false, "could not load Redis client certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newTLSConfig(tt.cfg)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("newTLSConfig() = %v, want an error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newTLSConfig(): %v", err)
+			}
+			if got.MinVersion != tls.VersionTLS12 {
+				t.Errorf("MinVersion = %x, want TLS 1.2", got.MinVersion)
+			}
+			if (got.RootCAs != nil) != tt.rootCAs {
+				t.Errorf("RootCAs set = %v, want %v", got.RootCAs != nil, tt.rootCAs)
+			}
+			if len(got.Certificates) != tt.certs {
+				t.Errorf("%d client certificates, want %d", len(got.Certificates), tt.certs)
+			}
+			if got.InsecureSkipVerify != tt.insecure {
+				t.Errorf("InsecureSkipVerify = %v, want %v", got.InsecureSkipVerify, tt.insecure)
+			}
+		})
+	}
+
+	if got, err := newTLSConfig(config.RedisTLSConfig{CAFile: certFile}); got != nil || err != nil {
+		t.Errorf("disabled TLS = %v, %v; want no tls.Config", got, err)
+	}
+}
+
+func TestConnectErrorShowsTLSCause(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir())
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	policy := connectPolicy
+	connectPolicy = retry.Policy{InitialInterval: time.Millisecond, MaxAttempts: 2}
+	t.Cleanup(func() { connectPolicy = policy })
+
+	// The server's certificate isn't signed by a trusted CA
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	_, err = NewCache(config.RedisConfig{Host: host, Port: port, TLS: config.RedisTLSConfig{Enabled: true}})
+	if err == nil {
+		t.Fatal("NewCache trusted an unknown certificate")
+	}
+	if !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("NewCache error = %q, want the TLS failure", err)
+	}
+}
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"ad_service/internal/config"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// TrendingBucket is the time span one sorted set of views covers
+const TrendingBucket = time.Hour
+
+// trendingUnionTTL is how long the union of a window's buckets is reused by later requests
+const trendingUnionTTL = time.Minute
+
+// Trending counts ad views in hourly Redis sorted sets, scored by view count, and ranks ads over
+// a window by unioning the buckets it spans
+type Trending struct {
+	Client *redis.Client
+	Prefix string        // namespace of the trending keys
+	MaxAge time.Duration // longest window that can be ranked; buckets expire after it
+}
+
+// NewTrending connects a Redis client for view counting. Keys live under the cache namespace.
+func NewTrending(cfg config.CacheConfig, redisCfg config.RedisConfig, maxWindow time.Duration) (*Trending, error) {
+	rdb, err := newRedisClient(redisCfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure Redis client: %v", err)
+	}
+	return &Trending{Client: rdb, Prefix: Namespace(cfg.KeyPrefix) + Key("trending", ""), MaxAge: maxWindow}, nil
+}
+
+// TrendingBuckets returns the start of every hourly bucket a window ending at now spans, oldest
+// first. The current, partial hour counts as one of them, so a 1h window is the current hour.
+func TrendingBuckets(window time.Duration, now time.Time) []time.Time {
+	current := now.UTC().Truncate(TrendingBucket)
+	count := int(window / TrendingBucket)
+	if count < 1 {
+		count = 1
+	}
+	buckets := make([]time.Time, count)
+	for i := range buckets {
+		buckets[i] = current.Add(-time.Duration(count-1-i) * TrendingBucket)
+	}
+	return buckets
+}
+
+// bucketKey is the key of the sorted set counting the views of the tenant of ctx in the hour
+// starting at start
+func (t *Trending) bucketKey(start time.Time, ctx context.Context) string {
+	return t.Prefix + TenantKey(Key("views", start.UTC().Format("2006010215")), ctx)
+}
+
+// RecordView adds one view of the ad to the current hour's bucket, with tracing. The bucket
+// expires once no window can reach it anymore.
+func (t *Trending) RecordView(id int64, ctx context.Context) error {
+	tracer := otel.Tracer("cache")
+	ctx, span := tracer.Start(ctx, "Redis ZINCRBY")
+	defer span.End()
+
+	now := time.Now()
+	key := t.bucketKey(now.UTC().Truncate(TrendingBucket), ctx)
+	span.SetAttributes(attribute.String("redis.key", key), attribute.Int64("ad_id", id))
+
+	pipe := t.Client.TxPipeline()
+	pipe.ZIncrBy(ctx, key, 1, strconv.FormatInt(id, 10))
+	pipe.Expire(ctx, key, t.MaxAge+TrendingBucket)
+	if _, err := pipe.Exec(ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Error in Redis ZINCRBY operation")
+		return err
+	}
+	return nil
+}
+
+// Top returns the IDs of the most viewed ads over the window, most viewed first, with tracing.
+// The union of the window's buckets is stored for a minute so concurrent requests share it.
+func (t *Trending) Top(window time.Duration, limit int, ctx context.Context) ([]int64, error) {
+	tracer := otel.Tracer("cache")
+	ctx, span := tracer.Start(ctx, "Redis ZUNIONSTORE")
+	defer span.End()
+
+	buckets := TrendingBuckets(window, time.Now())
+	keys := make([]string, len(buckets))
+	for i, start := range buckets {
+		keys[i] = t.bucketKey(start, ctx)
+	}
+	union := t.Prefix + TenantKey(Key("top", window.String(), buckets[len(buckets)-1].Format("2006010215")), ctx)
+	span.SetAttributes(attribute.String("redis.key", union), attribute.Int("redis.buckets", len(keys)))
+
+	exists, err := t.Client.Exists(ctx, union).Result()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Error in Redis EXISTS operation")
+		return nil, err
+	}
+	if exists == 0 {
+		pipe := t.Client.TxPipeline()
+		pipe.ZUnionStore(ctx, union, &redis.ZStore{Keys: keys})
+		pipe.Expire(ctx, union, trendingUnionTTL)
+		if _, err := pipe.Exec(ctx); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "Error in Redis ZUNIONSTORE operation")
+			return nil, err
+		}
+	}
+
+	members, err := t.Client.ZRevRange(ctx, union, 0, int64(limit-1)).Result()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Error in Redis ZREVRANGE operation")
+		return nil, err
+	}
+	ids := make([]int64, 0, len(members))
+	for _, member := range members {
+		if id, err := strconv.ParseInt(member, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// Close releases the Redis connections
+func (t *Trending) Close() error {
+	return t.Client.Close()
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"ad_service/pkg/tenant"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestTrendingBuckets(t *testing.T) {
+	at := func(day, hour, minute, second int) time.Time {
+		return time.Date(2026, 3, day, hour, minute, second, 0, time.UTC)
+	}
+	tests := []struct {
+		name   string
+		window time.Duration
+		now    time.Time
+		first  time.Time
+		count  int
+	}{
+		{"last second of the hour", time.Hour, at(10, 10, 59, 59), at(10, 10, 0, 0), 1},
+		// The next hour starts a new bucket, and the previous one drops out of a 1h window
+		{"first second of the hour", time.Hour, at(10, 11, 0, 0), at(10, 11, 0, 0), 1},
+		{"6h before the hour", 6 * time.Hour, at(10, 10, 59, 59), at(10, 5, 0, 0), 6},
+		{"6h after the hour", 6 * time.Hour, at(10, 11, 0, 0), at(10, 6, 0, 0), 6},
+		{"24h across midnight", 24 * time.Hour, at(10, 0, 30, 0), at(9, 1, 0, 0), 24},
+		{"7d", 7 * 24 * time.Hour, at(10, 0, 30, 0), at(3, 1, 0, 0), 168},
+		// Buckets are UTC hours whatever the zone of now
+		{"other zone", time.Hour, time.Date(2026, 3, 10, 14, 15, 0, 0, time.FixedZone("UTC+3", 3*3600)), at(10, 11, 0, 0), 1},
+		{"under an hour", time.Minute, at(10, 10, 30, 0), at(10, 10, 0, 0), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets := TrendingBuckets(tt.window, tt.now)
+			if len(buckets) != tt.count || !buckets[0].Equal(tt.first) {
+				t.Fatalf("%d buckets from %s, want %d from %s", len(buckets), buckets[0], tt.count, tt.first)
+			}
+			for i := 1; i < len(buckets); i++ {
+				if buckets[i].Sub(buckets[i-1]) != TrendingBucket {
+					t.Fatalf("bucket %d starts at %s after %s, want consecutive hours", i, buckets[i], buckets[i-1])
+				}
+			}
+			if last := buckets[len(buckets)-1]; !last.Equal(tt.now.UTC().Truncate(time.Hour)) {
+				t.Errorf("last bucket %s, want the current hour", last)
+			}
+		})
+	}
+}
+
+// newTestTrending returns a Trending on an in-process Redis server
+func newTestTrending(t *testing.T) (*Trending, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return &Trending{Client: client, Prefix: "adsvc:test:trending:", MaxAge: 7 * 24 * time.Hour}, server
+}
+
+func TestTrendingTop(t *testing.T) {
+	trending, server := newTestTrending(t)
+	ctx := tenant.With(context.Background(), tenant.Default)
+	// Views of the current hour, the previous one and seven hours ago
+	current := time.Now().UTC().Truncate(TrendingBucket)
+	for _, views := range []struct {
+		hoursAgo int
+		id       string
+		count    float64
+	}{{0, "1", 3}, {1, "2", 5}, {7, "3", 10}} {
+		server.ZAdd(trending.bucketKey(current.Add(-time.Duration(views.hoursAgo)*TrendingBucket), ctx), views.count, views.id)
+	}
+
+	tests := []struct {
+		window time.Duration
+		limit  int
+		want   string
+	}{
+		{time.Hour, 10, "[1]"},
+		{6 * time.Hour, 10, "[2 1]"},
+		{24 * time.Hour, 10, "[3 2 1]"},
+		{24 * time.Hour, 2, "[3 2]"},
+	}
+	for _, tt := range tests {
+		ids, err := trending.Top(tt.window, tt.limit, ctx)
+		if err != nil {
+			t.Fatalf("Top(%s, %d): %v", tt.window, tt.limit, err)
+		}
+		if got := fmt.Sprint(ids); got != tt.want {
+			t.Errorf("Top(%s, %d) = %s, want %s", tt.window, tt.limit, got, tt.want)
+		}
+	}
+
+	// New views land in the current bucket, which expires once the longest window has passed it
+	for i := 0; i < 4; i++ {
+		if err := trending.RecordView(4, ctx); err != nil {
+			t.Fatalf("RecordView: %v", err)
+		}
+	}
+	key := trending.bucketKey(time.Now().UTC().Truncate(TrendingBucket), ctx)
+	if ttl := server.TTL(key); ttl != 7*24*time.Hour+TrendingBucket {
+		t.Errorf("bucket TTL = %s, want 7d and an hour", ttl)
+	}
+	// The union is reused for a minute, then includes them
+	if ids, _ := trending.Top(time.Hour, 10, ctx); fmt.Sprint(ids) != "[1]" {
+		t.Errorf("Top right after the views = %v, want the stored union", ids)
+	}
+	server.FastForward(trendingUnionTTL)
+	if ids, _ := trending.Top(time.Hour, 10, ctx); fmt.Sprint(ids) != "[4 1]" {
+		t.Errorf("Top after the union expired = %v, want [4 1]", ids)
+	}
+
+	// Views are counted per tenant
+	other := tenant.With(context.Background(), "acme")
+	if ids, err := trending.Top(24*time.Hour, 10, other); err != nil || len(ids) != 0 {
+		t.Errorf("Top of another tenant = %v, %v; want nothing", ids, err)
+	}
+}
@@ -0,0 +1,385 @@
+// Package client is a Go client for the ad-service HTTP API. It propagates the caller's trace
+// context, retries idempotent calls with backoff and maps error responses to sentinel errors.
+package client
+
+import (
+	"ad_service/pkg/retry"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// apiPrefix is the API version the client speaks, whose responses use the envelope
+const apiPrefix = "/v1"
+
+// Client calls the ad-service API. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	retry      retry.Policy
+	apiKey     string
+	headers    http.Header
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithTimeout bounds each HTTP attempt, 10s by default
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) { c.httpClient.Timeout = timeout }
+}
+
+// WithHTTPClient replaces the underlying HTTP client, e.g. to add a custom transport
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithRetryPolicy replaces the backoff used for idempotent calls; MaxAttempts 1 disables retries
+func WithRetryPolicy(policy retry.Policy) Option {
+	return func(c *Client) { c.retry = policy }
+}
+
+// WithAPIKey sends key as a bearer token, for the API gateway in front of the service
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithHeader sets a header on every request, e.g. the caller identity a gateway would add
+func WithHeader(name, value string) Option {
+	return func(c *Client) {
+		if c.headers == nil {
+			c.headers = http.Header{}
+		}
+		c.headers.Set(name, value)
+	}
+}
+
+// DefaultRetryPolicy retries idempotent calls up to 3 times within 5s
+func DefaultRetryPolicy() retry.Policy {
+	return retry.Policy{
+		InitialInterval: 100 * time.Millisecond,
+		MaxInterval:     time.Second,
+		Multiplier:      2,
+		Jitter:          0.2,
+		MaxElapsedTime:  5 * time.Second,
+		MaxAttempts:     3,
+	}
+}
+
+// New creates a client for the API served at baseURL, e.g. http://ad-service:8080
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		retry:      DefaultRetryPolicy(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Ad is an ad as returned by the API
+type Ad struct {
+	ID          int64      `json:"id"` // 0 when the server hides numeric IDs
+	PublicID    string     `json:"public_id,omitempty"`
+	Slug        string     `json:"slug,omitempty"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Price       float64    `json:"price"`
+	CreatedAt   time.Time  `json:"created_at"`
+	IsActive    bool       `json:"is_active"`
+	ExternalRef *string    `json:"external_ref,omitempty"`
+	Category    string     `json:"category,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Weight      int        `json:"weight"`
+	// Moderation state; only approved ads are public
+	Status       string     `json:"status"`
+	StatusReason *string    `json:"status_reason,omitempty"`
+	OwnerID      *string    `json:"owner_id,omitempty"`
+	RenewedAt    time.Time  `json:"renewed_at"`
+	ArchivedAt   *time.Time `json:"archived_at,omitempty"`
+	// FavoritesCount is the number of users who saved the ad; IsFavorited is only set by GetAd
+	// for a request carrying the user ID
+	FavoritesCount int   `json:"favorites_count"`
+	IsFavorited    *bool `json:"is_favorited,omitempty"`
+	// CampaignID is set for ads that belong to a campaign
+	CampaignID *int     `json:"campaign_id,omitempty"`
+	Keywords   []string `json:"keywords,omitempty"`
+	// Variant names the creative variant merged into an ad returned by the serve endpoint
+	Variant *string `json:"variant,omitempty"`
+	// Translations are keyed by locale; Locale names the translation the response was localized to
+	Translations map[string]Translation `json:"translations,omitempty"`
+	Locale       string                 `json:"locale,omitempty"`
+	// DistanceKm is only set on the results of a radius search, Score on those of a full-text search
+	Latitude   *float64 `json:"latitude,omitempty"`
+	Longitude  *float64 `json:"longitude,omitempty"`
+	DistanceKm *float64 `json:"distance_km,omitempty"`
+	Score      *float64 `json:"score,omitempty"`
+	// Price is always in Currency; a request with ?currency= adds the converted DisplayPrice or
+	// sets ConversionUnavailable when no fresh rate was known
+	Currency              string   `json:"currency,omitempty"`
+	DisplayPrice          *float64 `json:"display_price,omitempty"`
+	DisplayCurrency       string   `json:"display_currency,omitempty"`
+	ConversionUnavailable bool     `json:"conversion_unavailable,omitempty"`
+}
+
+// Translation is the title and description of an ad in one locale
+type Translation struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// AdRequest is the body of CreateAd and UpdateAd
+type AdRequest struct {
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+	// IsActive nil keeps the stored state on UpdateAd and is false on CreateAd
+	IsActive  *bool      `json:"is_active,omitempty"`
+	Category  string     `json:"category,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Weight    int        `json:"weight,omitempty"`
+	Keywords  []string   `json:"keywords,omitempty"`
+	// Latitude and Longitude are set together or not at all
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	// Translations are keyed by locale, which must be configured in ads.locales
+	Translations map[string]Translation `json:"translations,omitempty"`
+	// RegenerateSlug rebuilds the slug from the new title; only UpdateAd honors it
+	RegenerateSlug bool `json:"regenerate_slug,omitempty"`
+}
+
+// ListOptions selects a page of ads; zero values use the API defaults
+type ListOptions struct {
+	Page   int
+	Limit  int
+	SortBy string // id, title, price, created_at, renewed_at, is_active, distance or relevance
+	Order  string // asc or desc
+	// Owner "me" lists the caller's own ads; Status and State need it or the admin role
+	Owner      string
+	Status     string // pending, approved or rejected
+	State      string // live or archived
+	CampaignID int
+	Currency   string
+	// TitleContains keeps the ads whose title contains it, 2 to 100 characters
+	TitleContains string
+	// Near makes it a radius search, sorted by distance
+	Near *Near
+	// Search looks words up in the title and description, sorted by relevance; SearchEngine picks
+	// like or fulltext instead of the server's default
+	Search       string
+	SearchEngine string
+}
+
+// Near is the center and radius of a radius search
+type Near struct {
+	Latitude  float64
+	Longitude float64
+	RadiusKm  float64
+}
+
+func (o ListOptions) query() url.Values {
+	q := url.Values{}
+	if o.Page > 0 {
+		q.Set("page", strconv.Itoa(o.Page))
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.SortBy != "" {
+		q.Set("sort_by", o.SortBy)
+	}
+	if o.Order != "" {
+		q.Set("order", o.Order)
+	}
+	if o.Owner != "" {
+		q.Set("owner", o.Owner)
+	}
+	if o.Status != "" {
+		q.Set("status", o.Status)
+	}
+	if o.State != "" {
+		q.Set("state", o.State)
+	}
+	if o.CampaignID > 0 {
+		q.Set("campaign_id", strconv.Itoa(o.CampaignID))
+	}
+	if o.Currency != "" {
+		q.Set("currency", o.Currency)
+	}
+	if o.TitleContains != "" {
+		q.Set("title_contains", o.TitleContains)
+	}
+	if o.Search != "" {
+		q.Set("q", o.Search)
+	}
+	if o.SearchEngine != "" {
+		q.Set("engine", o.SearchEngine)
+	}
+	if o.Near != nil {
+		q.Set("lat", strconv.FormatFloat(o.Near.Latitude, 'f', -1, 64))
+		q.Set("lng", strconv.FormatFloat(o.Near.Longitude, 'f', -1, 64))
+		q.Set("radius_km", strconv.FormatFloat(o.Near.RadiusKm, 'f', -1, 64))
+	}
+	return q
+}
+
+// GetAd fetches a single ad; ErrNotFound when it doesn't exist
+func (c *Client) GetAd(id int64, ctx context.Context) (*Ad, error) {
+	var ad Ad
+	if err := c.do(http.MethodGet, "/ads/"+strconv.FormatInt(id, 10), nil, &ad, ctx); err != nil {
+		return nil, err
+	}
+	return &ad, nil
+}
+
+// GetAdByPublicID fetches a single ad by its public UUID; ErrNotFound when it doesn't exist
+func (c *Client) GetAdByPublicID(publicID string, ctx context.Context) (*Ad, error) {
+	var ad Ad
+	if err := c.do(http.MethodGet, "/ads/uuid/"+url.PathEscape(publicID), nil, &ad, ctx); err != nil {
+		return nil, err
+	}
+	return &ad, nil
+}
+
+// GetAdBySlug fetches a single ad by its slug; ErrNotFound when it doesn't exist
+func (c *Client) GetAdBySlug(slug string, ctx context.Context) (*Ad, error) {
+	var ad Ad
+	if err := c.do(http.MethodGet, "/ads/slug/"+url.PathEscape(slug), nil, &ad, ctx); err != nil {
+		return nil, err
+	}
+	return &ad, nil
+}
+
+// ListAds fetches one page of ads
+func (c *Client) ListAds(opts ListOptions, ctx context.Context) ([]Ad, error) {
+	path := "/ads"
+	if q := opts.query().Encode(); q != "" {
+		path += "?" + q
+	}
+	var ads []Ad
+	if err := c.do(http.MethodGet, path, nil, &ads, ctx); err != nil {
+		return nil, err
+	}
+	return ads, nil
+}
+
+// CreateAd creates an ad and returns it with its ID. It is not retried, since a retry after a
+// lost response would create a duplicate.
+func (c *Client) CreateAd(req AdRequest, ctx context.Context) (*Ad, error) {
+	var ad Ad
+	if err := c.do(http.MethodPost, "/ads", req, &ad, ctx); err != nil {
+		return nil, err
+	}
+	return &ad, nil
+}
+
+// UpdateAd replaces an ad; ErrNotFound when it doesn't exist, ErrConflict while another
+// request modifies it
+func (c *Client) UpdateAd(id int64, req AdRequest, ctx context.Context) error {
+	return c.do(http.MethodPut, "/ads/"+strconv.FormatInt(id, 10), req, nil, ctx)
+}
+
+// DeleteAd deletes an ad; ErrNotFound when it doesn't exist
+func (c *Client) DeleteAd(id int64, ctx context.Context) error {
+	return c.do(http.MethodDelete, "/ads/"+strconv.FormatInt(id, 10), nil, nil, ctx)
+}
+
+// do sends the request in a client span, retrying idempotent methods on transport errors and
+// 5xx responses, and decodes a 2xx body into out when given
+func (c *Client) do(method, path string, body, out any, ctx context.Context) error {
+	tracer := otel.Tracer("ad-service.client")
+	ctx, span := tracer.Start(ctx, method+" "+strings.SplitN(path, "?", 2)[0], trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("could not encode request: %v", err)
+		}
+	}
+
+	policy := c.retry
+	if method == http.MethodPost {
+		policy.MaxAttempts = 1
+	}
+
+	attempts := 0
+	err := retry.Do(policy, func() error {
+		attempts++
+		err := c.attempt(method, path, payload, out, ctx)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode < 500 {
+			return retry.Permanent(err)
+		}
+		return err
+	}, ctx)
+
+	span.SetAttributes(attribute.Int("attempts", attempts))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "request failed")
+	}
+	return err
+}
+
+// attempt sends the request once
+func (c *Client) attempt(method, path string, payload []byte, out any, ctx context.Context) error {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+apiPrefix+path, body)
+	if err != nil {
+		return retry.Permanent(fmt.Errorf("could not build request: %v", err))
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	for name, values := range c.headers {
+		req.Header[name] = values
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	// Continue the caller's trace in the service
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach ad-service: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return newAPIError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	envelope := struct {
+		Data any `json:"data"`
+	}{Data: out}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return retry.Permanent(fmt.Errorf("could not decode response: %v", err))
+	}
+	return nil
+}
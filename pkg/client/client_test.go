@@ -0,0 +1,289 @@
+package client
+
+import (
+	"ad_service/internal/ad"
+	"ad_service/internal/config"
+	"ad_service/internal/server"
+	"ad_service/pkg/cache"
+	"ad_service/pkg/pagination"
+	"ad_service/pkg/retry"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// adColumns are the columns of an ad row as the repository selects them by ID, keywords first
+var adColumns = []string{
+	"keywords", "id", "public_id", "slug", "title", "description", "price", "created_at", "is_active", "external_ref",
+	"category", "expires_at", "weight", "status", "status_reason", "owner_id", "renewed_at", "renewal_count",
+	"renewal_window_start", "archived_at", "favorites_count", "campaign_id", "latitude", "longitude",
+}
+
+// testCreatedAt is the creation and renewal time of the ads the mock returns
+var testCreatedAt = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// adRow returns the values of an approved, active ad in the order of adColumns
+func adRow(id int64, title string) []driver.Value {
+	return []driver.Value{
+		nil, id, nil, nil, title, "Description of " + title, "10.00", testCreatedAt, true, nil,
+		"", nil, int64(1), "approved", nil, nil, testCreatedAt, int64(0), nil, nil, int64(0), nil, nil, nil,
+	}
+}
+
+// adRows returns the rows of a query by ID; listings select the same columns without keywords
+func adRows(keywords bool, rows ...[]driver.Value) *sqlmock.Rows {
+	columns := adColumns
+	if !keywords {
+		columns = adColumns[1:]
+	}
+	result := sqlmock.NewRows(columns)
+	for _, row := range rows {
+		if !keywords {
+			row = row[1:]
+		}
+		result.AddRow(row...)
+	}
+	return result
+}
+
+// expectAd answers the lookup of ad id with row, or with no rows when row is nil
+func expectAd(mock sqlmock.Sqlmock, id int64, row []driver.Value) {
+	if row == nil {
+		mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(id, "default").WillReturnRows(adRows(true))
+		return
+	}
+	mock.ExpectQuery("FROM ads WHERE id = ").WithArgs(id, "default").WillReturnRows(adRows(true, row))
+	expectNoTranslations(mock)
+}
+
+// expectNoTranslations answers the translations query of the found ads with no rows
+func expectNoTranslations(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("FROM ad_translations").WillReturnRows(sqlmock.NewRows([]string{"ad_id", "locale", "title", "description"}))
+}
+
+// newTestServer serves the API routes with the real handlers on a mocked database and an
+// in-memory cache, and returns its URL. The mock must have met all its expectations when the
+// test ends.
+func newTestServer(t *testing.T) (string, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := cache.NewMemoryCache(time.Minute)
+	t.Cleanup(func() {
+		memory.Close()
+		db.Close()
+	})
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	// Nothing is cached, so every call reaches the mock
+	service := &ad.AdService{
+		Repo:  &ad.Repository{DB: db},
+		Cache: cache.NewTenantCache(memory),
+		TTL:   config.NewReloadable(config.CacheConfig{WriteMode: ad.WriteModeInvalidate, LockTimeout: 5 * time.Second, LockWait: 500 * time.Millisecond, MutationLockTTL: 5 * time.Second, MutationLockWait: time.Second}),
+		Rules: config.NewReloadable(config.AdsConfig{ExposeNumericIDs: true, SearchEngine: ad.SearchLike}),
+	}
+	handlers := server.Handlers{Ads: &ad.Handler{Service: service, Paging: pagination.Policy{MaxLimit: 100, Mode: pagination.ModeClamp}}}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	server.RegisterRoutes(r, handlers, config.AuthConfig{UserIDHeader: "X-User-Id", RoleHeader: "X-User-Role", AdminRole: "admin"}, config.TenancyConfig{}, false)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv.URL, mock
+}
+
+// noRetries disables retries so every call is one request
+var noRetries = WithRetryPolicy(retry.Policy{MaxAttempts: 1})
+
+// fastRetries retries up to 3 times without waiting long
+var fastRetries = WithRetryPolicy(retry.Policy{InitialInterval: time.Millisecond, Multiplier: 1, MaxAttempts: 3})
+
+// recordingTransport counts the requests it sends and keeps the headers of the last one
+type recordingTransport struct {
+	requests int
+	header   http.Header
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	t.header = req.Header.Clone()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestClientCalls(t *testing.T) {
+	url, mock := newTestServer(t)
+	c := New(url, noRetries)
+	ctx := context.Background()
+
+	expectAd(mock, 7, adRow(7, "Bike"))
+	got, err := c.GetAd(7, ctx)
+	if err != nil {
+		t.Fatalf("GetAd: %v", err)
+	}
+	if got.ID != 7 || got.Title != "Bike" || got.Price != 10 || got.Status != "approved" || !got.CreatedAt.Equal(testCreatedAt) {
+		t.Errorf("GetAd = %+v, want ad 7 decoded from the envelope", got)
+	}
+
+	mock.ExpectQuery("FROM ads").WillReturnRows(adRows(false, adRow(1, "Bike"), adRow(2, "Car")))
+	expectNoTranslations(mock)
+	ads, err := c.ListAds(ListOptions{Page: 2, Limit: 2, SortBy: "price", Order: "asc"}, ctx)
+	if err != nil {
+		t.Fatalf("ListAds: %v", err)
+	}
+	if len(ads) != 2 || ads[0].Title != "Bike" || ads[1].ID != 2 {
+		t.Errorf("ListAds = %+v, want ads 1 and 2", ads)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO ads").WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectExec("UPDATE ads SET slug").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT created_at FROM ads").WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(testCreatedAt))
+	mock.ExpectCommit()
+	created, err := c.CreateAd(AdRequest{Title: "Mountain bike", Description: "Barely used", Price: 250.5, Category: "sports"}, ctx)
+	if err != nil {
+		t.Fatalf("CreateAd: %v", err)
+	}
+	if created.ID != 9 || created.Title != "Mountain bike" || created.Price != 250.5 || created.Status != "pending" {
+		t.Errorf("CreateAd = %+v, want the pending ad 9", created)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE ads SET title = ").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := c.UpdateAd(9, AdRequest{Title: "Road bike", Description: "Barely used", Price: 199.99}, ctx); err != nil {
+		t.Fatalf("UpdateAd: %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM ads").WithArgs(int64(9), "default").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := c.DeleteAd(9, ctx); err != nil {
+		t.Fatalf("DeleteAd: %v", err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	url, mock := newTestServer(t)
+	c := New(url, noRetries)
+	ctx := context.Background()
+
+	expectAd(mock, 404, nil)
+	_, err := c.GetAd(404, ctx)
+	var apiErr *APIError
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &apiErr) || apiErr.Code != "not_found" {
+		t.Errorf("GetAd of a missing ad = %v, want ErrNotFound with code not_found", err)
+	}
+
+	// Rejected by the handler before any query
+	_, err = c.CreateAd(AdRequest{Description: "No title", Price: 10}, ctx)
+	if !errors.Is(err, ErrValidation) || errors.Is(err, ErrNotFound) {
+		t.Fatalf("CreateAd without a title = %v, want ErrValidation", err)
+	}
+	if !errors.As(err, &apiErr) || apiErr.Code != "validation_failed" || len(apiErr.Fields) == 0 || apiErr.Fields[0].Field != "title" {
+		t.Errorf("CreateAd without a title = %+v, want the title field error", apiErr)
+	}
+	if err := c.UpdateAd(0, AdRequest{Title: "Bike", Description: "Bike", Price: 1}, ctx); !errors.Is(err, ErrValidation) {
+		t.Errorf("UpdateAd of ID 0 = %v, want ErrValidation", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM ads").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if err := c.DeleteAd(404, ctx); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteAd of a missing ad = %v, want ErrNotFound", err)
+	}
+
+	// Responses that aren't the envelope keep the status text
+	if _, err := New(url+"/missing", noRetries).GetAd(1, ctx); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Message != "Not Found" {
+		t.Errorf("GetAd on an unknown route = %v, want a bare 404", err)
+	}
+}
+
+func TestClientRetriesIdempotentCalls(t *testing.T) {
+	url, mock := newTestServer(t)
+	transport := &recordingTransport{}
+	c := New(url, fastRetries, WithHTTPClient(&http.Client{Transport: transport}))
+	ctx := context.Background()
+
+	// A database error is a 503, which is retried until the ad is read
+	mock.ExpectQuery("FROM ads WHERE id = ").WillReturnError(errors.New("connection reset"))
+	mock.ExpectQuery("FROM ads WHERE id = ").WillReturnError(errors.New("connection reset"))
+	expectAd(mock, 7, adRow(7, "Bike"))
+	if got, err := c.GetAd(7, ctx); err != nil || got.ID != 7 {
+		t.Fatalf("GetAd = %+v, %v; want ad 7 on the third attempt", got, err)
+	}
+	if transport.requests != 3 {
+		t.Errorf("GetAd sent %d requests, want 3", transport.requests)
+	}
+
+	// Other errors are returned right away
+	transport.requests = 0
+	expectAd(mock, 404, nil)
+	if _, err := c.GetAd(404, ctx); !errors.Is(err, ErrNotFound) || transport.requests != 1 {
+		t.Errorf("GetAd of a missing ad = %v after %d requests, want ErrNotFound after 1", err, transport.requests)
+	}
+
+	// A retried POST could create the ad twice
+	transport.requests = 0
+	mock.ExpectBegin().WillReturnError(errors.New("connection reset"))
+	_, err := c.CreateAd(AdRequest{Title: "Bike", Description: "Bike", Price: 1}, ctx)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable || transport.requests != 1 {
+		t.Errorf("CreateAd = %v after %d requests, want the 503 after 1", err, transport.requests)
+	}
+
+	// Transport errors are retried too
+	transport.requests = 0
+	unreachable := New("http://127.0.0.1:1", fastRetries, WithHTTPClient(&http.Client{Transport: transport}))
+	if err := unreachable.DeleteAd(7, ctx); err == nil || !strings.Contains(err.Error(), "could not reach ad-service") || transport.requests != 3 {
+		t.Errorf("DeleteAd on an unreachable server = %v after %d requests, want 3 attempts", err, transport.requests)
+	}
+}
+
+func TestClientPropagatesTraceContext(t *testing.T) {
+	previous := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(previous) })
+
+	url, mock := newTestServer(t)
+	transport := &recordingTransport{}
+	c := New(url, noRetries, WithHTTPClient(&http.Client{Transport: transport}), WithAPIKey("secret"), WithHeader("X-User-Id", "owner"))
+
+	provider := sdktrace.NewTracerProvider()
+	ctx, span := provider.Tracer("test").Start(context.Background(), "caller")
+	defer span.End()
+	expectAd(mock, 7, adRow(7, "Bike"))
+	mock.ExpectQuery("FROM favorites").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	if _, err := c.GetAd(7, ctx); err != nil {
+		t.Fatalf("GetAd: %v", err)
+	}
+
+	// The request continues the caller's trace
+	traceparent := transport.header.Get("traceparent")
+	if !strings.Contains(traceparent, "-"+span.SpanContext().TraceID().String()+"-") {
+		t.Errorf("traceparent = %q, want the trace %s", traceparent, span.SpanContext().TraceID())
+	}
+	if got := transport.header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("Authorization = %q, want the API key", got)
+	}
+	if got := transport.header.Get("X-User-Id"); got != "owner" {
+		t.Errorf("X-User-Id = %q, want the configured header", got)
+	}
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Sentinel errors matched by errors.Is against an *APIError
+var (
+	ErrNotFound   = errors.New("ad not found")
+	ErrValidation = errors.New("invalid request")
+	ErrConflict   = errors.New("ad is being modified by another request")
+)
+
+// FieldError names a request field and the validation rule it broke
+type FieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+}
+
+// APIError is a non-2xx response from the API
+type APIError struct {
+	StatusCode int
+	Code       string // e.g. not_found or validation_failed
+	Message    string
+	Fields     []FieldError
+	TraceID    string // set on 500s for sampled requests
+	// Details are the error details as sent, including Fields and TraceID
+	Details map[string]json.RawMessage
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("ad-service returned %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("ad-service returned %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Is maps the status code to the sentinel errors
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrValidation:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
+// newAPIError reads the error envelope of resp; bodies that aren't one keep the status text
+func newAPIError(resp *http.Response) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	var envelope struct {
+		Error struct {
+			Code    string                     `json:"code"`
+			Message string                     `json:"message"`
+			Details map[string]json.RawMessage `json:"details"`
+		} `json:"error"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(body, &envelope) != nil || envelope.Error.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+		return apiErr
+	}
+	apiErr.Code = envelope.Error.Code
+	apiErr.Message = envelope.Error.Message
+	apiErr.Details = envelope.Error.Details
+	// Details that don't decode are still in Details
+	_ = json.Unmarshal(apiErr.Details["fields"], &apiErr.Fields)
+	_ = json.Unmarshal(apiErr.Details["trace_id"], &apiErr.TraceID)
+	return apiErr
+}
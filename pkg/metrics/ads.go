@@ -0,0 +1,221 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Gauge for the number of ads in the database, labeled by tenant and is_active
+	AdsTotal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ads_total",
+			Help: "Number of ads in the database by tenant",
+		},
+		[]string{"tenant", "is_active"},
+	)
+
+	// Counter for failed refreshes of ads_total; the gauge keeps its last good value meanwhile
+	AdsTotalRefreshErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ads_total_refresh_errors_total",
+			Help: "Total number of failed ads_total refreshes",
+		},
+	)
+
+	// Counters for successful ad writes, incremented by the service layer for every entry point
+	AdsCreated = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ads_created_total",
+			Help: "Total number of ads created",
+		},
+	)
+	AdsUpdated = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ads_updated_total",
+			Help: "Total number of ads updated",
+		},
+	)
+	AdsDeleted = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ads_deleted_total",
+			Help: "Total number of ads deleted",
+		},
+	)
+
+	// Counter for moderation decisions, labeled by the status the ad moved to
+	AdsModerated = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ads_moderated_total",
+			Help: "Total number of moderation status changes by resulting status",
+		},
+		[]string{"status"},
+	)
+
+	// Counter for successful renewals
+	AdsRenewed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ads_renewed_total",
+			Help: "Total number of ads renewed",
+		},
+	)
+
+	// Counter for ads deactivated by the expired ads job
+	AdsExpired = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ads_expired_total",
+			Help: "Total number of expired ads deactivated",
+		},
+	)
+
+	// Counter for runs of the expired ads job, labeled by result (success, error, skipped when
+	// another replica held the lock)
+	AdExpiryRuns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ad_expiry_runs_total",
+			Help: "Total number of expired ads job runs by result",
+		},
+		[]string{"result"},
+	)
+
+	// Counter for new abuse reports, labeled by reason code
+	AdReports = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ad_reports_total",
+			Help: "Total number of abuse reports filed by reason",
+		},
+		[]string{"reason"},
+	)
+
+	// Counter for keyword-targeted serve requests, labeled by outcome (matched, fallback)
+	AdServeKeywordMatches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ad_serve_keyword_matches_total",
+			Help: "Total number of serve requests with keywords by whether a targeted ad matched",
+		},
+		[]string{"outcome"},
+	)
+
+	// Counter for the impressions of served ads, labeled by result (recorded, error, dropped when
+	// the queue was full)
+	AdImpressions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ad_impressions_total",
+			Help: "Total number of impressions of served ads by whether they were recorded, failed or dropped",
+		},
+		[]string{"result"},
+	)
+
+	// Counter for trending requests, labeled by the source of the ranking (views, recent)
+	AdTrendingRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ad_trending_requests_total",
+			Help: "Total number of trending requests by whether they were ranked by views or fell back to recent ads",
+		},
+		[]string{"source"},
+	)
+
+	// Counter for ad creations refused by the owner quota, labeled by limit (active, daily)
+	AdQuotaRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ads_quota_rejections_total",
+			Help: "Total number of ad creations rejected by the owner quota by limit",
+		},
+		[]string{"limit"},
+	)
+
+	// Counter for GET /ads/search requests, labeled by the engine that answered (mysql,
+	// elasticsearch) and whether it was a fallback from Elasticsearch
+	AdSearchRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ad_search_requests_total",
+			Help: "Total number of ad search requests by answering engine and whether they fell back to MySQL",
+		},
+		[]string{"engine", "degraded"},
+	)
+
+	// Counter for search indexer runs, labeled by result (success, error, skipped)
+	AdSearchIndexRuns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ad_search_index_runs_total",
+			Help: "Total number of search indexer runs by result",
+		},
+		[]string{"result"},
+	)
+
+	// Counter for search documents written by the indexer and reindex, labeled by operation (index, delete)
+	AdSearchDocuments = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ad_search_documents_total",
+			Help: "Total number of search documents indexed or deleted by operation",
+		},
+		[]string{"operation"},
+	)
+
+	// Counter for failed ad creations, labeled by reason (validation, quota, db_error)
+	AdCreateFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ads_create_failures_total",
+			Help: "Total number of failed ad creations by reason",
+		},
+		[]string{"reason"},
+	)
+
+	// Counter for rejected requests, labeled by route and the field that failed validation
+	ValidationFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "validation_failures_total",
+			Help: "Total number of request validation failures by endpoint and field",
+		},
+		[]string{"endpoint", "field"},
+	)
+)
+
+// AdCounter is implemented by the ad repository
+type AdCounter interface {
+	CountAdsByTenant(ctx context.Context) (map[string]map[bool]int, error)
+}
+
+// AdsTotalRefreshTimeout bounds one refresh of AdsTotal
+const AdsTotalRefreshTimeout = 10 * time.Second
+
+// adsTotalTenants are the tenants AdsTotal was last set for, so the series of a tenant whose
+// ads are all gone can be dropped
+var (
+	adsTotalMu      sync.Mutex
+	adsTotalTenants = map[string]bool{}
+)
+
+// RefreshAdsTotal recomputes AdsTotal with one count query, keeping the previous values on
+// error. It is run periodically as a background job, starting right away so the gauge is
+// populated before the first scrape.
+func RefreshAdsTotal(counter AdCounter, ctx context.Context) error {
+	counts, err := counter.CountAdsByTenant(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			AdsTotalRefreshErrors.Inc()
+		}
+		return fmt.Errorf("could not refresh ads_total: %w", err)
+	}
+
+	adsTotalMu.Lock()
+	defer adsTotalMu.Unlock()
+	for tenantID := range adsTotalTenants {
+		if _, ok := counts[tenantID]; !ok {
+			AdsTotal.DeletePartialMatch(prometheus.Labels{"tenant": tenantID})
+			delete(adsTotalTenants, tenantID)
+		}
+	}
+	for tenantID, byActive := range counts {
+		for isActive, count := range byActive {
+			AdsTotal.WithLabelValues(tenantID, strconv.FormatBool(isActive)).Set(float64(count))
+		}
+		adsTotalTenants[tenantID] = true
+	}
+	return nil
+}
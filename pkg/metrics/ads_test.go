@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// adCounts is an AdCounter returning fixed counts or an error
+type adCounts struct {
+	counts map[string]map[bool]int
+	err    error
+}
+
+func (c adCounts) CountAdsByTenant(ctx context.Context) (map[string]map[bool]int, error) {
+	return c.counts, c.err
+}
+
+func TestRefreshAdsTotal(t *testing.T) {
+	AdsTotal.Reset()
+	t.Cleanup(AdsTotal.Reset)
+	ctx := context.Background()
+
+	counts := map[string]map[bool]int{"acme": {true: 3, false: 1}, "beta": {true: 0, false: 2}}
+	if err := RefreshAdsTotal(adCounts{counts: counts}, ctx); err != nil {
+		t.Fatal(err)
+	}
+	for tenantID, byActive := range counts {
+		for isActive, want := range byActive {
+			label := strconv.FormatBool(isActive)
+			if got := testutil.ToFloat64(AdsTotal.WithLabelValues(tenantID, label)); got != float64(want) {
+				t.Errorf("ads_total{tenant=%q,is_active=%q} = %v, want %d", tenantID, label, got, want)
+			}
+		}
+	}
+
+	// A failed refresh keeps the last values
+	failures := testutil.ToFloat64(AdsTotalRefreshErrors)
+	if err := RefreshAdsTotal(adCounts{err: errors.New("connection refused")}, ctx); err == nil {
+		t.Error("RefreshAdsTotal succeeded with a failing counter")
+	}
+	if got := testutil.ToFloat64(AdsTotalRefreshErrors) - failures; got != 1 {
+		t.Errorf("ads_total_refresh_errors_total grew by %v, want 1", got)
+	}
+	if got := testutil.CollectAndCount(AdsTotal); got != 4 {
+		t.Errorf("ads_total has %d series after a failed refresh, want 4", got)
+	}
+
+	// The series of a tenant without ads are dropped
+	if err := RefreshAdsTotal(adCounts{counts: map[string]map[bool]int{"acme": {true: 3, false: 0}}}, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.CollectAndCount(AdsTotal); got != 2 {
+		t.Errorf("ads_total has %d series, want the 2 of acme", got)
+	}
+	if got := testutil.ToFloat64(AdsTotal.WithLabelValues("acme", "false")); got != 0 {
+		t.Errorf("ads_total{tenant=\"acme\",is_active=\"false\"} = %v, want 0", got)
+	}
+}
@@ -0,0 +1,20 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ObserveWithTrace records value on o and, when ctx carries a sampled span, attaches the trace ID
+// as a `trace_id` exemplar so a latency outlier in Grafana links to its trace. Unsampled requests
+// get a plain observation since their trace wouldn't exist in the backend.
+func ObserveWithTrace(o prometheus.Observer, value float64, ctx context.Context) {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if exemplarObserver, ok := o.(prometheus.ExemplarObserver); ok && spanContext.IsSampled() {
+		exemplarObserver.ObserveWithExemplar(value, prometheus.Labels{"trace_id": spanContext.TraceID().String()})
+		return
+	}
+	o.Observe(value)
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"ad_service/internal/config"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spanContext returns a context carrying a span of traceID, sampled or not
+func spanContext(t *testing.T, traceID string, sampled bool) context.Context {
+	t.Helper()
+	id, err := trace.TraceIDFromHex(traceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var flags trace.TraceFlags
+	if sampled {
+		flags = trace.FlagsSampled
+	}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: id, SpanID: trace.SpanID{1}, TraceFlags: flags})
+	return trace.ContextWithSpanContext(context.Background(), sc)
+}
+
+func TestObserveWithTrace(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		exemplar bool
+	}{
+		{"sampled", spanContext(t, traceID, true), true},
+		{"unsampled", spanContext(t, traceID, false), false},
+		{"no span", context.Background(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_seconds", Buckets: []float64{0.1, 1}})
+			ObserveWithTrace(h, 0.05, tt.ctx)
+
+			var m dto.Metric
+			if err := h.Write(&m); err != nil {
+				t.Fatal(err)
+			}
+			if m.GetHistogram().GetSampleCount() != 1 {
+				t.Fatalf("%d observations, want 1", m.GetHistogram().GetSampleCount())
+			}
+			exemplar := m.GetHistogram().GetBucket()[0].GetExemplar()
+			if (exemplar != nil) != tt.exemplar {
+				t.Fatalf("exemplar = %v, want one: %v", exemplar, tt.exemplar)
+			}
+			if tt.exemplar {
+				if labels := exemplar.GetLabel(); len(labels) != 1 || labels[0].GetName() != "trace_id" || labels[0].GetValue() != traceID {
+					t.Errorf("exemplar labels = %v, want trace_id=%s", labels, traceID)
+				}
+			}
+		})
+	}
+}
+
+func TestScrapeShowsRequestExemplar(t *testing.T) {
+	const sampledID, unsampledID = "4bf92f3577b34da6a3ce929d0e0e4736", "0af7651916cd43dd8448eb211c80319c"
+	r, _ := newTestRouter(t, config.MetricsConfig{ExcludePaths: []string{"/metrics"}})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ads/1", nil).WithContext(spanContext(t, sampledID, true)))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ads/missing", nil).WithContext(spanContext(t, unsampledID, false)))
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/openmetrics-text") {
+		t.Fatalf("scrape Content-Type = %q, want OpenMetrics", w.Header().Get("Content-Type"))
+	}
+	body, _ := io.ReadAll(w.Body)
+	scrape := string(body)
+
+	var exemplars []string
+	for _, line := range strings.Split(scrape, "\n") {
+		if strings.HasPrefix(line, "http_request_duration_seconds_bucket") && strings.Contains(line, " # {") {
+			exemplars = append(exemplars, line)
+		}
+	}
+	if len(exemplars) != 1 || !strings.Contains(exemplars[0], `status_code="200"`) || !strings.Contains(exemplars[0], `# {trace_id="`+sampledID+`"}`) {
+		t.Errorf("duration exemplars = %q, want one of the sampled request", exemplars)
+	}
+	if strings.Contains(scrape, unsampledID) {
+		t.Error("the unsampled request's trace ID was attached as an exemplar")
+	}
+}
@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"ad_service/pkg/scheduler"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Job run results
+const (
+	JobSuccess  = "success"
+	JobError    = "error"
+	JobTimeout  = "timeout"
+	JobCanceled = "canceled" // stopped by shutdown
+	JobSkipped  = "skipped"  // scheduler.ErrSkipped, e.g. another replica held the lock
+)
+
+var (
+	// Counter for background job runs, labeled by job and result (success, error, timeout, canceled, skipped)
+	JobRuns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "job_runs_total",
+			Help: "Total number of background job runs by job and result",
+		},
+		[]string{"job", "result"},
+	)
+
+	// Histogram for the duration of the background job runs that did work, labeled by job
+	JobDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "job_duration_seconds",
+			Help: "Duration of background job runs in seconds, skipped runs excluded",
+			// 10ms to about 11 minutes, jobs range from a cache refresh to draining a backlog
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 9),
+		},
+		[]string{"job"},
+	)
+
+	// Gauge for the Unix time of the last successful run of each job; alerts compare it to now
+	JobLastSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "job_last_success_timestamp",
+			Help: "Unix time in seconds of the last successful run of a background job",
+		},
+		[]string{"job"},
+	)
+
+	// Gauge for the work a job has left after its last run, for the jobs that can count it
+	JobBacklog = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "job_backlog",
+			Help: "Items a background job had left to process after its last run",
+		},
+		[]string{"job"},
+	)
+)
+
+// jobBacklogTimeout bounds counting a job's backlog after a run
+const jobBacklogTimeout = 5 * time.Second
+
+// InstrumentJob is the scheduler.Wrapper recording the job metrics of every run. Only a run that
+// returns nil counts as a success and moves job_last_success_timestamp; a panic is an error. The
+// backlog is counted after every run that did work, so it keeps growing while a job fails.
+func InstrumentJob(job scheduler.Job) scheduler.Job {
+	run := job.Run
+	name := job.Name
+	job.Run = func(ctx context.Context) (err error) {
+		start := time.Now()
+		defer func() {
+			panicked := recover()
+			if panicked != nil {
+				err = errors.New("panic")
+			}
+			result := jobResult(err, ctx)
+			JobRuns.WithLabelValues(name, result).Inc()
+			if result != JobSkipped {
+				JobDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+			}
+			if result == JobSuccess {
+				JobLastSuccess.WithLabelValues(name).Set(float64(time.Now().Unix()))
+			}
+			if job.Backlog != nil && result != JobSkipped && result != JobCanceled {
+				recordBacklog(name, job.Backlog, ctx)
+			}
+			// The scheduler turns the panic into an error with its stack
+			if panicked != nil {
+				panic(panicked)
+			}
+		}()
+		return run(ctx)
+	}
+	return job
+}
+
+// jobResult classifies the outcome of a run
+func jobResult(err error, ctx context.Context) string {
+	switch {
+	case err == nil:
+		return JobSuccess
+	case errors.Is(err, scheduler.ErrSkipped):
+		return JobSkipped
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return JobTimeout
+	case errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled):
+		return JobCanceled
+	default:
+		return JobError
+	}
+}
+
+// recordBacklog sets the job's backlog gauge, keeping the previous value when it can't be counted.
+// The count gets its own timeout, since the run context may be the one that timed out.
+func recordBacklog(name string, backlog func(ctx context.Context) (int, error), ctx context.Context) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jobBacklogTimeout)
+	defer cancel()
+	if n, err := backlog(ctx); err == nil {
+		JobBacklog.WithLabelValues(name).Set(float64(n))
+	}
+}
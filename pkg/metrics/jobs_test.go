@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"ad_service/pkg/scheduler"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// jobSeries is every series of a job at one point
+type jobSeries struct {
+	runs        map[string]float64 // by result
+	durations   uint64
+	lastSuccess float64
+	backlog     float64
+}
+
+// readJobSeries reads the series of the job
+func readJobSeries(t *testing.T, name string) jobSeries {
+	t.Helper()
+	s := jobSeries{runs: map[string]float64{}}
+	for _, result := range []string{JobSuccess, JobError, JobTimeout, JobCanceled, JobSkipped} {
+		s.runs[result] = testutil.ToFloat64(JobRuns.WithLabelValues(name, result))
+	}
+	var m dto.Metric
+	if err := JobDuration.WithLabelValues(name).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	s.durations = m.GetHistogram().GetSampleCount()
+	s.lastSuccess = testutil.ToFloat64(JobLastSuccess.WithLabelValues(name))
+	s.backlog = testutil.ToFloat64(JobBacklog.WithLabelValues(name))
+	return s
+}
+
+func TestInstrumentJob(t *testing.T) {
+	name := "fake-" + t.Name()
+	var result error
+	backlog := 0
+	job := InstrumentJob(scheduler.Job{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			time.Sleep(5 * time.Millisecond)
+			return result
+		},
+		Backlog: func(ctx context.Context) (int, error) {
+			if backlog < 0 {
+				return 0, errors.New("count failed")
+			}
+			return backlog, nil
+		},
+	})
+
+	// A success counts, is timed, moves the last success and records the backlog
+	backlog = 12
+	before := time.Now().Unix()
+	if err := job.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s := readJobSeries(t, name)
+	if s.runs[JobSuccess] != 1 || s.durations != 1 || s.backlog != 12 {
+		t.Errorf("after a success: %+v, want one timed success and a backlog of 12", s)
+	}
+	if s.lastSuccess < float64(before) || s.lastSuccess > float64(time.Now().Unix()) {
+		t.Errorf("job_last_success_timestamp = %v, want the time of the run", s.lastSuccess)
+	}
+	success := s.lastSuccess
+
+	// A failure keeps the last success, and the backlog still tracks the work left
+	result, backlog = fmt.Errorf("could not expire ads: %w", errors.New("connection refused")), 15
+	time.Sleep(time.Second) // the timestamp is in whole seconds
+	if err := job.Run(context.Background()); err == nil {
+		t.Fatal("the wrapper swallowed the error")
+	}
+	s = readJobSeries(t, name)
+	if s.runs[JobError] != 1 || s.durations != 2 || s.lastSuccess != success || s.backlog != 15 {
+		t.Errorf("after a failure: %+v, want a timed error, the last success kept and a backlog of 15", s)
+	}
+
+	// A backlog that can't be counted keeps its last value
+	result, backlog = nil, -1
+	job.Run(context.Background())
+	if s = readJobSeries(t, name); s.backlog != 15 || s.lastSuccess <= success {
+		t.Errorf("after a success with a failed count: %+v, want the backlog kept", s)
+	}
+
+	// A skipped run isn't timed, nor does it count the backlog or move the last success
+	result, backlog = fmt.Errorf("lock held: %w", scheduler.ErrSkipped), 99
+	last := s.lastSuccess
+	job.Run(context.Background())
+	if s = readJobSeries(t, name); s.runs[JobSkipped] != 1 || s.durations != 3 || s.backlog != 15 || s.lastSuccess != last {
+		t.Errorf("after a skipped run: %+v, want only the skip counted", s)
+	}
+}
+
+func TestInstrumentJobResults(t *testing.T) {
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	canceled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		run    func(ctx context.Context) error
+		result string
+	}{
+		{"timeout", expired, func(ctx context.Context) error { return ctx.Err() }, JobTimeout},
+		// Whatever the job returns, its context decides between a timeout and a shutdown
+		{"timeout wrapped", expired, func(ctx context.Context) error { return errors.New("query interrupted") }, JobTimeout},
+		{"shutdown", canceled, func(ctx context.Context) error { return fmt.Errorf("stopped: %w", ctx.Err()) }, JobCanceled},
+		{"panic", context.Background(), func(ctx context.Context) error { panic("nil map") }, JobError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := "fake-" + t.Name()
+			job := InstrumentJob(scheduler.Job{Name: name, Run: tt.run})
+			func() {
+				// The panic goes on to the scheduler
+				defer func() { recover() }()
+				job.Run(tt.ctx)
+			}()
+			s := readJobSeries(t, name)
+			if s.runs[tt.result] != 1 || s.lastSuccess != 0 {
+				t.Errorf("series = %+v, want one %s run and no success", s, tt.result)
+			}
+		})
+	}
+}
+
+// The scheduler applies the wrapper to every job it runs
+func TestSchedulerRecordsJobMetrics(t *testing.T) {
+	name := "fake-" + t.Name()
+	done := make(chan struct{})
+	s := scheduler.New()
+	s.Use(InstrumentJob)
+	if err := s.Add(scheduler.Job{Name: name, Interval: time.Hour, Immediate: true, Run: func(ctx context.Context) error {
+		defer close(done)
+		return nil
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	<-done
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if series := readJobSeries(t, name); series.runs[JobSuccess] != 1 || series.lastSuccess == 0 {
+		t.Errorf("series = %+v, want the run recorded", series)
+	}
+}
@@ -0,0 +1,213 @@
+/*
+This file pushes the metrics over OTLP to an OpenTelemetry collector, alongside the Prometheus
+endpoint. Nothing is recorded twice: at every export a producer gathers the Prometheus registry
+and converts its families to OTel data, so both pipelines carry the same series under the same
+names and labels while dashboards move over. Export failures are handled by the OTel SDK on its
+own goroutine and only logged; requests never wait for the collector.
+*/
+package metrics
+
+import (
+	"ad_service/internal/config"
+	"ad_service/pkg/tracing"
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// otlpScope is the instrumentation scope of the metrics converted from the registry
+const otlpScope = "ad-service.metrics"
+
+// InitOTLP starts pushing the registry to the collector in cfg every cfg.Interval and returns the
+// function that pushes the last values and stops, for graceful shutdown. It is a no-op when the
+// exporter is disabled. The collector is connected to lazily, so an unreachable one is not an
+// error here.
+func (m *Metrics) InitOTLP(cfg config.MetricsOTLPConfig, res *resource.Resource) (func(context.Context) error, error) {
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exp, err := newMetricExporter(cfg, context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("could not create %s metric exporter: %v", cfg.Protocol, err)
+	}
+	reader := sdkmetric.NewPeriodicReader(exp,
+		sdkmetric.WithInterval(cfg.Interval),
+		sdkmetric.WithTimeout(cfg.Timeout),
+		sdkmetric.WithProducer(NewRegistryProducer(m.Registry)),
+	)
+	// The provider is not made global: instruments created through the OTel API would only reach
+	// the collector, and both pipelines must carry the same metrics
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithResource(res), sdkmetric.WithReader(reader))
+
+	return func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+		// Shutdown collects and exports once more, so the last values aren't lost
+		return provider.Shutdown(ctx)
+	}, nil
+}
+
+// newMetricExporter creates the OTLP exporter for the configured transport
+func newMetricExporter(cfg config.MetricsOTLPConfig, ctx context.Context) (sdkmetric.Exporter, error) {
+	tlsConfig, err := tracing.NewTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Protocol {
+	case "http", "":
+		opts := []otlpmetrichttp.Option{
+			otlpmetrichttp.WithEndpoint(cfg.Endpoint),
+			otlpmetrichttp.WithHeaders(tracing.ExporterHeaders(cfg.Headers)),
+			otlpmetrichttp.WithTimeout(cfg.Timeout),
+		}
+		if tlsConfig != nil {
+			opts = append(opts, otlpmetrichttp.WithTLSClientConfig(tlsConfig))
+		} else {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	}
+	return nil, fmt.Errorf("unknown exporter protocol %q", cfg.Protocol)
+}
+
+// RegistryProducer is an OTel metric producer reading a Prometheus registry. Counters become
+// cumulative monotonic sums, gauges and untyped metrics gauges, histograms explicit bucket
+// histograms and summaries summaries; names, help and labels are kept as they are.
+type RegistryProducer struct {
+	gatherer prometheus.Gatherer
+	start    time.Time // start time of the cumulative series, the registry doesn't record one
+}
+
+// NewRegistryProducer returns the producer of the metrics in gatherer
+func NewRegistryProducer(gatherer prometheus.Gatherer) *RegistryProducer {
+	return &RegistryProducer{gatherer: gatherer, start: time.Now()}
+}
+
+// Produce gathers the registry and converts it. Like a scrape, families that could be gathered
+// are returned together with the error of those that couldn't.
+func (p *RegistryProducer) Produce(ctx context.Context) ([]metricdata.ScopeMetrics, error) {
+	families, err := p.gatherer.Gather()
+	now := time.Now()
+	metrics := make([]metricdata.Metrics, 0, len(families))
+	for _, family := range families {
+		if data, ok := p.convert(family, now); ok {
+			metrics = append(metrics, metricdata.Metrics{
+				Name:        family.GetName(),
+				Description: family.GetHelp(),
+				Data:        data,
+			})
+		}
+	}
+	if len(metrics) == 0 {
+		return nil, err
+	}
+	return []metricdata.ScopeMetrics{{Scope: instrumentation.Scope{Name: otlpScope}, Metrics: metrics}}, err
+}
+
+// convert turns one family into its OTel aggregation, false for types with no equivalent
+func (p *RegistryProducer) convert(family *dto.MetricFamily, now time.Time) (metricdata.Aggregation, bool) {
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		sum := metricdata.Sum[float64]{Temporality: metricdata.CumulativeTemporality, IsMonotonic: true}
+		for _, metric := range family.GetMetric() {
+			sum.DataPoints = append(sum.DataPoints, metricdata.DataPoint[float64]{
+				Attributes: labelSet(metric.GetLabel()),
+				StartTime:  p.startTime(metric.GetCounter().GetCreatedTimestamp().AsTime()),
+				Time:       now,
+				Value:      metric.GetCounter().GetValue(),
+			})
+		}
+		return sum, true
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		var gauge metricdata.Gauge[float64]
+		for _, metric := range family.GetMetric() {
+			value := metric.GetGauge().GetValue()
+			if family.GetType() == dto.MetricType_UNTYPED {
+				value = metric.GetUntyped().GetValue()
+			}
+			gauge.DataPoints = append(gauge.DataPoints, metricdata.DataPoint[float64]{
+				Attributes: labelSet(metric.GetLabel()),
+				Time:       now,
+				Value:      value,
+			})
+		}
+		return gauge, true
+	case dto.MetricType_HISTOGRAM:
+		histogram := metricdata.Histogram[float64]{Temporality: metricdata.CumulativeTemporality}
+		for _, metric := range family.GetMetric() {
+			histogram.DataPoints = append(histogram.DataPoints, p.histogramPoint(metric, now))
+		}
+		return histogram, true
+	case dto.MetricType_SUMMARY:
+		var summary metricdata.Summary
+		for _, metric := range family.GetMetric() {
+			s := metric.GetSummary()
+			point := metricdata.SummaryDataPoint{
+				Attributes: labelSet(metric.GetLabel()),
+				StartTime:  p.startTime(s.GetCreatedTimestamp().AsTime()),
+				Time:       now,
+				Count:      s.GetSampleCount(),
+				Sum:        s.GetSampleSum(),
+			}
+			for _, q := range s.GetQuantile() {
+				point.QuantileValues = append(point.QuantileValues, metricdata.QuantileValue{Quantile: q.GetQuantile(), Value: q.GetValue()})
+			}
+			summary.DataPoints = append(summary.DataPoints, point)
+		}
+		return summary, true
+	}
+	return nil, false
+}
+
+// histogramPoint converts the cumulative Prometheus buckets into the per-bucket counts of OTel,
+// the last count being the observations above the highest bound
+func (p *RegistryProducer) histogramPoint(metric *dto.Metric, now time.Time) metricdata.HistogramDataPoint[float64] {
+	h := metric.GetHistogram()
+	point := metricdata.HistogramDataPoint[float64]{
+		Attributes: labelSet(metric.GetLabel()),
+		StartTime:  p.startTime(h.GetCreatedTimestamp().AsTime()),
+		Time:       now,
+		Count:      h.GetSampleCount(),
+		Sum:        h.GetSampleSum(),
+	}
+	var below uint64
+	for _, bucket := range h.GetBucket() {
+		if math.IsInf(bucket.GetUpperBound(), 1) {
+			continue
+		}
+		point.Bounds = append(point.Bounds, bucket.GetUpperBound())
+		point.BucketCounts = append(point.BucketCounts, bucket.GetCumulativeCount()-below)
+		below = bucket.GetCumulativeCount()
+	}
+	point.BucketCounts = append(point.BucketCounts, h.GetSampleCount()-below)
+	return point
+}
+
+// startTime is the creation time of a series when the registry knows it, the producer's otherwise
+func (p *RegistryProducer) startTime(created time.Time) time.Time {
+	if created.Unix() <= 0 {
+		return p.start
+	}
+	return created
+}
+
+// labelSet turns Prometheus labels into OTel attributes
+func labelSet(labels []*dto.LabelPair) attribute.Set {
+	attrs := make([]attribute.KeyValue, len(labels))
+	for i, label := range labels {
+		attrs[i] = attribute.String(label.GetName(), label.GetValue())
+	}
+	return attribute.NewSet(attrs...)
+}
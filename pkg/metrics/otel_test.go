@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"ad_service/internal/config"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// collectOTLP reads what an OTLP export of the registry would carry, by name
+func collectOTLP(t *testing.T, registry prometheus.Gatherer) map[string]metricdata.Metrics {
+	t.Helper()
+	reader := sdkmetric.NewManualReader(sdkmetric.WithProducer(NewRegistryProducer(registry)))
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer provider.Shutdown(context.Background())
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]metricdata.Metrics{}
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if scope.Scope.Name != otlpScope {
+				t.Errorf("metric %s in scope %q, want %q", m.Name, scope.Scope.Name, otlpScope)
+			}
+			byName[m.Name] = m
+		}
+	}
+	return byName
+}
+
+func TestRegistryProducer(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_events_total", Help: "Events"}, []string{"kind"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_queue", Help: "Queue"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_seconds", Help: "Latency", Buckets: []float64{0.1, 1}})
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "test_sizes", Help: "Sizes", Objectives: map[float64]float64{0.5: 0.05}})
+	untyped := prometheus.NewUntypedFunc(prometheus.UntypedOpts{Name: "test_untyped", Help: "Untyped"}, func() float64 { return 42 })
+	registry.MustRegister(counter, gauge, histogram, summary, untyped)
+
+	counter.WithLabelValues("click").Add(3)
+	gauge.Set(7)
+	for _, v := range []float64{0.05, 0.5, 5} {
+		histogram.Observe(v)
+		summary.Observe(v)
+	}
+	metrics := collectOTLP(t, registry)
+
+	sum, ok := metrics["test_events_total"].Data.(metricdata.Sum[float64])
+	if !ok || !sum.IsMonotonic || sum.Temporality != metricdata.CumulativeTemporality || len(sum.DataPoints) != 1 {
+		t.Fatalf("counter = %#v, want a cumulative monotonic sum", metrics["test_events_total"].Data)
+	}
+	if point := sum.DataPoints[0]; point.Value != 3 || point.Attributes != attribute.NewSet(attribute.String("kind", "click")) || point.StartTime.IsZero() {
+		t.Errorf("counter point = %+v, want 3 with kind=click", point)
+	}
+	if metrics["test_events_total"].Description != "Events" {
+		t.Errorf("description = %q, want the help", metrics["test_events_total"].Description)
+	}
+
+	if g, ok := metrics["test_queue"].Data.(metricdata.Gauge[float64]); !ok || g.DataPoints[0].Value != 7 {
+		t.Errorf("gauge = %#v, want 7", metrics["test_queue"].Data)
+	}
+	if g, ok := metrics["test_untyped"].Data.(metricdata.Gauge[float64]); !ok || g.DataPoints[0].Value != 42 {
+		t.Errorf("untyped = %#v, want a gauge of 42", metrics["test_untyped"].Data)
+	}
+
+	// Prometheus buckets are cumulative, OTel counts each bucket, with one past the last bound
+	h, ok := metrics["test_seconds"].Data.(metricdata.Histogram[float64])
+	if !ok || h.Temporality != metricdata.CumulativeTemporality {
+		t.Fatalf("histogram = %#v, want a cumulative histogram", metrics["test_seconds"].Data)
+	}
+	point := h.DataPoints[0]
+	if fmt.Sprint(point.Bounds) != "[0.1 1]" || fmt.Sprint(point.BucketCounts) != "[1 1 1]" || point.Count != 3 || point.Sum != 5.55 {
+		t.Errorf("histogram point = bounds %v counts %v count %d sum %v, want [0.1 1] [1 1 1] 3 5.55", point.Bounds, point.BucketCounts, point.Count, point.Sum)
+	}
+
+	s, ok := metrics["test_sizes"].Data.(metricdata.Summary)
+	if !ok || s.DataPoints[0].Count != 3 || len(s.DataPoints[0].QuantileValues) != 1 || s.DataPoints[0].QuantileValues[0].Value != 0.5 {
+		t.Errorf("summary = %#v, want 3 observations with a median of 0.5", metrics["test_sizes"].Data)
+	}
+}
+
+// Both pipelines carry the same series: what the middleware and the packages record reaches
+// the OTLP export under the Prometheus names and labels
+func TestOTLPCarriesServiceMetrics(t *testing.T) {
+	r, m := newTestRouter(t, config.MetricsConfig{})
+	get(r, "/ads/1")
+	DBQueryDuration.WithLabelValues("get_ad_by_id", "ok").Observe(0.002)
+	CacheOperations.WithLabelValues("ad", "hit").Inc()
+	AdsCreated.Inc()
+
+	metrics := collectOTLP(t, m.Registry)
+	requests, ok := metrics["http_requests_total"].Data.(metricdata.Sum[float64])
+	if !ok {
+		t.Fatalf("http_requests_total = %#v, want a sum", metrics["http_requests_total"].Data)
+	}
+	want := attribute.NewSet(attribute.String("method", http.MethodGet), attribute.String("endpoint", "/ads/:id"), attribute.String("status_code", "200"), attribute.String("status_class", "2xx"))
+	var found bool
+	for _, point := range requests.DataPoints {
+		found = found || point.Attributes == want && point.Value == 1
+	}
+	if !found {
+		t.Errorf("http_requests_total points = %+v, want the request", requests.DataPoints)
+	}
+	for _, name := range []string{"http_request_duration_seconds", "db_query_duration_seconds", "cache_operations_total", "ads_created_total", "go_goroutines"} {
+		if _, ok := metrics[name]; !ok {
+			t.Errorf("%s is missing from the OTLP export", name)
+		}
+	}
+}
+
+func TestInitOTLP(t *testing.T) {
+	m := newMetrics(config.MetricsConfig{})
+	var exports atomic.Int32
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/v1/metrics" && len(body) > 0 {
+			exports.Add(1)
+		}
+	}))
+	defer collector.Close()
+	cfg := config.MetricsOTLPConfig{
+		Enabled:  true,
+		Protocol: "http",
+		Endpoint: strings.TrimPrefix(collector.URL, "http://"),
+		Interval: time.Hour, // only the export on shutdown runs
+		Timeout:  2 * time.Second,
+	}
+
+	// The last values are pushed on shutdown
+	shutdown, err := m.InitOTLP(cfg, resource.Empty())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(context.Background()); err != nil || exports.Load() != 1 {
+		t.Errorf("shutdown = %v with %d exports, want the metrics flushed once", err, exports.Load())
+	}
+
+	// A collector that is down fails the flush within the timeout, without blocking anything else
+	cfg.Endpoint, cfg.Timeout = "127.0.0.1:1", 200*time.Millisecond
+	shutdown, err = m.InitOTLP(cfg, resource.Empty())
+	if err != nil {
+		t.Fatalf("InitOTLP with an unreachable collector = %v, want it connected to lazily", err)
+	}
+	start := time.Now()
+	shutdown(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown took %s with the collector down, want it bounded by the timeout", elapsed)
+	}
+
+	// Disabled, nothing is started
+	if shutdown, err := m.InitOTLP(config.MetricsOTLPConfig{}, resource.Empty()); err != nil || shutdown(context.Background()) != nil {
+		t.Errorf("disabled InitOTLP = %v", err)
+	}
+	if _, err := m.InitOTLP(config.MetricsOTLPConfig{Enabled: true, Protocol: "grpc"}, resource.Empty()); err == nil {
+		t.Error("InitOTLP accepted the grpc protocol")
+	}
+}
@@ -1,49 +1,380 @@
 package metrics
 
 import (
-	"time"
-
+	"ad_service/internal/config"
+	"io"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// Metrics is the registry built by InitMetrics, with the HTTP vectors recorded by the Gin middleware.
+// Vectors recorded deeper in the stack (cache, database, business) stay package variables and are
+// registered in the same registry.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	// Counter for total HTTP requests received, labeled by method, endpoint, status code and status class (2xx, 4xx, ...)
+	RequestCounter *prometheus.CounterVec
+	// Histogram to track request durations in seconds, labeled by method, endpoint, status code and status class
+	RequestDuration *prometheus.HistogramVec
+	// Histograms for request and response body sizes in bytes, labeled by method and endpoint
+	RequestSize  *prometheus.HistogramVec
+	ResponseSize *prometheus.HistogramVec
+}
+
 var (
-	// Counter for total HTTP requests received, labeled by method, endpoint and status code
-	RequestCounter = prometheus.NewCounterVec(
+	// Histogram to track repository query durations in seconds, labeled by method and outcome (ok, not_found, error)
+	DBQueryDuration = newDBQueryDuration(prometheus.DefBuckets)
+
+	// Counter for rows returned by list queries, labeled by repository method, to spot unbounded pages
+	DBRowsReturned = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_rows_returned_total",
+			Help: "Total number of rows returned by list queries",
+		},
+		[]string{"method"},
+	)
+
+	// Counter for cache lookups, labeled by entity (ad, list, count) and outcome (hit, miss, negative_hit, error, bypass)
+	CacheOperations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_operations_total",
+			Help: "Total number of cache lookups by entity and outcome",
+		},
+		[]string{"entity", "outcome"},
+	)
+
+	// Histogram to track Redis command latency in seconds, labeled by operation and outcome (hit, miss, ok, error)
+	RedisOperationDuration = newRedisOperationDuration(DefaultRedisBuckets)
+
+	// Counter for bytes saved by compressing cached values
+	CacheCompressionBytesSaved = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_compression_bytes_saved_total",
+			Help: "Total number of bytes saved by compressing cached values",
+		},
+	)
+
+	// Gauge with the state of each circuit breaker, labeled by dependency: 0 closed, 1 half-open, 2 open
+	CircuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "circuit_breaker_state",
+			Help: "State of the circuit breaker of a dependency: 0 closed, 1 half-open, 2 open",
+		},
+		[]string{"dependency"},
+	)
+
+	// Counter for circuit breaker state changes, labeled by dependency and the new state
+	CircuitBreakerTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "circuit_breaker_transitions_total",
+			Help: "Total number of circuit breaker state changes",
+		},
+		[]string{"dependency", "state"},
+	)
+
+	// Counter for calls refused by an open or probing circuit breaker, labeled by dependency
+	CircuitBreakerRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "circuit_breaker_rejected_total",
+			Help: "Total number of calls refused by a circuit breaker",
+		},
+		[]string{"dependency"},
+	)
+
+	// Counter for cache misses that waited for a database load already in flight for the same key
+	// instead of querying themselves, labeled by entity
+	CoalescedLoads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_coalesced_loads_total",
+			Help: "Total number of cache misses served by a concurrent database load of the same key",
+		},
+		[]string{"entity"},
+	)
+
+	// Gauge for the ads kept cached by the hot key refresher of this replica
+	CacheHotKeys = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cache_hot_keys",
+			Help: "Number of ads in the hot set refreshed by this replica",
+		},
+	)
+
+	// Counter for hot key refreshes, labeled by result (refreshed, not_found, busy, error)
+	CacheHotRefreshes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_hot_refreshes_total",
+			Help: "Total number of hot ad cache refreshes by result",
+		},
+		[]string{"result"},
+	)
+
+	// Counter for compressed cache values that could not be decompressed
+	CacheCompressionErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_compression_errors_total",
+			Help: "Total number of cached values that failed to decompress",
+		},
+	)
+
+	// Counter for cache rebuild lock attempts, labeled by result (acquired, contended, wait_timeout)
+	CacheRebuildLocks = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_rebuild_locks_total",
+			Help: "Total number of cache rebuild lock attempts",
+		},
+		[]string{"result"},
+	)
+
+	// Gauge set to 1 while the service runs without its cache, either because Redis was unreachable
+	// at startup or because the circuit breaker is open
+	CacheDegraded = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cache_degraded",
+			Help: "Whether the cache is currently bypassed (1) or in use (0)",
+		},
+	)
+
+	// Counter for cache invalidation messages received over Redis pub/sub, including this instance's own
+	CacheInvalidationsReceived = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_invalidations_received_total",
+			Help: "Total number of cache invalidation messages received",
+		},
+	)
+
+	// Counter for keys returned by SCAN while deleting cache keys by prefix
+	CachePrefixKeysScanned = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_prefix_keys_scanned_total",
+			Help: "Total number of keys scanned by prefix deletes",
+		},
+	)
+
+	// Counter for keys removed by prefix deletes
+	CachePrefixKeysDeleted = prometheus.NewCounter(
 		prometheus.CounterOpts{
-			Name: "http_requests_total",
-			Help: "Total number of HTTP requests",
+			Name: "cache_prefix_keys_deleted_total",
+			Help: "Total number of keys deleted by prefix deletes",
 		},
-		[]string{"method", "endpoint", "status_code"},
 	)
 
-	// Histogram to track request durations in seconds, labeled by method, endpoint and status code
-	RequestDuration = prometheus.NewHistogramVec(
+	// Counter for config.yaml hot reloads, labeled by result (applied, ignored, rejected)
+	ConfigReloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "config_reloads_total",
+			Help: "Total number of configuration reloads by result",
+		},
+		[]string{"result"},
+	)
+
+	// Counter for times the cache invalidation subscriber had to reconnect
+	CacheInvalidationReconnects = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "cache_invalidation_reconnects_total",
+			Help: "Total number of cache invalidation subscriber reconnects",
+		},
+	)
+
+	// Counter for requests abandoned by the client before the response, which are answered 499
+	// and left out of the error rate
+	ClientDisconnects = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_client_disconnects_total",
+			Help: "Total number of requests whose client disconnected before the response",
+		},
+		[]string{"method", "endpoint"},
+	)
+)
+
+// DefaultRedisBuckets suit sub-millisecond Redis commands: 0.1ms .. 250ms
+var DefaultRedisBuckets = []float64{0.0001, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25}
+
+// newRequestDuration creates the HTTP latency histogram with the given buckets
+func newRequestDuration(buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "http_request_duration_seconds",
 			Help:    "Duration of HTTP requests in seconds",
-			Buckets: prometheus.DefBuckets,
+			Buckets: buckets,
+		},
+		[]string{"method", "endpoint", "status_code", "status_class"},
+	)
+}
+
+// newDBQueryDuration creates the database latency histogram with the given buckets
+func newDBQueryDuration(buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "Duration of database queries in seconds by repository method",
+			Buckets: buckets,
+		},
+		[]string{"method", "outcome"},
+	)
+}
+
+// newRedisOperationDuration creates the Redis latency histogram with the given buckets
+func newRedisOperationDuration(buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "redis_operation_duration_seconds",
+			Help:    "Duration of Redis operations in seconds",
+			Buckets: buckets,
 		},
-		[]string{"method", "endpoint", "status_code"},
+		[]string{"operation", "outcome"},
 	)
+}
+
+// sizeBuckets cover body sizes from 64B to 1MiB
+var sizeBuckets = prometheus.ExponentialBuckets(64, 4, 8)
+
+var (
+	initOnce sync.Once
+	instance *Metrics
 )
 
-// InitMetrics initializes Prometheus metrics
-func InitMetrics() {
-	// Register the metrics with Prometheus
-	prometheus.MustRegister(RequestCounter)
-	prometheus.MustRegister(RequestDuration)
+// InitMetrics builds the service's own registry with the Go runtime and process collectors and every
+// vector of this package. Latency histograms are created with the configured buckets, so it must run
+// before anything observes them. Calling it again returns the first instance and ignores cfg.
+func InitMetrics(cfg config.MetricsConfig) *Metrics {
+	initOnce.Do(func() {
+		instance = newMetrics(cfg)
+	})
+	return instance
 }
 
-// MetricsMiddlewareGin is a middleware for Gin to collect metrics for each HTTP request
-func MetricsMiddlewareGin() gin.HandlerFunc {
+// newMetrics creates the registry and registers all collectors
+func newMetrics(cfg config.MetricsConfig) *Metrics {
+	httpBuckets := prometheus.DefBuckets
+	if len(cfg.HTTPBuckets) > 0 {
+		httpBuckets = cfg.HTTPBuckets
+	}
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		RequestCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_requests_total",
+				Help: "Total number of HTTP requests",
+			},
+			[]string{"method", "endpoint", "status_code", "status_class"},
+		),
+		RequestDuration: newRequestDuration(httpBuckets),
+		RequestSize: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_request_size_bytes",
+				Help:    "Size of HTTP request bodies in bytes",
+				Buckets: sizeBuckets,
+			},
+			[]string{"method", "endpoint"},
+		),
+		ResponseSize: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_response_size_bytes",
+				Help:    "Size of HTTP response bodies in bytes",
+				Buckets: sizeBuckets,
+			},
+			[]string{"method", "endpoint"},
+		),
+	}
+
+	if len(cfg.DBBuckets) > 0 {
+		DBQueryDuration = newDBQueryDuration(cfg.DBBuckets)
+	}
+	if len(cfg.RedisBuckets) > 0 {
+		RedisOperationDuration = newRedisOperationDuration(cfg.RedisBuckets)
+	}
+
+	// Register the metrics with our own registry; the runtime collectors are added explicitly
+	// since the default registry isn't used
+	m.Registry.MustRegister(collectors.NewGoCollector())
+	m.Registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	m.Registry.MustRegister(m.RequestCounter)
+	m.Registry.MustRegister(m.RequestDuration)
+	m.Registry.MustRegister(m.RequestSize)
+	m.Registry.MustRegister(m.ResponseSize)
+	m.Registry.MustRegister(CacheRebuildLocks)
+	m.Registry.MustRegister(CacheOperations)
+	m.Registry.MustRegister(RedisOperationDuration)
+	m.Registry.MustRegister(DBQueryDuration)
+	m.Registry.MustRegister(DBRowsReturned)
+	m.Registry.MustRegister(CacheCompressionBytesSaved)
+	m.Registry.MustRegister(CacheCompressionErrors)
+	m.Registry.MustRegister(CacheDegraded)
+	m.Registry.MustRegister(CoalescedLoads)
+	m.Registry.MustRegister(CacheHotKeys)
+	m.Registry.MustRegister(CacheHotRefreshes)
+	m.Registry.MustRegister(CircuitBreakerState)
+	m.Registry.MustRegister(CircuitBreakerTransitions)
+	m.Registry.MustRegister(CircuitBreakerRejected)
+	m.Registry.MustRegister(CacheInvalidationsReceived)
+	m.Registry.MustRegister(CacheInvalidationReconnects)
+	m.Registry.MustRegister(CachePrefixKeysScanned)
+	m.Registry.MustRegister(CachePrefixKeysDeleted)
+	m.Registry.MustRegister(AdsTotal)
+	m.Registry.MustRegister(AdsTotalRefreshErrors)
+	m.Registry.MustRegister(AdsCreated)
+	m.Registry.MustRegister(AdsUpdated)
+	m.Registry.MustRegister(AdsDeleted)
+	m.Registry.MustRegister(AdsModerated)
+	m.Registry.MustRegister(AdsRenewed)
+	m.Registry.MustRegister(AdsExpired)
+	m.Registry.MustRegister(AdExpiryRuns)
+	m.Registry.MustRegister(AdReports)
+	m.Registry.MustRegister(AdServeKeywordMatches)
+	m.Registry.MustRegister(AdImpressions)
+	m.Registry.MustRegister(AdTrendingRequests)
+	m.Registry.MustRegister(AdCreateFailures)
+	m.Registry.MustRegister(AdQuotaRejections)
+	m.Registry.MustRegister(AdSearchRequests)
+	m.Registry.MustRegister(AdSearchIndexRuns)
+	m.Registry.MustRegister(AdSearchDocuments)
+	m.Registry.MustRegister(JobRuns)
+	m.Registry.MustRegister(JobDuration)
+	m.Registry.MustRegister(JobLastSuccess)
+	m.Registry.MustRegister(JobBacklog)
+	m.Registry.MustRegister(ValidationFailures)
+	m.Registry.MustRegister(ConfigReloads)
+	m.Registry.MustRegister(ClientDisconnects)
+	return m
+}
+
+// unmatchedRoute is the endpoint label for requests that matched no route, so 404 scans
+// from bots collapse into one series instead of an empty label
+const unmatchedRoute = "unmatched"
+
+// MetricsMiddlewareGin is a middleware for Gin to collect metrics for each HTTP request.
+// Requests to cfg.ExcludePaths aren't recorded; cfg.LegacyStatusLabels restores the old
+// status text labels (e.g. "Not Found") and empty endpoint for unmatched routes.
+func (m *Metrics) MetricsMiddlewareGin(cfg config.MetricsConfig) gin.HandlerFunc {
+	excluded := make(map[string]bool, len(cfg.ExcludePaths))
+	for _, path := range cfg.ExcludePaths {
+		excluded[path] = true
+	}
+
 	return func(c *gin.Context) {
+		if excluded[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+
 		// Start a timer for request duration
 		startTime := time.Now()
 
+		// Chunked requests carry no Content-Length, so count the bytes the handler reads instead
+		var body *countingReader
+		if c.Request.ContentLength < 0 && c.Request.Body != nil {
+			body = &countingReader{ReadCloser: c.Request.Body}
+			c.Request.Body = body
+		}
+
 		// Process the request
 		c.Next()
 
@@ -52,16 +383,49 @@ func MetricsMiddlewareGin() gin.HandlerFunc {
 
 		// Get the response status code
 		statusCode := c.Writer.Status()
+		status := strconv.Itoa(statusCode)
+		endpoint := c.FullPath()
+		if cfg.LegacyStatusLabels {
+			status = http.StatusText(statusCode)
+		} else if endpoint == "" {
+			endpoint = unmatchedRoute
+		}
+		class := strconv.Itoa(statusCode/100) + "xx"
 
 		// Increment the request counter with labels
-		RequestCounter.WithLabelValues(c.Request.Method, c.FullPath(), http.StatusText(statusCode)).Inc()
+		m.RequestCounter.WithLabelValues(c.Request.Method, endpoint, status, class).Inc()
 
 		// Observe the duration of the request
-		RequestDuration.WithLabelValues(c.Request.Method, c.FullPath(), http.StatusText(statusCode)).Observe(duration)
+		ObserveWithTrace(m.RequestDuration.WithLabelValues(c.Request.Method, endpoint, status, class), duration, c.Request.Context())
+
+		// Observe the payload sizes; Size() counts the bytes actually written, so streamed responses are exact
+		requestBytes := c.Request.ContentLength
+		if body != nil {
+			requestBytes = body.n
+		}
+		m.RequestSize.WithLabelValues(c.Request.Method, endpoint).Observe(float64(requestBytes))
+		m.ResponseSize.WithLabelValues(c.Request.Method, endpoint).Observe(float64(max(c.Writer.Size(), 0)))
 	}
 }
 
-// PrometheusHandler returns the HTTP handler for Prometheus metrics scraping
-func PrometheusHandler() http.Handler {
-	return promhttp.Handler()
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+// Read reads from the wrapped body and adds to the count
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// PrometheusHandler returns the HTTP handler serving the registry for Prometheus scraping. OpenMetrics
+// is negotiated when the scraper asks for it, which is the only format that carries exemplars.
+func (m *Metrics) PrometheusHandler() http.Handler {
+	return promhttp.InstrumentMetricHandler(
+		m.Registry,
+		promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{EnableOpenMetrics: true, Registry: m.Registry}),
+	)
 }
@@ -0,0 +1,208 @@
+package metrics
+
+import (
+	"ad_service/internal/config"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// newTestRouter returns a router recording into a fresh registry, with an /ads/:id route
+// answering 200 and /ads/missing answering 404
+func newTestRouter(t *testing.T, cfg config.MetricsConfig) (*gin.Engine, *Metrics) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	m := newMetrics(cfg)
+	r := gin.New()
+	r.Use(m.MetricsMiddlewareGin(cfg))
+	r.GET("/ads/:id", func(c *gin.Context) {
+		if c.Param("id") == "missing" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ad not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id")})
+	})
+	r.GET("/metrics", gin.WrapH(m.PrometheusHandler()))
+	r.GET("/healthz", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r, m
+}
+
+// get sends a GET request to r
+func get(r http.Handler, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+func TestMetricsMiddlewareLabels(t *testing.T) {
+	r, m := newTestRouter(t, config.MetricsConfig{ExcludePaths: []string{"/metrics", "/healthz"}})
+	get(r, "/ads/1")
+	get(r, "/ads/2")
+	get(r, "/ads/missing")
+	get(r, "/wp-login.php")
+	get(r, "/.env")
+	get(r, "/metrics")
+	get(r, "/healthz")
+
+	tests := []struct {
+		endpoint, status, class string
+		want                    float64
+	}{
+		{"/ads/:id", "200", "2xx", 2},
+		{"/ads/:id", "404", "4xx", 1},
+		// Every unmatched path shares one series
+		{"unmatched", "404", "4xx", 2},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(m.RequestCounter.WithLabelValues(http.MethodGet, tt.endpoint, tt.status, tt.class)); got != tt.want {
+			t.Errorf("requests of %s %s = %v, want %v", tt.endpoint, tt.status, got, tt.want)
+		}
+	}
+	// Excluded paths and the old labels aren't recorded at all
+	if series := testutil.CollectAndCount(m.RequestCounter); series != len(tests) {
+		t.Errorf("%d request series, want %d", series, len(tests))
+	}
+	if series := testutil.CollectAndCount(m.RequestDuration); series != len(tests) {
+		t.Errorf("%d duration series, want %d", series, len(tests))
+	}
+}
+
+func TestMetricsMiddlewareLegacyLabels(t *testing.T) {
+	r, m := newTestRouter(t, config.MetricsConfig{LegacyStatusLabels: true})
+	get(r, "/ads/1")
+	get(r, "/wp-login.php")
+
+	if got := testutil.ToFloat64(m.RequestCounter.WithLabelValues(http.MethodGet, "/ads/:id", "OK", "2xx")); got != 1 {
+		t.Errorf("legacy OK requests = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.RequestCounter.WithLabelValues(http.MethodGet, "", "Not Found", "4xx")); got != 1 {
+		t.Errorf("legacy unmatched requests = %v, want 1 with an empty endpoint", got)
+	}
+	// Without exclusions /metrics itself is recorded
+	get(r, "/metrics")
+	if got := testutil.ToFloat64(m.RequestCounter.WithLabelValues(http.MethodGet, "/metrics", "OK", "2xx")); got != 1 {
+		t.Errorf("/metrics requests = %v, want 1 when not excluded", got)
+	}
+}
+
+// sizeObservations returns the number and sum of the observations of a size histogram series
+func sizeObservations(t *testing.T, h *prometheus.HistogramVec, method, endpoint string) (uint64, float64) {
+	t.Helper()
+	var m dto.Metric
+	if err := h.WithLabelValues(method, endpoint).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+func TestMetricsMiddlewareSizes(t *testing.T) {
+	cfg := config.MetricsConfig{ExcludePaths: []string{"/metrics"}}
+	gin.SetMode(gin.TestMode)
+	m := newMetrics(cfg)
+	r := gin.New()
+	r.Use(m.MetricsMiddlewareGin(cfg))
+	r.POST("/ads", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.JSON(http.StatusCreated, gin.H{"received": len(body)})
+	})
+	large := strings.Repeat("x", 200_000)
+	r.GET("/ads", func(c *gin.Context) {
+		c.String(http.StatusOK, large)
+	})
+	// Streams its response in chunks without a Content-Length
+	r.GET("/ads/export", func(c *gin.Context) {
+		for i := 0; i < 10; i++ {
+			c.Writer.WriteString(strings.Repeat("y", 1000))
+			c.Writer.Flush()
+		}
+	})
+	r.GET("/metrics", gin.WrapH(m.PrometheusHandler()))
+
+	small := `{"title": "Bike", "price": 10}`
+	post := httptest.NewRecorder()
+	r.ServeHTTP(post, httptest.NewRequest(http.MethodPost, "/ads", strings.NewReader(small)))
+	if n, sum := sizeObservations(t, m.RequestSize, http.MethodPost, "/ads"); n != 1 || sum != float64(len(small)) {
+		t.Errorf("POST request sizes = %d observations of %v bytes, want 1 of %d", n, sum, len(small))
+	}
+	if n, sum := sizeObservations(t, m.ResponseSize, http.MethodPost, "/ads"); n != 1 || sum != float64(post.Body.Len()) {
+		t.Errorf("POST response sizes = %d observations of %v bytes, want 1 of %d", n, sum, post.Body.Len())
+	}
+
+	// A chunked request has no Content-Length, so the bytes read are counted
+	chunked := httptest.NewRequest(http.MethodPost, "/ads", io.MultiReader(strings.NewReader(small), strings.NewReader(small)))
+	chunked.ContentLength = -1
+	r.ServeHTTP(httptest.NewRecorder(), chunked)
+	if n, sum := sizeObservations(t, m.RequestSize, http.MethodPost, "/ads"); n != 2 || sum != float64(3*len(small)) {
+		t.Errorf("request sizes after a chunked POST = %d observations of %v bytes, want 2 of %d", n, sum, 3*len(small))
+	}
+
+	get(r, "/ads")
+	if n, sum := sizeObservations(t, m.ResponseSize, http.MethodGet, "/ads"); n != 1 || sum != float64(len(large)) {
+		t.Errorf("GET response sizes = %d observations of %v bytes, want 1 of %d", n, sum, len(large))
+	}
+	if n, sum := sizeObservations(t, m.RequestSize, http.MethodGet, "/ads"); n != 1 || sum != 0 {
+		t.Errorf("GET request sizes = %d observations of %v bytes, want 1 of 0", n, sum)
+	}
+	get(r, "/ads/export")
+	if _, sum := sizeObservations(t, m.ResponseSize, http.MethodGet, "/ads/export"); sum != 10000 {
+		t.Errorf("streamed response size = %v, want the 10000 bytes written", sum)
+	}
+
+	get(r, "/metrics")
+	if n, _ := sizeObservations(t, m.ResponseSize, http.MethodGet, "/metrics"); n != 0 {
+		t.Errorf("/metrics responses were observed %d times, want it excluded", n)
+	}
+}
+
+// upperBounds returns the bucket bounds of one series of a latency histogram
+func upperBounds(t *testing.T, h *prometheus.HistogramVec, labels ...string) []float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := h.WithLabelValues(labels...).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	var bounds []float64
+	for _, b := range m.GetHistogram().GetBucket() {
+		bounds = append(bounds, b.GetUpperBound())
+	}
+	return bounds
+}
+
+func TestLatencyBuckets(t *testing.T) {
+	db, redis := DBQueryDuration, RedisOperationDuration
+	t.Cleanup(func() { DBQueryDuration, RedisOperationDuration = db, redis })
+
+	// Redis commands are timed from 0.1ms by default
+	if got := upperBounds(t, RedisOperationDuration, "get", "hit"); fmt.Sprint(got) != fmt.Sprint(DefaultRedisBuckets) || got[0] != 0.0001 || got[len(got)-1] != 0.25 {
+		t.Errorf("default Redis buckets = %v, want 0.1ms..250ms", got)
+	}
+
+	m := newMetrics(config.MetricsConfig{
+		HTTPBuckets:  []float64{0.1, 1},
+		DBBuckets:    []float64{0.01, 0.1, 1},
+		RedisBuckets: []float64{0.001, 0.01},
+	})
+	tests := []struct {
+		name   string
+		h      *prometheus.HistogramVec
+		labels []string
+		want   string
+	}{
+		{"HTTP", m.RequestDuration, []string{http.MethodGet, "/ads/:id", "200", "2xx"}, "[0.1 1]"},
+		{"DB", DBQueryDuration, []string{"get_ad_by_id", "ok"}, "[0.01 0.1 1]"},
+		{"Redis", RedisOperationDuration, []string{"get", "hit"}, "[0.001 0.01]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(upperBounds(t, tt.h, tt.labels...)); got != tt.want {
+			t.Errorf("%s buckets = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"ad_service/pkg/metrics"
+	"context"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StatusClientClosedRequest is the nginx-style status recorded for requests whose client went
+// away before the response; it is never seen by the client
+const StatusClientClosedRequest = 499
+
+// ClientDisconnected reports whether err is the cancellation of a request whose client
+// disconnected. It is then recorded as a 499 with nothing written, the request span gets the
+// client_disconnected attribute instead of an error, and the disconnect is counted, so aborted
+// requests don't show up as server errors. Handlers that write their error responses themselves
+// return when it is true.
+func ClientDisconnected(c *gin.Context, err error) bool {
+	if !errors.Is(err, context.Canceled) || !errors.Is(c.Request.Context().Err(), context.Canceled) {
+		return false
+	}
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.Bool("client_disconnected", true))
+	endpoint := c.FullPath()
+	if endpoint == "" {
+		endpoint = "unmatched"
+	}
+	metrics.ClientDisconnects.WithLabelValues(c.Request.Method, endpoint).Inc()
+	c.Status(StatusClientClosedRequest)
+	return true
+}
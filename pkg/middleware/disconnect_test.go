@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"ad_service/pkg/metrics"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// slowQuery stands for a repository call that takes longer than the client waits, failing with
+// the cancellation of its context like the MySQL driver does
+func slowQuery(ctx context.Context, started chan<- struct{}) error {
+	close(started)
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("could not list ads: %w", ctx.Err())
+	case <-time.After(5 * time.Second):
+		return nil
+	}
+}
+
+func TestClientDisconnectDuringSlowCall(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	disconnects := testutil.ToFloat64(metrics.ClientDisconnects.WithLabelValues(http.MethodGet, "/ads"))
+
+	type outcome struct {
+		status  int
+		written bool
+	}
+	outcomes := make(chan outcome, 1)
+	started := make(chan struct{})
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		// The request span, and what the server made of the request once every middleware ran
+		ctx, span := provider.Tracer("test").Start(c.Request.Context(), "GET /ads")
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		span.End()
+		outcomes <- outcome{c.Writer.Status(), c.Writer.Written()}
+	})
+	r.Use(Errors(testMappings...))
+	r.GET("/ads", func(c *gin.Context) {
+		if err := slowQuery(c.Request.Context(), started); err != nil {
+			c.Error(err).SetMeta("Failed to list ads")
+		}
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	// The client gives up while the query runs and closes its connection
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/ads", nil)
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatalf("request = %d, want it canceled", resp.StatusCode)
+	}
+
+	var got outcome
+	select {
+	case got = <-outcomes:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the server didn't notice the disconnect")
+	}
+	if got.status != StatusClientClosedRequest || got.written {
+		t.Errorf("server recorded %d, written: %v; want 499 with nothing written", got.status, got.written)
+	}
+	if delta := testutil.ToFloat64(metrics.ClientDisconnects.WithLabelValues(http.MethodGet, "/ads")) - disconnects; delta != 1 {
+		t.Errorf("http_client_disconnects_total grew by %v, want 1", delta)
+	}
+	span := recorder.Ended()[0]
+	if span.Status().Code == codes.Error || len(span.Events()) != 0 {
+		t.Errorf("span status = %v with %d events, want no error recorded", span.Status(), len(span.Events()))
+	}
+	if !hasAttribute(span.Attributes(), attribute.Bool("client_disconnected", true)) {
+		t.Errorf("span attributes = %v, want client_disconnected", span.Attributes())
+	}
+}
+
+// hasAttribute reports whether attrs has attr
+func hasAttribute(attrs []attribute.KeyValue, attr attribute.KeyValue) bool {
+	for _, a := range attrs {
+		if a == attr {
+			return true
+		}
+	}
+	return false
+}
+
+func TestClientDisconnected(t *testing.T) {
+	live, canceled := context.Background(), func() context.Context {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		return ctx
+	}()
+	tests := []struct {
+		name         string
+		requestCtx   context.Context
+		err          error
+		disconnected bool
+	}{
+		{"client canceled", canceled, fmt.Errorf("could not list ads: %w", context.Canceled), true},
+		// A cancellation from inside the service, like a shutdown, is a server error
+		{"request still live", live, context.Canceled, false},
+		{"other error after a disconnect", canceled, errDown, false},
+		{"timeout after a disconnect", canceled, context.DeadlineExceeded, false},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/ads", nil).WithContext(tt.requestCtx)
+		if got := ClientDisconnected(c, tt.err); got != tt.disconnected {
+			t.Errorf("%s: ClientDisconnected = %v, want %v", tt.name, got, tt.disconnected)
+		}
+		if tt.disconnected && c.Writer.Status() != StatusClientClosedRequest {
+			t.Errorf("%s: status = %d, want 499", tt.name, c.Writer.Status())
+		}
+	}
+}
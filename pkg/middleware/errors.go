@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"ad_service/pkg/ratelimit"
+	"ad_service/pkg/response"
+	"ad_service/pkg/tracing"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrorMapping maps the errors matching Target with errors.Is to a response
+type ErrorMapping struct {
+	Target  error
+	Status  int
+	Code    string // defaults to the code of Status
+	Message string // defaults to the client message of the error, or the text of Target
+	// RetryAfter is sent as Retry-After, which 429s and 503s always carry; 1 second when unset.
+	// An error with its own RetryAfter method, like a quota with a reset time, overrides it.
+	RetryAfter time.Duration
+}
+
+// clientError is implemented by errors whose message can be shown to clients as is
+type clientError interface {
+	ClientMessage() string
+}
+
+// detailedError is implemented by errors with details for the response, like failed fields
+type detailedError interface {
+	ClientDetails() map[string]any
+}
+
+// retryableError is implemented by errors that know when a retry can succeed, like a quota with
+// a reset time; it replaces the RetryAfter of the mapping
+type retryableError interface {
+	RetryAfter(now time.Time) time.Duration
+}
+
+// Errors turns the error a handler attached with c.Error into a response, so handlers don't
+// each translate domain errors to statuses. The last error wins and is recorded on the request
+// span. Errors matching no mapping are 500s, whose message is the string meta of the error
+// (c.Error(err).SetMeta("Failed to ...")) and never the error text; so is any other 5xx. 5xx
+// errors are logged with the trace and span IDs of the request. A handler that already
+// responded keeps its response, and a request canceled by its client gets no response at all
+// (see ClientDisconnected).
+func Errors(mappings ...ErrorMapping) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		last := c.Errors.Last()
+		if last == nil || c.Writer.Written() || ClientDisconnected(c, last.Err) {
+			return
+		}
+		span := trace.SpanFromContext(c.Request.Context())
+		span.RecordError(last.Err)
+
+		mapped := mapError(last, mappings)
+		if mapped.Status == http.StatusTooManyRequests || mapped.Status == http.StatusServiceUnavailable {
+			wait := mapped.RetryAfter
+			var retryable retryableError
+			if errors.As(last.Err, &retryable) {
+				wait = retryable.RetryAfter(time.Now())
+			}
+			ratelimit.SetRetryAfter(c.Writer.Header(), wait)
+		}
+		if mapped.Status < http.StatusInternalServerError {
+			response.ErrorCode(c, mapped.Status, mapped.Code, mapped.Message, clientDetails(last.Err))
+			return
+		}
+		span.SetStatus(codes.Error, mapped.Message)
+		slog.ErrorContext(c.Request.Context(), mapped.Message, "error", last.Err, "method", c.Request.Method, "route", c.FullPath(), "status", mapped.Status)
+		var details gin.H
+		if traceID, ok := tracing.TraceID(c.Request.Context()); ok {
+			details = gin.H{"trace_id": traceID}
+		}
+		response.ErrorCode(c, mapped.Status, mapped.Code, mapped.Message, details)
+	}
+}
+
+// mapError returns the response to an error: timeouts are 504s whatever else they are wrapped
+// in, then the first mapping matching the error applies
+func mapError(ginErr *gin.Error, mappings []ErrorMapping) ErrorMapping {
+	if errors.Is(ginErr.Err, context.DeadlineExceeded) {
+		return ErrorMapping{Status: http.StatusGatewayTimeout, Code: response.CodeTimeout, Message: "The request timed out"}
+	}
+	mapped := ErrorMapping{Status: http.StatusInternalServerError, Message: "Internal server error"}
+	for _, mapping := range mappings {
+		if errors.Is(ginErr.Err, mapping.Target) {
+			mapped = mapping
+			break
+		}
+	}
+	if mapped.Code == "" {
+		mapped.Code = response.Code(mapped.Status)
+	}
+
+	var client clientError
+	switch {
+	case mapped.Status >= http.StatusInternalServerError:
+		if message, ok := ginErr.Meta.(string); ok {
+			mapped.Message = message
+		}
+	case mapped.Message != "":
+	case errors.As(ginErr.Err, &client):
+		mapped.Message = client.ClientMessage()
+	default:
+		mapped.Message = mapped.Target.Error()
+	}
+	return mapped
+}
+
+// clientDetails returns the details of an error, nil for errors without any
+func clientDetails(err error) gin.H {
+	var detailed detailedError
+	if !errors.As(err, &detailed) {
+		return nil
+	}
+	return gin.H(detailed.ClientDetails())
+}
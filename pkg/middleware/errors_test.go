@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"ad_service/pkg/tracing"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	errMissing  = errors.New("missing")
+	errInvalid  = errors.New("invalid")
+	errTaken    = errors.New("taken")
+	errDenied   = errors.New("denied")
+	errThrottle = errors.New("throttled")
+	errDown     = errors.New("down")
+)
+
+// testMappings cover one error of every class
+var testMappings = []ErrorMapping{
+	{Target: errMissing, Status: http.StatusNotFound},
+	{Target: errInvalid, Status: http.StatusBadRequest, Code: "validation_failed"},
+	{Target: errTaken, Status: http.StatusConflict, Message: "Already taken"},
+	{Target: errDenied, Status: http.StatusForbidden},
+	{Target: errThrottle, Status: http.StatusTooManyRequests},
+	{Target: errDown, Status: http.StatusServiceUnavailable, RetryAfter: 5 * time.Second},
+}
+
+// messageError has a client message, fields and a reset time like the domain errors
+type messageError struct {
+	kind    error
+	message string
+	wait    time.Duration
+}
+
+func (e *messageError) Error() string                          { return "internal text of " + e.message }
+func (e *messageError) Unwrap() error                          { return e.kind }
+func (e *messageError) ClientMessage() string                  { return e.message }
+func (e *messageError) ClientDetails() map[string]any          { return map[string]any{"field": "title"} }
+func (e *messageError) RetryAfter(now time.Time) time.Duration { return e.wait }
+
+// errorBody is the envelope of an error response
+type errorBody struct {
+	Error struct {
+		Code    string         `json:"code"`
+		Message string         `json:"message"`
+		Details map[string]any `json:"details"`
+	} `json:"error"`
+}
+
+func TestErrorsMapsErrorClasses(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		status     int
+		code       string
+		message    string
+		retryAfter string
+		details    bool
+	}{
+		{"not found", errMissing, http.StatusNotFound, "not_found", "missing", "", false},
+		{"not found wrapped", fmt.Errorf("could not get ad: %w", errMissing), http.StatusNotFound, "not_found", "missing", "", false},
+		{"validation with details", &messageError{kind: errInvalid, message: "Title is required"}, http.StatusBadRequest, "validation_failed", "Title is required", "", true},
+		{"conflict mapping message", &messageError{kind: errTaken, message: "Slug taken"}, http.StatusConflict, "conflict", "Already taken", "", true},
+		{"forbidden", errDenied, http.StatusForbidden, "forbidden", "denied", "", false},
+		{"throttled default wait", errThrottle, http.StatusTooManyRequests, "too_many_requests", "throttled", "1", false},
+		{"throttled own wait", &messageError{kind: errThrottle, message: "Slow down", wait: 90 * time.Second}, http.StatusTooManyRequests, "too_many_requests", "Slow down", "90", true},
+		{"unavailable", fmt.Errorf("could not query: %w", errDown), http.StatusServiceUnavailable, "unavailable", "Failed to list ads", "5", false},
+		// A timeout is a 504 even when wrapped in a mapped error
+		{"deadline exceeded", fmt.Errorf("%w: %w", errMissing, context.DeadlineExceeded), http.StatusGatewayTimeout, "timeout", "The request timed out", "", false},
+		{"unmapped", errors.New("connection refused"), http.StatusInternalServerError, "internal", "Failed to list ads", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.Use(Errors(testMappings...))
+			r.GET("/ads", func(c *gin.Context) {
+				c.Error(tt.err).SetMeta("Failed to list ads")
+			})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ads", nil))
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			var body errorBody
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode %q: %v", w.Body.String(), err)
+			}
+			if body.Error.Code != tt.code || body.Error.Message != tt.message {
+				t.Errorf("error = %s %q, want %s %q", body.Error.Code, body.Error.Message, tt.code, tt.message)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.retryAfter)
+			}
+			if got := body.Error.Details["field"] != nil; got != tt.details {
+				t.Errorf("details = %v, want the field details: %v", body.Error.Details, tt.details)
+			}
+		})
+	}
+}
+
+func TestErrorsKeepsWrittenResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Errors(testMappings...))
+	r.GET("/ads", func(c *gin.Context) {
+		c.Error(errMissing)
+		c.Status(http.StatusNoContent)
+		c.Writer.WriteHeaderNow()
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ads", nil))
+
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("response = %d %q, want the handler's 204", w.Code, w.Body.String())
+	}
+}
+
+// Server errors are logged with the IDs of the request's span, client errors aren't logged
+func TestErrorsLogsServerErrorsWithTraceIDs(t *testing.T) {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(tracing.NewLogHandler(slog.NewJSONHandler(&logs, nil))))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	provider := sdktrace.NewTracerProvider()
+	defer provider.Shutdown(context.Background())
+
+	var span trace.Span
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		var ctx context.Context
+		ctx, span = provider.Tracer("test").Start(c.Request.Context(), "request")
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		span.End()
+	})
+	r.Use(Errors(testMappings...))
+	r.GET("/ads/:id", func(c *gin.Context) {
+		if c.Param("id") == "missing" {
+			c.Error(errMissing)
+			return
+		}
+		c.Error(errors.New("connection refused")).SetMeta("Failed to get ad")
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ads/missing", nil))
+	if logs.Len() != 0 {
+		t.Errorf("a 404 was logged: %s", logs.String())
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ads/7", nil))
+	var record map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &record); err != nil {
+		t.Fatalf("decode %q: %v", logs.String(), err)
+	}
+	sc := span.SpanContext()
+	if record["msg"] != "Failed to get ad" || record["status"] != 500.0 || record["trace_id"] != sc.TraceID().String() || record["span_id"] != sc.SpanID().String() {
+		t.Errorf("log record = %v, want the 500 with trace %s and span %s", record, sc.TraceID(), sc.SpanID())
+	}
+}
@@ -6,34 +6,83 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 )
 
-// GracefulShutdown handles the graceful shutdown of the HTTP server
-func GracefulShutdown(srv *http.Server) {
-	// Start the server in a goroutine
-	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("listen: %s\n", err)
-		}
-	}()
+// ShutdownHook releases one dependency once the HTTP servers have drained
+type ShutdownHook struct {
+	Name string
+	Fn   func(ctx context.Context) error
+}
 
-	// Wait for a signal to gracefully shut down the server
+// GracefulShutdown starts the HTTP servers and, on SIGINT or SIGTERM, shuts them down together
+// and then runs the hooks one by one in the given order. The drain and the hooks share one
+// timeout; a hook still running when it expires is abandoned and the remaining hooks are skipped.
+// If any server fails to start, the process exits with the server's address in the error.
+func GracefulShutdown(timeout time.Duration, servers []*http.Server, hooks ...ShutdownHook) {
+	// Start the servers in goroutines
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("listen on %s: %s\n", srv.Addr, err)
+			}
+		}(srv)
+	}
+
+	// Wait for a signal to gracefully shut down the servers
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	log.Println("Shutting down server...")
 
-	// Create a context with a timeout to allow for graceful shutdown
-	timeoutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Create a context with a timeout shared by the servers and the hooks
+	timeoutCtx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	// Attempt to gracefully shut down the server
-	if err := srv.Shutdown(timeoutCtx); err != nil {
-		log.Fatal("Server forced to shutdown:", err)
+	// Attempt to gracefully shut down the servers in parallel
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(timeoutCtx); err != nil {
+				log.Printf("Server on %s forced to shutdown: %v", srv.Addr, err)
+			}
+		}(srv)
 	}
+	wg.Wait()
+
+	RunShutdownHooks(hooks, timeoutCtx)
 
 	log.Println("Server exiting")
 }
+
+// RunShutdownHooks runs the hooks in order, logging how long each took and its error. A hook
+// that doesn't return before ctx is done is abandoned, and the hooks after it are skipped.
+func RunShutdownHooks(hooks []ShutdownHook, ctx context.Context) {
+	for i, hook := range hooks {
+		start := time.Now()
+		done := make(chan error, 1)
+		go func() {
+			done <- hook.Fn(ctx)
+		}()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Printf("Shutdown hook %q failed after %s: %v", hook.Name, time.Since(start), err)
+			} else {
+				log.Printf("Shutdown hook %q done in %s", hook.Name, time.Since(start))
+			}
+		case <-ctx.Done():
+			log.Printf("Shutdown hook %q abandoned after %s: %v", hook.Name, time.Since(start), ctx.Err())
+			for _, skipped := range hooks[i+1:] {
+				log.Printf("Shutdown hook %q skipped, out of time", skipped.Name)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// captureLog sends the standard logger's output to a buffer until the test ends
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(previous) })
+	return &buf
+}
+
+func TestRunShutdownHooksInOrder(t *testing.T) {
+	logs := captureLog(t)
+	var ran []string
+	hook := func(name string, err error) ShutdownHook {
+		return ShutdownHook{Name: name, Fn: func(context.Context) error {
+			ran = append(ran, name)
+			return err
+		}}
+	}
+	RunShutdownHooks([]ShutdownHook{
+		hook("background workers", nil),
+		hook("tracer", errors.New("could not flush spans: context deadline exceeded")),
+		hook("database", nil),
+	}, context.Background())
+
+	// A failed hook is logged and the next ones still run
+	if got := strings.Join(ran, ", "); got != "background workers, tracer, database" {
+		t.Errorf("hooks ran: %s, want all in order", got)
+	}
+	if !strings.Contains(logs.String(), `Shutdown hook "tracer" failed after`) || !strings.Contains(logs.String(), `Shutdown hook "database" done in`) {
+		t.Errorf("log = %q, want the failure and the later hook", logs.String())
+	}
+}
+
+func TestRunShutdownHooksAbandonsHungHook(t *testing.T) {
+	logs := captureLog(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	release := make(chan struct{})
+	defer close(release)
+	closed := false
+
+	start := time.Now()
+	RunShutdownHooks([]ShutdownHook{
+		{Name: "tracer", Fn: func(context.Context) error {
+			// A collector that never answers, ignoring ctx
+			<-release
+			return nil
+		}},
+		{Name: "database", Fn: func(context.Context) error {
+			closed = true
+			return nil
+		}},
+	}, ctx)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("RunShutdownHooks took %s past its 50ms deadline", elapsed)
+	}
+	if closed {
+		t.Error("the hook after the abandoned one ran out of time and still ran")
+	}
+	if !strings.Contains(logs.String(), `Shutdown hook "tracer" abandoned`) || !strings.Contains(logs.String(), `Shutdown hook "database" skipped`) {
+		t.Errorf("log = %q, want the abandoned and skipped hooks", logs.String())
+	}
+}
+
+// freeAddr returns a local address nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestGracefulShutdownDrainsBeforeHooks(t *testing.T) {
+	captureLog(t)
+	addr := freeAddr(t)
+	started := make(chan struct{})
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+	srv := &http.Server{Addr: addr, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		record("request done")
+	})}
+
+	release := make(chan struct{})
+	defer close(release)
+	hook := func(name string) ShutdownHook {
+		return ShutdownHook{Name: name, Fn: func(context.Context) error {
+			record(name)
+			return nil
+		}}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		GracefulShutdown(300*time.Millisecond, []*http.Server{srv},
+			hook("tracer"),
+			hook("cache"),
+			ShutdownHook{Name: "hung", Fn: func(context.Context) error {
+				<-release
+				return nil
+			}},
+			hook("database"),
+		)
+	}()
+
+	// An in-flight request when SIGTERM arrives; the first attempts may come before the
+	// server listens
+	go func() {
+		for i := 0; i < 100; i++ {
+			if resp, err := http.Get("http://" + addr); err == nil {
+				resp.Body.Close()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server never answered")
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("GracefulShutdown still running after its 300ms timeout")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// The request drained first, the hooks ran in order and the one after the hung hook was skipped
+	if got := strings.Join(events, ", "); got != "request done, tracer, cache" {
+		t.Errorf("shutdown sequence = %s, want the request, tracer and cache", got)
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"ad_service/pkg/response"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Caller is the end user making a request, as asserted by the API gateway
+type Caller struct {
+	UserID string // empty for anonymous requests
+	Admin  bool
+}
+
+// callerKey is the gin context key holding the Caller
+const callerKey = "caller"
+
+// Identity reads the caller from the headers forwarded by the API gateway and stores it in the
+// gin context for CallerFrom. An empty roleHeader means no caller is ever an admin.
+func Identity(userIDHeader, roleHeader, adminRole string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller := Caller{UserID: c.GetHeader(userIDHeader)}
+		if roleHeader != "" {
+			caller.Admin = c.GetHeader(roleHeader) == adminRole
+		}
+		c.Set(callerKey, caller)
+		c.Next()
+	}
+}
+
+// CallerFrom returns the caller stored by Identity, or an anonymous caller
+func CallerFrom(c *gin.Context) Caller {
+	caller, _ := c.Get(callerKey)
+	if caller, ok := caller.(Caller); ok {
+		return caller
+	}
+	return Caller{}
+}
+
+// RequireAdmin rejects requests from callers without the admin role with a 403
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !CallerFrom(c).Admin {
+			response.Abort(c, http.StatusForbidden, "Admin role required", nil)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"ad_service/internal/config"
+	"ad_service/pkg/response"
+	"ad_service/pkg/tenant"
+	"crypto/subtle"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tenant resolves the tenant of each request and stores it in the request context, where the
+// repositories and the cache scope everything to it. While tenancy is disabled every request
+// belongs to tenant.Default. Otherwise an API key selects the tenant it belongs to; without
+// one, the tenant header selects a configured tenant that has no API key. Requests resolving
+// to no tenant are answered with cfg.MissingStatus.
+func Tenant(cfg config.TenancyConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := tenant.Default
+		if cfg.Enabled {
+			var ok bool
+			if id, ok = resolveTenant(c, cfg); !ok {
+				response.Abort(c, cfg.MissingStatus, "A known tenant or a valid API key is required", nil)
+				return
+			}
+		}
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("tenant.id", id))
+		c.Request = c.Request.WithContext(tenant.With(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// resolveTenant returns the tenant of the request's API key, or of its tenant header when it
+// has no API key
+func resolveTenant(c *gin.Context, cfg config.TenancyConfig) (string, bool) {
+	if cfg.APIKeyHeader != "" {
+		if key := c.GetHeader(cfg.APIKeyHeader); key != "" {
+			for id, tenantKey := range cfg.Tenants {
+				if tenantKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(tenantKey)) == 1 {
+					return id, true
+				}
+			}
+			return "", false
+		}
+	}
+	if cfg.Header == "" {
+		return "", false
+	}
+	id := c.GetHeader(cfg.Header)
+	key, known := cfg.Tenants[id]
+	// A tenant with an API key can't be selected without it
+	return id, known && key == ""
+}
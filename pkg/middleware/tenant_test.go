@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"ad_service/internal/config"
+	"ad_service/pkg/tenant"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTenant(t *testing.T) {
+	enabled := config.TenancyConfig{
+		Enabled:       true,
+		Header:        "X-Tenant-Id",
+		APIKeyHeader:  "X-Api-Key",
+		Tenants:       map[string]string{"acme": "", "beta": "beta-key", "gamma": "gamma-key"},
+		MissingStatus: http.StatusUnauthorized,
+	}
+	rejecting400 := enabled
+	rejecting400.MissingStatus = http.StatusBadRequest
+	headerOnly := enabled
+	headerOnly.APIKeyHeader = ""
+
+	tests := []struct {
+		name    string
+		cfg     config.TenancyConfig
+		headers map[string]string
+		status  int
+		tenant  string
+	}{
+		{"disabled", config.TenancyConfig{}, nil, http.StatusOK, tenant.Default},
+		{"disabled ignores the header", config.TenancyConfig{Header: "X-Tenant-Id"}, map[string]string{"X-Tenant-Id": "acme"}, http.StatusOK, tenant.Default},
+		{"header", enabled, map[string]string{"X-Tenant-Id": "acme"}, http.StatusOK, "acme"},
+		{"API key", enabled, map[string]string{"X-Api-Key": "beta-key"}, http.StatusOK, "beta"},
+		// The API key wins over a header naming another tenant
+		{"API key over header", enabled, map[string]string{"X-Api-Key": "gamma-key", "X-Tenant-Id": "acme"}, http.StatusOK, "gamma"},
+		{"nothing", enabled, nil, http.StatusUnauthorized, ""},
+		{"nothing with 400", rejecting400, nil, http.StatusBadRequest, ""},
+		{"unknown tenant", enabled, map[string]string{"X-Tenant-Id": "delta"}, http.StatusUnauthorized, ""},
+		{"unknown API key", enabled, map[string]string{"X-Api-Key": "wrong", "X-Tenant-Id": "acme"}, http.StatusUnauthorized, ""},
+		// A tenant with an API key can't be selected by its header alone
+		{"header of a keyed tenant", enabled, map[string]string{"X-Tenant-Id": "beta"}, http.StatusUnauthorized, ""},
+		{"API key header not configured", headerOnly, map[string]string{"X-Api-Key": "beta-key", "X-Tenant-Id": "acme"}, http.StatusOK, "acme"},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			r := gin.New()
+			r.Use(Tenant(tt.cfg))
+			r.GET("/ads", func(c *gin.Context) {
+				got, _ = tenant.FromContext(c.Request.Context())
+				c.Status(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/ads", nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status || got != tt.tenant {
+				t.Errorf("response = %d with tenant %q, want %d with %q", w.Code, got, tt.status, tt.tenant)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"ad_service/pkg/tracing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TraceIDHeader is the response header carrying the trace ID of the request
+const TraceIDHeader = "X-Trace-Id"
+
+// TraceID sets the X-Trace-Id response header for sampled requests, so a trace can be found from
+// a client report. It must run after the otelgin middleware that starts the server span. The header
+// is set before the handler runs because headers can't be added once the body is written.
+func TraceID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if traceID, ok := tracing.TraceID(c.Request.Context()); ok {
+			c.Header(TraceIDHeader, traceID)
+		}
+		c.Next()
+	}
+}
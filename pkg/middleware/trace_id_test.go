@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newTracedRouter returns a router starting server spans with a provider using sampler, with
+// the trace ID and error middleware in the order of the server
+func newTracedRouter(t *testing.T, sampler sdktrace.Sampler) (*gin.Engine, *tracetest.SpanRecorder) {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSampler(sampler), sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(otelgin.Middleware("ad-service", otelgin.WithTracerProvider(provider)), TraceID(), Errors(testMappings...))
+	r.GET("/ads/:id", func(c *gin.Context) {
+		switch c.Param("id") {
+		case "missing":
+			c.Error(errMissing)
+		case "broken":
+			c.Error(errors.New("connection refused")).SetMeta("Failed to get ad")
+		default:
+			c.JSON(http.StatusOK, gin.H{"id": c.Param("id")})
+		}
+	})
+	return r, recorder
+}
+
+func TestTraceIDOfSampledRequests(t *testing.T) {
+	r, recorder := newTracedRouter(t, sdktrace.AlwaysSample())
+	for _, tt := range []struct {
+		target string
+		status int
+		inBody bool // only 5xx bodies carry the trace ID
+	}{
+		{"/ads/1", http.StatusOK, false},
+		{"/ads/missing", http.StatusNotFound, false},
+		{"/ads/broken", http.StatusInternalServerError, true},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if w.Code != tt.status {
+			t.Fatalf("GET %s = %d, want %d", tt.target, w.Code, tt.status)
+		}
+
+		spans := recorder.Ended()
+		want := spans[len(spans)-1].SpanContext().TraceID().String()
+		if got := w.Header().Get(TraceIDHeader); got != want {
+			t.Errorf("GET %s %s = %q, want the trace ID of the server span %s", tt.target, TraceIDHeader, got, want)
+		}
+		var body struct {
+			Error struct {
+				Details map[string]any `json:"details"`
+			} `json:"error"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if traceID, ok := body.Error.Details["trace_id"]; ok != tt.inBody || (ok && traceID != want) {
+			t.Errorf("GET %s error details = %v, want trace_id %s: %v", tt.target, body.Error.Details, want, tt.inBody)
+		}
+	}
+}
+
+func TestTraceIDAbsentWhenNotSampled(t *testing.T) {
+	r, _ := newTracedRouter(t, sdktrace.NeverSample())
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ads/broken", nil))
+
+	if _, ok := w.Header()[TraceIDHeader]; ok {
+		t.Errorf("%s = %q on an unsampled request, want no header", TraceIDHeader, w.Header().Get(TraceIDHeader))
+	}
+	var body errorBody
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+	if _, ok := body.Error.Details["trace_id"]; ok {
+		t.Errorf("error details = %v on an unsampled request, want no trace_id", body.Error.Details)
+	}
+}
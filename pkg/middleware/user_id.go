@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"ad_service/pkg/tracing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// UserID puts the end-user ID forwarded by the API gateway in the given header into the request
+// baggage, from where the baggage span processor copies it onto every span. It must run after
+// the otelgin middleware, whose server span is already started and so gets the attribute here.
+func UserID(header string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetHeader(header)
+		if userID == "" {
+			c.Next()
+			return
+		}
+
+		ctx, err := tracing.WithBaggageMember(tracing.EndUserIDKey, userID, c.Request.Context())
+		if err == nil {
+			trace.SpanFromContext(ctx).SetAttributes(attribute.String(tracing.EndUserIDKey, userID))
+			c.Request = c.Request.WithContext(ctx)
+		}
+		c.Next()
+	}
+}
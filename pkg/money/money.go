@@ -0,0 +1,153 @@
+// Package money holds prices as whole cents, so they are stored, compared and written to JSON
+// exactly instead of picking up float64 noise like 19.990000000000002.
+package money
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Amount is a price in cents
+type Amount int64
+
+// Max is the largest amount the DECIMAL(10, 2) price column holds, 99999999.99
+const Max Amount = 9999999999
+
+// ErrPrecision is returned when parsing an amount with more than two decimal places
+var ErrPrecision = errors.New("amounts have at most two decimal places")
+
+// Parse reads a decimal amount such as "19.99", "-5" or "0.5" without going through float64
+func Parse(s string) (Amount, error) {
+	text := s
+	negative := strings.HasPrefix(text, "-")
+	if negative || strings.HasPrefix(text, "+") {
+		text = text[1:]
+	}
+	units, fraction, hasFraction := strings.Cut(text, ".")
+	if units == "" && fraction == "" || hasFraction && fraction == "" || !digits(units) || !digits(fraction) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if len(fraction) > 2 {
+		// Trailing zeros like 19.990 are still exact
+		if strings.TrimRight(fraction[2:], "0") != "" {
+			return 0, ErrPrecision
+		}
+		fraction = fraction[:2]
+	}
+	fraction += strings.Repeat("0", 2-len(fraction))
+	if units == "" {
+		units = "0"
+	}
+	cents, err := strconv.ParseInt(units+fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q: %v", s, err)
+	}
+	if negative {
+		cents = -cents
+	}
+	return Amount(cents), nil
+}
+
+// digits reports whether s holds only ASCII digits
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// FromFloat converts a float to the nearest amount, rounding halves away from zero like MySQL
+// does when storing into a DECIMAL column
+func FromFloat(f float64) Amount {
+	return Amount(math.Round(f * 100))
+}
+
+// Float64 is the amount in currency units, for arithmetic where cents don't matter
+func (a Amount) Float64() float64 {
+	return float64(a) / 100
+}
+
+// Mul multiplies the amount by a factor such as an exchange rate, rounding to the nearest cent
+func (a Amount) Mul(factor float64) Amount {
+	return Amount(math.Round(float64(a) * factor))
+}
+
+// String formats the amount with exactly two decimal places, e.g. "19.99" or "-0.50"
+func (a Amount) String() string {
+	sign, cents := "", int64(a)
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// MarshalJSON writes the amount as a JSON number with two decimal places
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON reads a JSON number or string, failing with ErrPrecision for sub-cent amounts
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	text := string(data)
+	if strings.HasPrefix(text, `"`) {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+	} else if strings.ContainsAny(text, "eE") {
+		// Exponents are valid JSON numbers; expanding them with enough precision keeps them exact
+		expanded, _, err := big.ParseFloat(text, 10, 256, big.ToNearestEven)
+		if err != nil {
+			return fmt.Errorf("invalid amount %s", text)
+		}
+		text = expanded.Text('f', 10)
+	}
+	parsed, err := Parse(text)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// Scan reads the DECIMAL column, which the driver returns as text
+func (a *Amount) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return a.scanText(string(v))
+	case string:
+		return a.scanText(v)
+	case int64:
+		*a = Amount(v * 100)
+		return nil
+	case float64:
+		*a = FromFloat(v)
+		return nil
+	}
+	return fmt.Errorf("could not scan %T into an amount", src)
+}
+
+func (a *Amount) scanText(text string) error {
+	parsed, err := Parse(text)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// Value writes the amount as decimal text, which MySQL stores into DECIMAL without rounding
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
@@ -0,0 +1,146 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+		err  bool
+	}{
+		{"19.99", 1999, false},
+		{"0.1", 10, false},
+		{".5", 50, false},
+		{"-5", -500, false},
+		{"+7.05", 705, false},
+		{"19.990", 1999, false},
+		{"99999999.99", Max, false},
+		{"19.999", 0, true},
+		{"1.", 0, true},
+		{"", 0, true},
+		{"1e3", 0, true},
+		{"12,50", 0, true},
+		{"99999999999999999999", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("Parse(%q) = %d, %v; want %d, error %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+	if _, err := Parse("0.001"); !errors.Is(err, ErrPrecision) {
+		t.Errorf("Parse of a sub-cent amount = %v, want ErrPrecision", err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	// The sums that float64 gets wrong are exact in cents
+	tenth, _ := Parse("0.1")
+	fifth, _ := Parse("0.2")
+	tests := []struct {
+		name   string
+		amount Amount
+		want   string
+	}{
+		{"0.1 + 0.2", tenth + fifth, "0.30"},
+		{"19.99", 1999, "19.99"},
+		{"3 × 19.99", 3 * 1999, "59.97"},
+		{"largest price", Max, "99999999.99"},
+		{"beyond float64 precision", 9007199254740993, "90071992547409.93"},
+		{"negative", -50, "-0.50"},
+		{"zero", 0, "0.00"},
+	}
+	for _, tt := range tests {
+		data, err := json.Marshal(struct {
+			Price Amount `json:"price"`
+		}{tt.amount})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `{"price":` + tt.want + `}`; string(data) != want {
+			t.Errorf("%s: %s, want %s", tt.name, data, want)
+		}
+		var decoded struct {
+			Price Amount `json:"price"`
+		}
+		if err := json.Unmarshal(data, &decoded); err != nil || decoded.Price != tt.amount {
+			t.Errorf("%s: round trip = %d, %v; want %d", tt.name, decoded.Price, err, tt.amount)
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+		err  error
+	}{
+		{`19.99`, 1999, nil},
+		{`"19.99"`, 1999, nil},
+		{`1.999e1`, 1999, nil},
+		{`2E2`, 20000, nil},
+		{`null`, 42, nil}, // keeps the previous value
+		{`19.999`, 42, ErrPrecision},
+		{`1.9999e1`, 42, ErrPrecision},
+	}
+	for _, tt := range tests {
+		got := Amount(42)
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("Unmarshal(%s) = %d, %v; want %d, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+	var got Amount
+	if err := json.Unmarshal([]byte(`"abc"`), &got); err == nil {
+		t.Error("Unmarshal of a non-numeric string succeeded")
+	}
+}
+
+func TestScanAndValue(t *testing.T) {
+	tests := []struct {
+		src  any
+		want Amount
+	}{
+		{[]byte("19.99"), 1999},
+		{"0.30", 30},
+		{[]byte("99999999.99"), Max},
+		{int64(5), 500},
+		// A driver returning floats is rounded to the nearest cent
+		{0.1 + 0.2, 30},
+		{19.990000000000002, 1999},
+	}
+	for _, tt := range tests {
+		var got Amount
+		if err := got.Scan(tt.src); err != nil || got != tt.want {
+			t.Errorf("Scan(%v) = %d, %v; want %d", tt.src, got, err, tt.want)
+		}
+	}
+	var got Amount
+	if err := got.Scan(true); err == nil {
+		t.Error("Scan of a bool succeeded")
+	}
+
+	if value, err := Amount(1999).Value(); err != nil || value != "19.99" {
+		t.Errorf("Value() = %v, %v; want the text 19.99", value, err)
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	// Halves round away from zero, like MySQL storing into DECIMAL
+	if got := FromFloat(0.125); got != 13 {
+		t.Errorf("FromFloat(0.125) = %d, want 13", got)
+	}
+	if got := FromFloat(-0.125); got != -13 {
+		t.Errorf("FromFloat(-0.125) = %d, want -13", got)
+	}
+	if got := Amount(1999).Mul(0.92); got != 1839 {
+		t.Errorf("19.99 × 0.92 = %s, want 18.39", got)
+	}
+	if got := Amount(1999).Float64(); got != 19.99 {
+		t.Errorf("Float64() = %v, want 19.99", got)
+	}
+}
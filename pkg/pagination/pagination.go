@@ -0,0 +1,103 @@
+// Package pagination parses the page and limit parameters of list endpoints and caps how many
+// items one request may ask for, so a request like ?limit=1000000 can't make the service load
+// and serialize a whole table. Oversized pages are either served at the cap or rejected; lists
+// of IDs above the cap are always rejected, since serving part of them would silently drop ads
+// the caller asked for.
+package pagination
+
+import (
+	"ad_service/pkg/response"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Supported values of Policy.Mode
+const (
+	ModeClamp  = "clamp"
+	ModeReject = "reject"
+)
+
+// DefaultLimit is the page size when a request doesn't give one
+const DefaultLimit = 10
+
+// DefaultMaxLimit is the cap of a zero Policy
+const DefaultMaxLimit = 100
+
+// Policy caps the size of pages and ID lists
+type Policy struct {
+	MaxLimit int    // largest page or ID list a request may ask for, DefaultMaxLimit when 0
+	Mode     string // clamp serves larger pages at MaxLimit, reject answers them with 400
+}
+
+// Max is the effective cap
+func (p Policy) Max() int {
+	if p.MaxLimit <= 0 {
+		return DefaultMaxLimit
+	}
+	return p.MaxLimit
+}
+
+// Page is a validated page request
+type Page struct {
+	Page      int
+	Limit     int // page size to query, at most the policy's cap
+	Requested int // page size the request asked for, larger than Limit when it was clamped
+}
+
+// Clamped reports whether the requested page size was lowered to the cap
+func (p Page) Clamped() bool {
+	return p.Requested > p.Limit
+}
+
+// Meta is the response meta of the page holding count items. A clamped page also reports the
+// limit that was asked for, next to the limit that was applied.
+func (p Page) Meta(count int) gin.H {
+	meta := response.Page(p.Page, p.Limit, count)
+	if p.Clamped() {
+		meta["requested_limit"] = p.Requested
+	}
+	return meta
+}
+
+// Error is an invalid parameter, with the field and rule reported in the 400 response
+type Error struct {
+	Field   string
+	Rule    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Parse reads the page and limit query parameters, defaulting to the first page of
+// DefaultLimit items, and applies the cap to the limit
+func (p Policy) Parse(c *gin.Context) (Page, *Error) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page <= 0 {
+		return Page{}, &Error{"page", "positive_integer", "Invalid page value. Must be a positive integer."}
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(DefaultLimit)))
+	if err != nil || limit <= 0 {
+		return Page{}, &Error{"limit", "positive_integer", "Invalid limit value. Must be a positive integer."}
+	}
+
+	result := Page{Page: page, Limit: limit, Requested: limit}
+	if limit > p.Max() {
+		if p.Mode == ModeReject {
+			return Page{}, &Error{"limit", "max", "Invalid limit value. Must be at most " + strconv.Itoa(p.Max()) + "."}
+		}
+		result.Limit = p.Max()
+	}
+	return result, nil
+}
+
+// CheckCount validates the length of a list of IDs given in field, which may hold at most the
+// cap items whatever the mode
+func (p Policy) CheckCount(field string, count int) *Error {
+	if count > p.Max() {
+		return &Error{field, "max_count", "Too many " + field + ". At most " + strconv.Itoa(p.Max()) + " are allowed."}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package pagination
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parse runs p.Parse on a request with the given query string
+func parse(p Policy, query string) (Page, *Error) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/ads?"+query, nil)
+	return p.Parse(c)
+}
+
+func TestParse(t *testing.T) {
+	clamp := Policy{MaxLimit: 100, Mode: ModeClamp}
+	reject := Policy{MaxLimit: 100, Mode: ModeReject}
+	tests := []struct {
+		name   string
+		policy Policy
+		query  string
+		want   Page
+		rule   string
+	}{
+		{"defaults", clamp, "", Page{Page: 1, Limit: DefaultLimit, Requested: DefaultLimit}, ""},
+		{"at the cap", clamp, "page=3&limit=100", Page{Page: 3, Limit: 100, Requested: 100}, ""},
+		{"at the cap rejecting", reject, "limit=100", Page{Page: 1, Limit: 100, Requested: 100}, ""},
+		{"one over clamped", clamp, "limit=101", Page{Page: 1, Limit: 100, Requested: 101}, ""},
+		{"far over clamped", clamp, "limit=1000000", Page{Page: 1, Limit: 100, Requested: 1000000}, ""},
+		{"one over rejected", reject, "limit=101", Page{}, "max"},
+		// A zero policy caps at the default and clamps
+		{"zero policy", Policy{}, "limit=101", Page{Page: 1, Limit: DefaultMaxLimit, Requested: 101}, ""},
+		{"custom cap", Policy{MaxLimit: 5, Mode: ModeReject}, "limit=6", Page{}, "max"},
+		{"zero limit", clamp, "limit=0", Page{}, "positive_integer"},
+		{"negative limit", reject, "limit=-1", Page{}, "positive_integer"},
+		{"non-numeric limit", clamp, "limit=all", Page{}, "positive_integer"},
+		{"zero page", clamp, "page=0", Page{}, "positive_integer"},
+		{"non-numeric page", clamp, "page=last", Page{}, "positive_integer"},
+	}
+	for _, tt := range tests {
+		got, err := parse(tt.policy, tt.query)
+		if tt.rule != "" {
+			if err == nil || err.Rule != tt.rule {
+				t.Errorf("%s: Parse = %+v, %v; want rule %s", tt.name, got, err, tt.rule)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: Parse = %+v, %v; want %+v", tt.name, got, err, tt.want)
+		}
+	}
+
+	if _, err := parse(reject, "limit=101"); err == nil || err.Field != "limit" || err.Error() != "Invalid limit value. Must be at most 100." {
+		t.Errorf("rejected limit = %v, want the cap in the message", err)
+	}
+	if _, err := parse(clamp, "page=0"); err == nil || err.Field != "page" {
+		t.Errorf("invalid page = %v, want the page field", err)
+	}
+}
+
+func TestMeta(t *testing.T) {
+	page := Page{Page: 2, Limit: 100, Requested: 100}
+	meta := page.Meta(7)
+	if page.Clamped() || meta["page"] != 2 || meta["limit"] != 100 || meta["count"] != 7 || meta["requested_limit"] != nil {
+		t.Errorf("Meta = %v, want page, limit and count only", meta)
+	}
+	clamped := Page{Page: 1, Limit: 100, Requested: 101}
+	if meta := clamped.Meta(100); !clamped.Clamped() || meta["limit"] != 100 || meta["requested_limit"] != 101 {
+		t.Errorf("Meta of a clamped page = %v, want the applied and requested limits", meta)
+	}
+}
+
+func TestCheckCount(t *testing.T) {
+	// ID lists are rejected over the cap whatever the mode
+	for _, mode := range []string{ModeClamp, ModeReject} {
+		p := Policy{MaxLimit: 100, Mode: mode}
+		if err := p.CheckCount("ids", 100); err != nil {
+			t.Errorf("%s: 100 IDs = %v, want them allowed", mode, err)
+		}
+		err := p.CheckCount("ids", 101)
+		if err == nil || err.Field != "ids" || err.Rule != "max_count" || err.Message != "Too many ids. At most 100 are allowed." {
+			t.Errorf("%s: 101 IDs = %+v, want max_count", mode, err)
+		}
+	}
+	if err := (Policy{}).CheckCount("ids", DefaultMaxLimit+1); err == nil {
+		t.Error("a zero policy allows more than DefaultMaxLimit IDs")
+	}
+}
@@ -0,0 +1,69 @@
+// Package ratelimit describes the state of a sliding window limit to clients. It writes the
+// X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset headers, and Retry-After on
+// throttled and unavailable responses.
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Window is the state of a sliding window limit for one caller: Hits holds the times of the
+// requests counted in the window, oldest first. A hit stops counting Length after it was made.
+type Window struct {
+	Limit  int
+	Length time.Duration
+	Hits   []time.Time
+}
+
+// Remaining is the number of requests still allowed in the window
+func (w Window) Remaining() int {
+	return max(w.Limit-len(w.Hits), 0)
+}
+
+// Allowed reports whether one more request fits in the window
+func (w Window) Allowed() bool {
+	return len(w.Hits) < w.Limit
+}
+
+// Record counts a request made at t, which is later than every hit already counted
+func (w *Window) Record(t time.Time) {
+	w.Hits = append(w.Hits, t)
+}
+
+// Reset is when the next request becomes allowed, or when Remaining next goes up if one already
+// is. A sliding window has no fixed reset: a slot frees up when a hit leaves the window, and with
+// the window full that is the hit which brings the count back below Limit once it leaves. An
+// empty window returns now.
+func (w Window) Reset(now time.Time) time.Time {
+	if len(w.Hits) == 0 {
+		return now
+	}
+	return w.Hits[max(len(w.Hits)-w.Limit, 0)].Add(w.Length)
+}
+
+// SetHeaders writes the limit, the remaining requests and the reset time, in Unix seconds
+// rounded up so a client waiting until then is never early
+func (w Window) SetHeaders(h http.Header, now time.Time) {
+	reset := w.Reset(now)
+	h.Set("X-RateLimit-Limit", strconv.Itoa(w.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(w.Remaining()))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Add(time.Second-time.Nanosecond).Unix(), 10))
+}
+
+// RetryAfter is the number of whole seconds until the window allows a request, at least 1
+func (w Window) RetryAfter(now time.Time) int {
+	return Seconds(w.Reset(now).Sub(now))
+}
+
+// Seconds rounds a wait up to whole seconds for Retry-After, which can't say 0
+func Seconds(wait time.Duration) int {
+	return max(int(math.Ceil(wait.Seconds())), 1)
+}
+
+// SetRetryAfter writes Retry-After for a wait, rounded up to whole seconds
+func SetRetryAfter(h http.Header, wait time.Duration) {
+	h.Set("Retry-After", strconv.Itoa(Seconds(wait)))
+}
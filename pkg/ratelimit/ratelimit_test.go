@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWindowAtTheBoundary(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	first, second := now.Add(-50*time.Minute), now.Add(-20*time.Minute)
+	w := Window{Limit: 3, Length: time.Hour, Hits: []time.Time{first, second}}
+
+	// The last allowed request: one slot left, freed again when the first hit leaves
+	if !w.Allowed() || w.Remaining() != 1 || !w.Reset(now).Equal(first.Add(time.Hour)) {
+		t.Errorf("before the last request: allowed %v, remaining %d, reset %s", w.Allowed(), w.Remaining(), w.Reset(now))
+	}
+	w.Record(now)
+	if w.Allowed() || w.Remaining() != 0 {
+		t.Errorf("after the last request: allowed %v, remaining %d; want the window full", w.Allowed(), w.Remaining())
+	}
+
+	// The first rejected request waits for the oldest hit to leave, not for a whole window
+	if reset := w.Reset(now); !reset.Equal(now.Add(10 * time.Minute)) {
+		t.Errorf("Reset = %s, want %s", reset, now.Add(10*time.Minute))
+	}
+	if got := w.RetryAfter(now); got != 600 {
+		t.Errorf("RetryAfter = %d, want 600", got)
+	}
+	// Once it has left, a request fits again
+	later := now.Add(10*time.Minute + time.Second)
+	w.Hits = w.Hits[1:]
+	if !w.Allowed() || w.Remaining() != 1 || !w.Reset(later).Equal(second.Add(time.Hour)) {
+		t.Errorf("after the oldest hit left: allowed %v, remaining %d, reset %s", w.Allowed(), w.Remaining(), w.Reset(later))
+	}
+}
+
+func TestWindowReset(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	hits := []time.Time{now.Add(-55 * time.Minute), now.Add(-40 * time.Minute), now.Add(-5 * time.Minute)}
+	tests := []struct {
+		name  string
+		limit int
+		hits  []time.Time
+		want  time.Time
+	}{
+		{"empty", 3, nil, now},
+		{"room left", 5, hits, hits[0].Add(time.Hour)},
+		{"full", 3, hits, hits[0].Add(time.Hour)},
+		// A lowered limit waits until enough hits have left, not just the oldest
+		{"over the limit", 2, hits, hits[1].Add(time.Hour)},
+		{"over a limit of one", 1, hits, hits[2].Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		w := Window{Limit: tt.limit, Length: time.Hour, Hits: tt.hits}
+		if got := w.Reset(now); !got.Equal(tt.want) {
+			t.Errorf("%s: Reset = %s, want %s", tt.name, got, tt.want)
+		}
+		if got := w.Remaining(); got != max(tt.limit-len(tt.hits), 0) {
+			t.Errorf("%s: Remaining = %d", tt.name, got)
+		}
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	// The reset is rounded up to the next second, so a client is never early
+	oldest := now.Add(-50*time.Minute + 300*time.Millisecond)
+	w := Window{Limit: 2, Length: time.Hour, Hits: []time.Time{oldest, now}}
+
+	h := http.Header{}
+	w.SetHeaders(h, now)
+	reset := now.Add(10*time.Minute + time.Second).Unix()
+	if h.Get("X-RateLimit-Limit") != "2" || h.Get("X-RateLimit-Remaining") != "0" || h.Get("X-RateLimit-Reset") != strconv.FormatInt(reset, 10) {
+		t.Errorf("headers = %v, want limit 2, remaining 0 and reset %d", h, reset)
+	}
+	if got := w.RetryAfter(now); got != 601 {
+		t.Errorf("RetryAfter = %d, want 601", got)
+	}
+
+	// A whole second isn't rounded further
+	w.Hits[0] = now.Add(-50 * time.Minute)
+	w.SetHeaders(h, now)
+	if want := strconv.FormatInt(now.Add(10*time.Minute).Unix(), 10); h.Get("X-RateLimit-Reset") != want {
+		t.Errorf("X-RateLimit-Reset = %s, want %s", h.Get("X-RateLimit-Reset"), want)
+	}
+}
+
+func TestSeconds(t *testing.T) {
+	tests := []struct {
+		wait time.Duration
+		want int
+	}{
+		{0, 1},
+		{-time.Second, 1},
+		{time.Millisecond, 1},
+		{time.Second, 1},
+		{time.Second + time.Nanosecond, 2},
+		{5 * time.Second, 5},
+	}
+	for _, tt := range tests {
+		if got := Seconds(tt.wait); got != tt.want {
+			t.Errorf("Seconds(%s) = %d, want %d", tt.wait, got, tt.want)
+		}
+	}
+	h := http.Header{}
+	SetRetryAfter(h, 1500*time.Millisecond)
+	if h.Get("Retry-After") != "2" {
+		t.Errorf("Retry-After = %q, want 2", h.Get("Retry-After"))
+	}
+}
@@ -0,0 +1,145 @@
+// Package response writes the JSON bodies of the API. Successful responses are wrapped as
+// {"data": ..., "meta": {...}} and failures as {"error": {"code", "message", "details"}}, except
+// on routes behind Legacy, which keep the shapes from before the envelope: bare data, {"message"}
+// and {"error": message} with the details as top-level keys.
+package response
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes of the envelope, derived from the status by Error except for the ones set by
+// the error mapping, validation_failed, dependency_unavailable and quota_exceeded
+const (
+	CodeBadRequest      = "bad_request"
+	CodeValidation      = "validation_failed"
+	CodeUnauthenticated = "unauthenticated"
+	CodeForbidden       = "forbidden"
+	CodeNotFound        = "not_found"
+	CodeConflict        = "conflict"
+	CodeTooManyRequests = "too_many_requests"
+	CodeInternal        = "internal"
+	CodeUnavailable     = "unavailable"
+	CodeTimeout         = "timeout"
+
+	CodeDependencyUnavailable = "dependency_unavailable"
+	CodeQuotaExceeded         = "quota_exceeded"
+)
+
+// legacyKey is the gin context key set by Legacy
+const legacyKey = "response.legacy"
+
+// Envelope is the body of a successful response
+type Envelope struct {
+	Data any   `json:"data"`
+	Meta gin.H `json:"meta"`
+}
+
+// ErrorBody is the body of a failed response
+type ErrorBody struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes a failure; Details holds e.g. the failed fields of a 400
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details gin.H  `json:"details,omitempty"`
+}
+
+// Legacy keeps the pre-envelope response shapes for the routes it's applied to
+func Legacy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(legacyKey, true)
+		c.Next()
+	}
+}
+
+// IsLegacy reports whether the request is served with the pre-envelope shapes
+func IsLegacy(c *gin.Context) bool {
+	return c.GetBool(legacyKey)
+}
+
+// Data responds with data and an empty meta
+func Data(c *gin.Context, status int, data any) {
+	List(c, status, data, nil)
+}
+
+// List responds with data and meta, e.g. the page and limit of a list. Legacy responses have
+// no room for meta and only return data.
+func List(c *gin.Context, status int, data any, meta gin.H) {
+	if IsLegacy(c) {
+		c.JSON(status, data)
+		return
+	}
+	if meta == nil {
+		meta = gin.H{}
+	}
+	c.JSON(status, Envelope{Data: data, Meta: meta})
+}
+
+// Message responds to an action without a resource to return, like a delete
+func Message(c *gin.Context, status int, message string) {
+	if IsLegacy(c) {
+		c.JSON(status, gin.H{"message": message})
+		return
+	}
+	c.JSON(status, Envelope{Data: gin.H{"message": message}, Meta: gin.H{}})
+}
+
+// Error responds with a failure whose code is derived from the status; details may be nil
+func Error(c *gin.Context, status int, message string, details gin.H) {
+	ErrorCode(c, status, Code(status), message, details)
+}
+
+// ErrorCode responds with a failure with an explicit code
+func ErrorCode(c *gin.Context, status int, code, message string, details gin.H) {
+	if IsLegacy(c) {
+		body := gin.H{"error": message}
+		for key, value := range details {
+			body[key] = value
+		}
+		c.JSON(status, body)
+		return
+	}
+	c.JSON(status, ErrorBody{Error: ErrorDetail{Code: code, Message: message, Details: details}})
+}
+
+// Abort responds with a failure like Error and stops the remaining handlers
+func Abort(c *gin.Context, status int, message string, details gin.H) {
+	Error(c, status, message, details)
+	c.Abort()
+}
+
+// Code is the error code of a status
+func Code(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthenticated
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	case http.StatusGatewayTimeout:
+		return CodeTimeout
+	}
+	if status >= 500 {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
+
+// Page is the meta of one page of a list
+func Page(page, limit, count int) gin.H {
+	return gin.H{"page": page, "limit": limit, "count": count}
+}
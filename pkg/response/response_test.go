@@ -0,0 +1,102 @@
+package response
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestShapes(t *testing.T) {
+	tests := []struct {
+		name   string
+		write  func(c *gin.Context)
+		status int
+		body   string
+		legacy string
+	}{
+		{
+			"data", func(c *gin.Context) { Data(c, http.StatusCreated, gin.H{"id": 7}) }, http.StatusCreated,
+			`{"data":{"id":7},"meta":{}}`, `{"id":7}`,
+		},
+		{
+			"list", func(c *gin.Context) { List(c, http.StatusOK, []int{1, 2}, Page(2, 10, 2)) }, http.StatusOK,
+			`{"data":[1,2],"meta":{"count":2,"limit":10,"page":2}}`, `[1,2]`,
+		},
+		{
+			"empty list", func(c *gin.Context) { List(c, http.StatusOK, []int{}, nil) }, http.StatusOK,
+			`{"data":[],"meta":{}}`, `[]`,
+		},
+		{
+			"message", func(c *gin.Context) { Message(c, http.StatusOK, "Ad deleted") }, http.StatusOK,
+			`{"data":{"message":"Ad deleted"},"meta":{}}`, `{"message":"Ad deleted"}`,
+		},
+		{
+			"error", func(c *gin.Context) { Error(c, http.StatusNotFound, "Ad not found", nil) }, http.StatusNotFound,
+			`{"error":{"code":"not_found","message":"Ad not found"}}`, `{"error":"Ad not found"}`,
+		},
+		{
+			"error with details", func(c *gin.Context) {
+				ErrorCode(c, http.StatusBadRequest, CodeValidation, "Invalid title", gin.H{"fields": []string{"title"}})
+			}, http.StatusBadRequest,
+			`{"error":{"code":"validation_failed","message":"Invalid title","details":{"fields":["title"]}}}`,
+			`{"error":"Invalid title","fields":["title"]}`,
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, legacy := range []bool{false, true} {
+				r := gin.New()
+				if legacy {
+					r.Use(Legacy())
+				}
+				r.GET("/", tt.write)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+				want := tt.body
+				if legacy {
+					want = tt.legacy
+				}
+				if w.Code != tt.status || w.Body.String() != want {
+					t.Errorf("legacy %v: %d %s, want %d %s", legacy, w.Code, w.Body.String(), tt.status, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAbort(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	reached := false
+	r.GET("/", func(c *gin.Context) { Abort(c, http.StatusForbidden, "Admins only", nil) }, func(c *gin.Context) { reached = true })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if reached || w.Code != http.StatusForbidden || w.Body.String() != `{"error":{"code":"forbidden","message":"Admins only"}}` {
+		t.Errorf("Abort = %d %s, next handler reached: %v", w.Code, w.Body.String(), reached)
+	}
+}
+
+func TestCode(t *testing.T) {
+	tests := map[int]string{
+		http.StatusBadRequest:            CodeBadRequest,
+		http.StatusUnauthorized:          CodeUnauthenticated,
+		http.StatusForbidden:             CodeForbidden,
+		http.StatusNotFound:              CodeNotFound,
+		http.StatusConflict:              CodeConflict,
+		http.StatusRequestEntityTooLarge: CodeBadRequest,
+		http.StatusTooManyRequests:       CodeTooManyRequests,
+		http.StatusInternalServerError:   CodeInternal,
+		http.StatusBadGateway:            CodeInternal,
+		http.StatusServiceUnavailable:    CodeUnavailable,
+		http.StatusGatewayTimeout:        CodeTimeout,
+	}
+	for status, want := range tests {
+		if got := Code(status); got != want {
+			t.Errorf("Code(%d) = %s, want %s", status, got, want)
+		}
+	}
+}
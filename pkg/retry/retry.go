@@ -0,0 +1,104 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Policy describes an exponential backoff schedule
+type Policy struct {
+	InitialInterval time.Duration // delay before the second attempt
+	MaxInterval     time.Duration // upper bound on a single delay, before jitter
+	Multiplier      float64       // growth factor between consecutive delays
+	Jitter          float64       // fraction by which each delay is randomly shortened or extended, e.g. 0.2 for ±20%
+	MaxElapsedTime  time.Duration // give up once this much time has passed since the first attempt, 0 means no limit
+	MaxAttempts     int           // give up after this many attempts, 0 means no limit
+}
+
+// DefaultPolicy is a reasonable schedule for connecting to a dependency at startup:
+// 500ms, 1s, 2s, 4s, 5s, ... with ±20% jitter, for at most 30s
+func DefaultPolicy() Policy {
+	return Policy{
+		InitialInterval: 500 * time.Millisecond,
+		MaxInterval:     5 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.2,
+		MaxElapsedTime:  30 * time.Second,
+	}
+}
+
+// Backoff returns the delay to wait after the given failed attempt (starting at 1), including jitter
+func (p Policy) Backoff(attempt int) time.Duration {
+	delay := float64(p.InitialInterval)
+	for i := 1; i < attempt; i++ {
+		delay *= p.Multiplier
+		if p.MaxInterval > 0 && delay >= float64(p.MaxInterval) {
+			delay = float64(p.MaxInterval)
+			break
+		}
+	}
+	if p.MaxInterval > 0 && delay > float64(p.MaxInterval) {
+		delay = float64(p.MaxInterval)
+	}
+	if p.Jitter > 0 {
+		delay += (rand.Float64()*2 - 1) * p.Jitter * delay
+	}
+	return time.Duration(delay)
+}
+
+// permanentError marks an error that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so Do stops retrying and returns err right away
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Do runs operation until it succeeds, the policy gives up or ctx is done, and returns
+// the error of the last attempt. If ctx ends before any attempt failed, ctx.Err() is returned.
+// An error wrapped with Permanent ends the loop immediately and is returned unwrapped.
+func Do(p Policy, operation func() error, ctx context.Context) error {
+	start := time.Now()
+	var lastErr error
+
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			if lastErr != nil {
+				return lastErr
+			}
+			return err
+		}
+
+		lastErr = operation()
+		if lastErr == nil {
+			return nil
+		}
+		var permanent *permanentError
+		if errors.As(lastErr, &permanent) {
+			return permanent.err
+		}
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			return lastErr
+		}
+
+		delay := p.Backoff(attempt)
+		if p.MaxElapsedTime > 0 && time.Since(start)+delay > p.MaxElapsedTime {
+			return lastErr
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return lastErr
+		}
+	}
+}
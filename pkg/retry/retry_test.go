@@ -0,0 +1,132 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBackoffSchedule(t *testing.T) {
+	p := Policy{InitialInterval: 100 * time.Millisecond, MaxInterval: time.Second, Multiplier: 2}
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, ms := range want {
+		if got := p.Backoff(i + 1); got != ms*time.Millisecond {
+			t.Errorf("Backoff(%d) = %s, want %s", i+1, got, ms*time.Millisecond)
+		}
+	}
+	// Far attempts stay capped instead of overflowing
+	if got := p.Backoff(500); got != time.Second {
+		t.Errorf("Backoff(500) = %s, want the 1s cap", got)
+	}
+	// Without a cap the delay keeps growing
+	uncapped := Policy{InitialInterval: time.Millisecond, Multiplier: 3}
+	if got := uncapped.Backoff(4); got != 27*time.Millisecond {
+		t.Errorf("uncapped Backoff(4) = %s, want 27ms", got)
+	}
+}
+
+func TestBackoffJitterBounds(t *testing.T) {
+	p := Policy{InitialInterval: time.Second, MaxInterval: 4 * time.Second, Multiplier: 2, Jitter: 0.2}
+	for attempt, base := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 5: 4 * time.Second} {
+		low, high := base, base
+		for i := 0; i < 5000; i++ {
+			got := p.Backoff(attempt)
+			if got < base*8/10 || got > base*12/10 {
+				t.Fatalf("Backoff(%d) = %s, want within 20%% of %s", attempt, got, base)
+			}
+			low, high = min(low, got), max(high, got)
+		}
+		// The jitter spreads both ways
+		if low > base*9/10 || high < base*11/10 {
+			t.Errorf("Backoff(%d) ranged over [%s, %s], want most of ±20%% around %s", attempt, low, high, base)
+		}
+	}
+}
+
+func TestDoReturnsLastError(t *testing.T) {
+	p := Policy{InitialInterval: time.Millisecond, Multiplier: 1, MaxAttempts: 3}
+	attempts := 0
+	err := Do(p, func() error {
+		attempts++
+		return fmt.Errorf("ping %d failed", attempts)
+	}, context.Background())
+	if attempts != 3 || err == nil || err.Error() != "ping 3 failed" {
+		t.Errorf("Do = %v after %d attempts, want the error of attempt 3", err, attempts)
+	}
+
+	attempts = 0
+	err = Do(p, func() error {
+		attempts++
+		if attempts < 2 {
+			return errors.New("not yet")
+		}
+		return nil
+	}, context.Background())
+	if err != nil || attempts != 2 {
+		t.Errorf("Do = %v after %d attempts, want success on attempt 2", err, attempts)
+	}
+}
+
+func TestDoStopsAtMaxElapsedTime(t *testing.T) {
+	p := Policy{InitialInterval: 20 * time.Millisecond, Multiplier: 1, MaxElapsedTime: 50 * time.Millisecond}
+	attempts := 0
+	start := time.Now()
+	Do(p, func() error {
+		attempts++
+		return errors.New("down")
+	}, context.Background())
+	// Attempts at 0, 20 and 40ms, the next delay would pass 50ms. A slow timer can leave
+	// room for one attempt less, never more.
+	if attempts < 2 || attempts > 3 {
+		t.Errorf("%d attempts, want 3 within 50ms", attempts)
+	}
+	// Do gives up instead of sleeping past the limit; the margin is for slow timers
+	if elapsed := time.Since(start); elapsed > 80*time.Millisecond {
+		t.Errorf("Do took %s, longer than MaxElapsedTime", elapsed)
+	}
+}
+
+func TestDoPermanentError(t *testing.T) {
+	errAuth := errors.New("wrong password")
+	attempts := 0
+	err := Do(Policy{InitialInterval: time.Millisecond, Multiplier: 1}, func() error {
+		attempts++
+		return Permanent(errAuth)
+	}, context.Background())
+	if attempts != 1 || err != errAuth {
+		t.Errorf("Do = %v after %d attempts, want the unwrapped error after 1", err, attempts)
+	}
+}
+
+func TestDoCancellation(t *testing.T) {
+	// Canceled while waiting between attempts: the last failure is returned, not the context's error
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	start := time.Now()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	err := Do(Policy{InitialInterval: time.Hour, Multiplier: 1}, func() error {
+		attempts++
+		return errors.New("connection refused")
+	}, ctx)
+	if attempts != 1 || err == nil || err.Error() != "connection refused" {
+		t.Errorf("Do = %v after %d attempts, want the first failure", err, attempts)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Do returned %s after cancellation, want right away", elapsed)
+	}
+
+	// Canceled before the first attempt
+	attempts = 0
+	err = Do(DefaultPolicy(), func() error {
+		attempts++
+		return nil
+	}, ctx)
+	if attempts != 0 || !errors.Is(err, context.Canceled) {
+		t.Errorf("Do on a canceled context = %v after %d attempts, want Canceled without attempts", err, attempts)
+	}
+}
@@ -0,0 +1,252 @@
+// Package scheduler runs the service's periodic background jobs. Each job runs on an interval or
+// a cron expression in its own goroutine, so a run never overlaps the previous run of the same
+// job; starts missed while a run took too long are skipped. Every run gets a timeout, an optional
+// random delay so replicas don't run in lockstep, a root span, and panic recovery so one failing
+// job can't take the service or the other jobs down. Stop cancels the runs in progress and waits
+// for them as part of graceful shutdown. Wrappers added with Use, such as the job metrics, apply
+// to every job.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrStarted is returned by Add once the scheduler is running
+var ErrStarted = errors.New("scheduler already started")
+
+// ErrSkipped is returned by a run that had nothing to do for a reason that isn't a failure, such
+// as another replica holding the job's lock. It is neither logged nor recorded as an error.
+var ErrSkipped = errors.New("run skipped")
+
+// Job is a periodic task. Exactly one of Interval and Cron sets when it runs.
+type Job struct {
+	Name      string
+	Interval  time.Duration // time between the starts of two runs
+	Cron      string        // standard five-field cron expression, in UTC, e.g. "*/5 * * * *"
+	Timeout   time.Duration // bounds one run, 0 leaves it bounded only by Stop
+	Jitter    time.Duration // each run starts up to this much later than scheduled, chosen at random
+	Immediate bool          // run once as soon as the scheduler starts, then on schedule
+	Run       func(ctx context.Context) error
+	// Backlog optionally counts the work left for the job, such as queued rows, for the metrics
+	Backlog func(ctx context.Context) (int, error)
+}
+
+// Wrapper decorates a job, e.g. to instrument its runs
+type Wrapper func(Job) Job
+
+// schedule is when a job runs next
+type schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// every is the schedule of an interval job
+type every time.Duration
+
+func (e every) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(e))
+}
+
+// entry is a registered job
+type entry struct {
+	job      Job
+	schedule schedule
+}
+
+// Scheduler runs registered jobs between Start and Stop
+type Scheduler struct {
+	mu       sync.Mutex
+	entries  []*entry
+	wrappers []Wrapper
+	started  bool
+	cancel   context.CancelFunc
+	running  sync.WaitGroup
+
+	now func() time.Time
+}
+
+// New returns an empty scheduler
+func New() *Scheduler {
+	return &Scheduler{now: func() time.Time { return time.Now().UTC() }}
+}
+
+// Add registers a job. It fails for a job without a name or a function, with a name already in
+// use, or without a valid schedule, and once the scheduler has started.
+func (s *Scheduler) Add(job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return ErrStarted
+	}
+	if job.Name == "" || job.Run == nil {
+		return errors.New("job needs a name and a function")
+	}
+	for _, e := range s.entries {
+		if e.job.Name == job.Name {
+			return fmt.Errorf("job %s already registered", job.Name)
+		}
+	}
+
+	var sched schedule
+	switch {
+	case job.Cron != "" && job.Interval != 0:
+		return fmt.Errorf("job %s has both an interval and a cron expression", job.Name)
+	case job.Cron != "":
+		parsed, err := cron.ParseStandard(job.Cron)
+		if err != nil {
+			return fmt.Errorf("job %s: invalid cron expression %q: %w", job.Name, job.Cron, err)
+		}
+		sched = parsed
+	case job.Interval > 0:
+		sched = every(job.Interval)
+	default:
+		return fmt.Errorf("job %s needs a positive interval or a cron expression", job.Name)
+	}
+	if job.Timeout < 0 || job.Jitter < 0 {
+		return fmt.Errorf("job %s: timeout and jitter cannot be negative", job.Name)
+	}
+
+	s.entries = append(s.entries, &entry{job: job, schedule: sched})
+	return nil
+}
+
+// Use adds wrappers applied to every job when the scheduler starts, the first one outermost
+func (s *Scheduler) Use(wrappers ...Wrapper) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.wrappers = append(s.wrappers, wrappers...)
+}
+
+// Start runs every registered job on its schedule until Stop
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+
+	for _, e := range s.entries {
+		for i := len(s.wrappers) - 1; i >= 0; i-- {
+			e.job = s.wrappers[i](e.job)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	for _, e := range s.entries {
+		s.running.Add(1)
+		go s.loop(e, ctx)
+	}
+}
+
+// Stop cancels the runs in progress and waits for them to return. If ctx is done first, it
+// returns ctx.Err() and the runs are left to finish on their own.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.started {
+		s.mu.Unlock()
+		return nil
+	}
+	s.cancel()
+	s.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("jobs still running: %w", ctx.Err())
+	}
+}
+
+// loop runs one job until ctx is canceled
+func (s *Scheduler) loop(e *entry, ctx context.Context) {
+	defer s.running.Done()
+
+	if e.job.Immediate {
+		s.run(e, ctx)
+	}
+	last := s.now()
+	for {
+		next := e.schedule.Next(last)
+		// Starts missed while the previous run was still going are skipped, not made up for
+		if now := s.now(); next.Before(now) {
+			skipped := 0
+			for next.Before(now) {
+				next = e.schedule.Next(next)
+				skipped++
+			}
+			slog.WarnContext(ctx, "Job overran its schedule", "job", e.job.Name, "skipped_runs", skipped)
+		}
+		// The jitter delays this run only, the schedule keeps its own pace
+		start := next
+		if e.job.Jitter > 0 {
+			start = start.Add(time.Duration(rand.Int63n(int64(e.job.Jitter))))
+		}
+
+		timer := time.NewTimer(start.Sub(s.now()))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		last = next
+		s.run(e, ctx)
+	}
+}
+
+// run makes one run of a job under its own root span, turning a panic into an error
+func (s *Scheduler) run(e *entry, ctx context.Context) {
+	tracer := otel.Tracer("ad-service.scheduler")
+	ctx, span := tracer.Start(ctx, "job "+e.job.Name, trace.WithNewRoot(), trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+	span.SetAttributes(attribute.String("job.name", e.job.Name))
+
+	if e.job.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.job.Timeout)
+		defer cancel()
+	}
+
+	err := call(e.job.Run, ctx)
+	if errors.Is(err, ErrSkipped) {
+		span.SetAttributes(attribute.Bool("job.skipped", true))
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Job failed")
+		// Runs interrupted by shutdown aren't failures worth logging
+		if !errors.Is(err, context.Canceled) {
+			slog.ErrorContext(ctx, "Job failed", "job", e.job.Name, "error", err)
+		}
+	}
+}
+
+// call runs fn, recovering a panic into an error
+func call(fn func(ctx context.Context) error, ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return fn(ctx)
+}
@@ -0,0 +1,273 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// startTest starts s with the given jobs and stops it when the test ends
+func startTest(t *testing.T, s *Scheduler, jobs ...Job) {
+	t.Helper()
+	for _, job := range jobs {
+		if err := s.Add(job); err != nil {
+			t.Fatalf("Add(%s): %v", job.Name, err)
+		}
+	}
+	s.Start()
+	t.Cleanup(func() { s.Stop(context.Background()) })
+}
+
+// recordSpans installs a span recorder as the global tracer provider for the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+// hasAttribute reports whether span has an attribute with the given key
+func hasAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) bool {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRunsNeverOverlap(t *testing.T) {
+	var running, maxRunning, runs atomic.Int32
+	s := New()
+	// Each run takes five intervals
+	startTest(t, s, Job{Name: "slow", Interval: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+		now := running.Add(1)
+		for {
+			highest := maxRunning.Load()
+			if now <= highest || maxRunning.CompareAndSwap(highest, now) {
+				break
+			}
+		}
+		runs.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		running.Add(-1)
+		return nil
+	}})
+
+	time.Sleep(220 * time.Millisecond)
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if got := maxRunning.Load(); got != 1 {
+		t.Errorf("%d runs overlapped, want 1 at a time", got)
+	}
+	// The starts missed during a run are skipped rather than run back to back afterwards
+	if got := runs.Load(); got < 2 || got > 5 {
+		t.Errorf("%d runs in 220ms of 50ms runs, want 2 to 5", got)
+	}
+}
+
+func TestStopWhileJobIsRunning(t *testing.T) {
+	started := make(chan struct{})
+	var sawCancel atomic.Bool
+	s := New()
+	startTest(t, s, Job{Name: "blocking", Interval: time.Hour, Immediate: true, Run: func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond) // cleaning up
+		sawCancel.Store(true)
+		return ctx.Err()
+	}})
+	<-started
+
+	// Stop cancels the run and waits for it to return
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if !sawCancel.Load() {
+		t.Error("Stop returned before the run finished")
+	}
+	// Stopping again is harmless
+	if err := s.Stop(context.Background()); err != nil {
+		t.Errorf("second Stop: %v", err)
+	}
+}
+
+func TestStopGivesUpOnStuckJob(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	s := New()
+	startTest(t, s, Job{Name: "stuck", Interval: time.Hour, Immediate: true, Run: func(ctx context.Context) error {
+		close(started)
+		<-release // ignores its context
+		return nil
+	}})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Stop with a stuck job = %v, want DeadlineExceeded", err)
+	}
+	close(release)
+}
+
+func TestPanicIsIsolated(t *testing.T) {
+	recorder := recordSpans(t)
+	var panics, healthy atomic.Int32
+	s := New()
+	startTest(t, s,
+		Job{Name: "panicking", Interval: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+			panics.Add(1)
+			panic("nil map")
+		}},
+		Job{Name: "healthy", Interval: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+			healthy.Add(1)
+			return nil
+		}},
+	)
+
+	time.Sleep(100 * time.Millisecond)
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	// The panicking job keeps its schedule and the other job isn't affected
+	if panics.Load() < 3 || healthy.Load() < 3 {
+		t.Errorf("%d panicking and %d healthy runs, want both to keep running", panics.Load(), healthy.Load())
+	}
+
+	var failed bool
+	for _, span := range recorder.Ended() {
+		if span.Name() != "job panicking" {
+			continue
+		}
+		failed = span.Status().Code == codes.Error && len(span.Events()) > 0
+		for _, attr := range span.Events()[0].Attributes {
+			if attr.Key == "exception.message" && !strings.HasPrefix(attr.Value.AsString(), "panic: nil map") {
+				t.Errorf("recorded error = %q, want the panic", attr.Value.AsString())
+			}
+		}
+		break
+	}
+	if !failed {
+		t.Error("the panic wasn't recorded on the job's span")
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	recorder := recordSpans(t)
+	deadlines := make(chan bool, 1)
+	skipped := make(chan struct{})
+	s := New()
+	startTest(t, s,
+		Job{Name: "bounded", Interval: time.Hour, Immediate: true, Timeout: time.Minute, Run: func(ctx context.Context) error {
+			_, ok := ctx.Deadline()
+			deadlines <- ok
+			return nil
+		}},
+		Job{Name: "skipping", Interval: time.Hour, Immediate: true, Run: func(ctx context.Context) error {
+			defer close(skipped)
+			return ErrSkipped
+		}},
+	)
+	if !<-deadlines {
+		t.Error("run of a job with a timeout has no deadline")
+	}
+	<-skipped
+	s.Stop(context.Background())
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	bounded, ok := spans["job bounded"]
+	if !ok || bounded.Parent().IsValid() || !hasAttribute(bounded, "job.name") {
+		t.Errorf("span of a run = %v, want a root span with job.name", bounded)
+	}
+	// A skipped run isn't a failure
+	if skip, ok := spans["job skipping"]; !ok || skip.Status().Code == codes.Error || !hasAttribute(skip, "job.skipped") {
+		t.Errorf("span of a skipped run = %v, want job.skipped without an error", skip)
+	}
+}
+
+func TestWrappers(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	record := func(name string) Wrapper {
+		return func(job Job) Job {
+			run := job.Run
+			job.Run = func(ctx context.Context) error {
+				mu.Lock()
+				calls = append(calls, name+" "+job.Name)
+				mu.Unlock()
+				return run(ctx)
+			}
+			return job
+		}
+	}
+	done := make(chan struct{})
+	s := New()
+	s.Use(record("outer"), record("inner"))
+	startTest(t, s, Job{Name: "wrapped", Interval: time.Hour, Immediate: true, Run: func(ctx context.Context) error {
+		close(done)
+		return nil
+	}})
+	<-done
+	s.Stop(context.Background())
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != 2 || calls[0] != "outer wrapped" || calls[1] != "inner wrapped" {
+		t.Errorf("wrapper calls = %v, want outer then inner", calls)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	run := func(ctx context.Context) error { return nil }
+	tests := []struct {
+		name string
+		job  Job
+		err  string
+	}{
+		{"interval", Job{Name: "a", Interval: time.Minute, Run: run}, ""},
+		{"cron", Job{Name: "b", Cron: "*/5 * * * *", Run: run}, ""},
+		{"duplicate", Job{Name: "a", Interval: time.Minute, Run: run}, "already registered"},
+		{"no name", Job{Interval: time.Minute, Run: run}, "needs a name"},
+		{"no function", Job{Name: "c", Interval: time.Minute}, "needs a name"},
+		{"both schedules", Job{Name: "c", Interval: time.Minute, Cron: "* * * * *", Run: run}, "both an interval and a cron"},
+		{"invalid cron", Job{Name: "c", Cron: "every minute", Run: run}, "invalid cron expression"},
+		{"no schedule", Job{Name: "c", Run: run}, "positive interval"},
+		{"negative interval", Job{Name: "c", Interval: -time.Second, Run: run}, "positive interval"},
+		{"negative timeout", Job{Name: "c", Interval: time.Minute, Timeout: -time.Second, Run: run}, "cannot be negative"},
+	}
+	s := New()
+	for _, tt := range tests {
+		err := s.Add(tt.job)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: Add = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	// Cron expressions are in UTC
+	after := time.Date(2026, 3, 1, 12, 3, 30, 0, time.UTC)
+	if next := s.entries[1].schedule.Next(after); !next.Equal(time.Date(2026, 3, 1, 12, 5, 0, 0, time.UTC)) {
+		t.Errorf("next cron run after %s = %s, want 12:05", after, next)
+	}
+
+	s.Start()
+	defer s.Stop(context.Background())
+	if err := s.Add(Job{Name: "late", Interval: time.Minute, Run: run}); !errors.Is(err, ErrStarted) {
+		t.Errorf("Add after Start = %v, want ErrStarted", err)
+	}
+}
@@ -0,0 +1,41 @@
+// Package tenant carries the marketplace a request belongs to through its context, so one
+// deployment can serve several white-label marketplaces. The tenant middleware stores it; the
+// repositories scope every query to it and the cache keeps every tenant's keys apart. Code
+// that reads tenant data without a tenant in its context fails rather than reading across
+// tenants.
+package tenant
+
+import (
+	"context"
+	"errors"
+)
+
+// Default is the tenant of every request while multi-tenancy is disabled, and the tenant of
+// the rows stored before it was enabled
+const Default = "default"
+
+// ErrMissing is returned when data is read or written with a context carrying no tenant
+var ErrMissing = errors.New("no tenant in context")
+
+// contextKey is the context key of the tenant ID
+type contextKey struct{}
+
+// With returns a copy of ctx scoped to the tenant
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant ctx is scoped to
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok && id != ""
+}
+
+// Require returns the tenant ctx is scoped to, or ErrMissing
+func Require(ctx context.Context) (string, error) {
+	id, ok := FromContext(ctx)
+	if !ok {
+		return "", ErrMissing
+	}
+	return id, nil
+}
@@ -0,0 +1,55 @@
+// Package timestamp keeps API timestamps in UTC. Time marshals as RFC 3339 with a trailing Z
+// and second precision, which is all the TIMESTAMP columns hold, and accepts any offset on input.
+package timestamp
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// Time is a timestamp written to JSON as e.g. "2024-03-01T12:00:00Z"
+type Time struct {
+	time.Time
+}
+
+// From converts t to UTC
+func From(t time.Time) Time {
+	return Time{t.UTC()}
+}
+
+// FromPtr converts an optional time to UTC, keeping nil
+func FromPtr(t *time.Time) *Time {
+	if t == nil {
+		return nil
+	}
+	converted := From(*t)
+	return &converted
+}
+
+// UTC returns a copy of an optional time in UTC, keeping nil
+func UTC(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	converted := t.UTC()
+	return &converted
+}
+
+// MarshalJSON writes the time as RFC 3339 in UTC
+func (t Time) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + t.UTC().Format(time.RFC3339) + `"`), nil
+}
+
+// UnmarshalJSON reads RFC 3339 with any offset and converts it to UTC
+func (t *Time) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	parsed, err := time.Parse(`"`+time.RFC3339+`"`, string(data))
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %s, must be RFC 3339: %v", data, err)
+	}
+	t.Time = parsed.UTC()
+	return nil
+}
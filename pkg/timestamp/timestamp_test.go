@@ -0,0 +1,72 @@
+package timestamp
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	berlin := time.FixedZone("CET", 3600)
+	tests := []struct {
+		name string
+		in   time.Time
+		want string
+	}{
+		{"UTC", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), `"2024-03-01T12:00:00Z"`},
+		{"offset", time.Date(2024, 3, 1, 13, 0, 0, 0, berlin), `"2024-03-01T12:00:00Z"`},
+		// Across midnight the UTC date is written
+		{"previous day in UTC", time.Date(2024, 3, 1, 0, 30, 0, 0, berlin), `"2024-02-29T23:30:00Z"`},
+		// TIMESTAMP columns hold seconds, so fractions are dropped
+		{"fraction", time.Date(2024, 3, 1, 12, 0, 0, 999999999, time.UTC), `"2024-03-01T12:00:00Z"`},
+	}
+	for _, tt := range tests {
+		// Even a Time built without From marshals in UTC
+		data, err := json.Marshal(Time{tt.in})
+		if err != nil || string(data) != tt.want {
+			t.Errorf("%s: %s, %v; want %s", tt.name, data, err, tt.want)
+		}
+		if got := From(tt.in); got.Location() != time.UTC || !got.Equal(tt.in) {
+			t.Errorf("%s: From = %s, want the same instant in UTC", tt.name, got)
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	want := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, in := range []string{`"2024-03-01T12:00:00Z"`, `"2024-03-01T14:00:00+02:00"`, `"2024-03-01T07:00:00-05:00"`} {
+		var got Time
+		if err := json.Unmarshal([]byte(in), &got); err != nil || !got.Equal(want) || got.Location() != time.UTC {
+			t.Errorf("Unmarshal(%s) = %s, %v; want %s", in, got, err, want)
+		}
+		// Writing it back gives the UTC form whatever offset came in
+		if data, _ := json.Marshal(got); string(data) != `"2024-03-01T12:00:00Z"` {
+			t.Errorf("round trip of %s = %s", in, data)
+		}
+	}
+
+	for _, in := range []string{`"2024-03-01"`, `"2024-03-01 12:00:00"`, `"yesterday"`, `1709294400`} {
+		var got Time
+		if err := json.Unmarshal([]byte(in), &got); err == nil {
+			t.Errorf("Unmarshal(%s) = %s, want an error", in, got)
+		}
+	}
+
+	got := Time{want}
+	if err := json.Unmarshal([]byte(`null`), &got); err != nil || !got.Equal(want) {
+		t.Errorf("Unmarshal(null) = %s, %v; want the time unchanged", got, err)
+	}
+}
+
+func TestPointers(t *testing.T) {
+	if FromPtr(nil) != nil || UTC(nil) != nil {
+		t.Error("nil times aren't kept nil")
+	}
+	in := time.Date(2024, 3, 1, 14, 0, 0, 0, time.FixedZone("EET", 2*3600))
+	if got := UTC(&in); got.Location() != time.UTC || !got.Equal(in) || in.Location() == time.UTC {
+		t.Errorf("UTC = %s, want a UTC copy leaving %s alone", got, in)
+	}
+	if got := FromPtr(&in); got.Location() != time.UTC || !got.Equal(in) {
+		t.Errorf("FromPtr = %s, want the instant in UTC", got)
+	}
+}
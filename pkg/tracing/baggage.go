@@ -0,0 +1,50 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// EndUserIDKey is the baggage member and span attribute holding the caller's end-user ID
+const EndUserIDKey = "enduser.id"
+
+// BaggageSpanProcessor copies selected baggage members onto every span as attributes when it
+// starts, so spans deeper in the request (repository, cache) carry them without extra code
+type BaggageSpanProcessor struct {
+	keys []string
+}
+
+// NewBaggageSpanProcessor creates a processor copying the given baggage members
+func NewBaggageSpanProcessor(keys []string) *BaggageSpanProcessor {
+	return &BaggageSpanProcessor{keys: keys}
+}
+
+func (p *BaggageSpanProcessor) OnStart(parent context.Context, s trace.ReadWriteSpan) {
+	bag := baggage.FromContext(parent)
+	for _, key := range p.keys {
+		if member := bag.Member(key); member.Key() != "" {
+			s.SetAttributes(attribute.String(key, member.Value()))
+		}
+	}
+}
+
+func (p *BaggageSpanProcessor) OnEnd(trace.ReadOnlySpan)         {}
+func (p *BaggageSpanProcessor) Shutdown(context.Context) error   { return nil }
+func (p *BaggageSpanProcessor) ForceFlush(context.Context) error { return nil }
+
+// WithBaggageMember returns ctx with the member added to its baggage, replacing any member
+// with the same key a caller may have sent
+func WithBaggageMember(key, value string, ctx context.Context) (context.Context, error) {
+	member, err := baggage.NewMemberRaw(key, value)
+	if err != nil {
+		return ctx, err
+	}
+	bag, err := baggage.FromContext(ctx).SetMember(member)
+	if err != nil {
+		return ctx, err
+	}
+	return baggage.ContextWithBaggage(ctx, bag), nil
+}
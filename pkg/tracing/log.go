@@ -0,0 +1,45 @@
+package tracing
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attribute names of the span context on log records
+const (
+	LogTraceIDKey = "trace_id"
+	LogSpanIDKey  = "span_id"
+)
+
+// LogHandler adds the W3C hex trace_id and span_id of the span in the context passed to the
+// slog *Context methods to every record, so a log line leads to its trace. Records logged
+// without a valid span, such as at startup or through the log package, get neither field.
+type LogHandler struct {
+	slog.Handler
+}
+
+// NewLogHandler wraps next with the span context enrichment
+func NewLogHandler(next slog.Handler) *LogHandler {
+	return &LogHandler{Handler: next}
+}
+
+func (h *LogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		record = record.Clone()
+		record.AddAttrs(
+			slog.String(LogTraceIDKey, sc.TraceID().String()),
+			slog.String(LogSpanIDKey, sc.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &LogHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+	return &LogHandler{Handler: h.Handler.WithGroup(name)}
+}
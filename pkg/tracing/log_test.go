@@ -0,0 +1,97 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// newTestLogger returns a logger writing JSON records through a LogHandler to the buffer
+func newTestLogger() (*slog.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return slog.New(NewLogHandler(slog.NewJSONHandler(&buf, nil))), &buf
+}
+
+// logRecords decodes the JSON records in buf
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		record := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("decode %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLogHandlerAddsSpanContext(t *testing.T) {
+	provider := sdktrace.NewTracerProvider()
+	defer provider.Shutdown(context.Background())
+	logger, buf := newTestLogger()
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	childCtx, child := provider.Tracer("test").Start(ctx, "query")
+	logger.InfoContext(ctx, "handling request")
+	logger.With("job", "expire_ads").ErrorContext(childCtx, "query failed")
+	child.End()
+	parent.End()
+
+	records := logRecords(t, buf)
+	if len(records) != 2 {
+		t.Fatalf("%d records, want 2", len(records))
+	}
+	for i, span := range []trace.Span{parent, child} {
+		sc := span.SpanContext()
+		if records[i][LogTraceIDKey] != sc.TraceID().String() || records[i][LogSpanIDKey] != sc.SpanID().String() {
+			t.Errorf("record %d = %v, want trace %s and span %s", i, records[i], sc.TraceID(), sc.SpanID())
+		}
+	}
+	// Attributes added with With are kept next to the IDs
+	if records[1]["job"] != "expire_ads" {
+		t.Errorf("record = %v, want the job attribute", records[1])
+	}
+}
+
+func TestLogHandlerWithoutSpan(t *testing.T) {
+	logger, buf := newTestLogger()
+
+	// Startup lines, records logged without a context and spans of a disabled tracer carry no IDs
+	logger.Info("starting")
+	logger.InfoContext(context.Background(), "loaded configuration")
+	ctx, span := noop.NewTracerProvider().Tracer("test").Start(context.Background(), "job")
+	logger.InfoContext(ctx, "job ran")
+	span.End()
+
+	// Nor do lines of the log package, which slog routes through the default handler
+	previous := slog.Default()
+	flags := log.Flags()
+	slog.SetDefault(logger)
+	defer func() {
+		slog.SetDefault(previous)
+		log.SetFlags(flags)
+	}()
+	log.Print("legacy line")
+
+	records := logRecords(t, buf)
+	if len(records) != 4 {
+		t.Fatalf("%d records, want 4", len(records))
+	}
+	for _, record := range records {
+		if _, ok := record[LogTraceIDKey]; ok {
+			t.Errorf("record %v has a trace ID", record)
+		}
+		if _, ok := record[LogSpanIDKey]; ok {
+			t.Errorf("record %v has a span ID", record)
+		}
+	}
+}
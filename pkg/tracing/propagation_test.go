@@ -0,0 +1,112 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// The caller's span in every incoming header format
+const (
+	callerTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	callerSpanID  = "00f067aa0ba902b7"
+)
+
+func TestPropagatorsContinueIncomingTraces(t *testing.T) {
+	propagator, err := newPropagator([]string{"tracecontext", "baggage", "b3"})
+	if err != nil {
+		t.Fatalf("newPropagator: %v", err)
+	}
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{"tracecontext", map[string]string{"traceparent": "00-" + callerTraceID + "-" + callerSpanID + "-01"}},
+		// B3 is extracted in both encodings, whichever one is injected
+		{"b3 single header", map[string]string{"b3": callerTraceID + "-" + callerSpanID + "-1"}},
+		{"b3 multiple headers", map[string]string{"X-B3-TraceId": callerTraceID, "X-B3-SpanId": callerSpanID, "X-B3-Sampled": "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+			r := gin.New()
+			r.Use(otelgin.Middleware("ad-service", otelgin.WithTracerProvider(provider), otelgin.WithPropagators(propagator)))
+			r.GET("/ads/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodGet, "/ads/7", nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := recorder.Ended()
+			if len(spans) != 1 {
+				t.Fatalf("%d spans, want the server span", len(spans))
+			}
+			parent := spans[0].Parent()
+			if parent.TraceID().String() != callerTraceID || parent.SpanID().String() != callerSpanID || !parent.IsRemote() || !parent.IsSampled() {
+				t.Errorf("parent = %s/%s remote %v sampled %v, want the caller's sampled span %s/%s",
+					parent.TraceID(), parent.SpanID(), parent.IsRemote(), parent.IsSampled(), callerTraceID, callerSpanID)
+			}
+			if got := spans[0].SpanContext().TraceID().String(); got != callerTraceID {
+				t.Errorf("server span trace = %s, want the caller's %s", got, callerTraceID)
+			}
+		})
+	}
+}
+
+func TestPropagatorsInjectConfiguredFormats(t *testing.T) {
+	traceID, _ := oteltrace.TraceIDFromHex(callerTraceID)
+	spanID, _ := oteltrace.SpanIDFromHex(callerSpanID)
+	ctx := oteltrace.ContextWithSpanContext(context.Background(), oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+		TraceID: traceID, SpanID: spanID, TraceFlags: oteltrace.FlagsSampled,
+	}))
+	ctx, err := WithBaggageMember(EndUserIDKey, "user-42", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	traceparent, b3 := "00-"+callerTraceID+"-"+callerSpanID+"-01", callerTraceID+"-"+callerSpanID+"-1"
+	tests := []struct {
+		names []string
+		want  map[string]string // every header of the outgoing request
+	}{
+		{[]string{"tracecontext", "baggage"}, map[string]string{"Traceparent": traceparent, "Baggage": EndUserIDKey + "=user-42"}},
+		{[]string{"tracecontext", "b3"}, map[string]string{"Traceparent": traceparent, "B3": b3}},
+		{[]string{"b3multi"}, map[string]string{"X-B3-Traceid": callerTraceID, "X-B3-Spanid": callerSpanID, "X-B3-Sampled": "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.names, ","), func(t *testing.T) {
+			propagator, err := newPropagator(tt.names)
+			if err != nil {
+				t.Fatalf("newPropagator: %v", err)
+			}
+			header := http.Header{}
+			propagator.Inject(ctx, propagation.HeaderCarrier(header))
+			if len(header) != len(tt.want) {
+				t.Errorf("injected %v, want %v", header, tt.want)
+			}
+			for name, value := range tt.want {
+				if got := header.Get(name); got != value {
+					t.Errorf("%s = %q, want %q", name, got, value)
+				}
+			}
+		})
+	}
+
+	if _, err := newPropagator([]string{"jaeger"}); err == nil || !strings.Contains(err.Error(), `unknown propagator "jaeger"`) {
+		t.Errorf("newPropagator(jaeger) = %v, want an unknown propagator error", err)
+	}
+}
@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// routeSampler drops server spans for infrastructure routes and hands every other decision to
+// the wrapped sampler. Routes are matched against the http.route attribute set by otelgin, so
+// /ads/:id is matched as a template rather than per ID. An entry ending in * matches a prefix.
+type routeSampler struct {
+	routes   map[string]bool
+	prefixes []string
+	next     trace.Sampler
+}
+
+// newRouteSampler wraps next, or returns it unchanged when no routes are dropped
+func newRouteSampler(routes []string, next trace.Sampler) trace.Sampler {
+	if len(routes) == 0 {
+		return next
+	}
+	s := &routeSampler{routes: make(map[string]bool, len(routes)), next: next}
+	for _, route := range routes {
+		if prefix, ok := strings.CutSuffix(route, "*"); ok {
+			s.prefixes = append(s.prefixes, prefix)
+		} else {
+			s.routes[route] = true
+		}
+	}
+	return s
+}
+
+func (s *routeSampler) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	if p.Kind == oteltrace.SpanKindServer && s.dropped(route(p)) {
+		return trace.SamplingResult{
+			Decision:   trace.Drop,
+			Tracestate: oteltrace.SpanContextFromContext(p.ParentContext).TraceState(),
+		}
+	}
+	return s.next.ShouldSample(p)
+}
+
+func (s *routeSampler) Description() string {
+	return "RouteSampler{" + s.next.Description() + "}"
+}
+
+// dropped reports whether spans for the route are never sampled
+func (s *routeSampler) dropped(route string) bool {
+	if route == "" {
+		return false
+	}
+	if s.routes[route] {
+		return true
+	}
+	for _, prefix := range s.prefixes {
+		if strings.HasPrefix(route, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// route returns the matched route of a server span, empty for unmatched requests
+func route(p trace.SamplingParameters) string {
+	for _, attr := range p.Attributes {
+		if attr.Key == semconv.HTTPRouteKey {
+			return attr.Value.AsString()
+		}
+	}
+	return ""
+}
+
+// ratioSampler is a TraceIDRatioBased sampler whose ratio can be changed while running
+type ratioSampler struct {
+	current atomic.Value // trace.Sampler
+}
+
+// samplerRatio is the sampler built by newSampler for the ratio setting, nil otherwise
+var samplerRatio atomic.Pointer[ratioSampler]
+
+func newRatioSampler(ratio float64) *ratioSampler {
+	s := &ratioSampler{}
+	s.current.Store(trace.TraceIDRatioBased(ratio))
+	return s
+}
+
+func (s *ratioSampler) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	return s.current.Load().(trace.Sampler).ShouldSample(p)
+}
+
+func (s *ratioSampler) Description() string {
+	return s.current.Load().(trace.Sampler).Description()
+}
+
+// SetSamplerRatio changes the fraction of new traces sampled, on hot reload. It has no effect
+// unless tracing.sampler is ratio.
+func SetSamplerRatio(ratio float64) {
+	if s := samplerRatio.Load(); s != nil {
+		s.current.Store(trace.TraceIDRatioBased(ratio))
+	}
+}
@@ -0,0 +1,132 @@
+package tracing
+
+import (
+	"ad_service/internal/config"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// sampled asks s whether to sample a new trace with the given ID, under parent when it is valid
+func sampled(s trace.Sampler, traceID oteltrace.TraceID, parent oteltrace.SpanContext) bool {
+	ctx := oteltrace.ContextWithRemoteSpanContext(context.Background(), parent)
+	return s.ShouldSample(trace.SamplingParameters{ParentContext: ctx, TraceID: traceID, Name: "GET /ads/:id"}).Decision == trace.RecordAndSample
+}
+
+func TestNewSampler(t *testing.T) {
+	t.Cleanup(func() { samplerRatio.Store(nil) })
+	low, high := oteltrace.TraceID{15: 1}, oteltrace.TraceID{8: 0xff, 9: 0xff, 10: 0xff, 11: 0xff, 12: 0xff, 13: 0xff, 14: 0xff, 15: 0xff}
+	parent := func(flags oteltrace.TraceFlags) oteltrace.SpanContext {
+		return oteltrace.NewSpanContext(oteltrace.SpanContextConfig{TraceID: high, SpanID: oteltrace.SpanID{1}, TraceFlags: flags, Remote: true})
+	}
+
+	tests := []struct {
+		name           string
+		cfg            config.TracingConfig
+		low, high      bool // new traces with a low and a high trace ID are sampled
+		sampledParent  bool // a trace sampled upstream is continued
+		unsampledChild bool // a trace dropped upstream is sampled anyway
+	}{
+		{"always", config.TracingConfig{Sampler: "always"}, true, true, true, false},
+		{"never", config.TracingConfig{Sampler: "never"}, false, false, true, false},
+		// TraceIDRatioBased samples the trace IDs whose lower half is below ratio * 2^63
+		{"ratio", config.TracingConfig{Sampler: "ratio", SamplerRatio: 0.5}, true, false, true, false},
+		{"ratio zero", config.TracingConfig{Sampler: "ratio", SamplerRatio: 0}, false, false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newSampler(tt.cfg)
+			if err != nil {
+				t.Fatalf("newSampler: %v", err)
+			}
+			if !strings.HasPrefix(s.Description(), "ParentBased{") {
+				t.Errorf("sampler = %s, want it to respect the parent's decision", s.Description())
+			}
+			none := oteltrace.SpanContext{}
+			if got := sampled(s, low, none); got != tt.low {
+				t.Errorf("new trace %s sampled = %v, want %v", low, got, tt.low)
+			}
+			if got := sampled(s, high, none); got != tt.high {
+				t.Errorf("new trace %s sampled = %v, want %v", high, got, tt.high)
+			}
+			if got := sampled(s, high, parent(oteltrace.FlagsSampled)); got != tt.sampledParent {
+				t.Errorf("sampled upstream trace sampled = %v, want %v", got, tt.sampledParent)
+			}
+			if got := sampled(s, low, parent(0)); got != tt.unsampledChild {
+				t.Errorf("unsampled upstream trace sampled = %v, want %v", got, tt.unsampledChild)
+			}
+		})
+	}
+}
+
+func TestNewSamplerRejectsInvalidSettings(t *testing.T) {
+	tests := []struct {
+		cfg  config.TracingConfig
+		want string
+	}{
+		{config.TracingConfig{Sampler: "ratio", SamplerRatio: 1.5}, "between 0 and 1"},
+		{config.TracingConfig{Sampler: "ratio", SamplerRatio: -0.1}, "between 0 and 1"},
+		{config.TracingConfig{Sampler: "sometimes"}, `unknown sampler "sometimes"`},
+	}
+	for _, tt := range tests {
+		if _, err := newSampler(tt.cfg); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("newSampler(%+v) = %v, want an error containing %q", tt.cfg, err, tt.want)
+		}
+	}
+}
+
+func TestRouteSamplerDropsInfrastructureRoutes(t *testing.T) {
+	sampler, err := newSampler(config.TracingConfig{Sampler: "always", DropRoutes: []string{"/healthz", "/metrics", "/debug/*"}})
+	if err != nil {
+		t.Fatalf("newSampler: %v", err)
+	}
+	recorder := tracetest.NewSpanRecorder()
+	provider := trace.NewTracerProvider(trace.WithSampler(sampler), trace.WithSpanProcessor(recorder))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(otelgin.Middleware("ad-service", otelgin.WithTracerProvider(provider), otelgin.WithPropagators(propagation.TraceContext{})))
+	for _, route := range []string{"/healthz", "/metrics", "/debug/pprof/:profile", "/ads/:id"} {
+		r.GET(route, func(c *gin.Context) { c.Status(http.StatusOK) })
+	}
+
+	tests := []struct {
+		target   string
+		upstream bool // sent with a sampled traceparent
+		exported bool
+	}{
+		{"/healthz", false, false},
+		{"/metrics", false, false},
+		{"/debug/pprof/heap", false, false},
+		// Dropped even when the caller sampled it
+		{"/healthz", true, false},
+		{"/ads/7", false, true},
+		{"/ads/7", true, true},
+		// The matched route decides, so an unmatched path under a dropped prefix is still sampled
+		{"/healthz/deep", false, true},
+	}
+	for _, tt := range tests {
+		before := len(recorder.Ended())
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.upstream {
+			req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		}
+		r.ServeHTTP(httptest.NewRecorder(), req)
+		spans := recorder.Ended()
+		if tt.exported && tt.upstream && spans[len(spans)-1].Parent().SpanID().String() != "00f067aa0ba902b7" {
+			t.Errorf("GET %s didn't continue the caller's trace", tt.target)
+		}
+		if exported := len(spans) > before; exported != tt.exported {
+			t.Errorf("GET %s (upstream sampled %v) exported a span: %v, want %v", tt.target, tt.upstream, exported, tt.exported)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceID returns the W3C hex trace ID of the span in ctx. It reports false when there is no span
+// or the span is not sampled, since such a trace can't be looked up in the collector.
+func TraceID(ctx context.Context) (string, bool) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() || !sc.IsSampled() {
+		return "", false
+	}
+	return sc.TraceID().String(), true
+}
@@ -2,61 +2,263 @@ package tracing
 
 import (
 	"ad_service/internal/config"
+	"ad_service/pkg/version"
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"log"
+	"net/http"
+	"os"
 	"time"
 
+	"go.opentelemetry.io/contrib/propagators/b3"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"google.golang.org/grpc/credentials"
 )
 
-// InitTracer initializes an OpenTelemetry tracer with a Jaeger exporter.
-func InitTracer(cfg config.TracingConfig) func() {
+// newSampler builds the sampler selected in the configuration. Sampling decisions made by an
+// upstream caller are respected, the configured sampler only applies to new traces. Server spans
+// for the routes in DropRoutes are never sampled, whatever the caller decided.
+func newSampler(cfg config.TracingConfig) (trace.Sampler, error) {
+	var root trace.Sampler
+	switch cfg.Sampler {
+	case "always":
+		root = trace.AlwaysSample()
+	case "never":
+		root = trace.NeverSample()
+	case "ratio":
+		if cfg.SamplerRatio < 0 || cfg.SamplerRatio > 1 {
+			return nil, fmt.Errorf("sampler ratio must be between 0 and 1, got %g", cfg.SamplerRatio)
+		}
+		ratio := newRatioSampler(cfg.SamplerRatio)
+		samplerRatio.Store(ratio)
+		root = ratio
+	default:
+		return nil, fmt.Errorf("unknown sampler %q", cfg.Sampler)
+	}
+	return newRouteSampler(cfg.DropRoutes, trace.ParentBased(root)), nil
+}
 
-	// Set up headers for the HTTP client
-	headers := map[string]string{
-		"content-type": "application/json",
+// newExporter creates the OTLP exporter for the configured transport. Exporters connect lazily,
+// so an unreachable collector is not an error here.
+func newExporter(cfg config.TracingConfig, ctx context.Context) (trace.SpanExporter, error) {
+	tlsConfig, err := NewTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
 	}
+	headers := ExporterHeaders(cfg.Headers)
 
-	// Create OTLP exporter using HTTP and the Jaeger endpoint
-	client := otlptracehttp.NewClient(
-		otlptracehttp.WithEndpoint(cfg.JaegerEndpoint),
-		otlptracehttp.WithHeaders(headers),
-		otlptracehttp.WithInsecure(), // Disable TLS
+	switch cfg.Protocol {
+	case "grpc":
+		opts := []otlptracegrpc.Option{
+			otlptracegrpc.WithEndpoint(cfg.ExporterEndpoint()),
+			otlptracegrpc.WithHeaders(headers),
+		}
+		if tlsConfig != nil {
+			opts = append(opts, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(tlsConfig)))
+		} else {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case "http", "":
+		opts := []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(cfg.ExporterEndpoint()),
+			otlptracehttp.WithHeaders(headers),
+		}
+		if tlsConfig != nil {
+			opts = append(opts, otlptracehttp.WithTLSClientConfig(tlsConfig))
+		} else {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptrace.New(ctx, otlptracehttp.NewClient(opts...))
+	}
+	return nil, fmt.Errorf("unknown exporter protocol %q", cfg.Protocol)
+}
+
+// ExporterHeaders expands ${VAR} references in the configured header values, so credentials
+// such as an Authorization token can come from the environment instead of config.yaml. The
+// OTLP metrics exporter uses it too.
+func ExporterHeaders(configured map[string]string) map[string]string {
+	headers := make(map[string]string, len(configured))
+	for name, value := range configured {
+		headers[name] = os.ExpandEnv(value)
+	}
+	return headers
+}
+
+// NewTLSConfig builds the tls.Config for a collector connection, or nil when TLS is disabled
+func NewTLSConfig(cfg config.TracingTLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		caPEM, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read collector CA bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in collector CA bundle %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// newPropagator combines the configured context propagation formats. B3 extraction accepts both
+// the single and multi header encodings, the name only selects what is injected.
+func newPropagator(names []string) (propagation.TextMapPropagator, error) {
+	propagators := make([]propagation.TextMapPropagator, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case "tracecontext":
+			propagators = append(propagators, propagation.TraceContext{})
+		case "baggage":
+			propagators = append(propagators, propagation.Baggage{})
+		case "b3":
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		case "b3multi":
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		default:
+			return nil, fmt.Errorf("unknown propagator %q", name)
+		}
+	}
+	return propagation.NewCompositeTextMapPropagator(propagators...), nil
+}
+
+// NewResource describes this process: service name and version, deployment environment and
+// instance ID, merged over the SDK defaults (telemetry.sdk.*). Spans and OTLP metrics share it.
+func NewResource(cfg config.TracingConfig) (*resource.Resource, error) {
+	return resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName("ad-service"),
+			semconv.ServiceVersion(version.Version),
+			semconv.DeploymentEnvironment(cfg.Environment),
+			semconv.ServiceInstanceID(instanceID()),
+		),
 	)
+}
+
+// instanceID identifies this replica, the hostname (the pod name on Kubernetes) when available
+// and a random UUID otherwise
+func instanceID() string {
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "unknown"
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// newSpanExporter creates the exporter for the configured mode, or nil for the none mode
+func newSpanExporter(cfg config.TracingConfig) (trace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case "none":
+		return nil, nil
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "otlp":
+		exp, err := newExporter(cfg, context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("could not create %s trace exporter: %v", cfg.Protocol, err)
+		}
+		return exp, nil
+	}
+	return nil, fmt.Errorf("unknown exporter %q", cfg.Exporter)
+}
 
-	// Initialize the trace exporter
-	exp, err := otlptrace.New(context.Background(), client)
+// InitTracer initializes an OpenTelemetry tracer exporting to an OTLP collector over HTTP or gRPC,
+// or pretty-printing spans to stdout for local development. In the none mode nothing is sampled,
+// so spans cost nothing and go nowhere.
+func InitTracer(cfg config.TracingConfig) (func(context.Context) error, error) {
+	exp, err := newSpanExporter(cfg)
 	if err != nil {
-		log.Fatalf("failed to create Jaeger exporter: %v", err)
+		return nil, err
 	}
 
-	// Create and configure a new tracer provider
-	tp := trace.NewTracerProvider(
-		trace.WithBatcher(
-			exp,
-			trace.WithMaxExportBatchSize(trace.DefaultMaxExportBatchSize),
-			trace.WithBatchTimeout(trace.DefaultScheduleDelay*time.Millisecond),
-		),
-		trace.WithResource(
-			resource.NewWithAttributes(
-				semconv.SchemaURL,
-				semconv.ServiceNameKey.String("ad-service"),
+	propagator, err := newPropagator(cfg.Propagators)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := NewResource(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not build trace resource: %v", err)
+	}
+
+	opts := []trace.TracerProviderOption{
+		trace.WithResource(res),
+		trace.WithSpanProcessor(NewBaggageSpanProcessor(cfg.BaggageKeys)),
+	}
+	if exp == nil {
+		log.Println("Trace exporter disabled, spans are not sampled")
+		opts = append(opts, trace.WithSampler(trace.NeverSample()))
+	} else {
+		sampler, err := newSampler(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("could not configure sampler: %v", err)
+		}
+		opts = append(opts,
+			trace.WithSampler(sampler),
+			trace.WithBatcher(
+				exp,
+				trace.WithMaxExportBatchSize(trace.DefaultMaxExportBatchSize),
+				trace.WithBatchTimeout(trace.DefaultScheduleDelay*time.Millisecond),
 			),
-		),
-	)
+		)
+	}
+
+	// Create and configure a new tracer provider
+	tp := trace.NewTracerProvider(opts...)
 
 	// Set the global tracer provider
 	otel.SetTracerProvider(tp)
 
-	// Return a shutdown function for graceful shutdown
-	return func() {
-		if err := tp.Shutdown(context.Background()); err != nil {
-			log.Fatalf("failed to shutdown tracer provider: %v", err)
+	// Continue traces started by upstream callers and inject the same formats into outgoing requests
+	otel.SetTextMapPropagator(propagator)
+
+	// Return a shutdown function for graceful shutdown. Buffered spans are flushed first, and a
+	// collector that doesn't answer can't hold the process past the timeout.
+	return func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, cfg.ShutdownTimeout)
+		defer cancel()
+		if err := tp.ForceFlush(ctx); err != nil {
+			return fmt.Errorf("could not flush spans: %v", err)
 		}
+		return tp.Shutdown(ctx)
+	}, nil
+}
+
+// ExcludePaths returns a request filter that skips tracing for the given paths
+func ExcludePaths(paths []string) func(r *http.Request) bool {
+	excluded := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		excluded[path] = true
+	}
+	return func(r *http.Request) bool {
+		return !excluded[r.URL.Path]
 	}
 }
@@ -0,0 +1,311 @@
+package tracing
+
+import (
+	"ad_service/internal/config"
+	"ad_service/pkg/version"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// collectorRequest is what a test collector saw of an export
+type collectorRequest struct {
+	path          string
+	authorization string
+}
+
+// collector records the export requests it receives
+type collector struct {
+	mu       sync.Mutex
+	requests []collectorRequest
+}
+
+func (c *collector) record(path, authorization string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, collectorRequest{path, authorization})
+}
+
+func (c *collector) received() []collectorRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]collectorRequest(nil), c.requests...)
+}
+
+// startHTTPCollector runs an OTLP/HTTP collector accepting every export
+func startHTTPCollector(t *testing.T) (*collector, string) {
+	t.Helper()
+	c := &collector{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.record(r.URL.Path, r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return c, strings.TrimPrefix(server.URL, "http://")
+}
+
+// startGRPCCollector runs an OTLP/gRPC collector accepting every export
+func startGRPCCollector(t *testing.T) (*collector, string) {
+	t.Helper()
+	c := &collector{}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Any method is answered with an empty response, which decodes as an export without errors
+	server := grpc.NewServer(grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		c.record(method, strings.Join(md.Get("authorization"), ","))
+		if err := stream.RecvMsg(&emptypb.Empty{}); err != nil {
+			return err
+		}
+		return stream.SendMsg(&emptypb.Empty{})
+	}))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return c, listener.Addr().String()
+}
+
+// exportSpan sends one span through the exporter built for cfg
+func exportSpan(t *testing.T, cfg config.TracingConfig) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	exp, err := newExporter(cfg, ctx)
+	if err != nil {
+		t.Fatalf("newExporter: %v", err)
+	}
+	defer exp.Shutdown(ctx)
+	spans := tracetest.SpanStubs{{Name: "GET /ads/:id"}}.Snapshots()
+	if err := exp.ExportSpans(ctx, spans); err != nil {
+		t.Fatalf("ExportSpans: %v", err)
+	}
+}
+
+func TestNewExporterProtocols(t *testing.T) {
+	t.Setenv("TEST_COLLECTOR_TOKEN", "s3cret")
+	headers := map[string]string{"Authorization": "Bearer ${TEST_COLLECTOR_TOKEN}"}
+
+	tests := []struct {
+		name, protocol string
+		start          func(t *testing.T) (*collector, string)
+		path           string
+	}{
+		{"http", "http", startHTTPCollector, "/v1/traces"},
+		// An empty protocol is HTTP, like before it could be chosen
+		{"default", "", startHTTPCollector, "/v1/traces"},
+		{"grpc", "grpc", startGRPCCollector, "/opentelemetry.proto.collector.trace.v1.TraceService/Export"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, endpoint := tt.start(t)
+			exportSpan(t, config.TracingConfig{Protocol: tt.protocol, Endpoint: endpoint, Headers: headers})
+
+			got := c.received()
+			if len(got) != 1 || got[0].path != tt.path {
+				t.Fatalf("collector received %+v, want one export to %s", got, tt.path)
+			}
+			if got[0].authorization != "Bearer s3cret" {
+				t.Errorf("Authorization = %q, want the token from the environment", got[0].authorization)
+			}
+		})
+	}
+}
+
+func TestNewExporterErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.TracingConfig
+		want string
+	}{
+		{"unknown protocol", config.TracingConfig{Protocol: "thrift", Endpoint: "localhost:4317"}, `unknown exporter protocol "thrift"`},
+		{"missing CA bundle", config.TracingConfig{Protocol: "grpc", Endpoint: "localhost:4317", TLS: config.TracingTLSConfig{Enabled: true, CAFile: "missing.pem"}}, "could not read collector CA bundle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newExporter(tt.cfg, context.Background()); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("newExporter = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+	// InitTracer returns the error for main to handle
+	if _, err := InitTracer(config.TracingConfig{Exporter: "otlp", Protocol: "thrift", Endpoint: "localhost:4317"}); err == nil || !strings.Contains(err.Error(), "could not create thrift trace exporter") {
+		t.Errorf("InitTracer = %v, want the exporter error", err)
+	}
+}
+
+func TestExporterHeaders(t *testing.T) {
+	t.Setenv("TEST_COLLECTOR_TOKEN", "s3cret")
+	got := ExporterHeaders(map[string]string{
+		"Authorization": "Bearer ${TEST_COLLECTOR_TOKEN}",
+		"X-Scope-OrgID": "ads",
+		"X-Unset":       "${TEST_UNSET_VARIABLE}",
+	})
+	want := map[string]string{"Authorization": "Bearer s3cret", "X-Scope-OrgID": "ads", "X-Unset": ""}
+	if len(got) != len(want) {
+		t.Fatalf("ExporterHeaders = %v, want %v", got, want)
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("header %s = %q, want %q", name, got[name], value)
+		}
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	if tlsConfig, err := NewTLSConfig(config.TracingTLSConfig{}); tlsConfig != nil || err != nil {
+		t.Errorf("NewTLSConfig when disabled = %v, %v, want nil", tlsConfig, err)
+	}
+	tlsConfig, err := NewTLSConfig(config.TracingTLSConfig{Enabled: true, InsecureSkipVerify: true})
+	if err != nil || tlsConfig == nil || !tlsConfig.InsecureSkipVerify || tlsConfig.RootCAs != nil {
+		t.Errorf("NewTLSConfig = %+v, %v, want the system pool without verification", tlsConfig, err)
+	}
+}
+
+// initTracer runs InitTracer with cfg and restores the global provider when the test ends
+func initTracer(t *testing.T, cfg config.TracingConfig) func(context.Context) error {
+	t.Helper()
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	cfg.Sampler, cfg.ShutdownTimeout = "always", 5*time.Second
+	shutdown, err := InitTracer(cfg)
+	if err != nil {
+		t.Fatalf("InitTracer: %v", err)
+	}
+	return shutdown
+}
+
+// startSpan starts and ends a span with the global provider, returning whether it was sampled
+func startSpan(name string) bool {
+	_, span := otel.Tracer("test").Start(context.Background(), name)
+	defer span.End()
+	return span.SpanContext().IsSampled()
+}
+
+func TestInitTracerExporterModes(t *testing.T) {
+	t.Run("none", func(t *testing.T) {
+		shutdown := initTracer(t, config.TracingConfig{Exporter: "none"})
+		if startSpan("GET /ads/:id") {
+			t.Error("span sampled without an exporter")
+		}
+		if err := shutdown(context.Background()); err != nil {
+			t.Errorf("shutdown: %v", err)
+		}
+	})
+
+	t.Run("stdout", func(t *testing.T) {
+		// The exporter prints to the process stdout, so only its selection is checked
+		exp, err := newSpanExporter(config.TracingConfig{Exporter: "stdout"})
+		if err != nil {
+			t.Fatalf("newSpanExporter: %v", err)
+		}
+		if _, ok := exp.(*stdouttrace.Exporter); !ok {
+			t.Errorf("exporter = %T, want the stdout exporter", exp)
+		}
+	})
+
+	t.Run("otlp", func(t *testing.T) {
+		c, endpoint := startHTTPCollector(t)
+		shutdown := initTracer(t, config.TracingConfig{Exporter: "otlp", Protocol: "http", Endpoint: endpoint})
+		if !startSpan("GET /ads/:id") {
+			t.Error("span not sampled with the otlp exporter")
+		}
+		// Shutting down flushes the batch
+		if err := shutdown(context.Background()); err != nil {
+			t.Errorf("shutdown: %v", err)
+		}
+		if got := c.received(); len(got) != 1 {
+			t.Errorf("collector received %d exports, want 1", len(got))
+		}
+	})
+}
+
+func TestNewResource(t *testing.T) {
+	previous := version.Version
+	version.Version = "v1.4.0"
+	t.Cleanup(func() { version.Version = previous })
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := NewResource(config.TracingConfig{Environment: "staging"})
+	if err != nil {
+		t.Fatalf("NewResource: %v", err)
+	}
+	// Spans of a provider built with it carry the same attributes
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithResource(res), sdktrace.WithSpanProcessor(recorder))
+	_, span := provider.Tracer("test").Start(context.Background(), "GET /ads/:id")
+	span.End()
+
+	got := map[attribute.Key]string{}
+	for _, attr := range recorder.Ended()[0].Resource().Attributes() {
+		got[attr.Key] = attr.Value.Emit()
+	}
+	want := map[attribute.Key]string{
+		"service.name":           "ad-service",
+		"service.version":        "v1.4.0",
+		"deployment.environment": "staging",
+		"service.instance.id":    hostname,
+		// Merged over the SDK defaults
+		"telemetry.sdk.language": "go",
+		"telemetry.sdk.name":     "opentelemetry",
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("resource %s = %q, want %q", key, got[key], value)
+		}
+	}
+}
+
+func TestTracerShutdownTimeout(t *testing.T) {
+	// The collector accepts connections but never answers
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	shutdown, err := InitTracer(config.TracingConfig{
+		Exporter:        "otlp",
+		Protocol:        "http",
+		Endpoint:        strings.TrimPrefix(server.URL, "http://"),
+		Sampler:         "always",
+		ShutdownTimeout: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("InitTracer: %v", err)
+	}
+	startSpan("GET /ads/:id")
+
+	// The caller's context has no deadline, the configured timeout bounds the flush
+	start := time.Now()
+	err = shutdown(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown took %s with a 200ms timeout", elapsed)
+	}
+	if err == nil || !strings.Contains(err.Error(), "could not flush spans") {
+		t.Errorf("shutdown = %v, want the flush error", err)
+	}
+}
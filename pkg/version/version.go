@@ -0,0 +1,21 @@
+// Package version holds build information injected at link time, e.g.
+//
+//	go build -ldflags "-X ad_service/pkg/version.Version=v1.4.0 -X ad_service/pkg/version.Commit=$(git rev-parse --short HEAD)"
+package version
+
+// Version is the release of the service, "dev" for local builds
+var Version = "dev"
+
+// Commit is the git commit the binary was built from
+var Commit = "unknown"
+
+// Info is the build information served by GET /version
+type Info struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+}
+
+// Get returns the build information of the running binary
+func Get() Info {
+	return Info{Version: Version, Commit: Commit}
+}